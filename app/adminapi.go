@@ -0,0 +1,324 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/db"
+)
+
+// botConfig returns the current bot.json commands, which ReloadBotConfig
+// may swap out at any time.
+func (app *App) botConfig() *bot.BotConfig {
+	app.botCfgMu.RLock()
+	defer app.botCfgMu.RUnlock()
+	return app.BotCfg
+}
+
+// ReloadBotConfig reads bot.json again and, if it is valid, uses it for
+// every command from now on. Exec commands that can't run are logged, as at
+// startup. It returns the number of commands loaded.
+func (app *App) ReloadBotConfig() (int, error) {
+	if app.BotConfigPath == "" {
+		return 0, errors.New("bot commands were set in code, not loaded from bot.json")
+	}
+	botCfg, err := bot.LoadBotConfig(app.BotConfigPath)
+	if err != nil {
+		return 0, err
+	}
+	if errs := botCfg.Validate(); len(errs) > 0 {
+		return 0, errors.Join(errs...)
+	}
+	for _, err := range botCfg.CheckExecBinaries() {
+		log.Error().Err(err).Msg("exec command can't run")
+	}
+	app.botCfgMu.Lock()
+	app.BotCfg = botCfg
+	app.botCfgMu.Unlock()
+	log.Info().Str("path", app.BotConfigPath).Int("commands", len(botCfg.Commands)).Msg("reloaded bot config")
+	return len(botCfg.Commands), nil
+}
+
+// AdminHandler serves the HTTP admin API. Every request must carry
+// "Authorization: Bearer <token>"; with an empty token every request is
+// refused.
+//
+//	GET  /api/rooms                   monitored rooms
+//	GET  /api/rooms/{id}/messages     a room's stored messages (?since=&q=&limit=)
+//	GET  /api/rooms/{id}/links        a room's stored links (?since=&limit=)
+//	POST /api/send {"room","body"}    send a message to a monitored room
+//	POST /api/notify/{name} {"room","data"} send a NOTIFICATIONS template
+//	GET  /api/audit?room=&limit=      recent moderation audit entries
+//	GET  /api/ailog?room=&since=&limit= logged AI requests (AI_LOG)
+//	GET  /api/aiusage?user=&since=     AI usage per user and key owner
+//	POST /api/reload                  reload bot.json
+//	POST /api/export                  write the link snapshot now
+func (app *App) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/rooms", app.apiRooms)
+	mux.HandleFunc("GET /api/rooms/{id}/messages", app.apiMessages)
+	mux.HandleFunc("GET /api/rooms/{id}/links", app.apiLinks)
+	mux.HandleFunc("POST /api/send", app.apiSend)
+	mux.HandleFunc("POST /api/notify/{name}", app.apiNotify)
+	mux.HandleFunc("GET /api/audit", app.apiAudit)
+	mux.HandleFunc("GET /api/ailog", app.apiAILog)
+	mux.HandleFunc("GET /api/aiusage", app.apiAIUsage)
+	mux.HandleFunc("POST /api/reload", app.apiReload)
+	mux.HandleFunc("POST /api/export", app.apiExport)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// RunAdminAPI serves AdminHandler on addr until ctx is done.
+func (app *App) RunAdminAPI(ctx context.Context, addr, token string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           app.AdminHandler(token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	log.Info().Str("addr", addr).Msg("admin API listening")
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeAPIJSON(w, status, map[string]string{"error": msg})
+}
+
+type apiRoom struct {
+	ID       string `json:"id"`
+	Comment  string `json:"comment"`
+	Hook     bool   `json:"hook"`
+	SlowMode bool   `json:"slow_mode"`
+}
+
+func (app *App) apiRooms(w http.ResponseWriter, _ *http.Request) {
+	var seen []string
+	if app.Cfg.AllJoinedRooms && app.MessagesDB != nil {
+		var err error
+		if seen, err = db.LinkRoomIDs(app.MessagesDB); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	rooms := []apiRoom{}
+	for _, r := range app.Cfg.Rooms(seen) {
+		rooms = append(rooms, apiRoom{
+			ID:       r.ID,
+			Comment:  r.Comment,
+			Hook:     r.Hook != "",
+			SlowMode: app.SlowMode != nil && app.SlowMode.Interval(id.RoomID(r.ID)) > 0,
+		})
+	}
+	writeAPIJSON(w, http.StatusOK, rooms)
+}
+
+// apiPage parses the since and limit query parameters shared by the archive
+// endpoints. since is a Unix time in milliseconds, an RFC 3339 time or a
+// date; limit defaults to 100.
+func apiPage(r *http.Request) (sinceMS int64, limit int, err error) {
+	limit = 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 1000 {
+			return 0, 0, errors.New("limit must be between 1 and 1000")
+		}
+		limit = n
+	}
+	s := r.URL.Query().Get("since")
+	if s == "" {
+		return 0, limit, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ms, limit, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UnixMilli(), limit, nil
+		}
+	}
+	return 0, 0, errors.New("since must be milliseconds, an RFC 3339 time or YYYY-MM-DD")
+}
+
+// apiArchiveRoom returns the monitored room named in the path, writing a 404
+// if it isn't one.
+func (app *App) apiArchiveRoom(w http.ResponseWriter, r *http.Request) (string, bool) {
+	roomID := r.PathValue("id")
+	if _, ok := app.findRoom(id.RoomID(roomID)); !ok {
+		writeAPIError(w, http.StatusNotFound, "room is not monitored")
+		return "", false
+	}
+	return roomID, true
+}
+
+type apiMessage struct {
+	ID      string `json:"id"`
+	Sender  string `json:"sender"`
+	Body    string `json:"body"`
+	MsgType string `json:"msgtype,omitempty"`
+	TSMs    int64  `json:"ts_ms"`
+}
+
+func (app *App) apiMessages(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := app.apiArchiveRoom(w, r)
+	if !ok {
+		return
+	}
+	since, limit, err := apiPage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	msgs, err := db.SearchMessages(app.MessagesDB, roomID, since, r.URL.Query().Get("q"), limit)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := []apiMessage{}
+	for _, m := range msgs {
+		out = append(out, apiMessage{ID: m.ID, Sender: m.Sender, Body: m.Body, MsgType: m.MsgType, TSMs: m.TSMillis})
+	}
+	writeAPIJSON(w, http.StatusOK, out)
+}
+
+func (app *App) apiLinks(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := app.apiArchiveRoom(w, r)
+	if !ok {
+		return
+	}
+	since, limit, err := apiPage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := db.RoomLinksSince(app.MessagesDB, roomID, since, limit)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if rows == nil {
+		rows = []db.LinkRow{}
+	}
+	writeAPIJSON(w, http.StatusOK, rows)
+}
+
+func (app *App) apiSend(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Room string `json:"room"`
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Room == "" || strings.TrimSpace(req.Body) == "" {
+		writeAPIError(w, http.StatusBadRequest, "room and body are required")
+		return
+	}
+	if _, ok := app.findRoom(id.RoomID(req.Room)); !ok && req.Room != app.Cfg.ModRoomID {
+		writeAPIError(w, http.StatusForbidden, "room is not monitored")
+		return
+	}
+	if app.Cfg.ReadOnly {
+		writeAPIError(w, http.StatusConflict, "read-only mode: sending disabled")
+		return
+	}
+	content := event.MessageEventContent{MsgType: event.MsgText, Body: req.Body}
+	resp, err := app.Client.SendMessageEvent(r.Context(), id.RoomID(req.Room), event.EventMessage, &content)
+	if err != nil {
+		log.Error().Err(err).Str("room", req.Room).Msg("admin API send failed")
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	log.Info().Str("room", req.Room).Str("event_id", string(resp.EventID)).Msg("admin API sent message")
+	writeAPIJSON(w, http.StatusOK, map[string]string{"event_id": string(resp.EventID)})
+}
+
+type apiAuditEntry struct {
+	Room    string `json:"room"`
+	Actor   string `json:"actor"`
+	Action  string `json:"action"`
+	Target  string `json:"target"`
+	Reason  string `json:"reason,omitempty"`
+	EventID string `json:"event_id,omitempty"`
+	Time    string `json:"time"`
+}
+
+func (app *App) apiAudit(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 500 {
+			writeAPIError(w, http.StatusBadRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+	entries, err := db.ModLog(app.MessagesDB, r.URL.Query().Get("room"), limit)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := []apiAuditEntry{}
+	for _, e := range entries {
+		out = append(out, apiAuditEntry{
+			Room:    e.RoomID,
+			Actor:   e.Actor,
+			Action:  e.Action,
+			Target:  e.Target,
+			Reason:  e.Reason,
+			EventID: e.EventID,
+			Time:    time.UnixMilli(e.TSMillis).UTC().Format(time.RFC3339),
+		})
+	}
+	writeAPIJSON(w, http.StatusOK, out)
+}
+
+func (app *App) apiReload(w http.ResponseWriter, _ *http.Request) {
+	n, err := app.ReloadBotConfig()
+	if err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]int{"commands": n})
+}
+
+func (app *App) apiExport(w http.ResponseWriter, _ *http.Request) {
+	if err := app.exportNow(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("export failed: %v", err))
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]bool{"exported": true})
+}
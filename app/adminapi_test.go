@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestAdminAPI(t *testing.T) {
+	var sent []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"event_id":"$sent"}`)
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	if err := db.StoreModAction(messagesDB, db.ModLogEntry{RoomID: "!room:example.com", Actor: "@mod:example.com", Action: "kick", Target: "@spam:example.com", TSMillis: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for i, body := range []string{"hello there", "see https://example.com/x", "100% HELLO"} {
+		if _, err := messagesDB.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, '!room:example.com', '@alice:example.com', ?, ?, 'm.text')`,
+			fmt.Sprintf("$m%d", i), 1000*(i+1), body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := messagesDB.Exec(`INSERT INTO links(message_id, url, idx, title, ts_ms) VALUES ('$m1', 'https://example.com/x', 0, 'X', 2000)`); err != nil {
+		t.Fatal(err)
+	}
+	botCfgPath := filepath.Join(t.TempDir(), "bot.json")
+	if err := os.WriteFile(botCfgPath, []byte(`{"commands":{"hi":{"type":"builtin","command":"hi"}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a := &App{
+		Cfg:           &config.Config{RoomIDs: []config.RoomIDEntry{{ID: "!room:example.com", Comment: "lounge"}}},
+		Client:        client,
+		MessagesDB:    messagesDB,
+		BotConfigPath: botCfgPath,
+	}
+	srv := httptest.NewServer(a.AdminHandler("secret-token-1234"))
+	defer srv.Close()
+
+	do := func(method, path, token, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, string(out)
+	}
+	const token = "secret-token-1234"
+
+	if code, _ := do("GET", "/api/rooms", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("no token: status %d", code)
+	}
+	if code, _ := do("GET", "/api/rooms", "wrong", ""); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", code)
+	}
+	if code, body := do("GET", "/api/rooms", token, ""); code != http.StatusOK || !strings.Contains(body, `"comment":"lounge"`) {
+		t.Errorf("rooms: %d %s", code, body)
+	}
+	if code, body := do("GET", "/api/rooms/!room:example.com/messages?q=hello", token, ""); code != http.StatusOK ||
+		!strings.Contains(body, `"id":"$m0"`) || !strings.Contains(body, `"id":"$m2"`) || strings.Contains(body, `"id":"$m1"`) {
+		t.Errorf("messages q: %d %s", code, body)
+	}
+	if code, body := do("GET", "/api/rooms/!room:example.com/messages?since=3000&q=%25", token, ""); code != http.StatusOK ||
+		!strings.Contains(body, `"body":"100% HELLO"`) || strings.Contains(body, `"id":"$m0"`) {
+		t.Errorf("messages since: %d %s", code, body)
+	}
+	if code, _ := do("GET", "/api/rooms/!room:example.com/messages?since=yesterday", token, ""); code != http.StatusBadRequest {
+		t.Errorf("messages bad since: status %d", code)
+	}
+	if code, _ := do("GET", "/api/rooms/!other:example.com/messages", token, ""); code != http.StatusNotFound {
+		t.Errorf("messages of unmonitored room: status %d", code)
+	}
+	if code, body := do("GET", "/api/rooms/!room:example.com/links?since=1970-01-01", token, ""); code != http.StatusOK ||
+		!strings.Contains(body, `"url":"https://example.com/x"`) || !strings.Contains(body, `"title":"X"`) {
+		t.Errorf("links: %d %s", code, body)
+	}
+	if code, body := do("GET", "/api/audit?room=!room:example.com", token, ""); code != http.StatusOK || !strings.Contains(body, `"action":"kick"`) {
+		t.Errorf("audit: %d %s", code, body)
+	}
+	if code, _ := do("GET", "/api/audit?limit=0", token, ""); code != http.StatusBadRequest {
+		t.Errorf("audit limit 0: status %d", code)
+	}
+	if code, body := do("POST", "/api/reload", token, ""); code != http.StatusOK || body != `{"commands":1}` {
+		t.Errorf("reload: %d %s", code, body)
+	}
+	if a.botConfig() == nil || len(a.botConfig().Commands) != 1 {
+		t.Errorf("bot config not swapped in: %+v", a.botConfig())
+	}
+	if code, _ := do("POST", "/api/send", token, `{"room":"!other:example.com","body":"hi"}`); code != http.StatusForbidden {
+		t.Errorf("send to unmonitored room: status %d", code)
+	}
+	if code, body := do("POST", "/api/send", token, `{"room":"!room:example.com","body":"hello"}`); code != http.StatusOK || body != `{"event_id":"$sent"}` {
+		t.Errorf("send: %d %s", code, body)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "/rooms/!room:example.com/send/m.room.message/") {
+		t.Errorf("homeserver saw %v", sent)
+	}
+}
+
+func TestAdminAPIWithoutToken(t *testing.T) {
+	srv := httptest.NewServer((&App{Cfg: &config.Config{}}).AdminHandler(""))
+	defer srv.Close()
+	for _, auth := range []string{"", "Bearer ", "Bearer x"} {
+		req, _ := http.NewRequest("GET", srv.URL+"/api/rooms", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status %d, want 401", auth, resp.StatusCode)
+		}
+	}
+}
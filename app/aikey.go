@@ -0,0 +1,291 @@
+package app
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/db"
+)
+
+// aiKeyUsageDays is how far back /bot aikey reports usage.
+const aiKeyUsageDays = 30
+
+// aiKeyCipher returns the AES-GCM cipher keys are sealed with, derived from
+// AI_KEYS_SECRET.
+func aiKeyCipher(secret string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealAIKey encrypts a user's API key. The user ID is bound in as associated
+// data, so a sealed key can't be copied to another user's row.
+func sealAIKey(secret, userID, key string) (string, error) {
+	gcm, err := aiKeyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(key), []byte(userID))), nil
+}
+
+// openAIKey decrypts a key sealed by sealAIKey.
+func openAIKey(secret, userID, sealed string) (string, error) {
+	gcm, err := aiKeyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("sealed key is too short")
+	}
+	key, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(userID))
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// aiKeyFor returns the API key a user's AI requests use: their own if they
+// registered one, else the operator's GROQ_API_KEY.
+func (app *App) aiKeyFor(userID id.UserID) (key string, own bool) {
+	secret := app.Cfg.AIKeysSecret
+	if secret == "" || app.MessagesDB == nil {
+		return app.Cfg.GroqAPIKey, false
+	}
+	sealed, err := db.AIKey(app.MessagesDB, string(userID))
+	if err != nil {
+		log.Warn().Err(err).Str("user", string(userID)).Msg("failed to read AI key")
+		return app.Cfg.GroqAPIKey, false
+	}
+	if sealed == "" {
+		return app.Cfg.GroqAPIKey, false
+	}
+	key, err = openAIKey(secret, string(userID), sealed)
+	if err != nil {
+		log.Warn().Err(err).Str("user", string(userID)).Msg("failed to decrypt AI key; was AI_KEYS_SECRET changed?")
+		return app.Cfg.GroqAPIKey, false
+	}
+	return key, true
+}
+
+// aiKeyArgs returns the arguments of a /bot aikey message, and false for any
+// other message.
+func (app *App) aiKeyArgs(body string) ([]string, bool) {
+	parts := strings.Fields(body)
+	botCfg := app.botConfig()
+	if len(parts) < 2 || parts[0] != "/bot" || botCfg == nil {
+		return nil, false
+	}
+	cmdCfg, ok := botCfg.Commands[parts[1]]
+	if !ok || cmdCfg.Type != "builtin" || cmdCfg.Command != "aikey" {
+		return nil, false
+	}
+	return parts[2:], true
+}
+
+// interceptAIKey takes over /bot aikey messages before they are archived,
+// since they may carry an API key. It reports whether ev was one.
+func (app *App) interceptAIKey(ctx context.Context, ev *event.Event) bool {
+	if app.Cfg.ReadOnly || ev.Sender == app.botUserID() {
+		return false
+	}
+	msg := ev.Content.AsMessage()
+	if msg == nil {
+		return false
+	}
+	args, ok := app.aiKeyArgs(msg.Body)
+	if !ok {
+		return false
+	}
+	go func() {
+		select {
+		case <-app.ReadyChan:
+		case <-ctx.Done():
+			return
+		}
+		app.handleAIKey(ctx, ev, args)
+	}()
+	return true
+}
+
+// isDM reports whether a room the bot isn't monitoring is a direct chat
+// between the bot and one user.
+func (app *App) isDM(ctx context.Context, roomID id.RoomID) bool {
+	if _, ok := app.findRoom(roomID); ok {
+		return false
+	}
+	resp, err := app.Client.JoinedMembers(ctx, roomID)
+	return err == nil && len(resp.Joined) == 2
+}
+
+// handleAIKey implements /bot aikey. In a direct chat:
+//
+//	set <key>    register your own API key for AI commands
+//	remove       go back to the bot's key
+//	(nothing)    show which key you use and your usage
+//
+// In a monitored room it opens a direct chat with those instructions, and
+// a key posted there is redacted straight away.
+func (app *App) handleAIKey(ctx context.Context, ev *event.Event, args []string) {
+	label := ResolveReplyLabel(app.Cfg, app.botConfig())
+	reply := func(body string) { SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+body, "aikey") }
+	action := ""
+	if len(args) > 0 {
+		action = strings.ToLower(args[0])
+	}
+
+	if !app.isDM(ctx, ev.RoomID) {
+		if _, ok := app.findRoom(ev.RoomID); !ok {
+			return
+		}
+		switch {
+		case action == "set":
+			if _, err := app.Client.RedactEvent(ctx, ev.RoomID, ev.ID, mautrix.ReqRedact{Reason: "API key posted in a room"}); err != nil {
+				log.Error().Err(err).Str("event_id", string(ev.ID)).Msg("failed to redact posted AI key")
+			}
+			reply("don't post API keys in rooms. I removed your message, but revoke that key and register a new one in a DM")
+		case app.Cfg.AIKeysSecret == "":
+			reply("own AI keys aren't enabled (AI_KEYS_SECRET in config.json)")
+			return
+		default:
+			reply("I've sent you a DM")
+		}
+		if app.Cfg.AIKeysSecret == "" {
+			return
+		}
+		if err := app.sendDM(ctx, ev.Sender, label+app.aiKeyStatus(ev.Sender)+
+			"\nreply here with /bot aikey set <key> to use your own Groq API key for AI commands, or /bot aikey remove to stop"); err != nil {
+			log.Error().Err(err).Str("user", string(ev.Sender)).Msg("failed to open AI key DM")
+		}
+		return
+	}
+
+	secret := app.Cfg.AIKeysSecret
+	if secret == "" {
+		reply("own AI keys aren't enabled (AI_KEYS_SECRET in config.json)")
+		return
+	}
+	user := string(ev.Sender)
+	switch action {
+	case "set":
+		if len(args) != 2 || len(args[1]) < 20 {
+			reply("usage: /bot aikey set <key>")
+			return
+		}
+		sealed, err := sealAIKey(secret, user, args[1])
+		if err == nil {
+			err = db.SetAIKey(app.MessagesDB, user, sealed, time.Now().UnixMilli())
+		}
+		if err != nil {
+			log.Error().Err(err).Str("user", user).Msg("failed to store AI key")
+			reply("couldn't save your key, try again later")
+			return
+		}
+		// Keep the key out of the chat history too.
+		if _, err := app.Client.RedactEvent(ctx, ev.RoomID, ev.ID, mautrix.ReqRedact{Reason: "API key stored"}); err != nil {
+			log.Debug().Err(err).Msg("failed to redact AI key message")
+		}
+		log.Info().Str("user", user).Msg("AI key registered")
+		reply("saved. your AI commands now use your own key")
+	case "remove":
+		removed, err := db.DeleteAIKey(app.MessagesDB, user)
+		switch {
+		case err != nil:
+			log.Error().Err(err).Str("user", user).Msg("failed to remove AI key")
+			reply("couldn't remove your key, try again later")
+		case !removed:
+			reply("you haven't registered a key")
+		default:
+			log.Info().Str("user", user).Msg("AI key removed")
+			reply("removed. your AI commands use the bot's key again")
+		}
+	case "", "status":
+		reply(app.aiKeyStatus(ev.Sender))
+	default:
+		reply("usage: /bot aikey [set <key>|remove]")
+	}
+}
+
+// aiKeyStatus says which key a user's AI commands use and how much they
+// used each lately.
+func (app *App) aiKeyStatus(userID id.UserID) string {
+	status := "your AI commands use the bot's key"
+	if _, own := app.aiKeyFor(userID); own {
+		status = "your AI commands use your own key"
+	}
+	since := time.Now().In(bot.YapTimezone).AddDate(0, 0, -aiKeyUsageDays).Format("2006-01-02")
+	usage, err := db.AIUsageSince(app.MessagesDB, string(userID), since)
+	if err != nil {
+		log.Warn().Err(err).Str("user", string(userID)).Msg("failed to read AI usage")
+		return status
+	}
+	return status + "\n" + FormatAIUsage(usage, aiKeyUsageDays)
+}
+
+// FormatAIUsage summarizes one user's AI usage rows by key owner.
+func FormatAIUsage(usage []db.AIUsage, days int) string {
+	if len(usage) == 0 {
+		return fmt.Sprintf("no AI requests in the last %d days", days)
+	}
+	var parts []string
+	for _, u := range usage {
+		owner := "the bot's key"
+		if u.OwnKey {
+			owner = "your key"
+		}
+		parts = append(parts, fmt.Sprintf("%d requests (%d tokens) on %s", u.Requests, u.Tokens, owner))
+	}
+	return fmt.Sprintf("last %d days: %s", days, strings.Join(parts, ", "))
+}
+
+type apiAIUsage struct {
+	User     string `json:"user"`
+	OwnKey   bool   `json:"own_key"`
+	Requests int    `json:"requests"`
+	Tokens   int    `json:"tokens"`
+}
+
+func (app *App) apiAIUsage(w http.ResponseWriter, r *http.Request) {
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		since = time.Now().In(bot.YapTimezone).AddDate(0, 0, -aiKeyUsageDays).Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", since); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "since must be YYYY-MM-DD")
+		return
+	}
+	usage, err := db.AIUsageSince(app.MessagesDB, r.URL.Query().Get("user"), since)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := []apiAIUsage{}
+	for _, u := range usage {
+		out = append(out, apiAIUsage{User: u.UserID, OwnKey: u.OwnKey, Requests: u.Requests, Tokens: u.Tokens})
+	}
+	writeAPIJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestSealAIKey(t *testing.T) {
+	const secret = "0123456789abcdef"
+	sealed, err := sealAIKey(secret, "@alice:example.com", "gsk_alice_0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "gsk_alice") {
+		t.Fatal("key stored in the clear")
+	}
+	if key, err := openAIKey(secret, "@alice:example.com", sealed); err != nil || key != "gsk_alice_0123456789" {
+		t.Errorf("openAIKey = %q, %v", key, err)
+	}
+	if _, err := openAIKey(secret, "@mallory:example.com", sealed); err == nil {
+		t.Error("sealed key opened for another user")
+	}
+	if _, err := openAIKey("another secret 0123", "@alice:example.com", sealed); err == nil {
+		t.Error("sealed key opened with another secret")
+	}
+}
+
+func TestAIKeyFor(t *testing.T) {
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	a := &App{Cfg: &config.Config{GroqAPIKey: "operator", AIKeysSecret: "0123456789abcdef"}, MessagesDB: messagesDB}
+	sealed, err := sealAIKey(a.Cfg.AIKeysSecret, "@alice:example.com", "gsk_alice_0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetAIKey(messagesDB, "@alice:example.com", sealed, 0); err != nil {
+		t.Fatal(err)
+	}
+	if key, own := a.aiKeyFor("@alice:example.com"); key != "gsk_alice_0123456789" || !own {
+		t.Errorf("alice uses %q, own %v", key, own)
+	}
+	if key, own := a.aiKeyFor("@bob:example.com"); key != "operator" || own {
+		t.Errorf("bob uses %q, own %v", key, own)
+	}
+
+	// Usage is attributed to whoever's key paid for it.
+	a.LogAIExchange(context.Background(), bot.AIExchange{UserID: "@alice:example.com", OwnKey: true, Tokens: 120})
+	a.LogAIExchange(context.Background(), bot.AIExchange{UserID: "@alice:example.com", OwnKey: true, Tokens: 30})
+	a.LogAIExchange(context.Background(), bot.AIExchange{UserID: "@alice:example.com", Tokens: 10})
+	usage, err := db.AIUsageSince(messagesDB, "@alice:example.com", time.Now().AddDate(0, 0, -1).Format("2006-01-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "last 30 days: 2 requests (150 tokens) on your key, 1 requests (10 tokens) on the bot's key"
+	if got := FormatAIUsage(usage, 30); got != want {
+		t.Errorf("FormatAIUsage = %q, want %q", got, want)
+	}
+}
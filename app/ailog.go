@@ -0,0 +1,140 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+// aiLogRetentionDays is how many days AI requests are kept.
+func aiLogRetentionDays(c *config.AILogConfig) int {
+	if c.RetentionDays > 0 {
+		return c.RetentionDays
+	}
+	return 30
+}
+
+// LogAIExchange counts an AI request in the user's usage and, with AI_LOG,
+// stores it in the ai_log table, honouring the user's consent. It is
+// installed as bot.AILog.
+func (app *App) LogAIExchange(_ context.Context, x bot.AIExchange) {
+	day := time.Now().In(bot.YapTimezone).Format("2006-01-02")
+	if err := db.AddAIUsage(app.MessagesDB, day, x.UserID, x.OwnKey, x.Tokens); err != nil {
+		log.Warn().Err(err).Str("user", x.UserID).Msg("failed to count AI usage")
+	}
+	c := app.Cfg.AILog
+	if c == nil {
+		return
+	}
+	consent, set, err := db.AILogConsent(app.MessagesDB, x.UserID)
+	if err != nil {
+		log.Warn().Err(err).Str("user", x.UserID).Msg("failed to read AI log consent")
+		return
+	}
+	if !consent && (set || c.OptIn) {
+		return
+	}
+	entry := db.AILogEntry{
+		RoomID:   x.RoomID,
+		UserID:   x.UserID,
+		Command:  x.Command,
+		Model:    x.Model,
+		Prompt:   x.Prompt,
+		Response: x.Response,
+		Error:    x.Error,
+		TSMillis: time.Now().UnixMilli(),
+	}
+	if err := db.StoreAILogEntry(app.MessagesDB, entry, (time.Duration(aiLogRetentionDays(c)) * 24 * time.Hour).Milliseconds()); err != nil {
+		log.Warn().Err(err).Str("command", x.Command).Msg("failed to log AI request")
+	}
+}
+
+// handleAILog implements the ailog builtin: "on" or "off" records whether
+// the sender's AI requests may be logged for review, and no argument shows
+// the current setting.
+func (app *App) handleAILog(ctx context.Context, ev *event.Event, args, label string) {
+	reply := func(body string) { SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+body, "ailog") }
+	c := app.Cfg.AILog
+	if c == nil {
+		reply("AI requests aren't logged here")
+		return
+	}
+	user := string(ev.Sender)
+	switch arg := strings.ToLower(strings.TrimSpace(args)); arg {
+	case "on", "off":
+		consent := arg == "on"
+		if err := db.SetAILogConsent(app.MessagesDB, user, consent, time.Now().UnixMilli()); err != nil {
+			log.Error().Err(err).Str("user", user).Msg("failed to store AI log consent")
+			reply("couldn't save that, try again later")
+			return
+		}
+		if consent {
+			reply("your AI requests will be logged for review")
+		} else {
+			reply("your AI requests won't be logged")
+		}
+	case "":
+		consent, set, err := db.AILogConsent(app.MessagesDB, user)
+		if err != nil {
+			log.Error().Err(err).Str("user", user).Msg("failed to read AI log consent")
+			reply("couldn't read your setting")
+			return
+		}
+		if consent || (!set && !c.OptIn) {
+			reply(fmt.Sprintf("your AI requests are logged for review and kept for %d days. use /bot ailog off to stop", aiLogRetentionDays(c)))
+		} else {
+			reply("your AI requests aren't logged. use /bot ailog on to allow it")
+		}
+	default:
+		reply("usage: /bot ailog [on|off]")
+	}
+}
+
+type apiAIEntry struct {
+	ID       int64  `json:"id"`
+	Room     string `json:"room"`
+	User     string `json:"user"`
+	Command  string `json:"command"`
+	Model    string `json:"model"`
+	Prompt   string `json:"prompt"`
+	Response string `json:"response"`
+	Error    string `json:"error,omitempty"`
+	Time     string `json:"time"`
+}
+
+func (app *App) apiAILog(w http.ResponseWriter, r *http.Request) {
+	since, limit, err := apiPage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	entries, err := db.AILogEntries(app.MessagesDB, r.URL.Query().Get("room"), since, limit)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := []apiAIEntry{}
+	for _, e := range entries {
+		out = append(out, apiAIEntry{
+			ID:       e.ID,
+			Room:     e.RoomID,
+			User:     e.UserID,
+			Command:  e.Command,
+			Model:    e.Model,
+			Prompt:   e.Prompt,
+			Response: e.Response,
+			Error:    e.Error,
+			Time:     time.UnixMilli(e.TSMillis).UTC().Format(time.RFC3339),
+		})
+	}
+	writeAPIJSON(w, http.StatusOK, out)
+}
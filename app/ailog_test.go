@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestLogAIExchange(t *testing.T) {
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	a := &App{Cfg: &config.Config{AILog: &config.AILogConfig{}}, MessagesDB: messagesDB}
+	now := time.Now().UnixMilli()
+	if err := db.SetAILogConsent(messagesDB, "@carol:example.com", false, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetAILogConsent(messagesDB, "@dave:example.com", true, now); err != nil {
+		t.Fatal(err)
+	}
+	logAs := func(user string) {
+		a.LogAIExchange(context.Background(), bot.AIExchange{RoomID: "!room:example.com", UserID: user, Command: "ask", Prompt: "hi", Response: "hello"})
+	}
+
+	// By default everyone is logged unless they opted out.
+	logAs("@alice:example.com")
+	logAs("@carol:example.com")
+	// With OptIn only users who agreed are.
+	a.Cfg.AILog.OptIn = true
+	logAs("@bob:example.com")
+	logAs("@dave:example.com")
+
+	entries, err := db.AILogEntries(messagesDB, "", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var users []string
+	for _, e := range entries {
+		users = append(users, e.UserID)
+	}
+	if len(users) != 2 || users[0] != "@alice:example.com" || users[1] != "@dave:example.com" {
+		t.Fatalf("logged %v, want alice and dave", users)
+	}
+
+	srv := httptest.NewServer(a.AdminHandler("0123456789abcdef"))
+	defer srv.Close()
+	req, _ := http.NewRequest("GET", srv.URL+"/api/ailog?room=!room:example.com&limit=1", nil)
+	req.Header.Set("Authorization", "Bearer 0123456789abcdef")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out []apiAIEntry
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(out) != 1 || out[0].User != "@alice:example.com" || out[0].Response != "hello" {
+		t.Errorf("export = %d %+v", resp.StatusCode, out)
+	}
+}
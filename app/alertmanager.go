@@ -0,0 +1,217 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+)
+
+// alertmanagerMaxAlerts is how many alerts of a group a notice lists.
+const alertmanagerMaxAlerts = 10
+
+// alertmanagerMaxBytes caps Alertmanager webhook payloads.
+const alertmanagerMaxBytes = 1 << 20
+
+// Colors of firing and resolved alerts in notices.
+const (
+	alertFiringColor   = "#d32f2f"
+	alertResolvedColor = "#2e7d32"
+)
+
+type amAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	GeneratorURL string            `json:"generatorURL"`
+}
+
+// amPayload is Alertmanager's webhook body (version 4).
+type amPayload struct {
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Alerts            []amAlert         `json:"alerts"`
+}
+
+// sortedLabels renders labels as k=v pairs sorted by name, leaving out skip.
+func sortedLabels(labels map[string]string, skip map[string]string) []string {
+	var out []string
+	for k, v := range labels {
+		if _, ok := skip[k]; !ok {
+			out = append(out, k+"="+v)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// silenceURL links to Alertmanager's new silence form prefilled with
+// matchers for labels.
+func silenceURL(externalURL string, labels map[string]string) string {
+	if externalURL == "" || len(labels) == 0 {
+		return ""
+	}
+	var matchers []string
+	for k, v := range labels {
+		matchers = append(matchers, k+"="+strconv.Quote(v))
+	}
+	slices.Sort(matchers)
+	filter := "{" + strings.Join(matchers, ",") + "}"
+	return strings.TrimSuffix(externalURL, "/") + "/#/silences/new?filter=" + url.QueryEscape(filter)
+}
+
+// FormatAlertmanager renders an Alertmanager webhook as a notice in plain
+// text and HTML: a red or green header with the group's name and labels,
+// one line per alert (up to alertmanagerMaxAlerts) with its summary, other
+// labels and links to its source and a silence, and a link to silence the
+// whole group.
+func FormatAlertmanager(payload []byte) (body, htmlBody string, err error) {
+	var p amPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", "", fmt.Errorf("invalid payload: %w", err)
+	}
+	if len(p.Alerts) == 0 {
+		return "", "", fmt.Errorf("no alerts")
+	}
+	esc := html.EscapeString
+	firing := 0
+	for _, a := range p.Alerts {
+		if a.Status == "firing" {
+			firing++
+		}
+	}
+	status, color := "RESOLVED", alertResolvedColor
+	if p.Status == "firing" {
+		status, color = fmt.Sprintf("FIRING:%d", firing), alertFiringColor
+	}
+	name := p.GroupLabels["alertname"]
+	if name == "" {
+		name = p.CommonLabels["alertname"]
+	}
+	if name == "" {
+		name = p.Receiver
+	}
+	groupLabels := sortedLabels(p.GroupLabels, map[string]string{"alertname": ""})
+
+	var plain, rich strings.Builder
+	fmt.Fprintf(&plain, "[%s] %s", status, name)
+	fmt.Fprintf(&rich, `<font color="%s"><b>[%s]</b></font> <b>%s</b>`, color, status, esc(name))
+	if len(groupLabels) > 0 {
+		fmt.Fprintf(&plain, " (%s)", strings.Join(groupLabels, ", "))
+		fmt.Fprintf(&rich, " <code>%s</code>", esc(strings.Join(groupLabels, ", ")))
+	}
+	if s := p.CommonAnnotations["summary"]; s != "" && len(p.Alerts) > 1 {
+		fmt.Fprintf(&plain, "\n%s", s)
+		fmt.Fprintf(&rich, "<br>%s", esc(s))
+	}
+
+	rich.WriteString("<ul>")
+	for i, a := range p.Alerts {
+		if i == alertmanagerMaxAlerts {
+			break
+		}
+		summary := a.Annotations["summary"]
+		if summary == "" {
+			summary = a.Labels["alertname"]
+		}
+		dot := alertFiringColor
+		if a.Status != "firing" {
+			dot = alertResolvedColor
+		}
+		labels := sortedLabels(a.Labels, p.CommonLabels)
+		labels = slices.DeleteFunc(labels, func(l string) bool { return l == "alertname="+name })
+		fmt.Fprintf(&plain, "\n- %s", summary)
+		fmt.Fprintf(&rich, `<li><font color="%s">●</font> %s`, dot, esc(summary))
+		if d := a.Annotations["description"]; d != "" {
+			fmt.Fprintf(&plain, ": %s", d)
+			fmt.Fprintf(&rich, ": %s", esc(d))
+		}
+		if len(labels) > 0 {
+			fmt.Fprintf(&plain, " (%s)", strings.Join(labels, ", "))
+			fmt.Fprintf(&rich, " <code>%s</code>", esc(strings.Join(labels, ", ")))
+		}
+		var links []string
+		if a.GeneratorURL != "" {
+			links = append(links, fmt.Sprintf(`<a href="%s">source</a>`, esc(a.GeneratorURL)))
+		}
+		if u := silenceURL(p.ExternalURL, a.Labels); u != "" && a.Status == "firing" {
+			links = append(links, fmt.Sprintf(`<a href="%s">silence</a>`, esc(u)))
+		}
+		if len(links) > 0 {
+			rich.WriteString(" · " + strings.Join(links, " · "))
+		}
+		rich.WriteString("</li>")
+	}
+	rich.WriteString("</ul>")
+
+	more := p.TruncatedAlerts
+	if len(p.Alerts) > alertmanagerMaxAlerts {
+		more += len(p.Alerts) - alertmanagerMaxAlerts
+	}
+	if more > 0 {
+		fmt.Fprintf(&plain, "\n… and %d more", more)
+		fmt.Fprintf(&rich, "… and %d more<br>", more)
+	}
+	if u := silenceURL(p.ExternalURL, p.GroupLabels); u != "" && p.Status == "firing" {
+		fmt.Fprintf(&plain, "\nsilence: %s", u)
+		fmt.Fprintf(&rich, `<a href="%s">silence this group</a>`, esc(u))
+	}
+	return plain.String(), strings.TrimSuffix(rich.String(), "<br>"), nil
+}
+
+// handleAlertmanagerHook serves POST /alertmanager.
+func (app *App) handleAlertmanagerHook(cfg *config.AlertmanagerHookConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(cfg.Token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+		if app.Cfg.ReadOnly {
+			writeAPIError(w, http.StatusForbidden, "bot is read-only")
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, alertmanagerMaxBytes))
+		if err != nil {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		plain, rich, err := FormatAlertmanager(body)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		room := id.RoomID(cfg.Room)
+		content := event.MessageEventContent{
+			MsgType:       event.MsgNotice,
+			Body:          plain,
+			Format:        event.FormatHTML,
+			FormattedBody: rich,
+		}
+		resp, err := app.Client.SendMessageEvent(r.Context(), room, event.EventMessage, &content)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to post alerts")
+			writeAPIError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		log.Info().Str("room", string(room)).Int("bytes", len(body)).Msg("posted alerts")
+		writeAPIJSON(w, http.StatusOK, map[string]string{"event_id": string(resp.EventID)})
+	}
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+
+	"github.com/polarhive/ash/config"
+)
+
+const alertmanagerFiring = `{"version":"4","status":"firing","receiver":"ops",
+	"groupLabels":{"alertname":"HighLatency","job":"api"},
+	"commonLabels":{"alertname":"HighLatency","job":"api","severity":"page"},
+	"commonAnnotations":{"summary":"API is slow"},
+	"externalURL":"http://am:9093/","truncatedAlerts":2,"alerts":[
+	{"status":"firing","labels":{"alertname":"HighLatency","job":"api","severity":"page","instance":"a:80"},
+	 "annotations":{"summary":"p99 over 1s","description":"p99 is <2.3s>"},"generatorURL":"http://prom/graph?g0.expr=x"},
+	{"status":"resolved","labels":{"alertname":"HighLatency","job":"api","severity":"page","instance":"b:80"},"annotations":{}}]}`
+
+func TestFormatAlertmanager(t *testing.T) {
+	plain, html, err := FormatAlertmanager([]byte(alertmanagerFiring))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupSilence := "http://am:9093/#/silences/new?filter=%7Balertname%3D%22HighLatency%22%2Cjob%3D%22api%22%7D"
+	wantPlain := "[FIRING:1] HighLatency (job=api)\nAPI is slow\n- p99 over 1s: p99 is <2.3s> (instance=a:80)\n- HighLatency (instance=b:80)\n… and 2 more\nsilence: " + groupSilence
+	if plain != wantPlain {
+		t.Errorf("plain:\n%s\nwant:\n%s", plain, wantPlain)
+	}
+	for _, want := range []string{
+		`<font color="#d32f2f"><b>[FIRING:1]</b></font> <b>HighLatency</b> <code>job=api</code>`,
+		`p99 is &lt;2.3s&gt;`,
+		`<a href="http://prom/graph?g0.expr=x">source</a>`,
+		`%2Cinstance%3D%22a%3A80%22%2C`,
+		`<li><font color="#2e7d32">●</font> HighLatency <code>instance=b:80</code></li>`,
+		`<a href="` + groupSilence + `">silence this group</a>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("html missing %q:\n%s", want, html)
+		}
+	}
+
+	plain, html, err = FormatAlertmanager([]byte(`{"status":"resolved","groupLabels":{"alertname":"Down"},"externalURL":"http://am:9093",
+		"alerts":[{"status":"resolved","labels":{"alertname":"Down"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != "[RESOLVED] Down\n- Down" || strings.Contains(html, "silence") || !strings.Contains(html, "#2e7d32") {
+		t.Errorf("resolved: %q %q", plain, html)
+	}
+	if _, _, err := FormatAlertmanager([]byte(`{"status":"firing","alerts":[]}`)); err == nil {
+		t.Error("expected an error without alerts")
+	}
+}
+
+func TestAlertmanagerHook(t *testing.T) {
+	var sent []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"event_id":"$alert"}`)
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{Cfg: &config.Config{}, Client: client}
+	handler, err := a.InboundHookHandler(&config.InboundHooksConfig{
+		Alertmanager: &config.AlertmanagerHookConfig{Token: "am-token-0123456789", Room: "!ops:example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	post := func(token string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+"/alertmanager", strings.NewReader(alertmanagerFiring))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", code)
+	}
+	if code := post("am-token-0123456789"); code != http.StatusOK {
+		t.Errorf("post: status %d", code)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "/rooms/!ops:example.com/send/m.room.message/") {
+		t.Errorf("homeserver saw %v", sent)
+	}
+}
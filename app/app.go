@@ -7,7 +7,9 @@ import (
 	grand "math/rand"
 	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog/log"
 	"maunium.net/go/mautrix"
@@ -18,6 +20,8 @@ import (
 	"github.com/polarhive/ash/config"
 	"github.com/polarhive/ash/db"
 	"github.com/polarhive/ash/links"
+	"github.com/polarhive/ash/locale"
+	"github.com/polarhive/ash/matrix"
 	"github.com/polarhive/ash/util"
 )
 
@@ -29,6 +33,34 @@ type App struct {
 	Client     *mautrix.Client
 	ReadyChan  <-chan bool
 	KnockKnock *bot.KnockKnockState
+	Moderation *bot.ModerationState
+	Pages      *bot.PagedReplies
+	Flood      *FloodTracker
+	Welcome    *WelcomeLimiter
+	Ignored    *IgnoreList
+	Router     *Router
+	SlowMode   *SlowMode
+	Exporter   *Exporter
+	Hooks      *links.Dispatcher
+
+	// DataDeletions holds /bot mydata delete requests awaiting
+	// confirmation; nil disables deletion.
+	DataDeletions *DataDeletions
+
+	// Links is the Exporter's link snapshot, invalidated when links are
+	// deleted; may be nil.
+	Links *db.LinkSnapshot
+
+	// MetaDB holds backfill checkpoints; nil disables them.
+	MetaDB *sql.DB
+
+	// BotConfigPath is where ReloadBotConfig reads bot.json from; empty
+	// when the commands were set in code.
+	BotConfigPath string
+
+	botCfgMu sync.RWMutex
+
+	dmMu sync.Mutex // serializes dmRoom, so a user gets one direct chat
 }
 
 // ResolveReplyLabel returns the reply label with precedence:
@@ -43,39 +75,90 @@ func ResolveReplyLabel(cfg *config.Config, botCfg *bot.BotConfig) string {
 	return "> "
 }
 
-// SendBotReply sends a text reply to the given event.
+// SendBotReply sends a text reply to the given event, with an escaped HTML
+// body alongside the plain one.
 func SendBotReply(ctx context.Context, client *mautrix.Client, roomID id.RoomID, eventID id.EventID, body, cmd string) {
-	content := event.MessageEventContent{
-		MsgType:   event.MsgText,
-		Body:      body,
-		RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: eventID}},
-	}
-	if _, err := client.SendMessageEvent(ctx, roomID, event.EventMessage, &content); err != nil {
+	sendFormattedBotReply(ctx, client, roomID, eventID, body, "", cmd)
+}
+
+// sendFormattedBotReply is SendBotReply with formatted as the HTML body, if
+// set.
+func sendFormattedBotReply(ctx context.Context, client *mautrix.Client, roomID id.RoomID, eventID id.EventID, body, formatted, cmd string) {
+	if _, err := matrix.SendReply(ctx, client, roomID, eventID, body, formatted); err != nil {
 		log.Error().Err(err).Str("cmd", cmd).Msg("failed to send response")
 	} else {
 		log.Info().Str("cmd", cmd).Msg("sent bot response")
 	}
 }
 
-// GenerateHelpMessage creates a help message listing available commands.
-func GenerateHelpMessage(botCfg *bot.BotConfig, allowedCommands []string) string {
+// reactionMaxRunes is the longest output used as a reaction; longer output
+// is posted as a reply.
+const reactionMaxRunes = 16
+
+// ResultReaction picks the reaction for an output_type reaction command: ❌
+// if it failed, else success if set, else the trimmed output (✅ if empty).
+// It returns false if the output is too long for a reaction.
+func ResultReaction(output, success string, err error) (string, bool) {
+	if err != nil {
+		return "❌", true
+	}
+	if success != "" {
+		return success, true
+	}
+	output = strings.TrimSpace(output)
+	switch {
+	case output == "":
+		return "✅", true
+	case utf8.RuneCountInString(output) > reactionMaxRunes || strings.ContainsRune(output, '\n'):
+		return "", false
+	}
+	return output, true
+}
+
+// GenerateHelpMessage creates a help message listing available commands, in
+// English.
+func GenerateHelpMessage(botCfg *bot.BotConfig, allowedCommands []string, extra ...string) string {
+	return locale.Get("", "help", strings.Join(HelpCommands(botCfg, allowedCommands, extra...), ", "))
+}
+
+// HelpCommands returns the sorted commands help lists. Extra names, such as
+// Go-native Router commands, are listed alongside bot.json commands when
+// the room doesn't restrict commands.
+func HelpCommands(botCfg *bot.BotConfig, allowedCommands []string, extra ...string) []string {
 	var cmds []string
 	if len(allowedCommands) > 0 {
 		cmds = make([]string, len(allowedCommands))
 		copy(cmds, allowedCommands)
 	} else {
-		for cmd := range botCfg.Commands {
-			cmds = append(cmds, cmd)
+		if botCfg != nil {
+			for cmd := range botCfg.Commands {
+				cmds = append(cmds, cmd)
+			}
+		}
+		for _, cmd := range extra {
+			if botCfg == nil || !hasCommand(botCfg, cmd) {
+				cmds = append(cmds, cmd)
+			}
 		}
 	}
 	sort.Strings(cmds)
-	return "Available commands: " + strings.Join(cmds, ", ")
+	return cmds
+}
+
+func hasCommand(botCfg *bot.BotConfig, name string) bool {
+	_, ok := botCfg.Commands[name]
+	return ok
 }
 
 // HandleMessage processes an incoming Matrix message event.
 func (app *App) HandleMessage(evCtx context.Context, ev *event.Event) {
+	// /bot aikey can carry an API key, so it's handled before anything is
+	// archived, and also in direct chats.
+	if app.interceptAIKey(evCtx, ev) {
+		return
+	}
 	currentRoom, ok := app.findRoom(ev.RoomID)
-	if len(app.Cfg.RoomIDs) > 0 && !ok {
+	if (len(app.Cfg.RoomIDs) > 0 || app.Cfg.AllJoinedRooms) && !ok {
 		return
 	}
 
@@ -91,7 +174,18 @@ func (app *App) HandleMessage(evCtx context.Context, ev *event.Event) {
 		log.Error().Err(err).Str("event_id", string(ev.ID)).Msg("store event")
 		return
 	}
-	log.Info().Str("room", currentRoom.Comment).Str("sender", string(ev.Sender)).Msg(util.Truncate(msgData.Msg.Body, 100))
+	logEvent := log.Info().Str("room", currentRoom.Comment).Str("sender", string(ev.Sender))
+	if name := matrix.CachedDisplayName(ev.RoomID, ev.Sender); name != "" {
+		logEvent = logEvent.Str("name", name)
+	}
+	logEvent.Msg(util.Truncate(msgData.Msg.Body, 100))
+
+	// Track the bot's own messages so /bot oops can redact them.
+	if app.Client != nil && ev.Sender == app.Client.UserID {
+		if err := db.StoreSentMessage(app.MessagesDB, string(ev.ID), string(ev.RoomID), int64(ev.Timestamp)); err != nil {
+			log.Warn().Err(err).Str("event_id", string(ev.ID)).Msg("store sent message")
+		}
+	}
 
 	// Skip messages that contain the bot's own reply label.
 	if app.Cfg.BotReplyLabel != "" && strings.Contains(msgData.Msg.Body, app.Cfg.BotReplyLabel) {
@@ -99,6 +193,49 @@ func (app *App) HandleMessage(evCtx context.Context, ev *event.Event) {
 		return
 	}
 
+	// Ignored users are archived but never trigger anything.
+	if app.Ignored != nil && app.Ignored.Contains(ev.Sender) {
+		log.Debug().Str("sender", string(ev.Sender)).Msg("skipped ignored user")
+		return
+	}
+
+	// Read-only deployments only archive messages and export links.
+	if app.Cfg.ReadOnly {
+		app.processLinks(evCtx, ev, msgData, currentRoom)
+		return
+	}
+
+	// Flood detection: shadow-ignored senders are stored but otherwise skipped.
+	if currentRoom.Flood != nil && app.Flood != nil && (app.Client == nil || ev.Sender != app.Client.UserID) {
+		now := time.Now()
+		if app.Flood.IsIgnored(string(ev.RoomID), string(ev.Sender), now) {
+			log.Debug().Str("sender", string(ev.Sender)).Msg("skipped shadow-ignored sender")
+			return
+		}
+		if reason := app.Flood.Check(string(ev.RoomID), string(ev.Sender), msgData.Msg.Body, len(msgData.URLs), now, currentRoom.Flood); reason != "" {
+			app.handleFlood(evCtx, ev, currentRoom, reason)
+			if util.InSlice(currentRoom.Flood.Actions, "ignore") {
+				return
+			}
+		}
+	}
+
+	// Slow mode: messages sent too soon are archived but not processed.
+	if app.SlowMode != nil && (app.Client == nil || ev.Sender != app.Client.UserID) && !app.isAdmin(ev.Sender) {
+		if interval := app.SlowMode.Interval(ev.RoomID); interval > 0 && app.SlowMode.Check(ev.RoomID, ev.Sender, time.Now()) {
+			go app.handleSlowModeViolation(evCtx, ev, currentRoom, interval)
+			return
+		}
+	}
+
+	// Word filter: matching messages stay archived but aren't processed further.
+	if currentRoom.WordFilter != nil && (app.Client == nil || ev.Sender != app.Client.UserID) {
+		if pattern, ok := matchWordFilter(currentRoom.WordFilter.Patterns, msgData.Msg.Body); ok {
+			go app.handleWordFilter(evCtx, ev, currentRoom, pattern)
+			return
+		}
+	}
+
 	// Check for knock-knock joke reply continuations.
 	if app.KnockKnock != nil && msgData.Msg.RelatesTo != nil && msgData.Msg.RelatesTo.InReplyTo != nil {
 		if step, ok := app.KnockKnock.Get(msgData.Msg.RelatesTo.InReplyTo.EventID); ok {
@@ -107,6 +244,22 @@ func (app *App) HandleMessage(evCtx context.Context, ev *event.Event) {
 		}
 	}
 
+	// Check for moderation confirmations from the requester.
+	if app.Moderation != nil && msgData.Msg.RelatesTo != nil && msgData.Msg.RelatesTo.InReplyTo != nil {
+		if action, ok := app.Moderation.Get(msgData.Msg.RelatesTo.InReplyTo.EventID); ok && action.Requester == ev.Sender {
+			go app.confirmModeration(evCtx, ev, msgData.Msg.Body, action, msgData.Msg.RelatesTo.InReplyTo.EventID)
+			return
+		}
+	}
+
+	// Check for data deletion confirmations from the requester.
+	if app.DataDeletions != nil && msgData.Msg.RelatesTo != nil && msgData.Msg.RelatesTo.InReplyTo != nil {
+		if user, ok := app.DataDeletions.Get(msgData.Msg.RelatesTo.InReplyTo.EventID); ok && user == ev.Sender {
+			go app.confirmDataDeletion(evCtx, ev, msgData.Msg.Body, msgData.Msg.RelatesTo.InReplyTo.EventID)
+			return
+		}
+	}
+
 	// Check for trivia quiz replies.
 	if msgData.Msg.RelatesTo != nil && msgData.Msg.RelatesTo.InReplyTo != nil {
 		speaker, ok := bot.GetTriviaAnswer(msgData.Msg.RelatesTo.InReplyTo.EventID)
@@ -117,6 +270,32 @@ func (app *App) HandleMessage(evCtx context.Context, ev *event.Event) {
 		}
 	}
 
+	// Transcribe voice messages in rooms that want it.
+	if currentRoom.TranscribeVoice && bot.Transcription != nil && app.Client != nil && ev.Sender != app.Client.UserID && bot.IsVoiceMessage(msgData.Msg) {
+		go app.transcribeVoice(evCtx, ev, msgData.Msg)
+		return
+	}
+
+	// Answer sed-style corrections of the sender's own messages.
+	if currentRoom.SedCorrections && app.Client != nil && ev.Sender != app.Client.UserID {
+		if sub, ok := ParseSubstitution(msgData.Msg.Body); ok {
+			go app.correctMessage(evCtx, ev, sub)
+			return
+		}
+	}
+
+	// Offer a summary of threads that grew long.
+	if currentRoom.ThreadDigest != nil && app.Client != nil && ev.Sender != app.Client.UserID {
+		go app.checkThreadDigest(evCtx, ev, msgData, currentRoom)
+	}
+
+	// Point repeated questions at earlier answers. Replies are left alone,
+	// they're usually part of a conversation.
+	if currentRoom.DupQuestions != nil && app.Client != nil && ev.Sender != app.Client.UserID &&
+		msgData.Msg.RelatesTo == nil && IsQuestion(msgData.Msg.Body) {
+		go app.checkDuplicateQuestion(evCtx, ev, msgData.Msg.Body, currentRoom)
+	}
+
 	// Handle bot commands.
 	if currentRoom.AllowedCommands != nil && (strings.HasPrefix(msgData.Msg.Body, "/bot") || strings.HasPrefix(msgData.Msg.Body, "@gork")) {
 		app.dispatchBotCommand(evCtx, ev, msgData, currentRoom)
@@ -127,22 +306,19 @@ func (app *App) HandleMessage(evCtx context.Context, ev *event.Event) {
 	app.processLinks(evCtx, ev, msgData, currentRoom)
 }
 
-// findRoom returns the RoomIDEntry matching the given room ID.
+// findRoom returns the RoomIDEntry for the given room ID, including rooms
+// picked up by ALL_JOINED_ROOMS.
 func (app *App) findRoom(roomID id.RoomID) (config.RoomIDEntry, bool) {
-	for _, r := range app.Cfg.RoomIDs {
-		if string(roomID) == r.ID {
-			return r, true
-		}
-	}
-	return config.RoomIDEntry{}, false
+	return app.Cfg.Room(string(roomID))
+}
+
+// isAdmin reports whether the user is listed in config ADMINS.
+func (app *App) isAdmin(userID id.UserID) bool {
+	return util.InSlice(app.Cfg.Admins, string(userID))
 }
 
 // dispatchBotCommand parses and dispatches a bot command.
 func (app *App) dispatchBotCommand(evCtx context.Context, ev *event.Event, msgData *db.MessageData, room config.RoomIDEntry) {
-	if app.Cfg.DryRun {
-		log.Info().Msg("dry run mode: skipping bot command")
-		return
-	}
 	select {
 	case <-app.ReadyChan:
 	case <-evCtx.Done():
@@ -159,50 +335,146 @@ func (app *App) dispatchBotCommand(evCtx context.Context, ev *event.Event, msgDa
 		cmd = parts[1]
 	}
 
-	label := ResolveReplyLabel(app.Cfg, app.BotCfg)
+	label := ResolveReplyLabel(app.Cfg, app.botConfig())
 
 	// Check command permissions.
 	if len(room.AllowedCommands) > 0 && !util.InSlice(room.AllowedCommands, cmd) && cmd != "hi" {
-		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+"command not allowed in this room", cmd)
+		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+locale.ForRoom(room.ID, "command_not_allowed"), cmd)
 		return
 	}
 
-	if app.BotCfg == nil {
-		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+"no bot configuration loaded", cmd)
-		return
+	router := app.Router
+	if router == nil {
+		router = NewRouter()
+	}
+	var args string
+	if len(parts) > 2 {
+		args = strings.Join(parts[2:], " ")
+	}
+	c := &Command{App: app, Event: ev, Msg: msgData, Room: room, Name: cmd, Args: args, Label: label}
+
+	// Run the command in a goroutine to avoid blocking other messages.
+	go router.Serve(evCtx, c, app.runConfiguredCommand)
+}
+
+// runConfiguredCommand is the Router fallback for commands defined in bot.json.
+func (app *App) runConfiguredCommand(evCtx context.Context, c *Command) {
+	ev, msgData, room, cmd, label := c.Event, c.Msg, c.Room, c.Name, c.Label
+	botCfg := app.botConfig()
+	var routerNames []string
+	if app.Router != nil {
+		routerNames = app.Router.Names()
 	}
 
+	help := locale.ForRoom(room.ID, "help", strings.Join(HelpCommands(botCfg, room.AllowedCommands, routerNames...), ", "))
 	if cmd == "help" {
-		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+GenerateHelpMessage(app.BotCfg, room.AllowedCommands), cmd)
+		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+help, cmd)
 		return
 	}
 
-	cmdCfg, ok := app.BotCfg.Commands[cmd]
+	if botCfg == nil {
+		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+locale.ForRoom(room.ID, "no_bot_config"), cmd)
+		return
+	}
+
+	cmdCfg, ok := botCfg.Commands[cmd]
 	if !ok {
-		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+"Unknown command. "+GenerateHelpMessage(app.BotCfg, room.AllowedCommands), cmd)
+		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+locale.ForRoom(room.ID, "unknown_command", help), cmd)
 		return
 	}
 
-	// Handle knockknock specially since it needs conversational state.
-	if cmdCfg.Type == "builtin" && cmdCfg.Command == "knockknock" {
-		go app.startKnockKnock(evCtx, ev, label)
+	if cmdCfg.Admin && !app.isAdmin(ev.Sender) {
+		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+locale.ForRoom(room.ID, "admin_only"), cmd)
 		return
 	}
+	// Admins may exceed the room's daily media quota.
+	if app.isAdmin(ev.Sender) {
+		evCtx = matrix.WithQuotaOverride(evCtx)
+	}
+	aiKey, ownKey := app.aiKeyFor(ev.Sender)
+	evCtx = bot.WithAIOrigin(evCtx, string(ev.RoomID), string(ev.Sender), cmd, ownKey)
 
-	// Run the command in a goroutine to avoid blocking other messages.
-	go func() {
-		resp, err := bot.FetchBotCommand(evCtx, &cmdCfg, app.Cfg.LinkstashURL, ev, app.Client, app.Cfg.GroqAPIKey, label, app.MessagesDB)
-		var body string
-		if err != nil {
-			log.Error().Err(err).Str("cmd", cmd).Msg("failed to execute bot command")
-			body = fmt.Sprintf("sorry, couldn't execute %s right now", cmd)
-		} else if resp != "" {
-			body = resp
-		} else {
-			return // Command sent its own message (like images).
+	if cmdCfg.Type == "builtin" {
+		switch {
+		case bot.ModerationActions[cmdCfg.Command]:
+			// Moderation actions need a confirmation round-trip.
+			app.startModeration(evCtx, ev, msgData, cmdCfg.Command, label)
+			return
+		case cmdCfg.Command == "ignore" || cmdCfg.Command == "unignore":
+			app.handleIgnore(evCtx, ev, msgData, cmdCfg.Command, label)
+			return
+		case cmdCfg.Command == "oops":
+			app.handleOops(evCtx, ev, msgData, label)
+			return
+		case cmdCfg.Command == "slowmode" && app.SlowMode != nil:
+			app.handleSlowModeCommand(evCtx, ev, msgData, room, label)
+			return
+		case cmdCfg.Command == "export":
+			app.handleExport(evCtx, ev, label)
+			return
+		case cmdCfg.Command == "more":
+			app.handleMore(evCtx, ev, msgData, label)
+			return
+		case cmdCfg.Command == "status":
+			app.handleStatus(evCtx, ev, label)
+			return
+		case cmdCfg.Command == "backfill":
+			app.handleBackfill(evCtx, ev, c.Args, label)
+			return
+		case cmdCfg.Command == "modlog":
+			app.handleModLog(evCtx, ev, c.Args, label)
+			return
+		case cmdCfg.Command == "feed":
+			app.handleFeed(evCtx, ev, c.Args, cmd, label)
+			return
+		case cmdCfg.Command == "ailog":
+			app.handleAILog(evCtx, ev, c.Args, label)
+			return
+		case cmdCfg.Command == "glossary":
+			app.handleGlossary(evCtx, ev, c.Args, cmdCfg, aiKey, cmd, label)
+			return
+		case cmdCfg.Command == "sticker":
+			app.handleSticker(evCtx, ev, c.Args, cmd, label)
+			return
+		case cmdCfg.Command == "mydata":
+			app.handleMyData(evCtx, ev, c.Args, cmd, label)
+			return
+		case cmdCfg.Command == "report":
+			app.handleReport(evCtx, ev, msgData, room.Comment, label)
+			return
+		case cmdCfg.Command == "knockknock":
+			// Handle knockknock specially since it needs conversational state.
+			app.startKnockKnock(evCtx, ev, label)
+			return
 		}
-		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+body, cmd)
-	}()
+	}
+
+	if app.Cfg.DryRun && app.Cfg.DryRunNoNetwork && (cmdCfg.Type == "http" || cmdCfg.Type == "ai" || cmdCfg.Type == "download" || (cmdCfg.Type == "builtin" && (cmdCfg.Command == "gif" || cmdCfg.Command == "upload"))) {
+		log.Info().Str("cmd", cmd).Str("type", cmdCfg.Type).Msg("dry run mode: skipping network command")
+		return
+	}
+	resp, err := bot.FetchBotCommand(evCtx, &cmdCfg, app.Cfg.LinkstashURL, ev, app.Client, aiKey, label, app.MessagesDB, room)
+	if err != nil {
+		log.Error().Err(err).Str("cmd", cmd).Msg("failed to execute bot command")
+		app.captureFailure(c, cmdCfg.Type, err)
+	}
+	if cmdCfg.OutputType == "reaction" {
+		if key, ok := ResultReaction(resp, cmdCfg.Reaction, err); ok {
+			if _, err := app.Client.SendReaction(evCtx, ev.RoomID, ev.ID, key); err != nil {
+				log.Error().Err(err).Str("cmd", cmd).Msg("failed to react with command result")
+			}
+			return
+		}
+		log.Debug().Str("cmd", cmd).Msg("command output too long for a reaction, replying instead")
+	}
+	if err != nil {
+		SendBotReply(evCtx, app.Client, ev.RoomID, ev.ID, label+locale.ForRoom(room.ID, "command_failed", cmd), cmd)
+		return
+	}
+	if resp == "" {
+		return // Command sent its own message (like images).
+	}
+	app.sendCommandOutput(evCtx, ev, &cmdCfg, label, resp, cmd)
 }
 
 // startKnockKnock begins a knock-knock joke conversation.
@@ -210,28 +482,17 @@ func (app *App) startKnockKnock(ctx context.Context, ev *event.Event, label stri
 	joke := bot.KnockKnockJokes[grand.Intn(len(bot.KnockKnockJokes))]
 
 	body := label + "Knock knock! (reply to this message)"
-	content := event.MessageEventContent{
-		MsgType:   event.MsgText,
-		Body:      body,
-		RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: ev.ID}},
-	}
-	resp, err := app.Client.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content)
+	openerID, err := matrix.SendReply(ctx, app.Client, ev.RoomID, ev.ID, body, "")
 	if err != nil {
 		log.Error().Err(err).Msg("failed to send knock knock opener")
 		return
 	}
 
-	app.KnockKnock.Set(resp.EventID, &bot.KnockKnockStep{
+	app.KnockKnock.Set(openerID, &bot.KnockKnockStep{
 		Joke:  joke,
 		Step:  0,
 		Label: label,
 	})
-
-	// Clean up after 5 minutes if no reply.
-	go func() {
-		time.Sleep(5 * time.Minute)
-		app.KnockKnock.Delete(resp.EventID)
-	}()
 }
 
 // handleKnockKnockReply continues a knock-knock joke conversation.
@@ -241,26 +502,16 @@ func (app *App) handleKnockKnockReply(ctx context.Context, ev *event.Event, step
 	if step.Step == 0 {
 		// User replied to "Knock knock!" — send the name.
 		body := fmt.Sprintf("%s%s (reply to this message)", step.Label, step.Joke.Name)
-		content := event.MessageEventContent{
-			MsgType:   event.MsgText,
-			Body:      body,
-			RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: ev.ID}},
-		}
-		resp, err := app.Client.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content)
+		nameID, err := matrix.SendReply(ctx, app.Client, ev.RoomID, ev.ID, body, "")
 		if err != nil {
 			log.Error().Err(err).Msg("failed to send knock knock name")
 			return
 		}
-		app.KnockKnock.Set(resp.EventID, &bot.KnockKnockStep{
+		app.KnockKnock.Set(nameID, &bot.KnockKnockStep{
 			Joke:  step.Joke,
 			Step:  1,
 			Label: step.Label,
 		})
-		// Clean up after 5 minutes.
-		go func() {
-			time.Sleep(5 * time.Minute)
-			app.KnockKnock.Delete(resp.EventID)
-		}()
 	} else {
 		// User replied to the name — send the punchline!
 		body := step.Label + step.Joke.Punchline
@@ -272,12 +523,8 @@ func (app *App) handleKnockKnockReply(ctx context.Context, ev *event.Event, step
 func (app *App) revealTriviaAnswer(ctx context.Context, ev *event.Event, speaker string) {
 	// Resolve display name for speaker
 	display := speaker
-	if app.Client != nil {
-		if resp, err := app.Client.JoinedMembers(ctx, ev.RoomID); err == nil {
-			if member, ok := resp.Joined[id.UserID(speaker)]; ok && member.DisplayName != "" {
-				display = member.DisplayName
-			}
-		}
+	if name := matrix.DisplayName(ctx, app.Client, ev.RoomID, id.UserID(speaker)); name != "" {
+		display = name
 	}
 	if display == speaker && strings.HasPrefix(speaker, "@") {
 		if idx := strings.Index(speaker, ":"); idx > 0 {
@@ -285,12 +532,13 @@ func (app *App) revealTriviaAnswer(ctx context.Context, ev *event.Event, speaker
 		}
 	}
 
-	label := ResolveReplyLabel(app.Cfg, app.BotCfg)
+	label := ResolveReplyLabel(app.Cfg, app.botConfig())
 	body := fmt.Sprintf("%s%s said that", label, display)
 	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, body, "trivia")
 }
 
-// HandleReaction stores emoji reactions to messages.
+// HandleReaction stores emoji reactions to messages, accepts thread summary
+// offers and turns the pages of paged replies.
 func (app *App) HandleReaction(ctx context.Context, ev *event.Event) {
 	relatesTo := ev.Content.AsReaction()
 	if relatesTo == nil || relatesTo.RelatesTo.EventID == "" {
@@ -314,10 +562,22 @@ func (app *App) HandleReaction(ctx context.Context, ev *event.Event) {
 	}
 
 	log.Debug().Str("target_msg", targetMsgID).Str("emoji", emoji).Msg("reaction stored successfully")
+
+	// Accept thread summary offers, ignoring the bot's own pre-reaction.
+	if room, ok := app.findRoom(ev.RoomID); ok && room.ThreadDigest != nil && !app.Cfg.ReadOnly &&
+		strings.HasPrefix(emoji, threadDigestEmoji) && ev.Sender != app.botUserID() {
+		aiCtx := bot.WithAIOrigin(ctx, string(ev.RoomID), string(ev.Sender), "threadDigest", false)
+		go app.postThreadDigest(aiCtx, ev.RoomID, relatesTo.RelatesTo.EventID, room)
+	}
+	// Clients send ➡ with or without the emoji variation selector.
+	if app.Pages != nil && !app.Cfg.ReadOnly && strings.HasPrefix(emoji, strings.TrimSuffix(bot.NextPageEmoji, "\ufe0f")) && ev.Sender != app.botUserID() {
+		go app.postNextPage(ctx, ev.RoomID, relatesTo.RelatesTo.EventID)
+	}
 }
 
-// processLinks handles link extraction, hooks, and snapshot exports.
-func (app *App) processLinks(_ context.Context, ev *event.Event, msgData *db.MessageData, room config.RoomIDEntry) {
+// processLinks handles link extraction, hooks, crossposts and snapshot
+// exports.
+func (app *App) processLinks(ctx context.Context, ev *event.Event, msgData *db.MessageData, room config.RoomIDEntry) {
 	if len(msgData.URLs) == 0 {
 		log.Debug().Msg("no links found")
 		return
@@ -330,28 +590,116 @@ func (app *App) processLinks(_ context.Context, ev *event.Event, msgData *db.Mes
 
 	if app.Cfg.OptOutTag != "" && strings.Contains(msgData.Msg.Body, app.Cfg.OptOutTag) {
 		log.Info().Str("tag", app.Cfg.OptOutTag).Msg("skipped sending hooks due to opt-out tag")
-	} else if app.Cfg.DryRun {
-		log.Info().Msg("dry run mode: skipping hooks")
 	} else {
 		blacklist, err := links.LoadBlacklist("blacklist.json")
 		if err != nil {
 			log.Error().Err(err).Msg("failed to load blacklist")
 		}
+		var accepted []string
+		for _, u := range msgData.URLs {
+			if blacklist != nil && links.IsBlacklisted(u, blacklist) {
+				log.Info().Str("url", u).Msg("skipped blacklisted url")
+				continue
+			}
+			accepted = append(accepted, u)
+		}
 		if room.Hook != "" {
-			for _, u := range msgData.URLs {
-				if blacklist != nil && links.IsBlacklisted(u, blacklist) {
-					log.Info().Str("url", u).Msg("skipped blacklisted url")
+			senderName := ""
+			if room.SendUser && len(accepted) > 0 {
+				senderName = matrix.DisplayName(ctx, app.Client, ev.RoomID, ev.Sender)
+			}
+			for _, u := range accepted {
+				if app.Cfg.DryRun {
+					go links.LogHook(room.Hook, u, string(ev.Sender), senderName, room.ID, room.Comment, room.SendUser, room.SendTopic, !app.Cfg.DryRunNoNetwork)
 					continue
 				}
-				go links.SendHook(room.Hook, u, room.Key, string(ev.Sender), room.ID, room.Comment, room.SendUser, room.SendTopic)
+				if app.Hooks != nil {
+					app.Hooks.Enqueue(room.Hook, room.Key, links.HookLink{
+						URL:         u,
+						Sender:      string(ev.Sender),
+						SenderName:  senderName,
+						RoomID:      room.ID,
+						RoomComment: room.Comment,
+						SendUser:    room.SendUser,
+						SendTopic:   room.SendTopic,
+					})
+				}
 			}
 		}
+		if room.Crosspost != nil && len(accepted) > 0 && !app.Cfg.ReadOnly && app.Client != nil && ev.Sender != app.Client.UserID {
+			go app.crosspostLinks(ctx, ev, accepted, msgData.Msg.Body, room)
+		}
 	}
 
+	if app.Exporter != nil {
+		app.Exporter.LinksStored()
+		return
+	}
 	log.Info().Msg("stored to db, exporting snapshot...")
-	if err := db.ExportAllSnapshots(app.MessagesDB, app.Cfg.RoomIDs, app.Cfg.LinksPath); err != nil {
+	if err := app.exportSnapshots(); err != nil {
 		log.Error().Err(err).Msg("export snapshots")
 	} else {
 		log.Info().Str("path", app.Cfg.LinksPath).Msg("exported")
 	}
 }
+
+// exportSnapshots writes all monitored rooms' links to LINKS_JSON_PATH.
+func (app *App) exportSnapshots() error {
+	return ExportLinks(app.MessagesDB, app.Cfg, app.Cfg.LinksPath)
+}
+
+// exportNow writes the link snapshot immediately, whatever EXPORT_MODE is.
+func (app *App) exportNow() error {
+	if app.Exporter != nil {
+		return app.Exporter.ExportNow()
+	}
+	return app.exportSnapshots()
+}
+
+// ExportLinks writes the links of every monitored room to path. With
+// ALL_JOINED_ROOMS that includes every non-excluded room links were seen in.
+func ExportLinks(database *sql.DB, cfg *config.Config, path string) error {
+	rooms, err := exportRooms(database, cfg)
+	if err != nil {
+		return err
+	}
+	return db.ExportAllSnapshots(database, rooms, path)
+}
+
+// LinkExporter returns an export function for NewExporter that writes what
+// ExportLinks does to LINKS_JSON_PATH, skipping the write when the links
+// haven't changed since snapshot's previous export.
+func LinkExporter(database *sql.DB, cfg *config.Config, snapshot *db.LinkSnapshot) func() error {
+	return func() error {
+		rooms, err := exportRooms(database, cfg)
+		if err != nil {
+			return err
+		}
+		return snapshot.Export(database, rooms, cfg.LinksPath)
+	}
+}
+
+// exportRooms lists the rooms whose links are exported.
+func exportRooms(database *sql.DB, cfg *config.Config) ([]config.RoomIDEntry, error) {
+	var seen []string
+	if cfg.AllJoinedRooms {
+		var err error
+		if seen, err = db.LinkRoomIDs(database); err != nil {
+			return nil, fmt.Errorf("list link rooms: %w", err)
+		}
+	}
+	return cfg.Rooms(seen), nil
+}
+
+// handleExport implements the admin-only /bot export.
+func (app *App) handleExport(ctx context.Context, ev *event.Event, label string) {
+	if !app.isAdmin(ev.Sender) {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+locale.ForRoom(string(ev.RoomID), "admin_only"), "export")
+		return
+	}
+	if err := app.exportNow(); err != nil {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"export failed", "export")
+		return
+	}
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"links exported", "export")
+}
@@ -1,11 +1,25 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
 
 	"github.com/polarhive/ash/bot"
 	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
 )
 
 func TestResolveReplyLabel(t *testing.T) {
@@ -55,3 +69,275 @@ func TestGenerateHelpMessage(t *testing.T) {
 		t.Errorf("GenerateHelpMessage should not include filtered-out command: %s", msg)
 	}
 }
+
+func TestIsAdmin(t *testing.T) {
+	a := &App{Cfg: &config.Config{Admins: []string{"@alice:example.com"}}}
+	if !a.isAdmin("@alice:example.com") {
+		t.Error("expected alice to be an admin")
+	}
+	if a.isAdmin("@bob:example.com") {
+		t.Error("expected bob not to be an admin")
+	}
+	if (&App{Cfg: &config.Config{}}).isAdmin("@alice:example.com") {
+		t.Error("expected no admins when ADMINS is empty")
+	}
+}
+
+func TestMatchWordFilter(t *testing.T) {
+	patterns := []string{`\bheck\b`, `(`, `darn+`}
+	if p, ok := matchWordFilter(patterns, "oh HECK no"); !ok || p != `\bheck\b` {
+		t.Errorf("expected heck pattern to match, got %q %v", p, ok)
+	}
+	if p, ok := matchWordFilter(patterns, "darnnn it"); !ok || p != `darn+` {
+		t.Errorf("expected darn pattern to match, got %q %v", p, ok)
+	}
+	if _, ok := matchWordFilter(patterns, "checking in"); ok {
+		t.Error("expected no match for substring of a bounded word")
+	}
+}
+
+func TestFormatReport(t *testing.T) {
+	link := matrix.Permalink("!room:example.com", "$ev")
+	got := FormatReport("lounge", "@rep:example.com", "@bad:example.com", "spam", link, "")
+	want := "report from @rep:example.com in lounge\nauthor: @bad:example.com\nmessage: spam\nlink: " + link
+	if got != want {
+		t.Errorf("FormatReport() = %q, want %q", got, want)
+	}
+	if got := FormatReport("lounge", "@rep:example.com", "@bad:example.com", "spam", link, "scam link"); !strings.HasSuffix(got, "\nreason: scam link") {
+		t.Errorf("FormatReport() with reason = %q", got)
+	}
+}
+
+func TestDMRoom(t *testing.T) {
+	members := map[string]string{
+		"!old:example.com/@ash:example.com":    "join",
+		"!old:example.com/@alice:example.com":  "join",
+		"!gone:example.com/@ash:example.com":   "join",
+		"!gone:example.com/@alice:example.com": "leave",
+	}
+	var created int
+	var direct string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/account_data/m.direct"):
+			if r.Method == http.MethodPut {
+				body, _ := io.ReadAll(r.Body)
+				direct = string(body)
+			}
+			fmt.Fprint(w, `{"@alice:example.com":["!old:example.com","!gone:example.com"]}`)
+		case strings.Contains(r.URL.Path, "/state/m.room.member/"):
+			room, user, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/rooms/"), "/state/m.room.member/")
+			membership, ok := members[room+"/"+user]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"errcode":"M_NOT_FOUND"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"membership":%q}`, membership)
+		case strings.HasSuffix(r.URL.Path, "/createRoom"):
+			created++
+			fmt.Fprint(w, `{"room_id":"!new:example.com"}`)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{Cfg: &config.Config{}, Client: client}
+
+	// The newest listed room alice left is skipped for the one she's in.
+	if room, err := a.dmRoom(context.Background(), "@alice:example.com"); err != nil || room != "!old:example.com" || created != 0 {
+		t.Errorf("alice: %q, %v, %d created", room, err, created)
+	}
+	room, err := a.dmRoom(context.Background(), "@bob:example.com")
+	if err != nil || room != "!new:example.com" || created != 1 {
+		t.Errorf("bob: %q, %v, %d created", room, err, created)
+	}
+	if !strings.Contains(direct, `"@bob:example.com":["!new:example.com"]`) || !strings.Contains(direct, `"@alice:example.com"`) {
+		t.Errorf("m.direct = %s", direct)
+	}
+}
+
+func TestIgnoreList(t *testing.T) {
+	database, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	if err := db.AddIgnoredUser(database, "@bridge:example.com", "@admin:example.com", 1); err != nil {
+		t.Fatalf("AddIgnoredUser: %v", err)
+	}
+
+	l, err := LoadIgnoreList(database)
+	if err != nil {
+		t.Fatalf("LoadIgnoreList: %v", err)
+	}
+	if !l.Contains("@bridge:example.com") || l.Contains("@alice:example.com") {
+		t.Errorf("unexpected ignore list after load: %v", l.List())
+	}
+	l.Set("@alice:example.com", true)
+	l.Set("@bridge:example.com", false)
+	if got := l.List(); len(got) != 1 || got[0] != "@alice:example.com" {
+		t.Errorf("List() = %v, want [@alice:example.com]", got)
+	}
+}
+
+func TestParseOopsCount(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    int
+		wantErr bool
+	}{
+		{"", 1, false},
+		{"3", 3, false},
+		{"500", maxOops, false},
+		{"0", 0, true},
+		{"lots", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOopsCount(tt.args)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseOopsCount(%q) = %d, %v; want %d, err=%v", tt.args, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRecentSentMessages(t *testing.T) {
+	database, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	for i, evID := range []string{"$a", "$b", "$c"} {
+		if err := db.StoreSentMessage(database, evID, "!room:example.com", int64(i)); err != nil {
+			t.Fatalf("StoreSentMessage: %v", err)
+		}
+	}
+	_ = db.StoreSentMessage(database, "$other", "!other:example.com", 10)
+	_ = db.DeleteSentMessage(database, "$c")
+
+	got, err := db.RecentSentMessages(database, "!room:example.com", 5)
+	if err != nil {
+		t.Fatalf("RecentSentMessages: %v", err)
+	}
+	if strings.Join(got, ",") != "$b,$a" {
+		t.Errorf("RecentSentMessages() = %v, want [$b $a]", got)
+	}
+}
+
+func TestGenerateHelpMessageExtra(t *testing.T) {
+	botCfg := &bot.BotConfig{Commands: map[string]bot.BotCommand{"hello": {Type: "http"}}}
+	if got, want := GenerateHelpMessage(botCfg, nil, "ping", "hello"), "Available commands: hello, ping"; got != want {
+		t.Errorf("GenerateHelpMessage() = %q, want %q", got, want)
+	}
+	if got, want := GenerateHelpMessage(nil, nil, "ping"), "Available commands: ping"; got != want {
+		t.Errorf("GenerateHelpMessage(nil) = %q, want %q", got, want)
+	}
+}
+
+func TestModLog(t *testing.T) {
+	database, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	a := &App{MessagesDB: database}
+	a.audit("!room:example.com", "@mod:example.com", "kick", "@bad:example.com", "spam", "$ev1")
+	a.audit("!room:example.com", "@ash:example.com", "wordfilter", "@bad:example.com", "", "$ev2")
+	a.audit("!other:example.com", "@mod:example.com", "ban", "@x:example.com", "", "")
+
+	entries, err := db.ModLog(database, "!room:example.com", 10)
+	if err != nil {
+		t.Fatalf("ModLog: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != "wordfilter" || entries[1].EventID != "$ev1" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	got := FormatModLog(entries[1:])
+	if !strings.HasSuffix(got, " kick @bad:example.com by @mod:example.com: spam ($ev1)") {
+		t.Errorf("FormatModLog() = %q", got)
+	}
+	if FormatModLog(nil) != "no moderation actions recorded" {
+		t.Errorf("FormatModLog(nil) = %q", FormatModLog(nil))
+	}
+}
+
+func TestCaptureFailure(t *testing.T) {
+	database, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	ev := &event.Event{
+		ID:      "$fail",
+		RoomID:  "!room:example.com",
+		Sender:  "@alice:example.com",
+		Type:    event.EventMessage,
+		Content: event.Content{Raw: map[string]any{"msgtype": "m.text", "body": "/bot deepfry"}},
+	}
+	c := &Command{Event: ev, Name: "deepfry", Room: config.RoomIDEntry{Comment: "lounge"}}
+
+	a := &App{Cfg: &config.Config{}, MessagesDB: database}
+	a.captureFailure(c, "exec", errors.New("boom"))
+	if _, err := db.GetDebugEvent(database, 1); err == nil {
+		t.Fatal("nothing should be captured when CAPTURE_FAILED_EVENTS is off")
+	}
+
+	a.Cfg.CaptureFailedEvents = true
+	a.captureFailure(c, "exec", errors.New("boom"))
+	got, err := db.GetDebugEvent(database, 1)
+	if err != nil {
+		t.Fatalf("GetDebugEvent: %v", err)
+	}
+	if got.Command != "deepfry" || got.Error != "boom" || !strings.Contains(got.StateJSON, `"room":"lounge"`) {
+		t.Errorf("unexpected capture: %+v", got)
+	}
+	var replayed event.Event
+	if err := json.Unmarshal([]byte(got.RawJSON), &replayed); err != nil {
+		t.Fatalf("captured JSON is not an event: %v", err)
+	}
+	if replayed.ID != "$fail" || replayed.Content.Raw["body"] != "/bot deepfry" {
+		t.Errorf("captured event lost data: %s", got.RawJSON)
+	}
+
+	// Old captures are pruned on insert.
+	if err := db.StoreDebugEvent(database, db.DebugEvent{Command: "later", TSMillis: time.Now().Add(48 * time.Hour).UnixMilli()}, int64(24*time.Hour/time.Millisecond)); err != nil {
+		t.Fatalf("StoreDebugEvent: %v", err)
+	}
+	if _, err := db.GetDebugEvent(database, 1); err == nil {
+		t.Error("expected expired capture to be pruned")
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	got := StatusText(startedAt.Add(90 * time.Minute))
+	if !strings.HasPrefix(got, "ash ") || !strings.Contains(got, "uptime: 1h30m0s") {
+		t.Errorf("StatusText() = %q", got)
+	}
+}
+
+func TestResultReaction(t *testing.T) {
+	tests := []struct {
+		output, success string
+		err             error
+		want            string
+		ok              bool
+	}{
+		{"😊\n", "", nil, "😊", true},
+		{"", "", nil, "✅", true},
+		{`{"status":"queued"}`, "✅", nil, "✅", true},
+		{"", "✅", errors.New("unexpected status: 500"), "❌", true},
+		{"this is a whole sentence of output", "", nil, "", false},
+		{"two\nlines", "", nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ResultReaction(tt.output, tt.success, tt.err)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ResultReaction(%q, %q, %v) = %q, %v; want %q, %v", tt.output, tt.success, tt.err, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/locale"
+)
+
+// backfillPageSize is how many events each /messages request asks for.
+const backfillPageSize = 100
+
+// BackfillResult summarises a backfill run.
+type BackfillResult struct {
+	Events      int // timeline events seen
+	Stored      int // messages passed to the store (duplicates are ignored)
+	Links       int
+	Undecrypted int
+}
+
+// BackfillProgress reports a room finishing within BackfillRooms.
+type BackfillProgress struct {
+	Room   id.RoomID
+	Done   int // rooms finished so far, including this one
+	Total  int
+	Result BackfillResult
+	Err    error
+}
+
+// add folds another run's counts into res.
+func (res *BackfillResult) add(o BackfillResult) {
+	res.Events += o.Events
+	res.Stored += o.Stored
+	res.Links += o.Links
+	res.Undecrypted += o.Undecrypted
+}
+
+// backfillCheckpoint is the meta DB record of where a room's backfill got
+// to: the /messages token for the next page and the since it was run with.
+type backfillCheckpoint struct {
+	SinceMS int64  `json:"since_ms"`
+	From    string `json:"from"`
+}
+
+func backfillCheckpointKey(roomID id.RoomID) string {
+	return "backfill_checkpoint:" + string(roomID)
+}
+
+// Backfill pages backwards through a room's history via /messages, storing
+// messages and links sent at or after since. Encrypted events are decrypted
+// when the session keys are available and skipped otherwise. Messages that
+// are already stored are left alone, so backfill can be rerun safely.
+//
+// With a MetaDB, the pagination token is checkpointed after every page, so a
+// run that fails or is interrupted resumes where it stopped when it's
+// retried with the same since. The checkpoint is removed once the room is
+// done.
+func (app *App) Backfill(ctx context.Context, roomID id.RoomID, since time.Time) (BackfillResult, error) {
+	var res BackfillResult
+	sinceMS := since.UnixMilli()
+	from := app.loadBackfillCheckpoint(ctx, roomID, sinceMS)
+	if from != "" {
+		log.Info().Str("room", string(roomID)).Msg("resuming backfill from checkpoint")
+	}
+	if err := app.backfillPages(ctx, roomID, sinceMS, from, &res); err != nil {
+		return res, err
+	}
+	if app.MetaDB != nil {
+		if err := db.DeleteMeta(ctx, app.MetaDB, backfillCheckpointKey(roomID)); err != nil {
+			log.Warn().Err(err).Str("room", string(roomID)).Msg("failed to clear backfill checkpoint")
+		}
+	}
+	return res, nil
+}
+
+// backfillPages does Backfill's paging, starting at the from token.
+func (app *App) backfillPages(ctx context.Context, roomID id.RoomID, sinceMS int64, from string, res *BackfillResult) error {
+	for {
+		resp, err := app.Client.Messages(ctx, roomID, from, "", mautrix.DirectionBackward, nil, backfillPageSize)
+		if err != nil {
+			return fmt.Errorf("fetch messages: %w", err)
+		}
+		for _, ev := range resp.Chunk {
+			if ev.Timestamp < sinceMS {
+				return nil
+			}
+			res.Events++
+			if ev.RoomID == "" {
+				ev.RoomID = roomID
+			}
+			ev = app.decryptBackfill(ctx, ev, res)
+			if ev == nil || ev.Type != event.EventMessage {
+				continue
+			}
+			msgData, err := db.ProcessMessageEvent(ev)
+			if err != nil || msgData == nil {
+				continue
+			}
+			if err := db.StoreMessage(app.MessagesDB, msgData); err != nil {
+				return fmt.Errorf("store event %s: %w", ev.ID, err)
+			}
+			res.Stored++
+			res.Links += len(msgData.URLs)
+		}
+		if resp.End == "" || len(resp.Chunk) == 0 {
+			return nil
+		}
+		from = resp.End
+		app.saveBackfillCheckpoint(ctx, roomID, sinceMS, from)
+		log.Debug().Str("room", string(roomID)).Int("events", res.Events).Msg("backfill page")
+	}
+}
+
+// loadBackfillCheckpoint returns the token a previous backfill of the room
+// with the same since stopped at, or "" to start from the newest event.
+func (app *App) loadBackfillCheckpoint(ctx context.Context, roomID id.RoomID, sinceMS int64) string {
+	if app.MetaDB == nil {
+		return ""
+	}
+	raw, err := db.GetMeta(ctx, app.MetaDB, backfillCheckpointKey(roomID))
+	if err != nil || raw == "" {
+		return ""
+	}
+	var cp backfillCheckpoint
+	if err := json.Unmarshal([]byte(raw), &cp); err != nil || cp.SinceMS != sinceMS {
+		return ""
+	}
+	return cp.From
+}
+
+func (app *App) saveBackfillCheckpoint(ctx context.Context, roomID id.RoomID, sinceMS int64, from string) {
+	if app.MetaDB == nil {
+		return
+	}
+	raw, _ := json.Marshal(backfillCheckpoint{SinceMS: sinceMS, From: from})
+	if err := db.SetMeta(ctx, app.MetaDB, backfillCheckpointKey(roomID), string(raw)); err != nil {
+		log.Warn().Err(err).Str("room", string(roomID)).Msg("failed to save backfill checkpoint")
+	}
+}
+
+// BackfillRooms backfills several rooms, at most concurrency at a time
+// (1 if it's below 1). A room that fails doesn't stop the others; progress,
+// if set, is called as each room finishes, one call at a time. The result
+// adds up every room's counts and the error joins the rooms' failures.
+func (app *App) BackfillRooms(ctx context.Context, rooms []id.RoomID, since time.Time, concurrency int, progress func(BackfillProgress)) (BackfillResult, error) {
+	concurrency = max(concurrency, 1)
+	var (
+		total BackfillResult
+		errs  []error
+		done  int
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, roomID := range rooms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			res, err := app.Backfill(ctx, roomID, since)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", roomID, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			total.add(res)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			done++
+			if progress != nil {
+				progress(BackfillProgress{Room: roomID, Done: done, Total: len(rooms), Result: res, Err: err})
+			}
+		}()
+	}
+	wg.Wait()
+	return total, errors.Join(errs...)
+}
+
+// ReportBackfillProgress logs a room finishing and posts it to the mod room.
+func (app *App) ReportBackfillProgress(ctx context.Context, p BackfillProgress) {
+	name := string(p.Room)
+	if r, ok := app.Cfg.Room(name); ok && r.Comment != "" {
+		name = r.Comment
+	}
+	var body string
+	if p.Err != nil {
+		log.Error().Err(p.Err).Str("room", string(p.Room)).Msg("backfill failed")
+		body = fmt.Sprintf("backfill %d/%d: %s failed after %d messages (rerun to resume)", p.Done, p.Total, name, p.Result.Stored)
+	} else {
+		log.Info().Str("room", string(p.Room)).Int("events", p.Result.Events).Int("stored", p.Result.Stored).Int("links", p.Result.Links).Int("undecrypted", p.Result.Undecrypted).Msg("backfill finished")
+		body = fmt.Sprintf("backfill %d/%d: %s done, %d messages, %d links", p.Done, p.Total, name, p.Result.Stored, p.Result.Links)
+	}
+	app.notifyModRoom(ctx, body)
+}
+
+// decryptBackfill returns the decrypted form of an encrypted event, the
+// event itself if it isn't encrypted, or nil if it can't be decrypted.
+func (app *App) decryptBackfill(ctx context.Context, ev *event.Event, res *BackfillResult) *event.Event {
+	if ev.Type != event.EventEncrypted {
+		return ev
+	}
+	if app.Client.Crypto == nil {
+		res.Undecrypted++
+		return nil
+	}
+	if ev.Content.Raw != nil {
+		if err := ev.Content.ParseRaw(ev.Type); err != nil && !strings.Contains(err.Error(), "already parsed") {
+			res.Undecrypted++
+			return nil
+		}
+	}
+	decrypted, err := app.Client.Crypto.Decrypt(ctx, ev)
+	if err != nil {
+		log.Debug().Err(err).Str("event_id", string(ev.ID)).Msg("backfill: can't decrypt event")
+		res.Undecrypted++
+		return nil
+	}
+	return decrypted
+}
+
+// ParseBackfillSince parses a backfill start date. An empty value means the
+// last 30 days.
+func ParseBackfillSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return now.AddDate(0, 0, -30), nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (want YYYY-MM-DD)", s)
+}
+
+// backfillConcurrency is how many rooms /bot backfill all fetches at once.
+const backfillConcurrency = 3
+
+// handleBackfill implements the admin-only /bot backfill [all] [YYYY-MM-DD],
+// which backfills the current room or, with all, every monitored room,
+// posting each room's progress to the mod room.
+func (app *App) handleBackfill(ctx context.Context, ev *event.Event, args, label string) {
+	if !app.isAdmin(ev.Sender) {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+locale.ForRoom(string(ev.RoomID), "admin_only"), "backfill")
+		return
+	}
+	args = strings.TrimSpace(args)
+	rooms := []id.RoomID{ev.RoomID}
+	if rest, ok := strings.CutPrefix(args, "all"); ok && (rest == "" || rest[0] == ' ') {
+		args = strings.TrimSpace(rest)
+		rooms = app.BackfillTargets(ctx)
+	}
+	since, err := ParseBackfillSince(args, time.Now())
+	if err != nil {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"usage: /bot backfill [all] [YYYY-MM-DD]", "backfill")
+		return
+	}
+	var progress func(BackfillProgress)
+	if len(rooms) > 1 {
+		progress = func(p BackfillProgress) { app.ReportBackfillProgress(ctx, p) }
+	}
+	what := "backfilling"
+	if len(rooms) > 1 {
+		what = fmt.Sprintf("backfilling %d rooms", len(rooms))
+	}
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+what+" since "+since.Format("2006-01-02")+"...", "backfill")
+	res, err := app.BackfillRooms(ctx, rooms, since, backfillConcurrency, progress)
+	if res.Links > 0 && app.Exporter != nil {
+		app.Exporter.LinksStored()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("backfill failed")
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%sbackfill failed after %d messages; rerun to resume", label, res.Stored), "backfill")
+		return
+	}
+	log.Info().Int("rooms", len(rooms)).Int("events", res.Events).Int("stored", res.Stored).Int("links", res.Links).Int("undecrypted", res.Undecrypted).Msg("backfill finished")
+	body := fmt.Sprintf("backfill done: %d messages, %d links", res.Stored, res.Links)
+	if res.Undecrypted > 0 {
+		body += fmt.Sprintf(", %d couldn't be decrypted", res.Undecrypted)
+	}
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+body, "backfill")
+}
+
+// BackfillTargets returns the monitored rooms: those in MATRIX_ROOM_ID plus,
+// with ALL_JOINED_ROOMS, every joined room that isn't excluded.
+func (app *App) BackfillTargets(ctx context.Context) []id.RoomID {
+	var joined []string
+	if app.Cfg.AllJoinedRooms {
+		if resp, err := app.Client.JoinedRooms(ctx); err != nil {
+			log.Warn().Err(err).Msg("failed to list joined rooms")
+		} else {
+			for _, r := range resp.JoinedRooms {
+				joined = append(joined, string(r))
+			}
+		}
+	}
+	var rooms []id.RoomID
+	for _, r := range app.Cfg.Rooms(joined) {
+		rooms = append(rooms, id.RoomID(r.ID))
+	}
+	return rooms
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestBackfill(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := func(id string, ts time.Time, body string) string {
+		return fmt.Sprintf(`{"type":"m.room.message","event_id":"%s","sender":"@alice:example.com","origin_server_ts":%d,"content":{"msgtype":"m.text","body":"%s"}}`, id, ts.UnixMilli(), body)
+	}
+	pages := map[string]string{
+		"": `{"start":"t0","end":"t1","chunk":[` +
+			msg("$3", since.Add(48*time.Hour), "see https://example.com/a") + `,` +
+			`{"type":"m.room.member","event_id":"$m","sender":"@bob:example.com","state_key":"@bob:example.com","origin_server_ts":` + fmt.Sprint(since.Add(36*time.Hour).UnixMilli()) + `,"content":{"membership":"join"}},` +
+			msg("$2", since.Add(24*time.Hour), "hello") + `]}`,
+		"t1": `{"start":"t1","end":"t2","chunk":[` + msg("$1", since.Add(-time.Hour), "too old") + `]}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Query().Get("from")]
+		if !ok {
+			t.Errorf("unexpected page %q", r.URL.Query().Get("from"))
+			page = `{"chunk":[]}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, page)
+	}))
+	defer srv.Close()
+
+	client, err := mautrix.NewClient(srv.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	app := &App{Cfg: &config.Config{}, Client: client, MessagesDB: messagesDB}
+
+	res, err := app.Backfill(context.Background(), "!room:example.com", since)
+	if err != nil {
+		t.Fatalf("Backfill: %v", err)
+	}
+	if res.Events != 3 || res.Stored != 2 || res.Links != 1 {
+		t.Errorf("result = %+v, want 3 events, 2 stored, 1 link", res)
+	}
+	var n int
+	if err := messagesDB.QueryRow(`SELECT COUNT(*) FROM messages WHERE room_id = '!room:example.com'`).Scan(&n); err != nil || n != 2 {
+		t.Errorf("stored %d messages (err %v), want 2", n, err)
+	}
+
+	// A second run must not duplicate anything.
+	if _, err := app.Backfill(context.Background(), "!room:example.com", since); err != nil {
+		t.Fatalf("second Backfill: %v", err)
+	}
+	if err := messagesDB.QueryRow(`SELECT COUNT(*) FROM links`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("stored %d links after rerun (err %v), want 1", n, err)
+	}
+}
+
+func TestBackfillResumesFromCheckpoint(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := func(id string, ts time.Time) string {
+		return fmt.Sprintf(`{"type":"m.room.message","event_id":"%s","sender":"@alice:example.com","origin_server_ts":%d,"content":{"msgtype":"m.text","body":"hi"}}`, id, ts.UnixMilli())
+	}
+	var froms []string
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from := r.URL.Query().Get("from")
+		froms = append(froms, from)
+		w.Header().Set("Content-Type", "application/json")
+		switch from {
+		case "":
+			fmt.Fprint(w, `{"start":"t0","end":"t1","chunk":[`+msg("$2", since.Add(48*time.Hour))+`]}`)
+		case "t1":
+			if failing {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"errcode":"M_UNKNOWN","error":"boom"}`)
+				return
+			}
+			fmt.Fprint(w, `{"start":"t1","end":"t2","chunk":[`+msg("$1", since.Add(24*time.Hour))+`]}`)
+		default:
+			fmt.Fprint(w, `{"start":"t2","chunk":[]}`)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := mautrix.NewClient(srv.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	messagesDB, err := db.OpenMessages(ctx, filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	metaDB, err := db.OpenMeta(ctx, filepath.Join(t.TempDir(), "meta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metaDB.Close()
+	app := &App{Cfg: &config.Config{}, Client: client, MessagesDB: messagesDB, MetaDB: metaDB}
+
+	if _, err := app.Backfill(ctx, "!room:example.com", since); err == nil {
+		t.Fatal("Backfill succeeded despite the failing page")
+	}
+	if cp, _ := db.GetMeta(ctx, metaDB, "backfill_checkpoint:!room:example.com"); cp == "" {
+		t.Fatal("no checkpoint saved after the failure")
+	}
+
+	failing = false
+	froms = nil
+	res, err := app.Backfill(ctx, "!room:example.com", since)
+	if err != nil {
+		t.Fatalf("resumed Backfill: %v", err)
+	}
+	if len(froms) == 0 || froms[0] != "t1" || res.Stored != 1 {
+		t.Errorf("resumed run fetched %q and stored %d, want to start at t1 and store 1", froms, res.Stored)
+	}
+	if cp, _ := db.GetMeta(ctx, metaDB, "backfill_checkpoint:!room:example.com"); cp != "" {
+		t.Errorf("checkpoint %q left after finishing", cp)
+	}
+}
+
+func TestBackfillRooms(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	inFlight, peak, served := 0, 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		served++
+		n := served
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"start":"t0","chunk":[{"type":"m.room.message","event_id":"$%d","sender":"@alice:example.com","origin_server_ts":%d,"content":{"msgtype":"m.text","body":"see https://example.com"}}]}`,
+			n, since.Add(time.Hour).UnixMilli())
+	}))
+	defer srv.Close()
+
+	client, err := mautrix.NewClient(srv.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	app := &App{Cfg: &config.Config{}, Client: client, MessagesDB: messagesDB}
+
+	rooms := []id.RoomID{"!a:example.com", "!b:example.com", "!c:example.com", "!d:example.com", "!e:example.com"}
+	var reports []BackfillProgress
+	res, err := app.BackfillRooms(context.Background(), rooms, since, 2, func(p BackfillProgress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatalf("BackfillRooms: %v", err)
+	}
+	if res.Stored != len(rooms) || res.Links != len(rooms) {
+		t.Errorf("result = %+v, want %d messages and links", res, len(rooms))
+	}
+	if peak > 2 {
+		t.Errorf("%d rooms fetched at once, want at most 2", peak)
+	}
+	if len(reports) != len(rooms) || reports[len(reports)-1].Done != len(rooms) || reports[0].Total != len(rooms) {
+		t.Errorf("progress reports = %+v", reports)
+	}
+}
+
+func TestParseBackfillSince(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", now.AddDate(0, 0, -30), false},
+		{"2024-01-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2024-01-01T10:00:00Z", time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), false},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBackfillSince(tt.in, now)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("ParseBackfillSince(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
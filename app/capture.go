@@ -0,0 +1,49 @@
+package app
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/polarhive/ash/db"
+)
+
+// captureFailure stores the event behind a failed command in debug_events
+// when CAPTURE_FAILED_EVENTS is set, so it can be replayed with
+// `ash replay --captured <id>`.
+func (app *App) captureFailure(c *Command, cmdType string, cmdErr error) {
+	if !app.Cfg.CaptureFailedEvents || app.MessagesDB == nil {
+		return
+	}
+	raw, err := json.Marshal(c.Event)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to encode event for capture")
+		return
+	}
+	state, _ := json.Marshal(map[string]any{
+		"command": c.Name,
+		"type":    cmdType,
+		"args":    c.Args,
+		"room":    c.Room.Comment,
+		"sender":  c.Event.Sender,
+	})
+	days := app.Cfg.CaptureRetentionDays
+	if days <= 0 {
+		days = 7
+	}
+	err = db.StoreDebugEvent(app.MessagesDB, db.DebugEvent{
+		EventID:   string(c.Event.ID),
+		RoomID:    string(c.Event.RoomID),
+		Command:   c.Name,
+		Error:     cmdErr.Error(),
+		RawJSON:   string(raw),
+		StateJSON: string(state),
+		TSMillis:  time.Now().UnixMilli(),
+	}, int64(days)*24*int64(time.Hour/time.Millisecond))
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to capture failing event")
+		return
+	}
+	log.Info().Str("event_id", string(c.Event.ID)).Str("cmd", c.Name).Msg("captured failing event")
+}
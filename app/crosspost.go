@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/links"
+	"github.com/polarhive/ash/matrix"
+)
+
+// crosspostDedupeDays is how long a link crossposted into a links room isn't
+// posted there again, unless the room sets crosspost.dedupeDays.
+const crosspostDedupeDays = 30
+
+var hashtagRe = regexp.MustCompile(`^#[\p{L}\p{N}_][\p{L}\p{N}_-]*$`)
+
+// CrosspostTags returns tags followed by the #hashtags in body, lowercased,
+// without the # and without repeats. Room aliases like #room:server aren't
+// hashtags.
+func CrosspostTags(body string, tags []string) []string {
+	var out []string
+	add := func(t string) {
+		t = strings.ToLower(strings.TrimPrefix(t, "#"))
+		if t != "" && !slices.Contains(out, t) {
+			out = append(out, t)
+		}
+	}
+	for _, t := range tags {
+		add(t)
+	}
+	for _, f := range strings.Fields(body) {
+		f = strings.TrimLeft(strings.TrimRight(f, ".,!?;:)\"'"), "(\"'")
+		if hashtagRe.MatchString(f) {
+			add(f)
+		}
+	}
+	return out
+}
+
+// FormatCrosspost renders a crossposted link in plain text and HTML,
+// crediting sender and linking back to the message in roomName.
+func FormatCrosspost(link string, sender id.UserID, roomName, permalink string, tags []string) (body, htmlBody string) {
+	esc := html.EscapeString
+	body = fmt.Sprintf("🔗 %s\nshared by %s in %s", link, sender, roomName)
+	htmlBody = fmt.Sprintf(`🔗 <a href="%s">%s</a><br>shared by <a href="%s">%s</a> in <a href="%s">%s</a>`,
+		esc(link), esc(link), esc(matrix.UserLink(sender)), esc(string(sender)), esc(permalink), esc(roomName))
+	if len(tags) > 0 {
+		hashtags := "#" + strings.Join(tags, " #")
+		body += "\n" + hashtags
+		htmlBody += "<br>" + esc(hashtags)
+	}
+	return body, htmlBody
+}
+
+// crosspostLinks mirrors a message's accepted links into the room's links
+// room, skipping ones already posted there within the dedupe window.
+func (app *App) crosspostLinks(ctx context.Context, ev *event.Event, urls []string, body string, room config.RoomIDEntry) {
+	c := room.Crosspost
+	days := c.DedupeDays
+	if days == 0 {
+		days = crosspostDedupeDays
+	}
+	now := time.Now()
+	since := now.AddDate(0, 0, -days).UnixMilli()
+	if days < 0 {
+		since = math.MaxInt64
+	}
+	roomName := room.Comment
+	if roomName == "" {
+		roomName = room.ID
+	}
+	tags := CrosspostTags(body, c.Tags)
+	for _, u := range urls {
+		claimed, err := db.ClaimCrosspost(app.MessagesDB, c.Room, links.CanonicalURL(u), string(ev.RoomID), string(ev.ID), now.UnixMilli(), since)
+		if err != nil {
+			log.Warn().Err(err).Str("url", u).Msg("failed to record crosspost")
+			continue
+		}
+		if !claimed {
+			log.Debug().Str("url", u).Msg("skipped crossposting duplicate link")
+			continue
+		}
+		plain, rich := FormatCrosspost(u, ev.Sender, roomName, matrix.Permalink(ev.RoomID, ev.ID), tags)
+		content := event.MessageEventContent{
+			MsgType:       event.MsgNotice,
+			Body:          plain,
+			Format:        event.FormatHTML,
+			FormattedBody: rich,
+		}
+		if _, err := app.Client.SendMessageEvent(ctx, id.RoomID(c.Room), event.EventMessage, &content); err != nil {
+			log.Error().Err(err).Str("url", u).Str("links_room", c.Room).Msg("failed to crosspost link")
+			continue
+		}
+		log.Info().Str("url", u).Str("room", roomName).Str("links_room", c.Room).Msg("crossposted link")
+	}
+}
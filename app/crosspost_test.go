@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestCrosspostTags(t *testing.T) {
+	got := CrosspostTags("new #Go release, see #go and #room:example.com (#tools)", []string{"#news", "go"})
+	if want := []string{"news", "go", "tools"}; !slices.Equal(got, want) {
+		t.Errorf("CrosspostTags = %q, want %q", got, want)
+	}
+}
+
+func TestFormatCrosspost(t *testing.T) {
+	body, html := FormatCrosspost("https://example.com/?a=1&b=2", "@alice:example.com", "lounge", "https://matrix.to/#/!r/$e", []string{"go"})
+	if body != "🔗 https://example.com/?a=1&b=2\nshared by @alice:example.com in lounge\n#go" {
+		t.Errorf("body = %q", body)
+	}
+	if !strings.Contains(html, `href="https://example.com/?a=1&amp;b=2"`) || !strings.Contains(html, `<a href="https://matrix.to/#/!r/$e">lounge</a>`) {
+		t.Errorf("html = %q", html)
+	}
+}
+
+func TestCrosspostLinks(t *testing.T) {
+	var sent []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var content struct {
+			Body string `json:"body"`
+		}
+		json.NewDecoder(r.Body).Decode(&content)
+		sent = append(sent, content.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"event_id":"$crosspost"}`)
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	a := &App{Cfg: &config.Config{}, Client: client, MessagesDB: messagesDB}
+	room := config.RoomIDEntry{ID: "!room:example.com", Comment: "lounge", Crosspost: &config.CrosspostConfig{Room: "!links:example.com"}}
+
+	ev := &event.Event{ID: "$1", RoomID: "!room:example.com", Sender: "@alice:example.com"}
+	a.crosspostLinks(context.Background(), ev, []string{"https://example.com/a", "https://example.com/b"}, "", room)
+	// The same link again, with tracking parameters, is a duplicate.
+	ev = &event.Event{ID: "$2", RoomID: "!room:example.com", Sender: "@bob:example.com"}
+	a.crosspostLinks(context.Background(), ev, []string{"https://example.com/a?utm_source=x"}, "", room)
+	if len(sent) != 2 || !strings.HasPrefix(sent[0], "🔗 https://example.com/a\nshared by @alice:example.com in lounge") {
+		t.Fatalf("sent %q", sent)
+	}
+
+	room.Crosspost.DedupeDays = -1
+	a.crosspostLinks(context.Background(), ev, []string{"https://example.com/a"}, "", room)
+	if len(sent) != 3 {
+		t.Errorf("with dedupeDays -1 sent %q", sent)
+	}
+}
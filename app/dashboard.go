@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+//go:embed templates/*.html
+var dashboardFS embed.FS
+
+var dashboardTmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"date":      func(ms int64) string { return time.UnixMilli(ms).Format("2006-01-02 15:04") },
+	"localpart": func(s string) string { return id.UserID(s).Localpart() },
+}).ParseFS(dashboardFS, "templates/*.html"))
+
+// dashboardDays is how far back the dashboard's charts and rankings go.
+const dashboardDays = 30
+
+// bar is one labelled row of a dashboard bar chart.
+type bar struct {
+	Label string
+	N     int
+	Pct   int // of the chart's largest value
+}
+
+func bars(counts []db.Count, label func(string) string) []bar {
+	top := 0
+	for _, c := range counts {
+		top = max(top, c.N)
+	}
+	out := make([]bar, 0, len(counts))
+	for _, c := range counts {
+		b := bar{Label: c.Key, N: c.N}
+		if label != nil {
+			b.Label = label(c.Key)
+		}
+		if top > 0 {
+			b.Pct = c.N * 100 / top
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+type dashboardRoom struct {
+	ID      string
+	Comment string
+}
+
+type dashboardPage struct {
+	Rooms    []dashboardRoom
+	Room     *dashboardRoom
+	Days     int
+	Timezone string
+	Total    int
+	Volume   []bar
+	Yappers  []bar
+	Commands []bar
+	Links    []db.LinkRow
+}
+
+// DashboardHandler serves the read-only statistics dashboard: a room list at
+// / and each room's message volume, top yappers, command usage and recent
+// links at /room/{id}, and the same numbers for Grafana's JSON datasource
+// under /grafana/. With user set, it asks for HTTP basic auth.
+func (app *App) DashboardHandler(user, password string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", app.dashboardIndex)
+	mux.HandleFunc("GET /room/{id}", app.dashboardRoom)
+	mux.HandleFunc("GET /grafana/{$}", app.grafanaHealth)
+	mux.HandleFunc("POST /grafana/search", app.grafanaSearch)
+	mux.HandleFunc("POST /grafana/metrics", app.grafanaMetricList)
+	mux.HandleFunc("POST /grafana/query", app.grafanaQuery)
+	if user == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ash"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// RunDashboard serves DashboardHandler on addr until ctx is done.
+func (app *App) RunDashboard(ctx context.Context, d *config.DashboardConfig) error {
+	srv := &http.Server{
+		Addr:              d.Listen,
+		Handler:           app.DashboardHandler(d.User, d.Password),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	log.Info().Str("addr", d.Listen).Msg("dashboard listening")
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// dashboardRooms returns the monitored rooms, as for the admin API.
+func (app *App) dashboardRooms() ([]dashboardRoom, error) {
+	var seen []string
+	if app.Cfg.AllJoinedRooms && app.MessagesDB != nil {
+		var err error
+		if seen, err = db.LinkRoomIDs(app.MessagesDB); err != nil {
+			return nil, err
+		}
+	}
+	var rooms []dashboardRoom
+	for _, r := range app.Cfg.Rooms(seen) {
+		rooms = append(rooms, dashboardRoom{ID: r.ID, Comment: r.Comment})
+	}
+	return rooms, nil
+}
+
+// roomLocation is the time zone a room's days are counted in.
+func roomLocation(roomID string) *time.Location {
+	if tz, ok := bot.RoomTimezones[roomID]; ok {
+		return tz
+	}
+	return bot.YapTimezone
+}
+
+func (app *App) dashboardIndex(w http.ResponseWriter, _ *http.Request) {
+	rooms, err := app.dashboardRooms()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderDashboard(w, dashboardPage{Rooms: rooms, Days: dashboardDays})
+}
+
+func (app *App) dashboardRoom(w http.ResponseWriter, r *http.Request) {
+	rooms, err := app.dashboardRooms()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page := dashboardPage{Rooms: rooms, Days: dashboardDays}
+	roomID := r.PathValue("id")
+	for i := range rooms {
+		if rooms[i].ID == roomID {
+			page.Room = &rooms[i]
+		}
+	}
+	if page.Room == nil || app.MessagesDB == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	loc := roomLocation(roomID)
+	now := time.Now().In(loc)
+	_, offset := now.Zone()
+	page.Timezone = loc.String()
+	since := time.Date(now.Year(), now.Month(), now.Day()-dashboardDays+1, 0, 0, 0, 0, loc).UnixMilli()
+
+	volume, err := db.DailyMessageCounts(app.MessagesDB, roomID, since, offset/60)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, c := range volume {
+		page.Total += c.N
+	}
+	page.Volume = bars(volume, nil)
+	yappers, err := db.TopSenders(app.MessagesDB, roomID, since, 10)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page.Yappers = bars(yappers, func(s string) string { return id.UserID(s).Localpart() })
+	commands, err := db.CommandCounts(app.MessagesDB, roomID, since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page.Commands = bars(commands, nil)
+	if page.Links, err = db.RecentLinks(app.MessagesDB, roomID, 20); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.renderDashboard(w, page)
+}
+
+func (app *App) renderDashboard(w http.ResponseWriter, page dashboardPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTmpl.ExecuteTemplate(w, "dashboard.html", page); err != nil {
+		log.Warn().Err(err).Msg("render dashboard")
+	}
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestDashboard(t *testing.T) {
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	now := time.Now().UnixMilli()
+	for i, m := range []struct{ sender, body string }{
+		{"@alice:example.com", "hello"},
+		{"@alice:example.com", "look https://example.com/a"},
+		{"@bob:example.com", "/bot yap hours"},
+		{"@bob:example.com", "/bot yap"},
+		{"@bob:example.com", "/bot top reacted"},
+	} {
+		id := "$m" + string(rune('a'+i))
+		if _, err := messagesDB.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, ?, ?, ?, ?, 'm.text')`,
+			id, "!room:example.com", m.sender, now-int64(i), m.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := messagesDB.Exec(`INSERT INTO links(message_id, url, idx, title, ts_ms) VALUES ('$mb', 'https://example.com/a', 0, 'Example <A>', ?)`, now); err != nil {
+		t.Fatal(err)
+	}
+	a := &App{
+		Cfg:        &config.Config{RoomIDs: []config.RoomIDEntry{{ID: "!room:example.com", Comment: "lounge"}}},
+		MessagesDB: messagesDB,
+	}
+	srv := httptest.NewServer(a.DashboardHandler("admin", "hunter2"))
+	defer srv.Close()
+
+	get := func(path string, auth bool) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		if auth {
+			req.SetBasicAuth("admin", "hunter2")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get("/", false); code != http.StatusUnauthorized {
+		t.Errorf("no auth: status %d", code)
+	}
+	if code, body := get("/", true); code != http.StatusOK || !strings.Contains(body, "lounge") {
+		t.Errorf("index: %d %s", code, body)
+	}
+	if code, _ := get("/room/!other:example.com", true); code != http.StatusNotFound {
+		t.Errorf("unmonitored room: status %d", code)
+	}
+	code, body := get("/room/!room:example.com", true)
+	if code != http.StatusOK {
+		t.Fatalf("room: status %d", code)
+	}
+	for _, want := range []string{
+		"5 messages in the past 30 days",
+		`<td class="label">alice</td>`,
+		`<td class="label">/bot yap</td><td><div class="bar" style="width: 100%"></div></td><td class="n">2</td>`,
+		`/bot top`,
+		`Example &lt;A&gt;`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("room page missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `<td class="label">bob</td>`) {
+		t.Errorf("bot commands counted as yaps:\n%s", body)
+	}
+}
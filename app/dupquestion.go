@@ -0,0 +1,116 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
+)
+
+// dupQuestionMinTerms is how many meaningful words a question needs before
+// it is compared; shorter ones ("any updates?") match too much.
+const dupQuestionMinTerms = 2
+
+// questionStopwords are left out when comparing questions.
+var questionStopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "you": true, "are": true, "was": true,
+	"what": true, "how": true, "why": true, "who": true, "when": true, "where": true,
+	"which": true, "does": true, "did": true, "can": true, "could": true, "would": true,
+	"should": true, "any": true, "anyone": true, "there": true, "this": true, "that": true,
+	"with": true, "have": true, "has": true, "get": true, "not": true, "but": true,
+	"here": true, "know": true, "someone": true, "please": true, "from": true, "into": true,
+	"about": true, "your": true, "our": true, "its": true, "it's": true, "i'm": true,
+}
+
+// IsQuestion reports whether a message looks like a question worth matching.
+func IsQuestion(body string) bool {
+	return strings.Contains(body, "?") && !strings.HasPrefix(body, "/bot") && len(QuestionTerms(body)) >= dupQuestionMinTerms
+}
+
+// QuestionTerms returns the distinct meaningful words of a question: lower
+// case, at least three letters, stopwords and links left out.
+func QuestionTerms(body string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, w := range strings.Fields(strings.ToLower(body)) {
+		if strings.Contains(w, "://") {
+			continue
+		}
+		w = strings.TrimFunc(w, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if len([]rune(w)) < 3 || questionStopwords[w] {
+			continue
+		}
+		terms[w] = true
+	}
+	return terms
+}
+
+// QuestionSimilarity is the cosine similarity of two questions' term sets,
+// from 0 (nothing in common) to 1 (the same words).
+func QuestionSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range a {
+		if b[t] {
+			shared++
+		}
+	}
+	return float64(shared) / math.Sqrt(float64(len(a)*len(b)))
+}
+
+// findDuplicateQuestion returns the most similar answered question in the
+// room at or above threshold, or nil.
+func (app *App) findDuplicateQuestion(roomID id.RoomID, eventID id.EventID, body string, cfg *config.DupQuestionsConfig, now time.Time) (*db.AnsweredQuestion, error) {
+	threshold, days := 0.6, 90
+	if cfg.Threshold > 0 {
+		threshold = cfg.Threshold
+	}
+	if cfg.Days > 0 {
+		days = cfg.Days
+	}
+	since := now.AddDate(0, 0, -days).UnixMilli()
+	candidates, err := db.AnsweredQuestions(app.MessagesDB, string(roomID), since, 2000)
+	if err != nil {
+		return nil, err
+	}
+	terms := QuestionTerms(body)
+	var best *db.AnsweredQuestion
+	bestScore := threshold
+	for i, q := range candidates {
+		if q.ID == string(eventID) {
+			continue
+		}
+		if score := QuestionSimilarity(terms, QuestionTerms(q.Body)); score >= bestScore {
+			best, bestScore = &candidates[i], score
+		}
+	}
+	return best, nil
+}
+
+// checkDuplicateQuestion replies to a question that closely matches an
+// earlier answered one with links to both.
+func (app *App) checkDuplicateQuestion(ctx context.Context, ev *event.Event, body string, room config.RoomIDEntry) {
+	match, err := app.findDuplicateQuestion(ev.RoomID, ev.ID, body, room.DupQuestions, time.Now())
+	if err != nil {
+		log.Warn().Err(err).Str("room", room.Comment).Msg("failed to look for duplicate questions")
+		return
+	}
+	if match == nil {
+		return
+	}
+	log.Info().Str("room", room.Comment).Str("event_id", string(ev.ID)).Str("earlier", match.ID).Msg("duplicate question")
+	label := ResolveReplyLabel(app.Cfg, app.botConfig())
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%sthis was asked before (%s), answered here: %s",
+		label, time.UnixMilli(match.TSMillis).Format("2006-01-02"), matrix.Permalink(ev.RoomID, id.EventID(match.AnswerID))), "duplicate")
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestQuestionSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		min  float64
+		max  float64
+	}{
+		{"How do I reset my password?", "how do i reset my password??", 1, 1},
+		{"How do I reset my wiki password?", "anyone know how to reset the password on the wiki?", 0.6, 1},
+		{"How do I reset my password?", "where is the meetup this week?", 0, 0},
+	}
+	for _, tt := range tests {
+		got := QuestionSimilarity(QuestionTerms(tt.a), QuestionTerms(tt.b))
+		if got < tt.min || got > tt.max {
+			t.Errorf("similarity(%q, %q) = %.2f, want %.2f..%.2f", tt.a, tt.b, got, tt.min, tt.max)
+		}
+	}
+	if IsQuestion("any updates?") {
+		t.Error("short question should not be matched")
+	}
+	if !IsQuestion("How do I reset my password?") {
+		t.Error("question not recognized")
+	}
+	if IsQuestion("/bot what is the wiki password?") {
+		t.Error("commands are not questions")
+	}
+}
+
+func TestFindDuplicateQuestion(t *testing.T) {
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	now := time.Now()
+	for _, m := range []struct{ id, sender, body, raw string }{
+		{"$q1", "@alice:example.com", "how do I reset my wiki password?", `{}`},
+		{"$a1", "@bob:example.com", "the admin panel, under account", `{"m.relates_to":{"m.in_reply_to":{"event_id":"$q1"}}}`},
+		{"$q2", "@carol:example.com", "where does the wiki password reset live?", `{}`},
+	} {
+		if _, err := messagesDB.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype, raw_json) VALUES (?, '!room:example.com', ?, ?, ?, 'm.text', ?)`,
+			m.id, m.sender, now.UnixMilli(), m.body, m.raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &App{Cfg: &config.Config{}, MessagesDB: messagesDB}
+	cfg := &config.DupQuestionsConfig{Threshold: 0.6}
+
+	match, err := a.findDuplicateQuestion("!room:example.com", "$new", "how can I reset the wiki password?", cfg, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match == nil || match.ID != "$q1" || match.AnswerID != "$a1" {
+		t.Fatalf("match = %+v, want $q1 answered by $a1", match)
+	}
+	// The unanswered $q2 is never suggested, and unrelated questions don't match.
+	if match, _ := a.findDuplicateQuestion("!room:example.com", "$new", "what time is the meetup tomorrow?", cfg, now); match != nil {
+		t.Errorf("unrelated question matched %+v", match)
+	}
+}
@@ -0,0 +1,413 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/matrix"
+)
+
+// emailMaxBodyRunes caps the text of a mail posted into a room.
+const emailMaxBodyRunes = 4000
+
+// emailMaxRecipients caps RCPT commands per message.
+const emailMaxRecipients = 100
+
+// emailIdleTimeout drops SMTP clients that go quiet.
+const emailIdleTimeout = 5 * time.Minute
+
+// errNoEmailRule refuses mail that no EMAIL rule matches.
+var errNoEmailRule = errors.New("no rule for this mail")
+
+// Email is a received message, decoded for posting.
+type Email struct {
+	From        string // address in the From header
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []EmailAttachment
+}
+
+// EmailAttachment is a non-text part of a mail.
+type EmailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+var wordDecoder = new(mime.WordDecoder)
+
+// decodeWords decodes RFC 2047 encoded words, keeping s if it's malformed.
+func decodeWords(s string) string {
+	if d, err := wordDecoder.DecodeHeader(s); err == nil {
+		return d
+	}
+	return s
+}
+
+// ParseEmail decodes a raw RFC 5322 message: the sender's address, the
+// subject, the first plain text and HTML bodies, and every other part as an
+// attachment. Transfer encodings are undone; charsets are not converted.
+func ParseEmail(data []byte) (*Email, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	from := msg.Header.Get("From")
+	if addr, err := mail.ParseAddress(from); err == nil {
+		from = addr.Address
+	}
+	e := &Email{From: from, Subject: decodeWords(msg.Header.Get("Subject"))}
+	if err := e.addPart(textproto.MIMEHeader(msg.Header), msg.Body, 0); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// addPart adds one MIME part, walking into multiparts up to a few levels.
+func (e *Email) addPart(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	if strings.HasPrefix(mediaType, "multipart/") && depth < 5 {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := e.addPart(p.Header, p, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	name = decodeWords(name)
+	inline := disposition != "attachment" && name == ""
+	switch {
+	case inline && mediaType == "text/plain" && e.Text == "":
+		e.Text = string(data)
+	case inline && mediaType == "text/html" && e.HTML == "":
+		e.HTML = string(data)
+	case inline && strings.HasPrefix(mediaType, "text/"):
+		// An alternative body we already have in another form.
+	case len(data) > 0:
+		if name == "" {
+			name = "attachment"
+		}
+		e.Attachments = append(e.Attachments, EmailAttachment{Name: name, ContentType: mediaType, Data: data})
+	}
+	return nil
+}
+
+var (
+	htmlDropRe  = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)>`)
+	htmlBreakRe = regexp.MustCompile(`(?i)<(br|/p|/div|/tr|/h[1-6]|/li)\b[^>]*>`)
+	htmlTagRe   = regexp.MustCompile(`<[^>]*>`)
+	blankRunRe  = regexp.MustCompile(`\n\s*\n\s*\n+`)
+)
+
+// htmlToText roughly renders an HTML mail body as plain text.
+func htmlToText(s string) string {
+	s = htmlDropRe.ReplaceAllString(s, "")
+	s = htmlBreakRe.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(htmlTagRe.ReplaceAllString(s, ""))
+	return strings.TrimSpace(blankRunRe.ReplaceAllString(s, "\n\n"))
+}
+
+// BodyText is the mail's plain text body, or its HTML body as text.
+func (e *Email) BodyText() string {
+	text := strings.TrimSpace(strings.ReplaceAll(e.Text, "\r\n", "\n"))
+	if text == "" && e.HTML != "" {
+		text = htmlToText(strings.ReplaceAll(e.HTML, "\r\n", "\n"))
+	}
+	if r := []rune(text); len(r) > emailMaxBodyRunes {
+		text = string(r[:emailMaxBodyRunes]) + "…"
+	}
+	return text
+}
+
+// emailRule is a config.EmailRule with its patterns compiled.
+type emailRule struct {
+	to, from, subject *regexp.Regexp
+	room              id.RoomID
+}
+
+func compileEmailRules(rules []config.EmailRule) ([]emailRule, error) {
+	compile := func(p string) (*regexp.Regexp, error) {
+		if p == "" {
+			return nil, nil
+		}
+		return regexp.Compile("(?i)" + p)
+	}
+	out := make([]emailRule, 0, len(rules))
+	for i, r := range rules {
+		to, err1 := compile(r.To)
+		from, err2 := compile(r.From)
+		subject, err3 := compile(r.Subject)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		out = append(out, emailRule{to: to, from: from, subject: subject, room: id.RoomID(r.Room)})
+	}
+	return out, nil
+}
+
+// matchEmailRule returns the room of the first rule that matches one of the
+// envelope recipients and the mail's sender and subject.
+func matchEmailRule(rules []emailRule, rcpts []string, from, subject string) (id.RoomID, bool) {
+	for _, r := range rules {
+		if r.from != nil && !r.from.MatchString(from) {
+			continue
+		}
+		if r.subject != nil && !r.subject.MatchString(subject) {
+			continue
+		}
+		if r.to != nil && !slices.ContainsFunc(rcpts, r.to.MatchString) {
+			continue
+		}
+		return r.room, true
+	}
+	return "", false
+}
+
+// smtpPath returns the address of a "FROM:<addr>" or "TO:<addr>" argument,
+// ignoring any ESMTP parameters after it.
+func smtpPath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(rest, '>')
+	if end < 0 {
+		return "", false
+	}
+	return rest[1:end], true
+}
+
+// serveSMTP speaks just enough SMTP (RFC 5321, no TLS or AUTH) to take mail
+// from a relay or a local system, handing each message to deliver. A
+// deliver error wrapping errNoEmailRule refuses the mail for good; any other
+// asks the client to retry later.
+func serveSMTP(conn net.Conn, hostname string, maxBytes int64, deliver func(from string, rcpts []string, data []byte) error) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	reply := func(code int, msg string) {
+		tp.PrintfLine("%d %s", code, msg)
+	}
+	var (
+		inMail bool
+		from   string
+		rcpts  []string
+	)
+	reset := func() { inMail, from, rcpts = false, "", nil }
+
+	reply(220, hostname+" ESMTP ash")
+	for {
+		conn.SetDeadline(time.Now().Add(emailIdleTimeout))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reset()
+			reply(250, hostname)
+		case "EHLO":
+			reset()
+			tp.PrintfLine("250-%s", hostname)
+			tp.PrintfLine("250-SIZE %d", maxBytes)
+			tp.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			addr, ok := smtpPath(arg, "FROM:")
+			if !ok {
+				reply(501, "syntax: MAIL FROM:<address>")
+				continue
+			}
+			reset()
+			inMail, from = true, addr
+			reply(250, "OK")
+		case "RCPT":
+			addr, ok := smtpPath(arg, "TO:")
+			switch {
+			case !inMail:
+				reply(503, "MAIL first")
+			case !ok || addr == "":
+				reply(501, "syntax: RCPT TO:<address>")
+			case len(rcpts) >= emailMaxRecipients:
+				reply(452, "too many recipients")
+			default:
+				rcpts = append(rcpts, addr)
+				reply(250, "OK")
+			}
+		case "DATA":
+			if len(rcpts) == 0 {
+				reply(503, "RCPT first")
+				continue
+			}
+			reply(354, "end data with <CR><LF>.<CR><LF>")
+			dr := tp.DotReader()
+			data, err := io.ReadAll(io.LimitReader(dr, maxBytes+1))
+			if err == nil {
+				_, err = io.Copy(io.Discard, dr)
+			}
+			if err != nil {
+				return
+			}
+			if int64(len(data)) > maxBytes {
+				reply(552, "message too large")
+			} else if err := deliver(from, rcpts, data); errors.Is(err, errNoEmailRule) {
+				reply(550, err.Error())
+			} else if err != nil {
+				reply(451, "delivery failed, try again later")
+			} else {
+				reply(250, "OK")
+			}
+			reset()
+		case "RSET":
+			reset()
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "command not implemented")
+		}
+	}
+}
+
+// RunEmail accepts mail on EMAIL.listen until ctx is cancelled.
+func (app *App) RunEmail(ctx context.Context, c *config.EmailConfig) error {
+	rules, err := compileEmailRules(c.Rules)
+	if err != nil {
+		return err
+	}
+	hostname := c.Hostname
+	if hostname == "" {
+		hostname = "ash"
+	}
+	maxBytes := int64(25) << 20
+	if c.MaxSizeMB > 0 {
+		maxBytes = int64(c.MaxSizeMB) << 20
+	}
+	ln, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	log.Info().Str("addr", c.Listen).Int("rules", len(rules)).Msg("email listening")
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go serveSMTP(conn, hostname, maxBytes, func(from string, rcpts []string, data []byte) error {
+			return app.deliverEmail(ctx, rules, from, rcpts, data)
+		})
+	}
+}
+
+// deliverEmail posts a received mail into its rule's room as a notice, then
+// uploads its attachments as replies to it.
+func (app *App) deliverEmail(ctx context.Context, rules []emailRule, envelopeFrom string, rcpts []string, data []byte) error {
+	e, err := ParseEmail(data)
+	if err != nil {
+		return fmt.Errorf("%w: unreadable message", errNoEmailRule)
+	}
+	if e.From == "" {
+		e.From = envelopeFrom
+	}
+	room, ok := matchEmailRule(rules, rcpts, e.From, e.Subject)
+	if !ok {
+		log.Info().Str("from", e.From).Strs("to", rcpts).Str("subject", e.Subject).Msg("refused email: no rule matches")
+		return errNoEmailRule
+	}
+
+	esc := html.EscapeString
+	subject := e.Subject
+	if subject == "" {
+		subject = "(no subject)"
+	}
+	text := e.BodyText()
+	content := event.MessageEventContent{
+		MsgType:       event.MsgNotice,
+		Body:          fmt.Sprintf("📧 %s\nfrom %s", subject, e.From),
+		Format:        event.FormatHTML,
+		FormattedBody: fmt.Sprintf("📧 <b>%s</b><br>from %s", esc(subject), esc(e.From)),
+	}
+	if text != "" {
+		content.Body += "\n\n" + text
+		content.FormattedBody += "<br><br>" + strings.ReplaceAll(esc(text), "\n", "<br>")
+	}
+	resp, err := app.Client.SendMessageEvent(ctx, room, event.EventMessage, &content)
+	if err != nil {
+		log.Error().Err(err).Str("room", string(room)).Msg("failed to post email")
+		return err
+	}
+	for _, a := range e.Attachments {
+		var err error
+		// Whether an attachment is posted as an image goes by its content,
+		// not the type the mail declared.
+		if _, _, sniffErr := matrix.SniffImage(a.Data); sniffErr == nil {
+			err = matrix.SendImageToMatrix(ctx, app.Client, room, resp.EventID, a.Data, a.Name, "")
+		} else {
+			err = matrix.SendDataToMatrix(ctx, app.Client, room, resp.EventID, a.Data, a.ContentType, event.MsgFile, a.Name)
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("file", a.Name).Msg("failed to upload email attachment")
+		}
+	}
+	log.Info().Str("room", string(room)).Str("from", e.From).Int("attachments", len(e.Attachments)).Msg("posted email")
+	return nil
+}
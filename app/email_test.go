@@ -0,0 +1,153 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/polarhive/ash/config"
+)
+
+const testEmail = "From: \"Grafana\" <alerts@grafana.example.com>\r\n" +
+	"To: ops@example.com\r\n" +
+	"Subject: =?UTF-8?Q?Disk_full_=E2=80=94_db1?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"/var is at 97% on db1, please =\r\n" +
+	"clean up.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>/var is at 97%</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png; name=\"graph.png\"\r\n" +
+	"Content-Disposition: attachment; filename=\"graph.png\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0K\r\n" +
+	"GgoAAAA=\r\n" +
+	"--outer--\r\n"
+
+func TestParseEmail(t *testing.T) {
+	e, err := ParseEmail([]byte(testEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.From != "alerts@grafana.example.com" || e.Subject != "Disk full — db1" {
+		t.Errorf("from %q, subject %q", e.From, e.Subject)
+	}
+	if got := e.BodyText(); got != "/var is at 97% on db1, please clean up." {
+		t.Errorf("BodyText = %q", got)
+	}
+	if len(e.Attachments) != 1 {
+		t.Fatalf("attachments = %+v", e.Attachments)
+	}
+	a := e.Attachments[0]
+	if a.Name != "graph.png" || a.ContentType != "image/png" || string(a.Data) != "\x89PNG\r\n\x1a\n\x00\x00\x00" {
+		t.Errorf("attachment = %q %q %q", a.Name, a.ContentType, a.Data)
+	}
+
+	htmlOnly := "From: x@example.com\r\nSubject: hi\r\nContent-Type: text/html\r\n\r\n<html><head><style>p{}</style></head><body><p>one &amp; two</p><p>three</p></body></html>"
+	e, err = ParseEmail([]byte(htmlOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.BodyText(); got != "one & two\nthree" {
+		t.Errorf("html BodyText = %q", got)
+	}
+}
+
+func TestMatchEmailRule(t *testing.T) {
+	rules, err := compileEmailRules([]config.EmailRule{
+		{From: `@grafana\.example\.com$`, Subject: `^disk`, Room: "!disk:example.com"},
+		{To: `^ops@`, Room: "!ops:example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rcpts         []string
+		from, subject string
+		want          string
+	}{
+		{[]string{"ops@example.com"}, "alerts@grafana.example.com", "Disk full", "!disk:example.com"},
+		{[]string{"root@example.com", "ops@example.com"}, "cron@example.com", "backup done", "!ops:example.com"},
+		{[]string{"root@example.com"}, "alerts@grafana.example.com", "CPU high", ""},
+	}
+	for _, tt := range tests {
+		room, ok := matchEmailRule(rules, tt.rcpts, tt.from, tt.subject)
+		if string(room) != tt.want || ok != (tt.want != "") {
+			t.Errorf("matchEmailRule(%v, %q, %q) = %q, %v", tt.rcpts, tt.from, tt.subject, room, ok)
+		}
+	}
+}
+
+func TestServeSMTP(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	var got []string
+	go serveSMTP(server, "mx.example.com", 64, func(from string, rcpts []string, data []byte) error {
+		if strings.Contains(string(data), "nobody") {
+			return errNoEmailRule
+		}
+		got = append(got, fmt.Sprintf("%s>%s:%s", from, strings.Join(rcpts, ","), data))
+		return nil
+	})
+
+	r := bufio.NewReader(client)
+	// Each step sends a line and expects the reply code of the last line of
+	// the reply.
+	steps := []struct{ send, want string }{
+		{"", "220"},
+		{"EHLO client", "250"},
+		{"RCPT TO:<ops@example.com>", "503"},
+		{"MAIL FROM:<alerts@example.com> SIZE=20", "250"},
+		{"DATA", "503"},
+		{"RCPT TO:<ops@example.com>", "250"},
+		{"DATA", "354"},
+		{"Subject: hi\r\n\r\n..dotted\r\n.", "250"},
+		{"MAIL FROM:<>", "250"},
+		{"RCPT TO:<nobody@example.com>", "250"},
+		{"DATA", "354"},
+		{"To: nobody\r\n.", "550"},
+		{"MAIL FROM:<a@example.com>", "250"},
+		{"RCPT TO:<ops@example.com>", "250"},
+		{"DATA", "354"},
+		{strings.Repeat("x", 100) + "\r\n.", "552"},
+		{"QUIT", "221"},
+	}
+	for _, s := range steps {
+		if s.send != "" {
+			fmt.Fprintf(client, "%s\r\n", s.send)
+		}
+		var line string
+		for {
+			l, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("after %q: %v", s.send, err)
+			}
+			if len(l) < 4 || l[3] != '-' {
+				line = l
+				break
+			}
+		}
+		if !strings.HasPrefix(line, s.want) {
+			t.Fatalf("after %q got %q, want %s", s.send, line, s.want)
+		}
+	}
+	want := "alerts@example.com>ops@example.com:Subject: hi\n\n.dotted\n"
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/links"
+	"github.com/polarhive/ash/util"
+)
+
+// enrichBatch is how many pending links each pass looks at.
+const enrichBatch = 200
+
+// Enricher fills in the title, status code and content type of stored links
+// in the background, fetching at most one page per domain every perDomain.
+type Enricher struct {
+	db        *sql.DB
+	perDomain time.Duration
+	fetch     func(ctx context.Context, link string) (links.Metadata, error)
+	onUpdate  func()
+
+	last map[string]time.Time
+}
+
+// NewEnricher creates an Enricher. onUpdate, if set, is called after a pass
+// that stored metadata, so exports can pick up the new titles. A zero
+// perDomain falls back to 10 seconds. Links are anything room members post,
+// so only public addresses are fetched.
+func NewEnricher(database *sql.DB, perDomain time.Duration, onUpdate func()) *Enricher {
+	if perDomain <= 0 {
+		perDomain = 10 * time.Second
+	}
+	return &Enricher{
+		db:        database,
+		perDomain: perDomain,
+		fetch: func(ctx context.Context, link string) (links.Metadata, error) {
+			ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+			defer cancel()
+			return links.FetchMetadata(ctx, util.PublicHTTPClient, link)
+		},
+		onUpdate: onUpdate,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// RunOnce enriches pending links whose domain isn't rate limited and returns
+// how many were updated. Links that fail to fetch are stored with status 0
+// so they aren't retried forever.
+func (e *Enricher) RunOnce(ctx context.Context) (int, error) {
+	pending, err := db.PendingLinks(e.db, enrichBatch)
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, l := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		host := ""
+		if u, err := url.Parse(l.URL); err == nil {
+			host = strings.ToLower(u.Hostname())
+		}
+		now := time.Now()
+		if host != "" {
+			if last, ok := e.last[host]; ok && now.Sub(last) < e.perDomain {
+				continue
+			}
+			e.last[host] = now
+		}
+		var meta links.Metadata
+		if host != "" {
+			meta, err = e.fetch(ctx, l.URL)
+			switch {
+			case errors.Is(err, util.ErrPrivateAddress):
+				log.Debug().Str("url", l.URL).Msg("skipped enriching link to a non-public address")
+				meta = links.Metadata{}
+			case err != nil:
+				log.Debug().Err(err).Str("url", l.URL).Msg("link enrichment failed")
+			}
+		}
+		if err := db.SetLinkMetadata(e.db, l, meta.Title, meta.StatusCode, meta.ContentType, time.Now().UnixMilli()); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	if updated > 0 && e.onUpdate != nil {
+		e.onUpdate()
+	}
+	return updated, nil
+}
+
+// Run enriches links every perDomain until ctx is cancelled.
+func (e *Enricher) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.perDomain)
+	defer ticker.Stop()
+	for {
+		if n, err := e.RunOnce(ctx); err != nil {
+			log.Error().Err(err).Msg("link enrichment")
+		} else if n > 0 {
+			log.Info().Int("count", n).Msg("enriched links")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
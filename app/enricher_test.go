@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/links"
+)
+
+func TestEnricherRateLimitsPerDomain(t *testing.T) {
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	for i, u := range []string{"https://a.example/1", "https://a.example/2", "https://b.example/", "https://down.example/"} {
+		if _, err := messagesDB.Exec(`INSERT INTO links(message_id, url, idx, ts_ms) VALUES (?, ?, 0, ?)`, "$m", u, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updates := 0
+	e := NewEnricher(messagesDB, time.Hour, func() { updates++ })
+	var fetched []string
+	e.fetch = func(_ context.Context, link string) (links.Metadata, error) {
+		fetched = append(fetched, link)
+		if link == "https://down.example/" {
+			return links.Metadata{}, errors.New("connection refused")
+		}
+		return links.Metadata{StatusCode: 200, ContentType: "text/html", Title: "Title of " + link}, nil
+	}
+
+	n, err := e.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if n != 3 || len(fetched) != 3 || updates != 1 {
+		t.Errorf("first pass: updated %d, fetched %v, %d updates; want 3, one per domain, 1", n, fetched, updates)
+	}
+	var title string
+	var status int
+	if err := messagesDB.QueryRow(`SELECT title, status_code FROM links WHERE url = 'https://b.example/'`).Scan(&title, &status); err != nil {
+		t.Fatal(err)
+	}
+	if title != "Title of https://b.example/" || status != 200 {
+		t.Errorf("b.example = %q %d", title, status)
+	}
+
+	// The second a.example link waits for the domain delay; the failed
+	// link isn't retried.
+	if n, _ := e.RunOnce(context.Background()); n != 0 {
+		t.Errorf("second pass updated %d, want 0 while rate limited", n)
+	}
+	e.last = make(map[string]time.Time)
+	if n, _ := e.RunOnce(context.Background()); n != 1 {
+		t.Errorf("third pass updated %d, want 1", n)
+	}
+}
+
+func TestEnricherSkipsPrivateAddresses(t *testing.T) {
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	fetched := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetched = true
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<title>internal admin</title>")
+	}))
+	defer srv.Close()
+	if _, err := messagesDB.Exec(`INSERT INTO links(message_id, url, idx, ts_ms) VALUES ('$m', ?, 0, 1)`, srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEnricher(messagesDB, time.Hour, nil)
+	if n, err := e.RunOnce(context.Background()); err != nil || n != 1 {
+		t.Fatalf("RunOnce = %d, %v", n, err)
+	}
+	var title string
+	var status int
+	if err := messagesDB.QueryRow(`SELECT COALESCE(title, ''), status_code FROM links`).Scan(&title, &status); err != nil {
+		t.Fatal(err)
+	}
+	if fetched || title != "" || status != 0 {
+		t.Errorf("loopback link fetched: %v, title %q, status %d", fetched, title, status)
+	}
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Export modes for EXPORT_MODE.
+const (
+	ExportPerMessage = "per-message" // export after every message with links
+	ExportDebounce   = "debounce"    // export at most once every EXPORT_DEBOUNCE_SECONDS (default)
+	ExportSchedule   = "schedule"    // export every EXPORT_INTERVAL_MINUTES if links changed
+	ExportOnDemand   = "on-demand"   // export only via /bot export or `ash export`
+	ExportOnShutdown = "shutdown"    // export once when the bot stops
+)
+
+// Exporter decides when link snapshots are written. Exports run in the
+// background, one at a time, never on the message handling path.
+type Exporter struct {
+	mode     string
+	debounce time.Duration
+	interval time.Duration
+	export   func() error
+
+	mu        sync.Mutex
+	dirty     bool
+	timer     *time.Timer
+	exporting sync.Mutex // held while an export runs
+}
+
+// NewExporter creates an Exporter. Zero durations fall back to 30 seconds
+// for debounce and an hour for schedule.
+func NewExporter(mode string, debounce, interval time.Duration, export func() error) *Exporter {
+	if mode == "" {
+		mode = ExportDebounce
+	}
+	if debounce <= 0 {
+		debounce = 30 * time.Second
+	}
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return &Exporter{mode: mode, debounce: debounce, interval: interval, export: export}
+}
+
+// LinksStored notes that new links were stored and exports according to
+// mode. It never waits for the export.
+func (e *Exporter) LinksStored() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dirty = true
+	switch e.mode {
+	case ExportPerMessage:
+		go e.Flush()
+	case ExportDebounce:
+		// The timer isn't reset by later links, so a steady stream of them
+		// still gets exported every debounce interval.
+		if e.timer == nil {
+			e.timer = time.AfterFunc(e.debounce, func() {
+				e.mu.Lock()
+				e.timer = nil
+				e.mu.Unlock()
+				e.Flush()
+			})
+		}
+	}
+}
+
+// Flush exports immediately if anything changed since the last export.
+// Changes made while an export runs, or left by one that failed, are picked
+// up by the next one.
+func (e *Exporter) Flush() error {
+	e.exporting.Lock()
+	defer e.exporting.Unlock()
+	e.mu.Lock()
+	if !e.dirty {
+		e.mu.Unlock()
+		return nil
+	}
+	e.dirty = false
+	e.mu.Unlock()
+	return e.exportNow()
+}
+
+// ExportNow exports regardless of whether links changed.
+func (e *Exporter) ExportNow() error {
+	e.exporting.Lock()
+	defer e.exporting.Unlock()
+	e.mu.Lock()
+	e.dirty = false
+	e.mu.Unlock()
+	return e.exportNow()
+}
+
+func (e *Exporter) exportNow() error {
+	if err := e.export(); err != nil {
+		log.Error().Err(err).Msg("export snapshots")
+		e.mu.Lock()
+		e.dirty = true
+		e.mu.Unlock()
+		return err
+	}
+	log.Info().Str("mode", e.mode).Msg("exported link snapshot")
+	return nil
+}
+
+// Run drives scheduled exports until ctx is cancelled.
+func (e *Exporter) Run(ctx context.Context) {
+	if e.mode != ExportSchedule {
+		return
+	}
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Flush()
+		}
+	}
+}
+
+// Shutdown writes pending changes when the bot stops. Debounced and
+// scheduled exports are flushed too so no links are lost.
+func (e *Exporter) Shutdown() {
+	e.mu.Lock()
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	e.mu.Unlock()
+	if e.mode != ExportOnDemand {
+		e.Flush()
+	}
+}
@@ -0,0 +1,208 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestExporterModes(t *testing.T) {
+	var n atomic.Int32
+	count := func() error { n.Add(1); return nil }
+
+	if e := NewExporter("", 0, 0, count); e.mode != ExportDebounce {
+		t.Errorf("default mode = %q", e.mode)
+	}
+
+	e := NewExporter(ExportPerMessage, 0, 0, count)
+	e.LinksStored()
+	time.Sleep(20 * time.Millisecond)
+	e.LinksStored()
+	time.Sleep(20 * time.Millisecond)
+	if got := n.Load(); got != 2 {
+		t.Errorf("per-message: %d exports, want 2", got)
+	}
+
+	n.Store(0)
+	e = NewExporter(ExportDebounce, 20*time.Millisecond, 0, count)
+	for range 5 {
+		e.LinksStored()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := n.Load(); got != 1 {
+		t.Errorf("debounce: %d exports, want 1", got)
+	}
+
+	n.Store(0)
+	e = NewExporter(ExportDebounce, 30*time.Millisecond, 0, count)
+	for range 30 {
+		e.LinksStored()
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := n.Load(); got < 2 {
+		t.Errorf("debounce with a steady stream: %d exports, want at least 2", got)
+	}
+	e.Shutdown()
+
+	n.Store(0)
+	e = NewExporter(ExportSchedule, 0, 20*time.Millisecond, count)
+	ctx, cancel := context.WithCancel(context.Background())
+	go e.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+	if got := n.Load(); got != 0 {
+		t.Errorf("schedule without changes: %d exports, want 0", got)
+	}
+	e.LinksStored()
+	time.Sleep(60 * time.Millisecond)
+	cancel()
+	if got := n.Load(); got != 1 {
+		t.Errorf("schedule: %d exports, want 1", got)
+	}
+
+	n.Store(0)
+	e = NewExporter(ExportOnDemand, 0, 0, count)
+	e.LinksStored()
+	e.Shutdown()
+	if got := n.Load(); got != 0 {
+		t.Errorf("on-demand: %d exports before request, want 0", got)
+	}
+	e.ExportNow()
+	if got := n.Load(); got != 1 {
+		t.Errorf("on-demand: %d exports after request, want 1", got)
+	}
+
+	n.Store(0)
+	e = NewExporter(ExportOnShutdown, 0, 0, count)
+	e.LinksStored()
+	e.LinksStored()
+	e.Shutdown()
+	if got := n.Load(); got != 1 {
+		t.Errorf("shutdown: %d exports, want 1", got)
+	}
+
+	var fail atomic.Bool
+	fail.Store(true)
+	n.Store(0)
+	e = NewExporter(ExportOnShutdown, 0, 0, func() error {
+		n.Add(1)
+		if fail.Load() {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+	e.LinksStored()
+	if err := e.Flush(); err == nil {
+		t.Error("Flush should return the export error")
+	}
+	fail.Store(false)
+	e.Flush()
+	e.Flush()
+	if got := n.Load(); got != 2 {
+		t.Errorf("failed export: %d exports, want a retry after the failure and none after it succeeds", got)
+	}
+}
+
+func TestLinkExporter(t *testing.T) {
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	store := func(id, roomID, url string, ts int64) {
+		t.Helper()
+		if _, err := messagesDB.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, ?, '@alice:example.com', ?, ?, 'm.text')`,
+			id, roomID, ts, url); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := messagesDB.Exec(`INSERT INTO links(message_id, url, idx, ts_ms) VALUES (?, ?, 0, ?)`, id, url, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "links.json")
+	cfg := &config.Config{LinksPath: path, RoomIDs: []config.RoomIDEntry{{ID: "!room:example.com", Comment: "lounge"}}}
+	export := LinkExporter(messagesDB, cfg, db.NewLinkSnapshot())
+	read := func() map[string][]db.LinkRow {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out struct{ Rooms map[string][]db.LinkRow }
+		if err := json.Unmarshal(data, &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.Rooms
+	}
+
+	store("$b", "!room:example.com", "https://example.com/b", 2000)
+	store("$x", "!other:example.com", "https://example.com/x", 2000)
+	if err := export(); err != nil {
+		t.Fatal(err)
+	}
+	if rooms := read(); len(rooms) != 1 || len(rooms["lounge"]) != 1 {
+		t.Fatalf("first export = %+v", rooms)
+	}
+
+	// A backfilled older link and metadata for the first one.
+	store("$a", "!room:example.com", "https://example.com/a", 1000)
+	if err := db.SetLinkMetadata(messagesDB, db.PendingLink{MessageID: "$b", URL: "https://example.com/b"}, "B", 200, "text/html", 3000); err != nil {
+		t.Fatal(err)
+	}
+	if err := export(); err != nil {
+		t.Fatal(err)
+	}
+	got := read()["lounge"]
+	if len(got) != 2 || got[0].MessageID != "$a" || got[1].Title != "B" {
+		t.Errorf("second export = %+v", got)
+	}
+
+	cfg.RoomIDs = append(cfg.RoomIDs, config.RoomIDEntry{ID: "!other:example.com", Comment: "other"})
+	if err := export(); err != nil {
+		t.Fatal(err)
+	}
+	if rooms := read(); len(rooms["lounge"]) != 2 || len(rooms["other"]) != 1 {
+		t.Errorf("export after adding a room = %+v", rooms)
+	}
+
+	// Unchanged links aren't written again, but a missing file is.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := export(); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.Stat(path); !os.SameFile(info, again) {
+		t.Error("unchanged links were exported again")
+	}
+	os.Remove(path)
+	if err := export(); err != nil {
+		t.Fatal(err)
+	}
+	if rooms := read(); len(rooms["lounge"]) != 2 {
+		t.Errorf("export after removing the file = %+v", rooms)
+	}
+
+	// A full export streamed from the database lays out the same file.
+	full := filepath.Join(t.TempDir(), "full.json")
+	if err := ExportLinks(messagesDB, cfg, full); err != nil {
+		t.Fatal(err)
+	}
+	rooms := func(path string) string {
+		data, _ := os.ReadFile(path)
+		_, after, _ := strings.Cut(string(data), `"rooms"`)
+		return after
+	}
+	if got, want := rooms(full), rooms(path); got != want {
+		t.Errorf("full export rooms =%s\nwant%s", got, want)
+	}
+}
@@ -0,0 +1,243 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/links"
+	"github.com/polarhive/ash/locale"
+	"github.com/polarhive/ash/util"
+)
+
+// fetchFeed fetches a feed, giving up after 20 seconds. Feed URLs come from
+// chat, so only public addresses are fetched.
+func fetchFeed(ctx context.Context, feedURL string) (links.Feed, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+	return links.FetchFeed(ctx, util.PublicHTTPClient, feedURL)
+}
+
+// RunFeeds polls every subscribed feed each FEEDS.intervalMinutes (default
+// 30) until ctx is cancelled.
+func (app *App) RunFeeds(ctx context.Context) {
+	interval := 30 * time.Minute
+	if m := app.Cfg.Feeds.IntervalMinutes; m > 0 {
+		interval = time.Duration(m) * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		app.pollFeeds(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollFeeds fetches each subscribed feed once and posts its new items to the
+// subscribed rooms, oldest first. At most FEEDS.maxItems (default 5) of the
+// newest items are posted per feed; the rest are marked seen.
+func (app *App) pollFeeds(ctx context.Context) {
+	subs, err := db.Feeds(app.MessagesDB, "")
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to list feeds")
+		return
+	}
+	maxItems := 5
+	if n := app.Cfg.Feeds.MaxItems; n > 0 {
+		maxItems = n
+	}
+	fetched := make(map[string]links.Feed)
+	fetchErrs := make(map[string]error)
+	for _, sub := range subs {
+		if ctx.Err() != nil {
+			return
+		}
+		room, ok := app.findRoom(id.RoomID(sub.RoomID))
+		if !ok {
+			continue
+		}
+		feed, seen := fetched[sub.URL]
+		err, failed := fetchErrs[sub.URL]
+		if !seen && !failed {
+			feed, err = fetchFeed(ctx, sub.URL)
+			if err != nil {
+				fetchErrs[sub.URL] = err
+			} else {
+				fetched[sub.URL] = feed
+			}
+		}
+		now := time.Now().UnixMilli()
+		if err != nil {
+			log.Warn().Err(err).Str("feed", sub.URL).Msg("failed to fetch feed")
+			db.SetFeedPolled(app.MessagesDB, sub.RoomID, sub.URL, now, err.Error())
+			if sub.LastError == "" {
+				app.notifyEvent(ctx, NotifyFeedError, map[string]any{"room": sub.RoomID, "url": sub.URL, "error": err.Error()})
+			}
+			continue
+		}
+		db.SetFeedPolled(app.MessagesDB, sub.RoomID, sub.URL, now, "")
+
+		var fresh []links.FeedItem
+		for _, it := range feed.Items {
+			claimed, err := db.ClaimFeedItem(app.MessagesDB, sub.RoomID, sub.URL, it.GUID, now)
+			if err != nil {
+				log.Warn().Err(err).Str("feed", sub.URL).Msg("failed to record feed item")
+				break
+			}
+			if claimed {
+				fresh = append(fresh, it)
+			}
+		}
+		if len(fresh) > maxItems {
+			log.Info().Str("feed", sub.URL).Int("skipped", len(fresh)-maxItems).Msg("too many new feed items")
+			fresh = fresh[:maxItems]
+		}
+		title := feed.Title
+		if title == "" {
+			title = sub.Title
+		}
+		for i := len(fresh) - 1; i >= 0; i-- {
+			app.postFeedItem(ctx, id.RoomID(sub.RoomID), title, fresh[i])
+		}
+		if len(fresh) > 0 {
+			log.Info().Str("room", room.Comment).Str("feed", sub.URL).Int("items", len(fresh)).Msg("posted feed items")
+		}
+	}
+}
+
+// postFeedItem posts one feed item as a notice with its title and link.
+func (app *App) postFeedItem(ctx context.Context, roomID id.RoomID, feedTitle string, it links.FeedItem) {
+	esc := html.EscapeString
+	title := it.Title
+	if title == "" {
+		title = it.Link
+	}
+	content := event.MessageEventContent{
+		MsgType:       event.MsgNotice,
+		Body:          fmt.Sprintf("%s: %s\n%s", feedTitle, title, it.Link),
+		Format:        event.FormatHTML,
+		FormattedBody: fmt.Sprintf(`<b>%s</b>: <a href="%s">%s</a>`, esc(feedTitle), esc(it.Link), esc(title)),
+	}
+	if _, err := app.Client.SendMessageEvent(ctx, roomID, event.EventMessage, &content); err != nil {
+		log.Error().Err(err).Str("link", it.Link).Msg("failed to post feed item")
+	}
+}
+
+// handleFeed implements the feed builtin:
+//
+//	list              the room's feeds (default)
+//	add <url>         subscribe (admins)
+//	remove <url|n>    unsubscribe by URL or list number (admins)
+func (app *App) handleFeed(ctx context.Context, ev *event.Event, args, cmd, label string) {
+	reply := func(body string) { SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+body, cmd) }
+	say := func(key string, args ...any) { reply(locale.ForRoom(string(ev.RoomID), key, args...)) }
+	if app.Cfg.Feeds == nil {
+		say("feed_disabled")
+		return
+	}
+	roomID := string(ev.RoomID)
+	action, arg, _ := strings.Cut(strings.TrimSpace(args), " ")
+	arg = strings.TrimSpace(arg)
+	subs, err := db.Feeds(app.MessagesDB, roomID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list feeds")
+		say("feed_read_failed")
+		return
+	}
+
+	switch strings.ToLower(action) {
+	case "", "list":
+		if len(subs) == 0 {
+			say("feed_none", cmd)
+			return
+		}
+		reply(FormatFeeds(subs))
+	case "add", "remove":
+		if !app.isAdmin(ev.Sender) {
+			say("feed_admin_only")
+			return
+		}
+		if arg == "" {
+			say("feed_usage_url", cmd, action)
+			return
+		}
+		if strings.EqualFold(action, "remove") {
+			if n, err := strconv.Atoi(arg); err == nil && n >= 1 && n <= len(subs) {
+				arg = subs[n-1].URL
+			}
+			removed, err := db.RemoveFeed(app.MessagesDB, roomID, arg)
+			switch {
+			case err != nil:
+				log.Error().Err(err).Str("feed", arg).Msg("failed to remove feed")
+				say("feed_remove_failed")
+			case !removed:
+				say("feed_not_subscribed", arg)
+			default:
+				say("feed_unsubscribed", arg)
+			}
+			return
+		}
+		if u, err := url.Parse(arg); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			say("feed_bad_url")
+			return
+		}
+		if app.Cfg.DryRun && app.Cfg.DryRunNoNetwork {
+			say("feed_no_network")
+			return
+		}
+		feed, err := fetchFeed(ctx, arg)
+		if err != nil {
+			say("feed_fetch_failed", err)
+			return
+		}
+		guids := make([]string, len(feed.Items))
+		for i, it := range feed.Items {
+			guids[i] = it.GUID
+		}
+		sub := db.FeedSub{RoomID: roomID, URL: arg, Title: feed.Title, AddedBy: string(ev.Sender)}
+		if err := db.AddFeed(app.MessagesDB, sub, guids, time.Now().UnixMilli()); err != nil {
+			log.Error().Err(err).Str("feed", arg).Msg("failed to add feed")
+			say("feed_save_failed")
+			return
+		}
+		name := feed.Title
+		if name == "" {
+			name = arg
+		}
+		log.Info().Str("room", roomID).Str("feed", arg).Str("by", string(ev.Sender)).Msg("feed added")
+		say("feed_subscribed", name)
+	default:
+		say("feed_usage", cmd)
+	}
+}
+
+// FormatFeeds renders a room's subscriptions as a numbered list, noting
+// feeds whose last fetch failed.
+func FormatFeeds(subs []db.FeedSub) string {
+	var sb strings.Builder
+	sb.WriteString("feeds:")
+	for i, f := range subs {
+		fmt.Fprintf(&sb, "\n%d. ", i+1)
+		if f.Title != "" {
+			fmt.Fprintf(&sb, "%s — ", f.Title)
+		}
+		sb.WriteString(f.URL)
+		if f.LastError != "" {
+			fmt.Fprintf(&sb, " (failing: %s)", f.LastError)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/util"
+)
+
+func TestPollFeeds(t *testing.T) {
+	items := `<item><title>Three</title><link>https://example.com/3</link><guid>3</guid></item>
+<item><title>Two</title><link>https://example.com/2</link><guid>2</guid></item>
+<item><title>One</title><link>https://example.com/1</link><guid>1</guid></item>`
+	feedSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<rss><channel><title>News</title>%s</channel></rss>`, items)
+	}))
+	defer feedSrv.Close()
+	if _, err := fetchFeed(context.Background(), feedSrv.URL); !errors.Is(err, util.ErrPrivateAddress) {
+		t.Fatalf("fetching a loopback feed: %v, want ErrPrivateAddress", err)
+	}
+	public := util.PublicHTTPClient
+	util.PublicHTTPClient = feedSrv.Client()
+	defer func() { util.PublicHTTPClient = public }()
+
+	var sent []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var content struct {
+			Body string `json:"body"`
+		}
+		json.NewDecoder(r.Body).Decode(&content)
+		sent = append(sent, content.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"event_id":"$item"}`)
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	a := &App{
+		Cfg: &config.Config{
+			RoomIDs: []config.RoomIDEntry{{ID: "!room:example.com", Comment: "lounge"}},
+			Feeds:   &config.FeedsConfig{MaxItems: 1},
+		},
+		Client:     client,
+		MessagesDB: messagesDB,
+	}
+	// Item 1 was in the feed when it was added, so it is never posted.
+	sub := db.FeedSub{RoomID: "!room:example.com", URL: feedSrv.URL, Title: "News"}
+	if err := db.AddFeed(messagesDB, sub, []string{"1"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	a.pollFeeds(context.Background())
+	a.pollFeeds(context.Background())
+	// Two new items but maxItems is 1: only the newest is posted, once.
+	if len(sent) != 1 || !strings.Contains(sent[0], "News: Three") || !strings.Contains(sent[0], "https://example.com/3") {
+		t.Fatalf("sent %q", sent)
+	}
+
+	subs, err := db.Feeds(messagesDB, "!room:example.com")
+	if err != nil || len(subs) != 1 || subs[0].PolledMS == 0 {
+		t.Fatalf("feeds = %+v, %v", subs, err)
+	}
+	if got := FormatFeeds(subs); got != "feeds:\n1. News — "+feedSrv.URL {
+		t.Errorf("FormatFeeds = %q", got)
+	}
+	if removed, err := db.RemoveFeed(messagesDB, "!room:example.com", feedSrv.URL); err != nil || !removed {
+		t.Errorf("RemoveFeed = %v, %v", removed, err)
+	}
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/util"
+)
+
+// floodWindow is the sliding window over which flood thresholds are counted.
+const floodWindow = time.Minute
+
+type floodKey struct {
+	room   string
+	sender string
+}
+
+type floodEntry struct {
+	at    time.Time
+	body  string
+	links int
+}
+
+// FloodTracker keeps a sliding window of recent messages per room and user.
+type FloodTracker struct {
+	mu      sync.Mutex
+	recent  map[floodKey][]floodEntry
+	ignored map[floodKey]time.Time
+	pruned  time.Time
+}
+
+// NewFloodTracker creates a new FloodTracker.
+func NewFloodTracker() *FloodTracker {
+	return &FloodTracker{
+		recent:  make(map[floodKey][]floodEntry),
+		ignored: make(map[floodKey]time.Time),
+	}
+}
+
+// Check records a message and returns a description of the first threshold
+// it exceeds, or "" if none. The sender's window is reset after a trigger so
+// one burst is reported once.
+func (t *FloodTracker) Check(roomID, sender, body string, links int, now time.Time, cfg *config.FloodConfig) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+	key := floodKey{roomID, sender}
+	var window []floodEntry
+	for _, e := range t.recent[key] {
+		if now.Sub(e.at) < floodWindow {
+			window = append(window, e)
+		}
+	}
+	window = append(window, floodEntry{at: now, body: body, links: links})
+
+	dupes, linkCount := 0, 0
+	for _, e := range window {
+		if e.body == body {
+			dupes++
+		}
+		linkCount += e.links
+	}
+
+	var reason string
+	switch {
+	case cfg.MessagesPerMinute > 0 && len(window) > cfg.MessagesPerMinute:
+		reason = fmt.Sprintf("%d messages in a minute", len(window))
+	case cfg.DuplicateLimit > 0 && dupes > cfg.DuplicateLimit:
+		reason = fmt.Sprintf("same message %d times", dupes)
+	case cfg.LinksPerMinute > 0 && linkCount > cfg.LinksPerMinute:
+		reason = fmt.Sprintf("%d links in a minute", linkCount)
+	}
+	if reason != "" {
+		delete(t.recent, key)
+	} else {
+		t.recent[key] = window
+	}
+	return reason
+}
+
+// Ignore shadow-ignores a sender in a room until the given time.
+func (t *FloodTracker) Ignore(roomID, sender string, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ignored[floodKey{roomID, sender}] = until
+}
+
+// IsIgnored reports whether a sender is currently shadow-ignored in a room.
+func (t *FloodTracker) IsIgnored(roomID, sender string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+	key := floodKey{roomID, sender}
+	until, ok := t.ignored[key]
+	if ok && !now.Before(until) {
+		delete(t.ignored, key)
+		return false
+	}
+	return ok
+}
+
+// prune drops senders with no messages in the window and expired ignores,
+// at most once per floodWindow, so quiet senders don't stay in memory. The
+// caller holds t.mu.
+func (t *FloodTracker) prune(now time.Time) {
+	if now.Sub(t.pruned) < floodWindow {
+		return
+	}
+	t.pruned = now
+	for key, window := range t.recent {
+		if len(window) == 0 || now.Sub(window[len(window)-1].at) >= floodWindow {
+			delete(t.recent, key)
+		}
+	}
+	for key, until := range t.ignored {
+		if !now.Before(until) {
+			delete(t.ignored, key)
+		}
+	}
+}
+
+// handleFlood runs the room's configured flood actions for a sender.
+func (app *App) handleFlood(ctx context.Context, ev *event.Event, room config.RoomIDEntry, reason string) {
+	actions := room.Flood.Actions
+	if len(actions) == 0 {
+		actions = []string{"warn"}
+	}
+	log.Warn().Str("room", room.Comment).Str("sender", string(ev.Sender)).Str("reason", reason).Strs("actions", actions).Msg("flood detected")
+	app.audit(ev.RoomID, app.botUserID(), "flood", ev.Sender, fmt.Sprintf("%s (%s)", reason, strings.Join(actions, ", ")), ev.ID)
+
+	if util.InSlice(actions, "ignore") {
+		minutes := room.Flood.IgnoreMinutes
+		if minutes <= 0 {
+			minutes = 10
+		}
+		app.Flood.Ignore(string(ev.RoomID), string(ev.Sender), time.Now().Add(time.Duration(minutes)*time.Minute))
+	}
+	label := ResolveReplyLabel(app.Cfg, app.botConfig())
+	if util.InSlice(actions, "warn") {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"slow down please ("+reason+")", "flood")
+	}
+	if util.InSlice(actions, "notify") {
+		app.notifyModRoom(ctx, fmt.Sprintf("%sflood in %s: %s sent %s", label, room.Comment, ev.Sender, reason))
+	}
+}
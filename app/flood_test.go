@@ -0,0 +1,75 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/polarhive/ash/config"
+)
+
+func TestFloodTrackerCheck(t *testing.T) {
+	cfg := &config.FloodConfig{MessagesPerMinute: 3, DuplicateLimit: 2, LinksPerMinute: 2}
+	now := time.Now()
+	const room, alice = "!room:example.com", "@alice:example.com"
+
+	ft := NewFloodTracker()
+	for i, body := range []string{"a", "b", "c"} {
+		if got := ft.Check(room, alice, body, 0, now.Add(time.Duration(i)*time.Second), cfg); got != "" {
+			t.Fatalf("message %d unexpectedly flagged: %s", i, got)
+		}
+	}
+	if got := ft.Check(room, alice, "d", 0, now.Add(3*time.Second), cfg); got == "" {
+		t.Error("expected fourth message in a minute to be flagged")
+	}
+	// Window resets after a trigger and old messages age out.
+	if got := ft.Check(room, alice, "e", 0, now.Add(2*time.Minute), cfg); got != "" {
+		t.Errorf("expected reset window, got %s", got)
+	}
+
+	ft = NewFloodTracker()
+	ft.Check(room, alice, "spam", 0, now, cfg)
+	ft.Check(room, alice, "spam", 0, now, cfg)
+	if got := ft.Check(room, alice, "spam", 0, now, cfg); got == "" {
+		t.Error("expected third duplicate to be flagged")
+	}
+
+	ft = NewFloodTracker()
+	ft.Check(room, alice, "x", 2, now, cfg)
+	if got := ft.Check(room, alice, "y", 1, now, cfg); got == "" {
+		t.Error("expected link burst to be flagged")
+	}
+	if got := ft.Check(room, "@bob:example.com", "z", 2, now, cfg); got != "" {
+		t.Errorf("senders should be tracked separately, got %s", got)
+	}
+}
+
+func TestFloodTrackerIgnore(t *testing.T) {
+	ft := NewFloodTracker()
+	now := time.Now()
+	ft.Ignore("!room:example.com", "@alice:example.com", now.Add(time.Minute))
+	if !ft.IsIgnored("!room:example.com", "@alice:example.com", now) {
+		t.Error("expected alice to be ignored")
+	}
+	if ft.IsIgnored("!other:example.com", "@alice:example.com", now) {
+		t.Error("ignore should be per room")
+	}
+	if ft.IsIgnored("!room:example.com", "@alice:example.com", now.Add(2*time.Minute)) {
+		t.Error("expected ignore to expire")
+	}
+}
+
+func TestFloodTrackerPrunes(t *testing.T) {
+	cfg := &config.FloodConfig{MessagesPerMinute: 3}
+	now := time.Now()
+	ft := NewFloodTracker()
+	ft.Check("!room:example.com", "@alice:example.com", "hi", 0, now, cfg)
+	ft.Ignore("!room:example.com", "@bob:example.com", now.Add(time.Minute))
+	ft.Check("!room:example.com", "@carol:example.com", "hi", 0, now.Add(2*time.Minute), cfg)
+	if len(ft.recent) != 1 || len(ft.ignored) != 0 {
+		t.Errorf("after a quiet spell: %d senders tracked, %d ignored; want 1, 0", len(ft.recent), len(ft.ignored))
+	}
+	ft.IsIgnored("!room:example.com", "@dave:example.com", now.Add(4*time.Minute))
+	if len(ft.recent) != 0 {
+		t.Errorf("%d senders still tracked, want 0", len(ft.recent))
+	}
+}
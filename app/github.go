@@ -0,0 +1,234 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+)
+
+// githubMaxBytes caps GitHub webhook payloads, which can be large for big
+// pushes.
+const githubMaxBytes = 1 << 20
+
+// githubCommitLines is how many commits a push notice lists.
+const githubCommitLines = 3
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+type githubPayload struct {
+	Action     string `json:"action"`
+	Ref        string `json:"ref"`
+	Compare    string `json:"compare"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+	Sender githubUser `json:"sender"`
+	Pusher struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+	} `json:"commits"`
+	PullRequest *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+	} `json:"pull_request"`
+	Issue *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+	} `json:"issue"`
+	Release *struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		HTMLURL string `json:"html_url"`
+	} `json:"release"`
+}
+
+// VerifyGitHubSignature checks an X-Hub-Signature-256 header against the
+// HMAC-SHA256 of body under secret.
+func VerifyGitHubSignature(secret string, body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// FormatGitHubEvent renders a GitHub webhook as a compact notice in plain
+// text and HTML, returning the repository it's about. body is "" for events
+// and actions that aren't posted.
+func FormatGitHubEvent(eventType string, payload []byte) (repo, body, htmlBody string, err error) {
+	var p githubPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", "", "", fmt.Errorf("invalid payload: %w", err)
+	}
+	repo = p.Repository.FullName
+	esc := html.EscapeString
+	link := func(url, text string) string {
+		return fmt.Sprintf(`<a href="%s">%s</a>`, esc(url), esc(text))
+	}
+	var plain, rich string
+	switch eventType {
+	case "push":
+		branch := strings.TrimPrefix(strings.TrimPrefix(p.Ref, "refs/heads/"), "refs/tags/")
+		who := p.Pusher.Name
+		if who == "" {
+			who = p.Sender.Login
+		}
+		if p.Deleted {
+			plain = fmt.Sprintf("[%s] %s deleted %s", repo, who, branch)
+			rich = fmt.Sprintf("[%s] <b>%s</b> deleted <code>%s</code>", esc(repo), esc(who), esc(branch))
+			break
+		}
+		if len(p.Commits) == 0 {
+			return repo, "", "", nil
+		}
+		noun := "commits"
+		if len(p.Commits) == 1 {
+			noun = "commit"
+		}
+		plain = fmt.Sprintf("[%s] %s pushed %d %s to %s: %s", repo, who, len(p.Commits), noun, branch, p.Compare)
+		rich = fmt.Sprintf("[%s] <b>%s</b> %s to <code>%s</code>", esc(repo), esc(who),
+			link(p.Compare, fmt.Sprintf("pushed %d %s", len(p.Commits), noun)), esc(branch))
+		var lines []string
+		for i, c := range p.Commits {
+			if i == githubCommitLines {
+				lines = append(lines, fmt.Sprintf("… and %d more", len(p.Commits)-githubCommitLines))
+				break
+			}
+			subject, _, _ := strings.Cut(c.Message, "\n")
+			short := c.ID
+			if len(short) > 7 {
+				short = short[:7]
+			}
+			plain += fmt.Sprintf("\n%s %s", short, subject)
+			lines = append(lines, fmt.Sprintf("<code>%s</code> %s", link(c.URL, short), esc(subject)))
+		}
+		if len(p.Commits) > githubCommitLines {
+			plain += fmt.Sprintf("\n… and %d more", len(p.Commits)-githubCommitLines)
+		}
+		rich += "<br>" + strings.Join(lines, "<br>")
+	case "pull_request":
+		pr := p.PullRequest
+		action := p.Action
+		switch {
+		case pr == nil:
+			return repo, "", "", nil
+		case action == "closed" && pr.Merged:
+			action = "merged"
+		case action == "ready_for_review":
+			action = "marked ready for review"
+		case action != "opened" && action != "closed" && action != "reopened":
+			return repo, "", "", nil
+		}
+		plain = fmt.Sprintf("[%s] %s %s PR #%d: %s %s", repo, p.Sender.Login, action, pr.Number, pr.Title, pr.HTMLURL)
+		rich = fmt.Sprintf("[%s] <b>%s</b> %s PR %s", esc(repo), esc(p.Sender.Login), action,
+			link(pr.HTMLURL, fmt.Sprintf("#%d %s", pr.Number, pr.Title)))
+	case "issues":
+		issue := p.Issue
+		if issue == nil || (p.Action != "opened" && p.Action != "closed" && p.Action != "reopened") {
+			return repo, "", "", nil
+		}
+		plain = fmt.Sprintf("[%s] %s %s issue #%d: %s %s", repo, p.Sender.Login, p.Action, issue.Number, issue.Title, issue.HTMLURL)
+		rich = fmt.Sprintf("[%s] <b>%s</b> %s issue %s", esc(repo), esc(p.Sender.Login), p.Action,
+			link(issue.HTMLURL, fmt.Sprintf("#%d %s", issue.Number, issue.Title)))
+	case "release":
+		rel := p.Release
+		if rel == nil || p.Action != "published" {
+			return repo, "", "", nil
+		}
+		name := rel.Name
+		if name == "" {
+			name = rel.TagName
+		}
+		plain = fmt.Sprintf("[%s] %s released %s %s", repo, p.Sender.Login, name, rel.HTMLURL)
+		rich = fmt.Sprintf("[%s] <b>%s</b> released %s", esc(repo), esc(p.Sender.Login), link(rel.HTMLURL, name))
+	default:
+		return repo, "", "", nil
+	}
+	return repo, plain, rich, nil
+}
+
+// githubRoom returns the room a repository's events go to.
+func githubRoom(cfg *config.GitHubHookConfig, repo string) (id.RoomID, bool) {
+	if room, ok := cfg.Repos[repo]; ok {
+		return id.RoomID(room), true
+	}
+	room, ok := cfg.Repos["*"]
+	return id.RoomID(room), ok
+}
+
+// handleGitHubHook serves POST /github.
+func (app *App) handleGitHubHook(cfg *config.GitHubHookConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, githubMaxBytes))
+		if err != nil {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if !VerifyGitHubSignature(cfg.Secret, body, r.Header.Get("X-Hub-Signature-256")) {
+			writeAPIError(w, http.StatusUnauthorized, "bad signature")
+			return
+		}
+		eventType := r.Header.Get("X-GitHub-Event")
+		if eventType == "ping" {
+			writeAPIJSON(w, http.StatusOK, map[string]string{"status": "pong"})
+			return
+		}
+		repo, plain, rich, err := FormatGitHubEvent(eventType, body)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		room, ok := githubRoom(cfg, repo)
+		if plain == "" || !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if app.Cfg.ReadOnly {
+			writeAPIError(w, http.StatusForbidden, "bot is read-only")
+			return
+		}
+		content := event.MessageEventContent{
+			MsgType:       event.MsgNotice,
+			Body:          plain,
+			Format:        event.FormatHTML,
+			FormattedBody: rich,
+		}
+		resp, err := app.Client.SendMessageEvent(r.Context(), room, event.EventMessage, &content)
+		if err != nil {
+			log.Error().Err(err).Str("repo", repo).Str("event", eventType).Msg("failed to post GitHub event")
+			writeAPIError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		log.Info().Str("repo", repo).Str("event", eventType).Str("room", string(room)).Msg("posted GitHub event")
+		writeAPIJSON(w, http.StatusOK, map[string]string{"event_id": string(resp.EventID)})
+	}
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+
+	"github.com/polarhive/ash/config"
+)
+
+func githubSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestFormatGitHubEvent(t *testing.T) {
+	push := `{"ref":"refs/heads/main","compare":"https://github.com/o/r/compare/a...b","repository":{"full_name":"o/r"},"pusher":{"name":"alice"},
+		"commits":[{"id":"0123456789","message":"Fix <b>\n\nbody","url":"u1"},{"id":"1","message":"two"},{"id":"2","message":"three"},{"id":"3","message":"four"}]}`
+	tests := []struct {
+		event, payload string
+		plain, html    string
+	}{
+		{"push", push,
+			"[o/r] alice pushed 4 commits to main: https://github.com/o/r/compare/a...b\n0123456 Fix <b>\n1 two\n2 three\n… and 1 more",
+			`[o/r] <b>alice</b> <a href="https://github.com/o/r/compare/a...b">pushed 4 commits</a> to <code>main</code><br><code><a href="u1">0123456</a></code> Fix &lt;b&gt;<br><code><a href="">1</a></code> two<br><code><a href="">2</a></code> three<br>… and 1 more`},
+		{"pull_request", `{"action":"closed","repository":{"full_name":"o/r"},"sender":{"login":"bob"},"pull_request":{"number":7,"title":"Add X","html_url":"https://github.com/o/r/pull/7","merged":true}}`,
+			"[o/r] bob merged PR #7: Add X https://github.com/o/r/pull/7",
+			`[o/r] <b>bob</b> merged PR <a href="https://github.com/o/r/pull/7">#7 Add X</a>`},
+		{"pull_request", `{"action":"labeled","repository":{"full_name":"o/r"},"pull_request":{"number":7}}`, "", ""},
+		{"issues", `{"action":"opened","repository":{"full_name":"o/r"},"sender":{"login":"carol"},"issue":{"number":3,"title":"Crash","html_url":"https://github.com/o/r/issues/3"}}`,
+			"[o/r] carol opened issue #3: Crash https://github.com/o/r/issues/3",
+			`[o/r] <b>carol</b> opened issue <a href="https://github.com/o/r/issues/3">#3 Crash</a>`},
+		{"release", `{"action":"published","repository":{"full_name":"o/r"},"sender":{"login":"dan"},"release":{"tag_name":"v1.2.0","html_url":"https://github.com/o/r/releases/v1.2.0"}}`,
+			"[o/r] dan released v1.2.0 https://github.com/o/r/releases/v1.2.0",
+			`[o/r] <b>dan</b> released <a href="https://github.com/o/r/releases/v1.2.0">v1.2.0</a>`},
+		{"star", `{"action":"created","repository":{"full_name":"o/r"}}`, "", ""},
+	}
+	for _, tt := range tests {
+		repo, plain, html, err := FormatGitHubEvent(tt.event, []byte(tt.payload))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.event, err)
+		}
+		if repo != "o/r" || plain != tt.plain || html != tt.html {
+			t.Errorf("%s: got %q, %q, %q\nwant %q, %q", tt.event, repo, plain, html, tt.plain, tt.html)
+		}
+	}
+}
+
+func TestGitHubHook(t *testing.T) {
+	var sent []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"event_id":"$gh"}`)
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const secret = "github-secret-0123"
+	a := &App{Cfg: &config.Config{}, Client: client}
+	handler, err := a.InboundHookHandler(&config.InboundHooksConfig{GitHub: &config.GitHubHookConfig{
+		Secret: secret,
+		Repos:  map[string]string{"o/r": "!dev:example.com"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	post := func(event, body, signature string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+"/github", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", signature)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	issue := `{"action":"opened","repository":{"full_name":"o/r"},"sender":{"login":"carol"},"issue":{"number":3,"title":"Crash","html_url":"x"}}`
+	if code := post("issues", issue, githubSignature("wrong-secret", issue)); code != http.StatusUnauthorized {
+		t.Errorf("bad signature: status %d", code)
+	}
+	if code := post("ping", `{}`, githubSignature(secret, `{}`)); code != http.StatusOK {
+		t.Errorf("ping: status %d", code)
+	}
+	other := strings.Replace(issue, "o/r", "o/other", 1)
+	if code := post("issues", other, githubSignature(secret, other)); code != http.StatusNoContent {
+		t.Errorf("unmapped repo: status %d", code)
+	}
+	if code := post("issues", issue, githubSignature(secret, issue)); code != http.StatusOK {
+		t.Errorf("issue: status %d", code)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "/rooms/!dev:example.com/send/m.room.message/") {
+		t.Errorf("homeserver saw %v", sent)
+	}
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/locale"
+)
+
+// ParseGlossaryArgs splits the arguments of the glossary builtin into an
+// action ("lookup", "add", "forget" or "list"), a normalized term and, for
+// add, the definition:
+//
+//	is <term>?                lookup
+//	add <term> = <definition> add or replace (admins)
+//	forget <term>             remove (admins)
+//	list                      list terms
+func ParseGlossaryArgs(args string) (action, term, definition string) {
+	args = strings.TrimSpace(args)
+	first, rest, _ := strings.Cut(args, " ")
+	switch strings.ToLower(first) {
+	case "":
+		return "list", "", ""
+	case "list":
+		if rest == "" {
+			return "list", "", ""
+		}
+	case "add":
+		if t, d, ok := strings.Cut(rest, "="); ok {
+			return "add", normalizeTerm(t), strings.TrimSpace(d)
+		}
+		return "add", "", ""
+	case "forget":
+		return "forget", normalizeTerm(rest), ""
+	case "is", "are":
+		args = rest
+	}
+	return "lookup", normalizeTerm(args), ""
+}
+
+// normalizeTerm lower-cases a term and drops surrounding spaces and question
+// marks, so "What is LFS?" and "what is lfs" find the same entry.
+func normalizeTerm(s string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(s), "?"))
+}
+
+// handleGlossary implements the glossary builtin: answers from the room's
+// glossary and, for terms it doesn't define, falls back to AI when the
+// command has a prompt.
+func (app *App) handleGlossary(ctx context.Context, ev *event.Event, args string, cmdCfg bot.BotCommand, aiKey, cmd, label string) {
+	reply := func(body string) { SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+body, cmd) }
+	say := func(key string, args ...any) { reply(locale.ForRoom(string(ev.RoomID), key, args...)) }
+	action, term, definition := ParseGlossaryArgs(args)
+	roomID := string(ev.RoomID)
+
+	switch action {
+	case "list":
+		terms, err := db.GlossaryTerms(app.MessagesDB, roomID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to list glossary")
+			say("glossary_read_failed")
+			return
+		}
+		if len(terms) == 0 {
+			say("glossary_empty", cmd)
+			return
+		}
+		say("glossary_list", strings.Join(terms, ", "))
+		return
+	case "add", "forget":
+		if !app.isAdmin(ev.Sender) {
+			say("glossary_admin_only")
+			return
+		}
+		if term == "" || (action == "add" && definition == "") {
+			say("glossary_usage", cmd, cmd)
+			return
+		}
+	}
+
+	switch action {
+	case "add":
+		if err := db.SetGlossaryEntry(app.MessagesDB, roomID, term, definition, string(ev.Sender), time.Now().UnixMilli()); err != nil {
+			log.Error().Err(err).Str("term", term).Msg("failed to store glossary entry")
+			say("glossary_update_failed")
+			return
+		}
+		say("glossary_saved", term)
+	case "forget":
+		found, err := db.DeleteGlossaryEntry(app.MessagesDB, roomID, term)
+		if err != nil {
+			log.Error().Err(err).Str("term", term).Msg("failed to delete glossary entry")
+			say("glossary_update_failed")
+			return
+		}
+		if !found {
+			say("glossary_unknown", term)
+			return
+		}
+		say("glossary_forgot", term)
+	case "lookup":
+		if term == "" {
+			say("glossary_usage_lookup", cmd)
+			return
+		}
+		def, err := db.GlossaryDefinition(app.MessagesDB, roomID, term)
+		if err != nil {
+			log.Error().Err(err).Str("term", term).Msg("failed to look up glossary entry")
+		}
+		if def != "" {
+			reply(term + ": " + def)
+			return
+		}
+		if cmdCfg.Prompt == "" || (app.Cfg.DryRun && app.Cfg.DryRunNoNetwork) {
+			say("glossary_unknown", term)
+			return
+		}
+		answer, err := bot.AskAI(ctx, aiKey, &cmdCfg, "what is "+term+"?")
+		if err != nil {
+			log.Error().Err(err).Str("term", term).Msg("glossary AI fallback failed")
+			say("glossary_unknown", term)
+			return
+		}
+		reply(answer)
+	}
+}
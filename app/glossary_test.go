@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/locale"
+)
+
+func TestParseGlossaryArgs(t *testing.T) {
+	tests := []struct {
+		args                     string
+		action, term, definition string
+	}{
+		{"", "list", "", ""},
+		{"list", "list", "", ""},
+		{"is LFS?", "lookup", "lfs", ""},
+		{"are Office Hours", "lookup", "office hours", ""},
+		{"list comprehension", "lookup", "list comprehension", ""},
+		{"add LFS = https://git-lfs.com, large file storage", "add", "lfs", "https://git-lfs.com, large file storage"},
+		{"add LFS", "add", "", ""},
+		{"forget lfs", "forget", "lfs", ""},
+	}
+	for _, tt := range tests {
+		action, term, definition := ParseGlossaryArgs(tt.args)
+		if action != tt.action || term != tt.term || definition != tt.definition {
+			t.Errorf("ParseGlossaryArgs(%q) = %q, %q, %q; want %q, %q, %q", tt.args, action, term, definition, tt.action, tt.term, tt.definition)
+		}
+	}
+}
+
+func TestHandleGlossary(t *testing.T) {
+	var replies []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var content event.MessageEventContent
+		if r.Method == http.MethodPut && json.NewDecoder(r.Body).Decode(&content) == nil {
+			replies = append(replies, content.Body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"event_id":"$reply"}`)
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	a := &App{
+		Cfg:        &config.Config{Admins: []string{"@admin:example.com"}},
+		Client:     client,
+		MessagesDB: messagesDB,
+	}
+	cmdCfg := bot.BotCommand{Type: "builtin", Command: "glossary"}
+	run := func(sender, args string) string {
+		t.Helper()
+		ev := &event.Event{ID: "$cmd", RoomID: "!room:example.com", Sender: id.UserID(sender)}
+		a.handleGlossary(context.Background(), ev, args, cmdCfg, "", "what", "")
+		if len(replies) == 0 {
+			t.Fatalf("no reply to %q", args)
+		}
+		return replies[len(replies)-1]
+	}
+
+	if got := run("@user:example.com", "add lfs = large file storage"); !strings.Contains(got, "only bot admins") {
+		t.Errorf("non-admin add: %q", got)
+	}
+	if got := run("@admin:example.com", "add LFS = large file storage"); got != `saved "lfs"` {
+		t.Errorf("add: %q", got)
+	}
+	if got := run("@user:example.com", "is lfs?"); got != "lfs: large file storage" {
+		t.Errorf("lookup: %q", got)
+	}
+	if got := run("@user:example.com", "is nix"); got != `"nix" isn't in the glossary` {
+		t.Errorf("missing term without AI: %q", got)
+	}
+	if got := run("@user:example.com", ""); got != "glossary: lfs" {
+		t.Errorf("list: %q", got)
+	}
+	if got := run("@admin:example.com", "forget lfs"); got != `forgot "lfs"` {
+		t.Errorf("forget: %q", got)
+	}
+
+	// Replies follow the room's language.
+	defer func(tr locale.Catalog, rooms map[string]string) {
+		locale.Translations, locale.RoomLanguages = tr, rooms
+	}(locale.Translations, locale.RoomLanguages)
+	locale.Translations = locale.Catalog{"de": {"glossary_unknown": "%q steht nicht im Glossar"}}
+	locale.RoomLanguages = map[string]string{"!room:example.com": "de"}
+	if got := run("@user:example.com", "is nix"); got != `"nix" steht nicht im Glossar` {
+		t.Errorf("translated reply: %q", got)
+	}
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/polarhive/ash/db"
+)
+
+// grafanaMetrics are the daily series offered for each room, as targets
+// named "<metric> <room>" where room is the room's comment or ID.
+var grafanaMetrics = []string{"messages", "links", "commands"}
+
+// grafanaMaxDays caps the days in one series.
+const grafanaMaxDays = 3660
+
+type grafanaTarget struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+	Type   string `json:"type"` // "timeserie" (default) or "table"
+}
+
+// grafanaRequest is the body of a JSON datasource /query.
+type grafanaRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []grafanaTarget `json:"targets"`
+}
+
+type grafanaSeries struct {
+	Target     string     `json:"target"`
+	Datapoints [][2]int64 `json:"datapoints"` // [value, unix ms]
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+func grafanaRoomName(r dashboardRoom) string {
+	if r.Comment != "" {
+		return r.Comment
+	}
+	return r.ID
+}
+
+// grafanaTargets lists every metric of every room.
+func grafanaTargets(rooms []dashboardRoom) []string {
+	var out []string
+	for _, r := range rooms {
+		for _, m := range grafanaMetrics {
+			out = append(out, m+" "+grafanaRoomName(r))
+		}
+	}
+	return out
+}
+
+// parseGrafanaTarget splits a target into its metric and room.
+func parseGrafanaTarget(target string, rooms []dashboardRoom) (string, dashboardRoom, bool) {
+	metric, name, _ := strings.Cut(strings.TrimSpace(target), " ")
+	if !slices.Contains(grafanaMetrics, metric) {
+		return "", dashboardRoom{}, false
+	}
+	for _, r := range rooms {
+		if name == r.ID || (r.Comment != "" && name == r.Comment) {
+			return metric, r, true
+		}
+	}
+	return "", dashboardRoom{}, false
+}
+
+// dailyDatapoints turns per-day counts into one point per day from from to
+// to, at each day's midnight in loc, with zeros for days without any.
+func dailyDatapoints(counts []db.Count, from, to time.Time, loc *time.Location) [][2]int64 {
+	byDay := make(map[string]int, len(counts))
+	for _, c := range counts {
+		byDay[c.Key] = c.N
+	}
+	from = from.In(loc)
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	points := [][2]int64{}
+	for i := 0; !day.After(to) && i < grafanaMaxDays; i++ {
+		points = append(points, [2]int64{int64(byDay[day.Format("2006-01-02")]), day.UnixMilli()})
+		day = day.AddDate(0, 0, 1)
+	}
+	return points
+}
+
+// grafanaHealth answers the datasource's connection test.
+func (app *App) grafanaHealth(w http.ResponseWriter, _ *http.Request) {
+	writeAPIJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// grafanaSearch lists the targets, for the older /search protocol.
+func (app *App) grafanaSearch(w http.ResponseWriter, _ *http.Request) {
+	rooms, err := app.dashboardRooms()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	targets := grafanaTargets(rooms)
+	if targets == nil {
+		targets = []string{}
+	}
+	writeAPIJSON(w, http.StatusOK, targets)
+}
+
+// grafanaMetricList lists the targets, for the newer /metrics protocol.
+func (app *App) grafanaMetricList(w http.ResponseWriter, _ *http.Request) {
+	rooms, err := app.dashboardRooms()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := []map[string]string{}
+	for _, t := range grafanaTargets(rooms) {
+		out = append(out, map[string]string{"label": t, "value": t})
+	}
+	writeAPIJSON(w, http.StatusOK, out)
+}
+
+// grafanaQuery answers a /query: each target is a series of daily counts
+// over the range, or, for "commands" targets of type table, each command's
+// uses in the range.
+func (app *App) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var req grafanaRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid query: "+err.Error())
+		return
+	}
+	to, from := req.Range.To, req.Range.From
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -dashboardDays)
+	}
+	rooms, err := app.dashboardRooms()
+	if err != nil || app.MessagesDB == nil {
+		writeAPIError(w, http.StatusInternalServerError, "statistics unavailable")
+		return
+	}
+
+	out := []any{}
+	for _, t := range req.Targets {
+		metric, room, ok := parseGrafanaTarget(t.Target, rooms)
+		if !ok {
+			writeAPIError(w, http.StatusBadRequest, "unknown target "+t.Target)
+			return
+		}
+		if metric == "commands" && t.Type == "table" {
+			counts, err := db.CommandCountsBetween(app.MessagesDB, room.ID, from.UnixMilli(), to.UnixMilli())
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			table := grafanaTable{
+				Type:    "table",
+				Columns: []grafanaColumn{{Text: "Command", Type: "string"}, {Text: "Uses", Type: "number"}},
+				Rows:    [][]any{},
+			}
+			for _, c := range counts {
+				table.Rows = append(table.Rows, []any{c.Key, c.N})
+			}
+			out = append(out, table)
+			continue
+		}
+
+		loc := roomLocation(room.ID)
+		start := from.In(loc)
+		_, offset := start.Zone()
+		since := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc).UnixMilli()
+		var counts []db.Count
+		switch metric {
+		case "messages":
+			counts, err = db.DailyMessageCounts(app.MessagesDB, room.ID, since, offset/60)
+		case "links":
+			counts, err = db.DailyLinkCounts(app.MessagesDB, room.ID, since, offset/60)
+		case "commands":
+			counts, err = db.DailyCommandCounts(app.MessagesDB, room.ID, since, offset/60)
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, grafanaSeries{Target: t.Target, Datapoints: dailyDatapoints(counts, from, to, loc)})
+	}
+	writeAPIJSON(w, http.StatusOK, out)
+}
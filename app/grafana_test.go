@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestDailyDatapoints(t *testing.T) {
+	loc := time.FixedZone("IST", 330*60)
+	from := time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC) // 2 March in IST
+	to := time.Date(2026, 3, 4, 1, 0, 0, 0, time.UTC)
+	got := dailyDatapoints([]db.Count{{Key: "2026-03-03", N: 7}}, from, to, loc)
+	day := func(d int) int64 { return time.Date(2026, 3, d, 0, 0, 0, 0, loc).UnixMilli() }
+	want := [][2]int64{{0, day(2)}, {7, day(3)}, {0, day(4)}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("dailyDatapoints = %v, want %v", got, want)
+	}
+}
+
+func TestGrafana(t *testing.T) {
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	now := time.Now().UnixMilli()
+	for i, body := range []string{"hello", "look https://example.com/a", "/bot yap", "/bot yap hours", "/bot top"} {
+		if _, err := messagesDB.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, ?, ?, ?, ?, 'm.text')`,
+			fmt.Sprintf("$m%d", i), "!room:example.com", "@alice:example.com", now, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := messagesDB.Exec(`INSERT INTO links(message_id, url, idx, ts_ms) VALUES ('$m1', 'https://example.com/a', 0, ?)`, now); err != nil {
+		t.Fatal(err)
+	}
+	a := &App{
+		Cfg:        &config.Config{RoomIDs: []config.RoomIDEntry{{ID: "!room:example.com", Comment: "lounge"}}},
+		MessagesDB: messagesDB,
+	}
+	srv := httptest.NewServer(a.DashboardHandler("", ""))
+	defer srv.Close()
+
+	post := func(path, body string, v any) int {
+		t.Helper()
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(v)
+		return resp.StatusCode
+	}
+
+	var targets []string
+	if post("/grafana/search", `{}`, &targets); strings.Join(targets, ",") != "messages lounge,links lounge,commands lounge" {
+		t.Errorf("search = %q", targets)
+	}
+
+	from := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	to := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	var series []struct {
+		Target     string
+		Datapoints [][2]int64
+		Rows       [][]any
+	}
+	query := fmt.Sprintf(`{"range": {"from": %q, "to": %q}, "targets": [
+		{"target": "messages lounge"}, {"target": "links !room:example.com"}, {"target": "commands lounge", "type": "table"}]}`, from, to)
+	if code := post("/grafana/query", query, &series); code != http.StatusOK || len(series) != 3 {
+		t.Fatalf("query: %d %+v", code, series)
+	}
+	sum := func(points [][2]int64) (n int64) {
+		for _, p := range points {
+			n += p[0]
+		}
+		return n
+	}
+	if series[0].Target != "messages lounge" || sum(series[0].Datapoints) != 5 || len(series[0].Datapoints) < 3 {
+		t.Errorf("messages = %+v", series[0])
+	}
+	if sum(series[1].Datapoints) != 1 {
+		t.Errorf("links = %+v", series[1])
+	}
+	if fmt.Sprint(series[2].Rows) != "[[yap 2] [top 1]]" {
+		t.Errorf("commands table = %v", series[2].Rows)
+	}
+
+	if code := post("/grafana/query", `{"targets": [{"target": "messages nowhere"}]}`, &series); code != http.StatusBadRequest {
+		t.Errorf("unknown target: status %d", code)
+	}
+}
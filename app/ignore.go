@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/locale"
+)
+
+// IgnoreList is the set of users whose messages are archived but never
+// trigger commands, link hooks or games. It is persisted in ignored_users.
+type IgnoreList struct {
+	mu    sync.RWMutex
+	users map[id.UserID]bool
+}
+
+// LoadIgnoreList reads the persisted ignore list from the messages database.
+func LoadIgnoreList(database *sql.DB) (*IgnoreList, error) {
+	l := &IgnoreList{users: make(map[id.UserID]bool)}
+	users, err := db.LoadIgnoredUsers(database)
+	if err != nil {
+		return nil, fmt.Errorf("load ignored users: %w", err)
+	}
+	for _, u := range users {
+		l.users[id.UserID(u)] = true
+	}
+	return l, nil
+}
+
+// Contains reports whether the user is ignored.
+func (l *IgnoreList) Contains(userID id.UserID) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.users[userID]
+}
+
+// Set adds or removes a user from the in-memory list.
+func (l *IgnoreList) Set(userID id.UserID, ignored bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ignored {
+		l.users[userID] = true
+	} else {
+		delete(l.users, userID)
+	}
+}
+
+// List returns the ignored users in sorted order.
+func (l *IgnoreList) List() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	users := make([]string, 0, len(l.users))
+	for u := range l.users {
+		users = append(users, string(u))
+	}
+	sort.Strings(users)
+	return users
+}
+
+// handleIgnore implements /bot ignore and /bot unignore for admins. Without
+// a target, ignore lists the currently ignored users.
+func (app *App) handleIgnore(ctx context.Context, ev *event.Event, msgData *db.MessageData, cmd, label string) {
+	if !app.isAdmin(ev.Sender) {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+locale.ForRoom(string(ev.RoomID), "admin_only"), cmd)
+		return
+	}
+	if app.Ignored == nil {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"ignore list is unavailable", cmd)
+		return
+	}
+	var args string
+	if parts := strings.Fields(msgData.Msg.Body); len(parts) > 2 {
+		args = strings.Join(parts[2:], " ")
+	}
+	target, _ := bot.ParseModerationArgs(args)
+	if target == "" {
+		if cmd == "ignore" && args == "" {
+			users := app.Ignored.List()
+			if len(users) == 0 {
+				SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"no users are ignored", cmd)
+			} else {
+				SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"ignored users: "+strings.Join(users, ", "), cmd)
+			}
+			return
+		}
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%susage: /bot %s @user:server", label, cmd), cmd)
+		return
+	}
+
+	ignore := cmd == "ignore"
+	var err error
+	if ignore {
+		err = db.AddIgnoredUser(app.MessagesDB, string(target), string(ev.Sender), time.Now().UnixMilli())
+	} else {
+		err = db.RemoveIgnoredUser(app.MessagesDB, string(target))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("cmd", cmd).Str("target", string(target)).Msg("failed to update ignore list")
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"couldn't update the ignore list", cmd)
+		return
+	}
+	app.Ignored.Set(target, ignore)
+	app.audit(ev.RoomID, ev.Sender, cmd, target, "", ev.ID)
+	log.Info().Str("cmd", cmd).Str("actor", string(ev.Sender)).Str("target", string(target)).Msg("ignore list updated")
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%sdone: %s %s", label, cmd, target), cmd)
+}
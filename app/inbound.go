@@ -0,0 +1,161 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+)
+
+// inboundHookMaxBytes caps the size of an inbound hook request body.
+const inboundHookMaxBytes = 64 << 10
+
+type inboundHook struct {
+	token []byte
+	room  id.RoomID
+	tmpl  *template.Template
+}
+
+// RenderInboundHook turns an inbound hook request body into message text.
+// JSON objects are fed to tmpl; without one their "text", "body" or
+// "message" field is used, falling back to the indented JSON. Anything else
+// is plain text, available to tmpl as {{.text}}.
+func RenderInboundHook(tmpl *template.Template, contentType string, body []byte) (string, error) {
+	var data map[string]any
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	isJSON := mediaType == "application/json" || (mediaType == "" && bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")))
+	if isJSON {
+		if err := json.Unmarshal(body, &data); err != nil {
+			return "", fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else {
+		data = map[string]any{"text": string(body)}
+	}
+
+	var text string
+	switch {
+	case tmpl != nil:
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return "", err
+		}
+		text = sb.String()
+	case !isJSON:
+		text = string(body)
+	default:
+		for _, key := range []string{"text", "body", "message"} {
+			if s, ok := data[key].(string); ok {
+				text = s
+				break
+			}
+		}
+		if text == "" {
+			pretty, _ := json.MarshalIndent(data, "", "  ")
+			text = string(pretty)
+		}
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errors.New("empty message")
+	}
+	return text, nil
+}
+
+// InboundHookHandler serves POST /hooks/{token}, posting the body into the
+// room mapped to token as a notice, plus POST /github and POST /alertmanager
+// if those are configured.
+func (app *App) InboundHookHandler(c *config.InboundHooksConfig) (http.Handler, error) {
+	var parsed []inboundHook
+	for token, h := range c.Hooks {
+		ih := inboundHook{token: []byte(token), room: id.RoomID(h.Room)}
+		if h.Template != "" {
+			t, err := template.New("hook").Parse(h.Template)
+			if err != nil {
+				return nil, fmt.Errorf("template for %s: %w", h.Room, err)
+			}
+			ih.tmpl = t
+		}
+		parsed = append(parsed, ih)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hooks/{token}", func(w http.ResponseWriter, r *http.Request) {
+		var hook *inboundHook
+		for i := range parsed {
+			if subtle.ConstantTimeCompare([]byte(r.PathValue("token")), parsed[i].token) == 1 {
+				hook = &parsed[i]
+			}
+		}
+		if hook == nil {
+			writeAPIError(w, http.StatusNotFound, "unknown hook")
+			return
+		}
+		if app.Cfg.ReadOnly {
+			writeAPIError(w, http.StatusForbidden, "bot is read-only")
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, inboundHookMaxBytes))
+		if err != nil {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		text, err := RenderInboundHook(hook.tmpl, r.Header.Get("Content-Type"), body)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		content := event.MessageEventContent{MsgType: event.MsgNotice, Body: text}
+		resp, err := app.Client.SendMessageEvent(r.Context(), hook.room, event.EventMessage, &content)
+		if err != nil {
+			log.Error().Err(err).Str("room", string(hook.room)).Msg("failed to post inbound hook")
+			writeAPIError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		log.Info().Str("room", string(hook.room)).Int("bytes", len(body)).Msg("posted inbound hook")
+		writeAPIJSON(w, http.StatusOK, map[string]string{"event_id": string(resp.EventID)})
+	})
+	if c.GitHub != nil {
+		mux.HandleFunc("POST /github", app.handleGitHubHook(c.GitHub))
+	}
+	if c.Alertmanager != nil {
+		mux.HandleFunc("POST /alertmanager", app.handleAlertmanagerHook(c.Alertmanager))
+	}
+	return mux, nil
+}
+
+// RunInboundHooks serves InboundHookHandler until ctx is done.
+func (app *App) RunInboundHooks(ctx context.Context, c *config.InboundHooksConfig) error {
+	handler, err := app.InboundHookHandler(c)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:              c.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	log.Info().Str("addr", c.Listen).Int("hooks", len(c.Hooks)).Msg("inbound hooks listening")
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
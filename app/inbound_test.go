@@ -0,0 +1,91 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+
+	"maunium.net/go/mautrix"
+
+	"github.com/polarhive/ash/config"
+)
+
+func TestRenderInboundHook(t *testing.T) {
+	tmpl := template.Must(template.New("hook").Parse("{{.repo}}: build {{.status}}"))
+	tests := []struct {
+		name        string
+		tmpl        *template.Template
+		contentType string
+		body        string
+		want        string
+		wantErr     bool
+	}{
+		{"plain text", nil, "text/plain", "backup done\n", "backup done", false},
+		{"json text field", nil, "application/json", `{"text":"door opened"}`, "door opened", false},
+		{"json without text", nil, "application/json", `{"a":1}`, "{\n  \"a\": 1\n}", false},
+		{"json sniffed", tmpl, "", `{"repo":"ash","status":"passed"}`, "ash: build passed", false},
+		{"plain text template", template.Must(template.New("hook").Parse("cron: {{.text}}")), "text/plain; charset=utf-8", "ok", "cron: ok", false},
+		{"invalid json", nil, "application/json", `{`, "", true},
+		{"empty", nil, "text/plain", "  ", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderInboundHook(tt.tmpl, tt.contentType, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInboundHookHandler(t *testing.T) {
+	var sent []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"event_id":"$hook"}`)
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{Cfg: &config.Config{}, Client: client}
+	handler, err := a.InboundHookHandler(&config.InboundHooksConfig{Hooks: map[string]config.InboundHook{
+		"ci-token-0123456789": {Room: "!ci:example.com"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	post := func(token, body string) int {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/hooks/"+token, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post("wrong-token", "hi"); code != http.StatusNotFound {
+		t.Errorf("wrong token: status %d", code)
+	}
+	if code := post("ci-token-0123456789", "deploy finished"); code != http.StatusOK {
+		t.Errorf("post: status %d", code)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "/rooms/!ci:example.com/send/m.room.message/") {
+		t.Errorf("homeserver saw %v", sent)
+	}
+	a.Cfg.ReadOnly = true
+	if code := post("ci-token-0123456789", "hi"); code != http.StatusForbidden {
+		t.Errorf("read-only: status %d", code)
+	}
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
+)
+
+// startModeration validates a moderation command and asks the requester to
+// confirm it by replying "yes".
+func (app *App) startModeration(ctx context.Context, ev *event.Event, msgData *db.MessageData, action, label string) {
+	var args string
+	if parts := strings.Fields(msgData.Msg.Body); len(parts) > 2 {
+		args = strings.Join(parts[2:], " ")
+	}
+	target, reason := bot.ParseModerationArgs(args)
+	if target == "" && msgData.Msg.RelatesTo != nil && msgData.Msg.RelatesTo.InReplyTo != nil {
+		if original, err := matrix.FetchAndDecrypt(ctx, app.Client, ev.RoomID, msgData.Msg.RelatesTo.InReplyTo.EventID); err == nil {
+			target = original.Sender
+		}
+	}
+	if target == "" {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%susage: /bot %s @user:server [reason] (or reply to their message)", label, action), action)
+		return
+	}
+	if target == app.Client.UserID {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"i can't do that to myself", action)
+		return
+	}
+
+	if !app.isAdmin(ev.Sender) {
+		pl, err := bot.FetchPowerLevels(ctx, app.Client, ev.RoomID)
+		if err != nil {
+			log.Error().Err(err).Str("cmd", action).Msg("failed to check moderation permissions")
+			SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"couldn't check your permissions", action)
+			return
+		}
+		senderLevel := pl.GetUserLevel(ev.Sender)
+		if senderLevel < bot.RequiredPowerLevel(pl, action) {
+			SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%syou don't have permission to %s here", label, action), action)
+			return
+		}
+		if pl.GetUserLevel(target) >= senderLevel {
+			SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%syou can't %s someone with an equal or higher power level", label, action), action)
+			return
+		}
+	}
+
+	pending := &bot.ModAction{
+		Action:    action,
+		Target:    target,
+		Reason:    reason,
+		Requester: ev.Sender,
+		Label:     label,
+	}
+	confirmID, err := matrix.SendReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%sreply \"yes\" to confirm: %s", label, pending.Describe()), "")
+	if err != nil {
+		log.Error().Err(err).Str("cmd", action).Msg("failed to send moderation confirmation")
+		return
+	}
+	log.Info().Str("cmd", action).Str("actor", string(ev.Sender)).Str("target", string(target)).Msg("moderation action awaiting confirmation")
+	app.Moderation.Set(confirmID, pending)
+}
+
+// confirmModeration applies or cancels a pending moderation action based on
+// the requester's reply, recording applied actions in the audit log.
+func (app *App) confirmModeration(ctx context.Context, ev *event.Event, body string, a *bot.ModAction, promptID id.EventID) {
+	app.Moderation.Delete(promptID)
+	if !bot.IsConfirmation(body) {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, a.Label+"cancelled", a.Action)
+		return
+	}
+	if err := app.applyModeration(ctx, ev.RoomID, a, time.Time{}); err != nil {
+		log.Error().Err(err).Str("cmd", a.Action).Str("target", string(a.Target)).Msg("moderation action failed")
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%scouldn't %s: %v", a.Label, a.Action, err), a.Action)
+		return
+	}
+	log.Info().Str("cmd", a.Action).Str("actor", string(a.Requester)).Str("target", string(a.Target)).Str("reason", a.Reason).Msg("moderation action applied")
+	app.audit(ev.RoomID, a.Requester, a.Action, a.Target, a.Reason, ev.ID)
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, a.Label+"done: "+a.Describe(), a.Action)
+}
+
+// applyModeration performs the action, recording a muted user's previous
+// power level so unmuting restores it rather than the room default. A mute
+// with a non-zero expires is lifted by RunMuteExpiry.
+func (app *App) applyModeration(ctx context.Context, roomID id.RoomID, a *bot.ModAction, expires time.Time) error {
+	if a.Action == "unmute" && app.MessagesDB != nil {
+		m, ok, err := db.GetMute(app.MessagesDB, string(roomID), string(a.Target))
+		if err != nil {
+			log.Warn().Err(err).Str("target", string(a.Target)).Msg("failed to load level before mute")
+		} else if ok {
+			a.PrevLevel = &m.PrevLevel
+		}
+	}
+	if err := bot.ApplyModeration(ctx, app.Client, roomID, a); err != nil {
+		return err
+	}
+	if app.MessagesDB == nil {
+		return nil
+	}
+	var err error
+	switch a.Action {
+	case "mute":
+		m := db.Mute{RoomID: string(roomID), UserID: string(a.Target), PrevLevel: *a.PrevLevel, MuteLevel: a.MuteLevel}
+		if !expires.IsZero() {
+			m.ExpiresMillis = expires.UnixMilli()
+		}
+		err = db.SaveMute(app.MessagesDB, m, time.Now().UnixMilli())
+	case "unmute":
+		err = db.DeleteMute(app.MessagesDB, string(roomID), string(a.Target))
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("cmd", a.Action).Str("target", string(a.Target)).Msg("failed to record mute")
+	}
+	return nil
+}
+
+// notifyModRoom posts a notice to MOD_ROOM_ID, if configured.
+func (app *App) notifyModRoom(ctx context.Context, body string) {
+	if app.Cfg.ModRoomID == "" {
+		return
+	}
+	content := event.MessageEventContent{MsgType: event.MsgText, Body: body}
+	if _, err := app.Client.SendMessageEvent(ctx, id.RoomID(app.Cfg.ModRoomID), event.EventMessage, &content); err != nil {
+		log.Error().Err(err).Msg("failed to notify mod room")
+	}
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+// powerLevelsApp returns an App whose homeserver serves and stores the
+// room's power levels in *pl, with a fresh messages database.
+func powerLevelsApp(t *testing.T, pl **event.PowerLevelsEventContent) *App {
+	t.Helper()
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(r.URL.Path, "/state/m.room.power_levels") {
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			return
+		}
+		if r.Method == http.MethodPut {
+			*pl = &event.PowerLevelsEventContent{}
+			if err := json.NewDecoder(r.Body).Decode(*pl); err != nil {
+				t.Error(err)
+			}
+			fmt.Fprint(w, `{"event_id":"$pl"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(*pl)
+	}))
+	t.Cleanup(hs.Close)
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	database, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return &App{Cfg: &config.Config{}, Client: client, MessagesDB: database}
+}
+
+func TestMuteRestoresLevel(t *testing.T) {
+	const room, alice = "!room:example.com", "@alice:example.com"
+	pl := &event.PowerLevelsEventContent{Users: map[id.UserID]int{"@ash:example.com": 100, alice: 10}}
+	a := powerLevelsApp(t, &pl)
+
+	for _, action := range []string{"mute", "mute"} {
+		if err := a.applyModeration(context.Background(), room, &bot.ModAction{Action: action, Target: alice}, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := pl.GetUserLevel(alice); got != -1 {
+		t.Errorf("muted level = %d, want -1", got)
+	}
+	if err := a.applyModeration(context.Background(), room, &bot.ModAction{Action: "unmute", Target: alice}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := pl.GetUserLevel(alice); got != 10 {
+		t.Errorf("unmuted level = %d, want 10 from before the first mute", got)
+	}
+	if _, ok, _ := db.GetMute(a.MessagesDB, room, alice); ok {
+		t.Error("unmute should forget the recorded level")
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/db"
+)
+
+// audit records a moderation action taken by or through the bot.
+func (app *App) audit(roomID id.RoomID, actor id.UserID, action string, target id.UserID, reason string, eventID id.EventID) {
+	if app.MessagesDB == nil {
+		return
+	}
+	err := db.StoreModAction(app.MessagesDB, db.ModLogEntry{
+		RoomID:   string(roomID),
+		Actor:    string(actor),
+		Action:   action,
+		Target:   string(target),
+		Reason:   reason,
+		EventID:  string(eventID),
+		TSMillis: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("action", action).Msg("failed to record moderation action")
+	}
+	app.notifyEvent(context.Background(), NotifyModeration, map[string]any{
+		"room":   string(roomID),
+		"actor":  string(actor),
+		"action": action,
+		"target": string(target),
+		"reason": reason,
+	})
+}
+
+// botUserID returns the bot's user ID, or "" without a client.
+func (app *App) botUserID() id.UserID {
+	if app.Client == nil {
+		return ""
+	}
+	return app.Client.UserID
+}
+
+// FormatModLog renders audit log entries one per line.
+func FormatModLog(entries []db.ModLogEntry) string {
+	if len(entries) == 0 {
+		return "no moderation actions recorded"
+	}
+	var sb strings.Builder
+	for i, e := range entries {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%s %s", time.UnixMilli(e.TSMillis).In(bot.YapTimezone).Format("2006-01-02 15:04"), e.Action)
+		if e.Target != "" {
+			fmt.Fprintf(&sb, " %s", e.Target)
+		}
+		if e.Actor != "" {
+			fmt.Fprintf(&sb, " by %s", e.Actor)
+		}
+		if e.Reason != "" {
+			fmt.Fprintf(&sb, ": %s", e.Reason)
+		}
+		if e.EventID != "" {
+			fmt.Fprintf(&sb, " (%s)", e.EventID)
+		}
+	}
+	return sb.String()
+}
+
+// handleModLog implements /bot modlog [n] for admins and users allowed to
+// kick in the room.
+func (app *App) handleModLog(ctx context.Context, ev *event.Event, args, label string) {
+	if !app.isAdmin(ev.Sender) {
+		pl, err := bot.FetchPowerLevels(ctx, app.Client, ev.RoomID)
+		if err != nil || pl.GetUserLevel(ev.Sender) < bot.RequiredPowerLevel(pl, "kick") {
+			SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"you don't have permission to view the moderation log here", "modlog")
+			return
+		}
+	}
+	n, err := ParseOopsCount(args)
+	if err != nil {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"usage: /bot modlog [n] ("+err.Error()+")", "modlog")
+		return
+	}
+	if args == "" {
+		n = 10
+	}
+	entries, err := db.ModLog(app.MessagesDB, string(ev.RoomID), n)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load moderation log")
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"couldn't load the moderation log", "modlog")
+		return
+	}
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+FormatModLog(entries), "modlog")
+}
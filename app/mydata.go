@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
+)
+
+// DataDeletions holds /bot mydata delete requests awaiting confirmation:
+// the requester, keyed by the confirmation prompt.
+type DataDeletions = bot.ConversationState[id.EventID, id.UserID]
+
+// dataDeletionTTL is how long a deletion request waits for confirmation.
+const dataDeletionTTL = 5 * time.Minute
+
+// NewDataDeletions creates an empty DataDeletions.
+func NewDataDeletions() *DataDeletions {
+	return bot.NewConversationState[id.EventID, id.UserID](dataDeletionTTL)
+}
+
+// userDataExport is the JSON file /bot mydata export sends.
+type userDataExport struct {
+	UserID     string                      `json:"user_id"`
+	ExportedAt string                      `json:"exported_at"`
+	Data       map[string][]map[string]any `json:"data"`
+}
+
+// handleMyData implements the mydata builtin, which lets anyone get or
+// erase what the bot stores about them:
+//
+//	export   DM the requester a JSON file of their stored data
+//	delete   delete it, after they confirm by replying "yes"
+func (app *App) handleMyData(ctx context.Context, ev *event.Event, args, cmd, label string) {
+	reply := func(body string) { SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+body, cmd) }
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "export":
+		app.exportMyData(ctx, ev, cmd, label)
+	case "delete":
+		if app.DataDeletions == nil {
+			reply("deleting data isn't available here")
+			return
+		}
+		body := fmt.Sprintf("%sreply \"yes\" to confirm: delete your messages, links, reactions, quotes, scores and AI history in every room. this can't be undone; /bot %s export first if you want a copy", label, cmd)
+		promptID, err := matrix.SendReply(ctx, app.Client, ev.RoomID, ev.ID, body, "")
+		if err != nil {
+			log.Error().Err(err).Str("cmd", cmd).Msg("failed to send data deletion confirmation")
+			return
+		}
+		app.DataDeletions.Set(promptID, ev.Sender)
+	default:
+		reply(fmt.Sprintf("usage: /bot %s export | /bot %s delete", cmd, cmd))
+	}
+}
+
+// exportMyData sends the requester a direct message with a JSON file of
+// everything the bot stores about them.
+func (app *App) exportMyData(ctx context.Context, ev *event.Event, cmd, label string) {
+	reply := func(body string) { SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+body, cmd) }
+	data, err := db.ExportUserData(app.MessagesDB, string(ev.Sender))
+	if err != nil {
+		log.Error().Err(err).Str("user", string(ev.Sender)).Msg("failed to export user data")
+		reply("couldn't export your data")
+		return
+	}
+	file, err := json.MarshalIndent(userDataExport{
+		UserID:     string(ev.Sender),
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Data:       data,
+	}, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Str("user", string(ev.Sender)).Msg("failed to encode user data")
+		reply("couldn't export your data")
+		return
+	}
+
+	dm, err := app.dmRoom(ctx, ev.Sender)
+	if err != nil {
+		log.Error().Err(err).Str("user", string(ev.Sender)).Msg("failed to start data export dm")
+		reply("couldn't send you a direct message")
+		return
+	}
+	intro := event.MessageEventContent{MsgType: event.MsgText, Body: label + "here's everything this bot stores about you"}
+	if _, err := app.Client.SendMessageEvent(ctx, dm, event.EventMessage, &intro); err != nil {
+		log.Error().Err(err).Str("user", string(ev.Sender)).Msg("failed to send data export dm")
+		reply("couldn't send you a direct message")
+		return
+	}
+	// Exports don't count against the DM's media quota.
+	name := fmt.Sprintf("mydata-%s.json", ev.Sender.Localpart())
+	err = matrix.SendAttachment(matrix.WithQuotaOverride(ctx), app.Client, dm, "", file, "application/json", name)
+	if errors.Is(err, matrix.ErrFileTooLarge) {
+		reply("your data is larger than the homeserver allows uploading; ask a bot admin for it")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("user", string(ev.Sender)).Msg("failed to send data export")
+		reply("couldn't send you your data")
+		return
+	}
+	log.Info().Str("user", string(ev.Sender)).Int("bytes", len(file)).Msg("exported user data")
+	reply("sent you a direct message with your data")
+}
+
+// confirmDataDeletion deletes the requester's data if they replied "yes" to
+// the confirmation prompt, and cancels the request otherwise.
+func (app *App) confirmDataDeletion(ctx context.Context, ev *event.Event, body string, promptID id.EventID) {
+	app.DataDeletions.Delete(promptID)
+	label := ResolveReplyLabel(app.Cfg, app.botConfig())
+	if !bot.IsConfirmation(body) {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"cancelled", "mydata")
+		return
+	}
+	messages, err := db.DeleteUserData(app.MessagesDB, string(ev.Sender))
+	if err != nil {
+		log.Error().Err(err).Str("user", string(ev.Sender)).Msg("failed to delete user data")
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"couldn't delete your data", "mydata")
+		return
+	}
+	log.Info().Str("user", string(ev.Sender)).Int64("messages", messages).Msg("deleted user data")
+	// Rewrite the links export now, whatever EXPORT_MODE is, so it doesn't
+	// keep the deleted links.
+	if app.Links != nil {
+		app.Links.Invalidate()
+	}
+	if app.Cfg.LinksPath != "" {
+		if err := app.exportNow(); err != nil {
+			log.Error().Err(err).Str("user", string(ev.Sender)).Msg("failed to export links after deleting user data")
+		}
+	}
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%sdeleted %d message(s) and the rest of your data. messages you send from now on are stored again", label, messages), "mydata")
+}
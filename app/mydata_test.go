@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestMyData(t *testing.T) {
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	for _, q := range []string{
+		`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype, raw_json) VALUES ('$a1', '!room:example.com', '@alice:example.com', 1, 'see https://example.com', 'm.text', '{"body":"see https://example.com"}')`,
+		`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype, raw_json) VALUES ('$b1', '!room:example.com', '@bob:example.com', 2, 'nice', 'm.text', '{}')`,
+		`INSERT INTO links(message_id, url, idx, ts_ms) VALUES ('$a1', 'https://example.com', 0, 1)`,
+		`INSERT INTO links(message_id, url, idx, ts_ms) VALUES ('$b1', 'https://example.org', 0, 2)`,
+		`INSERT INTO reactions(message_id, room_id, emoji, reactor, created_at_ms) VALUES ('$b1', '!room:example.com', '👍', '@alice:example.com', 3)`,
+		`INSERT INTO reactions(message_id, room_id, emoji, reactor, created_at_ms) VALUES ('$a1', '!room:example.com', '🔥', '@bob:example.com', 3)`,
+		`INSERT INTO reactions(message_id, room_id, emoji, reactor, created_at_ms) VALUES ('$b1', '!room:example.com', '😂', '@carol:example.com', 3)`,
+		`INSERT INTO game_scores(room_id, user_id, game, points, ts_ms) VALUES ('!room:example.com', '@alice:example.com', 'yapguess', 3, 4)`,
+		`INSERT INTO game_scores(room_id, user_id, game, points, ts_ms) VALUES ('!room:example.com', '@bob:example.com', 'yapguess', 1, 4)`,
+		`INSERT INTO ai_keys(user_id, sealed, ts_ms) VALUES ('@alice:example.com', 'secret', 5)`,
+		`INSERT INTO mod_audit(room_id, actor, action, target, ts_ms) VALUES ('!room:example.com', '@admin:example.com', 'mute', '@alice:example.com', 6)`,
+	} {
+		if _, err := messagesDB.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var replies []string
+	var upload []byte
+	var file *event.MessageEventContent
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/createRoom"):
+			fmt.Fprint(w, `{"room_id":"!dm:example.com"}`)
+		case strings.HasSuffix(r.URL.Path, "/account_data/m.direct"):
+			if r.Method == http.MethodGet {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"errcode":"M_NOT_FOUND"}`)
+				return
+			}
+			fmt.Fprint(w, `{}`)
+		case strings.HasSuffix(r.URL.Path, "/upload"):
+			upload, _ = io.ReadAll(r.Body)
+			fmt.Fprint(w, `{"content_uri":"mxc://example.com/export"}`)
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/send/"):
+			var content event.MessageEventContent
+			if json.NewDecoder(r.Body).Decode(&content) == nil {
+				if content.MsgType == event.MsgFile {
+					file = &content
+				} else {
+					replies = append(replies, content.Body)
+				}
+			}
+			fmt.Fprint(w, `{"event_id":"$reply"}`)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	linksPath := filepath.Join(t.TempDir(), "links.json")
+	cfg := &config.Config{LinksPath: linksPath, RoomIDs: []config.RoomIDEntry{{ID: "!room:example.com", Comment: "lounge"}}}
+	snapshot := db.NewLinkSnapshot()
+	a := &App{
+		Cfg:           cfg,
+		Client:        client,
+		MessagesDB:    messagesDB,
+		DataDeletions: NewDataDeletions(),
+		Exporter:      NewExporter(ExportOnDemand, 0, 0, LinkExporter(messagesDB, cfg, snapshot)),
+		Links:         snapshot,
+	}
+	ev := &event.Event{ID: "$cmd", RoomID: "!room:example.com", Sender: "@alice:example.com"}
+	exported := func() string {
+		t.Helper()
+		data, err := os.ReadFile(linksPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if err := a.exportNow(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(exported(), "https://example.com") {
+		t.Fatalf("links export = %s", exported())
+	}
+
+	a.handleMyData(context.Background(), ev, "export", "mydata", "")
+	if len(replies) != 2 || replies[1] != "sent you a direct message with your data" {
+		t.Fatalf("replies = %q", replies)
+	}
+	if file == nil || file.FileName != "mydata-alice.json" || file.Info.MimeType != "application/json" || file.RelatesTo != nil {
+		t.Fatalf("file = %+v", file)
+	}
+	var export userDataExport
+	if err := json.Unmarshal(upload, &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.UserID != "@alice:example.com" || len(export.Data["messages"]) != 1 || len(export.Data["links"]) != 1 ||
+		len(export.Data["reactions"]) != 1 || len(export.Data["game_scores"]) != 1 || len(export.Data["ai_keys"]) != 1 {
+		t.Errorf("export = %s", upload)
+	}
+	if raw, _ := json.Marshal(export.Data["messages"][0]["raw_json"]); string(raw) != `{"body":"see https://example.com"}` {
+		t.Errorf("raw_json = %s", raw)
+	}
+	if strings.Contains(string(upload), "secret") || strings.Contains(string(upload), "@bob") {
+		t.Errorf("export has the sealed key or someone else's data: %s", upload)
+	}
+
+	// Anything but "yes" cancels.
+	a.handleMyData(context.Background(), ev, "delete", "mydata", "")
+	if !strings.Contains(replies[len(replies)-1], `reply "yes" to confirm`) {
+		t.Fatalf("prompt = %q", replies[len(replies)-1])
+	}
+	if user, ok := a.DataDeletions.Get("$reply"); !ok || user != ev.Sender {
+		t.Fatalf("pending deletion = %q, %v", user, ok)
+	}
+	a.confirmDataDeletion(context.Background(), ev, "no", "$reply")
+	if replies[len(replies)-1] != "> cancelled" {
+		t.Errorf("cancel reply = %q", replies[len(replies)-1])
+	}
+	a.handleMyData(context.Background(), ev, "delete", "mydata", "")
+	a.confirmDataDeletion(context.Background(), ev, "yes", "$reply")
+	if got := replies[len(replies)-1]; !strings.HasPrefix(got, "> deleted 1 message(s)") {
+		t.Errorf("delete reply = %q", got)
+	}
+	if _, ok := a.DataDeletions.Get("$reply"); ok {
+		t.Error("deletion still pending")
+	}
+
+	for _, c := range []struct {
+		query string
+		want  int
+	}{
+		{`SELECT COUNT(*) FROM messages WHERE sender = '@alice:example.com'`, 0},
+		{`SELECT COUNT(*) FROM links`, 1},
+		{`SELECT COUNT(*) FROM reactions`, 1}, // carol's on bob's message
+		{`SELECT COUNT(*) FROM game_scores`, 1},
+		{`SELECT COUNT(*) FROM ai_keys`, 0},
+		{`SELECT COUNT(*) FROM messages`, 1},
+		{`SELECT COUNT(*) FROM mod_audit`, 1},
+	} {
+		var n int
+		if err := messagesDB.QueryRow(c.query).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != c.want {
+			t.Errorf("%s = %d, want %d", c.query, n, c.want)
+		}
+	}
+
+	// The links export no longer has alice's link, even on demand.
+	if got := exported(); strings.Contains(got, "https://example.com\"") || !strings.Contains(got, "https://example.org") {
+		t.Errorf("links export after deletion = %s", got)
+	}
+
+	a.handleMyData(context.Background(), ev, "", "mydata", "")
+	if replies[len(replies)-1] != "usage: /bot mydata export | /bot mydata delete" {
+		t.Errorf("usage = %q", replies[len(replies)-1])
+	}
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/matrix"
+)
+
+// Internal events the bot sends notifications for, when NOTIFICATIONS has a
+// template of the same name.
+const (
+	NotifyStartup    = "startup"    // the bot finished its first sync: user, version, rooms
+	NotifyModeration = "moderation" // a moderation action: room, actor, action, target, reason
+	NotifyFeedError  = "feed_error" // a feed started failing: room, url, error
+)
+
+var (
+	// ErrUnknownNotification is returned for names NOTIFICATIONS doesn't
+	// define.
+	ErrUnknownNotification = errors.New("unknown notification")
+	// ErrNotificationData is returned when a notification's templates fail
+	// on the data given.
+	ErrNotificationData = errors.New("can't render notification")
+)
+
+// RenderNotification executes a notification's text and HTML templates over
+// data. Missing keys render as empty rather than "<no value>".
+func RenderNotification(name string, n config.NotificationTemplate, data map[string]any) (text, html string, err error) {
+	t, err := template.New(name).Option("missingkey=zero").Parse(n.Text)
+	if err != nil {
+		return "", "", fmt.Errorf("text: %w", err)
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", "", fmt.Errorf("text: %w", err)
+	}
+	text = strings.TrimSpace(strings.ReplaceAll(sb.String(), "<no value>", ""))
+	if text == "" {
+		return "", "", errors.New("text rendered empty")
+	}
+	if n.HTML == "" {
+		return text, "", nil
+	}
+	ht, err := htmltemplate.New(name).Option("missingkey=zero").Parse(n.HTML)
+	if err != nil {
+		return "", "", fmt.Errorf("html: %w", err)
+	}
+	sb.Reset()
+	if err := ht.Execute(&sb, data); err != nil {
+		return "", "", fmt.Errorf("html: %w", err)
+	}
+	return text, strings.TrimSpace(sb.String()), nil
+}
+
+// Notify sends the notification name into its room, or into roomID if set.
+func (app *App) Notify(ctx context.Context, name string, roomID id.RoomID, data map[string]any) (id.EventID, error) {
+	n, ok := app.Cfg.Notifications[name]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownNotification, name)
+	}
+	if roomID == "" {
+		roomID = id.RoomID(n.Room)
+	}
+	text, html, err := RenderNotification(name, n, data)
+	if err != nil {
+		return "", fmt.Errorf("%w %s: %w", ErrNotificationData, name, err)
+	}
+	if matrix.HasShortcodes(text) || matrix.HasShortcodes(html) {
+		text, html = matrix.ExpandShortcodes(text, html, matrix.RoomEmoticons(ctx, app.Client, roomID))
+	}
+	content := event.MessageEventContent{MsgType: event.MsgNotice, Body: text}
+	if n.MsgType == "text" {
+		content.MsgType = event.MsgText
+	}
+	if html != "" {
+		content.Format = event.FormatHTML
+		content.FormattedBody = html
+	}
+	resp, err := app.Client.SendMessageEvent(ctx, roomID, event.EventMessage, &content)
+	if err != nil {
+		return "", err
+	}
+	log.Info().Str("notification", name).Str("room", string(roomID)).Msg("sent notification")
+	return resp.EventID, nil
+}
+
+// notifyEvent sends the notification for an internal event in the
+// background, if one is configured.
+func (app *App) notifyEvent(ctx context.Context, name string, data map[string]any) {
+	if app.Cfg == nil || app.Client == nil || app.Cfg.ReadOnly {
+		return
+	}
+	if _, ok := app.Cfg.Notifications[name]; !ok {
+		return
+	}
+	go func() {
+		if _, err := app.Notify(context.WithoutCancel(ctx), name, "", data); err != nil {
+			log.Warn().Err(err).Str("notification", name).Msg("failed to send notification")
+		}
+	}()
+}
+
+// NotifyStarted sends the startup notification.
+func (app *App) NotifyStarted(ctx context.Context, version string) {
+	var rooms []string
+	for _, r := range app.Cfg.RoomIDs {
+		rooms = append(rooms, r.Comment)
+	}
+	app.notifyEvent(ctx, NotifyStartup, map[string]any{
+		"user":    string(app.botUserID()),
+		"version": version,
+		"rooms":   rooms,
+	})
+}
+
+type apiNotifyRequest struct {
+	Room string         `json:"room"`
+	Data map[string]any `json:"data"`
+}
+
+// apiNotify serves POST /api/notify/{name} {"room","data"}.
+func (app *App) apiNotify(w http.ResponseWriter, r *http.Request) {
+	if app.Cfg.ReadOnly {
+		writeAPIError(w, http.StatusForbidden, "bot is read-only")
+		return
+	}
+	var req apiNotifyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, inboundHookMaxBytes)).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+	}
+	if req.Room != "" {
+		if _, ok := app.findRoom(id.RoomID(req.Room)); !ok {
+			writeAPIError(w, http.StatusBadRequest, "room is not monitored")
+			return
+		}
+	}
+	eventID, err := app.Notify(r.Context(), r.PathValue("name"), id.RoomID(req.Room), req.Data)
+	switch {
+	case errors.Is(err, ErrUnknownNotification):
+		writeAPIError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrNotificationData):
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		log.Error().Err(err).Str("notification", r.PathValue("name")).Msg("failed to send notification")
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+	default:
+		writeAPIJSON(w, http.StatusOK, map[string]string{"event_id": string(eventID)})
+	}
+}
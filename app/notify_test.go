@@ -0,0 +1,94 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+
+	"github.com/polarhive/ash/config"
+)
+
+func TestRenderNotification(t *testing.T) {
+	n := config.NotificationTemplate{
+		Text: "{{.service}} {{.version}} deployed{{if .by}} by {{.by}}{{end}}",
+		HTML: "<b>{{.service}}</b> {{.version}} deployed",
+	}
+	text, html, err := RenderNotification("deployment_done", n, map[string]any{"service": "api", "version": "<v2>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "api <v2> deployed" {
+		t.Errorf("text = %q", text)
+	}
+	if html != "<b>api</b> &lt;v2&gt; deployed" {
+		t.Errorf("html = %q", html)
+	}
+	if text, _, _ := RenderNotification("x", config.NotificationTemplate{Text: "[{{.missing}}]"}, nil); text != "[]" {
+		t.Errorf("missing key text = %q", text)
+	}
+	if _, _, err := RenderNotification("x", config.NotificationTemplate{Text: "{{.a.b.c}}"}, map[string]any{"a": 1}); err == nil {
+		t.Error("expected an error for a bad field chain")
+	}
+}
+
+func TestAPINotify(t *testing.T) {
+	var sent []map[string]any
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var content map[string]any
+		json.NewDecoder(r.Body).Decode(&content)
+		content["path"] = r.URL.Path
+		sent = append(sent, content)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"event_id":"$note"}`)
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{
+		Cfg: &config.Config{
+			RoomIDs: []config.RoomIDEntry{{ID: "!room:example.com", Comment: "lounge"}},
+			Notifications: config.NotificationsConfig{
+				"deployment_done": {Room: "!ops:example.com", Text: "{{.service}} deployed", HTML: "<b>{{.service}}</b> deployed"},
+			},
+		},
+		Client: client,
+	}
+	srv := httptest.NewServer(a.AdminHandler("secret-token-1234"))
+	defer srv.Close()
+	post := func(path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret-token-1234")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post("/api/notify/deployment_done", `{"data": {"service": "api"}}`); code != http.StatusOK {
+		t.Fatalf("notify: status %d", code)
+	}
+	if code := post("/api/notify/deployment_done", `{"room": "!room:example.com", "data": {"service": "web"}}`); code != http.StatusOK {
+		t.Fatalf("notify into room: status %d", code)
+	}
+	if len(sent) != 2 || sent[0]["body"] != "api deployed" || sent[0]["formatted_body"] != "<b>api</b> deployed" ||
+		sent[0]["msgtype"] != "m.notice" || !strings.Contains(sent[0]["path"].(string), "!ops:example.com") ||
+		!strings.Contains(sent[1]["path"].(string), "!room:example.com") {
+		t.Fatalf("sent %v", sent)
+	}
+	if code := post("/api/notify/nope", `{}`); code != http.StatusNotFound {
+		t.Errorf("unknown notification: status %d", code)
+	}
+	if code := post("/api/notify/deployment_done", `{"room": "!other:example.com"}`); code != http.StatusBadRequest {
+		t.Errorf("unmonitored room: status %d", code)
+	}
+}
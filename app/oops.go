@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/locale"
+)
+
+// maxOops caps how many messages a single /bot oops may redact.
+const maxOops = 20
+
+// ParseOopsCount parses the optional message count for /bot oops.
+func ParseOopsCount(args string) (int, error) {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("count must be a positive number")
+	}
+	return min(n, maxOops), nil
+}
+
+// handleOops redacts the bot's last n messages in the room.
+func (app *App) handleOops(ctx context.Context, ev *event.Event, msgData *db.MessageData, label string) {
+	if !app.isAdmin(ev.Sender) {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+locale.ForRoom(string(ev.RoomID), "admin_only"), "oops")
+		return
+	}
+	var args string
+	if parts := strings.Fields(msgData.Msg.Body); len(parts) > 2 {
+		args = strings.Join(parts[2:], " ")
+	}
+	n, err := ParseOopsCount(args)
+	if err != nil {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"usage: /bot oops [n] ("+err.Error()+")", "oops")
+		return
+	}
+	ids, err := db.RecentSentMessages(app.MessagesDB, string(ev.RoomID), n)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load sent messages")
+		return
+	}
+	redacted := 0
+	for _, evID := range ids {
+		if _, err := app.Client.RedactEvent(ctx, ev.RoomID, id.EventID(evID), mautrix.ReqRedact{Reason: "requested by " + string(ev.Sender)}); err != nil {
+			log.Warn().Err(err).Str("event", evID).Msg("failed to redact own message")
+			continue
+		}
+		app.audit(ev.RoomID, ev.Sender, "redact", app.botUserID(), "oops", id.EventID(evID))
+		if err := db.DeleteSentMessage(app.MessagesDB, evID); err != nil {
+			log.Warn().Err(err).Str("event", evID).Msg("failed to forget redacted message")
+		}
+		redacted++
+	}
+	log.Info().Str("actor", string(ev.Sender)).Int("count", redacted).Msg("redacted own messages")
+	if redacted == 0 {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"nothing to redact", "oops")
+	}
+}
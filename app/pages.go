@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/locale"
+	"github.com/polarhive/ash/matrix"
+)
+
+// sendCommandOutput replies to ev with a command's text output, split into
+// pages when it's longer than REPLY_PAGE_CHARS or a Matrix event allows.
+// Only the first page is posted; the rest wait for /bot more or a
+// NextPageEmoji reaction.
+func (app *App) sendCommandOutput(ctx context.Context, ev *event.Event, cmdCfg *bot.BotCommand, label, output, cmd string) {
+	pages := bot.Paginate(output, app.Cfg.ReplyPageChars)
+	if len(pages) == 0 {
+		return
+	}
+	p := &bot.ReplyPages{Cmd: cmdCfg, Label: label, Pages: pages, Lang: locale.Language(string(ev.RoomID))}
+	// Static responses may use :shortcode:s, including the room's custom
+	// emoticons.
+	if cmdCfg.Response != "" && matrix.HasShortcodes(output) {
+		p.Shortcodes = true
+		p.Emotes = matrix.RoomEmoticons(ctx, app.Client, ev.RoomID)
+	}
+	app.postPage(ctx, ev.RoomID, ev.ID, p, cmd)
+}
+
+// postPage posts p's next page as a reply to replyTo and keeps the rest for
+// later, if there is any.
+func (app *App) postPage(ctx context.Context, roomID id.RoomID, replyTo id.EventID, p *bot.ReplyPages, cmd string) {
+	body, formatted := p.Render()
+	pageID, err := matrix.SendReply(ctx, app.Client, roomID, replyTo, body, formatted)
+	if err != nil {
+		log.Error().Err(err).Str("cmd", cmd).Int("page", p.Next+1).Msg("failed to send response")
+		return
+	}
+	log.Info().Str("cmd", cmd).Int("page", p.Next+1).Int("pages", len(p.Pages)).Msg("sent bot response")
+	p.Next++
+	if p.Next < len(p.Pages) && app.Pages != nil {
+		app.Pages.Add(roomID, pageID, p)
+	}
+}
+
+// handleMore posts the next page of the paged reply being replied to, or
+// else of the room's latest one.
+func (app *App) handleMore(ctx context.Context, ev *event.Event, msgData *db.MessageData, label string) {
+	var pageID id.EventID
+	if rel := msgData.Msg.RelatesTo; rel != nil && rel.InReplyTo != nil {
+		pageID = rel.InReplyTo.EventID
+	}
+	var p *bot.ReplyPages
+	ok := false
+	if app.Pages != nil {
+		p, ok = app.Pages.Take(ev.RoomID, pageID)
+	}
+	if !ok {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+locale.ForRoom(string(ev.RoomID), "nothing_more"), "more")
+		return
+	}
+	app.postPage(ctx, ev.RoomID, ev.ID, p, "more")
+}
+
+// postNextPage posts the next page of a paged reply when someone reacts to
+// its last page with NextPageEmoji.
+func (app *App) postNextPage(ctx context.Context, roomID id.RoomID, pageID id.EventID) {
+	if p, ok := app.Pages.Take(roomID, pageID); ok {
+		app.postPage(ctx, roomID, pageID, p, "more")
+	}
+}
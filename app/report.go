@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
+)
+
+// FormatReport builds the notice forwarded to the moderation room.
+func FormatReport(roomName string, reporter, author id.UserID, body, link, reason string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "report from %s in %s\n", reporter, roomName)
+	fmt.Fprintf(&sb, "author: %s\n", author)
+	fmt.Fprintf(&sb, "message: %s\n", body)
+	fmt.Fprintf(&sb, "link: %s", link)
+	if reason != "" {
+		fmt.Fprintf(&sb, "\nreason: %s", reason)
+	}
+	return sb.String()
+}
+
+// handleReport forwards the replied-to message to MOD_ROOM_ID and
+// acknowledges the reporter by direct message rather than in the room.
+func (app *App) handleReport(ctx context.Context, ev *event.Event, msgData *db.MessageData, roomName, label string) {
+	if msgData.Msg.RelatesTo == nil || msgData.Msg.RelatesTo.InReplyTo == nil {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"usage: reply to a message with /bot report [reason]", "report")
+		return
+	}
+	if app.Cfg.ModRoomID == "" {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"no moderation room is configured", "report")
+		return
+	}
+	targetID := msgData.Msg.RelatesTo.InReplyTo.EventID
+	original, err := matrix.FetchAndDecrypt(ctx, app.Client, ev.RoomID, targetID)
+	if err != nil {
+		log.Error().Err(err).Str("event", string(targetID)).Msg("failed to fetch reported message")
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"couldn't fetch that message", "report")
+		return
+	}
+	var body string
+	if om := original.Content.AsMessage(); om != nil {
+		body = om.Body
+	}
+	var reason string
+	if parts := strings.Fields(msgData.Msg.Body); len(parts) > 2 {
+		reason = strings.Join(parts[2:], " ")
+	}
+
+	app.notifyModRoom(ctx, label+FormatReport(roomName, ev.Sender, original.Sender, body, matrix.Permalink(ev.RoomID, targetID), reason))
+	log.Info().Str("room", roomName).Str("reporter", string(ev.Sender)).Str("author", string(original.Sender)).Msg("message reported")
+	app.audit(ev.RoomID, ev.Sender, "report", original.Sender, reason, targetID)
+	if err := app.sendDM(ctx, ev.Sender, label+"thanks, your report was forwarded to the moderators"); err != nil {
+		log.Error().Err(err).Str("user", string(ev.Sender)).Msg("failed to acknowledge report")
+	}
+}
+
+// dmRoom returns the bot's direct chat with the user: the newest room
+// listed for them in the bot's m.direct account data that both are still
+// in, or else a new one, which is recorded in m.direct.
+func (app *App) dmRoom(ctx context.Context, userID id.UserID) (id.RoomID, error) {
+	app.dmMu.Lock()
+	defer app.dmMu.Unlock()
+	direct := event.DirectChatsEventContent{}
+	if err := app.Client.GetAccountData(ctx, event.AccountDataDirectChats.Type, &direct); err != nil && !errors.Is(err, mautrix.MNotFound) {
+		log.Warn().Err(err).Msg("failed to read m.direct")
+	}
+	for _, roomID := range slices.Backward(direct[userID]) {
+		if app.inDM(ctx, roomID, userID) {
+			return roomID, nil
+		}
+	}
+
+	resp, err := app.Client.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+		Invite:   []id.UserID{userID},
+		IsDirect: true,
+		Preset:   "trusted_private_chat",
+	})
+	if err != nil {
+		return "", fmt.Errorf("create dm: %w", err)
+	}
+	direct[userID] = append(direct[userID], resp.RoomID)
+	if err := app.Client.SetAccountData(ctx, event.AccountDataDirectChats.Type, direct); err != nil {
+		log.Warn().Err(err).Str("user", string(userID)).Msg("failed to record dm in m.direct")
+	}
+	return resp.RoomID, nil
+}
+
+// inDM reports whether the bot has joined roomID and the user is joined or
+// invited there.
+func (app *App) inDM(ctx context.Context, roomID id.RoomID, userID id.UserID) bool {
+	var bot, user event.MemberEventContent
+	if err := app.Client.StateEvent(ctx, roomID, event.StateMember, string(app.Client.UserID), &bot); err != nil || bot.Membership != event.MembershipJoin {
+		return false
+	}
+	if err := app.Client.StateEvent(ctx, roomID, event.StateMember, string(userID), &user); err != nil {
+		return false
+	}
+	return user.Membership == event.MembershipJoin || user.Membership == event.MembershipInvite
+}
+
+// sendDM sends the user a message in their direct chat with the bot.
+func (app *App) sendDM(ctx context.Context, userID id.UserID, body string) error {
+	roomID, err := app.dmRoom(ctx, userID)
+	if err != nil {
+		return err
+	}
+	content := event.MessageEventContent{MsgType: event.MsgText, Body: body}
+	if _, err := app.Client.SendMessageEvent(ctx, roomID, event.EventMessage, &content); err != nil {
+		return fmt.Errorf("send dm: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+// Command is a parsed bot command as it passes through the Router.
+type Command struct {
+	App   *App
+	Event *event.Event
+	Msg   *db.MessageData
+	Room  config.RoomIDEntry
+	Name  string
+	Args  string
+	Label string
+}
+
+// Reply sends a labelled reply to the message that invoked the command.
+func (c *Command) Reply(ctx context.Context, body string) {
+	SendBotReply(ctx, c.App.Client, c.Event.RoomID, c.Event.ID, c.Label+body, c.Name)
+}
+
+// Handler runs a bot command.
+type Handler func(ctx context.Context, cmd *Command)
+
+// Middleware wraps a Handler, e.g. to log, rate limit or reject commands.
+type Middleware func(next Handler) Handler
+
+// Router is the bot command dispatch pipeline. Programs embedding ash can
+// register Go-native commands with Handle alongside the bot.json ones, and
+// wrap every command with Use.
+type Router struct {
+	mu         sync.RWMutex
+	middleware []Middleware
+	handlers   map[string]Handler
+}
+
+// NewRouter creates an empty Router.
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]Handler)}
+}
+
+// Use appends middleware. The first middleware added runs outermost.
+func (r *Router) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Handle registers a Go-native command. It takes precedence over a bot.json
+// command with the same name.
+func (r *Router) Handle(name string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[name] = h
+}
+
+// Lookup returns the Go-native handler registered for a command.
+func (r *Router) Lookup(name string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[name]
+	return h, ok
+}
+
+// Names returns the registered Go-native command names in sorted order.
+func (r *Router) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Serve runs the command through the middleware chain, using the registered
+// handler for cmd.Name or fallback if there is none.
+func (r *Router) Serve(ctx context.Context, cmd *Command, fallback Handler) {
+	h, ok := r.Lookup(cmd.Name)
+	if !ok {
+		h = fallback
+	}
+	r.mu.RLock()
+	mw := append([]Middleware(nil), r.middleware...)
+	r.mu.RUnlock()
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	h(ctx, cmd)
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	var trace []string
+	r.Use(func(next Handler) Handler {
+		return func(ctx context.Context, cmd *Command) {
+			trace = append(trace, "outer")
+			next(ctx, cmd)
+		}
+	}, func(next Handler) Handler {
+		return func(ctx context.Context, cmd *Command) {
+			trace = append(trace, "inner")
+			if cmd.Args == "blocked" {
+				return
+			}
+			next(ctx, cmd)
+		}
+	})
+	r.Handle("ping", func(ctx context.Context, cmd *Command) { trace = append(trace, "ping") })
+	fallback := func(ctx context.Context, cmd *Command) { trace = append(trace, "fallback:"+cmd.Name) }
+
+	tests := []struct {
+		cmd  *Command
+		want string
+	}{
+		{&Command{Name: "ping"}, "outer,inner,ping"},
+		{&Command{Name: "deepfry"}, "outer,inner,fallback:deepfry"},
+		{&Command{Name: "ping", Args: "blocked"}, "outer,inner"},
+	}
+	for _, tt := range tests {
+		trace = nil
+		r.Serve(context.Background(), tt.cmd, fallback)
+		if got := strings.Join(trace, ","); got != tt.want {
+			t.Errorf("Serve(%s %q) = %s, want %s", tt.cmd.Name, tt.cmd.Args, got, tt.want)
+		}
+	}
+
+	if names := r.Names(); len(names) != 1 || names[0] != "ping" {
+		t.Errorf("Names() = %v, want [ping]", names)
+	}
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
+)
+
+const (
+	// sedMaxPattern bounds the length of a correction's pattern and
+	// replacement.
+	sedMaxPattern = 200
+	// sedLookback is how many of the sender's latest messages a correction
+	// is tried against.
+	sedLookback = 20
+	// sedMaxOutput bounds the corrected text posted.
+	sedMaxOutput = 1000
+)
+
+// Substitution is a parsed sed-style correction, s/find/replace/flags.
+type Substitution struct {
+	Find    *regexp.Regexp
+	Replace string // in regexp.Expand syntax
+	Global  bool
+}
+
+// ParseSubstitution parses a message like "s/typo/fix/" into a
+// Substitution. find is a regular expression; in replace, & is the whole
+// match and \1 to \9 are groups, as in sed. A / inside either is written
+// \/, and the trailing / may be left out. The flags are g to replace every
+// match rather than the first and i to ignore case.
+func ParseSubstitution(body string) (*Substitution, bool) {
+	body = strings.TrimSpace(body)
+	rest, ok := strings.CutPrefix(body, "s/")
+	if !ok || strings.ContainsRune(body, '\n') {
+		return nil, false
+	}
+	var parts []string
+	var cur strings.Builder
+	for i := 0; i < len(rest); i++ {
+		switch {
+		case rest[i] == '\\' && i+1 < len(rest) && rest[i+1] == '/':
+			cur.WriteByte('/')
+			i++
+		case rest[i] == '/':
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(rest[i])
+		}
+	}
+	parts = append(parts, cur.String())
+	if len(parts) == 2 {
+		parts = append(parts, "")
+	}
+	if len(parts) != 3 || parts[0] == "" || len(parts[0]) > sedMaxPattern || len(parts[1]) > sedMaxPattern {
+		return nil, false
+	}
+	find, replace, flags := parts[0], parts[1], parts[2]
+
+	s := &Substitution{Replace: sedReplacement(replace)}
+	for _, f := range flags {
+		switch f {
+		case 'g':
+			s.Global = true
+		case 'i':
+			find = "(?i)" + find
+		default:
+			return nil, false
+		}
+	}
+	re, err := regexp.Compile(find)
+	if err != nil {
+		return nil, false
+	}
+	s.Find = re
+	return s, true
+}
+
+// sedReplacement turns a sed replacement into regexp.Expand syntax.
+func sedReplacement(replace string) string {
+	var b strings.Builder
+	for i := 0; i < len(replace); i++ {
+		c := replace[i]
+		switch {
+		case c == '\\' && i+1 < len(replace) && replace[i+1] >= '0' && replace[i+1] <= '9':
+			fmt.Fprintf(&b, "${%c}", replace[i+1])
+			i++
+		case c == '\\' && i+1 < len(replace) && (replace[i+1] == '&' || replace[i+1] == '\\'):
+			b.WriteByte(replace[i+1])
+			i++
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Apply returns text with the first match, or every match with g, replaced,
+// and whether anything matched.
+func (s *Substitution) Apply(text string) (string, bool) {
+	if s.Global {
+		if !s.Find.MatchString(text) {
+			return "", false
+		}
+		return s.Find.ReplaceAllString(text, s.Replace), true
+	}
+	m := s.Find.FindStringSubmatchIndex(text)
+	if m == nil {
+		return "", false
+	}
+	out := s.Find.ExpandString([]byte(text[:m[0]]), s.Replace, text, m)
+	return string(out) + text[m[1]:], true
+}
+
+// correctMessage applies a sed-style correction to the sender's latest
+// message it matches and posts the result, in rooms with sedCorrections.
+// Nothing is posted when none of their recent messages match.
+func (app *App) correctMessage(ctx context.Context, ev *event.Event, sub *Substitution) {
+	recent, err := db.RecentMessagesBy(app.MessagesDB, string(ev.RoomID), string(ev.Sender), string(ev.ID), sedLookback)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load messages to correct")
+		return
+	}
+	for _, m := range recent {
+		if _, ok := ParseSubstitution(m.Body); ok {
+			continue
+		}
+		corrected, ok := sub.Apply(m.Body)
+		if !ok || corrected == m.Body {
+			continue
+		}
+		name := matrix.DisplayName(ctx, app.Client, ev.RoomID, ev.Sender)
+		if name == "" {
+			name = ev.Sender.Localpart()
+		}
+		if r := []rune(corrected); len(r) > sedMaxOutput {
+			corrected = string(r[:sedMaxOutput]) + "…"
+		}
+		label := ResolveReplyLabel(app.Cfg, app.botConfig())
+		body := fmt.Sprintf("%s%s meant: %s", label, name, corrected)
+		SendBotReply(ctx, app.Client, ev.RoomID, id.EventID(m.ID), body, "sed")
+		return
+	}
+	log.Debug().Str("sender", string(ev.Sender)).Msg("correction matched none of the sender's recent messages")
+}
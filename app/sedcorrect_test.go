@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestSubstitution(t *testing.T) {
+	tests := []struct {
+		sub, text, want string
+		ok              bool
+	}{
+		{"s/teh/the/", "teh cat and teh dog", "the cat and teh dog", true},
+		{"s/teh/the/g", "teh cat and teh dog", "the cat and the dog", true},
+		{"s/TEH/the/gi", "Teh cat and teh dog", "the cat and the dog", true},
+		{"s/teh/the", "teh cat", "the cat", true},
+		{"s/(\\w+) (\\w+)/\\2 \\1/", "hello world", "world hello", true},
+		{"s/cat/& & \\&/", "a cat", "a cat cat &", true},
+		{"s/cost/$5/", "it cost", "it $5", true},
+		{"s/a\\/b/c\\/d/", "see a/b", "see c/d", true},
+		{"s/dog/cat/", "teh cat", "", false},
+	}
+	for _, tt := range tests {
+		sub, ok := ParseSubstitution(tt.sub)
+		if !ok {
+			t.Errorf("ParseSubstitution(%q) failed", tt.sub)
+			continue
+		}
+		if got, ok := sub.Apply(tt.text); got != tt.want || ok != tt.ok {
+			t.Errorf("%q on %q = %q, %v; want %q, %v", tt.sub, tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	for _, body := range []string{
+		"s/", "s//x/", "s/a/b/c/d", "s/a/b/x", "s/(/x/", "hello s/a/b/", "s/a/b/\nmore", "she said so",
+		"s/" + strings.Repeat("a", sedMaxPattern+1) + "/b/",
+	} {
+		if _, ok := ParseSubstitution(body); ok {
+			t.Errorf("ParseSubstitution(%q) should fail", body)
+		}
+	}
+}
+
+func TestCorrectMessage(t *testing.T) {
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	for _, m := range []struct {
+		id, sender, body string
+		ts               int
+	}{
+		{"$a1", "@alice:example.com", "i love teh rain", 1},
+		{"$a2", "@alice:example.com", "going home now", 2},
+		{"$b1", "@bob:example.com", "teh bus is late", 3},
+		{"$a3", "@alice:example.com", "s/teh/the/", 4},
+	} {
+		if _, err := messagesDB.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, '!room:example.com', ?, ?, ?, 'm.text')`,
+			m.id, m.sender, m.ts, m.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var replies []event.MessageEventContent
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/joined_members"):
+			fmt.Fprint(w, `{"joined":{"@alice:example.com":{"display_name":"Alice"}}}`)
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/send/"):
+			var content event.MessageEventContent
+			if json.NewDecoder(r.Body).Decode(&content) == nil {
+				replies = append(replies, content)
+			}
+			fmt.Fprint(w, `{"event_id":"$reply"}`)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{Cfg: &config.Config{}, Client: client, MessagesDB: messagesDB}
+	ev := &event.Event{ID: "$a3", RoomID: "!room:example.com", Sender: "@alice:example.com"}
+
+	sub, _ := ParseSubstitution("s/teh/the/")
+	a.correctMessage(context.Background(), ev, sub)
+	if len(replies) != 1 {
+		t.Fatalf("got %d replies", len(replies))
+	}
+	if replies[0].Body != "> Alice meant: i love the rain" {
+		t.Errorf("reply = %q", replies[0].Body)
+	}
+	if rel := replies[0].RelatesTo; rel == nil || rel.InReplyTo == nil || rel.InReplyTo.EventID != "$a1" {
+		t.Errorf("reply relates to %+v, want $a1", rel)
+	}
+
+	// Corrections that match none of the sender's messages, such as
+	// someone else's, are ignored.
+	sub, _ = ParseSubstitution("s/bus/train/")
+	a.correctMessage(context.Background(), ev, sub)
+	if len(replies) != 1 {
+		t.Errorf("unexpected reply %q", replies[len(replies)-1].Body)
+	}
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+// SlowMode tracks which rooms have slow mode on and when each user last
+// spoke there.
+type SlowMode struct {
+	mu       sync.Mutex
+	interval map[id.RoomID]time.Duration
+	last     map[floodKey]time.Time
+}
+
+// NewSlowMode creates slow mode state, enabling it for rooms configured with
+// slowMode.enabled.
+func NewSlowMode(rooms []config.RoomIDEntry) *SlowMode {
+	s := &SlowMode{
+		interval: make(map[id.RoomID]time.Duration),
+		last:     make(map[floodKey]time.Time),
+	}
+	for _, r := range rooms {
+		if r.SlowMode != nil && r.SlowMode.Enabled && r.SlowMode.Seconds > 0 {
+			s.interval[id.RoomID(r.ID)] = time.Duration(r.SlowMode.Seconds) * time.Second
+		}
+	}
+	return s
+}
+
+// Enable turns slow mode on for a room.
+func (s *SlowMode) Enable(roomID id.RoomID, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval[roomID] = interval
+}
+
+// Disable turns slow mode off for a room.
+func (s *SlowMode) Disable(roomID id.RoomID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.interval, roomID)
+	for k := range s.last {
+		if k.room == string(roomID) {
+			delete(s.last, k)
+		}
+	}
+}
+
+// Interval returns the room's slow mode interval, or 0 if it is off.
+func (s *SlowMode) Interval(roomID id.RoomID) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interval[roomID]
+}
+
+// Check records a message and reports whether it came too soon after the
+// sender's previous one. Messages that are too soon don't reset the timer.
+func (s *SlowMode) Check(roomID id.RoomID, sender id.UserID, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	interval, ok := s.interval[roomID]
+	if !ok {
+		return false
+	}
+	key := floodKey{string(roomID), string(sender)}
+	if last, ok := s.last[key]; ok && now.Sub(last) < interval {
+		return true
+	}
+	s.last[key] = now
+	return false
+}
+
+// handleSlowModeViolation warns or temporarily mutes a user who posted too
+// soon. Muting falls back to a warning if the bot lacks the power level.
+func (app *App) handleSlowModeViolation(ctx context.Context, ev *event.Event, room config.RoomIDEntry, interval time.Duration) {
+	label := ResolveReplyLabel(app.Cfg, app.botConfig())
+	warning := fmt.Sprintf("%sslow mode is on: one message every %s please", label, interval)
+	if room.SlowMode == nil || room.SlowMode.Action != "mute" {
+		app.audit(ev.RoomID, app.botUserID(), "warn", ev.Sender, "slow mode", ev.ID)
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, warning, "slowmode")
+		return
+	}
+
+	pl, err := bot.FetchPowerLevels(ctx, app.Client, ev.RoomID)
+	if err != nil || pl.GetUserLevel(app.Client.UserID) < bot.RequiredPowerLevel(pl, "mute") || pl.GetUserLevel(ev.Sender) >= pl.GetUserLevel(app.Client.UserID) {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, warning, "slowmode")
+		return
+	}
+	minutes := room.SlowMode.MuteMinutes
+	if minutes <= 0 {
+		minutes = 5
+	}
+	mute := &bot.ModAction{Action: "mute", Target: ev.Sender, Reason: "slow mode", Requester: app.Client.UserID}
+	if err := app.applyModeration(ctx, ev.RoomID, mute, time.Now().Add(time.Duration(minutes)*time.Minute)); err != nil {
+		log.Error().Err(err).Str("target", string(ev.Sender)).Msg("slow mode mute failed")
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, warning, "slowmode")
+		return
+	}
+	app.audit(ev.RoomID, app.Client.UserID, "mute", ev.Sender, "slow mode", ev.ID)
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%smuted for %d minutes for ignoring slow mode", label, minutes), "slowmode")
+}
+
+// muteExpiryInterval is how often RunMuteExpiry looks for expired mutes.
+const muteExpiryInterval = time.Minute
+
+// RunMuteExpiry lifts expired timed mutes, such as slow mode's, every
+// muteExpiryInterval until ctx is cancelled. The mutes are stored, so ones
+// that expired while the bot was down are lifted at startup.
+func (app *App) RunMuteExpiry(ctx context.Context) {
+	ticker := time.NewTicker(muteExpiryInterval)
+	defer ticker.Stop()
+	for {
+		app.liftExpiredMutes(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// liftExpiredMutes restores the previous power level of each user whose
+// timed mute expired by now. A user whose level a moderator changed since
+// the mute is left alone.
+func (app *App) liftExpiredMutes(ctx context.Context, now time.Time) {
+	mutes, err := db.ExpiredMutes(app.MessagesDB, now.UnixMilli())
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to list expired mutes")
+		return
+	}
+	for _, m := range mutes {
+		if ctx.Err() != nil {
+			return
+		}
+		roomID, target := id.RoomID(m.RoomID), id.UserID(m.UserID)
+		pl, err := bot.FetchPowerLevels(ctx, app.Client, roomID)
+		if err != nil {
+			log.Error().Err(err).Str("target", m.UserID).Msg("failed to lift expired mute")
+			continue
+		}
+		if pl.GetUserLevel(target) != m.MuteLevel {
+			log.Info().Str("target", m.UserID).Msg("power level changed since the mute, not restoring it")
+			if err := db.DeleteMute(app.MessagesDB, m.RoomID, m.UserID); err != nil {
+				log.Warn().Err(err).Str("target", m.UserID).Msg("failed to forget mute")
+			}
+			continue
+		}
+		unmute := &bot.ModAction{Action: "unmute", Target: target, Reason: "mute expired", Requester: app.Client.UserID}
+		if err := app.applyModeration(ctx, roomID, unmute, time.Time{}); err != nil {
+			log.Error().Err(err).Str("target", m.UserID).Msg("failed to lift expired mute")
+			continue
+		}
+		app.audit(roomID, app.Client.UserID, "unmute", target, unmute.Reason, "")
+	}
+}
+
+// handleSlowModeCommand implements /bot slowmode [seconds|off] for admins
+// and users allowed to mute, announcing the change in the room.
+func (app *App) handleSlowModeCommand(ctx context.Context, ev *event.Event, msgData *db.MessageData, room config.RoomIDEntry, label string) {
+	if !app.isAdmin(ev.Sender) {
+		pl, err := bot.FetchPowerLevels(ctx, app.Client, ev.RoomID)
+		if err != nil || pl.GetUserLevel(ev.Sender) < bot.RequiredPowerLevel(pl, "mute") {
+			SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"you don't have permission to change slow mode here", "slowmode")
+			return
+		}
+	}
+	var arg string
+	if parts := strings.Fields(msgData.Msg.Body); len(parts) > 2 {
+		arg = parts[2]
+	}
+
+	if arg == "off" {
+		app.SlowMode.Disable(ev.RoomID)
+		app.audit(ev.RoomID, ev.Sender, "slowmode", "", "off", ev.ID)
+		log.Info().Str("room", room.Comment).Str("actor", string(ev.Sender)).Msg("slow mode disabled")
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"slow mode disabled", "slowmode")
+		return
+	}
+	seconds := 30
+	if room.SlowMode != nil && room.SlowMode.Seconds > 0 {
+		seconds = room.SlowMode.Seconds
+	}
+	if arg != "" && arg != "on" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"usage: /bot slowmode [seconds|off]", "slowmode")
+			return
+		}
+		seconds = n
+	}
+	interval := time.Duration(seconds) * time.Second
+	app.SlowMode.Enable(ev.RoomID, interval)
+	app.audit(ev.RoomID, ev.Sender, "slowmode", "", interval.String(), ev.ID)
+	log.Info().Str("room", room.Comment).Str("actor", string(ev.Sender)).Dur("interval", interval).Msg("slow mode enabled")
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, fmt.Sprintf("%sslow mode enabled: one message every %s per person", label, interval), "slowmode")
+}
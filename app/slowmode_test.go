@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestSlowModeCheck(t *testing.T) {
+	const room, other, alice = "!room:example.com", "!other:example.com", "@alice:example.com"
+	s := NewSlowMode([]config.RoomIDEntry{
+		{ID: room, SlowMode: &config.SlowModeConfig{Enabled: true, Seconds: 10}},
+		{ID: other, SlowMode: &config.SlowModeConfig{Seconds: 10}},
+	})
+	now := time.Now()
+
+	if got := s.Interval(room); got != 10*time.Second {
+		t.Fatalf("Interval() = %s, want 10s", got)
+	}
+	if s.Interval(other) != 0 {
+		t.Error("slow mode should start off unless enabled")
+	}
+	if s.Check(room, alice, now) {
+		t.Error("first message should be allowed")
+	}
+	if !s.Check(room, alice, now.Add(5*time.Second)) {
+		t.Error("message within the interval should be flagged")
+	}
+	if s.Check(room, alice, now.Add(11*time.Second)) {
+		t.Error("message after the interval should be allowed")
+	}
+	if s.Check(room, "@bob:example.com", now.Add(11*time.Second)) {
+		t.Error("intervals are tracked per user")
+	}
+
+	s.Disable(room)
+	if s.Check(room, alice, now.Add(12*time.Second)) {
+		t.Error("disabled slow mode should not flag messages")
+	}
+	s.Enable(other, time.Minute)
+	s.Check(other, alice, now)
+	if !s.Check(other, alice, now.Add(30*time.Second)) {
+		t.Error("runtime-enabled slow mode should flag messages")
+	}
+}
+
+func TestLiftExpiredMutes(t *testing.T) {
+	const room = "!room:example.com"
+	const alice, bob, carol = "@alice:example.com", "@bob:example.com", "@carol:example.com"
+	pl := &event.PowerLevelsEventContent{Users: map[id.UserID]int{"@ash:example.com": 100, alice: 10}}
+	a := powerLevelsApp(t, &pl)
+	now := time.Now()
+	for target, expires := range map[id.UserID]time.Time{alice: now.Add(-time.Minute), bob: now, carol: now.Add(time.Hour)} {
+		if err := a.applyModeration(context.Background(), room, &bot.ModAction{Action: "mute", Target: target}, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A moderator promoted bob while he was muted.
+	pl.SetUserLevel(bob, 50)
+
+	a.liftExpiredMutes(context.Background(), now)
+	if got := pl.GetUserLevel(alice); got != 10 {
+		t.Errorf("alice = %d, want her level from before the mute", got)
+	}
+	if got := pl.GetUserLevel(bob); got != 50 {
+		t.Errorf("bob = %d, want the level a moderator set", got)
+	}
+	if got := pl.GetUserLevel(carol); got != -1 {
+		t.Errorf("carol = %d, want her to stay muted until her mute expires", got)
+	}
+	for target, want := range map[string]bool{alice: false, bob: false, carol: true} {
+		if _, ok, _ := db.GetMute(a.MessagesDB, room, target); ok != want {
+			t.Errorf("%s mute recorded = %v, want %v", target, ok, want)
+		}
+	}
+}
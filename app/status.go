@@ -0,0 +1,24 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/version"
+)
+
+// startedAt is when the process started, for /bot status uptime.
+var startedAt = time.Now()
+
+// StatusText describes the running build and how long it has been up.
+func StatusText(now time.Time) string {
+	return fmt.Sprintf("%s\nuptime: %s", version.Get(), now.Sub(startedAt).Round(time.Second))
+}
+
+// handleStatus replies to /bot status with the build info and uptime.
+func (app *App) handleStatus(ctx context.Context, ev *event.Event, label string) {
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+StatusText(time.Now()), "status")
+}
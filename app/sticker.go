@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/locale"
+	"github.com/polarhive/ash/matrix"
+)
+
+// ParseStickerArgs splits the arguments of the sticker builtin into an
+// action ("list", "add" or "remove") and a sticker name:
+//
+//	list            list the room's stickers
+//	add <name>      add the replied-to image (admins)
+//	remove <name>   remove a sticker (admins)
+func ParseStickerArgs(args string) (action, name string) {
+	first, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	switch action = strings.ToLower(first); action {
+	case "", "list":
+		return "list", ""
+	case "rm", "delete":
+		action = "remove"
+	}
+	return action, strings.Trim(strings.TrimSpace(rest), ":")
+}
+
+// handleSticker implements the sticker builtin: it manages the room's
+// sticker pack, an MSC2545 image pack in room state that clients such as
+// Cinny, FluffyChat and Nheko offer stickers from.
+func (app *App) handleSticker(ctx context.Context, ev *event.Event, args, cmd, label string) {
+	reply := func(body string) { SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+body, cmd) }
+	say := func(key string, args ...any) { reply(locale.ForRoom(string(ev.RoomID), key, args...)) }
+	action, name := ParseStickerArgs(args)
+
+	switch action {
+	case "list":
+		names, err := matrix.StickerNames(ctx, app.Client, ev.RoomID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to read sticker pack")
+			say("sticker_read_failed")
+			return
+		}
+		if len(names) == 0 {
+			say("sticker_none", cmd)
+			return
+		}
+		say("sticker_list", strings.Join(names, ", "))
+		return
+	case "add", "remove":
+		if !app.isAdmin(ev.Sender) {
+			say("sticker_admin_only")
+			return
+		}
+		if !matrix.StickerNameRe.MatchString(name) {
+			say("sticker_usage_name", cmd, action)
+			return
+		}
+	default:
+		say("sticker_usage", cmd, cmd, cmd)
+		return
+	}
+
+	if action == "remove" {
+		err := matrix.RemoveSticker(ctx, app.Client, ev.RoomID, name)
+		switch {
+		case errors.Is(err, matrix.ErrNoSticker):
+			say("sticker_not_found", name)
+		case err != nil:
+			log.Error().Err(err).Str("sticker", name).Msg("failed to remove sticker")
+			say("sticker_update_failed")
+		default:
+			say("sticker_removed", name)
+		}
+		return
+	}
+
+	img, err := matrix.DownloadImageFromMessage(ctx, app.Client, ev)
+	if err != nil {
+		say("sticker_need_image", cmd, name)
+		return
+	}
+	err = matrix.AddSticker(ctx, app.Client, ev.RoomID, name, img)
+	switch {
+	case errors.Is(err, matrix.ErrNotImage):
+		say("sticker_not_image")
+	case errors.Is(err, matrix.ErrQuotaExceeded), errors.Is(err, matrix.ErrFileTooLarge):
+		reply(err.Error())
+	case err != nil:
+		log.Error().Err(err).Str("sticker", name).Msg("failed to add sticker")
+		say("sticker_update_failed")
+	default:
+		say("sticker_added", name)
+	}
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/matrix"
+)
+
+func TestParseStickerArgs(t *testing.T) {
+	tests := []struct{ args, action, name string }{
+		{"", "list", ""},
+		{"list", "list", ""},
+		{"add :party:", "add", "party"},
+		{"ADD cat_jam", "add", "cat_jam"},
+		{"rm party", "remove", "party"},
+		{"remove", "remove", ""},
+		{"rename x", "rename", "x"},
+	}
+	for _, tt := range tests {
+		if action, name := ParseStickerArgs(tt.args); action != tt.action || name != tt.name {
+			t.Errorf("ParseStickerArgs(%q) = %q, %q; want %q, %q", tt.args, action, name, tt.action, tt.name)
+		}
+	}
+}
+
+func TestHandleSticker(t *testing.T) {
+	var replies []string
+	var state []byte
+	statePath := "/state/im.ponies.room_emotes/" + matrix.StickerPackKey
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, statePath) && r.Method == http.MethodGet:
+			if state == nil {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"errcode":"M_NOT_FOUND","error":"not found"}`)
+				return
+			}
+			w.Write(state)
+		case strings.HasSuffix(r.URL.Path, statePath) && r.Method == http.MethodPut:
+			state, _ = io.ReadAll(r.Body)
+			fmt.Fprint(w, `{"event_id":"$state"}`)
+		case strings.Contains(r.URL.Path, "/event/"):
+			fmt.Fprint(w, `{"type":"m.room.message","event_id":"$img","room_id":"!room:example.com","sender":"@user:example.com",`+
+				`"content":{"msgtype":"m.image","body":"party.png","url":"mxc://example.com/party","info":{"mimetype":"image/png","w":64,"h":64,"size":1234}}}`)
+		case r.Method == http.MethodPut:
+			var content event.MessageEventContent
+			if json.NewDecoder(r.Body).Decode(&content) == nil {
+				replies = append(replies, content.Body)
+			}
+			fmt.Fprint(w, `{"event_id":"$reply"}`)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{Cfg: &config.Config{Admins: []string{"@admin:example.com"}}, Client: client}
+	run := func(sender, args string) string {
+		t.Helper()
+		ev := &event.Event{ID: "$cmd", RoomID: "!room:example.com", Sender: id.UserID(sender), Type: event.EventMessage,
+			Content: event.Content{Parsed: &event.MessageEventContent{
+				MsgType:   event.MsgText,
+				Body:      "/bot sticker " + args,
+				RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: "$img"}},
+			}}}
+		a.handleSticker(context.Background(), ev, args, "sticker", "")
+		if len(replies) == 0 {
+			t.Fatalf("no reply to %q", args)
+		}
+		return replies[len(replies)-1]
+	}
+
+	if got := run("@user:example.com", ""); !strings.Contains(got, "no stickers yet") {
+		t.Errorf("empty list: %q", got)
+	}
+	if got := run("@user:example.com", "add party"); !strings.Contains(got, "only bot admins") {
+		t.Errorf("non-admin add: %q", got)
+	}
+	if got := run("@admin:example.com", "add bad/name"); !strings.Contains(got, "usage") {
+		t.Errorf("bad name: %q", got)
+	}
+	if got := run("@admin:example.com", "add party"); got != `added "party" to the room's sticker pack` {
+		t.Fatalf("add: %q", got)
+	}
+	var pack event.ImagePackEventContent
+	if err := json.Unmarshal(state, &pack); err != nil {
+		t.Fatal(err)
+	}
+	img := pack.Images["party"]
+	if img == nil || img.URL != "mxc://example.com/party" || img.Info.Width != 64 || img.Info.MimeType != "image/png" {
+		t.Errorf("pack image = %+v", img)
+	}
+	if pack.Metadata.DisplayName != "Stickers" || len(pack.Metadata.Usage) != 1 || pack.Metadata.Usage[0] != event.ImagePackUsageSticker {
+		t.Errorf("pack metadata = %+v", pack.Metadata)
+	}
+
+	// Other fields and images are kept.
+	state = []byte(`{"images":{"party":{"url":"mxc://example.com/party"},"wave":{"url":"mxc://example.com/wave","usage":["emoticon"]}},"pack":{"display_name":"Ours"},"x.custom":1}`)
+	if got := run("@user:example.com", "list"); got != "stickers: party, wave" {
+		t.Errorf("list: %q", got)
+	}
+	if got := run("@admin:example.com", "remove nope"); got != `there's no sticker called "nope"` {
+		t.Errorf("remove missing: %q", got)
+	}
+	if got := run("@admin:example.com", "remove party"); got != `removed "party"` {
+		t.Errorf("remove: %q", got)
+	}
+	want := `{"images":{"wave":{"url":"mxc://example.com/wave","usage":["emoticon"]}},"pack":{"display_name":"Ours"},"x.custom":1}`
+	if string(state) != want {
+		t.Errorf("state after remove = %s", state)
+	}
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
+)
+
+// threadDigestEmoji is the reaction that accepts a thread summary offer.
+const threadDigestEmoji = "👍"
+
+// threadDigestCommand returns the room's summarizing ai command, or false if
+// bot.json doesn't have a usable one.
+func (app *App) threadDigestCommand(room config.RoomIDEntry) (*bot.BotCommand, bool) {
+	name := room.ThreadDigest.Command
+	if name == "" {
+		name = "tldr"
+	}
+	botCfg := app.botConfig()
+	if botCfg == nil {
+		return nil, false
+	}
+	c, ok := botCfg.Commands[name]
+	if !ok || c.Type != "ai" || c.InputType != "thread" {
+		log.Warn().Str("room", room.Comment).Str("cmd", name).Msg("threadDigest needs an ai command with input_type thread")
+		return nil, false
+	}
+	return &c, true
+}
+
+// checkThreadDigest offers, once per thread, to summarize a thread that has
+// reached the room's threadDigest threshold. The offer is posted into the
+// thread and pre-reacted so accepting it is one tap. Nothing is offered if
+// the homeserver doesn't support threads.
+func (app *App) checkThreadDigest(ctx context.Context, ev *event.Event, msgData *db.MessageData, room config.RoomIDEntry) {
+	rootID := msgData.Msg.RelatesTo.GetThreadParent()
+	if rootID == "" || !matrix.Caps.Threads {
+		return
+	}
+	n, err := db.ThreadReplyCount(app.MessagesDB, string(ev.RoomID), string(rootID))
+	if err != nil {
+		log.Warn().Err(err).Str("root", string(rootID)).Msg("failed to count thread replies")
+		return
+	}
+	if n < room.ThreadDigest.Threshold {
+		return
+	}
+	if _, ok := app.threadDigestCommand(room); !ok {
+		return
+	}
+	claimed, err := db.ClaimThreadDigest(app.MessagesDB, string(ev.RoomID), string(rootID), time.Now().UnixMilli())
+	if err != nil {
+		log.Warn().Err(err).Str("root", string(rootID)).Msg("failed to record thread digest offer")
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	label := ResolveReplyLabel(app.Cfg, app.botConfig())
+	content := matrix.ReplyContent("", fmt.Sprintf("%sthis thread has %d replies. react %s for a summary", label, n, threadDigestEmoji), "")
+	content.MsgType = event.MsgNotice
+	content.RelatesTo = (&event.RelatesTo{}).SetThread(rootID, ev.ID)
+	resp, err := app.Client.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content)
+	if err != nil {
+		log.Error().Err(err).Str("root", string(rootID)).Msg("failed to offer thread digest")
+		return
+	}
+	if err := db.SetThreadDigestPrompt(app.MessagesDB, string(ev.RoomID), string(rootID), string(resp.EventID)); err != nil {
+		log.Warn().Err(err).Str("root", string(rootID)).Msg("failed to store thread digest offer")
+		return
+	}
+	if _, err := app.Client.SendReaction(ctx, ev.RoomID, resp.EventID, threadDigestEmoji); err != nil {
+		log.Debug().Err(err).Msg("failed to pre-react to thread digest offer")
+	}
+	log.Info().Str("room", room.Comment).Str("root", string(rootID)).Int("replies", n).Msg("offered thread digest")
+}
+
+// postThreadDigest posts the summary a member accepted by reacting to an
+// offer. Only the first acceptance counts.
+func (app *App) postThreadDigest(ctx context.Context, roomID id.RoomID, promptID id.EventID, room config.RoomIDEntry) {
+	rootID, err := db.ClaimThreadDigestPost(app.MessagesDB, string(roomID), string(promptID), time.Now().UnixMilli())
+	if err != nil {
+		log.Warn().Err(err).Str("prompt", string(promptID)).Msg("failed to claim thread digest")
+		return
+	}
+	if rootID == "" {
+		return
+	}
+	c, ok := app.threadDigestCommand(room)
+	if !ok {
+		return
+	}
+	if app.Cfg.DryRun && app.Cfg.DryRunNoNetwork {
+		log.Info().Str("root", rootID).Msg("dry run mode: skipping thread digest")
+		return
+	}
+	label := ResolveReplyLabel(app.Cfg, app.botConfig())
+	summary, err := bot.ThreadSummary(ctx, app.Client, app.MessagesDB, c, app.Cfg.GroqAPIKey, roomID, id.EventID(rootID))
+	if err != nil {
+		log.Error().Err(err).Str("root", rootID).Msg("failed to summarize thread")
+		summary = "couldn't summarize this thread"
+	}
+	if summary == "" {
+		return
+	}
+	if err := bot.PostThreadSummary(ctx, app.Client, roomID, id.EventID(rootID), promptID, label+summary); err != nil {
+		log.Error().Err(err).Str("root", rootID).Msg("failed to post thread digest")
+	}
+}
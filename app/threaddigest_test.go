@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
+)
+
+func TestThreadDigest(t *testing.T) {
+	var sent []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			sent = append(sent, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"event_id":"$offer"}`)
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	for i := range 3 {
+		raw := `{"body":"reply","m.relates_to":{"rel_type":"m.thread","event_id":"$root"}}`
+		if _, err := messagesDB.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype, raw_json) VALUES (?, '!room:example.com', '@alice:example.com', ?, 'reply', 'm.text', ?)`,
+			fmt.Sprintf("$r%d", i), i, raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	room := config.RoomIDEntry{ID: "!room:example.com", Comment: "lounge", ThreadDigest: &config.ThreadDigestConfig{Threshold: 3}}
+	a := &App{
+		Cfg:        &config.Config{RoomIDs: []config.RoomIDEntry{room}},
+		Client:     client,
+		MessagesDB: messagesDB,
+		BotCfg:     &bot.BotConfig{Commands: map[string]bot.BotCommand{"tldr": {Type: "ai", InputType: "thread"}}},
+	}
+	ev := &event.Event{ID: "$r2", RoomID: "!room:example.com", Sender: "@alice:example.com"}
+	msgData := &db.MessageData{Event: ev, Msg: &event.MessageEventContent{
+		Body:      "reply",
+		RelatesTo: (&event.RelatesTo{}).SetThread("$root", "$r1"),
+	}}
+
+	// Servers without threads get no offer.
+	caps := matrix.Caps
+	matrix.Caps = &matrix.Capabilities{}
+	a.checkThreadDigest(context.Background(), ev, msgData, room)
+	matrix.Caps = caps
+	if len(sent) != 0 {
+		t.Fatalf("offered a digest without thread support: %v", sent)
+	}
+
+	a.checkThreadDigest(context.Background(), ev, msgData, room)
+	a.checkThreadDigest(context.Background(), ev, msgData, room)
+	if len(sent) != 2 || !strings.Contains(sent[0], "/send/m.room.message/") || !strings.Contains(sent[1], "/send/m.reaction/") {
+		t.Fatalf("offer: homeserver saw %v", sent)
+	}
+
+	// Without a GROQ_API_KEY the summary fails, which is reported once.
+	a.postThreadDigest(context.Background(), "!room:example.com", "$offer", room)
+	a.postThreadDigest(context.Background(), "!room:example.com", "$offer", room)
+	if len(sent) != 3 || !strings.Contains(sent[2], "/send/m.room.message/") {
+		t.Errorf("accept: homeserver saw %v", sent)
+	}
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/bot"
+)
+
+// transcribeVoice replies to a voice message with its transcript, in rooms
+// with transcribeVoice. whisper.cpp runs under the sandbox of bot.json's
+// transcribe command, if it has one.
+func (app *App) transcribeVoice(ctx context.Context, ev *event.Event, msg *event.MessageEventContent) {
+	var sandbox *bot.ExecSandbox
+	botCfg := app.botConfig()
+	if botCfg != nil {
+		if c, ok := botCfg.Commands["transcribe"]; ok {
+			sandbox = c.Sandbox
+		}
+	}
+	text, err := bot.Transcribe(ctx, app.Client, msg, sandbox)
+	if err != nil {
+		log.Warn().Err(err).Str("event_id", string(ev.ID)).Msg("failed to transcribe voice message")
+		return
+	}
+	if text == "" {
+		return
+	}
+	label := ResolveReplyLabel(app.Cfg, botCfg)
+	SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"🎤 "+text, "transcribe")
+}
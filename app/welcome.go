@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/matrix"
+)
+
+// WelcomeData is the data available to welcome templates.
+type WelcomeData struct {
+	DisplayName string
+	UserID      string
+	RoomName    string
+}
+
+// RenderWelcome executes a welcome template such as "hi {{.DisplayName}}!".
+func RenderWelcome(tmpl string, data WelcomeData) (string, error) {
+	t, err := template.New("welcome").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// WelcomeLimiter caps how many greetings are sent per room each minute, so a
+// bridge syncing hundreds of members doesn't make the bot spam the room.
+type WelcomeLimiter struct {
+	mu   sync.Mutex
+	sent map[id.RoomID][]time.Time
+}
+
+// NewWelcomeLimiter creates a new WelcomeLimiter.
+func NewWelcomeLimiter() *WelcomeLimiter {
+	return &WelcomeLimiter{sent: make(map[id.RoomID][]time.Time)}
+}
+
+// Allow records a greeting and reports whether it is within the per-minute limit.
+func (l *WelcomeLimiter) Allow(roomID id.RoomID, limit int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var recent []time.Time
+	for _, t := range l.sent[roomID] {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= limit {
+		l.sent[roomID] = recent
+		return false
+	}
+	l.sent[roomID] = append(recent, now)
+	return true
+}
+
+// HandleMember greets users joining rooms that have a welcome template.
+func (app *App) HandleMember(ctx context.Context, ev *event.Event) {
+	matrix.UpdateDisplayNames(ev)
+	room, ok := app.findRoom(ev.RoomID)
+	if !ok || app.Cfg.ReadOnly || room.Welcome == nil || room.Welcome.Template == "" || ev.StateKey == nil {
+		return
+	}
+	// Only greet live joins: skip the initial sync and state snapshots.
+	select {
+	case <-app.ReadyChan:
+	default:
+		return
+	}
+	if ev.Mautrix.EventSource&event.SourceTimeline == 0 {
+		return
+	}
+	if ev.Content.Raw != nil {
+		_ = ev.Content.ParseRaw(ev.Type)
+	}
+	member := ev.Content.AsMember()
+	if member.Membership != event.MembershipJoin {
+		return
+	}
+	if prev := ev.Unsigned.PrevContent; prev != nil {
+		_ = prev.ParseRaw(ev.Type)
+		if prev.AsMember().Membership == event.MembershipJoin {
+			return // profile change, not a new join
+		}
+	}
+	userID := id.UserID(*ev.StateKey)
+	if app.Client != nil && userID == app.Client.UserID {
+		return
+	}
+
+	limit := room.Welcome.MaxPerMinute
+	if limit <= 0 {
+		limit = 3
+	}
+	if app.Welcome != nil && !app.Welcome.Allow(ev.RoomID, limit, time.Now()) {
+		log.Info().Str("room", room.Comment).Str("user", string(userID)).Msg("welcome rate limited")
+		return
+	}
+
+	display := member.Displayname
+	if display == "" {
+		display = userID.Localpart()
+	}
+	body, err := RenderWelcome(room.Welcome.Template, WelcomeData{DisplayName: display, UserID: string(userID), RoomName: room.Comment})
+	if err != nil {
+		log.Error().Err(err).Str("room", room.Comment).Msg("failed to render welcome template")
+		return
+	}
+	if room.Welcome.DM {
+		// Custom emoticons are the room's, so in a DM only emoji expand.
+		body, _ = matrix.ExpandShortcodes(body, "", nil)
+		if err := app.sendDM(ctx, userID, body); err != nil {
+			log.Error().Err(err).Str("user", string(userID)).Msg("failed to send welcome DM")
+			return
+		}
+	} else {
+		content := event.MessageEventContent{MsgType: event.MsgText, Body: body}
+		if matrix.HasShortcodes(body) {
+			content.Body, content.FormattedBody = matrix.ExpandShortcodes(body, "", matrix.RoomEmoticons(ctx, app.Client, ev.RoomID))
+			content.Format = event.FormatHTML
+		}
+		if _, err := app.Client.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content); err != nil {
+			log.Error().Err(err).Str("user", string(userID)).Msg("failed to send welcome")
+			return
+		}
+	}
+	log.Info().Str("room", room.Comment).Str("user", string(userID)).Msg("sent welcome")
+}
@@ -0,0 +1,38 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderWelcome(t *testing.T) {
+	got, err := RenderWelcome("welcome to {{.RoomName}}, {{.DisplayName}}!", WelcomeData{DisplayName: "Alice", UserID: "@alice:example.com", RoomName: "lounge"})
+	if err != nil {
+		t.Fatalf("RenderWelcome: %v", err)
+	}
+	if want := "welcome to lounge, Alice!"; got != want {
+		t.Errorf("RenderWelcome() = %q, want %q", got, want)
+	}
+	if _, err := RenderWelcome("{{.Nope", WelcomeData{}); err == nil {
+		t.Error("expected error for malformed template")
+	}
+}
+
+func TestWelcomeLimiter(t *testing.T) {
+	l := NewWelcomeLimiter()
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if !l.Allow("!room:example.com", 2, now) {
+			t.Fatalf("greeting %d unexpectedly limited", i)
+		}
+	}
+	if l.Allow("!room:example.com", 2, now) {
+		t.Error("expected third greeting in a minute to be limited")
+	}
+	if !l.Allow("!other:example.com", 2, now) {
+		t.Error("limits should be per room")
+	}
+	if !l.Allow("!room:example.com", 2, now.Add(time.Minute)) {
+		t.Error("expected limit to reset after a minute")
+	}
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/util"
+)
+
+// wordFilterCache holds compiled word filter patterns keyed by source.
+var wordFilterCache sync.Map
+
+// matchWordFilter returns the first pattern matching body. Invalid patterns
+// are logged once and skipped.
+func matchWordFilter(patterns []string, body string) (string, bool) {
+	for _, p := range patterns {
+		cached, ok := wordFilterCache.Load(p)
+		if !ok {
+			re, err := regexp.Compile("(?i)" + p)
+			if err != nil {
+				log.Warn().Err(err).Str("pattern", p).Msg("invalid word filter pattern")
+			}
+			cached, _ = wordFilterCache.LoadOrStore(p, re)
+		}
+		if re := cached.(*regexp.Regexp); re != nil && re.MatchString(body) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// handleWordFilter runs the room's word filter actions for a matching
+// message and records the match in the moderation audit log.
+func (app *App) handleWordFilter(ctx context.Context, ev *event.Event, room config.RoomIDEntry, pattern string) {
+	actions := room.WordFilter.Actions
+	if len(actions) == 0 {
+		actions = []string{"warn"}
+	}
+	log.Warn().Str("room", room.Comment).Str("sender", string(ev.Sender)).Str("pattern", pattern).Strs("actions", actions).Msg("word filter matched")
+
+	reason := fmt.Sprintf("matched %q (%s)", pattern, strings.Join(actions, ", "))
+	app.audit(ev.RoomID, app.botUserID(), "wordfilter", ev.Sender, reason, ev.ID)
+
+	label := ResolveReplyLabel(app.Cfg, app.botConfig())
+	if util.InSlice(actions, "redact") {
+		if _, err := app.Client.RedactEvent(ctx, ev.RoomID, ev.ID, mautrix.ReqRedact{Reason: "word filter"}); err != nil {
+			log.Error().Err(err).Str("event_id", string(ev.ID)).Msg("failed to redact filtered message")
+		}
+	}
+	if util.InSlice(actions, "warn") {
+		SendBotReply(ctx, app.Client, ev.RoomID, ev.ID, label+"please watch your language", "wordfilter")
+	}
+	if util.InSlice(actions, "notify") {
+		app.notifyModRoom(ctx, fmt.Sprintf("%sword filter in %s: %s matched %q", label, room.Comment, ev.Sender, pattern))
+	}
+}
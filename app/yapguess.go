@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/db"
+)
+
+// RunYapGuessWeekly posts the previous week's /bot yap guess winners to
+// every room that played, checking hourly until ctx is cancelled. Each
+// week is posted once per room, even across restarts.
+func (app *App) RunYapGuessWeekly(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		app.postYapGuessWinners(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// postYapGuessWinners posts last week's winners where not already done.
+func (app *App) postYapGuessWinners(ctx context.Context, now time.Time) {
+	thisWeek := bot.StartOfWeek(now)
+	lastWeek := thisWeek.AddDate(0, 0, -7)
+	rooms, err := db.GameRooms(app.MessagesDB, bot.YapGuessGame, lastWeek.UnixMilli(), thisWeek.UnixMilli())
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to list yap guess rooms")
+		return
+	}
+	for _, roomID := range rooms {
+		room, ok := app.findRoom(id.RoomID(roomID))
+		if !ok {
+			continue
+		}
+		claimed, err := db.ClaimWeeklyPost(app.MessagesDB, roomID, bot.YapGuessGame, lastWeek.Format("2006-01-02"), now.UnixMilli())
+		if err != nil {
+			log.Warn().Err(err).Str("room", room.Comment).Msg("failed to claim yap guess weekly post")
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		body, err := bot.YapGuessStandings(ctx, app.MessagesDB, app.Client, id.RoomID(roomID),
+			app.Cfg.BotReplyLabel+"yap guess winners for the week of "+lastWeek.Format("Jan 2")+":", lastWeek, thisWeek)
+		if err != nil || body == "" {
+			log.Warn().Err(err).Str("room", room.Comment).Msg("failed to build yap guess winners")
+			continue
+		}
+		if app.Cfg.DryRun {
+			log.Info().Str("room", room.Comment).Msg("dry run mode: skipping yap guess winners post")
+			continue
+		}
+		content := event.MessageEventContent{MsgType: event.MsgText, Body: body}
+		if _, err := app.Client.SendMessageEvent(ctx, id.RoomID(roomID), event.EventMessage, &content); err != nil {
+			log.Error().Err(err).Str("room", room.Comment).Msg("failed to post yap guess winners")
+			continue
+		}
+		log.Info().Str("room", room.Comment).Msg("posted yap guess winners")
+	}
+}
@@ -0,0 +1,514 @@
+// Package ash exposes the bot as a library so other Go programs can reuse its
+// sync, E2EE and storage stack and add their own commands:
+//
+//	err := ash.New(cfg).
+//		WithCommand("ping", func(ctx context.Context, cmd *ash.Command) {
+//			cmd.Reply(ctx, "pong")
+//		}).
+//		Run(ctx)
+package ash
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/app"
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/links"
+	"github.com/polarhive/ash/locale"
+	"github.com/polarhive/ash/matrix"
+	"github.com/polarhive/ash/util"
+	"github.com/polarhive/ash/version"
+)
+
+// Re-exported command types so embedders only need to import this package.
+type (
+	Command    = app.Command
+	Handler    = app.Handler
+	Middleware = app.Middleware
+)
+
+// running is set while an instance runs. The media limits and quota,
+// homeserver capabilities, yap timezone, exec temp dir, locale settings and
+// HTTP clients are package-level settings, so only one instance may run in a
+// process at a time.
+var running atomic.Bool
+
+// Ash is a configured bot instance. Only one instance can run per process at
+// a time; a new one can start once the previous Run has returned.
+type Ash struct {
+	cfg    *config.Config
+	botCfg *bot.BotConfig
+	router *app.Router
+}
+
+// New creates a bot instance from cfg. bot.json is loaded from
+// cfg.BotConfigPath at Run unless WithBotConfig is used.
+func New(cfg *config.Config) *Ash {
+	return &Ash{cfg: cfg, router: app.NewRouter()}
+}
+
+// WithCommand registers a Go-native command alongside the bot.json ones.
+func (a *Ash) WithCommand(name string, h Handler) *Ash {
+	a.router.Handle(name, h)
+	return a
+}
+
+// WithMiddleware wraps every command with the given middleware.
+func (a *Ash) WithMiddleware(mw ...Middleware) *Ash {
+	a.router.Use(mw...)
+	return a
+}
+
+// WithBotConfig uses botCfg instead of loading bot.json.
+func (a *Ash) WithBotConfig(botCfg *bot.BotConfig) *Ash {
+	a.botCfg = botCfg
+	return a
+}
+
+// Router returns the command router.
+func (a *Ash) Router() *app.Router {
+	return a.router
+}
+
+// Run checks the config, opens the databases, logs in and syncs until ctx
+// is cancelled. It fails if another instance is running in this process.
+func (a *Ash) Run(ctx context.Context) error {
+	if !running.CompareAndSwap(false, true) {
+		return errors.New("another ash instance is already running in this process")
+	}
+	defer running.Store(false)
+	cfg := a.cfg
+	if errs := cfg.Validate(); len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	metaDB, err := db.OpenMeta(ctx, cfg.MetaDBPath)
+	if err != nil {
+		return fmt.Errorf("open meta db: %w", err)
+	}
+	defer metaDB.Close()
+
+	if err := matrix.EnsureSecrets(ctx, metaDB, cfg); err != nil {
+		return fmt.Errorf("ensure secrets: %w", err)
+	}
+
+	messagesDB, err := db.OpenMessages(ctx, cfg.DBPath)
+	if err != nil {
+		return fmt.Errorf("open messages db: %w", err)
+	}
+	defer messagesDB.Close()
+
+	if _, err := matrix.EnsurePickleKey(ctx, metaDB); err != nil {
+		return fmt.Errorf("ensure pickle key: %w", err)
+	}
+	return a.run(ctx, metaDB, messagesDB)
+}
+
+// run starts the Matrix client, sets up sync, and handles messages.
+func (a *Ash) run(ctx context.Context, metaDB *sql.DB, messagesDB *sql.DB) error {
+	cfg := a.cfg
+	log.Info().Msg(version.Get().String())
+	log.Info().Msgf("logging in as %s to %s (E2EE initializing)", cfg.User, cfg.Homeserver)
+	var roomNames []string
+	for _, r := range cfg.RoomIDs {
+		roomNames = append(roomNames, r.Comment)
+	}
+	log.Info().Msgf("ready: watching rooms: [%s]", strings.Join(roomNames, ", "))
+
+	client, err := a.connect(ctx, metaDB)
+	if err != nil {
+		return err
+	}
+	syncer := client.Syncer.(*mautrix.DefaultSyncer)
+	if cfg.AllJoinedRooms {
+		if joined, err := client.JoinedRooms(ctx); err != nil {
+			log.Warn().Err(err).Msg("failed to list joined rooms")
+		} else {
+			log.Info().Int("joined", len(joined.JoinedRooms)).Int("excluded", len(cfg.ExcludeRoomIDs)).Msg("all joined rooms mode: watching every joined room")
+		}
+	}
+
+	botCfg := a.loadBotConfig()
+
+	a.applySettings()
+	if botCfg != nil {
+		for _, err := range botCfg.CheckExecBinaries() {
+			log.Error().Err(err).Msg("exec command can't run")
+		}
+	}
+	matrix.DetectCapabilities(ctx, client)
+	if !cfg.ReadOnly {
+		if err := matrix.EnsureDisplayName(ctx, client, cfg.DisplayName); err != nil {
+			log.Warn().Err(err).Msg("failed to set display name")
+		}
+	}
+	matrix.Quota = &matrix.MediaQuota{
+		DB:    messagesDB,
+		Limit: func(roomID id.RoomID) int64 { return cfg.MediaQuotaBytes(string(roomID)) },
+	}
+
+	readyChan := make(chan bool)
+	var once sync.Once
+	syncer.OnSync(func(_ context.Context, _ *mautrix.RespSync, _ string) bool {
+		once.Do(func() { close(readyChan) })
+		return true
+	})
+
+	h, err := a.newApp(client, messagesDB, botCfg, readyChan)
+	if err != nil {
+		return err
+	}
+	h.MetaDB = metaDB
+	bot.InitTriviaState()
+	bot.AILog = h.LogAIExchange
+	syncer.OnEventType(event.EventMessage, h.HandleMessage)
+	syncer.OnEventType(event.StateMember, h.HandleMember)
+	syncer.OnEventType(event.EventReaction, func(ctx context.Context, ev *event.Event) {
+		log.Info().Str("event_id", string(ev.ID)).Str("reactor", string(ev.Sender)).Msg("reaction event received from matrix")
+		h.HandleReaction(ctx, ev)
+	})
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error().Msgf("sync goroutine panic: %v", r)
+			}
+		}()
+		log.Debug().Msg("starting sync")
+		if err := client.Sync(); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("sync error")
+		}
+	}()
+	defer client.StopSync()
+
+	select {
+	case <-readyChan:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	go h.Exporter.Run(ctx)
+	h.NotifyStarted(ctx, version.Get().String())
+	if g := cfg.YapGuess; g != nil && g.WeeklyPost && !cfg.ReadOnly {
+		go h.RunYapGuessWeekly(ctx)
+	}
+	if api := cfg.AdminAPI; api != nil {
+		go func() {
+			if err := h.RunAdminAPI(ctx, api.Listen, api.Token); err != nil {
+				log.Error().Err(err).Str("addr", api.Listen).Msg("admin API stopped")
+			}
+		}()
+	}
+	if d := cfg.Dashboard; d != nil {
+		go func() {
+			if err := h.RunDashboard(ctx, d); err != nil {
+				log.Error().Err(err).Str("addr", d.Listen).Msg("dashboard stopped")
+			}
+		}()
+	}
+	if hooks := cfg.InboundHooks; hooks != nil {
+		go func() {
+			if err := h.RunInboundHooks(ctx, hooks); err != nil {
+				log.Error().Err(err).Str("addr", hooks.Listen).Msg("inbound hooks stopped")
+			}
+		}()
+	}
+	if e := cfg.Email; e != nil && !cfg.ReadOnly {
+		go func() {
+			if err := h.RunEmail(ctx, e); err != nil {
+				log.Error().Err(err).Str("addr", e.Listen).Msg("email listener stopped")
+			}
+		}()
+	}
+	if cfg.Feeds != nil && !cfg.ReadOnly && !cfg.DryRunNoNetwork {
+		go h.RunFeeds(ctx)
+	}
+	if !cfg.ReadOnly && !cfg.DryRunNoNetwork {
+		go h.RunMuteExpiry(ctx)
+	}
+	if cfg.EnrichLinks && !cfg.DryRunNoNetwork {
+		enricher := app.NewEnricher(messagesDB, time.Duration(cfg.EnrichDomainSecs)*time.Second, h.Exporter.LinksStored)
+		go enricher.Run(ctx)
+	}
+	<-ctx.Done()
+	h.Exporter.Shutdown()
+	h.Hooks.Close()
+	log.Debug().Msg("exiting run")
+	return ctx.Err()
+}
+
+// Login logs in (or restores the stored session), sets up E2EE and verifies
+// the session with the recovery key, without syncing. It returns the
+// logged-in user and device.
+func (a *Ash) Login(ctx context.Context) (id.UserID, id.DeviceID, error) {
+	metaDB, err := db.OpenMeta(ctx, a.cfg.MetaDBPath)
+	if err != nil {
+		return "", "", fmt.Errorf("open meta db: %w", err)
+	}
+	defer metaDB.Close()
+	if err := matrix.EnsureSecrets(ctx, metaDB, a.cfg); err != nil {
+		return "", "", fmt.Errorf("ensure secrets: %w", err)
+	}
+	if _, err := matrix.EnsurePickleKey(ctx, metaDB); err != nil {
+		return "", "", fmt.Errorf("ensure pickle key: %w", err)
+	}
+	client, err := a.connect(ctx, metaDB)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := client.Whoami(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("whoami: %w", err)
+	}
+	return resp.UserID, resp.DeviceID, nil
+}
+
+// JoinedRooms returns the rooms the stored session's account has joined,
+// without setting up E2EE or syncing.
+func (a *Ash) JoinedRooms(ctx context.Context) ([]id.RoomID, error) {
+	metaDB, err := db.OpenMeta(ctx, a.cfg.MetaDBPath)
+	if err != nil {
+		return nil, fmt.Errorf("open meta db: %w", err)
+	}
+	defer metaDB.Close()
+	client, err := matrix.LoadOrCreate(ctx, metaDB, a.cfg)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.JoinedRooms(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("joined rooms: %w", err)
+	}
+	return resp.JoinedRooms, nil
+}
+
+// Logout invalidates the stored session and removes it and the crypto
+// store from disk, leaving the messages DB alone. See matrix.Logout.
+func (a *Ash) Logout(ctx context.Context, forgetSecrets, force bool) error {
+	metaDB, err := db.OpenMeta(ctx, a.cfg.MetaDBPath)
+	if err != nil {
+		return fmt.Errorf("open meta db: %w", err)
+	}
+	defer metaDB.Close()
+	return matrix.Logout(ctx, metaDB, a.cfg, forgetSecrets, force)
+}
+
+// Backfill logs in without syncing and stores the rooms' history back to
+// since, fetching up to concurrency rooms at once, then exports link
+// snapshots if any links were found. No rooms means every monitored room.
+// Progress is checkpointed in the meta DB, so a failed run resumes when
+// rerun, and each finished room is reported to the mod room.
+func (a *Ash) Backfill(ctx context.Context, rooms []id.RoomID, since time.Time, concurrency int) (app.BackfillResult, error) {
+	metaDB, err := db.OpenMeta(ctx, a.cfg.MetaDBPath)
+	if err != nil {
+		return app.BackfillResult{}, fmt.Errorf("open meta db: %w", err)
+	}
+	defer metaDB.Close()
+	if err := matrix.EnsureSecrets(ctx, metaDB, a.cfg); err != nil {
+		return app.BackfillResult{}, fmt.Errorf("ensure secrets: %w", err)
+	}
+	if _, err := matrix.EnsurePickleKey(ctx, metaDB); err != nil {
+		return app.BackfillResult{}, fmt.Errorf("ensure pickle key: %w", err)
+	}
+	messagesDB, err := db.OpenMessages(ctx, a.cfg.DBPath)
+	if err != nil {
+		return app.BackfillResult{}, fmt.Errorf("open messages db: %w", err)
+	}
+	defer messagesDB.Close()
+	client, err := a.connect(ctx, metaDB)
+	if err != nil {
+		return app.BackfillResult{}, err
+	}
+	readyChan := make(chan bool)
+	close(readyChan)
+	h, err := a.newApp(client, messagesDB, a.loadBotConfig(), readyChan)
+	if err != nil {
+		return app.BackfillResult{}, err
+	}
+	h.MetaDB = metaDB
+	if len(rooms) == 0 {
+		if rooms = h.BackfillTargets(ctx); len(rooms) == 0 {
+			return app.BackfillResult{}, errors.New("no rooms to backfill")
+		}
+	}
+	res, err := h.BackfillRooms(ctx, rooms, since, concurrency, func(p app.BackfillProgress) {
+		h.ReportBackfillProgress(ctx, p)
+	})
+	if res.Links > 0 && a.cfg.LinksPath != "" {
+		if exportErr := h.Exporter.ExportNow(); exportErr != nil {
+			return res, errors.Join(err, fmt.Errorf("export snapshots: %w", exportErr))
+		}
+	}
+	return res, err
+}
+
+// connect creates the Matrix client from stored or fresh credentials and
+// sets up E2EE.
+func (a *Ash) connect(ctx context.Context, metaDB *sql.DB) (*mautrix.Client, error) {
+	client, err := matrix.LoadOrCreate(ctx, metaDB, a.cfg)
+	if err != nil {
+		return nil, err
+	}
+	client.SyncPresence = "offline"
+	// The crypto helper registers its handlers on the syncer, so it must
+	// exist before SetupHelper.
+	client.Syncer = mautrix.NewDefaultSyncer()
+	client.Store = &db.MetaSyncStore{DB: metaDB}
+
+	cryptoHelper, err := matrix.SetupHelper(ctx, client, metaDB, a.cfg.MetaDBPath)
+	if err != nil {
+		return nil, err
+	}
+	client.Crypto = cryptoHelper
+	if a.cfg.DryRun {
+		// Run everything, but log what would be sent instead of sending it.
+		matrix.MakeDryRun(client)
+		log.Info().Bool("no_network", a.cfg.DryRunNoNetwork).Msg("dry run mode: outgoing events are logged, not sent")
+	}
+	if a.cfg.ReadOnly {
+		// Don't cross-sign the device or send anything else others can see.
+		matrix.MakeReadOnly(client)
+		log.Info().Msg("read-only mode: sending disabled")
+		return client, nil
+	}
+	if err := matrix.VerifyWithRecoveryKey(ctx, cryptoHelper.Machine(), a.cfg.RecoveryKey); err != nil {
+		log.Warn().Err(err).Msg("failed to verify session with recovery key")
+	}
+	return client, nil
+}
+
+// loadBotConfig returns the configured bot commands, loading bot.json unless
+// WithBotConfig was used. A missing bot.json is logged, not fatal.
+func (a *Ash) loadBotConfig() *bot.BotConfig {
+	if a.botCfg != nil {
+		return a.botCfg
+	}
+	botCfgPath := a.botConfigPath()
+	botCfg, err := bot.LoadBotConfig(botCfgPath)
+	if err != nil {
+		log.Warn().Err(err).Str("path", botCfgPath).Msg("failed to load bot config (continuing without)")
+		return nil
+	}
+	log.Info().Str("path", botCfgPath).Msg("loaded bot config")
+	return botCfg
+}
+
+// botConfigPath is where bot.json is read from.
+func (a *Ash) botConfigPath() string {
+	if a.cfg.BotConfigPath != "" {
+		return a.cfg.BotConfigPath
+	}
+	return "./bot.json"
+}
+
+// applySettings applies package-level settings from the config.
+func (a *Ash) applySettings() {
+	cfg := a.cfg
+	// Set yap leaderboard timezone from config (defaults to UTC).
+	if cfg.Timezone != "" {
+		if tz, err := time.LoadLocation(cfg.Timezone); err != nil {
+			log.Warn().Err(err).Str("tz", cfg.Timezone).Msg("invalid TIMEZONE in config, using UTC")
+		} else {
+			bot.YapTimezone = tz
+			log.Info().Str("tz", cfg.Timezone).Msg("yap leaderboard timezone set")
+		}
+	}
+	for _, r := range cfg.RoomIDs {
+		if r.Timezone == "" {
+			continue
+		}
+		if tz, err := time.LoadLocation(r.Timezone); err != nil {
+			log.Warn().Err(err).Str("room", r.Comment).Str("tz", r.Timezone).Msg("invalid room timezone in config, using TIMEZONE")
+		} else {
+			bot.RoomTimezones[r.ID] = tz
+		}
+	}
+	bot.YapFilter = bot.NewYapWordFilter(cfg.YapExclude)
+	bot.YapGuess = bot.NewYapGuessRules(cfg.YapGuess)
+	if cfg.MaxUploadMB > 0 {
+		matrix.MaxUploadBytes = int64(cfg.MaxUploadMB) << 20
+	}
+	matrix.ImageBlurhash = cfg.ImageBlurhash
+	if cfg.MessagesPath != "" {
+		if catalog, err := locale.Load(cfg.MessagesPath); err != nil {
+			log.Warn().Err(err).Msg("failed to load MESSAGES_PATH, replying in English")
+		} else {
+			locale.Translations = catalog
+			for _, err := range catalog.Check() {
+				log.Warn().Err(err).Msg("bad translation")
+			}
+		}
+	}
+	for _, r := range cfg.RoomIDs {
+		if r.Language != "" {
+			locale.RoomLanguages[r.ID] = r.Language
+		}
+	}
+	if cfg.RoomDefaults != nil {
+		locale.DefaultLanguage = cfg.RoomDefaults.Language
+	}
+	bot.ExecTmpDir = cfg.ExecTmpPath()
+	bot.ExecAllowlist = cfg.ExecAllowlist
+	bot.GIFSearch = cfg.GIFSearch
+	bot.Transcription = nil
+	if t := cfg.Transcription; t != nil {
+		tc := *t
+		if tc.APIKey == "" {
+			tc.APIKey = cfg.GroqAPIKey
+		}
+		bot.Transcription = &tc
+	}
+	bot.OCR = cfg.OCR
+	if err := util.ConfigureHTTP(cfg.ProxyURL); err != nil {
+		log.Warn().Err(err).Msg("invalid PROXY_URL in config, using the environment's proxy")
+	}
+}
+
+// newApp wires the event handlers' runtime state.
+func (a *Ash) newApp(client *mautrix.Client, messagesDB *sql.DB, botCfg *bot.BotConfig, readyChan <-chan bool) (*app.App, error) {
+	ignored, err := app.LoadIgnoreList(messagesDB)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := db.NewLinkSnapshot()
+	exporter := app.NewExporter(a.cfg.ExportMode,
+		time.Duration(a.cfg.ExportDebounceSecs)*time.Second,
+		time.Duration(a.cfg.ExportIntervalMins)*time.Minute,
+		app.LinkExporter(messagesDB, a.cfg, snapshot))
+	botCfgPath := ""
+	if a.botCfg == nil {
+		botCfgPath = a.botConfigPath()
+	}
+	return &app.App{
+		Cfg:           a.cfg,
+		MessagesDB:    messagesDB,
+		BotCfg:        botCfg,
+		Client:        client,
+		ReadyChan:     readyChan,
+		KnockKnock:    bot.NewKnockKnockState(),
+		Moderation:    bot.NewModerationState(),
+		DataDeletions: app.NewDataDeletions(),
+		Pages:         bot.NewPagedReplies(),
+		Flood:         app.NewFloodTracker(),
+		Welcome:       app.NewWelcomeLimiter(),
+		Ignored:       ignored,
+		Router:        a.router,
+		SlowMode:      app.NewSlowMode(a.cfg.RoomIDs),
+		Exporter:      exporter,
+		Links:         snapshot,
+		Hooks:         links.NewDispatcher(a.cfg.HookBatchSize, time.Duration(a.cfg.HookBatchSecs)*time.Second),
+		BotConfigPath: botCfgPath,
+	}, nil
+}
@@ -0,0 +1,31 @@
+package ash
+
+import (
+	"context"
+	"testing"
+
+	"github.com/polarhive/ash/config"
+)
+
+func TestBuilder(t *testing.T) {
+	a := New(&config.Config{}).
+		WithCommand("ping", func(ctx context.Context, cmd *Command) {}).
+		WithMiddleware(func(next Handler) Handler { return next })
+	if _, ok := a.Router().Lookup("ping"); !ok {
+		t.Error("expected ping to be registered on the router")
+	}
+	if names := a.Router().Names(); len(names) != 1 {
+		t.Errorf("Names() = %v, want [ping]", names)
+	}
+}
+
+func TestRunOneInstancePerProcess(t *testing.T) {
+	running.Store(true)
+	defer running.Store(false)
+	if err := New(&config.Config{}).Run(context.Background()); err == nil {
+		t.Error("expected Run to fail while another instance is running")
+	}
+	if !running.Load() {
+		t.Error("a refused Run must not clear the running instance's flag")
+	}
+}
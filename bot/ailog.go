@@ -0,0 +1,56 @@
+package bot
+
+import "context"
+
+// AIExchange is one prompt sent to the AI backend and what came back.
+type AIExchange struct {
+	RoomID   string
+	UserID   string
+	Command  string
+	Model    string
+	Prompt   string
+	Response string
+	Error    string
+	Tokens   int
+	OwnKey   bool // billed to the user's own key (/bot aikey)
+}
+
+// AILog, when set, receives every AI request made on behalf of a user, as
+// marked with WithAIOrigin, for usage stats and config.json "AI_LOG".
+var AILog func(ctx context.Context, x AIExchange)
+
+type aiOriginKey struct{}
+
+type aiOrigin struct {
+	roomID, userID, command string
+	ownKey                  bool
+}
+
+// WithAIOrigin records who an AI request made with ctx is for, and whether
+// it uses their own API key, so AILog can attribute it.
+func WithAIOrigin(ctx context.Context, roomID, userID, command string, ownKey bool) context.Context {
+	return context.WithValue(ctx, aiOriginKey{}, aiOrigin{roomID, userID, command, ownKey})
+}
+
+// logAIExchange hands a finished AI request to AILog. Requests without an
+// origin aren't logged.
+func logAIExchange(ctx context.Context, model, prompt, response string, tokens int, err error) {
+	origin, ok := ctx.Value(aiOriginKey{}).(aiOrigin)
+	if AILog == nil || !ok {
+		return
+	}
+	x := AIExchange{
+		RoomID:   origin.roomID,
+		UserID:   origin.userID,
+		Command:  origin.command,
+		Model:    model,
+		Prompt:   prompt,
+		Response: response,
+		Tokens:   tokens,
+		OwnKey:   origin.ownKey,
+	}
+	if err != nil {
+		x.Error = err.Error()
+	}
+	AILog(ctx, x)
+}
@@ -12,9 +12,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/polarhive/ash/locale"
 	"github.com/polarhive/ash/matrix"
 	"github.com/polarhive/ash/util"
 	"github.com/rs/zerolog/log"
@@ -33,26 +33,47 @@ var triviaState *TriviaState
 
 // BotCommand describes a bot command that can return text or images.
 type BotCommand struct {
-	Type         string                 `json:"type"`
-	Method       string                 `json:"method,omitempty"`
-	URL          string                 `json:"url,omitempty"`
-	Headers      map[string]string      `json:"headers,omitempty"`
-	JSONPath     string                 `json:"json_path,omitempty"`
-	ResponseType string                 `json:"response_type,omitempty"`
-	Command      string                 `json:"command,omitempty"`
-	Args         []string               `json:"args,omitempty"`
-	InputType    string                 `json:"input_type,omitempty"`
-	OutputType   string                 `json:"output_type,omitempty"`
-	Model        string                 `json:"model,omitempty"`
-	MaxTokens    int                    `json:"max_tokens,omitempty"`
-	Prompt       string                 `json:"prompt,omitempty"`
-	Response     string                 `json:"response,omitempty"`
-	Params       map[string]interface{} `json:"params,omitempty"`
-	Mention      bool                   `json:"mention,omitempty"`
+	Type               string                 `json:"type"`
+	Method             string                 `json:"method,omitempty"`
+	URL                string                 `json:"url,omitempty"`
+	Headers            map[string]string      `json:"headers,omitempty"`
+	JSONPath           string                 `json:"json_path,omitempty"`
+	ResponseType       string                 `json:"response_type,omitempty"`
+	Command            string                 `json:"command,omitempty"`
+	Args               []string               `json:"args,omitempty"`
+	AnimatedArgs       []string               `json:"animated_args,omitempty"`
+	InputType          string                 `json:"input_type,omitempty"`
+	OutputType         string                 `json:"output_type,omitempty"`
+	Reaction           string                 `json:"reaction,omitempty"` // output_type reaction: emoji on success instead of the output
+	Model              string                 `json:"model,omitempty"`
+	MaxTokens          int                    `json:"max_tokens,omitempty"`
+	Prompt             string                 `json:"prompt,omitempty"`
+	Response           string                 `json:"response,omitempty"`
+	Params             map[string]interface{} `json:"params,omitempty"`
+	Mention            bool                   `json:"mention,omitempty"`
+	Admin              bool                   `json:"admin,omitempty"`
+	CacheSeconds       int                    `json:"cache_seconds,omitempty"`        // http: reuse the response this long
+	CachePersist       bool                   `json:"cache_persist,omitempty"`        // http: keep cached responses in the messages DB
+	Sandbox            *ExecSandbox           `json:"sandbox,omitempty"`              // exec: process limits and wrapper
+	Env                map[string]string      `json:"env,omitempty"`                  // exec: extra environment, with placeholders
+	InheritEnv         []string               `json:"inherit_env,omitempty"`          // exec: bot environment variables to pass through
+	Workdir            string                 `json:"workdir,omitempty"`              // exec: working directory instead of the run's temp dir
+	MaxConcurrent      int                    `json:"max_concurrent,omitempty"`       // exec: runs at once; more wait their turn
+	Progress           bool                   `json:"progress,omitempty"`             // exec: edit a "working..." reply with stdout as it arrives
+	TextOutput         string                 `json:"text_output,omitempty"`          // exec media output: post stdout too, as "caption" or "reply"
+	Format             string                 `json:"format,omitempty"`               // exec, http: "code" posts text output as a code block
+	Language           string                 `json:"language,omitempty"`             // format code: the code block's language
+	Spoiler            bool                   `json:"spoiler,omitempty"`              // hide the text output behind a spoiler
+	MaxDurationSeconds int                    `json:"max_duration_seconds,omitempty"` // download: longest video or clip, default 300
+	MaxSizeMB          int                    `json:"max_size_mb,omitempty"`          // download, upload: largest file, default MAX_UPLOAD_MB
+	Transcode          bool                   `json:"transcode,omitempty"`            // download: re-encode as H.264/AAC MP4
+	AllowedTypes       []string               `json:"allowed_types,omitempty"`        // upload: MIME types or patterns like "image/*"; empty allows any
+	KeepMetadata       bool                   `json:"keep_metadata,omitempty"`        // http, exec image output: don't strip EXIF/GPS
 }
 
 // BotConfig is the structure of bot.json.
 type BotConfig struct {
+	Schema   string                `json:"$schema,omitempty"`
 	Label    string                `json:"label,omitempty"`
 	Commands map[string]BotCommand `json:"commands,omitempty"`
 }
@@ -139,37 +160,16 @@ type KnockKnockStep struct {
 	Label string
 }
 
-// KnockKnockState manages pending knock-knock joke conversations.
-type KnockKnockState struct {
-	mu      sync.Mutex
-	pending map[id.EventID]*KnockKnockStep
-}
+// KnockKnockState manages pending knock-knock joke conversations, keyed by
+// the bot message the next line should reply to.
+type KnockKnockState = ConversationState[id.EventID, *KnockKnockStep]
+
+// knockKnockTTL is how long a knock-knock joke waits for its next reply.
+const knockKnockTTL = 5 * time.Minute
 
 // NewKnockKnockState creates a new KnockKnockState.
 func NewKnockKnockState() *KnockKnockState {
-	return &KnockKnockState{pending: make(map[id.EventID]*KnockKnockStep)}
-}
-
-// Set stores a knock-knock step for the given event ID.
-func (s *KnockKnockState) Set(evID id.EventID, step *KnockKnockStep) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.pending[evID] = step
-}
-
-// Get retrieves a knock-knock step by event ID.
-func (s *KnockKnockState) Get(evID id.EventID) (*KnockKnockStep, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	v, ok := s.pending[evID]
-	return v, ok
-}
-
-// Delete removes a knock-knock step by event ID.
-func (s *KnockKnockState) Delete(evID id.EventID) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.pending, evID)
+	return NewConversationState[id.EventID, *KnockKnockStep](knockKnockTTL)
 }
 
 // ---------------------------------------------------------------------------
@@ -181,15 +181,16 @@ type TriviaAnswer struct {
 	Speaker string
 }
 
-// TriviaState manages pending trivia quiz answers.
-type TriviaState struct {
-	mu      sync.Mutex
-	answers map[id.EventID]string // bot message ID -> speaker
-}
+// TriviaState manages pending trivia quiz answers: the speaker of the quote
+// in each quiz, keyed by the quiz message.
+type TriviaState = ConversationState[id.EventID, string]
+
+// triviaTTL is how long a trivia quiz can still be answered.
+const triviaTTL = 24 * time.Hour
 
 // NewTriviaState creates a new TriviaState.
 func NewTriviaState() *TriviaState {
-	return &TriviaState{answers: make(map[id.EventID]string)}
+	return NewConversationState[id.EventID, string](triviaTTL)
 }
 
 // InitTriviaState initializes the global trivia state.
@@ -197,21 +198,6 @@ func InitTriviaState() {
 	triviaState = NewTriviaState()
 }
 
-// Set stores the speaker for a trivia quiz message.
-func (s *TriviaState) Set(botMsgID id.EventID, speaker string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.answers[botMsgID] = speaker
-}
-
-// Get retrieves the speaker for a trivia quiz by bot message ID.
-func (s *TriviaState) Get(botMsgID id.EventID) (string, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	v, ok := s.answers[botMsgID]
-	return v, ok
-}
-
 // ---------------------------------------------------------------------------
 // Yap leaderboard
 // ---------------------------------------------------------------------------
@@ -228,6 +214,7 @@ func startOfToday() int64 {
 
 // QueryTopYappers returns the top N message senders since midnight for the
 // current room, excluding messages that start with the bot label (e.g. [BOT]).
+// "page N" and "me" show other parts of the leaderboard; see yapViewFor.
 func QueryTopYappers(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client, ev *event.Event, args string, replyLabel string, mention bool) (string, error) {
 	if db == nil {
 		return "", fmt.Errorf("no database available")
@@ -239,21 +226,16 @@ func QueryTopYappers(ctx context.Context, db *sql.DB, matrixClient *mautrix.Clie
 		return queryYapBest(ctx, db, matrixClient, ev, strings.TrimSpace(trimmed[len("best"):]), replyLabel)
 	}
 
+	// Handle "hours [days]" subcommand.
+	if strings.HasPrefix(strings.ToLower(trimmed), "hours") {
+		return queryYapHours(ctx, db, matrixClient, ev, strings.TrimSpace(trimmed[len("hours"):]))
+	}
+
 	// Handle "guess N" subcommand.
 	if strings.HasPrefix(strings.ToLower(trimmed), "guess") {
 		return queryYapGuess(ctx, db, matrixClient, ev, strings.TrimSpace(trimmed[len("guess"):]), replyLabel)
 	}
 
-	limit := 5
-	if args != "" {
-		if n, err := strconv.Atoi(strings.TrimSpace(args)); err == nil && n > 0 {
-			limit = n
-		}
-	}
-	if limit > 50 {
-		limit = 50
-	}
-
 	roomID := string(ev.RoomID)
 	cutoff := startOfToday()
 
@@ -265,47 +247,40 @@ func QueryTopYappers(ctx context.Context, db *sql.DB, matrixClient *mautrix.Clie
 		botID = string(matrixClient.UserID)
 	}
 
-	rows, err := db.QueryContext(ctx, `
-		SELECT sender, SUM(LENGTH(body) - LENGTH(REPLACE(body, ' ', '')) + 1) as word_count
-		FROM messages
-		WHERE room_id = ?
-		  AND ts_ms >= ?
-		  AND body NOT LIKE '/bot %'
-		  AND (body NOT LIKE '[BOT] %' OR sender != ?)
-		  AND msgtype = 'm.text'
-		GROUP BY sender
-		ORDER BY word_count DESC
-		LIMIT ?
-	`, roomID, cutoff, botID, limit)
+	counts, err := yapWordCounts(ctx, db, roomID, cutoff, botID)
 	if err != nil {
 		return "", fmt.Errorf("query yappers: %w", err)
 	}
-	defer rows.Close()
-
-	// Pre-fetch room members for display name resolution.
-	displayNames := make(map[string]string)
-	if matrixClient != nil {
-		if resp, err := matrixClient.JoinedMembers(ctx, ev.RoomID); err == nil {
-			for uid, member := range resp.Joined {
-				if member.DisplayName != "" {
-					displayNames[string(uid)] = member.DisplayName
-				}
-			}
+	if len(counts) == 0 {
+		return locale.ForRoom(roomID, "no_messages_today"), nil
+	}
+	self := -1
+	for i, c := range counts {
+		if c.sender == string(ev.Sender) {
+			self = i
 		}
 	}
-
-	type yapEntry struct {
-		senderID string
-		display  string
-		count    int
+	view := yapViewFor(trimmed, len(counts), self)
+	if view.empty != "" {
+		return view.empty, nil
 	}
-	var entries []yapEntry
-	for rows.Next() {
-		var sender string
-		var count int
-		if err := rows.Scan(&sender, &count); err != nil {
-			continue
-		}
+	// Movement since yesterday is only shown once there is a yesterday to
+	// compare with.
+	prevRanks, err := yesterdayRanks(ctx, db, roomID, botID, time.Now())
+	if err != nil {
+		log.Warn().Err(err).Str("room", roomID).Msg("failed to load yesterday's yap ranks")
+	}
+
+	// Pre-fetch room members for display name resolution.
+	displayNames := matrix.RoomDisplayNames(ctx, matrixClient, ev.RoomID)
+
+	// Build plain text and HTML versions.
+	esc := event.TextToHTML
+	var plain, html strings.Builder
+	plain.WriteString(replyLabel + view.title + "\n")
+	html.WriteString(esc(replyLabel+view.title) + "<br>")
+	for i := view.start; i < view.end; i++ {
+		sender, count := counts[i].sender, counts[i].words
 		display := sender
 		if dn, ok := displayNames[sender]; ok {
 			display = dn
@@ -314,36 +289,26 @@ func QueryTopYappers(ctx context.Context, db *sql.DB, matrixClient *mautrix.Clie
 				display = sender[1:idx]
 			}
 		}
-		entries = append(entries, yapEntry{senderID: sender, display: display, count: count})
-	}
-
-	if len(entries) == 0 {
-		return "no messages found today", nil
-	}
-
-	// Build plain text and HTML versions.
-	var plain, html strings.Builder
-	plain.WriteString(replyLabel + "top yappers (today):\n")
-	html.WriteString(replyLabel + "top yappers (today):<br>")
-	for i, e := range entries {
-		plain.WriteString(fmt.Sprintf("%d. %s \u2014 %d words\n", i+1, e.display, e.count))
+		marker := ""
+		if len(prevRanks) > 0 {
+			if m := yapMovement(prevRanks[sender], i+1); m != "" {
+				marker = " (" + m + ")"
+			}
+		}
+		if view.markSelf && i == self {
+			marker += " \u2190 you"
+		}
+		plain.WriteString(fmt.Sprintf("%d. %s \u2014 %d words%s\n", i+1, display, count, marker))
 		if mention {
-			html.WriteString(fmt.Sprintf("%d. <a href=\"https://matrix.to/#/%s\">%s</a> \u2014 %d words<br>", i+1, e.senderID, e.display, e.count))
+			html.WriteString(fmt.Sprintf("%d. <a href=\"%s\">%s</a> \u2014 %d words%s<br>", i+1, esc(matrix.UserLink(id.UserID(sender))), esc(display), count, esc(marker)))
 		} else {
-			html.WriteString(fmt.Sprintf("%d. %s \u2014 %d words<br>", i+1, e.display, e.count))
+			html.WriteString(fmt.Sprintf("%d. %s \u2014 %d words%s<br>", i+1, esc(display), count, esc(marker)))
 		}
 	}
 
 	// Send the formatted message directly.
 	if matrixClient != nil {
-		content := event.MessageEventContent{
-			MsgType:       event.MsgText,
-			Body:          strings.TrimSpace(plain.String()),
-			Format:        event.FormatHTML,
-			FormattedBody: strings.TrimSuffix(html.String(), "<br>"),
-			RelatesTo:     &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: ev.ID}},
-		}
-		if _, err := matrixClient.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content); err != nil {
+		if _, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, ev.ID, strings.TrimSpace(plain.String()), strings.TrimSuffix(html.String(), "<br>")); err != nil {
 			return "", fmt.Errorf("send yap reply: %w", err)
 		}
 		return "", nil
@@ -355,7 +320,21 @@ func QueryTopYappers(ctx context.Context, db *sql.DB, matrixClient *mautrix.Clie
 
 // queryYapGuess handles "/bot yap guess N". It looks up the caller's actual
 // position on today's (since midnight UTC) word-count leaderboard and reports the difference.
+// Close guesses earn points under YapGuess; "/bot yap guess scores" shows
+// this week's points.
 func queryYapGuess(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client, ev *event.Event, guessArg string, replyLabel string) (string, error) {
+	now := time.Now()
+	if a := strings.ToLower(strings.TrimSpace(guessArg)); a == "scores" || a == "points" {
+		msg, err := YapGuessStandings(ctx, db, matrixClient, ev.RoomID, replyLabel+"yap guess points this week:", StartOfWeek(now), now.Add(time.Millisecond))
+		if err != nil {
+			return "", err
+		}
+		if msg == "" {
+			return "nobody has scored this week yet", nil
+		}
+		return msg, nil
+	}
+
 	guess := 1
 	if guessArg != "" {
 		if n, err := strconv.Atoi(strings.TrimSpace(guessArg)); err == nil && n > 0 {
@@ -375,35 +354,17 @@ func queryYapGuess(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client
 		botID = string(matrixClient.UserID)
 	}
 
-	rows, err := db.QueryContext(ctx, `
-		SELECT sender, SUM(LENGTH(body) - LENGTH(REPLACE(body, ' ', '')) + 1) as word_count
-		FROM messages
-		WHERE room_id = ?
-		  AND ts_ms >= ?
-		  AND body NOT LIKE '/bot %'
-		  AND (body NOT LIKE '[BOT] %' OR sender != ?)
-		  AND msgtype = 'm.text'
-		GROUP BY sender
-		ORDER BY word_count DESC
-	`, roomID, cutoff, botID)
+	counts, err := yapWordCounts(ctx, db, roomID, cutoff, botID)
 	if err != nil {
 		return "", fmt.Errorf("query yap guess: %w", err)
 	}
-	defer rows.Close()
 
 	actualPos := 0
 	totalWords := 0
-	rank := 0
-	for rows.Next() {
-		var sender string
-		var count int
-		if err := rows.Scan(&sender, &count); err != nil {
-			continue
-		}
-		rank++
-		if sender == senderID {
-			actualPos = rank
-			totalWords = count
+	for i, c := range counts {
+		if c.sender == senderID {
+			actualPos = i + 1
+			totalWords = c.words
 		}
 	}
 
@@ -411,6 +372,17 @@ func queryYapGuess(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client
 		return "you have no messages today!", nil
 	}
 
+	// Guesses are limited per day so the leaderboard can't be probed one
+	// place at a time. Without the game tables the guess is still answered,
+	// just not scored.
+	scored := true
+	if limitMsg, err := yapGuessTurn(db, roomID, senderID, now); err != nil {
+		log.Warn().Err(err).Msg("yap guess scoring unavailable")
+		scored = false
+	} else if limitMsg != "" {
+		return limitMsg, nil
+	}
+
 	diff := guess - actualPos
 	var msg string
 	if diff == 0 {
@@ -426,14 +398,16 @@ func queryYapGuess(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client
 		msg = fmt.Sprintf("%syou guessed #%d but you're actually #%d (%d words) — %d position(s) %s than you thought",
 			replyLabel, guess, actualPos, totalWords, absDiff, direction)
 	}
+	if scored {
+		reward, err := awardYapGuess(db, roomID, senderID, guess, actualPos, now)
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to award yap guess points")
+		}
+		msg += reward
+	}
 
 	if matrixClient != nil {
-		content := event.MessageEventContent{
-			MsgType:   event.MsgText,
-			Body:      msg,
-			RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: ev.ID}},
-		}
-		if _, err := matrixClient.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content); err != nil {
+		if _, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, ev.ID, msg, ""); err != nil {
 			return "", fmt.Errorf("send yap guess reply: %w", err)
 		}
 		return "", nil
@@ -517,21 +491,13 @@ func queryYapBest(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client,
 	}
 
 	// Pre-fetch display names
-	displayNames := make(map[string]string)
-	if matrixClient != nil {
-		if resp, err := matrixClient.JoinedMembers(ctx, ev.RoomID); err == nil {
-			for uid, member := range resp.Joined {
-				if member.DisplayName != "" {
-					displayNames[string(uid)] = member.DisplayName
-				}
-			}
-		}
-	}
+	displayNames := matrix.RoomDisplayNames(ctx, matrixClient, ev.RoomID)
 
 	// Build output
+	esc := event.TextToHTML
 	var plain, html strings.Builder
 	plain.WriteString(replyLabel + "🔥 most reacted (today):\n")
-	html.WriteString(replyLabel + "🔥 most reacted (today):<br>")
+	html.WriteString(esc(replyLabel) + "🔥 most reacted (today):<br>")
 
 	for i, m := range messages {
 		display := m.sender
@@ -549,19 +515,12 @@ func queryYapBest(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client,
 		}
 
 		plain.WriteString(fmt.Sprintf("%d. %s %s (%d) — %s\n", i+1, m.emojis, truncated, m.reactions, display))
-		html.WriteString(fmt.Sprintf("%d. %s %s (%d) — %s<br>", i+1, m.emojis, truncated, m.reactions, display))
+		html.WriteString(fmt.Sprintf("%d. %s %s (%d) — %s<br>", i+1, esc(m.emojis), esc(truncated), m.reactions, esc(display)))
 	}
 
 	// Send the formatted message
 	if matrixClient != nil {
-		content := event.MessageEventContent{
-			MsgType:       event.MsgText,
-			Body:          strings.TrimSpace(plain.String()),
-			Format:        event.FormatHTML,
-			FormattedBody: strings.TrimSuffix(html.String(), "<br>"),
-			RelatesTo:     &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: ev.ID}},
-		}
-		if _, err := matrixClient.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content); err != nil {
+		if _, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, ev.ID, strings.TrimSpace(plain.String()), strings.TrimSuffix(html.String(), "<br>")); err != nil {
 			return "", fmt.Errorf("send yap best reply: %w", err)
 		}
 		return "", nil
@@ -626,18 +585,14 @@ func QueryRandomQuote(ctx context.Context, db *sql.DB, matrixClient *mautrix.Cli
 	if sender == "" {
 		sender, body, tsMs, err = findRandomQuote(ctx, db, roomID, botID, cutoff)
 		if err != nil {
-			return "no messages found to quote", nil
+			return locale.ForRoom(roomID, "no_messages_to_quote"), nil
 		}
 	}
 
 	// Resolve display name.
 	display := sender
-	if matrixClient != nil {
-		if resp, err := matrixClient.JoinedMembers(ctx, ev.RoomID); err == nil {
-			if member, ok := resp.Joined[id.UserID(sender)]; ok && member.DisplayName != "" {
-				display = member.DisplayName
-			}
-		}
+	if name := matrix.DisplayName(ctx, matrixClient, ev.RoomID, id.UserID(sender)); name != "" {
+		display = name
 	}
 	if display == sender && strings.HasPrefix(sender, "@") {
 		if idx := strings.Index(sender, ":"); idx > 0 {
@@ -649,17 +604,11 @@ func QueryRandomQuote(ctx context.Context, db *sql.DB, matrixClient *mautrix.Cli
 	date := ts.Format("02 Jan 2006")
 
 	plain := fmt.Sprintf("%s> %s\n> \u2014 %s, %s", replyLabel, body, display, date)
-	html := fmt.Sprintf("%s<blockquote>%s<br>\u2014 <i>%s, %s</i></blockquote>", replyLabel, body, display, date)
+	esc := event.TextToHTML
+	html := fmt.Sprintf("%s<blockquote>%s<br>\u2014 <i>%s, %s</i></blockquote>", esc(replyLabel), esc(body), esc(display), date)
 
 	if matrixClient != nil {
-		content := event.MessageEventContent{
-			MsgType:       event.MsgText,
-			Body:          plain,
-			Format:        event.FormatHTML,
-			FormattedBody: html,
-			RelatesTo:     &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: id.EventID(replyTargetID)}},
-		}
-		if _, err := matrixClient.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content); err != nil {
+		if _, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, id.EventID(replyTargetID), plain, html); err != nil {
 			return "", fmt.Errorf("send quote reply: %w", err)
 		}
 		return "", nil
@@ -1040,12 +989,8 @@ func QueryQuotesForUser(ctx context.Context, db *sql.DB, matrixClient *mautrix.C
 
 	// Resolve display name for header
 	display := targetSender
-	if matrixClient != nil {
-		if resp, err := matrixClient.JoinedMembers(ctx, ev.RoomID); err == nil {
-			if member, ok := resp.Joined[id.UserID(targetSender)]; ok && member.DisplayName != "" {
-				display = member.DisplayName
-			}
-		}
+	if name := matrix.DisplayName(ctx, matrixClient, ev.RoomID, id.UserID(targetSender)); name != "" {
+		display = name
 	}
 	if display == targetSender && strings.HasPrefix(targetSender, "@") {
 		if idx := strings.Index(targetSender, ":"); idx > 0 {
@@ -1054,25 +999,19 @@ func QueryQuotesForUser(ctx context.Context, db *sql.DB, matrixClient *mautrix.C
 	}
 
 	// Build output
+	esc := event.TextToHTML
 	var plain, html strings.Builder
 	plain.WriteString(fmt.Sprintf("%squotes for %s:\n", replyLabel, display))
-	html.WriteString(fmt.Sprintf("%squotes for %s:<br>", replyLabel, display))
+	html.WriteString(fmt.Sprintf("%squotes for %s:<br>", esc(replyLabel), esc(display)))
 
 	for i, q := range quotes {
 		date := time.UnixMilli(q.LoggedAt).In(YapTimezone).Format("02 Jan 2006")
 		plain.WriteString(fmt.Sprintf("> %d. %s (%s)\n", i+1, q.Message, date))
-		html.WriteString(fmt.Sprintf("> %d. %s (%s)<br>", i+1, q.Message, date))
+		html.WriteString(fmt.Sprintf("&gt; %d. %s (%s)<br>", i+1, esc(q.Message), date))
 	}
 
 	if matrixClient != nil {
-		content := event.MessageEventContent{
-			MsgType:       event.MsgText,
-			Body:          strings.TrimSpace(plain.String()),
-			Format:        event.FormatHTML,
-			FormattedBody: strings.TrimSuffix(html.String(), "<br>"),
-			RelatesTo:     &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: ev.ID}},
-		}
-		if _, err := matrixClient.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content); err != nil {
+		if _, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, ev.ID, strings.TrimSpace(plain.String()), strings.TrimSuffix(html.String(), "<br>")); err != nil {
 			return "", fmt.Errorf("send quotes reply: %w", err)
 		}
 		return "", nil
@@ -1212,12 +1151,8 @@ func QueryFlipOpinion(ctx context.Context, db *sql.DB, matrixClient *mautrix.Cli
 
 	// Resolve display name
 	display := targetSender
-	if matrixClient != nil {
-		if resp, err := matrixClient.JoinedMembers(ctx, ev.RoomID); err == nil {
-			if member, ok := resp.Joined[id.UserID(targetSender)]; ok && member.DisplayName != "" {
-				display = member.DisplayName
-			}
-		}
+	if name := matrix.DisplayName(ctx, matrixClient, ev.RoomID, id.UserID(targetSender)); name != "" {
+		display = name
 	}
 	if display == targetSender && strings.HasPrefix(targetSender, "@") {
 		if idx := strings.Index(targetSender, ":"); idx > 0 {
@@ -1229,17 +1164,11 @@ func QueryFlipOpinion(ctx context.Context, db *sql.DB, matrixClient *mautrix.Cli
 	newDate := time.UnixMilli(targetTs).In(YapTimezone).Format("02 Jan 2006")
 
 	plain := fmt.Sprintf("%s🔄 flip:\n> %s (%s)\n> ↓\n> %s (%s)", replyLabel, oldBody, oldDate, targetBody, newDate)
-	html := fmt.Sprintf("%s🔄 flip:<br><blockquote>%s (%s)<br>↓<br>%s (%s)</blockquote>", replyLabel, oldBody, oldDate, targetBody, newDate)
+	esc := event.TextToHTML
+	html := fmt.Sprintf("%s🔄 flip:<br><blockquote>%s (%s)<br>↓<br>%s (%s)</blockquote>", esc(replyLabel), esc(oldBody), oldDate, esc(targetBody), newDate)
 
 	if matrixClient != nil {
-		content := event.MessageEventContent{
-			MsgType:       event.MsgText,
-			Body:          plain,
-			Format:        event.FormatHTML,
-			FormattedBody: html,
-			RelatesTo:     &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: id.EventID(replyTargetID)}},
-		}
-		if _, err := matrixClient.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content); err != nil {
+		if _, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, id.EventID(replyTargetID), plain, html); err != nil {
 			return "", fmt.Errorf("send flip reply: %w", err)
 		}
 		return "", nil
@@ -1356,19 +1285,15 @@ func QueryTrivia(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client,
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "no messages found", nil
+			return locale.ForRoom(roomID, "no_messages"), nil
 		}
 		return "", err
 	}
 
 	// Resolve display name for speaker (for answer, but hidden in quiz)
 	display := speaker
-	if matrixClient != nil {
-		if resp, err := matrixClient.JoinedMembers(ctx, ev.RoomID); err == nil {
-			if member, ok := resp.Joined[id.UserID(speaker)]; ok && member.DisplayName != "" {
-				display = member.DisplayName
-			}
-		}
+	if name := matrix.DisplayName(ctx, matrixClient, ev.RoomID, id.UserID(speaker)); name != "" {
+		display = name
 	}
 	if display == speaker && strings.HasPrefix(speaker, "@") {
 		if idx := strings.Index(speaker, ":"); idx > 0 {
@@ -1377,24 +1302,17 @@ func QueryTrivia(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client,
 	}
 
 	plain := fmt.Sprintf("%s❓ who said: %q", replyLabel, body)
-	html := fmt.Sprintf("%s❓ who said: <i>%s</i>", replyLabel, body)
+	html := fmt.Sprintf("%s❓ who said: <i>%s</i>", event.TextToHTML(replyLabel), event.TextToHTML(body))
 
 	if matrixClient != nil {
-		content := event.MessageEventContent{
-			MsgType:       event.MsgText,
-			Body:          plain,
-			Format:        event.FormatHTML,
-			FormattedBody: html,
-			RelatesTo:     &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: ev.ID}},
-		}
-		resp, err := matrixClient.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content)
+		quizID, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, ev.ID, plain, html)
 		if err != nil {
 			return "", fmt.Errorf("send trivia quiz: %w", err)
 		}
 
 		// Store the answer in memory for later retrieval
 		if triviaState != nil {
-			triviaState.Set(resp.EventID, speaker)
+			triviaState.Set(quizID, speaker)
 		}
 
 		return "", nil
@@ -1453,12 +1371,7 @@ func QueryMadlibs(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client,
 	story := fmt.Sprintf(template, words[0], words[1], words[2], words[3], words[4])
 
 	if matrixClient != nil {
-		content := event.MessageEventContent{
-			MsgType:   event.MsgText,
-			Body:      story,
-			RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: ev.ID}},
-		}
-		if _, err := matrixClient.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content); err != nil {
+		if _, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, ev.ID, story, ""); err != nil {
 			return "", fmt.Errorf("send madlibs: %w", err)
 		}
 		return "", nil
@@ -1589,12 +1502,8 @@ func QueryPredict(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client,
 
 	// Resolve display name
 	display := targetSender
-	if matrixClient != nil {
-		if resp, err := matrixClient.JoinedMembers(ctx, ev.RoomID); err == nil {
-			if member, ok := resp.Joined[id.UserID(targetSender)]; ok && member.DisplayName != "" {
-				display = member.DisplayName
-			}
-		}
+	if name := matrix.DisplayName(ctx, matrixClient, ev.RoomID, id.UserID(targetSender)); name != "" {
+		display = name
 	}
 	if display == targetSender && strings.HasPrefix(targetSender, "@") {
 		if idx := strings.Index(targetSender, ":"); idx > 0 {
@@ -1605,12 +1514,7 @@ func QueryPredict(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client,
 	plain := fmt.Sprintf("%s🔮 %s would probably say: %q", replyLabel, display, prediction)
 
 	if matrixClient != nil {
-		content := event.MessageEventContent{
-			MsgType:   event.MsgText,
-			Body:      plain,
-			RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: ev.ID}},
-		}
-		if _, err := matrixClient.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content); err != nil {
+		if _, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, ev.ID, plain, ""); err != nil {
 			return "", fmt.Errorf("send predict: %w", err)
 		}
 		return "", nil
@@ -1,10 +1,24 @@
 package bot
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +26,9 @@ import (
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
 )
 
 func TestLoadBotConfig(t *testing.T) {
@@ -34,7 +51,7 @@ func TestLoadBotConfig(t *testing.T) {
 			continue
 		}
 		switch cmd.Type {
-		case "http", "exec", "ai", "builtin":
+		case "http", "exec", "ai", "builtin", "download":
 		default:
 			t.Errorf("command %q has invalid type %q", name, cmd.Type)
 		}
@@ -436,3 +453,1333 @@ func TestQueryRandomQuote(t *testing.T) {
 		t.Errorf("expected alice or bob in quote, got: %s", result)
 	}
 }
+
+func TestParseModerationArgs(t *testing.T) {
+	tests := []struct {
+		args       string
+		wantTarget id.UserID
+		wantReason string
+	}{
+		{"@spam:example.com", "@spam:example.com", ""},
+		{"@spam:example.com posting ads", "@spam:example.com", "posting ads"},
+		{"posting ads", "", "posting ads"},
+		{"@notanmxid too", "", "@notanmxid too"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		target, reason := ParseModerationArgs(tt.args)
+		if target != tt.wantTarget || reason != tt.wantReason {
+			t.Errorf("ParseModerationArgs(%q) = (%q, %q), want (%q, %q)", tt.args, target, reason, tt.wantTarget, tt.wantReason)
+		}
+	}
+}
+
+func TestIsConfirmation(t *testing.T) {
+	for _, body := range []string{"yes", "Y", " confirm ", "> <@bot:example.com> reply \"yes\" to confirm\n\nyes"} {
+		if !IsConfirmation(body) {
+			t.Errorf("IsConfirmation(%q) = false, want true", body)
+		}
+	}
+	for _, body := range []string{"no", "yes please", ""} {
+		if IsConfirmation(body) {
+			t.Errorf("IsConfirmation(%q) = true, want false", body)
+		}
+	}
+}
+
+func TestBotConfigValidate(t *testing.T) {
+	if cfg, err := LoadBotConfig("../bot.json"); err == nil {
+		if errs := cfg.Validate(); len(errs) > 0 {
+			t.Errorf("bot.json should validate, got %v", errs)
+		}
+	}
+	bad := &BotConfig{Commands: map[string]BotCommand{
+		"static": {Response: "hi"},
+		"notype": {},
+		"fry":    {Type: "exec", Command: "magick", Args: []string{"{input}"}, OutputType: "image"},
+		"ask":    {Type: "ai", Prompt: "p", Model: "m"},
+		"weird":  {Type: "builtin", Command: "x", OutputType: "video"},
+		"thread": {Type: "http", URL: "https://example.com", InputType: "thread"},
+	}}
+	errs := bad.Validate()
+	if len(errs) != 5 {
+		t.Errorf("expected 5 errors, got %d: %v", len(errs), errs)
+	}
+	if errs := (&BotConfig{}).Validate(); len(errs) != 1 {
+		t.Errorf("empty config should report one error, got %v", errs)
+	}
+}
+
+func TestLintBotConfig(t *testing.T) {
+	if raw, err := os.ReadFile("../bot.json"); err == nil {
+		if errs := LintBotConfig(raw); len(errs) > 0 {
+			t.Errorf("bot.json should lint clean, got %v", errs)
+		}
+	}
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"unknown field", `{"commands":{"hi":{"respnse":"hello"}}}`, `unknown field "respnse"`},
+		{"duplicate", `{"commands":{"hi":{"response":"a"},"hi":{"response":"b"}}}`, "command hi: defined more than once"},
+		{"unknown builtin", `{"commands":{"x":{"type":"builtin","command":"nope"}}}`, `command x: unknown builtin "nope"`},
+		{"embedded placeholder", `{"commands":{"x":{"type":"exec","command":"c","args":["-o={output}"],"output_type":"image"}}}`, "must be a whole argument"},
+		{"unknown placeholder", `{"commands":{"x":{"type":"exec","command":"c","args":["{inptu}"]}}}`, "unknown placeholder {inptu}"},
+		{"unused input", `{"commands":{"x":{"type":"exec","command":"c","args":["{input}"]}}}`, "only filled in for input_type image or text"},
+		{"args on http", `{"commands":{"x":{"type":"http","url":"https://example.com","args":["a"]}}}`, "only used by exec"},
+		{"cache on exec", `{"commands":{"x":{"type":"exec","command":"c","cache_seconds":60}}}`, "only used by http"},
+		{"persist without cache", `{"commands":{"x":{"type":"http","url":"https://example.com","cache_persist":true}}}`, "no effect without cache_seconds"},
+		{"video on http", `{"commands":{"x":{"type":"http","url":"https://example.com","output_type":"video"}}}`, "only supported for exec"},
+		{"output for text", `{"commands":{"x":{"type":"exec","command":"c","args":["{output}"]}}}`, "only read for image, audio, video, file or media"},
+		{"sandbox on http", `{"commands":{"x":{"type":"http","url":"https://example.com","sandbox":{"timeout_seconds":5}}}}`, "only used by exec commands"},
+		{"sandbox on uwuify", `{"commands":{"x":{"type":"builtin","command":"uwuify","sandbox":{"timeout_seconds":5}}}}`, "only used by exec commands, download commands and builtins that run programs"},
+		{"env on http", `{"commands":{"x":{"type":"http","url":"https://example.com","env":{"A":"b"}}}}`, "only used by exec commands"},
+		{"unknown env placeholder", `{"commands":{"x":{"type":"exec","command":"c","env":{"WHO":"{user}"}}}}`, "unknown placeholder {user} in env WHO"},
+		{"workdir on ai", `{"commands":{"x":{"type":"ai","prompt":"p","model":"m","max_tokens":1,"workdir":"/srv"}}}`, "only used by exec commands"},
+		{"bad inherit_env", `{"commands":{"x":{"type":"exec","command":"c","inherit_env":[""]}}}`, `invalid inherit_env name ""`},
+		{"format on ai", `{"commands":{"x":{"type":"ai","prompt":"p","model":"m","max_tokens":5,"format":"code"}}}`, "format code is only supported"},
+		{"spoiler on image", `{"commands":{"x":{"type":"exec","command":"c","args":["{output}"],"output_type":"image","spoiler":true}}}`, "spoiler only applies to text output"},
+		{"language without format", `{"commands":{"x":{"type":"exec","command":"c","language":"json"}}}`, "language requires format code"},
+		{"bad language", `{"commands":{"x":{"type":"exec","command":"c","format":"code","language":"\"><script>"}}}`, "invalid language"},
+		{"text_output on text", `{"commands":{"x":{"type":"exec","command":"c","text_output":"caption"}}}`, "text_output only applies"},
+		{"bad text_output", `{"commands":{"x":{"type":"exec","command":"c","args":["{output}"],"output_type":"image","text_output":"both"}}}`, `invalid text_output "both"`},
+		{"progress reaction", `{"commands":{"x":{"type":"exec","command":"c","progress":true,"output_type":"reaction"}}}`, "progress has no effect"},
+		{"negative max_concurrent", `{"commands":{"x":{"type":"exec","command":"c","max_concurrent":-1}}}`, "max_concurrent must not be negative"},
+		{"bad env name", `{"commands":{"x":{"type":"exec","command":"c","env":{"A=B":"c"}}}}`, `invalid env name "A=B"`},
+		{"negative sandbox", `{"commands":{"x":{"type":"exec","command":"c","sandbox":{"memory_mb":-1}}}}`, "must not be negative"},
+		{"transcode on exec", `{"commands":{"x":{"type":"exec","command":"c","transcode":true}}}`, "only used by download commands"},
+		{"allowed_types on exec", `{"commands":{"x":{"type":"exec","command":"c","allowed_types":["image/*"]}}}`, "only used by the upload builtin"},
+		{"max_size_mb on gif", `{"commands":{"x":{"type":"builtin","command":"gif","max_size_mb":5}}}`, "max_size_mb is only used"},
+		{"keep_metadata on text", `{"commands":{"x":{"type":"exec","command":"c","output_type":"text","keep_metadata":true}}}`, "keep_metadata is only used"},
+		{"command on download", `{"commands":{"x":{"type":"download","command":"yt-dlp"}}}`, "don't use command or args"},
+		{"negative max_duration", `{"commands":{"x":{"type":"download","max_duration_seconds":-1}}}`, "must not be negative"},
+		{"unknown sandbox field", `{"commands":{"x":{"type":"exec","command":"c","sandbox":{"timeout":5}}}}`, `unknown field "timeout"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := LintBotConfig([]byte(tt.raw))
+			var found bool
+			for _, err := range errs {
+				found = found || strings.Contains(err.Error(), tt.want)
+			}
+			if !found {
+				t.Errorf("expected an error containing %q, got %v", tt.want, errs)
+			}
+		})
+	}
+	if errs := LintBotConfig([]byte(`{"commands":{"u":{"type":"builtin","command":"uwuify"},"k":{"type":"builtin","command":"kick"},"r":{"type":"builtin","command":"report"},"t":{"type":"builtin","command":"tex","sandbox":{"timeout_seconds":5}}}}`)); len(errs) != 0 {
+		t.Errorf("known builtins should lint clean, got %v", errs)
+	}
+
+	defer func(v bool) { resourceLimits = v }(resourceLimits)
+	resourceLimits = false
+	if errs := LintBotConfig([]byte(`{"commands":{"x":{"type":"exec","command":"c","sandbox":{"cpu_seconds":5}}}}`)); len(errs) != 1 || !strings.Contains(errs[0].Error(), "aren't supported") {
+		t.Errorf("cpu_seconds without ulimit: %v", errs)
+	}
+}
+
+func TestHTTPCommandCache(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"n": %d}`, hits)
+	}))
+	defer srv.Close()
+	messagesDB, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messagesDB.Close()
+	ctx := context.Background()
+
+	uncached := &BotCommand{Type: "http", URL: srv.URL + "/a"}
+	for range 2 {
+		if _, _, err := fetchHttpCommand(ctx, uncached, messagesDB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits != 2 {
+		t.Errorf("uncached: %d requests, want 2", hits)
+	}
+
+	hits = 0
+	cached := &BotCommand{Type: "http", URL: srv.URL + "/b", CacheSeconds: 60, CachePersist: true}
+	for range 3 {
+		body, ct, err := fetchHttpCommand(ctx, cached, messagesDB)
+		if err != nil || string(body) != `{"n": 1}` || ct != "application/json" {
+			t.Fatalf("cached: %q %q %v", body, ct, err)
+		}
+	}
+	httpCacheMu.Lock()
+	clear(httpCache)
+	httpCacheMu.Unlock()
+	if body, _, _ := fetchHttpCommand(ctx, cached, messagesDB); string(body) != `{"n": 1}` || hits != 1 {
+		t.Errorf("after a restart: %q, %d requests", body, hits)
+	}
+	other := *cached
+	other.Headers = map[string]string{"Accept": "text/plain"}
+	if _, _, err := fetchHttpCommand(ctx, &other, messagesDB); err != nil || hits != 2 {
+		t.Errorf("different headers: %d requests, want 2", hits)
+	}
+}
+
+func TestYapCountWords(t *testing.T) {
+	all := NewYapWordFilter([]string{"urls", "code", "quotes", "emoji"})
+	tests := []struct {
+		name   string
+		filter YapWordFilter
+		body   string
+		want   int
+	}{
+		{"default counts spaces", YapWordFilter{}, "see https://example.com/a/very/long/path now", 3},
+		{"url", YapWordFilter{URLs: true}, "see https://example.com/a/very/long/path now", 2},
+		{"quote fallback", YapWordFilter{Quotes: true}, "> <@bob:example.com> what do you think about it\n\nsounds good", 2},
+		{"fenced code", YapWordFilter{CodeBlocks: true}, "try this\n```\nfor i := range x {\n}\n```\nok?", 3},
+		{"inline code", YapWordFilter{CodeBlocks: true}, "run `go test ./...` first", 2},
+		{"emoji", YapWordFilter{Emoji: true}, "nice 🎉 🔥🔥 👍🏽 work", 2},
+		{"everything", all, "> quoted line\nlol 😂 https://x.com `code`", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.CountWords(tt.body); got != tt.want {
+				t.Errorf("CountWords(%q) = %d, want %d", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYapWordCounts(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE messages (id TEXT PRIMARY KEY, room_id TEXT, sender TEXT, ts_ms INTEGER, body TEXT, msgtype TEXT, raw_json TEXT)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	room := "!testroom:example.com"
+	now := time.Now().UnixMilli()
+	for i, m := range []struct{ sender, body string }{
+		{"@alice:example.com", "one two three"},
+		{"@bob:example.com", "https://example.com/x https://example.com/y"},
+		{"@bob:example.com", "/bot yap"},
+		{"@bot:example.com", "[BOT] top yappers"},
+	} {
+		if _, err := db.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, ?, ?, ?, ?, 'm.text')`, fmt.Sprint(i), room, m.sender, now, m.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts, err := yapWordCounts(context.Background(), db, room, 0, "@bot:example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 || counts[0].sender != "@alice:example.com" || counts[0].words != 3 || counts[1].words != 2 {
+		t.Errorf("unfiltered counts = %+v", counts)
+	}
+
+	YapFilter = YapWordFilter{URLs: true}
+	defer func() { YapFilter = YapWordFilter{} }()
+	counts, _ = yapWordCounts(context.Background(), db, room, 0, "@bot:example.com")
+	if len(counts) != 2 || counts[1].sender != "@bob:example.com" || counts[1].words != 0 {
+		t.Errorf("URL-filtered counts = %+v", counts)
+	}
+}
+
+func TestYapWordCountsTieBreak(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE messages (id TEXT PRIMARY KEY, room_id TEXT, sender TEXT, ts_ms INTEGER, body TEXT, msgtype TEXT, raw_json TEXT)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	room := "!testroom:example.com"
+	now := time.Now().UnixMilli()
+	// carol and alice both end on 2 words, but carol got there first; bob
+	// ties with carol on time, so user ID decides.
+	for i, m := range []struct {
+		sender string
+		ts     int64
+	}{
+		{"@alice:example.com", now},
+		{"@carol:example.com", now + 1},
+		{"@bob:example.com", now + 2},
+		{"@carol:example.com", now + 3},
+		{"@bob:example.com", now + 3},
+		{"@alice:example.com", now + 4},
+	} {
+		if _, err := db.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, ?, ?, ?, 'hi', 'm.text')`, fmt.Sprint(i), room, m.sender, m.ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts, err := yapWordCounts(context.Background(), db, room, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, c := range counts {
+		order = append(order, c.sender)
+	}
+	if want := "@bob:example.com @carol:example.com @alice:example.com"; strings.Join(order, " ") != want {
+		t.Errorf("order = %v, want %s", order, want)
+	}
+}
+
+func TestYapViewFor(t *testing.T) {
+	tests := []struct {
+		args       string
+		n, self    int
+		start, end int
+		empty      bool
+	}{
+		{"", 40, -1, 0, 5, false},
+		{"100", 40, -1, 0, 40, false},
+		{"100", 80, -1, 0, 50, false},
+		{"page 2", 25, -1, 10, 20, false},
+		{"page 3", 25, -1, 20, 25, false},
+		{"page 4", 25, -1, 0, 0, true},
+		{"me", 25, 12, 9, 16, false},
+		{"around me", 25, 1, 0, 5, false},
+		{"me", 25, 24, 21, 25, false},
+		{"me", 25, -1, 0, 0, true},
+	}
+	for _, tt := range tests {
+		v := yapViewFor(tt.args, tt.n, tt.self)
+		if (v.empty != "") != tt.empty || (!tt.empty && (v.start != tt.start || v.end != tt.end)) {
+			t.Errorf("yapViewFor(%q, %d, %d) = %+v, want [%d:%d] empty=%v", tt.args, tt.n, tt.self, v, tt.start, tt.end, tt.empty)
+		}
+	}
+}
+
+func TestYesterdayRanks(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE messages (id TEXT PRIMARY KEY, room_id TEXT, sender TEXT, ts_ms INTEGER, body TEXT, msgtype TEXT, raw_json TEXT);
+		CREATE TABLE yap_history (room_id TEXT, day TEXT, sender TEXT, rank INTEGER, words INTEGER, PRIMARY KEY (room_id, day, sender))`); err != nil {
+		t.Fatalf("create tables: %v", err)
+	}
+	room := "!testroom:example.com"
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	yesterday := now.Add(-24 * time.Hour).UnixMilli()
+	for i, m := range []struct {
+		sender, body string
+		ts           int64
+	}{
+		{"@alice:example.com", "one", yesterday},
+		{"@bob:example.com", "one two three", yesterday},
+		{"@carol:example.com", "today only", now.UnixMilli()},
+	} {
+		if _, err := db.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, ?, ?, ?, ?, 'm.text')`, fmt.Sprint(i), room, m.sender, m.ts, m.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ranks, err := yesterdayRanks(context.Background(), db, room, "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranks) != 2 || ranks["@bob:example.com"] != 1 || ranks["@alice:example.com"] != 2 {
+		t.Errorf("ranks = %v", ranks)
+	}
+	// Later calls read the stored history rather than recounting.
+	if _, err := db.Exec(`DELETE FROM messages`); err != nil {
+		t.Fatal(err)
+	}
+	if ranks, _ = yesterdayRanks(context.Background(), db, room, "", now); len(ranks) != 2 {
+		t.Errorf("stored ranks = %v", ranks)
+	}
+
+	for _, tt := range []struct {
+		prev, rank int
+		want       string
+	}{
+		{0, 3, "new"},
+		{4, 2, "▲2"},
+		{1, 2, "▼1"},
+		{2, 2, ""},
+	} {
+		if got := yapMovement(tt.prev, tt.rank); got != tt.want {
+			t.Errorf("yapMovement(%d, %d) = %q, want %q", tt.prev, tt.rank, got, tt.want)
+		}
+	}
+}
+
+func TestYapGuessGame(t *testing.T) {
+	ctx := context.Background()
+	database, err := db.OpenMessages(ctx, t.TempDir()+"/messages.db")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	room := "!testroom:example.com"
+	now := time.Now().UnixMilli()
+	for i, m := range []struct{ sender, body string }{
+		{"@alice:example.com", "one two three four"},
+		{"@bob:example.com", "one two"},
+		{"@carol:example.com", "one"},
+	} {
+		if _, err := database.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, ?, ?, ?, ?, 'm.text')`, fmt.Sprint(i), room, m.sender, now, m.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := YapGuess
+	defer func() { YapGuess = old }()
+	YapGuess = NewYapGuessRules(&config.YapGuessConfig{ExactPoints: 5, MaxPerDay: 3})
+
+	ev := &event.Event{RoomID: id.RoomID(room), Sender: "@bob:example.com"}
+	guess := func(n string) string {
+		t.Helper()
+		out, err := QueryTopYappers(ctx, database, nil, ev, "guess "+n, "", false)
+		if err != nil {
+			t.Fatalf("guess %s: %v", n, err)
+		}
+		return out
+	}
+	if out := guess("3"); !strings.Contains(out, "+1 point(s), 1 this week") {
+		t.Errorf("close guess: %s", out)
+	}
+	if out := guess("2"); !strings.Contains(out, "already scored today") {
+		t.Errorf("second scoring guess: %s", out)
+	}
+	if out := guess("5"); strings.Contains(out, "point") {
+		t.Errorf("wrong guess should not score: %s", out)
+	}
+	if out := guess("2"); !strings.Contains(out, "all 3 of today's guesses") {
+		t.Errorf("fourth guess should hit the limit: %s", out)
+	}
+
+	ev.Sender = "@alice:example.com"
+	if out := guess("1"); !strings.Contains(out, "+5 point(s)") {
+		t.Errorf("exact guess: %s", out)
+	}
+	out := guess("scores")
+	if !strings.Contains(out, "1. alice — 5 point(s)") || !strings.Contains(out, "2. bob — 1 point(s)") {
+		t.Errorf("standings: %s", out)
+	}
+}
+
+func TestStartOfWeek(t *testing.T) {
+	// 2026-03-04 is a Wednesday.
+	got := StartOfWeek(time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC))
+	if want := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("StartOfWeek = %v, want %v", got, want)
+	}
+	sunday := StartOfWeek(time.Date(2026, 3, 8, 23, 0, 0, 0, time.UTC))
+	if !sunday.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("StartOfWeek(Sunday) = %v", sunday)
+	}
+}
+
+func TestQueryTopReacted(t *testing.T) {
+	ctx := context.Background()
+	database, err := db.OpenMessages(ctx, t.TempDir()+"/messages.db")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	room := "!testroom:example.com"
+	now := time.Now().UnixMilli()
+	for _, m := range []struct{ id, sender, body string }{
+		{"$a1", "@alice:example.com", "first"},
+		{"$a2", "@alice:example.com", "second"},
+		{"$b1", "@bob:example.com", "the good one"},
+	} {
+		if _, err := database.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, ?, ?, ?, ?, 'm.text')`, m.id, room, m.sender, now, m.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []struct {
+		msg, emoji, reactor string
+		age                 time.Duration
+	}{
+		{"$a1", "👍", "@bob:example.com", time.Hour},
+		{"$a2", "👍", "@carol:example.com", time.Hour},
+		{"$a2", "😂", "@alice:example.com", time.Hour}, // own message
+		{"$b1", "🔥", "@alice:example.com", time.Hour},
+		{"$b1", "🔥", "@carol:example.com", 2 * time.Hour},
+		{"$b1", "😂", "@dave:example.com", 10 * 24 * time.Hour}, // outside 1w
+	} {
+		if _, err := database.Exec(`INSERT INTO reactions(message_id, room_id, emoji, reactor, created_at_ms) VALUES (?, ?, ?, ?, ?)`,
+			r.msg, room, r.emoji, r.reactor, now-r.age.Milliseconds()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ev := &event.Event{RoomID: id.RoomID(room), Sender: "@carol:example.com"}
+	out, err := QueryTopReacted(ctx, database, nil, ev, "reacted", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"most appreciated (past 1w):",
+		"1. bob — 2 reaction(s) on 1 message(s)",
+		"2. alice — 2 reaction(s) on 2 message(s)",
+		`top message: 🔥 "the good one" — bob (2 reaction(s))`,
+		"https://matrix.to/#/!testroom:example.com/$b1",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+
+	out, _ = QueryTopReacted(ctx, database, nil, ev, "reacted 2w", "", false)
+	if !strings.Contains(out, "1. bob — 3 reaction(s)") {
+		t.Errorf("2w should include older reactions:\n%s", out)
+	}
+	if out, _ := QueryTopReacted(ctx, database, nil, ev, "reacted soon", "", false); !strings.Contains(out, "should look like") {
+		t.Errorf("bad period: %s", out)
+	}
+}
+
+func TestQueryYapHours(t *testing.T) {
+	ctx := context.Background()
+	database, err := db.OpenMessages(ctx, t.TempDir()+"/messages.db")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	room := "!testroom:example.com"
+	loc := time.FixedZone("UTC+5", 5*3600)
+	RoomTimezones[room] = loc
+	defer delete(RoomTimezones, room)
+
+	y := time.Now().In(loc).AddDate(0, 0, -1)
+	at := func(h int) int64 { return time.Date(y.Year(), y.Month(), y.Day(), h, 30, 0, 0, loc).UnixMilli() }
+	for i, m := range []struct {
+		sender string
+		hour   int
+	}{
+		{"@owl:example.com", 2}, {"@owl:example.com", 2}, {"@owl:example.com", 3}, {"@owl:example.com", 21},
+		{"@lark:example.com", 6}, {"@lark:example.com", 6},
+		{"@owl:example.com", 2},
+	} {
+		if _, err := database.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, ?, ?, ?, 'hi', 'm.text')`, fmt.Sprint(i), room, m.sender, at(m.hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ev := &event.Event{RoomID: id.RoomID(room), Sender: "@owl:example.com"}
+	out, err := QueryTopYappers(ctx, database, nil, ev, "hours", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"yap hours (past 30 days, UTC+5):",
+		"busiest hour: 02:00–03:00 (42% of messages)",
+		"1. owl — mostly 02:00 🦉 (5 messages)",
+		"2. lark — mostly 06:00 🐦 (2 messages)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if out, _ := QueryTopYappers(ctx, database, nil, ev, "hours soon", "", false); !strings.HasPrefix(out, "usage:") {
+		t.Errorf("bad days: %s", out)
+	}
+}
+
+func TestHourSparkline(t *testing.T) {
+	var counts [24]int
+	counts[0], counts[12] = 8, 4
+	got := []rune(hourSparkline(counts))
+	if len(got) != 24 || got[0] != '█' || got[12] != '▄' || got[1] != '▁' {
+		t.Errorf("hourSparkline() = %q", string(got))
+	}
+}
+
+func TestThreadTranscript(t *testing.T) {
+	ctx := context.Background()
+	database, err := db.OpenMessages(ctx, t.TempDir()+"/messages.db")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	room := "!testroom:example.com"
+	thread := func(body string) string {
+		return `{"msgtype":"m.text","body":"` + body + `","m.relates_to":{"rel_type":"m.thread","event_id":"$root"}}`
+	}
+	for _, m := range []struct{ id, sender, body, raw string }{
+		{"$root", "@alice:example.com", "should we move the meetup?", `{"msgtype":"m.text","body":"should we move the meetup?"}`},
+		{"$r2", "@carol:example.com", "saturday works", thread("saturday works")},
+		{"$r1", "@bob:example.com", "yes, friday is bad", thread("yes, friday is bad")},
+		{"$cmd", "@bob:example.com", "/bot tldr thread", thread("/bot tldr thread")},
+		{"$other", "@dave:example.com", "unrelated", `{"msgtype":"m.text","body":"unrelated"}`},
+	} {
+		ts := map[string]int64{"$root": 1, "$r1": 2, "$r2": 3, "$cmd": 4, "$other": 5}[m.id]
+		if _, err := database.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype, raw_json) VALUES (?, ?, ?, ?, ?, 'm.text', ?)`, m.id, room, m.sender, ts, m.body, m.raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, n, err := threadTranscript(ctx, nil, database, id.RoomID(room), "$root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "alice: should we move the meetup?\nbob: yes, friday is bad\ncarol: saturday works\n"
+	if got != want || n != 3 {
+		t.Errorf("threadTranscript() = %q (%d), want %q", got, n, want)
+	}
+}
+
+func TestExecContext(t *testing.T) {
+	ev := &event.Event{
+		Sender: "@alice:example.com",
+		RoomID: "!room:example.com",
+		ID:     "$cmd",
+		Type:   event.EventMessage,
+		Content: event.Content{Parsed: &event.MessageEventContent{
+			MsgType: event.MsgText,
+			Body:    "/bot greet  to   everyone",
+		}},
+	}
+	ec := newExecContext(context.Background(), nil, ev, config.RoomIDEntry{Comment: "lounge"})
+	if got := ec.expand("--user={sender}@{room} {args} {event_id} {input} {nope}"); got != "--user=@alice:example.com@lounge to everyone $cmd {input} {nope}" {
+		t.Errorf("expand = %q", got)
+	}
+	c := &BotCommand{Env: map[string]string{"WHO": "{sender}", "WHERE": "{room_id}"}}
+	if got := ec.environ(c); !slices.Equal(got, []string{"WHERE=!room:example.com", "WHO=@alice:example.com"}) {
+		t.Errorf("environ = %q", got)
+	}
+	lint := `{"commands":{"x":{"type":"exec","command":"c","args":["--from={sender}","{args}"],"env":{"WHO":"{display_name}"}}}}`
+	if errs := LintBotConfig([]byte(lint)); len(errs) > 0 {
+		t.Errorf("context placeholders should lint clean, got %v", errs)
+	}
+}
+
+func TestRunExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a Unix shell")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	sh := func(script string, sandbox *ExecSandbox) ([]byte, error) {
+		return runExec(ctx, &BotCommand{Command: "/bin/sh", Sandbox: sandbox}, execRun{args: []string{"-c", script}, env: []string{"ASH_TEST=1"}, dir: dir})
+	}
+
+	t.Setenv("MATRIX_PASSWORD", "hunter2")
+	t.Setenv("TZ", "UTC")
+	out, err := sh(`echo "$HOME $TMPDIR $PWD $ASH_TEST" && echo ${MATRIX_PASSWORD:-unset} ${TZ:-unset}`, nil)
+	if err != nil || string(out) != dir+" "+dir+" "+dir+" 1\nunset unset\n" {
+		t.Errorf("environment = %q, %v", out, err)
+	}
+	workdir := t.TempDir()
+	c := &BotCommand{Command: "/bin/sh", InheritEnv: []string{"TZ"}, Workdir: workdir}
+	if out, err := runExec(ctx, c, execRun{args: []string{"-c", `echo "$PWD $TZ"`}, dir: dir}); err != nil || string(out) != workdir+" UTC\n" {
+		t.Errorf("workdir and inherit_env = %q, %v", out, err)
+	}
+
+	start := time.Now()
+	if _, err := sh("sleep 10 & sleep 10", &ExecSandbox{TimeoutSeconds: 1}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("slow command: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("timeout took %s", d)
+	}
+
+	if _, err := sh("yes", &ExecSandbox{MaxOutputBytes: 1000}); err == nil || !strings.Contains(err.Error(), "exceeded 1000 bytes") {
+		t.Errorf("noisy command: %v", err)
+	}
+
+	if out, err := sh("ulimit -t", &ExecSandbox{CPUSeconds: 7}); err != nil || strings.TrimSpace(string(out)) != "7" {
+		t.Errorf("cpu limit = %q, %v", out, err)
+	}
+
+	if out, err := runExec(ctx, &BotCommand{Command: "tr"}, execRun{args: []string{"a-z", "A-Z"}, dir: dir, stdin: "hello"}); err != nil || string(out) != "HELLO" {
+		t.Errorf("stdin filter = %q, %v", out, err)
+	}
+
+	wrapped := &ExecSandbox{Wrapper: []string{"/usr/bin/env", "WRAPPED={tmpdir}"}}
+	if out, err := sh(`echo "$WRAPPED"`, wrapped); err != nil || strings.TrimSpace(string(out)) != dir {
+		t.Errorf("wrapper output = %q, %v", out, err)
+	}
+}
+
+func TestExecMaxConcurrent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a Unix shell")
+	}
+	// Each run holds a lock directory while it sleeps; an overlapping run
+	// fails to create it.
+	lock := filepath.Join(t.TempDir(), "lock")
+	c := &BotCommand{Command: "/bin/sh", Args: []string{"-c", `mkdir "$LOCK" && sleep 0.1 && rmdir "$LOCK"`}, MaxConcurrent: 1}
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := runExec(context.Background(), c, execRun{args: c.Args, env: []string{"LOCK=" + lock}, dir: t.TempDir()})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("run overlapped another: %v", err)
+		}
+	}
+}
+
+func TestExecAllowlist(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a Unix shell")
+	}
+	defer func(old []string) { ExecAllowlist = old }(ExecAllowlist)
+	ExecAllowlist = []string{"/bin/sh"}
+	bc := &BotConfig{Commands: map[string]BotCommand{
+		"ok":       {Type: "exec", Command: "/bin/sh"},
+		"relative": {Type: "exec", Command: "sh"},
+		"listed":   {Type: "exec", Command: "/bin/sh", Sandbox: &ExecSandbox{Wrapper: []string{"/usr/bin/env"}}},
+		"missing":  {Type: "exec", Command: "/bin/sh-missing"},
+	}}
+	var got []string
+	for _, err := range bc.CheckExecBinaries() {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		"command listed: /usr/bin/env is not in EXEC_ALLOWLIST",
+		"command missing: /bin/sh-missing is not in EXEC_ALLOWLIST",
+		"command relative: sh is not in EXEC_ALLOWLIST",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("CheckExecBinaries = %q, want %q", got, want)
+	}
+	c := bc.Commands["relative"]
+	if _, err := runExec(context.Background(), &c, execRun{dir: t.TempDir()}); err == nil || !strings.Contains(err.Error(), "EXEC_ALLOWLIST") {
+		t.Errorf("runExec of a command not in the allowlist: %v", err)
+	}
+
+	ExecAllowlist = nil
+	bc.Commands = map[string]BotCommand{"missing": {Type: "exec", Command: "/bin/sh-missing"}}
+	if errs := bc.CheckExecBinaries(); len(errs) != 1 {
+		t.Errorf("expected the missing binary to be reported, got %v", errs)
+	}
+}
+
+func TestFormatOutput(t *testing.T) {
+	c := &BotCommand{Format: "code", Language: "json"}
+	plain, formatted := c.FormatOutput("> ", "{\"a\": \"<b>\"}")
+	if plain != "> \n```json\n{\"a\": \"<b>\"}\n```" {
+		t.Errorf("plain = %q", plain)
+	}
+	if formatted != `&gt; <pre><code class="language-json">{&#34;a&#34;: &#34;&lt;b&gt;&#34;}</code></pre>` {
+		t.Errorf("formatted = %q", formatted)
+	}
+	if plain, formatted := (&BotCommand{}).FormatOutput("> ", "hi"); plain != "> hi" || formatted != "" {
+		t.Errorf("FormatOutput without format = (%q, %q)", plain, formatted)
+	}
+
+	c = &BotCommand{Response: "it was <b>", Spoiler: true}
+	plain, formatted = c.FormatOutput("> ", c.Response)
+	if plain != "> ||it was <b>||" || formatted != "&gt; <span data-mx-spoiler>it was &lt;b&gt;</span>" {
+		t.Errorf("FormatOutput with spoiler = (%q, %q)", plain, formatted)
+	}
+	c = &BotCommand{Type: "ai"}
+	plain, formatted = c.FormatOutput("> ", "the butler ||did it||")
+	if plain != "> the butler ||did it||" || formatted != "&gt; the butler <span data-mx-spoiler>did it</span>" {
+		t.Errorf("FormatOutput with ||spoiler|| = (%q, %q)", plain, formatted)
+	}
+	// Shell output like "a || b || c" isn't a spoiler.
+	if _, formatted := (&BotCommand{Type: "exec"}).FormatOutput("> ", "a || b || c"); formatted != "" {
+		t.Errorf("exec output formatted as %q", formatted)
+	}
+}
+
+func TestProgressReply(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		sent = append(sent, string(body))
+		n := len(sent)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"event_id":"$sent%d"}`, n)
+	}))
+	defer srv.Close()
+	client, err := mautrix.NewClient(srv.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ev := &event.Event{ID: "$cmd", RoomID: "!room:example.com"}
+	p, err := startProgressReply(ctx, client, ev, "bot: ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Write([]byte(strings.Repeat("x", progressMaxBytes)))
+	p.Write([]byte("tail"))
+	if len(p.out) != progressMaxBytes || !strings.HasSuffix(string(p.out), "tail") {
+		t.Errorf("kept %d bytes of output", len(p.out))
+	}
+	p.finish(ctx, "bot: all done", "")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 2 || !strings.Contains(sent[0], `"bot: working..."`) || !strings.Contains(sent[0], `"$cmd"`) {
+		t.Fatalf("sent = %q", sent)
+	}
+	if !strings.Contains(sent[1], `"m.new_content":{`) || !strings.Contains(sent[1], `"body":"bot: all done"`) || !strings.Contains(sent[1], `"event_id":"$sent1"`) {
+		t.Errorf("edit = %s", sent[1])
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	if got := Paginate("short reply", 0); !slices.Equal(got, []string{"short reply"}) {
+		t.Errorf("Paginate(short) = %q", got)
+	}
+	got := Paginate("one\ntwo\nthree\nfour", 10)
+	if !slices.Equal(got, []string{"one\ntwo", "three\nfour"}) {
+		t.Errorf("Paginate(lines) = %q", got)
+	}
+	// A line longer than a page is cut on a character boundary.
+	got = Paginate(strings.Repeat("é", 5), 2)
+	if !slices.Equal(got, []string{"éé", "éé", "é"}) {
+		t.Errorf("Paginate(runes) = %q", got)
+	}
+	for _, page := range Paginate(strings.Repeat("a line of output\n", 2000), 0) {
+		if len(page) > maxPageBytes {
+			t.Errorf("page of %d bytes is over the limit", len(page))
+		}
+	}
+}
+
+func TestPagedReplies(t *testing.T) {
+	r := NewPagedReplies()
+	p := &ReplyPages{Cmd: &BotCommand{}, Label: "> ", Pages: []string{"a", "b", "c"}}
+	if plain, _ := p.Render(); plain != "> a\n(page 1/3, react ➡️ or send /bot more for the next)" {
+		t.Errorf("Render = %q", plain)
+	}
+	p.Next = 2
+	if plain, _ := p.Render(); plain != "> c\n(page 3/3)" {
+		t.Errorf("Render last = %q", plain)
+	}
+
+	r.Add("!room:example.com", "$page1", p)
+	if _, ok := r.Take("!other:example.com", ""); ok {
+		t.Error("took a paged reply from another room")
+	}
+	if got, ok := r.Take("!room:example.com", ""); !ok || got != p {
+		t.Errorf("Take latest = %v, %v", got, ok)
+	}
+	if _, ok := r.Take("!room:example.com", "$page1"); ok {
+		t.Error("took the same page twice")
+	}
+}
+
+func TestConversationStateExpiry(t *testing.T) {
+	s := NewConversationState[id.EventID, string](time.Millisecond)
+	s.Set("$a", "x")
+	if v, ok := s.Get("$a"); !ok || v != "x" {
+		t.Fatalf("Get = %q, %v", v, ok)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := s.Get("$a"); ok {
+		t.Error("expired entry still set")
+	}
+}
+
+func TestCleanTeX(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{`$$\frac{a}{b}$$`, `\frac{a}{b}`, true},
+		{`\[ x^2 \]`, `x^2`, true},
+		{`\begin{pmatrix} 1 & 0 \end{pmatrix}`, `\begin{pmatrix} 1 & 0 \end{pmatrix}`, true},
+		{`\inputenc`, `\inputenc`, true},
+		{"", "", false},
+		{`\input{/etc/passwd}`, "", false},
+		{`\input2`, "", false},
+		{`\immediate\write18{id}`, "", false},
+		{`^^5cinput{x}`, "", false},
+		{strings.Repeat("x", texMaxChars+1), "", false},
+	}
+	for _, tt := range tests {
+		got, err := cleanTeX(tt.in)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("cleanTeX(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestDiagramSource(t *testing.T) {
+	tests := []struct {
+		in, src, lang string
+	}{
+		{"digraph { a -> b }", "digraph { a -> b }", "dot"},
+		{"strict graph { a -- b }", "strict graph { a -- b }", "dot"},
+		{"digraph G {\n a -> b\n}", "digraph G {\n a -> b\n}", "dot"},
+		{"graph TD\n  A --> B", "graph TD\n  A --> B", "mermaid"},
+		{"sequenceDiagram\n  A->>B: hi", "sequenceDiagram\n  A->>B: hi", "mermaid"},
+		{"look:\n```dot\nx -> y\n```\nnice", "x -> y", "dot"},
+		{"```mermaid\npie\n  \"a\": 1\n```", "pie\n  \"a\": 1", "mermaid"},
+		{"```\ndigraph { a }\n```", "digraph { a }", "dot"},
+	}
+	for _, tt := range tests {
+		if src, lang := diagramSource(tt.in); src != tt.src || lang != tt.lang {
+			t.Errorf("diagramSource(%q) = %q, %q", tt.in, src, lang)
+		}
+	}
+}
+
+func TestCarbonSource(t *testing.T) {
+	code, lang := carbonSource("```Go\nfunc main() {\n}\n```")
+	if code != "func main() {\n}" || lang != "go" {
+		t.Errorf("carbonSource(block) = %q, %q", code, lang)
+	}
+	code, lang = carbonSource("```c++\nint x;\n```")
+	if code != "int x;" || lang != "c++" {
+		t.Errorf("carbonSource(c++) = %q, %q", code, lang)
+	}
+	code, lang = carbonSource("\n  indented\n")
+	if code != "  indented" || lang != "" {
+		t.Errorf("carbonSource(plain) = %q, %q", code, lang)
+	}
+}
+
+func TestDownloadRequest(t *testing.T) {
+	tests := []struct {
+		args, replied, link, clip string
+	}{
+		{"https://example.com/v/1", "", "https://example.com/v/1", ""},
+		{"https://example.com/v/1 1:30-2:00", "", "https://example.com/v/1", "1:30-2:00"},
+		{"90-120", "look at this https://example.com/a-b", "https://example.com/a-b", "90-120"},
+		{"", "no links here", "", ""},
+	}
+	for _, tt := range tests {
+		link, clip := downloadRequest(tt.args, tt.replied)
+		if link != tt.link || clip != tt.clip {
+			t.Errorf("downloadRequest(%q, %q) = %q, %q, want %q, %q", tt.args, tt.replied, link, clip, tt.link, tt.clip)
+		}
+	}
+}
+
+func TestParseClip(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end float64
+		ok         bool
+	}{
+		{"90-120", 90, 120, true},
+		{"1:30-2:00", 90, 120, true},
+		{"1:00:00-1:00:10.5", 3600, 3610.5, true},
+		{"0:30-0:10", 0, 0, false},
+		{"1:75-2:00", 0, 0, false},
+		{"1.5:00-2:00", 0, 0, false},
+		{"90", 0, 0, false},
+		{"a-b", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, ok := parseClip(tt.in)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("parseClip(%q) = %v, %v, %v, want %v, %v, %v", tt.in, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	if d, title := parseProbe([]byte("213.5\nA video\n")); d != 213.5 || title != "A video" {
+		t.Errorf("parseProbe = %v, %q", d, title)
+	}
+	if d, title := parseProbe([]byte("NA\nNA\n")); d != 0 || title != "" {
+		t.Errorf("parseProbe(NA) = %v, %q", d, title)
+	}
+}
+
+func TestGIFSearchURL(t *testing.T) {
+	u := gifSearchURL(&config.GIFSearchConfig{Provider: "tenor", APIKey: "k"}, "happy cat")
+	if !strings.HasPrefix(u, tenorSearchURL+"?") || !strings.Contains(u, "q=happy+cat") || !strings.Contains(u, "contentfilter=low") || !strings.Contains(u, "key=k") {
+		t.Errorf("tenor URL = %s", u)
+	}
+	u = gifSearchURL(&config.GIFSearchConfig{Provider: "giphy", APIKey: "k", Rating: "g"}, "cat")
+	if !strings.HasPrefix(u, giphySearchURL+"?") || !strings.Contains(u, "rating=g") || !strings.Contains(u, "api_key=k") {
+		t.Errorf("giphy URL = %s", u)
+	}
+}
+
+func TestParseGIFResults(t *testing.T) {
+	tenor := `{"results":[{"title":"","content_description":"Cat GIF","media_formats":{
+		"gif":{"url":"https://media.tenor.com/a.gif","dims":[498,280],"size":1234},
+		"mp4":{"url":"https://media.tenor.com/a.mp4","dims":[498,280],"size":99}}},
+		{"media_formats":{"mp4":{"url":"https://media.tenor.com/b.mp4"}}}]}`
+	results, err := parseTenor([]byte(tenor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := gifResult{Title: "Cat GIF", GIF: "https://media.tenor.com/a.gif", MP4: "https://media.tenor.com/a.mp4", Width: 498, Height: 280, GIFSize: 1234}
+	if len(results) != 1 || results[0] != want {
+		t.Errorf("parseTenor = %+v", results)
+	}
+
+	giphy := `{"data":[{"title":"Dog GIF","images":{"original":{"url":"https://media.giphy.com/a.gif",
+		"width":"480","height":"270","size":"5678","mp4":"https://media.giphy.com/a.mp4"}}}]}`
+	results, err = parseGiphy([]byte(giphy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = gifResult{Title: "Dog GIF", GIF: "https://media.giphy.com/a.gif", MP4: "https://media.giphy.com/a.mp4", Width: 480, Height: 270, GIFSize: 5678}
+	if len(results) != 1 || results[0] != want {
+		t.Errorf("parseGiphy = %+v", results)
+	}
+}
+
+func TestTranscribeAPI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/transcriptions" || r.Header.Get("Authorization") != "Bearer k" {
+			t.Errorf("request %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		if r.FormValue("model") != "whisper-large-v3-turbo" || r.FormValue("language") != "de" {
+			t.Errorf("form = %v", r.MultipartForm.Value)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text":" Hallo  zusammen "}`))
+	}))
+	defer srv.Close()
+	orig := Transcription
+	defer func() { Transcription = orig }()
+	Transcription = &config.TranscriptionConfig{Backend: "api", URL: srv.URL + "/v1/", APIKey: "k", Language: "de"}
+	text, err := transcribeAPI(context.Background(), []byte("OggS fake audio"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != " Hallo  zusammen " {
+		t.Errorf("transcribeAPI = %q", text)
+	}
+}
+
+func TestToolPrograms(t *testing.T) {
+	orig := Transcription
+	defer func() { Transcription = orig }()
+	Transcription = &config.TranscriptionConfig{Backend: "whisper.cpp", Model: "/models/ggml-base.bin"}
+	if p, ok := toolPrograms(&BotCommand{Type: "builtin", Command: "transcribe"}); !ok || !slices.Equal(p, []string{"ffmpeg", "whisper-cli"}) {
+		t.Errorf("whisper.cpp programs = %v, %v", p, ok)
+	}
+	Transcription.Backend = "api"
+	if p, ok := toolPrograms(&BotCommand{Type: "builtin", Command: "transcribe"}); !ok || p != nil {
+		t.Errorf("api programs = %v, %v", p, ok)
+	}
+	if _, ok := toolPrograms(&BotCommand{Type: "builtin", Command: "uwuify"}); ok {
+		t.Error("uwuify runs no programs")
+	}
+	if p, _ := toolPrograms(&BotCommand{Type: "download"}); !slices.Equal(p, downloadPrograms) {
+		t.Errorf("download programs = %v", p)
+	}
+	if p, ok := toolPrograms(&BotCommand{Type: "builtin", Command: "ocr"}); !ok || !slices.Equal(p, []string{"tesseract"}) {
+		t.Errorf("ocr programs = %v, %v", p, ok)
+	}
+	voice := &event.MessageEventContent{MsgType: event.MsgAudio, MSC3245Voice: &event.MSC3245Voice{}}
+	if !IsVoiceMessage(voice) || IsVoiceMessage(&event.MessageEventContent{MsgType: event.MsgAudio}) {
+		t.Error("IsVoiceMessage")
+	}
+}
+
+func TestOCRSpace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		if r.FormValue("apikey") != "k" || r.FormValue("language") != "ger" {
+			t.Errorf("form = %v", r.MultipartForm.Value)
+		}
+		if f := r.MultipartForm.File["file"]; len(f) != 1 || f[0].Filename != "image.png" {
+			t.Errorf("file = %v", f)
+		}
+		w.Write([]byte(`{"ParsedResults":[{"ParsedText":"Hallo\r\nWelt"}],"IsErroredOnProcessing":false}`))
+	}))
+	defer srv.Close()
+	origURL, orig := ocrSpaceURL, OCR
+	defer func() { ocrSpaceURL, OCR = origURL, orig }()
+	ocrSpaceURL = srv.URL
+	OCR = &config.OCRConfig{Backend: "ocr.space", APIKey: "k", Languages: "ger"}
+	png := []byte("\x89PNG\r\n\x1a\n fake image")
+	text, err := ocrSpace(context.Background(), png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Hallo\r\nWelt" {
+		t.Errorf("ocrSpace = %q", text)
+	}
+	if p := ocrPrograms(); p != nil {
+		t.Errorf("ocr.space programs = %v", p)
+	}
+
+	for _, raw := range []string{
+		`{"IsErroredOnProcessing":true,"ErrorMessage":["E301: bad image"]}`,
+		`{"IsErroredOnProcessing":true,"ErrorMessage":"E301: bad image"}`,
+	} {
+		if _, err := parseOCRSpace([]byte(raw)); err == nil || err.Error() != "ocr.space: E301: bad image" {
+			t.Errorf("parseOCRSpace(%s) = %v", raw, err)
+		}
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		keep, roomStrip, want bool
+	}{
+		{false, false, true},
+		{true, false, false},
+		{true, true, true},
+	} {
+		if got := stripMetadata(&BotCommand{KeepMetadata: tc.keep}, config.RoomIDEntry{StripEXIF: tc.roomStrip}); got != tc.want {
+			t.Errorf("stripMetadata(keep %v, room stripExif %v) = %v", tc.keep, tc.roomStrip, got)
+		}
+	}
+}
+
+func TestCaptionText(t *testing.T) {
+	top, bottom := captionText("one does not  simply | walk into ’mordor’")
+	if top != "ONE DOES NOT SIMPLY" || bottom != "WALK INTO 'MORDOR'" {
+		t.Errorf("captionText = %q, %q", top, bottom)
+	}
+	if top, bottom := captionText("just top"); top != "JUST TOP" || bottom != "" {
+		t.Errorf("captionText without | = %q, %q", top, bottom)
+	}
+	got := wrapCaption("ONE DOES NOT SIMPLY WALK INTO MORDORRR", 8)
+	want := []string{"ONE DOES", "NOT", "SIMPLY", "WALK", "INTO", "MORDORRR"}
+	if !slices.Equal(got, want) {
+		t.Errorf("wrapCaption = %q", got)
+	}
+	if got := wrapCaption("ABCDEFGHIJ", 4); !slices.Equal(got, []string{"ABCD", "EFGH", "IJ"}) {
+		t.Errorf("wrapCaption long word = %q", got)
+	}
+	scale, lines := captionLayout("SHORT", 720, 720)
+	if scale != 10 || len(lines) != 1 {
+		t.Errorf("captionLayout short = %d, %q", scale, lines)
+	}
+	scale, lines = captionLayout(strings.Repeat("LONG WORDS ", 20), 720, 720)
+	if scale >= 10 || len(lines) > captionMaxLines {
+		t.Errorf("captionLayout long = %d, %d lines", scale, len(lines))
+	}
+}
+
+func TestCaptionImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	out, ext, err := captionImage(buf.Bytes(), "TOP", "BOTTOM")
+	if err != nil || ext != ".png" {
+		t.Fatalf("captionImage = %q, %v", ext, err)
+	}
+	got, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Bounds() != img.Bounds() {
+		t.Errorf("bounds = %v", got.Bounds())
+	}
+	count := func(img image.Image, y0, y1 int, want color.Color) int {
+		n := 0
+		for y := y0; y < y1; y++ {
+			for x := 0; x < img.Bounds().Dx(); x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				wr, wg, wb, _ := want.RGBA()
+				if r == wr && g == wg && b == wb {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	if count(got, 0, 40, color.White) == 0 || count(got, 0, 40, color.Black) == 0 {
+		t.Error("no top text")
+	}
+	if count(got, 160, 200, color.White) == 0 {
+		t.Error("no bottom text")
+	}
+	if count(got, 80, 120, color.White) != 0 {
+		t.Error("text in the middle")
+	}
+
+	pal := color.Palette{color.Gray{0x80}, color.Black, color.White}
+	g := &gif.GIF{Delay: []int{10, 10}}
+	for range 2 {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 100, 100), pal))
+	}
+	buf.Reset()
+	gif.EncodeAll(&buf, g)
+	out, ext, err = captionImage(buf.Bytes(), "HI", "")
+	if err != nil || ext != ".gif" {
+		t.Fatalf("captionImage gif = %q, %v", ext, err)
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(out))
+	if err != nil || len(anim.Image) != 2 {
+		t.Fatalf("captioned gif: %v", err)
+	}
+	for i, frame := range anim.Image {
+		if count(frame, 0, 30, color.White) == 0 {
+			t.Errorf("frame %d has no text", i)
+		}
+	}
+
+	if _, _, err := captionImage([]byte("RIFF0000WEBPVP8 "), "X", ""); err != errCaptionFormat {
+		t.Errorf("webp err = %v", err)
+	}
+}
+
+func TestUploadHelpers(t *testing.T) {
+	u, _ := url.Parse("https://example.com/files/Q3%20report.pdf?dl=1")
+	for _, tc := range []struct{ disposition, want string }{
+		{"", "Q3 report.pdf"},
+		{`attachment; filename="final.pdf"`, "final.pdf"},
+		{`attachment; filename="../../etc/passwd"`, "....etcpasswd"},
+	} {
+		if got := uploadFileName(tc.disposition, u); got != tc.want {
+			t.Errorf("uploadFileName(%q) = %q, want %q", tc.disposition, got, tc.want)
+		}
+	}
+	root, _ := url.Parse("https://example.com/")
+	if got := uploadFileName("", root); got != "download" {
+		t.Errorf("uploadFileName of / = %q", got)
+	}
+
+	if got := uploadContentType("application/pdf; qs=1", nil); got != "application/pdf" {
+		t.Errorf("declared type = %q", got)
+	}
+	if got := uploadContentType("application/octet-stream", []byte("\x89PNG\r\n\x1a\n")); got != "image/png" {
+		t.Errorf("sniffed type = %q", got)
+	}
+
+	allowed := []string{"image/*", "application/pdf"}
+	for typ, want := range map[string]bool{"image/png": true, "application/pdf": true, "application/x-msdownload": false, "imagex/png": false} {
+		if got := typeAllowed(typ, allowed); got != want {
+			t.Errorf("typeAllowed(%q) = %v", typ, got)
+		}
+	}
+	if !typeAllowed("application/x-msdownload", nil) {
+		t.Error("no allowed_types should allow any type")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("private address fetched")
+	}))
+	defer srv.Close()
+	ev := &event.Event{RoomID: "!room:example.com", Type: event.EventMessage,
+		Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: "/bot upload " + srv.URL + "/x.pdf"}}}
+	if got, err := handleUploadCommand(context.Background(), nil, ev, &BotCommand{}); err != nil || got != "that link points to a private address" {
+		t.Errorf("private link = %q, %v", got, err)
+	}
+}
+
+func TestQuerySearch(t *testing.T) {
+	ctx := context.Background()
+	database, err := db.OpenMessages(ctx, t.TempDir()+"/messages.db")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	room := "!testroom:example.com"
+	day := func(d string) int64 {
+		ts, _ := time.Parse("2006-01-02 15:04", d)
+		return ts.UnixMilli()
+	}
+	for _, m := range []struct {
+		id, sender, body, ts string
+	}{
+		{"$a1", "@alice:example.com", "Deploy went fine", "2024-05-01 10:00"},
+		{"$a2", "@alice:example.com", "the deploy broke, see https://status.example.com", "2024-05-03 10:00"},
+		{"$b1", "@bob:example.com", "who broke the <deploy>?", "2024-05-05 10:00"},
+		{"$b2", "@bob:other.org", "deploy 100% done", "2024-05-06 10:00"},
+		{"$c1", "@carol:example.com", "/bot search deploy", "2024-05-07 10:00"},
+		{"$x1", "@ash:example.com", "deploy", "2024-05-07 11:00"},
+	} {
+		if _, err := database.Exec(`INSERT INTO messages(id, room_id, sender, ts_ms, body, msgtype) VALUES (?, ?, ?, ?, ?, 'm.text')`, m.id, room, m.sender, day(m.ts), m.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := database.Exec(`INSERT INTO links(message_id, url, idx, ts_ms) VALUES ('$a2', 'https://status.example.com', 0, ?)`, day("2024-05-03 10:00")); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(args string) string {
+		t.Helper()
+		q, err := parseSearchArgs(args, time.UTC)
+		if err != nil {
+			t.Fatalf("parseSearchArgs(%q): %v", args, err)
+		}
+		results, err := searchMessages(ctx, database, room, q, "@ash:example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, r := range results {
+			out = append(out, r.id)
+		}
+		return strings.Join(out, " ")
+	}
+	for _, tt := range []struct{ args, want string }{
+		{"deploy", "$b2 $b1 $a2 $a1"},
+		{"DEPLOY broke", "$b1 $a2"},
+		{"deploy limit:2", "$b2 $b1"},
+		{"deploy from:@alice:example.com", "$a2 $a1"},
+		{"deploy from:bob", "$b2 $b1"},
+		{"from:@bob:other.org", "$b2"},
+		{"deploy before:2024-05-05", "$a2 $a1"},
+		{"deploy after:2024-05-03", "$b2 $b1"},
+		{"deploy after:2024-05-01 before:2024-05-06", "$b1 $a2"},
+		{"has:link", "$a2"},
+		{"100%", "$b2"},
+		{"_", ""},
+	} {
+		if got := ids(tt.args); got != tt.want {
+			t.Errorf("search %q = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+	for _, args := range []string{"", "limit:3", "deploy before:yesterday", "deploy has:image", "deploy limit:50", "x after:2024-05-02 before:2024-05-03"} {
+		if _, err := parseSearchArgs(args, time.UTC); err == nil {
+			t.Errorf("parseSearchArgs(%q) should fail", args)
+		}
+	}
+
+	ev := &event.Event{RoomID: id.RoomID(room), Sender: "@carol:example.com"}
+	out, err := QuerySearch(ctx, database, nil, ev, "broke", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"2 result(s), newest first:",
+		"1. bob, 05 May 2024: who broke the <deploy>?\nhttps://matrix.to/#/!testroom:example.com/$b1",
+		"2. alice, 03 May 2024: the deploy broke",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if out, _ := QuerySearch(ctx, database, nil, ev, "nothing-like-this", "", false); out != "no messages found" {
+		t.Errorf("no results: %q", out)
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	plain, formatted := searchSnippet("who broke\nthe <Deploy>?", []string{"deploy", "BROKE"})
+	if plain != "who broke the <Deploy>?" || formatted != "who <b>broke</b> the &lt;<b>Deploy</b>&gt;?" {
+		t.Errorf("searchSnippet = %q, %q", plain, formatted)
+	}
+	long := strings.Repeat("a ", 100) + "needle" + strings.Repeat(" b", 100)
+	plain, formatted = searchSnippet(long, []string{"needle"})
+	if !strings.HasPrefix(plain, "…") || !strings.HasSuffix(plain, "…") || !strings.Contains(formatted, "<b>needle</b>") {
+		t.Errorf("long snippet = %q", plain)
+	}
+	if n := len([]rune(plain)); n != searchSnippetRunes+2 {
+		t.Errorf("long snippet has %d runes", n)
+	}
+	if plain, _ := searchSnippet("no terms at all", nil); plain != "no terms at all" {
+		t.Errorf("no terms = %q", plain)
+	}
+}
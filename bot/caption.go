@@ -0,0 +1,292 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"unicode/utf8"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/locale"
+	"github.com/polarhive/ash/matrix"
+)
+
+const (
+	// captionMaxChars bounds the text /bot caption draws.
+	captionMaxChars = 200
+	// captionMaxPixels bounds the images captioned, so a huge image can't
+	// exhaust memory.
+	captionMaxPixels = 25_000_000
+	// captionMaxLines is the most lines the top or bottom text wraps to
+	// before it's drawn smaller.
+	captionMaxLines = 3
+	// captionGlyphW and captionGlyphH are the size of captionFont's glyphs,
+	// which are drawn one blank pixel apart.
+	captionGlyphW, captionGlyphH = 5, 7
+)
+
+// errCaptionFormat is returned for images /bot caption can't decode.
+var errCaptionFormat = errors.New("unsupported image")
+
+// captionFont is a 5x7 bitmap font of upper-case ASCII, drawn scaled up
+// with an outline for the classic meme look without a font file.
+var captionFont = map[rune][captionGlyphH]string{
+	' ':  {".....", ".....", ".....", ".....", ".....", ".....", "....."},
+	'A':  {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'B':  {"####.", "#...#", "#...#", "####.", "#...#", "#...#", "####."},
+	'C':  {".###.", "#...#", "#....", "#....", "#....", "#...#", ".###."},
+	'D':  {"####.", "#...#", "#...#", "#...#", "#...#", "#...#", "####."},
+	'E':  {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+	'F':  {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+	'G':  {".###.", "#...#", "#....", "#.###", "#...#", "#...#", ".####"},
+	'H':  {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'I':  {".###.", "..#..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'J':  {"..###", "...#.", "...#.", "...#.", "...#.", "#..#.", ".##.."},
+	'K':  {"#...#", "#..#.", "#.#..", "##...", "#.#..", "#..#.", "#...#"},
+	'L':  {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+	'M':  {"#...#", "##.##", "#.#.#", "#.#.#", "#...#", "#...#", "#...#"},
+	'N':  {"#...#", "#...#", "##..#", "#.#.#", "#..##", "#...#", "#...#"},
+	'O':  {".###.", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'P':  {"####.", "#...#", "#...#", "####.", "#....", "#....", "#...."},
+	'Q':  {".###.", "#...#", "#...#", "#...#", "#.#.#", "#..#.", ".##.#"},
+	'R':  {"####.", "#...#", "#...#", "####.", "#.#..", "#..#.", "#...#"},
+	'S':  {".####", "#....", "#....", ".###.", "....#", "....#", "####."},
+	'T':  {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+	'U':  {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'V':  {"#...#", "#...#", "#...#", "#...#", "#...#", ".#.#.", "..#.."},
+	'W':  {"#...#", "#...#", "#...#", "#.#.#", "#.#.#", "#.#.#", ".#.#."},
+	'X':  {"#...#", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "#...#"},
+	'Y':  {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#..", "..#.."},
+	'Z':  {"#####", "....#", "...#.", "..#..", ".#...", "#....", "#####"},
+	'0':  {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1':  {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2':  {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3':  {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4':  {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5':  {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6':  {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7':  {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8':  {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9':  {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+	'!':  {"..#..", "..#..", "..#..", "..#..", "..#..", ".....", "..#.."},
+	'?':  {".###.", "#...#", "....#", "...#.", "..#..", ".....", "..#.."},
+	'.':  {".....", ".....", ".....", ".....", ".....", ".##..", ".##.."},
+	',':  {".....", ".....", ".....", ".....", ".##..", "..#..", ".#..."},
+	'\'': {"..#..", "..#..", ".#...", ".....", ".....", ".....", "....."},
+	'"':  {".#.#.", ".#.#.", ".....", ".....", ".....", ".....", "....."},
+	'-':  {".....", ".....", ".....", "#####", ".....", ".....", "....."},
+	':':  {".....", ".##..", ".##..", ".....", ".##..", ".##..", "....."},
+	';':  {".....", ".##..", ".##..", ".....", ".##..", "..#..", ".#..."},
+	'(':  {"...#.", "..#..", ".#...", ".#...", ".#...", "..#..", "...#."},
+	')':  {".#...", "..#..", "...#.", "...#.", "...#.", "..#..", ".#..."},
+	'&':  {".##..", "#..#.", "#.#..", ".#...", "#.#.#", "#..#.", ".##.#"},
+	'/':  {".....", "....#", "...#.", "..#..", ".#...", "#....", "....."},
+	'+':  {".....", "..#..", "..#..", "#####", "..#..", "..#..", "....."},
+	'=':  {".....", ".....", "#####", ".....", "#####", ".....", "....."},
+	'#':  {".#.#.", ".#.#.", "#####", ".#.#.", "#####", ".#.#.", ".#.#."},
+	'%':  {"##...", "##..#", "...#.", "..#..", ".#...", "#..##", "...##"},
+	'*':  {".....", "..#..", "#.#.#", ".###.", "#.#.#", "..#..", "....."},
+	'_':  {".....", ".....", ".....", ".....", ".....", ".....", "#####"},
+	'@':  {".###.", "#...#", "#.###", "#.#.#", "#.##.", "#....", ".###."},
+	'$':  {"..#..", ".####", "#.#..", ".###.", "..#.#", "####.", "..#.."},
+}
+
+// captionQuotes maps typographic quotes, which phones like to insert, to
+// the ones captionFont has.
+var captionQuotes = strings.NewReplacer("‘", "'", "’", "'", "“", `"`, "”", `"`)
+
+// captionText splits /bot caption's arguments into the top and bottom text
+// at the first "|", upper-cased as memes are. Without one, it's all top.
+func captionText(args string) (top, bottom string) {
+	args = strings.ToUpper(captionQuotes.Replace(args))
+	top, bottom, _ = strings.Cut(args, "|")
+	return strings.Join(strings.Fields(top), " "), strings.Join(strings.Fields(bottom), " ")
+}
+
+// wrapCaption wraps text into lines of at most cols characters, breaking
+// between words where it can.
+func wrapCaption(text string, cols int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for utf8.RuneCountInString(word) > cols {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			r := []rune(word)
+			lines = append(lines, string(r[:cols]))
+			word = string(r[cols:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= cols:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// captionLayout picks the scale text is drawn at on a width by height
+// image, and wraps it: lines about a ninth of the image's height, smaller
+// if the text would take more than captionMaxLines lines.
+func captionLayout(text string, width, height int) (int, []string) {
+	usable := width - 2*max(2, width/30)
+	scale := max(1, height/9/(captionGlyphH+1))
+	for {
+		cols := max(1, usable/((captionGlyphW+1)*scale))
+		lines := wrapCaption(text, cols)
+		if len(lines) <= captionMaxLines || scale == 1 {
+			return scale, lines
+		}
+		scale--
+	}
+}
+
+// captionRects returns the pixel blocks that draw top and bottom on an
+// image with bounds b, each scale pixels square, centred horizontally.
+func captionRects(b image.Rectangle, top, bottom string) (rects []image.Rectangle, outline int) {
+	margin := max(2, b.Dy()/30)
+	for i, text := range []string{top, bottom} {
+		if text == "" {
+			continue
+		}
+		scale, lines := captionLayout(text, b.Dx(), b.Dy())
+		outline = max(outline, max(1, scale/2))
+		lineH := (captionGlyphH + 1) * scale
+		y := b.Min.Y + margin
+		if i == 1 {
+			y = b.Max.Y - margin - len(lines)*lineH + scale
+		}
+		for _, line := range lines {
+			runes := []rune(line)
+			x := b.Min.X + (b.Dx()-(len(runes)*(captionGlyphW+1)-1)*scale)/2
+			for _, r := range runes {
+				glyph, ok := captionFont[r]
+				if !ok {
+					glyph = captionFont['?']
+				}
+				for gy, row := range glyph {
+					for gx, px := range row {
+						if px == '#' {
+							p := image.Pt(x+gx*scale, y+gy*scale)
+							rects = append(rects, image.Rectangle{Min: p, Max: p.Add(image.Pt(scale, scale))})
+						}
+					}
+				}
+				x += (captionGlyphW + 1) * scale
+			}
+			y += lineH
+		}
+	}
+	return rects, outline
+}
+
+// drawCaption draws rects in white with a black outline onto dst, clipped
+// to its bounds.
+func drawCaption(dst draw.Image, rects []image.Rectangle, outline int) {
+	black, white := image.NewUniform(color.Black), image.NewUniform(color.White)
+	for _, r := range rects {
+		draw.Draw(dst, r.Inset(-outline), black, image.Point{}, draw.Src)
+	}
+	for _, r := range rects {
+		draw.Draw(dst, r, white, image.Point{}, draw.Src)
+	}
+}
+
+// captionImage draws top and bottom onto the JPEG, PNG or GIF in data and
+// returns the result in the same format, with its extension. Every frame of
+// an animated GIF is captioned; APNGs become still images.
+func captionImage(data []byte, top, bottom string) ([]byte, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width*cfg.Height > captionMaxPixels {
+		return nil, "", errCaptionFormat
+	}
+	bounds := image.Rect(0, 0, cfg.Width, cfg.Height)
+	rects, outline := captionRects(bounds, top, bottom)
+	var buf bytes.Buffer
+	switch format {
+	case "gif":
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", errCaptionFormat
+		}
+		for _, frame := range g.Image {
+			drawCaption(frame, rects, outline)
+		}
+		err = gif.EncodeAll(&buf, g)
+		return buf.Bytes(), ".gif", err
+	case "jpeg", "png":
+		src, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", errCaptionFormat
+		}
+		img := image.NewRGBA(bounds)
+		draw.Draw(img, bounds, src, src.Bounds().Min, draw.Src)
+		drawCaption(img, rects, outline)
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+			return buf.Bytes(), ".jpg", err
+		}
+		err = png.Encode(&buf, img)
+		return buf.Bytes(), ".png", err
+	}
+	return nil, "", errCaptionFormat
+}
+
+// handleCaptionCommand draws the text after /bot caption onto the image it
+// replies to, or is sent with, meme style: "top text | bottom text".
+func handleCaptionCommand(ctx context.Context, matrixClient *mautrix.Client, ev *event.Event, c *BotCommand) (string, error) {
+	matrix.ParseEvent(ev)
+	msg := ev.Content.AsMessage()
+	if msg == nil {
+		return "", fmt.Errorf("not a message event")
+	}
+	top, bottom := captionText(commandArgs(msg.Body))
+	if top == "" && bottom == "" {
+		return "give the text, like /bot caption top text | bottom text", nil
+	}
+	if utf8.RuneCountInString(top)+utf8.RuneCountInString(bottom) > captionMaxChars {
+		return fmt.Sprintf("that caption is too long (max %d characters)", captionMaxChars), nil
+	}
+	imgMsg, err := matrix.DownloadImageFromMessage(ctx, matrixClient, ev)
+	if err != nil {
+		return locale.ForRoom(string(ev.RoomID), "need_image"), nil
+	}
+	mediaURL, encFile, err := matrix.MediaFromMessage(imgMsg)
+	if err != nil {
+		return "", err
+	}
+	data, err := matrix.DownloadImageBytes(ctx, matrixClient, mediaURL, encFile)
+	if errors.Is(err, matrix.ErrNotImage) {
+		return locale.ForRoom(string(ev.RoomID), "need_image"), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	out, ext, err := captionImage(data, top, bottom)
+	if errors.Is(err, errCaptionFormat) {
+		return "can't caption that image; JPEG, PNG and GIF work", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("encode captioned image: %w", err)
+	}
+	return "", matrix.SendImageToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, out, "caption"+ext, "")
+}
@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/matrix"
+)
+
+const (
+	// carbonMaxLines and carbonMaxChars bound the code /bot carbon renders.
+	carbonMaxLines = 200
+	carbonMaxChars = 20000
+	// carbonTheme is the chroma style snapshots are highlighted with.
+	carbonTheme = "dracula"
+)
+
+// carbonPrograms are the programs /bot carbon runs, looked up on PATH:
+// charmbracelet's freeze, which highlights code with chroma and renders it
+// as an image.
+var carbonPrograms = []string{"freeze"}
+
+// carbonSource returns the code in text, from its first code block if it
+// has one, and the block's language if it's set.
+func carbonSource(text string) (code, lang string) {
+	if m := codeFenceRe.FindStringSubmatch(text); m != nil {
+		return strings.Trim(m[2], "\n"), strings.ToLower(m[1])
+	}
+	return strings.Trim(text, "\n"), ""
+}
+
+// handleCarbonCommand renders the code in the replied-to message, or after
+// /bot carbon, as a syntax-highlighted PNG and replies with it. The code
+// block's language picks the highlighting; without one it's guessed from
+// the code. freeze runs like an exec command, in a fresh temp directory
+// under c's sandbox.
+func handleCarbonCommand(ctx context.Context, matrixClient *mautrix.Client, ev *event.Event, c *BotCommand) (string, error) {
+	text, err := commandTargetText(ctx, ev, matrixClient)
+	if err != nil {
+		return "", err
+	}
+	code, lang := carbonSource(text)
+	switch {
+	case strings.TrimSpace(code) == "":
+		return "reply to a code block to snapshot it", nil
+	case strings.Count(code, "\n")+1 > carbonMaxLines || len([]rune(code)) > carbonMaxChars:
+		return fmt.Sprintf("that's too much code for a snapshot (max %d lines)", carbonMaxLines), nil
+	}
+
+	dir, err := newExecDir()
+	if err != nil {
+		return "", fmt.Errorf("create exec dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "code.txt")
+	if err := os.WriteFile(input, []byte(code), 0644); err != nil {
+		return "", fmt.Errorf("write code: %w", err)
+	}
+	output := filepath.Join(dir, "code.png")
+	args := []string{"--theme=" + carbonTheme, "--window", "--output=" + output}
+	if lang != "" && languageRe.MatchString(lang) {
+		args = append(args, "--language="+lang)
+	}
+	args = append(args, input)
+
+	tool, err := builtinTool(c, carbonPrograms[0])
+	if err != nil {
+		return "", err
+	}
+	if _, err := runExec(ctx, tool, execRun{args: args, dir: dir}); err != nil {
+		var ee *execError
+		if errors.As(err, &ee) {
+			if msg, _, _ := strings.Cut(strings.TrimSpace(ee.stderr), "\n"); msg != "" {
+				return "couldn't snapshot that: " + msg, nil
+			}
+		}
+		return "", err
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		return "", fmt.Errorf("read snapshot: %w", err)
+	}
+	if err := matrix.CheckUploadSize(int64(len(data))); err != nil {
+		return err.Error(), nil
+	}
+	if err := matrix.CheckQuota(ctx, ev.RoomID, int64(len(data))); err != nil {
+		return err.Error(), nil
+	}
+	if err := matrix.SendImageToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, data, "code.png", ""); err != nil {
+		return "", err
+	}
+	return "", nil
+}
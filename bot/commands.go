@@ -1,15 +1,18 @@
 package bot
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,26 +22,35 @@ import (
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/locale"
 	"github.com/polarhive/ash/matrix"
 	"github.com/polarhive/ash/util"
 )
 
-const defaultContentType = "image/jpeg"
+// ExecTmpDir is where exec commands get their per-run temp directories. Set
+// from config EXEC_TMP_DIR.
+var ExecTmpDir = filepath.Join(os.TempDir(), "ash")
 
 // FetchBotCommand executes the configured command and returns a string to post.
-func FetchBotCommand(ctx context.Context, c *BotCommand, linkstashURL string, ev *event.Event, matrixClient *mautrix.Client, groqAPIKey string, replyLabel string, messagesDB *sql.DB) (string, error) {
+func FetchBotCommand(ctx context.Context, c *BotCommand, linkstashURL string, ev *event.Event, matrixClient *mautrix.Client, groqAPIKey string, replyLabel string, messagesDB *sql.DB, room config.RoomIDEntry) (string, error) {
 	if c.Response != "" {
 		return c.Response, nil
 	}
 	switch c.Type {
 	case "http":
-		return handleHttpCommand(ctx, c, linkstashURL, ev, matrixClient)
+		return handleHttpCommand(ctx, c, linkstashURL, ev, matrixClient, messagesDB, room)
 	case "exec":
-		return handleExecCommand(ctx, ev, matrixClient, c)
+		return handleExecCommand(ctx, ev, matrixClient, c, room, replyLabel)
 	case "ai":
+		if c.InputType == "thread" {
+			return handleThreadSummary(ctx, ev, matrixClient, c, groqAPIKey, replyLabel, messagesDB)
+		}
 		return handleAiCommand(ctx, ev, matrixClient, c, groqAPIKey, replyLabel)
 	case "builtin":
-		return handleBuiltinCommand(ctx, ev, matrixClient, c, messagesDB, replyLabel)
+		return handleBuiltinCommand(ctx, ev, matrixClient, c, groqAPIKey, messagesDB, replyLabel)
+	case "download":
+		return handleDownloadCommand(ctx, ev, matrixClient, c)
 	default:
 		return "", fmt.Errorf("unknown command type: %s", c.Type)
 	}
@@ -48,32 +60,20 @@ func FetchBotCommand(ctx context.Context, c *BotCommand, linkstashURL string, ev
 // Command handlers
 // ---------------------------------------------------------------------------
 
-func handleHttpCommand(ctx context.Context, c *BotCommand, linkstashURL string, ev *event.Event, matrixClient *mautrix.Client) (string, error) {
-	method := c.Method
-	if method == "" {
-		method = "GET"
-	}
-	req, err := http.NewRequestWithContext(ctx, method, c.URL, nil)
-	if err != nil {
-		return "", err
-	}
-	for k, v := range c.Headers {
-		req.Header.Set(k, v)
-	}
-	resp, err := (&http.Client{Timeout: 8 * time.Second}).Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("unexpected status: %d", resp.StatusCode)
-	}
-	bodyBytes, err := io.ReadAll(resp.Body)
+// stripMetadata reports whether images command c posts in room have their
+// EXIF, XMP and text metadata stripped: unless c has keep_metadata, which a
+// room with stripExif overrides.
+func stripMetadata(c *BotCommand, room config.RoomIDEntry) bool {
+	return !c.KeepMetadata || room.StripEXIF
+}
+
+func handleHttpCommand(ctx context.Context, c *BotCommand, linkstashURL string, ev *event.Event, matrixClient *mautrix.Client, messagesDB *sql.DB, room config.RoomIDEntry) (string, error) {
+	bodyBytes, contentType, err := fetchHttpCommand(ctx, c, messagesDB)
 	if err != nil {
 		return "", err
 	}
 
-	if c.JSONPath != "" || strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "application/json") {
+	if c.JSONPath != "" || strings.Contains(strings.ToLower(contentType), "application/json") {
 		var j interface{}
 		if err := json.Unmarshal(bodyBytes, &j); err != nil {
 			return strings.TrimSpace(string(bodyBytes)), nil
@@ -87,12 +87,15 @@ func handleHttpCommand(ctx context.Context, c *BotCommand, linkstashURL string,
 							log.Error().Interface("panic", r).Msg("panic in http image download")
 						}
 					}()
-					data, ct, err := downloadExternalImage(url)
+					data, ext, err := downloadExternalImage(url)
 					if err != nil {
 						log.Warn().Err(err).Str("url", url).Msg("image download failed")
 						return
 					}
-					if err := matrix.SendImageToMatrix(context.Background(), matrixClient, ev.RoomID, ev.ID, data, ct, "image.jpg"); err != nil {
+					if stripMetadata(c, room) {
+						data = matrix.StripImageMetadata(data)
+					}
+					if err := matrix.SendImageToMatrix(context.Background(), matrixClient, ev.RoomID, ev.ID, data, "image"+ext, ""); err != nil {
 						log.Warn().Err(err).Msg("send image failed")
 					}
 				}(s)
@@ -112,91 +115,298 @@ func handleHttpCommand(ctx context.Context, c *BotCommand, linkstashURL string,
 	return strings.TrimSpace(string(bodyBytes)), nil
 }
 
-func handleExecCommand(ctx context.Context, ev *event.Event, matrixClient *mautrix.Client, c *BotCommand) (string, error) {
-	var inputPath string
-	var tmpFiles []string
-	defer func() {
-		for _, f := range tmpFiles {
-			_ = os.Remove(f)
+// fetchHttpCommand makes an http command's request, or with cache_seconds
+// returns its cached response while it's fresh.
+func fetchHttpCommand(ctx context.Context, c *BotCommand, messagesDB *sql.DB) ([]byte, string, error) {
+	var key string
+	if c.CacheSeconds > 0 {
+		key = httpCacheKey(c)
+		if e, ok := cachedHTTPResponse(messagesDB, c, key); ok {
+			return e.Body, e.ContentType, nil
+		}
+	}
+	method := c.Method
+	if method == "" {
+		method = "GET"
+	}
+	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, c.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := util.HTTPClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if c.CacheSeconds > 0 {
+		cacheHTTPResponse(messagesDB, c, key, body, contentType)
+	}
+	return body, contentType, nil
+}
+
+func handleExecCommand(ctx context.Context, ev *event.Event, matrixClient *mautrix.Client, c *BotCommand, room config.RoomIDEntry, replyLabel string) (string, error) {
+	var inputPath, inputExt, inputText string
+	var animated bool
+	if c.InputType == "text" {
+		text, err := commandTargetText(ctx, ev, matrixClient)
+		if err != nil {
+			return "", err
 		}
-	}()
+		if text == "" {
+			return locale.ForRoom(string(ev.RoomID), "need_text"), nil
+		}
+		inputText = text
+	}
+	dir, err := newExecDir()
+	if err != nil {
+		return "", fmt.Errorf("create exec dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
 
 	if c.InputType == "image" {
 		imgMsg, err := matrix.DownloadImageFromMessage(ctx, matrixClient, ev)
 		if err != nil {
-			return "reply to an image to use this command", nil
+			return locale.ForRoom(string(ev.RoomID), "need_image"), nil
 		}
 		mediaURL, encFile, err := matrix.MediaFromMessage(imgMsg)
 		if err != nil {
 			return "", err
 		}
 		data, err := matrix.DownloadImageBytes(ctx, matrixClient, mediaURL, encFile)
+		if errors.Is(err, matrix.ErrNotImage) {
+			return locale.ForRoom(string(ev.RoomID), "need_image"), nil
+		}
 		if err != nil {
 			return "", err
 		}
-
-		tmpDir := "data/tmp"
-		_ = os.MkdirAll(tmpDir, 0755)
-		tmpFile, err := os.CreateTemp(tmpDir, "exec_input_*.tmp")
+		// The input is named after its sniffed type, which tools like
+		// ImageMagick go by.
+		_, inputExt, _ = matrix.SniffImage(data)
+		animated = matrix.IsAnimated(data)
+		tmpFile, err := os.CreateTemp(dir, "exec_input_*"+inputExt)
 		if err != nil {
 			return "", fmt.Errorf("create temp input: %w", err)
 		}
-		tmpFiles = append(tmpFiles, tmpFile.Name())
+		inputPath = tmpFile.Name()
 		if _, err := tmpFile.Write(data); err != nil {
 			tmpFile.Close()
 			return "", fmt.Errorf("write image data: %w", err)
 		}
 		tmpFile.Close()
+	}
+	if inputText != "" && slices.Contains(c.Args, "{input}") {
+		inputPath = filepath.Join(dir, "input.txt")
+		if err := os.WriteFile(inputPath, []byte(inputText), 0644); err != nil {
+			return "", fmt.Errorf("write text input: %w", err)
+		}
+	}
 
-		ext := matrix.DetectImageExtension(tmpFile.Name())
-		newName := strings.TrimSuffix(tmpFile.Name(), ".tmp") + ext
-		if err := os.Rename(tmpFile.Name(), newName); err != nil {
-			inputPath = tmpFile.Name()
-		} else {
-			inputPath = newName
-			tmpFiles = append(tmpFiles, newName)
+	// Animated inputs use animated_args when set, and the output keeps the
+	// input's extension so the animation survives the conversion.
+	cmdArgs := c.Args
+	outputPattern := "exec_output_*"
+	if c.OutputType == "video" {
+		// ffmpeg picks the container from the extension.
+		outputPattern += ".mp4"
+	}
+	if animated {
+		if len(c.AnimatedArgs) > 0 {
+			cmdArgs = c.AnimatedArgs
 		}
+		outputPattern += inputExt
 	}
 
-	args := make([]string, len(c.Args))
+	ec := newExecContext(ctx, matrixClient, ev, room)
+	args := make([]string, len(cmdArgs))
 	var outputPath string
-	for i, arg := range c.Args {
+	for i, arg := range cmdArgs {
 		switch arg {
 		case "{input}":
 			args[i] = inputPath
 		case "{output}":
-			out, err := os.CreateTemp("data/tmp", "exec_output_*")
+			out, err := os.CreateTemp(dir, outputPattern)
 			if err != nil {
 				return "", fmt.Errorf("create output file: %w", err)
 			}
 			outputPath = out.Name()
 			args[i] = outputPath
 			out.Close()
-			tmpFiles = append(tmpFiles, outputPath)
 		default:
-			args[i] = arg
+			args[i] = ec.expand(arg)
+		}
+	}
+
+	run := execRun{args: args, env: ec.environ(c), dir: dir, stdin: inputText}
+	var progress *progressReply
+	if c.Progress {
+		if progress, err = startProgressReply(ctx, matrixClient, ev, replyLabel); err != nil {
+			log.Warn().Err(err).Str("cmd", c.Command).Msg("failed to post progress reply")
+		} else {
+			run.progress = progress
+		}
+	}
+	stdout, err := runExec(ctx, c, run)
+	if err != nil {
+		if progress != nil {
+			progress.finish(ctx, replyLabel+"failed", "")
+		}
+		return "", err
+	}
+	resp, err := sendExecOutput(ctx, ev, matrixClient, c, room, outputPath, stdout)
+	if progress == nil {
+		return resp, err
+	}
+	// The progress reply becomes the command's reply.
+	switch {
+	case err != nil:
+		progress.finish(ctx, replyLabel+"failed", "")
+		return "", err
+	case resp != "":
+		plain, formatted := c.FormatOutput(replyLabel, resp)
+		progress.finish(ctx, plain, formatted)
+	default:
+		progress.finish(ctx, replyLabel+"done", "")
+	}
+	return "", nil
+}
+
+// spoilerRe matches ||spoiler|| text in ai and static responses.
+var spoilerRe = regexp.MustCompile(`(?s)\|\|(.+?)\|\|`)
+
+// FormatOutput returns the plain and HTML bodies of a reply with a command's
+// text output after label. With format code the output is a code block, so
+// jq output, logs and ASCII art keep their layout in clients with
+// proportional fonts. With spoiler the whole output is hidden until clicked,
+// and in ai and static responses ||text|| hides just that text; the plain
+// body keeps the || markers. Otherwise formatted is empty and the plain body
+// gets escaped as usual.
+func (c *BotCommand) FormatOutput(label, text string) (plain, formatted string) {
+	out, body := text, ""
+	switch {
+	case c.Format == "code":
+		class := ""
+		if c.Language != "" {
+			class = ` class="language-` + html.EscapeString(c.Language) + `"`
 		}
+		out = "\n```" + c.Language + "\n" + text + "\n```"
+		body = "<pre><code" + class + ">" + html.EscapeString(text) + "</code></pre>"
+	case c.Spoiler:
+		body = event.TextToHTML(text)
+	case (c.Type == "ai" || c.Response != "") && spoilerRe.MatchString(text):
+		body = spoilerRe.ReplaceAllString(event.TextToHTML(text), "<span data-mx-spoiler>$1</span>")
+	default:
+		return label + text, ""
+	}
+	if c.Spoiler {
+		out = "||" + out + "||"
+		body = "<span data-mx-spoiler>" + body + "</span>"
 	}
+	return label + out, event.TextToHTML(label) + body
+}
 
-	cmd := exec.Command(c.Command, args...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("exec failed: %w, stderr: %s", err, stderr.String())
+// sendExecOutput posts an exec command's {output} file for media output types
+// and returns the text to reply with, if any: stdout for text output, or with
+// text_output reply.
+func sendExecOutput(ctx context.Context, ev *event.Event, matrixClient *mautrix.Client, c *BotCommand, room config.RoomIDEntry, outputPath string, stdout []byte) (string, error) {
+	text := strings.TrimSpace(string(stdout))
+	// With text_output, stdout goes with the media as its caption or as a
+	// reply of its own.
+	var caption, reply string
+	switch c.TextOutput {
+	case "caption":
+		caption = text
+	case "reply":
+		reply = text
+	}
+	outputType := c.OutputType
+	if outputType == "media" {
+		outputType = mediaOutputType(outputPath)
+	}
+	switch outputType {
+	case "image", "audio", "video", "file":
+		info, err := os.Stat(outputPath)
+		if err != nil {
+			return "", fmt.Errorf("stat output: %w", err)
+		}
+		if err := matrix.CheckUploadSize(info.Size()); err != nil {
+			log.Warn().Err(err).Str("cmd", c.Command).Msg("exec output not uploaded")
+			return err.Error(), nil
+		}
+		if err := matrix.CheckQuota(ctx, ev.RoomID, info.Size()); err != nil {
+			log.Warn().Err(err).Str("cmd", c.Command).Msg("exec output not uploaded")
+			return err.Error(), nil
+		}
 	}
 
-	if c.OutputType == "image" {
+	if outputType == "image" {
 		data, err := os.ReadFile(outputPath)
 		if err != nil {
 			return "", fmt.Errorf("read processed image: %w", err)
 		}
-		if err := matrix.SendImageToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, data, defaultContentType, "processed.jpg"); err != nil {
+		if stripMetadata(c, room) {
+			data = matrix.StripImageMetadata(data)
+		}
+		_, ext, err := matrix.SniffImage(data)
+		if err != nil {
+			return "", fmt.Errorf("exec output: %w", err)
+		}
+		if err := matrix.SendImageToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, data, "processed"+ext, caption); err != nil {
 			return "", err
 		}
-		return "", nil
+		return reply, nil
 	}
-	return strings.TrimSpace(stdout.String()), nil
+	if outputType == "audio" {
+		data, err := os.ReadFile(outputPath)
+		if err != nil {
+			return "", fmt.Errorf("read processed audio: %w", err)
+		}
+		ct, ext := matrix.SniffMediaType(data)
+		// Declared audio output is a voice message; detected audio is a clip.
+		voice := c.OutputType == "audio"
+		if err := matrix.SendAudioToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, data, ct, "audio"+ext, caption, voice); err != nil {
+			return "", err
+		}
+		return reply, nil
+	}
+	if outputType == "video" {
+		_, ext := matrix.DetectFileType(outputPath)
+		if err := matrix.SendVideoToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, outputPath, "video"+ext, caption); err != nil {
+			return "", err
+		}
+		return reply, nil
+	}
+	if outputType == "file" {
+		_, ext := matrix.DetectFileType(outputPath)
+		if err := matrix.SendFileToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, outputPath, event.MsgFile, "output"+ext, caption); err != nil {
+			return "", err
+		}
+		return reply, nil
+	}
+	return text, nil
+}
+
+// mediaOutputType picks the output type for a media output from its
+// contents: image, video, audio or else file.
+func mediaOutputType(path string) string {
+	ct, _ := matrix.DetectFileType(path)
+	switch kind, _, _ := strings.Cut(ct, "/"); kind {
+	case "image", "video", "audio":
+		return kind
+	}
+	return "file"
 }
 
 func handleAiCommand(ctx context.Context, ev *event.Event, matrixClient *mautrix.Client, c *BotCommand, groqAPIKey string, replyLabel string) (string, error) {
@@ -257,17 +467,12 @@ func handleAiCommand(ctx context.Context, ev *event.Event, matrixClient *mautrix
 		return "", err
 	}
 
-	if originalEventID != "" {
+	if originalEventID != "" && c.OutputType != "reaction" {
 		label := replyLabel
 		if label == "" {
 			label = "> "
 		}
-		content := event.MessageEventContent{
-			MsgType:   event.MsgText,
-			Body:      label + response,
-			RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: originalEventID}},
-		}
-		if _, err := matrixClient.SendMessageEvent(ctx, ev.RoomID, event.EventMessage, &content); err != nil {
+		if _, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, originalEventID, label+response, ""); err != nil {
 			return "", fmt.Errorf("send reply: %w", err)
 		}
 		return "", nil
@@ -275,44 +480,32 @@ func handleAiCommand(ctx context.Context, ev *event.Event, matrixClient *mautrix
 	return response, nil
 }
 
-func handleBuiltinCommand(ctx context.Context, ev *event.Event, matrixClient *mautrix.Client, c *BotCommand, messagesDB *sql.DB, replyLabel string) (string, error) {
+// AskAI answers question using a command's prompt, model and max_tokens,
+// for builtins that fall back to AI.
+func AskAI(ctx context.Context, groqAPIKey string, c *BotCommand, question string) (string, error) {
+	return callGroq(ctx, groqAPIKey, c.Model, c.MaxTokens, c.Prompt+"\n\n"+util.TruncateText(question, 2000))
+}
+
+func handleBuiltinCommand(ctx context.Context, ev *event.Event, matrixClient *mautrix.Client, c *BotCommand, groqAPIKey string, messagesDB *sql.DB, replyLabel string) (string, error) {
+	if aiFn, ok := builtinAIFuncs[c.Command]; ok {
+		return aiFn(ctx, matrixClient, ev, c, groqAPIKey)
+	}
+	if cmdFn, ok := builtinCmdFuncs[c.Command]; ok {
+		return cmdFn(ctx, matrixClient, ev, c)
+	}
 	if dbFn, ok := builtinDBFuncs[c.Command]; ok {
 		matrix.ParseEvent(ev)
 		msg := ev.Content.AsMessage()
 		if msg == nil {
 			return "", fmt.Errorf("not a message event")
 		}
-		var args string
-		parts := strings.Fields(msg.Body)
-		if len(parts) > 2 {
-			args = strings.TrimSpace(strings.Join(parts[2:], " "))
-		}
-		return dbFn(ctx, messagesDB, matrixClient, ev, args, replyLabel, c.Mention)
-	}
-
-	matrix.ParseEvent(ev)
-	msg := ev.Content.AsMessage()
-	if msg == nil {
-		return "", fmt.Errorf("not a message event")
-	}
-
-	var targetText string
-	if msg.RelatesTo != nil && msg.RelatesTo.InReplyTo != nil {
-		original, err := matrix.FetchAndDecrypt(ctx, matrixClient, ev.RoomID, msg.RelatesTo.InReplyTo.EventID)
-		if err == nil {
-			if om := original.Content.AsMessage(); om != nil {
-				targetText = om.Body
-			}
-		}
+		return dbFn(ctx, messagesDB, matrixClient, ev, commandArgs(msg.Body), replyLabel, c.Mention)
 	}
 
-	if targetText == "" {
-		parts := strings.Fields(msg.Body)
-		if len(parts) > 2 {
-			targetText = strings.TrimSpace(strings.Join(parts[2:], " "))
-		}
+	targetText, err := commandTargetText(ctx, ev, matrixClient)
+	if err != nil {
+		return "", err
 	}
-
 	if targetText == "" {
 		return "uwu~ pwease give me some text to twansfowm!", nil
 	}
@@ -329,6 +522,24 @@ var builtinFuncs = map[string]func(string) string{
 	"uwuify": Uwuify,
 }
 
+// builtinCmdFuncs maps builtin command names that use their command's
+// settings, such as its sandbox.
+var builtinCmdFuncs = map[string]func(context.Context, *mautrix.Client, *event.Event, *BotCommand) (string, error){
+	"tex":        handleTexCommand,
+	"diagram":    handleDiagramCommand,
+	"carbon":     handleCarbonCommand,
+	"gif":        handleGifCommand,
+	"transcribe": handleTranscribeCommand,
+	"caption":    handleCaptionCommand,
+	"upload":     handleUploadCommand,
+}
+
+// builtinAIFuncs maps builtin command names that pass their result through
+// AI, with their command's prompt, model and max_tokens, when it has a prompt.
+var builtinAIFuncs = map[string]func(context.Context, *mautrix.Client, *event.Event, *BotCommand, string) (string, error){
+	"ocr": handleOCRCommand,
+}
+
 // builtinDBFuncs maps builtin command names that need DB access.
 var builtinDBFuncs = map[string]func(context.Context, *sql.DB, *mautrix.Client, *event.Event, string, string, bool) (string, error){
 	"yap":     QueryTopYappers,
@@ -339,6 +550,8 @@ var builtinDBFuncs = map[string]func(context.Context, *sql.DB, *mautrix.Client,
 	"trivia":  QueryTrivia,
 	"madlibs": QueryMadlibs,
 	"predict": QueryPredict,
+	"top":     QueryTopReacted,
+	"search":  QuerySearch,
 }
 
 // ---------------------------------------------------------------------------
@@ -357,27 +570,35 @@ func callGroq(ctx context.Context, apiKey, model string, maxTokens int, prompt s
 	}
 	cfg := openai.DefaultConfig(apiKey)
 	cfg.BaseURL = "https://api.groq.com/openai/v1"
+	cfg.HTTPClient = util.HTTPClient
 	resp, err := openai.NewClientWithConfig(cfg).CreateChatCompletion(ctx, openai.ChatCompletionRequest{
 		Model:     model,
 		Messages:  []openai.ChatCompletionMessage{{Role: "user", Content: prompt}},
 		MaxTokens: maxTokens,
 	})
-	if err != nil {
-		return "", fmt.Errorf("groq api: %w", err)
-	}
-	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("no response from groq")
+	var content string
+	var tokens int
+	switch {
+	case err != nil:
+		err = fmt.Errorf("groq api: %w", err)
+	case len(resp.Choices) == 0:
+		err = fmt.Errorf("no response from groq")
+	default:
+		content = resp.Choices[0].Message.Content
+		tokens = resp.Usage.TotalTokens
 	}
-	return resp.Choices[0].Message.Content, nil
+	logAIExchange(ctx, model, prompt, content, tokens, err)
+	return content, err
 }
 
 func fetchArticleContents(ctx context.Context) (string, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://linkstash.hsp-ec.xyz/api/summary", nil)
+	summaryCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(summaryCtx, "GET", "https://linkstash.hsp-ec.xyz/api/summary", nil)
 	if err != nil {
 		return "", err
 	}
-	resp, err := client.Do(req)
+	resp, err := util.HTTPClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -403,18 +624,22 @@ func fetchArticleContents(ctx context.Context) (string, error) {
 	var contents []string
 	for _, article := range data.Summary {
 		contentURL := fmt.Sprintf("https://linkstash.hsp-ec.xyz/api/content/%s", article.ID)
-		req, err := http.NewRequestWithContext(ctx, "GET", contentURL, nil)
+		contentCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		req, err := http.NewRequestWithContext(contentCtx, "GET", contentURL, nil)
 		if err != nil {
+			cancel()
 			log.Warn().Err(err).Str("id", article.ID).Msg("failed to create content request")
 			continue
 		}
-		resp, err := client.Do(req)
+		resp, err := util.HTTPClient.Do(req)
 		if err != nil {
+			cancel()
 			log.Warn().Err(err).Str("id", article.ID).Msg("failed to fetch content")
 			continue
 		}
 		body, err := io.ReadAll(resp.Body)
 		resp.Body.Close()
+		cancel()
 		if err != nil || resp.StatusCode != http.StatusOK {
 			log.Warn().Int("status", resp.StatusCode).Str("id", article.ID).Msg("bad content response")
 			continue
@@ -427,8 +652,11 @@ func fetchArticleContents(ctx context.Context) (string, error) {
 	return strings.Join(contents, "\n\n---\n\n"), nil
 }
 
+// downloadExternalImage fetches an http command's image URL and returns the
+// data and its sniffed extension; responses that aren't images, whatever
+// their Content-Type, fail with matrix.ErrNotImage.
 func downloadExternalImage(url string) ([]byte, string, error) {
-	resp, err := http.Get(url)
+	resp, err := util.HTTPClient.Get(url)
 	if err != nil {
 		return nil, "", fmt.Errorf("download image: %w", err)
 	}
@@ -436,13 +664,38 @@ func downloadExternalImage(url string) ([]byte, string, error) {
 	if resp.StatusCode != http.StatusOK {
 		return nil, "", fmt.Errorf("image download status %d", resp.StatusCode)
 	}
-	data, err := io.ReadAll(resp.Body)
+	if err := matrix.CheckUploadSize(resp.ContentLength); err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, matrix.MaxUploadBytes+1))
 	if err != nil {
 		return nil, "", fmt.Errorf("read image data: %w", err)
 	}
-	ct := resp.Header.Get("Content-Type")
-	if ct == "" {
-		ct = defaultContentType
+	if err := matrix.CheckUploadSize(int64(len(data))); err != nil {
+		return nil, "", err
+	}
+	_, ext, err := matrix.SniffImage(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, ext, nil
+}
+
+// commandTargetText returns the text a command transforms: the body of the
+// message ev replies to or, failing that, what follows "/bot <command>".
+func commandTargetText(ctx context.Context, ev *event.Event, matrixClient *mautrix.Client) (string, error) {
+	matrix.ParseEvent(ev)
+	msg := ev.Content.AsMessage()
+	if msg == nil {
+		return "", fmt.Errorf("not a message event")
+	}
+	if msg.RelatesTo != nil && msg.RelatesTo.InReplyTo != nil {
+		original, err := matrix.FetchAndDecrypt(ctx, matrixClient, ev.RoomID, msg.RelatesTo.InReplyTo.EventID)
+		if err == nil {
+			if om := original.Content.AsMessage(); om != nil && om.Body != "" {
+				return om.Body, nil
+			}
+		}
 	}
-	return data, ct, nil
+	return commandArgs(msg.Body), nil
 }
@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+// ConversationState holds the state of conversations that continue when
+// someone replies or reacts to one of the bot's messages, such as a
+// knock-knock joke or a paged reply, keyed by that message. Entries expire
+// ttl after they're set.
+type ConversationState[K comparable, V any] struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[K]conversationEntry[V]
+}
+
+type conversationEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// NewConversationState creates a ConversationState whose entries last ttl.
+func NewConversationState[K comparable, V any](ttl time.Duration) *ConversationState[K, V] {
+	return &ConversationState[K, V]{ttl: ttl, entries: make(map[K]conversationEntry[V])}
+}
+
+// Set stores v for k, replacing any earlier value, and drops expired
+// entries.
+func (s *ConversationState[K, V]) Set(k K, v V) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+	s.entries[k] = conversationEntry[V]{value: v, expires: now.Add(s.ttl)}
+}
+
+// Get retrieves the value for k, if it's set and hasn't expired.
+func (s *ConversationState[K, V]) Get(k K) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[k]
+	if !ok || time.Now().After(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Take retrieves and removes the value for k, so only one caller continues
+// the conversation.
+func (s *ConversationState[K, V]) Take(k K) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[k]
+	delete(s.entries, k)
+	if !ok || time.Now().After(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Delete removes the value for k.
+func (s *ConversationState[K, V]) Delete(k K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, k)
+}
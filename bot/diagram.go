@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/matrix"
+)
+
+// diagramMaxChars bounds the source /bot diagram renders.
+const diagramMaxChars = 10000
+
+// diagramPrograms are the programs /bot diagram runs, looked up on PATH:
+// Graphviz's dot for DOT source and mermaid-cli's mmdc for mermaid.
+var diagramPrograms = []string{"dot", "mmdc"}
+
+// codeFenceRe matches a Markdown code block, with its language.
+var codeFenceRe = regexp.MustCompile("(?s)```([A-Za-z0-9_.+#-]*)[^\\n]*\\n(.*?)```")
+
+// dotStartRe matches the start of a DOT graph, up to its opening brace;
+// mermaid's "graph TD" has none.
+var dotStartRe = regexp.MustCompile(`^(?i)(strict\s+)?(di)?graph(\s+("[^"]*"|[\w.]+))?\s*\{`)
+
+// diagramSource returns the diagram in text, from its first code block if
+// it has one, and its language: "dot" or "mermaid". A code block's language
+// decides; otherwise DOT is recognised by its "graph {" or "digraph {" header.
+func diagramSource(text string) (src, lang string) {
+	src = strings.TrimSpace(text)
+	if m := codeFenceRe.FindStringSubmatch(text); m != nil {
+		src = strings.TrimSpace(m[2])
+		switch strings.ToLower(m[1]) {
+		case "dot", "graphviz", "gv":
+			return src, "dot"
+		case "mermaid", "mmd":
+			return src, "mermaid"
+		}
+	}
+	if dotStartRe.MatchString(src) {
+		return src, "dot"
+	}
+	return src, "mermaid"
+}
+
+// handleDiagramCommand renders the mermaid or DOT diagram after /bot
+// diagram, or in the replied-to message, to a PNG and replies with it. dot
+// and mmdc run like exec commands, in a fresh temp directory under c's
+// sandbox.
+func handleDiagramCommand(ctx context.Context, matrixClient *mautrix.Client, ev *event.Event, c *BotCommand) (string, error) {
+	text, err := commandTargetText(ctx, ev, matrixClient)
+	if err != nil {
+		return "", err
+	}
+	src, lang := diagramSource(text)
+	switch {
+	case src == "":
+		return "give a mermaid or DOT diagram to render, or reply to one", nil
+	case len([]rune(src)) > diagramMaxChars:
+		return fmt.Sprintf("that diagram is too long (max %d characters)", diagramMaxChars), nil
+	}
+
+	dir, err := newExecDir()
+	if err != nil {
+		return "", fmt.Errorf("create exec dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "diagram.png")
+	var program string
+	var run execRun
+	switch lang {
+	case "dot":
+		// With GV_FILE_PATH set, Graphviz only loads images from it and
+		// refuses absolute paths, so a graph can't embed the bot's files.
+		program = diagramPrograms[0]
+		run = execRun{
+			args:  []string{"-Tpng", "-Gdpi=150", "-o", output},
+			env:   []string{"GV_FILE_PATH=" + dir},
+			dir:   dir,
+			stdin: src,
+		}
+	default:
+		input := filepath.Join(dir, "diagram.mmd")
+		if err := os.WriteFile(input, []byte(src), 0644); err != nil {
+			return "", fmt.Errorf("write diagram: %w", err)
+		}
+		program = diagramPrograms[1]
+		run = execRun{
+			args: []string{"-q", "-i", input, "-o", output, "-s", "2", "-b", "white"},
+			dir:  dir,
+		}
+	}
+	tool, err := builtinTool(c, program)
+	if err != nil {
+		return "", err
+	}
+	if _, err := runExec(ctx, tool, run); err != nil {
+		var ee *execError
+		if errors.As(err, &ee) {
+			if msg, _, _ := strings.Cut(strings.TrimSpace(ee.stderr), "\n"); msg != "" {
+				return "couldn't render that " + lang + " diagram: " + msg, nil
+			}
+		}
+		return "", err
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		return "", fmt.Errorf("read rendered diagram: %w", err)
+	}
+	if err := matrix.CheckUploadSize(int64(len(data))); err != nil {
+		return err.Error(), nil
+	}
+	if err := matrix.CheckQuota(ctx, ev.RoomID, int64(len(data))); err != nil {
+		return err.Error(), nil
+	}
+	if err := matrix.SendImageToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, data, "diagram.png", ""); err != nil {
+		return "", err
+	}
+	return "", nil
+}
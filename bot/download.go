@@ -0,0 +1,272 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/links"
+	"github.com/polarhive/ash/matrix"
+)
+
+const (
+	// defaultDownloadMaxDuration bounds the videos, or clips, a download
+	// command fetches without max_duration_seconds.
+	defaultDownloadMaxDuration = 5 * time.Minute
+	// defaultDownloadTimeout bounds each program a download command runs
+	// without a sandbox timeout_seconds; downloads take longer than the
+	// usual exec command.
+	defaultDownloadTimeout = 5 * time.Minute
+	// downloadMaxHeight is the tallest video downloaded, and transcoded
+	// videos are scaled down to downloadMaxWidth.
+	downloadMaxHeight = 720
+	downloadMaxWidth  = 1280
+)
+
+// downloadPrograms are the programs download commands run, looked up on
+// PATH: yt-dlp, which uses ffmpeg to merge and cut what it downloads, and
+// ffmpeg to transcode.
+var downloadPrograms = []string{"yt-dlp", "ffmpeg"}
+
+// downloadRequest returns the link in args, or else the first one in the
+// replied-to message, and a clip range like "1:30-2:00" from args.
+func downloadRequest(args, replied string) (link, clip string) {
+	for _, f := range strings.Fields(args) {
+		switch {
+		case link == "" && len(links.ExtractLinks(f)) > 0:
+			link = links.ExtractLinks(f)[0]
+		case clip == "" && f[0] >= '0' && f[0] <= '9':
+			clip = f
+		}
+	}
+	if link == "" {
+		if found := links.ExtractLinks(replied); len(found) > 0 {
+			link = found[0]
+		}
+	}
+	return link, clip
+}
+
+// parseTimestamp parses seconds, m:ss or h:mm:ss, with optional fractions of
+// a second.
+func parseTimestamp(s string) (float64, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, false
+	}
+	var secs float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 || math.IsInf(v, 0) || (i > 0 && v >= 60) || (i < len(parts)-1 && strings.Contains(p, ".")) {
+			return 0, false
+		}
+		secs = secs*60 + v
+	}
+	return secs, true
+}
+
+// parseClip parses a clip range like "90-120" or "1:30-2:00" into its
+// start and end in seconds.
+func parseClip(s string) (start, end float64, ok bool) {
+	from, to, found := strings.Cut(s, "-")
+	if !found {
+		return 0, 0, false
+	}
+	if start, ok = parseTimestamp(from); !ok {
+		return 0, 0, false
+	}
+	if end, ok = parseTimestamp(to); !ok || end <= start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// parseProbe reads the duration and title yt-dlp prints for a link. The
+// duration is 0 when the site doesn't say.
+func parseProbe(out []byte) (duration float64, title string) {
+	first, rest, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	duration, _ = strconv.ParseFloat(strings.TrimSpace(first), 64)
+	title, _, _ = strings.Cut(rest, "\n")
+	if title = strings.TrimSpace(title); title == "NA" {
+		title = ""
+	}
+	return duration, title
+}
+
+// formatSeconds formats secs as m:ss.
+func formatSeconds(secs float64) string {
+	s := int(secs)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// downloadTool returns the exec command that runs program for download
+// command c, under its sandbox with a longer default timeout.
+func downloadTool(c *BotCommand, program string) (*BotCommand, error) {
+	tool, err := builtinTool(c, program)
+	if err != nil {
+		return nil, err
+	}
+	s := ExecSandbox{TimeoutSeconds: int(defaultDownloadTimeout / time.Second)}
+	if c.Sandbox != nil {
+		s = *c.Sandbox
+		if s.TimeoutSeconds == 0 {
+			s.TimeoutSeconds = int(defaultDownloadTimeout / time.Second)
+		}
+	}
+	tool.Sandbox = &s
+	return tool, nil
+}
+
+// toolFailure turns a program's failure into a reply starting with what,
+// using the first line of its stderr, or returns err if it didn't say.
+func toolFailure(err error, what string) (string, error) {
+	var ee *execError
+	if errors.As(err, &ee) {
+		for _, line := range strings.Split(strings.TrimSpace(ee.stderr), "\n") {
+			if line = strings.TrimSpace(strings.TrimPrefix(line, "ERROR:")); line != "" {
+				return what + ": " + line, nil
+			}
+		}
+	}
+	return "", err
+}
+
+// handleDownloadCommand downloads the video linked after the command, or in
+// the replied-to message, and replies with it as an m.video with its title
+// as caption. A clip range after the link, like 1:30-2:00, downloads just
+// that part. Videos, or clips, longer than max_duration_seconds or larger
+// than max_size_mb are refused. yt-dlp, and ffmpeg with transcode, run like
+// exec commands, in a fresh temp directory under c's sandbox.
+func handleDownloadCommand(ctx context.Context, ev *event.Event, matrixClient *mautrix.Client, c *BotCommand) (string, error) {
+	matrix.ParseEvent(ev)
+	msg := ev.Content.AsMessage()
+	if msg == nil {
+		return "", fmt.Errorf("not a message event")
+	}
+	var replied string
+	if msg.RelatesTo != nil && msg.RelatesTo.InReplyTo != nil {
+		if original, err := matrix.FetchAndDecrypt(ctx, matrixClient, ev.RoomID, msg.RelatesTo.InReplyTo.EventID); err == nil {
+			if om := original.Content.AsMessage(); om != nil {
+				replied = om.Body
+			}
+		}
+	}
+	link, clip := downloadRequest(commandArgs(msg.Body), replied)
+	if link == "" {
+		return "give a link, or reply to a message with one, to download the video", nil
+	}
+	var start, end float64
+	if clip != "" {
+		var ok bool
+		if start, end, ok = parseClip(clip); !ok {
+			return fmt.Sprintf("can't read the clip %q, give it like 1:30-2:00", clip), nil
+		}
+	}
+	maxDuration := defaultDownloadMaxDuration.Seconds()
+	if c.MaxDurationSeconds > 0 {
+		maxDuration = float64(c.MaxDurationSeconds)
+	}
+	maxSize := matrix.MaxUploadBytes
+	if c.MaxSizeMB > 0 && (maxSize <= 0 || int64(c.MaxSizeMB)<<20 < maxSize) {
+		maxSize = int64(c.MaxSizeMB) << 20
+	}
+	if clip != "" && end-start > maxDuration {
+		return fmt.Sprintf("that clip is too long (max %s)", formatSeconds(maxDuration)), nil
+	}
+
+	release, err := acquireExecSlot(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	dir, err := newExecDir()
+	if err != nil {
+		return "", fmt.Errorf("create exec dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ytdlp, err := downloadTool(c, downloadPrograms[0])
+	if err != nil {
+		return "", err
+	}
+	common := []string{"--no-playlist", "--no-warnings", "--ignore-config"}
+	out, err := runExec(ctx, ytdlp, execRun{
+		args: append(common, "--skip-download", "--print", "%(duration)s", "--print", "%(title)s", "--", link),
+		dir:  dir,
+	})
+	if err != nil {
+		return toolFailure(err, "couldn't download that")
+	}
+	duration, title := parseProbe(out)
+	if clip == "" && duration > maxDuration {
+		return fmt.Sprintf("that video is %s long, over the %s limit; give a clip like 0:30-1:00", formatSeconds(duration), formatSeconds(maxDuration)), nil
+	}
+
+	args := append(common, "--quiet", "--no-progress",
+		"-S", fmt.Sprintf("res:%d,vcodec:h264,acodec:aac", downloadMaxHeight),
+		"--merge-output-format", "mp4", "-o", filepath.Join(dir, "video.%(ext)s"))
+	if maxSize > 0 {
+		args = append(args, "--max-filesize", strconv.FormatInt(maxSize, 10))
+	}
+	if clip != "" {
+		args = append(args, "--download-sections", fmt.Sprintf("*%g-%g", start, end), "--force-keyframes-at-cuts")
+	}
+	if _, err := runExec(ctx, ytdlp, execRun{args: append(args, "--", link), dir: dir}); err != nil {
+		return toolFailure(err, "couldn't download that")
+	}
+	// yt-dlp skips files over --max-filesize without failing.
+	files, _ := filepath.Glob(filepath.Join(dir, "video.*"))
+	path := ""
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".part") && !strings.HasSuffix(f, ".ytdl") {
+			path = f
+		}
+	}
+	if path == "" {
+		return fmt.Sprintf("that video is larger than %.0f MB", float64(maxSize)/(1<<20)), nil
+	}
+
+	if c.Transcode {
+		ffmpeg, err := downloadTool(c, downloadPrograms[1])
+		if err != nil {
+			return "", err
+		}
+		transcoded := filepath.Join(dir, "clip.mp4")
+		if _, err := runExec(ctx, ffmpeg, execRun{args: []string{
+			"-v", "error", "-y", "-i", path,
+			"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", downloadMaxWidth), "-pix_fmt", "yuv420p",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "28",
+			"-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart", transcoded,
+		}, dir: dir}); err != nil {
+			return toolFailure(err, "couldn't transcode that")
+		}
+		path = transcoded
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("stat video: %w", err)
+	}
+	if maxSize > 0 && info.Size() > maxSize {
+		return fmt.Sprintf("that video is larger than %.0f MB", float64(maxSize)/(1<<20)), nil
+	}
+	if err := matrix.CheckUploadSize(info.Size()); err != nil {
+		return err.Error(), nil
+	}
+	if err := matrix.CheckQuota(ctx, ev.RoomID, info.Size()); err != nil {
+		return err.Error(), nil
+	}
+	if err := matrix.SendVideoToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, path, "video"+filepath.Ext(path), title); err != nil {
+		return "", err
+	}
+	return "", nil
+}
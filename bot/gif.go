@@ -0,0 +1,251 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	grand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/matrix"
+	"github.com/polarhive/ash/util"
+)
+
+// GIFSearch is the provider /bot gif searches. Set from config GIF_SEARCH;
+// nil turns /bot gif off.
+var GIFSearch *config.GIFSearchConfig
+
+// The search APIs' endpoints, variables so tests can point them elsewhere.
+var (
+	tenorSearchURL = "https://tenor.googleapis.com/v2/search"
+	giphySearchURL = "https://api.giphy.com/v1/gifs/search"
+)
+
+const (
+	// gifResults is how many results are asked for; one is picked at
+	// random, so asking again gives another.
+	gifResults = 10
+	// gifMaxBytes is the largest GIF posted as is; bigger ones are posted as
+	// their MP4 version, which is a fraction of the size.
+	gifMaxBytes = 8 << 20
+	// gifSearchTimeout bounds the search request.
+	gifSearchTimeout = 10 * time.Second
+)
+
+// tenorContentFilter maps GIF_SEARCH ratings to Tenor's content filters.
+var tenorContentFilter = map[string]string{"g": "high", "pg": "medium", "pg-13": "low", "r": "off"}
+
+// gifResult is a search result, with its GIF and, if there is one, its MP4
+// version.
+type gifResult struct {
+	Title         string
+	GIF, MP4      string
+	Width, Height int
+	GIFSize       int64 // 0 if the provider doesn't say
+}
+
+// gifSearchURL returns the request URL searching provider g for query.
+func gifSearchURL(g *config.GIFSearchConfig, query string) string {
+	rating := g.Rating
+	if rating == "" {
+		rating = "pg-13"
+	}
+	q := url.Values{"q": {query}, "limit": {strconv.Itoa(gifResults)}}
+	if g.Provider == "giphy" {
+		q.Set("api_key", g.APIKey)
+		q.Set("rating", rating)
+		return giphySearchURL + "?" + q.Encode()
+	}
+	q.Set("key", g.APIKey)
+	q.Set("client_key", "ash")
+	q.Set("media_filter", "gif,mp4")
+	q.Set("contentfilter", tenorContentFilter[rating])
+	return tenorSearchURL + "?" + q.Encode()
+}
+
+// parseTenor reads a Tenor v2 search response.
+func parseTenor(data []byte) ([]gifResult, error) {
+	type format struct {
+		URL  string `json:"url"`
+		Dims []int  `json:"dims"`
+		Size int64  `json:"size"`
+	}
+	var resp struct {
+		Results []struct {
+			Title        string            `json:"title"`
+			Description  string            `json:"content_description"`
+			MediaFormats map[string]format `json:"media_formats"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("decode tenor response: %w", err)
+	}
+	var results []gifResult
+	for _, r := range resp.Results {
+		gif, ok := r.MediaFormats["gif"]
+		if !ok || gif.URL == "" {
+			continue
+		}
+		res := gifResult{Title: r.Title, GIF: gif.URL, MP4: r.MediaFormats["mp4"].URL, GIFSize: gif.Size}
+		if res.Title == "" {
+			res.Title = r.Description
+		}
+		if len(gif.Dims) == 2 {
+			res.Width, res.Height = gif.Dims[0], gif.Dims[1]
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// parseGiphy reads a Giphy search response, whose numbers are strings.
+func parseGiphy(data []byte) ([]gifResult, error) {
+	var resp struct {
+		Data []struct {
+			Title  string `json:"title"`
+			Images struct {
+				Original struct {
+					URL    string `json:"url"`
+					Width  string `json:"width"`
+					Height string `json:"height"`
+					Size   string `json:"size"`
+					MP4    string `json:"mp4"`
+				} `json:"original"`
+			} `json:"images"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("decode giphy response: %w", err)
+	}
+	var results []gifResult
+	for _, d := range resp.Data {
+		o := d.Images.Original
+		if o.URL == "" {
+			continue
+		}
+		res := gifResult{Title: d.Title, GIF: o.URL, MP4: o.MP4}
+		res.Width, _ = strconv.Atoi(o.Width)
+		res.Height, _ = strconv.Atoi(o.Height)
+		res.GIFSize, _ = strconv.ParseInt(o.Size, 10, 64)
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// searchGIFs searches GIFSearch's provider for query.
+func searchGIFs(ctx context.Context, query string) ([]gifResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, gifSearchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gifSearchURL(GIFSearch, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := util.HTTPClient.Do(req)
+	if err != nil {
+		// The error's URL has the API key in it.
+		return nil, fmt.Errorf("search %s failed", GIFSearch.Provider)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search %s: status %d", GIFSearch.Provider, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read %s response: %w", GIFSearch.Provider, err)
+	}
+	if GIFSearch.Provider == "giphy" {
+		return parseGiphy(data)
+	}
+	return parseTenor(data)
+}
+
+// fetchMedia downloads a search result's GIF or MP4, up to MaxUploadBytes.
+func fetchMedia(ctx context.Context, mediaURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := util.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download gif: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download gif: status %d", resp.StatusCode)
+	}
+	if err := matrix.CheckUploadSize(resp.ContentLength); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, matrix.MaxUploadBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("download gif: %w", err)
+	}
+	return data, matrix.CheckUploadSize(int64(len(data)))
+}
+
+// handleGifCommand searches GIF_SEARCH's provider for what follows /bot gif
+// and replies with one of the top results: as an animated m.image, or as
+// an m.video of its MP4 version when the GIF is over gifMaxBytes.
+func handleGifCommand(ctx context.Context, matrixClient *mautrix.Client, ev *event.Event, c *BotCommand) (string, error) {
+	matrix.ParseEvent(ev)
+	msg := ev.Content.AsMessage()
+	if msg == nil {
+		return "", fmt.Errorf("not a message event")
+	}
+	if GIFSearch == nil {
+		return "GIF search isn't set up; set GIF_SEARCH in config.json", nil
+	}
+	query := commandArgs(msg.Body)
+	if query == "" {
+		return "give something to search for, like /bot gif happy cat", nil
+	}
+	results, err := searchGIFs(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return fmt.Sprintf("no GIFs found for %q", query), nil
+	}
+	res := results[grand.Intn(len(results))]
+	body := res.Title
+	if body == "" {
+		body = query
+	}
+
+	if res.MP4 == "" || (res.GIFSize > 0 && res.GIFSize <= gifMaxBytes) {
+		data, err := fetchMedia(ctx, res.GIF)
+		if err == nil && (res.MP4 == "" || len(data) <= gifMaxBytes) {
+			return "", matrix.SendImageToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, data, body, "")
+		}
+		if res.MP4 == "" {
+			return "", err
+		}
+	}
+	data, err := fetchMedia(ctx, res.MP4)
+	if err != nil {
+		return "", err
+	}
+	if err := matrix.CheckQuota(ctx, ev.RoomID, int64(len(data))); err != nil {
+		return err.Error(), nil
+	}
+	dir, err := newExecDir()
+	if err != nil {
+		return "", fmt.Errorf("create exec dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "gif.mp4")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("write gif: %w", err)
+	}
+	return "", matrix.SendVideoToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, path, body, "")
+}
@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/polarhive/ash/db"
+)
+
+// httpCacheMax bounds the in-memory cache of http command responses.
+const httpCacheMax = 256
+
+var (
+	httpCacheMu sync.Mutex
+	httpCache   = make(map[string]db.HTTPCacheEntry)
+)
+
+// httpCacheKey identifies an http command's request: its method, URL and
+// headers.
+func httpCacheKey(c *BotCommand) string {
+	h := sha256.New()
+	h.Write([]byte(c.Method + "\n" + c.URL + "\n"))
+	for _, k := range slices.Sorted(maps.Keys(c.Headers)) {
+		h.Write([]byte(k + ": " + c.Headers[k] + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedHTTPResponse returns a response cached for c within its
+// cache_seconds, from memory or, with cache_persist, the messages database.
+func cachedHTTPResponse(messagesDB *sql.DB, c *BotCommand, key string) (db.HTTPCacheEntry, bool) {
+	now := time.Now().UnixMilli()
+	httpCacheMu.Lock()
+	e, ok := httpCache[key]
+	httpCacheMu.Unlock()
+	if ok && e.ExpiresMS > now {
+		return e, true
+	}
+	if !c.CachePersist || messagesDB == nil {
+		return db.HTTPCacheEntry{}, false
+	}
+	e, ok, err := db.HTTPCacheGet(messagesDB, key, now)
+	if err != nil {
+		log.Warn().Err(err).Str("url", c.URL).Msg("failed to read http cache")
+		return db.HTTPCacheEntry{}, false
+	}
+	if ok {
+		rememberHTTPResponse(key, e, now)
+	}
+	return e, ok
+}
+
+// cacheHTTPResponse keeps a response for c's cache_seconds.
+func cacheHTTPResponse(messagesDB *sql.DB, c *BotCommand, key string, body []byte, contentType string) {
+	now := time.Now().UnixMilli()
+	e := db.HTTPCacheEntry{
+		Body:        body,
+		ContentType: contentType,
+		ExpiresMS:   now + int64(c.CacheSeconds)*1000,
+	}
+	rememberHTTPResponse(key, e, now)
+	if c.CachePersist && messagesDB != nil {
+		if err := db.HTTPCachePut(messagesDB, key, e, now); err != nil {
+			log.Warn().Err(err).Str("url", c.URL).Msg("failed to write http cache")
+		}
+	}
+}
+
+// rememberHTTPResponse adds e to the in-memory cache, evicting expired
+// entries, or the one expiring soonest, when it's full.
+func rememberHTTPResponse(key string, e db.HTTPCacheEntry, now int64) {
+	httpCacheMu.Lock()
+	defer httpCacheMu.Unlock()
+	if _, ok := httpCache[key]; !ok && len(httpCache) >= httpCacheMax {
+		soonest := ""
+		for k, v := range httpCache {
+			if v.ExpiresMS <= now {
+				delete(httpCache, k)
+			} else if soonest == "" || v.ExpiresMS < httpCache[soonest].ExpiresMS {
+				soonest = k
+			}
+		}
+		if len(httpCache) >= httpCacheMax {
+			delete(httpCache, soonest)
+		}
+	}
+	httpCache[key] = e
+}
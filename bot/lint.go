@@ -0,0 +1,190 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+)
+
+// AppBuiltins are builtin commands implemented by the app package because
+// they need bot-wide state, so they aren't in builtinFuncs.
+var AppBuiltins = map[string]bool{
+	"knockknock": true,
+	"ignore":     true,
+	"unignore":   true,
+	"oops":       true,
+	"slowmode":   true,
+	"export":     true,
+	"modlog":     true,
+	"report":     true,
+	"backfill":   true,
+	"status":     true,
+	"more":       true,
+	"glossary":   true,
+	"ailog":      true,
+	"feed":       true,
+	"aikey":      true,
+	"sticker":    true,
+	"mydata":     true,
+}
+
+// IsBuiltin reports whether name is a builtin command ash implements.
+func IsBuiltin(name string) bool {
+	_, fn := builtinFuncs[name]
+	_, dbFn := builtinDBFuncs[name]
+	_, cmdFn := builtinCmdFuncs[name]
+	_, aiFn := builtinAIFuncs[name]
+	return fn || dbFn || cmdFn || aiFn || ModerationActions[name] || AppBuiltins[name]
+}
+
+var placeholderRe = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// LintBotConfig checks raw bot.json more strictly than Validate: unknown or
+// mistyped fields, duplicate command names (which JSON decoding silently
+// collapses), builtins ash doesn't implement and misused {input}/{output}
+// placeholders. Errors are sorted for stable output.
+func LintBotConfig(raw []byte) []error {
+	var bc BotConfig
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&bc); err != nil {
+		return []error{fmt.Errorf("decode: %w", err)}
+	}
+	errs := bc.Validate()
+	dups, err := duplicateCommands(raw)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, name := range dups {
+		errs = append(errs, fmt.Errorf("command %s: defined more than once", name))
+	}
+	for name, c := range bc.Commands {
+		errs = append(errs, lintCommand(name, c)...)
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
+}
+
+func lintCommand(name string, c BotCommand) []error {
+	if c.Response != "" {
+		return nil
+	}
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("command %s: "+format, append([]any{name}, args...)...))
+	}
+	if c.Type == "builtin" && c.Command != "" && !IsBuiltin(c.Command) {
+		fail("unknown builtin %q", c.Command)
+	}
+	if c.Type != "http" && (c.CacheSeconds != 0 || c.CachePersist) {
+		fail("cache_seconds and cache_persist are only used by http commands")
+	}
+	if c.Type == "http" && c.CachePersist && c.CacheSeconds <= 0 {
+		fail("cache_persist has no effect without cache_seconds")
+	}
+	upload := c.Type == "builtin" && c.Command == "upload"
+	if c.Type != "download" && (c.MaxDurationSeconds != 0 || c.Transcode) {
+		fail("max_duration_seconds and transcode are only used by download commands")
+	}
+	if c.Type != "download" && !upload && c.MaxSizeMB != 0 {
+		fail("max_size_mb is only used by download commands and the upload builtin")
+	}
+	if !upload && len(c.AllowedTypes) > 0 {
+		fail("allowed_types is only used by the upload builtin")
+	}
+	if c.KeepMetadata && ((c.Type != "http" && c.Type != "exec") || (c.OutputType != "image" && c.OutputType != "media")) {
+		fail("keep_metadata is only used by http and exec commands with output_type image or media")
+	}
+	if c.Type == "download" {
+		if c.Command != "" || len(c.Args) > 0 {
+			fail("download commands run yt-dlp themselves and don't use command or args")
+		}
+		if len(c.Env) > 0 || len(c.InheritEnv) > 0 || c.Workdir != "" || c.Progress || c.TextOutput != "" {
+			fail("env, inherit_env, workdir, progress and text_output are only used by exec commands")
+		}
+		return errs
+	}
+	if c.Type != "exec" {
+		if len(c.Args) > 0 || len(c.AnimatedArgs) > 0 {
+			fail("args are only used by exec commands")
+		}
+		if _, runsPrograms := toolPrograms(&c); c.Sandbox != nil && !runsPrograms {
+			fail("sandbox is only used by exec commands, download commands and builtins that run programs")
+		}
+		if len(c.Env) > 0 || len(c.InheritEnv) > 0 || c.Workdir != "" || c.MaxConcurrent != 0 || c.Progress || c.TextOutput != "" {
+			fail("env, inherit_env, workdir, max_concurrent, progress and text_output are only used by exec commands")
+		}
+		return errs
+	}
+	for _, args := range [][]string{c.Args, c.AnimatedArgs} {
+		for _, arg := range args {
+			for _, p := range placeholderRe.FindAllString(arg, -1) {
+				switch {
+				case slices.Contains(contextPlaceholders, p):
+				case p != "{input}" && p != "{output}":
+					fail("unknown placeholder %s", p)
+				case arg != p:
+					fail("placeholder %s must be a whole argument, got %q", p, arg)
+				case p == "{input}" && c.InputType != "image" && c.InputType != "text":
+					fail("{input} is only filled in for input_type image or text")
+				case p == "{output}" && !slices.Contains(fileOutputTypes, c.OutputType):
+					fail("{output} is only read for image, audio, video, file or media output")
+				}
+			}
+		}
+	}
+	if c.TextOutput != "" && !slices.Contains(fileOutputTypes, c.OutputType) {
+		fail("text_output only applies to image, audio, video, file or media output")
+	}
+	if c.Progress && c.OutputType == "reaction" {
+		fail("progress has no effect with output_type reaction")
+	}
+	for k, v := range c.Env {
+		for _, p := range placeholderRe.FindAllString(v, -1) {
+			if !slices.Contains(contextPlaceholders, p) {
+				fail("unknown placeholder %s in env %s", p, k)
+			}
+		}
+	}
+	if len(c.AnimatedArgs) > 0 && c.InputType != "image" {
+		fail("animated_args requires input_type image")
+	}
+	return errs
+}
+
+// duplicateCommands returns command names that appear more than once in the
+// "commands" object.
+func duplicateCommands(raw []byte) ([]string, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &top); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	cmds, ok := top["commands"]
+	if !ok {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(cmds))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("commands must be an object")
+	}
+	seen := make(map[string]int)
+	var dups []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("decode commands: %w", err)
+		}
+		name, _ := tok.(string)
+		if seen[name]++; seen[name] == 2 {
+			dups = append(dups, name)
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, fmt.Errorf("decode command %s: %w", name, err)
+		}
+	}
+	return dups, nil
+}
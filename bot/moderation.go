@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ---------------------------------------------------------------------------
+// Moderation (kick, ban, mute)
+// ---------------------------------------------------------------------------
+
+// ModerationActions lists the builtin commands that are moderation actions.
+var ModerationActions = map[string]bool{
+	"kick":   true,
+	"ban":    true,
+	"unban":  true,
+	"mute":   true,
+	"unmute": true,
+}
+
+// ModAction is a moderation action awaiting confirmation.
+type ModAction struct {
+	Action    string
+	Target    id.UserID
+	Reason    string
+	Requester id.UserID
+	Label     string
+	// PrevLevel is the target's power level before a mute: ApplyModeration
+	// sets it when muting and restores it when unmuting, falling back to the
+	// room's default level if it is nil.
+	PrevLevel *int
+	// MuteLevel is the level ApplyModeration set a muted target to.
+	MuteLevel int
+}
+
+// Describe returns a short human-readable summary of the action.
+func (a *ModAction) Describe() string {
+	s := fmt.Sprintf("%s %s", a.Action, a.Target)
+	if a.Reason != "" {
+		s += fmt.Sprintf(" (%s)", a.Reason)
+	}
+	return s
+}
+
+// ModerationState manages moderation actions pending confirmation, keyed by
+// the confirmation prompt.
+type ModerationState = ConversationState[id.EventID, *ModAction]
+
+// moderationTTL is how long a moderation action waits for confirmation.
+const moderationTTL = 5 * time.Minute
+
+// NewModerationState creates a new ModerationState.
+func NewModerationState() *ModerationState {
+	return NewConversationState[id.EventID, *ModAction](moderationTTL)
+}
+
+// ParseModerationArgs splits "@user:server some reason" into target and
+// reason. The target is empty if the first word isn't a Matrix user ID.
+func ParseModerationArgs(args string) (id.UserID, string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "@") || !strings.Contains(fields[0], ":") {
+		return "", strings.TrimSpace(args)
+	}
+	return id.UserID(fields[0]), strings.Join(fields[1:], " ")
+}
+
+// IsConfirmation reports whether a reply confirms a pending action.
+func IsConfirmation(body string) bool {
+	switch strings.ToLower(strings.TrimSpace(stripReplyFallback(body))) {
+	case "yes", "y", "confirm":
+		return true
+	}
+	return false
+}
+
+// stripReplyFallback drops the "> " quoted lines some clients prepend to replies.
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	var kept []string
+	for _, l := range lines {
+		if strings.HasPrefix(l, "> ") || l == ">" {
+			continue
+		}
+		kept = append(kept, l)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// RequiredPowerLevel returns the power level needed to perform the action.
+func RequiredPowerLevel(pl *event.PowerLevelsEventContent, action string) int {
+	switch action {
+	case "kick":
+		return pl.Kick()
+	case "ban", "unban":
+		return pl.Ban()
+	default:
+		return pl.GetEventLevel(event.StatePowerLevels)
+	}
+}
+
+// FetchPowerLevels loads the room's current power levels.
+func FetchPowerLevels(ctx context.Context, client *mautrix.Client, roomID id.RoomID) (*event.PowerLevelsEventContent, error) {
+	var pl event.PowerLevelsEventContent
+	if err := client.StateEvent(ctx, roomID, event.StatePowerLevels, "", &pl); err != nil {
+		return nil, fmt.Errorf("fetch power levels: %w", err)
+	}
+	return &pl, nil
+}
+
+// ApplyModeration performs the action through the Matrix API.
+func ApplyModeration(ctx context.Context, client *mautrix.Client, roomID id.RoomID, a *ModAction) error {
+	switch a.Action {
+	case "kick":
+		_, err := client.KickUser(ctx, roomID, &mautrix.ReqKickUser{UserID: a.Target, Reason: a.Reason})
+		return err
+	case "ban":
+		_, err := client.BanUser(ctx, roomID, &mautrix.ReqBanUser{UserID: a.Target, Reason: a.Reason})
+		return err
+	case "unban":
+		_, err := client.UnbanUser(ctx, roomID, &mautrix.ReqUnbanUser{UserID: a.Target, Reason: a.Reason})
+		return err
+	case "mute", "unmute":
+		pl, err := FetchPowerLevels(ctx, client, roomID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case a.Action == "mute":
+			prev := pl.GetUserLevel(a.Target)
+			a.PrevLevel = &prev
+			a.MuteLevel = pl.EventsDefault - 1
+			pl.SetUserLevel(a.Target, a.MuteLevel)
+		case a.PrevLevel != nil:
+			pl.SetUserLevel(a.Target, *a.PrevLevel)
+		default:
+			pl.SetUserLevel(a.Target, pl.UsersDefault)
+		}
+		_, err = client.SendStateEvent(ctx, roomID, event.StatePowerLevels, "", pl)
+		return err
+	default:
+		return fmt.Errorf("unknown moderation action: %s", a.Action)
+	}
+}
@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/locale"
+	"github.com/polarhive/ash/matrix"
+	"github.com/polarhive/ash/util"
+)
+
+// OCR is the backend /bot ocr reads text with. Set from config OCR; nil
+// means tesseract with English.
+var OCR *config.OCRConfig
+
+// ocrSpaceURL is OCR.space's endpoint, a variable so tests can point it
+// elsewhere.
+var ocrSpaceURL = "https://api.ocr.space/parse/image"
+
+// ocrTimeout bounds an OCR.space request.
+const ocrTimeout = 30 * time.Second
+
+// ocrPrograms returns the programs /bot ocr runs as exec commands:
+// tesseract, unless it uses OCR.space.
+func ocrPrograms() []string {
+	if OCR != nil && OCR.Backend == "ocr.space" {
+		return nil
+	}
+	return []string{"tesseract"}
+}
+
+// handleOCRCommand reads the text in the image /bot ocr replies to, or is
+// sent with, and replies with it. With a prompt, such as "Translate to
+// English:", the text is passed to AI with it and the answer is the reply.
+func handleOCRCommand(ctx context.Context, matrixClient *mautrix.Client, ev *event.Event, c *BotCommand, groqAPIKey string) (string, error) {
+	imgMsg, err := matrix.DownloadImageFromMessage(ctx, matrixClient, ev)
+	if err != nil {
+		return locale.ForRoom(string(ev.RoomID), "need_image"), nil
+	}
+	mediaURL, encFile, err := matrix.MediaFromMessage(imgMsg)
+	if err != nil {
+		return "", err
+	}
+	data, err := matrix.DownloadImageBytes(ctx, matrixClient, mediaURL, encFile)
+	if errors.Is(err, matrix.ErrNotImage) {
+		return locale.ForRoom(string(ev.RoomID), "need_image"), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var text string
+	if OCR != nil && OCR.Backend == "ocr.space" {
+		text, err = ocrSpace(ctx, data)
+	} else {
+		text, err = ocrTesseract(ctx, c, data)
+	}
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "no text found in that image", nil
+	}
+	if c.Prompt == "" {
+		return text, nil
+	}
+	return AskAI(ctx, groqAPIKey, c, text)
+}
+
+// ocrTesseract reads the text in an image with tesseract, which runs like
+// an exec command in a fresh temp directory under c's sandbox.
+func ocrTesseract(ctx context.Context, c *BotCommand, data []byte) (string, error) {
+	dir, err := newExecDir()
+	if err != nil {
+		return "", fmt.Errorf("create exec dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	_, ext, _ := matrix.SniffImage(data)
+	input := filepath.Join(dir, "image"+ext)
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		return "", fmt.Errorf("write image: %w", err)
+	}
+	langs := "eng"
+	if OCR != nil && OCR.Languages != "" {
+		langs = OCR.Languages
+	}
+	tool, err := builtinTool(c, ocrPrograms()[0])
+	if err != nil {
+		return "", err
+	}
+	out, err := runExec(ctx, tool, execRun{args: []string{input, "stdout", "-l", langs}, dir: dir})
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// ocrSpace reads the text in an image with the OCR.space API.
+func ocrSpace(ctx context.Context, data []byte) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("apikey", OCR.APIKey)
+	if OCR.Languages != "" {
+		w.WriteField("language", OCR.Languages)
+	}
+	w.WriteField("scale", "true")
+	_, ext, _ := matrix.SniffImage(data)
+	part, err := w.CreateFormFile("file", "image"+ext)
+	if err != nil {
+		return "", err
+	}
+	part.Write(data)
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ocrTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ocrSpaceURL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := util.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ocr.space: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("read ocr.space response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ocr.space: status %d", resp.StatusCode)
+	}
+	return parseOCRSpace(raw)
+}
+
+// parseOCRSpace reads an OCR.space response, whose ErrorMessage is a string
+// or a list of them.
+func parseOCRSpace(raw []byte) (string, error) {
+	var result struct {
+		ParsedResults []struct {
+			ParsedText string `json:"ParsedText"`
+		} `json:"ParsedResults"`
+		IsErroredOnProcessing bool            `json:"IsErroredOnProcessing"`
+		ErrorMessage          json.RawMessage `json:"ErrorMessage"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return "", fmt.Errorf("decode ocr.space response: %w", err)
+	}
+	if result.IsErroredOnProcessing {
+		var msgs []string
+		if json.Unmarshal(result.ErrorMessage, &msgs) != nil {
+			var msg string
+			json.Unmarshal(result.ErrorMessage, &msg)
+			msgs = []string{msg}
+		}
+		return "", fmt.Errorf("ocr.space: %s", strings.Join(msgs, "; "))
+	}
+	var texts []string
+	for _, r := range result.ParsedResults {
+		texts = append(texts, r.ParsedText)
+	}
+	return strings.Join(texts, "\n"), nil
+}
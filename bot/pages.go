@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/locale"
+	"github.com/polarhive/ash/matrix"
+)
+
+const (
+	// maxPageBytes bounds a page whatever REPLY_PAGE_CHARS is, so the plain
+	// body and its HTML escaping, up to five times longer, fit in Matrix's
+	// 64 KiB event limit.
+	maxPageBytes = 8000
+	// pagesTTL is how long the rest of a paged reply can be asked for.
+	pagesTTL = time.Hour
+	// NextPageEmoji is the reaction to a page that posts the next one.
+	NextPageEmoji = "➡️"
+)
+
+// Paginate splits text into pages of at most maxChars characters, and
+// maxPageBytes bytes, breaking after the last line that fits where there is
+// one. maxChars 0 leaves only the byte limit.
+func Paginate(text string, maxChars int) []string {
+	var pages []string
+	for text != "" {
+		cut := pageCut(text, maxChars)
+		if page := strings.TrimRight(text[:cut], "\n"); page != "" {
+			pages = append(pages, page)
+		}
+		text = strings.TrimLeft(text[cut:], "\n")
+	}
+	return pages
+}
+
+// pageCut returns the byte offset where the first page of text ends.
+func pageCut(text string, maxChars int) int {
+	end, n := 0, 0
+	for i, r := range text {
+		if (maxChars > 0 && n == maxChars) || i+utf8.RuneLen(r) > maxPageBytes {
+			break
+		}
+		n++
+		end = i + utf8.RuneLen(r)
+	}
+	if end == len(text) {
+		return end
+	}
+	if nl := strings.LastIndexByte(text[:end], '\n'); nl > 0 {
+		return nl + 1
+	}
+	return end
+}
+
+// ReplyPages is a command reply split into pages, of which Next is the next
+// to post.
+type ReplyPages struct {
+	Cmd   *BotCommand // formats each page
+	Label string
+	Pages []string
+	Next  int
+	Lang  string // of the page footers
+	// Shortcodes expands :shortcode:s in each page, with Emotes as the
+	// custom emoticons.
+	Shortcodes bool
+	Emotes     matrix.Emoticons
+}
+
+// Render returns the plain and HTML bodies of the next page, formatted as
+// the command's output with a footer saying how to get the one after.
+func (p *ReplyPages) Render() (plain, formatted string) {
+	plain, formatted = p.Cmd.FormatOutput(p.Label, p.Pages[p.Next])
+	if p.Shortcodes {
+		plain, formatted = matrix.ExpandShortcodes(plain, formatted, p.Emotes)
+	}
+	if len(p.Pages) == 1 {
+		return plain, formatted
+	}
+	footer := locale.Get(p.Lang, "page", p.Next+1, len(p.Pages))
+	if p.Next+1 < len(p.Pages) {
+		footer = locale.Get(p.Lang, "page_next", p.Next+1, len(p.Pages), NextPageEmoji)
+	}
+	plain += "\n" + footer
+	if formatted != "" {
+		formatted += event.TextToHTML(footer)
+	}
+	return plain, formatted
+}
+
+// PagedReplies holds the rest of each paged reply until someone asks for
+// it, by reacting to its last page or with /bot more.
+type PagedReplies struct {
+	byPage *ConversationState[id.EventID, *ReplyPages] // by the last page posted
+	latest *ConversationState[id.RoomID, id.EventID]   // each room's latest paged reply
+}
+
+// NewPagedReplies creates an empty PagedReplies.
+func NewPagedReplies() *PagedReplies {
+	return &PagedReplies{
+		byPage: NewConversationState[id.EventID, *ReplyPages](pagesTTL),
+		latest: NewConversationState[id.RoomID, id.EventID](pagesTTL),
+	}
+}
+
+// Add stores p, whose last posted page is pageID in roomID.
+func (r *PagedReplies) Add(roomID id.RoomID, pageID id.EventID, p *ReplyPages) {
+	r.byPage.Set(pageID, p)
+	r.latest.Set(roomID, pageID)
+}
+
+// Take removes and returns the paged reply whose last posted page is pageID,
+// or with pageID empty the room's latest one.
+func (r *PagedReplies) Take(roomID id.RoomID, pageID id.EventID) (*ReplyPages, bool) {
+	if pageID == "" {
+		var ok bool
+		if pageID, ok = r.latest.Get(roomID); !ok {
+			return nil, false
+		}
+	}
+	return r.byPage.Take(pageID)
+}
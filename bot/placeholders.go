@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/matrix"
+)
+
+// contextPlaceholders are what exec args and env values can refer to besides
+// {input} and {output}.
+var contextPlaceholders = []string{"{sender}", "{display_name}", "{room_id}", "{room}", "{event_id}", "{args}"}
+
+// execContext fills in contextPlaceholders for a command message. The
+// display name is only looked up when a placeholder needs it.
+type execContext struct {
+	ctx    context.Context
+	client *mautrix.Client
+	ev     *event.Event
+	room   config.RoomIDEntry
+	args   string
+}
+
+func newExecContext(ctx context.Context, client *mautrix.Client, ev *event.Event, room config.RoomIDEntry) *execContext {
+	ec := &execContext{ctx: ctx, client: client, ev: ev, room: room}
+	matrix.ParseEvent(ev)
+	if msg := ev.Content.AsMessage(); msg != nil {
+		ec.args = commandArgs(msg.Body)
+	}
+	return ec
+}
+
+func (ec *execContext) value(p string) (string, bool) {
+	switch p {
+	case "{sender}":
+		return string(ec.ev.Sender), true
+	case "{display_name}":
+		if name := matrix.DisplayName(ec.ctx, ec.client, ec.ev.RoomID, ec.ev.Sender); name != "" {
+			return name, true
+		}
+		return string(ec.ev.Sender), true
+	case "{room_id}":
+		return string(ec.ev.RoomID), true
+	case "{room}":
+		return ec.room.Comment, true
+	case "{event_id}":
+		return string(ec.ev.ID), true
+	case "{args}":
+		return ec.args, true
+	}
+	return "", false
+}
+
+// expand replaces the context placeholders in s, leaving any others as they
+// are.
+func (ec *execContext) expand(s string) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	return placeholderRe.ReplaceAllStringFunc(s, func(p string) string {
+		if v, ok := ec.value(p); ok {
+			return v
+		}
+		return p
+	})
+}
+
+// environ returns c's env with its values expanded, as KEY=value pairs in
+// key order.
+func (ec *execContext) environ(c *BotCommand) []string {
+	env := make([]string, 0, len(c.Env))
+	for k, v := range c.Env {
+		env = append(env, k+"="+ec.expand(v))
+	}
+	sort.Strings(env)
+	return env
+}
+
+// commandArgs returns what follows "/bot <command>" in body.
+func commandArgs(body string) string {
+	parts := strings.Fields(body)
+	if len(parts) > 2 {
+		return strings.TrimSpace(strings.Join(parts[2:], " "))
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/matrix"
+)
+
+const (
+	// progressInterval is how often a progress reply is edited with new
+	// output.
+	progressInterval = 2 * time.Second
+	// progressMaxBytes bounds the output a progress reply shows; older
+	// output is dropped from the front.
+	progressMaxBytes = 3000
+)
+
+// progressReply is the "working..." reply of an exec command with progress
+// set, edited with the command's stdout as it arrives and with the result
+// once it's done.
+type progressReply struct {
+	client *mautrix.Client
+	roomID id.RoomID
+	id     id.EventID
+	label  string
+
+	mu    sync.Mutex
+	out   []byte
+	dirty bool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// startProgressReply replies to ev with a "working..." message and starts
+// editing it as output is written to the returned progressReply.
+func startProgressReply(ctx context.Context, client *mautrix.Client, ev *event.Event, label string) (*progressReply, error) {
+	replyID, err := matrix.SendReply(ctx, client, ev.RoomID, ev.ID, label+"working...", "")
+	if err != nil {
+		return nil, err
+	}
+	p := &progressReply{
+		client: client,
+		roomID: ev.RoomID,
+		id:     replyID,
+		label:  label,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go p.run(ctx)
+	return p, nil
+}
+
+// Write adds command output to show in the next edit.
+func (p *progressReply) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.out = append(p.out, b...)
+	if over := len(p.out) - progressMaxBytes; over > 0 {
+		p.out = append(p.out[:0], p.out[over:]...)
+	}
+	p.dirty = true
+	return len(b), nil
+}
+
+func (p *progressReply) run(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			body, dirty := string(p.out), p.dirty
+			p.dirty = false
+			p.mu.Unlock()
+			if dirty {
+				p.edit(ctx, p.label+"working...\n"+body, "")
+			}
+		case <-p.stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finish stops the periodic edits and replaces the reply with body, and
+// formatted as its HTML body if set.
+func (p *progressReply) finish(ctx context.Context, body, formatted string) {
+	close(p.stop)
+	<-p.done
+	p.edit(ctx, body, formatted)
+}
+
+func (p *progressReply) edit(ctx context.Context, body, formatted string) {
+	content := matrix.ReplyContent("", body, formatted)
+	content.SetEdit(p.id)
+	if _, err := p.client.SendMessageEvent(ctx, p.roomID, event.EventMessage, &content); err != nil {
+		log.Warn().Err(err).Str("event_id", string(p.id)).Msg("failed to edit progress reply")
+	}
+}
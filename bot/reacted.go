@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/matrix"
+)
+
+// reactedPeriodRe matches "/bot top reacted" periods such as "1d", "2w" or "12h".
+var reactedPeriodRe = regexp.MustCompile(`^(\d+)([hdw])$`)
+
+// parseReactedPeriod turns a period argument into how far back to look. An
+// empty period means one week.
+func parseReactedPeriod(s string) (time.Duration, error) {
+	if s == "" {
+		return 7 * 24 * time.Hour, nil
+	}
+	m := reactedPeriodRe.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return 0, fmt.Errorf("period %q should look like 12h, 1d or 2w", s)
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n <= 0 || n > 520 {
+		return 0, fmt.Errorf("period %q is out of range", s)
+	}
+	unit := map[string]time.Duration{"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}[m[2]]
+	return time.Duration(n) * unit, nil
+}
+
+// reactedUser is one sender's count of reactions received.
+type reactedUser struct {
+	sender    string
+	reactions int
+	messages  int
+}
+
+// reactedMessage is the single most reacted message of a period.
+type reactedMessage struct {
+	id        string
+	sender    string
+	body      string
+	reactions int
+	emojis    string
+}
+
+// topReacted returns the senders whose messages got the most reactions since
+// cutoff (in ms) and the most reacted message, or nil if there is none.
+// Reactions to your own messages and to the bot's don't count.
+func topReacted(ctx context.Context, db *sql.DB, roomID string, cutoff int64, botID string, limit int) ([]reactedUser, *reactedMessage, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.sender, COUNT(*) AS reactions, COUNT(DISTINCT m.id) AS messages
+		FROM reactions r
+		INNER JOIN messages m ON m.id = r.message_id
+		WHERE r.room_id = ?
+		  AND r.created_at_ms >= ?
+		  AND r.reactor != m.sender
+		  AND m.sender != ?
+		GROUP BY m.sender
+		ORDER BY reactions DESC, messages ASC, m.sender
+		LIMIT ?
+	`, roomID, cutoff, botID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var users []reactedUser
+	for rows.Next() {
+		var u reactedUser
+		if err := rows.Scan(&u.sender, &u.reactions, &u.messages); err != nil {
+			return nil, nil, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil, nil
+	}
+
+	var best reactedMessage
+	err = db.QueryRowContext(ctx, `
+		SELECT m.id, m.sender, m.body, COUNT(*) AS reactions, GROUP_CONCAT(DISTINCT r.emoji)
+		FROM reactions r
+		INNER JOIN messages m ON m.id = r.message_id
+		WHERE r.room_id = ?
+		  AND r.created_at_ms >= ?
+		  AND r.reactor != m.sender
+		  AND m.sender != ?
+		GROUP BY m.id
+		ORDER BY reactions DESC, m.ts_ms ASC
+		LIMIT 1
+	`, roomID, cutoff, botID).Scan(&best.id, &best.sender, &best.body, &best.reactions, &best.emojis)
+	if err != nil {
+		return nil, nil, err
+	}
+	best.emojis = strings.ReplaceAll(best.emojis, ",", "")
+	return users, &best, nil
+}
+
+// QueryTopReacted handles "/bot top reacted [period]": the users whose
+// messages received the most reactions in the period (default 1w) and the
+// single most reacted message, with a permalink.
+func QueryTopReacted(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client, ev *event.Event, args string, replyLabel string, mention bool) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("no database available")
+	}
+	fields := strings.Fields(args)
+	if len(fields) == 0 || strings.ToLower(fields[0]) != "reacted" {
+		return "usage: /bot top reacted [12h|1d|1w]", nil
+	}
+	var period string
+	if len(fields) > 1 {
+		period = fields[1]
+	}
+	window, err := parseReactedPeriod(period)
+	if err != nil {
+		return err.Error(), nil
+	}
+	if period == "" {
+		period = "1w"
+	}
+
+	botID := ""
+	if matrixClient != nil {
+		botID = string(matrixClient.UserID)
+	}
+	cutoff := time.Now().Add(-window).UnixMilli()
+	users, best, err := topReacted(ctx, db, string(ev.RoomID), cutoff, botID, 10)
+	if err != nil {
+		return "", fmt.Errorf("query reactions: %w", err)
+	}
+	if len(users) == 0 {
+		return fmt.Sprintf("no reactions in the past %s", period), nil
+	}
+
+	displayNames := matrix.RoomDisplayNames(ctx, matrixClient, ev.RoomID)
+	display := func(sender string) string {
+		if dn, ok := displayNames[sender]; ok {
+			return dn
+		}
+		return id.UserID(sender).Localpart()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "most appreciated (past %s):\n", period)
+	for i, u := range users {
+		fmt.Fprintf(&b, "%d. %s — %d reaction(s) on %d message(s)\n", i+1, display(u.sender), u.reactions, u.messages)
+	}
+	body := best.body
+	if len([]rune(body)) > 80 {
+		body = string([]rune(body)[:77]) + "..."
+	}
+	fmt.Fprintf(&b, "\ntop message: %s %q — %s (%d reaction(s))\n%s",
+		best.emojis, body, display(best.sender), best.reactions, matrix.Permalink(ev.RoomID, id.EventID(best.id)))
+	return b.String(), nil
+}
@@ -0,0 +1,342 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// execQueueWait bounds how long a run waits for one of its command's
+	// max_concurrent slots.
+	execQueueWait = 2 * time.Minute
+	// defaultExecTimeout bounds an exec command without timeout_seconds.
+	defaultExecTimeout = 30 * time.Second
+	// defaultExecMaxOutput caps an exec command's stdout and stderr, each,
+	// without max_output_bytes.
+	defaultExecMaxOutput = 1 << 20
+)
+
+// ExecSandbox limits an exec command's process. Whatever the settings, every
+// run gets a fresh temp directory as its HOME and TMPDIR, and as its working
+// directory unless the command sets workdir, removed afterwards, and an
+// environment with only PATH, LANG, the variables listed in inherit_env and
+// the command's env.
+type ExecSandbox struct {
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`  // default 30
+	MaxOutputBytes int `json:"max_output_bytes,omitempty"` // per stream, default 1 MiB
+	MemoryMB       int `json:"memory_mb,omitempty"`        // address space limit (ulimit -v)
+	CPUSeconds     int `json:"cpu_seconds,omitempty"`      // CPU time limit (ulimit -t)
+	// Wrapper is a program and arguments the command runs under, such as
+	// bwrap, nsjail or docker run; the command and its arguments are
+	// appended and {tmpdir} is replaced with the run's temp directory.
+	Wrapper []string `json:"wrapper,omitempty"`
+}
+
+var (
+	execSlotsMu sync.Mutex
+	// execSlots holds a semaphore per command with max_concurrent, keyed by
+	// execSlotKey.
+	execSlots = make(map[string]chan struct{})
+)
+
+// execSlotKey identifies a command for max_concurrent: commands running the
+// same program with the same args share their slots.
+func execSlotKey(c *BotCommand) string {
+	return strings.Join(append([]string{c.Command}, c.Args...), "\x00")
+}
+
+// acquireExecSlot waits until fewer than c's max_concurrent runs are going
+// and returns a func that ends this one. Commands without max_concurrent
+// never wait.
+func acquireExecSlot(ctx context.Context, c *BotCommand) (func(), error) {
+	if c.MaxConcurrent <= 0 {
+		return func() {}, nil
+	}
+	key := execSlotKey(c)
+	execSlotsMu.Lock()
+	slots := execSlots[key]
+	if cap(slots) != c.MaxConcurrent {
+		// New, or max_concurrent changed on reload; runs holding the old
+		// semaphore release into it.
+		slots = make(chan struct{}, c.MaxConcurrent)
+		execSlots[key] = slots
+	}
+	execSlotsMu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	default:
+	}
+	log.Debug().Str("cmd", c.Command).Int("max_concurrent", c.MaxConcurrent).Msg("exec command queued")
+	timer := time.NewTimer(execQueueWait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("exec busy: still %d runs going after %s", c.MaxConcurrent, execQueueWait)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ExecAllowlist, when set, lists the only executables exec commands may run,
+// as absolute paths. Set from config EXEC_ALLOWLIST.
+var ExecAllowlist []string
+
+// execPrograms returns the executables c runs: its command and its sandbox
+// wrapper's program.
+func execPrograms(c *BotCommand) []string {
+	programs := []string{c.Command}
+	if c.Sandbox != nil && len(c.Sandbox.Wrapper) > 0 {
+		programs = append(programs, c.Sandbox.Wrapper[0])
+	}
+	return programs
+}
+
+// builtinPrograms lists the programs builtins run, as exec commands under
+// their command's sandbox.
+var builtinPrograms = map[string][]string{
+	"tex":     texPrograms,
+	"diagram": diagramPrograms,
+	"carbon":  carbonPrograms,
+}
+
+// toolPrograms returns the programs download command or builtin c runs as
+// exec commands, and whether it runs any.
+func toolPrograms(c *BotCommand) ([]string, bool) {
+	switch {
+	case c.Type == "download":
+		return downloadPrograms, true
+	case c.Type == "builtin" && c.Command == "transcribe":
+		return transcribePrograms(), true
+	case c.Type == "builtin" && c.Command == "ocr":
+		return ocrPrograms(), true
+	case c.Type == "builtin":
+		programs, ok := builtinPrograms[c.Command]
+		return programs, ok
+	}
+	return nil, false
+}
+
+// builtinTool returns the exec command that runs program for builtin c,
+// under c's sandbox. program is found on PATH so EXEC_ALLOWLIST can match
+// its absolute path.
+func builtinTool(c *BotCommand, program string) (*BotCommand, error) {
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return nil, err
+	}
+	return &BotCommand{Type: "exec", Command: path, Sandbox: c.Sandbox}, nil
+}
+
+// checkExecAllowed fails if c runs anything not in ExecAllowlist.
+func checkExecAllowed(c *BotCommand) error {
+	if len(ExecAllowlist) == 0 {
+		return nil
+	}
+	for _, p := range execPrograms(c) {
+		if !filepath.IsAbs(p) || !slices.Contains(ExecAllowlist, filepath.Clean(p)) {
+			return fmt.Errorf("%s is not in EXEC_ALLOWLIST", p)
+		}
+	}
+	return nil
+}
+
+// CheckExecBinaries checks that every exec command's executables, and those
+// of download commands and builtins that run programs (see toolPrograms),
+// exist and, with ExecAllowlist set, are allowed, so a misconfigured command
+// shows up at startup rather than when someone first uses it.
+func (bc *BotConfig) CheckExecBinaries() []error {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(bc.Commands)) {
+		c := bc.Commands[name]
+		if programs, ok := toolPrograms(&c); ok {
+			for _, p := range programs {
+				if tc, err := builtinTool(&c, p); err != nil {
+					errs = append(errs, fmt.Errorf("command %s: %w", name, err))
+				} else if err := checkExecAllowed(tc); err != nil {
+					errs = append(errs, fmt.Errorf("command %s: %w", name, err))
+				}
+			}
+			continue
+		}
+		if c.Type != "exec" || c.Response != "" || c.Command == "" {
+			continue
+		}
+		if err := checkExecAllowed(&c); err != nil {
+			errs = append(errs, fmt.Errorf("command %s: %w", name, err))
+			continue
+		}
+		for _, p := range execPrograms(&c) {
+			if _, err := exec.LookPath(p); err != nil {
+				errs = append(errs, fmt.Errorf("command %s: %w", name, err))
+			}
+		}
+	}
+	return errs
+}
+
+// errOutputLimit fails writes past an exec command's max_output_bytes.
+var errOutputLimit = errors.New("output limit exceeded")
+
+// cappedBuffer collects up to max bytes and fails writes beyond that, which
+// stops the copy from the process's pipe. It doesn't embed bytes.Buffer so
+// io.Copy can't bypass Write through Buffer.ReadFrom.
+type cappedBuffer struct {
+	buf  bytes.Buffer
+	max  int
+	over bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.max {
+		b.over = true
+		n, _ := b.buf.Write(p[:b.max-b.buf.Len()])
+		return n, errOutputLimit
+	}
+	return b.buf.Write(p)
+}
+
+// execError is a run that exited with an error, with what it wrote to
+// stderr.
+type execError struct {
+	err    error
+	stderr string
+}
+
+func (e *execError) Error() string {
+	return fmt.Sprintf("exec failed: %v, stderr: %s", e.err, e.stderr)
+}
+
+func (e *execError) Unwrap() error { return e.err }
+
+// newExecDir creates the temp directory for one run of an exec command.
+func newExecDir() (string, error) {
+	if err := os.MkdirAll(ExecTmpDir, 0755); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(ExecTmpDir, "exec_*")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(dir)
+}
+
+// command returns the program and arguments that run name with args
+// under s: inside the resource limits, then the wrapper.
+func (s *ExecSandbox) command(name string, args []string, dir string) (string, []string) {
+	argv := append([]string{name}, args...)
+	if s == nil {
+		return argv[0], argv[1:]
+	}
+	if len(s.Wrapper) > 0 {
+		wrapped := make([]string, 0, len(s.Wrapper)+len(argv))
+		for _, w := range s.Wrapper {
+			wrapped = append(wrapped, strings.ReplaceAll(w, "{tmpdir}", dir))
+		}
+		argv = append(wrapped, argv...)
+	}
+	var limits []string
+	if s.MemoryMB > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -v %d", s.MemoryMB*1024))
+	}
+	if s.CPUSeconds > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -t %d", s.CPUSeconds))
+	}
+	if len(limits) > 0 {
+		// The limits are inherited by the wrapper and everything it starts.
+		script := strings.Join(limits, " && ") + ` && exec "$@"`
+		argv = append([]string{"/bin/sh", "-c", script, "sh"}, argv...)
+	}
+	return argv[0], argv[1:]
+}
+
+// execRun is one run of an exec command.
+type execRun struct {
+	args     []string
+	env      []string // added to the environment
+	dir      string   // the run's temp directory
+	stdin    string
+	progress io.Writer // also gets stdout as it's written, if set
+}
+
+// runExec runs an exec command's program under its sandbox and returns what
+// it wrote to stdout. Programs not in ExecAllowlist are refused. With
+// max_concurrent it first waits its turn. The process, and anything it
+// started, is killed when the timeout passes.
+func runExec(ctx context.Context, c *BotCommand, run execRun) ([]byte, error) {
+	if err := checkExecAllowed(c); err != nil {
+		return nil, err
+	}
+	release, err := acquireExecSlot(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	timeout, maxOutput := defaultExecTimeout, defaultExecMaxOutput
+	if s := c.Sandbox; s != nil {
+		if s.TimeoutSeconds > 0 {
+			timeout = time.Duration(s.TimeoutSeconds) * time.Second
+		}
+		if s.MaxOutputBytes > 0 {
+			maxOutput = s.MaxOutputBytes
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	name, argv := c.Sandbox.command(c.Command, run.args, run.dir)
+	cmd := exec.CommandContext(ctx, name, argv...)
+	cmd.Dir = run.dir
+	if c.Workdir != "" {
+		cmd.Dir = c.Workdir
+	}
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	for _, k := range append([]string{"LANG"}, c.InheritEnv...) {
+		if v, ok := os.LookupEnv(k); ok {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+	cmd.Env = append(cmd.Env, "HOME="+run.dir, "TMPDIR="+run.dir)
+	cmd.Env = append(cmd.Env, run.env...)
+	if run.stdin != "" {
+		cmd.Stdin = strings.NewReader(run.stdin)
+	}
+	killProcessGroup(cmd)
+	// Don't wait forever for pipes held open by a killed process's children.
+	cmd.WaitDelay = 2 * time.Second
+	stdout := &cappedBuffer{max: maxOutput}
+	stderr := &cappedBuffer{max: maxOutput}
+	cmd.Stdout = stdout
+	if run.progress != nil {
+		cmd.Stdout = io.MultiWriter(stdout, run.progress)
+	}
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, fmt.Errorf("exec timed out after %s", timeout)
+	case stdout.over || stderr.over:
+		return nil, fmt.Errorf("exec output exceeded %d bytes", maxOutput)
+	case err != nil:
+		return nil, &execError{err: err, stderr: stderr.buf.String()}
+	}
+	return stdout.buf.Bytes(), nil
+}
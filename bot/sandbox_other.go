@@ -0,0 +1,13 @@
+//go:build !unix
+
+package bot
+
+import "os/exec"
+
+// resourceLimits reports whether sandbox memory_mb and cpu_seconds can be
+// applied: they are set with /bin/sh's ulimit, which Windows lacks.
+var resourceLimits = false
+
+// killProcessGroup leaves cmd as is: without process groups, cancelling it
+// kills only the process itself.
+func killProcessGroup(*exec.Cmd) {}
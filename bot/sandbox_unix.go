@@ -0,0 +1,21 @@
+//go:build unix
+
+package bot
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// resourceLimits reports whether sandbox memory_mb and cpu_seconds can be
+// applied, which needs /bin/sh's ulimit.
+var resourceLimits = true
+
+// killProcessGroup starts cmd in its own process group, so cancelling it
+// kills whatever it started as well.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
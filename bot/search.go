@@ -0,0 +1,282 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/locale"
+	"github.com/polarhive/ash/matrix"
+)
+
+const (
+	// searchDefaultLimit and searchMaxLimit bound how many results
+	// /bot search returns.
+	searchDefaultLimit = 5
+	searchMaxLimit     = 10
+	// searchSnippetRunes is how much of each matching message is shown.
+	searchSnippetRunes = 120
+)
+
+const searchUsage = "usage: /bot search <words> [from:@user] [before:YYYY-MM-DD] [after:YYYY-MM-DD] [has:link] [limit:N]"
+
+// searchQuery is a parsed /bot search. Zero fields don't filter.
+type searchQuery struct {
+	terms   []string
+	sender  string // a full user ID, or "@localpart" for any server
+	before  int64  // ms; messages sent before this
+	after   int64  // ms; messages sent at or after this
+	hasLink bool
+	limit   int
+}
+
+// parseSearchArgs parses the arguments of /bot search. Dates are days in
+// loc: before: keeps messages sent before that day and after: those sent
+// after it, so neither includes the day itself.
+func parseSearchArgs(args string, loc *time.Location) (searchQuery, error) {
+	q := searchQuery{limit: searchDefaultLimit}
+	day := func(key, value string) (time.Time, error) {
+		t, err := time.ParseInLocation("2006-01-02", value, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: wants a date like 2024-05-31, not %q", key, value)
+		}
+		return t, nil
+	}
+	for _, field := range strings.Fields(args) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok || value == "" {
+			q.terms = append(q.terms, field)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "from":
+			if !strings.HasPrefix(value, "@") {
+				value = "@" + value
+			}
+			q.sender = value
+		case "before":
+			t, err := day(key, value)
+			if err != nil {
+				return q, err
+			}
+			q.before = t.UnixMilli()
+		case "after":
+			t, err := day(key, value)
+			if err != nil {
+				return q, err
+			}
+			q.after = t.AddDate(0, 0, 1).UnixMilli()
+		case "has":
+			if strings.ToLower(value) != "link" {
+				return q, fmt.Errorf("has: only supports has:link")
+			}
+			q.hasLink = true
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > searchMaxLimit {
+				return q, fmt.Errorf("limit: wants a number from 1 to %d", searchMaxLimit)
+			}
+			q.limit = n
+		default:
+			q.terms = append(q.terms, field)
+		}
+	}
+	if len(q.terms) == 0 && q.sender == "" && !q.hasLink {
+		return q, fmt.Errorf("%s", searchUsage)
+	}
+	if q.before != 0 && q.after >= q.before {
+		return q, fmt.Errorf("after: must be a day before before:")
+	}
+	return q, nil
+}
+
+// searchResult is one message found by /bot search.
+type searchResult struct {
+	id     string
+	sender string
+	body   string
+	tsMs   int64
+}
+
+// searchMessages returns the newest of the room's messages matching q,
+// leaving out bot commands and the bot's own messages. Every term must
+// appear in the body, case-insensitively. Without a full-text index this is
+// a LIKE scan over the room's messages.
+func searchMessages(ctx context.Context, db *sql.DB, roomID string, q searchQuery, botID string) ([]searchResult, error) {
+	query := `
+		SELECT m.id, m.sender, m.body, m.ts_ms
+		FROM messages m
+		WHERE m.room_id = ?
+		  AND m.sender != ?
+		  AND m.body NOT LIKE '/bot %'`
+	params := []any{roomID, botID}
+	escape := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	for _, term := range q.terms {
+		query += ` AND m.body LIKE ? ESCAPE '\'`
+		params = append(params, "%"+escape.Replace(term)+"%")
+	}
+	switch {
+	case strings.Contains(q.sender, ":"):
+		query += ` AND m.sender = ?`
+		params = append(params, q.sender)
+	case q.sender != "":
+		query += ` AND m.sender LIKE ? ESCAPE '\'`
+		params = append(params, escape.Replace(q.sender)+":%")
+	}
+	if q.before != 0 {
+		query += ` AND m.ts_ms < ?`
+		params = append(params, q.before)
+	}
+	if q.after != 0 {
+		query += ` AND m.ts_ms >= ?`
+		params = append(params, q.after)
+	}
+	if q.hasLink {
+		query += ` AND EXISTS (SELECT 1 FROM links l WHERE l.message_id = m.id)`
+	}
+	query += ` ORDER BY m.ts_ms DESC, m.id LIMIT ?`
+	params = append(params, q.limit)
+
+	rows, err := db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []searchResult
+	for rows.Next() {
+		var r searchResult
+		if err := rows.Scan(&r.id, &r.sender, &r.body, &r.tsMs); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// searchSnippet cuts body to about searchSnippetRunes around the first
+// match of terms, on one line, and returns it as plain text and as HTML
+// with every match in bold.
+func searchSnippet(body string, terms []string) (plain, formatted string) {
+	runes := []rune(strings.Join(strings.Fields(body), " "))
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		t := []rune(strings.ToLower(term))
+		if len(t) == 0 {
+			continue
+		}
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) != string(t) {
+				continue
+			}
+			for j := i; j < i+len(t); j++ {
+				marked[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start, end := 0, len(runes)
+	if len(runes) > searchSnippetRunes {
+		start = max(first-searchSnippetRunes/3, 0)
+		end = min(start+searchSnippetRunes, len(runes))
+		start = max(end-searchSnippetRunes, 0)
+	}
+	var h strings.Builder
+	bold := false
+	for i := start; i < end; i++ {
+		if marked[i] != bold {
+			bold = marked[i]
+			if bold {
+				h.WriteString("<b>")
+			} else {
+				h.WriteString("</b>")
+			}
+		}
+		h.WriteString(html.EscapeString(string(runes[i])))
+	}
+	if bold {
+		h.WriteString("</b>")
+	}
+	plain, formatted = string(runes[start:end]), h.String()
+	if start > 0 {
+		plain, formatted = "…"+plain, "…"+formatted
+	}
+	if end < len(runes) {
+		plain, formatted = plain+"…", formatted+"…"
+	}
+	return plain, formatted
+}
+
+// QuerySearch handles "/bot search": the newest messages in the room that
+// contain every word given, optionally only from:@user, before: or after: a
+// day, or with has:link, as permalinks with the matches highlighted.
+func QuerySearch(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client, ev *event.Event, args string, replyLabel string, mention bool) (string, error) {
+	if db == nil {
+		return "", fmt.Errorf("no database available")
+	}
+	loc := roomTimezone(string(ev.RoomID))
+	q, err := parseSearchArgs(args, loc)
+	if err != nil {
+		return err.Error(), nil
+	}
+	botID := ""
+	if matrixClient != nil {
+		botID = string(matrixClient.UserID)
+	}
+	results, err := searchMessages(ctx, db, string(ev.RoomID), q, botID)
+	if err != nil {
+		return "", fmt.Errorf("search messages: %w", err)
+	}
+	if len(results) == 0 {
+		return locale.ForRoom(string(ev.RoomID), "no_messages"), nil
+	}
+
+	displayNames := matrix.RoomDisplayNames(ctx, matrixClient, ev.RoomID)
+	display := func(sender string) string {
+		if dn, ok := displayNames[sender]; ok {
+			return dn
+		}
+		return id.UserID(sender).Localpart()
+	}
+	esc := event.TextToHTML
+
+	var plain, rich strings.Builder
+	title := fmt.Sprintf("%d result(s), newest first:", len(results))
+	plain.WriteString(replyLabel + title + "\n")
+	rich.WriteString(esc(replyLabel+title) + "<br>")
+	for i, r := range results {
+		link := matrix.Permalink(ev.RoomID, id.EventID(r.id))
+		date := time.UnixMilli(r.tsMs).In(loc).Format("02 Jan 2006")
+		snippet, snippetHTML := searchSnippet(r.body, q.terms)
+		fmt.Fprintf(&plain, "%d. %s, %s: %s\n%s\n", i+1, display(r.sender), date, snippet, link)
+		who := esc(display(r.sender))
+		if mention {
+			who = fmt.Sprintf(`<a href="%s">%s</a>`, esc(matrix.UserLink(id.UserID(r.sender))), who)
+		}
+		fmt.Fprintf(&rich, `%d. %s, <a href="%s">%s</a>: %s<br>`, i+1, who, esc(link), date, snippetHTML)
+	}
+
+	if matrixClient != nil {
+		if _, err := matrix.SendReply(ctx, matrixClient, ev.RoomID, ev.ID, strings.TrimSpace(plain.String()), strings.TrimSuffix(rich.String(), "<br>")); err != nil {
+			return "", fmt.Errorf("send search reply: %w", err)
+		}
+		return "", nil
+	}
+	return strings.TrimSpace(plain.String()), nil
+}
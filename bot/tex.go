@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/matrix"
+)
+
+const (
+	// texMaxChars bounds the expression /bot tex renders.
+	texMaxChars = 2000
+	// texDPI is the resolution formulas are rendered at.
+	texDPI = 200
+)
+
+// texPrograms are the programs /bot tex runs, looked up on PATH: latex
+// typesets the formula and dvipng turns the result into a PNG.
+var texPrograms = []string{"latex", "dvipng"}
+
+// texForbiddenRe matches TeX that could read or write files, load packages
+// or redefine things, none of which a formula needs. A control word ends at
+// the first non-letter, and ^^ escapes could spell one out in hex.
+var texForbiddenRe = regexp.MustCompile(`\\(input|include|includeonly|includegraphics|openin|openout|read|readline|write|write18|immediate|special|catcode|csname|expandafter|def|edef|gdef|xdef|let|futurelet|newcommand|renewcommand|providecommand|newenvironment|renewenvironment|usepackage|RequirePackage|documentclass|jobname|directlua|lstinputlisting|verbatiminput|endinput)([^A-Za-z]|$)|\^\^`)
+
+// texErrorRe finds the first error in a latex log.
+var texErrorRe = regexp.MustCompile(`(?m)^! (.+)$`)
+
+// texDocument wraps a formula in a minimal LaTeX document, typeset as
+// display math.
+func texDocument(expr string) string {
+	return `\documentclass{article}
+\usepackage{amsmath,amssymb}
+\pagestyle{empty}
+\begin{document}
+\[ ` + expr + ` \]
+\end{document}
+`
+}
+
+// cleanTeX strips the math delimiters people tend to type around a formula
+// and rejects anything a formula has no business doing.
+func cleanTeX(expr string) (string, error) {
+	expr = strings.TrimSpace(expr)
+	for _, d := range [][2]string{{"$$", "$$"}, {`\[`, `\]`}, {`\(`, `\)`}, {"$", "$"}} {
+		if len(expr) > len(d[0])+len(d[1]) && strings.HasPrefix(expr, d[0]) && strings.HasSuffix(expr, d[1]) {
+			expr = strings.TrimSpace(expr[len(d[0]) : len(expr)-len(d[1])])
+			break
+		}
+	}
+	switch {
+	case expr == "":
+		return "", errors.New("give a formula to render, like /bot tex e^{i\\pi} + 1 = 0")
+	case len([]rune(expr)) > texMaxChars:
+		return "", fmt.Errorf("that formula is too long (max %d characters)", texMaxChars)
+	case texForbiddenRe.MatchString(expr):
+		return "", errors.New("that formula uses commands /bot tex doesn't allow")
+	}
+	return expr, nil
+}
+
+// handleTexCommand renders the LaTeX formula after /bot tex, or in the
+// replied-to message, to a PNG and replies with it. latex and dvipng run
+// like exec commands, in a fresh temp directory under c's sandbox, with
+// shell escapes off and file access limited to that directory.
+func handleTexCommand(ctx context.Context, matrixClient *mautrix.Client, ev *event.Event, c *BotCommand) (string, error) {
+	text, err := commandTargetText(ctx, ev, matrixClient)
+	if err != nil {
+		return "", err
+	}
+	expr, err := cleanTeX(text)
+	if err != nil {
+		return err.Error(), nil
+	}
+	latex, err := builtinTool(c, texPrograms[0])
+	if err != nil {
+		return "", err
+	}
+	dvipng, err := builtinTool(c, texPrograms[1])
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := newExecDir()
+	if err != nil {
+		return "", fmt.Errorf("create exec dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "formula.tex"), []byte(texDocument(expr)), 0644); err != nil {
+		return "", fmt.Errorf("write formula: %w", err)
+	}
+
+	// kpathsea's paranoid mode keeps TeX's file access inside the run's
+	// directory.
+	env := []string{"openin_any=p", "openout_any=p", "shell_escape=f"}
+	_, err = runExec(ctx, latex, execRun{
+		args: []string{"-no-shell-escape", "-interaction=nonstopmode", "-halt-on-error", "formula.tex"},
+		env:  env,
+		dir:  dir,
+	})
+	if err != nil {
+		if logData, readErr := os.ReadFile(filepath.Join(dir, "formula.log")); readErr == nil {
+			if m := texErrorRe.FindSubmatch(logData); m != nil {
+				log.Debug().Err(err).Msg("latex failed")
+				return "couldn't render that: " + string(m[1]), nil
+			}
+		}
+		return "", err
+	}
+	_, err = runExec(ctx, dvipng, execRun{
+		args: []string{"-q", "-T", "tight", "-D", fmt.Sprint(texDPI), "-bg", "White", "-o", "formula.png", "formula.dvi"},
+		env:  env,
+		dir:  dir,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "formula.png"))
+	if err != nil {
+		return "", fmt.Errorf("read rendered formula: %w", err)
+	}
+	if err := matrix.CheckQuota(ctx, ev.RoomID, int64(len(data))); err != nil {
+		return err.Error(), nil
+	}
+	if err := matrix.SendImageToMatrix(ctx, matrixClient, ev.RoomID, ev.ID, data, "formula.png", ""); err != nil {
+		return "", err
+	}
+	return "", nil
+}
@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
+)
+
+// threadLimit caps how many replies a thread summary reads.
+const threadLimit = 500
+
+// threadTranscript collects a thread's messages from the messages DB and the
+// relations API (for anything the bot didn't store) and renders them oldest
+// first as "name: text" lines. Bot commands are left out.
+func threadTranscript(ctx context.Context, client *mautrix.Client, messagesDB *sql.DB, roomID id.RoomID, rootID id.EventID) (string, int, error) {
+	seen := make(map[string]bool)
+	var msgs []db.ThreadMessage
+	add := func(m db.ThreadMessage) {
+		if seen[m.ID] || m.Body == "" {
+			return
+		}
+		seen[m.ID] = true
+		msgs = append(msgs, m)
+	}
+	if messagesDB != nil {
+		stored, err := db.ThreadMessages(messagesDB, string(roomID), string(rootID))
+		if err != nil {
+			log.Warn().Err(err).Str("root", string(rootID)).Msg("failed to load stored thread messages")
+		}
+		for _, m := range stored {
+			add(m)
+		}
+	}
+	if client != nil {
+		if !seen[string(rootID)] {
+			if root, err := matrix.FetchAndDecrypt(ctx, client, roomID, rootID); err == nil {
+				if msg := root.Content.AsMessage(); msg != nil {
+					add(db.ThreadMessage{ID: string(root.ID), Sender: string(root.Sender), Body: msg.Body, TSMillis: root.Timestamp})
+				}
+			}
+		}
+		replies, err := matrix.FetchThread(ctx, client, roomID, rootID, threadLimit)
+		if err != nil {
+			// Stored messages may still be enough to go on.
+			log.Warn().Err(err).Str("root", string(rootID)).Msg("failed to fetch thread relations")
+		}
+		for _, ev := range replies {
+			if msg := ev.Content.AsMessage(); msg != nil {
+				add(db.ThreadMessage{ID: string(ev.ID), Sender: string(ev.Sender), Body: msg.Body, TSMillis: ev.Timestamp})
+			}
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].TSMillis < msgs[j].TSMillis })
+
+	var b strings.Builder
+	n := 0
+	for _, m := range msgs {
+		if strings.HasPrefix(m.Body, "/bot ") {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\n", id.UserID(m.Sender).Localpart(), strings.TrimSpace(m.Body))
+		n++
+	}
+	return b.String(), n, nil
+}
+
+// handleThreadSummary runs an ai command with input_type "thread": it
+// summarizes the thread the command was sent in and posts the summary into
+// that thread.
+func handleThreadSummary(ctx context.Context, ev *event.Event, matrixClient *mautrix.Client, c *BotCommand, groqAPIKey, replyLabel string, messagesDB *sql.DB) (string, error) {
+	matrix.ParseEvent(ev)
+	msg := ev.Content.AsMessage()
+	if msg == nil {
+		return "", fmt.Errorf("not a message event")
+	}
+	if !matrix.Caps.Threads {
+		return "this homeserver doesn't support threads", nil
+	}
+	rootID := msg.RelatesTo.GetThreadParent()
+	if rootID == "" {
+		return "use this inside a thread to summarize it", nil
+	}
+	summary, err := ThreadSummary(ctx, matrixClient, messagesDB, c, groqAPIKey, ev.RoomID, rootID)
+	if err != nil {
+		return "", err
+	}
+	if summary == "" {
+		return "this thread is too short to summarize", nil
+	}
+	if matrixClient == nil {
+		return summary, nil
+	}
+	if err := PostThreadSummary(ctx, matrixClient, ev.RoomID, rootID, ev.ID, replyLabel+summary); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// ThreadSummary summarizes a thread with an ai command's prompt and model.
+// It returns "" if the thread is too short to summarize.
+func ThreadSummary(ctx context.Context, matrixClient *mautrix.Client, messagesDB *sql.DB, c *BotCommand, groqAPIKey string, roomID id.RoomID, rootID id.EventID) (string, error) {
+	transcript, n, err := threadTranscript(ctx, matrixClient, messagesDB, roomID, rootID)
+	if err != nil {
+		return "", err
+	}
+	if n < 2 {
+		return "", nil
+	}
+	// Keep the end of long threads (about 6000 tokens); the latest messages
+	// matter most.
+	if maxChars := 6000 * 4; len(transcript) > maxChars {
+		tail := transcript[len(transcript)-maxChars:]
+		transcript = "...\n" + tail[strings.Index(tail, "\n")+1:]
+	}
+	return callGroq(ctx, groqAPIKey, c.Model, c.MaxTokens, c.Prompt+"\n\n"+transcript)
+}
+
+// PostThreadSummary posts body into the thread rootID, falling back to a
+// reply to replyTo in clients without threads.
+func PostThreadSummary(ctx context.Context, matrixClient *mautrix.Client, roomID id.RoomID, rootID, replyTo id.EventID, body string) error {
+	content := matrix.ReplyContent("", body, "")
+	content.RelatesTo = (&event.RelatesTo{}).SetThread(rootID, replyTo)
+	if _, err := matrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, &content); err != nil {
+		return fmt.Errorf("send thread summary: %w", err)
+	}
+	return nil
+}
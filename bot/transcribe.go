@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/matrix"
+	"github.com/polarhive/ash/util"
+)
+
+const (
+	// transcribeMaxBytes bounds the audio transcribed, which is also what
+	// the Groq and OpenAI APIs accept.
+	transcribeMaxBytes = 25 << 20
+	// defaultTranscriptionURL and defaultTranscriptionModel are Groq's
+	// Whisper API.
+	defaultTranscriptionURL   = "https://api.groq.com/openai/v1"
+	defaultTranscriptionModel = "whisper-large-v3-turbo"
+	// defaultWhisperCommand is whisper.cpp's command-line program.
+	defaultWhisperCommand = "whisper-cli"
+)
+
+// Transcription is the backend voice messages are transcribed with, with
+// its apiKey defaulting to GROQ_API_KEY. Set from config TRANSCRIPTION; nil
+// turns transcription off.
+var Transcription *config.TranscriptionConfig
+
+// transcribePrograms returns the programs transcription runs as exec
+// commands: with whisper.cpp, ffmpeg to convert the audio to the 16 kHz WAV
+// it reads, and whisper.cpp itself.
+func transcribePrograms() []string {
+	if Transcription == nil || Transcription.Backend != "whisper.cpp" {
+		return nil
+	}
+	command := Transcription.Command
+	if command == "" {
+		command = defaultWhisperCommand
+	}
+	return []string{"ffmpeg", command}
+}
+
+// IsVoiceMessage reports whether msg is an MSC3245 voice message.
+func IsVoiceMessage(msg *event.MessageEventContent) bool {
+	return msg != nil && msg.MsgType == event.MsgAudio && msg.MSC3245Voice != nil
+}
+
+// Transcribe downloads the audio of msg and returns what's said in it, using
+// Transcription's backend. whisper.cpp and ffmpeg run like exec commands
+// under sandbox.
+func Transcribe(ctx context.Context, matrixClient *mautrix.Client, msg *event.MessageEventContent, sandbox *ExecSandbox) (string, error) {
+	if Transcription == nil {
+		return "", fmt.Errorf("TRANSCRIPTION is not set")
+	}
+	if msg.Info != nil && msg.Info.Size > transcribeMaxBytes {
+		return "", fmt.Errorf("audio too large to transcribe: %d bytes", msg.Info.Size)
+	}
+	mediaURL, file, err := matrix.MediaFromMessage(msg)
+	if err != nil {
+		return "", err
+	}
+	data, err := matrix.DownloadMedia(ctx, matrixClient, mediaURL, file)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > transcribeMaxBytes {
+		return "", fmt.Errorf("audio too large to transcribe: %d bytes", len(data))
+	}
+	var text string
+	if Transcription.Backend == "whisper.cpp" {
+		text, err = transcribeWhisperCPP(ctx, data, sandbox)
+	} else {
+		text, err = transcribeAPI(ctx, data)
+	}
+	return strings.Join(strings.Fields(text), " "), err
+}
+
+// transcribeAPI sends audio to an OpenAI-compatible transcription API.
+func transcribeAPI(ctx context.Context, data []byte) (string, error) {
+	cfg := openai.DefaultConfig(Transcription.APIKey)
+	cfg.BaseURL = defaultTranscriptionURL
+	if Transcription.URL != "" {
+		cfg.BaseURL = strings.TrimSuffix(Transcription.URL, "/")
+	}
+	cfg.HTTPClient = util.HTTPClient
+	model := Transcription.Model
+	if model == "" {
+		model = defaultTranscriptionModel
+	}
+	_, ext := matrix.SniffMediaType(data)
+	resp, err := openai.NewClientWithConfig(cfg).CreateTranscription(ctx, openai.AudioRequest{
+		Model:    model,
+		FilePath: "voice" + ext,
+		Reader:   bytes.NewReader(data),
+		Language: Transcription.Language,
+		Format:   openai.AudioResponseFormatJSON,
+	})
+	if err != nil {
+		return "", fmt.Errorf("transcription api: %w", err)
+	}
+	return resp.Text, nil
+}
+
+// transcribeWhisperCPP converts audio to 16 kHz mono WAV with ffmpeg and
+// transcribes it with whisper.cpp, in a fresh temp directory.
+func transcribeWhisperCPP(ctx context.Context, data []byte, sandbox *ExecSandbox) (string, error) {
+	dir, err := newExecDir()
+	if err != nil {
+		return "", fmt.Errorf("create exec dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "voice.audio")
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		return "", fmt.Errorf("write audio: %w", err)
+	}
+	programs := transcribePrograms()
+	c := &BotCommand{Sandbox: sandbox}
+	ffmpeg, err := builtinTool(c, programs[0])
+	if err != nil {
+		return "", err
+	}
+	wav := filepath.Join(dir, "voice.wav")
+	if _, err := runExec(ctx, ffmpeg, execRun{args: []string{"-v", "error", "-i", input, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", wav}, dir: dir}); err != nil {
+		return "", err
+	}
+	whisper, err := builtinTool(c, programs[1])
+	if err != nil {
+		return "", err
+	}
+	lang := Transcription.Language
+	if lang == "" {
+		lang = "auto"
+	}
+	out, err := runExec(ctx, whisper, execRun{args: []string{"-m", Transcription.Model, "-f", wav, "-l", lang, "-nt", "-np"}, dir: dir})
+	if err != nil {
+		return "", err
+	}
+	// whisper.cpp marks silence rather than leaving it out.
+	return strings.ReplaceAll(string(out), "[BLANK_AUDIO]", ""), nil
+}
+
+// handleTranscribeCommand replies with the transcript of the voice message,
+// or other audio, that /bot transcribe replies to.
+func handleTranscribeCommand(ctx context.Context, matrixClient *mautrix.Client, ev *event.Event, c *BotCommand) (string, error) {
+	if Transcription == nil {
+		return "transcription isn't set up; set TRANSCRIPTION in config.json", nil
+	}
+	matrix.ParseEvent(ev)
+	msg := ev.Content.AsMessage()
+	if msg == nil {
+		return "", fmt.Errorf("not a message event")
+	}
+	var audio *event.MessageEventContent
+	if msg.RelatesTo != nil && msg.RelatesTo.InReplyTo != nil {
+		if original, err := matrix.FetchAndDecrypt(ctx, matrixClient, ev.RoomID, msg.RelatesTo.InReplyTo.EventID); err == nil {
+			if om := original.Content.AsMessage(); om != nil && om.MsgType == event.MsgAudio {
+				audio = om
+			}
+		}
+	}
+	if audio == nil {
+		return "reply to a voice message to transcribe it", nil
+	}
+	text, err := Transcribe(ctx, matrixClient, audio, c.Sandbox)
+	if err != nil {
+		return "", err
+	}
+	if text == "" {
+		return "couldn't make out any words", nil
+	}
+	return "🎤 " + text, nil
+}
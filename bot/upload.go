@@ -0,0 +1,145 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/links"
+	"github.com/polarhive/ash/matrix"
+	"github.com/polarhive/ash/util"
+)
+
+// uploadMaxNameLen bounds the file names /bot upload posts.
+const uploadMaxNameLen = 200
+
+// uploadFileName picks the name of a file downloaded from u: the one in the
+// Content-Disposition header, or else the last part of the URL's path.
+// Path separators and control characters are dropped.
+func uploadFileName(disposition string, u *url.URL) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(disposition); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = path.Base(u.Path)
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		name = "download"
+	}
+	if r := []rune(name); len(r) > uploadMaxNameLen {
+		name = string(r[:uploadMaxNameLen])
+	}
+	return name
+}
+
+// uploadContentType returns the MIME type of a downloaded file: the one the
+// server declared, or else what its contents look like.
+func uploadContentType(declared string, data []byte) string {
+	if t, _, err := mime.ParseMediaType(declared); err == nil && t != "application/octet-stream" {
+		return t
+	}
+	t, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return t
+}
+
+// typeAllowed reports whether contentType matches one of allowed, which
+// are MIME types or patterns like "image/*". An empty list allows any type.
+func typeAllowed(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok {
+			if strings.HasPrefix(contentType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(a, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleUploadCommand downloads the file at the URL after /bot upload and
+// posts it as an m.file with its name and size, encrypted in encrypted
+// rooms. Only public addresses are fetched, and files larger than
+// max_size_mb (default and at most MAX_UPLOAD_MB) or of a type not in
+// allowed_types are refused.
+func handleUploadCommand(ctx context.Context, matrixClient *mautrix.Client, ev *event.Event, c *BotCommand) (string, error) {
+	matrix.ParseEvent(ev)
+	msg := ev.Content.AsMessage()
+	if msg == nil {
+		return "", fmt.Errorf("not a message event")
+	}
+	found := links.ExtractLinks(commandArgs(msg.Body))
+	if len(found) == 0 {
+		return "give a link to the file, like /bot upload https://example.com/file.pdf", nil
+	}
+	u, err := url.Parse(found[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "only http and https links can be uploaded", nil
+	}
+	maxSize := matrix.MaxUploadBytes
+	if c.MaxSizeMB > 0 && (maxSize <= 0 || int64(c.MaxSizeMB)<<20 < maxSize) {
+		maxSize = int64(c.MaxSizeMB) << 20
+	}
+	tooLarge := fmt.Sprintf("that file is larger than %.0f MB", float64(maxSize)/(1<<20))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := util.PublicHTTPClient.Do(req)
+	if errors.Is(err, util.ErrPrivateAddress) {
+		return "that link points to a private address", nil
+	}
+	if err != nil {
+		return fmt.Sprintf("couldn't download that: %v", errors.Unwrap(err)), nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("couldn't download that: %s", resp.Status), nil
+	}
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		return tooLarge, nil
+	}
+	var body io.Reader = resp.Body
+	if maxSize > 0 {
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("download file: %w", err)
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return tooLarge, nil
+	}
+	contentType := uploadContentType(resp.Header.Get("Content-Type"), data)
+	if !typeAllowed(contentType, c.AllowedTypes) {
+		return fmt.Sprintf("%s files can't be uploaded here", contentType), nil
+	}
+	name := uploadFileName(resp.Header.Get("Content-Disposition"), resp.Request.URL)
+	err = matrix.SendAttachment(ctx, matrixClient, ev.RoomID, ev.ID, data, contentType, name)
+	if errors.Is(err, matrix.ErrFileTooLarge) || errors.Is(err, matrix.ErrQuotaExceeded) {
+		return err.Error(), nil
+	}
+	return "", err
+}
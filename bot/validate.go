@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// fileOutputTypes are the output types an exec command writes to {output}.
+var fileOutputTypes = []string{"image", "audio", "video", "file", "media"}
+
+// languageRe matches a code block language name, like "json" or "c++".
+var languageRe = regexp.MustCompile(`^[A-Za-z0-9_.+#-]+$`)
+
+// Validate checks bot.json commands for missing or invalid fields, returning
+// one error per problem.
+func (bc *BotConfig) Validate() []error {
+	var errs []error
+	if len(bc.Commands) == 0 {
+		return []error{fmt.Errorf("no commands defined")}
+	}
+	for name, c := range bc.Commands {
+		errs = append(errs, validateCommand(name, c)...)
+	}
+	return errs
+}
+
+func validateCommand(name string, c BotCommand) []error {
+	if c.Response != "" {
+		return nil
+	}
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("command %s: "+format, append([]any{name}, args...)...))
+	}
+	switch c.Type {
+	case "http":
+		if c.URL == "" {
+			fail("http type requires url")
+		}
+		switch c.Method {
+		case "", "GET", "POST", "PUT", "DELETE", "PATCH":
+		default:
+			fail("invalid method %q", c.Method)
+		}
+		if c.OutputType == "image" && c.JSONPath == "" {
+			fail("image output_type requires json_path to specify image URL field")
+		}
+		if c.CacheSeconds < 0 {
+			fail("cache_seconds must not be negative")
+		}
+	case "exec":
+		if c.Command == "" {
+			fail("exec type requires command")
+		}
+		var hasInput, hasOutput bool
+		for _, arg := range c.Args {
+			hasInput = hasInput || arg == "{input}"
+			hasOutput = hasOutput || arg == "{output}"
+		}
+		if c.InputType == "image" && !hasInput {
+			fail("input_type image requires {input} placeholder in args")
+		}
+		if slices.Contains(fileOutputTypes, c.OutputType) && !hasOutput {
+			fail("output_type %s requires {output} placeholder in args", c.OutputType)
+		}
+		for k := range c.Env {
+			if k == "" || strings.ContainsAny(k, "= ") {
+				fail("invalid env name %q", k)
+			}
+		}
+		switch c.TextOutput {
+		case "", "caption", "reply":
+		default:
+			fail("invalid text_output %q, must be caption or reply", c.TextOutput)
+		}
+		if c.MaxConcurrent < 0 {
+			fail("max_concurrent must not be negative")
+		}
+		for _, k := range c.InheritEnv {
+			if k == "" || strings.ContainsAny(k, "= ") {
+				fail("invalid inherit_env name %q", k)
+			}
+		}
+	case "ai":
+		if c.Prompt == "" {
+			fail("ai type requires prompt")
+		}
+		if c.Model == "" {
+			fail("ai type requires model")
+		}
+		if c.MaxTokens <= 0 {
+			fail("ai type requires max_tokens > 0")
+		}
+	case "builtin":
+		if c.Command == "" {
+			fail("builtin type requires command")
+		}
+		if c.MaxSizeMB < 0 {
+			fail("max_size_mb must not be negative")
+		}
+	case "download":
+		if c.MaxDurationSeconds < 0 || c.MaxSizeMB < 0 {
+			fail("max_duration_seconds and max_size_mb must not be negative")
+		}
+		if c.MaxConcurrent < 0 {
+			fail("max_concurrent must not be negative")
+		}
+	case "":
+		fail("type is required")
+	default:
+		fail("invalid type %q, must be one of: http, exec, ai, builtin, download", c.Type)
+	}
+	if s := c.Sandbox; s != nil {
+		if s.TimeoutSeconds < 0 || s.MaxOutputBytes < 0 || s.MemoryMB < 0 || s.CPUSeconds < 0 {
+			fail("sandbox limits must not be negative")
+		}
+		if !resourceLimits && (s.MemoryMB > 0 || s.CPUSeconds > 0) {
+			fail("sandbox memory_mb and cpu_seconds aren't supported on %s; use a wrapper to limit resources", runtime.GOOS)
+		}
+		if len(s.Wrapper) > 0 && s.Wrapper[0] == "" {
+			fail("sandbox wrapper needs a program")
+		}
+	}
+	switch c.InputType {
+	case "", "none", "text", "image":
+	case "thread":
+		if c.Type != "ai" {
+			fail("input_type thread is only supported for ai commands")
+		}
+	default:
+		fail("invalid input_type %q", c.InputType)
+	}
+	switch c.OutputType {
+	case "", "text", "image", "audio", "video", "file", "media", "reaction":
+	default:
+		fail("invalid output_type %q", c.OutputType)
+	}
+	if (c.OutputType == "video" || c.OutputType == "media") && c.Type != "exec" {
+		fail("output_type %s is only supported for exec commands", c.OutputType)
+	}
+	if c.Reaction != "" && c.OutputType != "reaction" {
+		fail("reaction requires output_type reaction")
+	}
+	switch c.Format {
+	case "":
+	case "code":
+		if c.Type != "exec" && c.Type != "http" {
+			fail("format code is only supported for exec and http commands")
+		}
+	default:
+		fail("invalid format %q, must be code", c.Format)
+	}
+	if c.Spoiler && c.OutputType != "" && c.OutputType != "text" && c.OutputType != "reaction" {
+		fail("spoiler only applies to text output")
+	}
+	if c.Language != "" {
+		if c.Format != "code" {
+			fail("language requires format code")
+		}
+		if !languageRe.MatchString(c.Language) {
+			fail("invalid language %q", c.Language)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,287 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// YapWordFilter selects what is left out of yap leaderboard word counts.
+type YapWordFilter struct {
+	URLs       bool // links
+	CodeBlocks bool // ``` fenced ``` and `inline` code
+	Quotes     bool // "> " lines, such as reply fallbacks
+	Emoji      bool
+}
+
+// YapFilter is applied when counting words for the yap leaderboard. Set via
+// config.json "YAP_EXCLUDE".
+var YapFilter YapWordFilter
+
+// NewYapWordFilter builds a filter from YAP_EXCLUDE names: "urls", "code",
+// "quotes" and "emoji". Unknown names are ignored; config validation
+// reports them.
+func NewYapWordFilter(names []string) YapWordFilter {
+	var f YapWordFilter
+	for _, n := range names {
+		switch strings.ToLower(n) {
+		case "urls":
+			f.URLs = true
+		case "code":
+			f.CodeBlocks = true
+		case "quotes":
+			f.Quotes = true
+		case "emoji":
+			f.Emoji = true
+		}
+	}
+	return f
+}
+
+var (
+	yapURLRe   = regexp.MustCompile(`(?i)https?://\S+`)
+	yapFenceRe = regexp.MustCompile("(?s)```.*?(```|$)")
+	yapCodeRe  = regexp.MustCompile("`[^`\n]*`")
+)
+
+// CountWords counts the words in a message body. Without filters it counts
+// spaces plus one, as the leaderboard always has; with filters the excluded
+// parts are removed and whitespace-separated words are counted.
+func (f YapWordFilter) CountWords(body string) int {
+	if f == (YapWordFilter{}) {
+		return strings.Count(body, " ") + 1
+	}
+	if f.Quotes {
+		var kept []string
+		for _, line := range strings.Split(body, "\n") {
+			if !strings.HasPrefix(line, ">") {
+				kept = append(kept, line)
+			}
+		}
+		body = strings.Join(kept, "\n")
+	}
+	if f.CodeBlocks {
+		body = yapFenceRe.ReplaceAllString(body, " ")
+		body = yapCodeRe.ReplaceAllString(body, " ")
+	}
+	if f.URLs {
+		body = yapURLRe.ReplaceAllString(body, " ")
+	}
+	if f.Emoji {
+		body = strings.Map(func(r rune) rune {
+			if isEmojiRune(r) {
+				return ' '
+			}
+			return r
+		}, body)
+	}
+	return len(strings.Fields(body))
+}
+
+// isEmojiRune reports whether r is an emoji or an emoji modifier.
+func isEmojiRune(r rune) bool {
+	switch {
+	case r == 0x200D, r == 0xFE0F, r >= 0x1F3FB && r <= 0x1F3FF:
+		return true // joiner, variation selector, skin tones
+	case r >= 0x1F000 && r <= 0x1FAFF, r >= 0x2600 && r <= 0x27BF:
+		return true
+	}
+	return unicode.Is(unicode.So, r)
+}
+
+// yapCount is one sender's word count.
+type yapCount struct {
+	sender  string
+	words   int
+	reached int64 // ts_ms of the message that brought words to its total
+}
+
+// yapWordCounts returns word counts per sender in a room since cutoff, most
+// words first. Ties go to whoever reached the count first, then by user ID,
+// so the order is stable between calls. Commands and the bot's own labelled
+// replies don't count.
+func yapWordCounts(ctx context.Context, db *sql.DB, roomID string, cutoff int64, botID string) ([]yapCount, error) {
+	return yapWordCountsBetween(ctx, db, roomID, cutoff, math.MaxInt64, botID)
+}
+
+// yapWordCountsBetween is yapWordCounts for messages from start up to (not
+// including) end.
+func yapWordCountsBetween(ctx context.Context, db *sql.DB, roomID string, start, end int64, botID string) ([]yapCount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT sender, body, ts_ms
+		FROM messages
+		WHERE room_id = ?
+		  AND ts_ms >= ?
+		  AND ts_ms < ?
+		  AND body NOT LIKE '/bot %'
+		  AND (body NOT LIKE '[BOT] %' OR sender != ?)
+		  AND msgtype = 'm.text'
+		ORDER BY ts_ms
+	`, roomID, start, end, botID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	index := make(map[string]int)
+	var counts []yapCount
+	for rows.Next() {
+		var sender, body string
+		var ts int64
+		if err := rows.Scan(&sender, &body, &ts); err != nil {
+			continue
+		}
+		i, ok := index[sender]
+		if !ok {
+			i = len(counts)
+			index[sender] = i
+			counts = append(counts, yapCount{sender: sender})
+		}
+		if n := YapFilter.CountWords(body); n > 0 {
+			counts[i].words += n
+			counts[i].reached = ts
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.words != b.words {
+			return a.words > b.words
+		}
+		if a.reached != b.reached {
+			return a.reached < b.reached
+		}
+		return a.sender < b.sender
+	})
+	return counts, nil
+}
+
+// yapPageSize is how many yappers "/bot yap page N" shows per page.
+const yapPageSize = 10
+
+// yapAroundRadius is how many places above and below the requester
+// "/bot yap me" shows.
+const yapAroundRadius = 3
+
+// yapView is the slice of the leaderboard a "/bot yap" request asks for.
+type yapView struct {
+	title string
+	start int // index of the first entry shown
+	end   int // index after the last entry shown
+	// markSelf points out the requester's line.
+	markSelf bool
+	// empty, if set, is sent instead of a leaderboard.
+	empty string
+}
+
+// yapViewFor picks the leaderboard window for args over n ranked entries:
+// "N" for the top N (max 50), "page N" for pages of yapPageSize, and "me"
+// or "around me" for the places around sender at index self (-1 if absent).
+func yapViewFor(args string, n, self int) yapView {
+	fields := strings.Fields(strings.ToLower(args))
+	switch {
+	case len(fields) > 0 && fields[0] == "page":
+		page := 1
+		if len(fields) > 1 {
+			if p, err := strconv.Atoi(fields[1]); err == nil && p > 0 {
+				page = p
+			}
+		}
+		pages := (n + yapPageSize - 1) / yapPageSize
+		v := yapView{title: fmt.Sprintf("yappers (today), page %d of %d:", page, pages)}
+		v.start = min((page-1)*yapPageSize, n)
+		v.end = min(v.start+yapPageSize, n)
+		if v.start == v.end && n > 0 {
+			v.empty = fmt.Sprintf("there are only %d page(s) today", pages)
+		}
+		return v
+	case strings.Join(fields, " ") == "me" || strings.Join(fields, " ") == "around me":
+		if self < 0 {
+			return yapView{empty: "you have no messages today!"}
+		}
+		return yapView{
+			title:    "yappers around you (today):",
+			start:    max(self-yapAroundRadius, 0),
+			end:      min(self+yapAroundRadius+1, n),
+			markSelf: true,
+		}
+	}
+	limit := 5
+	if args != "" {
+		if l, err := strconv.Atoi(strings.TrimSpace(args)); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	return yapView{title: "top yappers (today):", end: min(limit, 50, n)}
+}
+
+// yesterdayRanks returns the room's final leaderboard ranks for the day
+// before now (in YapTimezone), keyed by sender. They are computed from the
+// messages table the first time they're asked for and kept in yap_history.
+func yesterdayRanks(ctx context.Context, db *sql.DB, roomID, botID string, now time.Time) (map[string]int, error) {
+	now = now.In(YapTimezone)
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, YapTimezone)
+	start := end.AddDate(0, 0, -1)
+	day := start.Format("2006-01-02")
+
+	ranks := make(map[string]int)
+	rows, err := db.QueryContext(ctx, `SELECT sender, rank FROM yap_history WHERE room_id = ? AND day = ?`, roomID, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sender string
+		var rank int
+		if err := rows.Scan(&sender, &rank); err != nil {
+			return nil, err
+		}
+		ranks[sender] = rank
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) > 0 {
+		return ranks, nil
+	}
+
+	counts, err := yapWordCountsBetween(ctx, db, roomID, start.UnixMilli(), end.UnixMilli(), botID)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for i, c := range counts {
+		if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO yap_history(room_id, day, sender, rank, words) VALUES(?, ?, ?, ?, ?)`,
+			roomID, day, c.sender, i+1, c.words); err != nil {
+			return nil, err
+		}
+		ranks[c.sender] = i + 1
+	}
+	return ranks, tx.Commit()
+}
+
+// yapMovement describes the change from yesterday's rank prev (0 if the
+// sender wasn't on the board) to rank: "▲2", "▼1", "new", or "" if unchanged.
+func yapMovement(prev, rank int) string {
+	switch {
+	case prev == 0:
+		return "new"
+	case prev > rank:
+		return fmt.Sprintf("\u25b2%d", prev-rank)
+	case prev < rank:
+		return fmt.Sprintf("\u25bc%d", rank-prev)
+	}
+	return ""
+}
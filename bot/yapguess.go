@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
+)
+
+// YapGuessGame is the game name /bot yap guess scores are stored under.
+const YapGuessGame = "yap_guess"
+
+// YapGuessRules are the rewards and limits of the /bot yap guess game.
+type YapGuessRules struct {
+	ExactPoints int
+	ClosePoints int // for a guess one place off
+	MaxPerDay   int
+}
+
+// YapGuess holds the active rules. Set via config.json "YAP_GUESS".
+var YapGuess = NewYapGuessRules(nil)
+
+// NewYapGuessRules fills in defaults for unset YAP_GUESS fields.
+func NewYapGuessRules(c *config.YapGuessConfig) YapGuessRules {
+	r := YapGuessRules{ExactPoints: 3, ClosePoints: 1, MaxPerDay: 3}
+	if c == nil {
+		return r
+	}
+	if c.ExactPoints > 0 {
+		r.ExactPoints = c.ExactPoints
+	}
+	if c.ClosePoints > 0 {
+		r.ClosePoints = c.ClosePoints
+	} else if c.ClosePoints < 0 {
+		r.ClosePoints = 0
+	}
+	if c.MaxPerDay > 0 {
+		r.MaxPerDay = c.MaxPerDay
+	}
+	return r
+}
+
+// Points returns what a guess of position guess earns when the guesser is
+// actually at position actual.
+func (r YapGuessRules) Points(guess, actual int) int {
+	switch guess - actual {
+	case 0:
+		return r.ExactPoints
+	case -1, 1:
+		return r.ClosePoints
+	}
+	return 0
+}
+
+// StartOfWeek returns Monday 00:00 of the week containing t, in YapTimezone.
+func StartOfWeek(t time.Time) time.Time {
+	t = t.In(YapTimezone)
+	offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, YapTimezone)
+}
+
+// yapGuessTurn records a guess. It returns a message for the user if they
+// are out of guesses for today, or "" if the guess may go ahead.
+func yapGuessTurn(database *sql.DB, roomID, userID string, now time.Time) (string, error) {
+	day := now.In(YapTimezone).Format("2006-01-02")
+	n, err := db.AddGameAttempt(database, roomID, userID, YapGuessGame, day)
+	if err != nil {
+		return "", fmt.Errorf("record guess: %w", err)
+	}
+	if n > YapGuess.MaxPerDay {
+		return fmt.Sprintf("you've used all %d of today's guesses, try again tomorrow", YapGuess.MaxPerDay), nil
+	}
+	return "", nil
+}
+
+// awardYapGuess gives the points for a guess, at most once per user per
+// day, and describes the result for the reply ("" for no points).
+func awardYapGuess(database *sql.DB, roomID, userID string, guess, actual int, now time.Time) (string, error) {
+	points := YapGuess.Points(guess, actual)
+	if points == 0 {
+		return "", nil
+	}
+	local := now.In(YapTimezone)
+	dayStart := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, YapTimezone)
+	today, err := db.GameScores(database, roomID, YapGuessGame, dayStart.UnixMilli(), now.UnixMilli()+1)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range today {
+		if s.UserID == userID {
+			return " (you've already scored today)", nil
+		}
+	}
+	if err := db.AddGamePoints(database, roomID, userID, YapGuessGame, points, now.UnixMilli()); err != nil {
+		return "", fmt.Errorf("award points: %w", err)
+	}
+	week, err := db.GameScores(database, roomID, YapGuessGame, StartOfWeek(now).UnixMilli(), now.UnixMilli()+1)
+	if err != nil {
+		return "", err
+	}
+	total := points
+	for _, s := range week {
+		if s.UserID == userID {
+			total = s.Points
+		}
+	}
+	return fmt.Sprintf(" +%d point(s), %d this week", points, total), nil
+}
+
+// YapGuessStandings formats a room's guess game points between from and to,
+// resolving display names through client when it is non-nil.
+func YapGuessStandings(ctx context.Context, database *sql.DB, client *mautrix.Client, roomID id.RoomID, title string, from, to time.Time) (string, error) {
+	scores, err := db.GameScores(database, string(roomID), YapGuessGame, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return "", fmt.Errorf("query guess scores: %w", err)
+	}
+	if len(scores) == 0 {
+		return "", nil
+	}
+	names := matrix.RoomDisplayNames(ctx, client, roomID)
+	var b strings.Builder
+	b.WriteString(title + "\n")
+	for i, s := range scores {
+		if i == 10 {
+			break
+		}
+		display := names[s.UserID]
+		if display == "" {
+			display = id.UserID(s.UserID).Localpart()
+		}
+		fmt.Fprintf(&b, "%d. %s — %d point(s)\n", i+1, display, s.Points)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
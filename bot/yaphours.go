@@ -0,0 +1,182 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/matrix"
+)
+
+// RoomTimezones holds per-room timezones for /bot yap hours, keyed by room
+// ID. Set via the "timezone" of MATRIX_ROOM_ID entries; other rooms use
+// YapTimezone.
+var RoomTimezones = map[string]*time.Location{}
+
+// roomTimezone returns the timezone messages in roomID are bucketed in.
+func roomTimezone(roomID string) *time.Location {
+	if loc, ok := RoomTimezones[roomID]; ok {
+		return loc
+	}
+	return YapTimezone
+}
+
+// yapHourUser is one sender's messages per local hour of the day.
+type yapHourUser struct {
+	sender   string
+	messages int
+	hours    [24]int
+}
+
+// peak returns the hour the user posts most in, the earliest on ties.
+func (u yapHourUser) peak() int {
+	best := 0
+	for h, n := range u.hours {
+		if n > u.hours[best] {
+			best = h
+		}
+	}
+	return best
+}
+
+// yapHourCounts buckets a room's messages since cutoff by hour in loc,
+// returning the room's distribution and the senders, most messages first.
+// Commands and the bot's labelled replies are left out, as for /bot yap.
+func yapHourCounts(ctx context.Context, db *sql.DB, roomID string, cutoff int64, botID string, loc *time.Location) ([24]int, []yapHourUser, error) {
+	var room [24]int
+	rows, err := db.QueryContext(ctx, `
+		SELECT sender, ts_ms
+		FROM messages
+		WHERE room_id = ?
+		  AND ts_ms >= ?
+		  AND body NOT LIKE '/bot %'
+		  AND (body NOT LIKE '[BOT] %' OR sender != ?)
+		  AND msgtype = 'm.text'
+	`, roomID, cutoff, botID)
+	if err != nil {
+		return room, nil, err
+	}
+	defer rows.Close()
+	index := make(map[string]int)
+	var users []yapHourUser
+	for rows.Next() {
+		var sender string
+		var ts int64
+		if err := rows.Scan(&sender, &ts); err != nil {
+			return room, nil, err
+		}
+		h := time.UnixMilli(ts).In(loc).Hour()
+		room[h]++
+		i, ok := index[sender]
+		if !ok {
+			i = len(users)
+			index[sender] = i
+			users = append(users, yapHourUser{sender: sender})
+		}
+		users[i].messages++
+		users[i].hours[h]++
+	}
+	if err := rows.Err(); err != nil {
+		return room, nil, err
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].messages != users[j].messages {
+			return users[i].messages > users[j].messages
+		}
+		return users[i].sender < users[j].sender
+	})
+	return room, users, nil
+}
+
+// hourSparkline draws the 24 hourly counts as block characters.
+func hourSparkline(counts [24]int) string {
+	const bars = "▁▂▃▄▅▆▇█"
+	blocks := []rune(bars)
+	top := 0
+	for _, n := range counts {
+		top = max(top, n)
+	}
+	var b strings.Builder
+	for _, n := range counts {
+		if top == 0 {
+			b.WriteRune(blocks[0])
+			continue
+		}
+		b.WriteRune(blocks[n*(len(blocks)-1)/top])
+	}
+	return b.String()
+}
+
+// hourTag marks night owls (peak between midnight and 5) and early birds
+// (peak between 5 and 9).
+func hourTag(h int) string {
+	switch {
+	case h < 5:
+		return " 🦉"
+	case h < 9:
+		return " 🐦"
+	}
+	return ""
+}
+
+// queryYapHours handles "/bot yap hours [days]": the room's messages per
+// hour of the day over the last days (default 30, max 365) and each top
+// yapper's most active hour, in the room's timezone.
+func queryYapHours(ctx context.Context, db *sql.DB, matrixClient *mautrix.Client, ev *event.Event, args string) (string, error) {
+	days := 30
+	if args != "" {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(args), "d"))
+		if err != nil || n <= 0 {
+			return "usage: /bot yap hours [days]", nil
+		}
+		days = min(n, 365)
+	}
+	botID := ""
+	if matrixClient != nil {
+		botID = string(matrixClient.UserID)
+	}
+	loc := roomTimezone(string(ev.RoomID))
+	cutoff := time.Now().AddDate(0, 0, -days).UnixMilli()
+	room, users, err := yapHourCounts(ctx, db, string(ev.RoomID), cutoff, botID, loc)
+	if err != nil {
+		return "", fmt.Errorf("query yap hours: %w", err)
+	}
+	if len(users) == 0 {
+		return fmt.Sprintf("no messages in the past %d days", days), nil
+	}
+
+	displayNames := matrix.RoomDisplayNames(ctx, matrixClient, ev.RoomID)
+
+	total, busiest := 0, 0
+	for h, n := range room {
+		total += n
+		if n > room[busiest] {
+			busiest = h
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "yap hours (past %d days, %s):\n", days, loc)
+	b.WriteString(hourSparkline(room) + "\n")
+	b.WriteString("0     6     12    18   23\n")
+	fmt.Fprintf(&b, "busiest hour: %02d:00–%02d:00 (%d%% of messages)\n", busiest, (busiest+1)%24, room[busiest]*100/total)
+	for i, u := range users {
+		if i == 5 {
+			break
+		}
+		display, ok := displayNames[u.sender]
+		if !ok {
+			display = id.UserID(u.sender).Localpart()
+		}
+		h := u.peak()
+		fmt.Fprintf(&b, "%d. %s — mostly %02d:00%s (%d messages)\n", i+1, display, h, hourTag(h), u.messages)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
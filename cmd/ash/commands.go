@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash"
+	"github.com/polarhive/ash/app"
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/locale"
+	"github.com/polarhive/ash/matrix"
+	"github.com/polarhive/ash/version"
+)
+
+// login handles `ash login`.
+func login(ctx context.Context, cfg *config.Config, _ []string) error {
+	userID, deviceID, err := ash.New(cfg).Login(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("user", string(userID)).Str("device", string(deviceID)).Msg("logged in")
+	return nil
+}
+
+// export handles `ash export [--out path]`.
+func export(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("out", cfg.LinksPath, "output JSON file")
+	_ = fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("--out is required when LINKS_JSON_PATH is unset")
+	}
+	messagesDB, err := db.OpenMessages(ctx, cfg.DBPath)
+	if err != nil {
+		return fmt.Errorf("open messages db: %w", err)
+	}
+	defer messagesDB.Close()
+	if err := app.ExportLinks(messagesDB, cfg, *out); err != nil {
+		return err
+	}
+	log.Info().Str("path", *out).Msg("exported links")
+	return nil
+}
+
+// migrate handles `ash migrate`. Opening a database applies its schema and
+// any pending column migrations.
+func migrate(ctx context.Context, cfg *config.Config, _ []string) error {
+	metaDB, err := db.OpenMeta(ctx, cfg.MetaDBPath)
+	if err != nil {
+		return fmt.Errorf("migrate meta db: %w", err)
+	}
+	metaDB.Close()
+	messagesDB, err := db.OpenMessages(ctx, cfg.DBPath)
+	if err != nil {
+		return fmt.Errorf("migrate messages db: %w", err)
+	}
+	messagesDB.Close()
+	log.Info().Str("meta", cfg.MetaDBPath).Str("messages", cfg.DBPath).Msg("databases migrated")
+	return nil
+}
+
+// printVersion handles `ash version`.
+func printVersion(_ context.Context, _ *config.Config, _ []string) error {
+	fmt.Println(version.Get())
+	return nil
+}
+
+// validate handles `ash validate`.
+func validate(_ context.Context, cfg *config.Config, _ []string) error {
+	errs := cfg.Validate()
+	botCfgPath := cfg.BotConfigPath
+	if botCfgPath == "" {
+		botCfgPath = "./bot.json"
+	}
+	botCfg, err := bot.LoadBotConfig(botCfgPath)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, botCfg.Validate()...)
+		bot.ExecAllowlist = cfg.ExecAllowlist
+		errs = append(errs, botCfg.CheckExecBinaries()...)
+	}
+	var catalog locale.Catalog
+	if cfg.MessagesPath != "" {
+		if catalog, err = locale.Load(cfg.MessagesPath); err != nil {
+			errs = append(errs, fmt.Errorf("MESSAGES_PATH: %w", err))
+		}
+	}
+	errs = append(errs, catalog.Check()...)
+	langs := make([]string, 0, len(cfg.RoomIDs)+1)
+	for _, r := range cfg.RoomIDs {
+		langs = append(langs, r.Language)
+	}
+	if cfg.RoomDefaults != nil {
+		langs = append(langs, cfg.RoomDefaults.Language)
+	}
+	errs = append(errs, catalog.CheckLanguages(langs...)...)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(errs))
+	}
+	log.Info().Msg("config.json and bot.json look good")
+	return nil
+}
+
+// replay handles `ash replay --event <json file|$event id>`,
+// `ash replay --captured <id>` and `ash replay --room <id> --since <date>`.
+func replay(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	eventArg := fs.String("event", "", "captured event JSON file (full event or message content), or the $id of a stored message")
+	roomID := fs.String("room", "", "room ID, if the event JSON doesn't include one; with --since, the room to replay")
+	sender := fs.String("sender", "", "sender, if the event JSON doesn't include one")
+	captured := fs.Int64("captured", 0, "replay a debug_events row captured by CAPTURE_FAILED_EVENTS")
+	since := fs.String("since", "", "replay the room's stored messages from this date on, YYYY-MM-DD")
+	limit := fs.Int("limit", 500, "most stored messages to replay with --since")
+	wait := fs.Duration("wait", 15*time.Second, "how long to let commands run")
+	_ = fs.Parse(args)
+
+	if strings.HasPrefix(*eventArg, "$") || *since != "" {
+		messagesDB, err := db.OpenMessages(ctx, cfg.DBPath)
+		if err != nil {
+			return fmt.Errorf("open messages db: %w", err)
+		}
+		var stored []db.StoredMessage
+		if *since != "" {
+			var t time.Time
+			if *roomID == "" {
+				err = errors.New("--since needs --room")
+			} else if t, err = app.ParseBackfillSince(*since, time.Now()); err == nil {
+				stored, err = db.StoredMessagesSince(messagesDB, *roomID, t.UnixMilli(), *limit)
+			}
+		} else {
+			var m *db.StoredMessage
+			if m, err = db.GetStoredMessage(messagesDB, *eventArg); err == nil {
+				stored = append(stored, *m)
+			}
+		}
+		messagesDB.Close()
+		if err != nil {
+			return err
+		}
+		evs := make([]*event.Event, 0, len(stored))
+		for _, m := range stored {
+			ev, err := ash.StoredReplayEvent(m)
+			if err != nil {
+				log.Warn().Err(err).Msg("skipping stored message")
+				continue
+			}
+			evs = append(evs, ev)
+		}
+		if len(evs) == 0 {
+			return errors.New("no stored messages to replay")
+		}
+		return ash.New(cfg).ReplayAll(ctx, evs, *wait)
+	}
+
+	var raw []byte
+	switch {
+	case *captured > 0:
+		messagesDB, err := db.OpenMessages(ctx, cfg.DBPath)
+		if err != nil {
+			return fmt.Errorf("open messages db: %w", err)
+		}
+		e, err := db.GetDebugEvent(messagesDB, *captured)
+		messagesDB.Close()
+		if err != nil {
+			return err
+		}
+		log.Info().Str("cmd", e.Command).Str("error", e.Error).RawJSON("state", []byte(e.StateJSON)).Msg("loaded captured event")
+		raw = []byte(e.RawJSON)
+	case *eventArg != "":
+		var err error
+		if raw, err = os.ReadFile(*eventArg); err != nil {
+			return fmt.Errorf("read event: %w", err)
+		}
+	default:
+		fs.Usage()
+		return errors.New("--event, --captured or --room with --since is required")
+	}
+	ev, err := ash.ParseReplayEvent(raw, id.RoomID(*roomID), id.UserID(*sender))
+	if err != nil {
+		return err
+	}
+	return ash.New(cfg).Replay(ctx, ev, *wait)
+}
+
+// repl handles `ash repl [--room id] [--sender id]`.
+func repl(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	var defaultRoom string
+	if len(cfg.RoomIDs) > 0 {
+		defaultRoom = cfg.RoomIDs[0].ID
+	}
+	roomID := fs.String("room", defaultRoom, "room the commands appear to come from")
+	sender := fs.String("sender", "@repl:localhost", "user the commands appear to come from")
+	_ = fs.Parse(args)
+	if *roomID == "" {
+		*roomID = "!repl:localhost"
+	}
+	return ash.New(cfg).REPL(ctx, os.Stdin, os.Stdout, id.RoomID(*roomID), id.UserID(*sender))
+}
+
+// backfill handles `ash backfill [--room id]... [--since YYYY-MM-DD]
+// [--concurrency n]`.
+func backfill(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	var rooms []id.RoomID
+	fs.Func("room", "room to backfill; repeat for several (default: every monitored room)", func(s string) error {
+		for r := range strings.SplitSeq(s, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				rooms = append(rooms, id.RoomID(r))
+			}
+		}
+		return nil
+	})
+	sinceStr := fs.String("since", "", "oldest date to fetch, YYYY-MM-DD (default: 30 days ago)")
+	concurrency := fs.Int("concurrency", 3, "rooms to fetch at once")
+	_ = fs.Parse(args)
+	since, err := app.ParseBackfillSince(*sinceStr, time.Now())
+	if err != nil {
+		return err
+	}
+	res, err := ash.New(cfg).Backfill(ctx, rooms, since, *concurrency)
+	if err != nil {
+		return err
+	}
+	log.Info().Int("events", res.Events).Int("stored", res.Stored).Int("links", res.Links).Int("undecrypted", res.Undecrypted).Msg("backfill finished")
+	return nil
+}
+
+// lintBotConfig handles `ash lint-bot-config [path]`.
+func lintBotConfig(_ context.Context, cfg *config.Config, args []string) error {
+	path := cfg.BotConfigPath
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		path = "./bot.json"
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read bot config: %w", err)
+	}
+	errs := bot.LintBotConfig(raw)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(errs))
+	}
+	log.Info().Str("path", path).Msg("bot config ok")
+	return nil
+}
+
+// logout handles `ash logout [--forget-secrets] [--force]`.
+func logout(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("logout", flag.ExitOnError)
+	forget := fs.Bool("forget-secrets", false, "also remove the stored homeserver, user, password and recovery key")
+	force := fs.Bool("force", false, "remove the local session even if the homeserver can't be reached")
+	_ = fs.Parse(args)
+	if err := ash.New(cfg).Logout(ctx, *forget, *force); err != nil {
+		return err
+	}
+	log.Info().Str("meta", cfg.MetaDBPath).Msg("session removed; message archive left intact")
+	return nil
+}
+
+// secrets handles `ash secrets export [--encrypt] [--out file]` and
+// `ash secrets import [--force] file`. The passphrase comes from
+// ASH_SECRETS_PASSPHRASE or is prompted for.
+func secrets(ctx context.Context, cfg *config.Config, args []string) error {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		return errors.New("usage: ash secrets export [--encrypt] [--out file] | ash secrets import [--force] file")
+	}
+	metaDB, err := db.OpenMeta(ctx, cfg.MetaDBPath)
+	if err != nil {
+		return fmt.Errorf("open meta db: %w", err)
+	}
+	defer metaDB.Close()
+	stdin := bufio.NewReader(os.Stdin)
+
+	if args[0] == "export" {
+		fs := flag.NewFlagSet("secrets export", flag.ExitOnError)
+		encrypt := fs.Bool("encrypt", false, "encrypt the bundle with a passphrase")
+		out := fs.String("out", "ash-secrets.json", "bundle file to write")
+		_ = fs.Parse(args[1:])
+		b, err := matrix.ExportSecrets(ctx, metaDB, cfg.MetaDBPath)
+		if err != nil {
+			return err
+		}
+		var passphrase string
+		if *encrypt {
+			if passphrase, err = secretsPassphrase(stdin, true); err != nil {
+				return err
+			}
+		} else {
+			log.Warn().Msg("writing an unencrypted bundle; it holds the password and access token in the clear")
+		}
+		data, err := b.Marshal(passphrase)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*out, data, 0o600); err != nil {
+			return fmt.Errorf("write bundle: %w", err)
+		}
+		log.Info().Str("path", *out).Str("device", b.Meta["device_id"]).Bool("crypto_store", len(b.CryptoStore) > 0).Msg("secrets exported; stop the bot here before starting it elsewhere")
+		return nil
+	}
+
+	fs := flag.NewFlagSet("secrets import", flag.ExitOnError)
+	force := fs.Bool("force", false, "replace an existing session")
+	_ = fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		return errors.New("usage: ash secrets import [--force] file")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read bundle: %w", err)
+	}
+	b, err := matrix.ParseSecrets(data, "")
+	if errors.Is(err, matrix.ErrPassphraseRequired) {
+		passphrase, perr := secretsPassphrase(stdin, false)
+		if perr != nil {
+			return perr
+		}
+		b, err = matrix.ParseSecrets(data, passphrase)
+	}
+	if err != nil {
+		return err
+	}
+	if err := matrix.ImportSecrets(ctx, metaDB, cfg.MetaDBPath, b, *force); err != nil {
+		return err
+	}
+	log.Info().Str("meta", cfg.MetaDBPath).Str("user", b.Meta["user_id"]).Str("device", b.Meta["device_id"]).Msg("secrets imported")
+	return nil
+}
+
+// secretsPassphrase returns ASH_SECRETS_PASSPHRASE or prompts for a
+// passphrase on stderr, twice when confirm is set.
+func secretsPassphrase(r *bufio.Reader, confirm bool) (string, error) {
+	if p := os.Getenv("ASH_SECRETS_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	read := func(label string) (string, error) {
+		fmt.Fprintf(os.Stderr, "%s: ", label)
+		line, err := r.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("read passphrase: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	p, err := read("Passphrase")
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		again, err := read("Repeat passphrase")
+		if err != nil {
+			return "", err
+		}
+		if again != p {
+			return "", errors.New("passphrases don't match")
+		}
+	}
+	return p, nil
+}
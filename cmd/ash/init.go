@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/matrix"
+)
+
+// prompter reads answers to interactive questions.
+type prompter struct {
+	r *bufio.Reader
+}
+
+// ask prints label and returns the trimmed answer, or def if it's blank.
+func (p *prompter) ask(label, def string) (string, error) {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", label, def)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	line, err := p.r.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", fmt.Errorf("read %s: %w", label, err)
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+// require asks until it gets a non-empty answer.
+func (p *prompter) require(label, def string) (string, error) {
+	for {
+		v, err := p.ask(label, def)
+		if err != nil || v != "" {
+			return v, err
+		}
+	}
+}
+
+// confirm asks a yes/no question.
+func (p *prompter) confirm(label string) (bool, error) {
+	v, err := p.ask(label+" [y/N]", "")
+	return strings.EqualFold(v, "y") || strings.EqualFold(v, "yes"), err
+}
+
+// initConfig handles `ash init`. It prompts for the account and rooms,
+// writes config.json, logs in (verifying the session with the recovery key)
+// and checks the account has joined the configured rooms. An existing
+// config.json supplies the defaults.
+func initConfig(ctx context.Context, _ *config.Config, _ []string) error {
+	const path = "config.json"
+	p := &prompter{r: bufio.NewReader(os.Stdin)}
+	cfg, err := config.LoadConfig()
+	switch {
+	case err == nil:
+		ok, err := p.confirm(path + " exists; overwrite it")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("aborted")
+		}
+	case errors.Is(err, os.ErrNotExist):
+		cfg = &config.Config{
+			DBPath:        "./data/messages.db",
+			MetaDBPath:    "./data/meta.db",
+			LinksPath:     "./data/links.json",
+			BotConfigPath: "./bot.json",
+			DeviceName:    "ash",
+			OptOutTag:     "#private",
+		}
+	default:
+		return err
+	}
+
+	def := cfg.Homeserver
+	if def == "" {
+		def = "https://matrix.org"
+	}
+	if cfg.Homeserver, err = p.require("Homeserver URL", def); err != nil {
+		return err
+	}
+	if cfg.User, err = p.require("Matrix user ID (@user:server)", cfg.User); err != nil {
+		return err
+	}
+	if cfg.Password, err = p.require("Password", cfg.Password); err != nil {
+		return err
+	}
+	if cfg.RecoveryKey, err = p.require("Recovery key (format: EsXX XXXX ...)", cfg.RecoveryKey); err != nil {
+		return err
+	}
+	if cfg.RoomIDs, err = promptRooms(ctx, p, cfg.Homeserver, cfg.RoomIDs); err != nil {
+		return err
+	}
+
+	for _, err := range cfg.Validate() {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := cfg.Save(path); err != nil {
+		return err
+	}
+	log.Info().Str("path", path).Msg("config written")
+
+	a := ash.New(cfg)
+	userID, deviceID, err := a.Login(ctx)
+	if err != nil {
+		return fmt.Errorf("first login: %w", err)
+	}
+	if !strings.EqualFold(string(userID), cfg.User) {
+		return fmt.Errorf("meta DB holds a session for %s, not %s; run `ash logout --forget-secrets` and `ash init` again", userID, cfg.User)
+	}
+	log.Info().Str("user", string(userID)).Str("device", string(deviceID)).Msg("logged in")
+
+	joined, err := a.JoinedRooms(ctx)
+	if err != nil {
+		return err
+	}
+	member := make(map[id.RoomID]bool, len(joined))
+	for _, roomID := range joined {
+		member[roomID] = true
+	}
+	missing := 0
+	for _, r := range cfg.RoomIDs {
+		if member[id.RoomID(r.ID)] {
+			log.Info().Str("room", r.ID).Str("comment", r.Comment).Msg("room ok")
+			continue
+		}
+		missing++
+		log.Warn().Str("room", r.ID).Str("comment", r.Comment).Msg("not joined; invite or join the bot account before running")
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d room(s) not joined", missing)
+	}
+	log.Info().Msg("setup complete; start the bot with `ash run`")
+	return nil
+}
+
+// promptRooms asks for rooms to watch, resolving aliases through the room
+// directory. Existing rooms are kept unless the user asks to replace them.
+func promptRooms(ctx context.Context, p *prompter, homeserver string, rooms []config.RoomIDEntry) ([]config.RoomIDEntry, error) {
+	if len(rooms) > 0 {
+		fmt.Printf("%d room(s) already configured.\n", len(rooms))
+		replace, err := p.confirm("Replace them")
+		if err != nil {
+			return nil, err
+		}
+		if replace {
+			rooms = nil
+		}
+	}
+	for {
+		input, err := p.ask("Room ID or alias to watch (blank to finish)", "")
+		if err != nil {
+			return nil, err
+		}
+		if input == "" {
+			if len(rooms) == 0 {
+				fmt.Println("At least one room is needed.")
+				continue
+			}
+			return rooms, nil
+		}
+		roomID, err := matrix.ResolveRoom(ctx, homeserver, input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		name := string(roomID)
+		if strings.HasPrefix(input, "#") {
+			fmt.Printf("%s -> %s\n", input, roomID)
+			name = strings.TrimPrefix(strings.SplitN(input, ":", 2)[0], "#")
+		}
+		comment, err := p.ask("Name for this room", name)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, config.RoomIDEntry{ID: string(roomID), Comment: comment})
+	}
+}
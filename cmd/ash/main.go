@@ -2,150 +2,105 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
-	"strings"
-	"sync"
+	"sort"
 	"syscall"
-	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"maunium.net/go/mautrix"
-	"maunium.net/go/mautrix/event"
 
-	"github.com/polarhive/ash/app"
-	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash"
 	"github.com/polarhive/ash/config"
-	"github.com/polarhive/ash/db"
-	"github.com/polarhive/ash/matrix"
 )
 
-// main initializes the application, loads config, sets up databases, and starts the bot.
-func main() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	log.Debug().Msg("starting")
-
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
-
-	cfg, err := config.LoadConfig()
-	must(err, "load config")
-	if cfg.Debug {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	}
-	log.Debug().Msg("config loaded")
-
-	metaDB, err := db.OpenMeta(ctx, cfg.MetaDBPath)
-	must(err, "open meta db")
-	defer metaDB.Close()
-
-	must(matrix.EnsureSecrets(ctx, metaDB, cfg), "ensure secrets")
-
-	messagesDB, err := db.OpenMessages(ctx, cfg.DBPath)
-	must(err, "open messages db")
-	defer messagesDB.Close()
-
-	_, err = matrix.EnsurePickleKey(ctx, metaDB)
-	must(err, "ensure pickle key")
+// subcommand is an `ash <name>` entry point.
+type subcommand struct {
+	usage string
+	run   func(ctx context.Context, cfg *config.Config, args []string) error
+}
 
-	must(run(ctx, metaDB, messagesDB, cfg), "run")
-	log.Debug().Msg("exiting")
+var subcommands = map[string]subcommand{
+	"run":             {"run the bot (default)", runBot},
+	"init":            {"interactively write config.json and log in", initConfig},
+	"login":           {"log in and store the session without syncing", login},
+	"logout":          {"log out and remove the stored session and crypto store", logout},
+	"export":          {"export link snapshots to JSON", export},
+	"migrate":         {"apply database schema migrations", migrate},
+	"secrets":         {"export or import the session and crypto store as a bundle", secrets},
+	"validate":        {"check config.json and bot.json", validate},
+	"lint-bot-config": {"check bot.json strictly (fields, builtins, placeholders)", lintBotConfig},
+	"backfill":        {"store rooms' history from before the bot joined", backfill},
+	"replay":          {"feed a captured event through a dry-run pipeline", replay},
+	"repl":            {"run bot.json commands locally without a homeserver", repl},
+	"version":         {"print the version and build info", printVersion},
 }
 
-// run starts the Matrix client, sets up sync, and handles messages.
-func run(ctx context.Context, metaDB *sql.DB, messagesDB *sql.DB, cfg *config.Config) error {
-	log.Info().Msgf("logging in as %s to %s (E2EE initializing)", cfg.User, cfg.Homeserver)
-	var roomNames []string
-	for _, r := range cfg.RoomIDs {
-		roomNames = append(roomNames, r.Comment)
-	}
-	log.Info().Msgf("ready: watching rooms: [%s]", strings.Join(roomNames, ", "))
+// noConfig lists subcommands that run without loading config.json: init
+// writes it and loads any existing one itself, version doesn't need it.
+var noConfig = map[string]bool{"init": true, "version": true}
 
-	client, err := matrix.LoadOrCreate(ctx, metaDB, cfg)
-	if err != nil {
-		return err
-	}
-	client.SyncPresence = "offline"
-	syncer := mautrix.NewDefaultSyncer()
-	client.Syncer = syncer
-	client.Store = &db.MetaSyncStore{DB: metaDB}
+// main initializes logging, loads config, and dispatches to a subcommand.
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	log.Debug().Msg("starting")
 
-	cryptoHelper, err := matrix.SetupHelper(ctx, client, metaDB, cfg.MetaDBPath)
-	if err != nil {
-		return err
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
-	client.Crypto = cryptoHelper
-	if err := matrix.VerifyWithRecoveryKey(ctx, cryptoHelper.Machine(), cfg.RecoveryKey); err != nil {
-		log.Warn().Err(err).Msg("failed to verify session with recovery key")
+	sub, ok := subcommands[name]
+	if !ok {
+		if name != "help" && name != "-h" && name != "--help" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		}
+		usage()
+		os.Exit(2)
 	}
 
-	// Load bot configuration (optional).
-	botCfgPath := cfg.BotConfigPath
-	if botCfgPath == "" {
-		botCfgPath = "./bot.json"
-	}
-	botCfg, err := bot.LoadBotConfig(botCfgPath)
-	if err != nil {
-		log.Warn().Err(err).Str("path", botCfgPath).Msg("failed to load bot config (continuing without)")
-	} else {
-		log.Info().Str("path", botCfgPath).Msg("loaded bot config")
-	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
 
-	// Set yap leaderboard timezone from config (defaults to UTC).
-	if cfg.Timezone != "" {
-		if tz, err := time.LoadLocation(cfg.Timezone); err != nil {
-			log.Warn().Err(err).Str("tz", cfg.Timezone).Msg("invalid TIMEZONE in config, using UTC")
-		} else {
-			bot.YapTimezone = tz
-			log.Info().Str("tz", cfg.Timezone).Msg("yap leaderboard timezone set")
+	var cfg *config.Config
+	if !noConfig[name] {
+		var err error
+		cfg, err = config.LoadConfig()
+		must(err, "load config")
+		if cfg.Debug {
+			zerolog.SetGlobalLevel(zerolog.DebugLevel)
 		}
+		log.Debug().Msg("config loaded")
 	}
 
-	readyChan := make(chan bool)
-	var once sync.Once
-	syncer.OnSync(func(_ context.Context, _ *mautrix.RespSync, _ string) bool {
-		once.Do(func() { close(readyChan) })
-		return true
-	})
+	must(sub.run(ctx, cfg, args), name)
+	log.Debug().Msg("exiting")
+}
 
-	a := &app.App{
-		Cfg:        cfg,
-		MessagesDB: messagesDB,
-		BotCfg:     botCfg,
-		Client:     client,
-		ReadyChan:  readyChan,
-		KnockKnock: bot.NewKnockKnockState(),
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ash <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
 	}
-	bot.InitTriviaState()
-	syncer.OnEventType(event.EventMessage, a.HandleMessage)
-	syncer.OnEventType(event.EventReaction, func(ctx context.Context, ev *event.Event) {
-		log.Info().Str("event_id", string(ev.ID)).Str("reactor", string(ev.Sender)).Msg("reaction event received from matrix")
-		a.HandleReaction(ctx, ev)
-	})
-
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Error().Msgf("sync goroutine panic: %v", r)
-			}
-		}()
-		log.Debug().Msg("starting sync")
-		if err := client.Sync(); err != nil && ctx.Err() == nil {
-			log.Error().Err(err).Msg("sync error")
-		}
-	}()
-
-	select {
-	case <-readyChan:
-	case <-ctx.Done():
-		return ctx.Err()
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", name, subcommands[name].usage)
 	}
-	<-ctx.Done()
-	log.Debug().Msg("exiting run")
-	return ctx.Err()
+}
+
+// runBot handles `ash run`.
+func runBot(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", cfg.DryRun, "run everything but log outgoing events instead of sending them")
+	noNetwork := fs.Bool("no-network", cfg.DryRunNoNetwork, "with --dry-run, also skip http and ai commands, link resolution and enrichment")
+	_ = fs.Parse(args)
+	cfg.DryRun = *dryRun || *noNetwork
+	cfg.DryRunNoNetwork = *noNetwork
+	return ash.New(cfg).Run(ctx)
 }
 
 func must(err error, context string) {
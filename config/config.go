@@ -4,39 +4,358 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 // RoomIDEntry describes a Matrix room the bot should monitor.
 type RoomIDEntry struct {
-	ID              string   `json:"id"`
-	Comment         string   `json:"comment"`
-	Hook            string   `json:"hook,omitempty"`
-	Key             string   `json:"key,omitempty"`
-	SendUser        bool     `json:"sendUser,omitempty"`
-	SendTopic       bool     `json:"sendTopic,omitempty"`
-	AllowedCommands []string `json:"allowedCommands,omitempty"`
+	ID              string              `json:"id"`
+	Comment         string              `json:"comment"`
+	Hook            string              `json:"hook,omitempty"`
+	Key             string              `json:"key,omitempty"`
+	SendUser        bool                `json:"sendUser,omitempty"`
+	SendTopic       bool                `json:"sendTopic,omitempty"`
+	AllowedCommands []string            `json:"allowedCommands,omitempty"`
+	StripEXIF       bool                `json:"stripExif,omitempty"`
+	Flood           *FloodConfig        `json:"flood,omitempty"`
+	WordFilter      *WordFilterConfig   `json:"wordFilter,omitempty"`
+	Welcome         *WelcomeConfig      `json:"welcome,omitempty"`
+	MediaQuotaMB    int                 `json:"mediaQuotaMB,omitempty"` // overrides MEDIA_QUOTA_MB; -1 disables
+	SlowMode        *SlowModeConfig     `json:"slowMode,omitempty"`
+	Timezone        string              `json:"timezone,omitempty"` // IANA name; overrides TIMEZONE for /bot yap hours
+	Language        string              `json:"language,omitempty"` // MESSAGES_PATH language for the bot's replies; default English
+	ThreadDigest    *ThreadDigestConfig `json:"threadDigest,omitempty"`
+	DupQuestions    *DupQuestionsConfig `json:"duplicateQuestions,omitempty"`
+	Crosspost       *CrosspostConfig    `json:"crosspost,omitempty"`
+	TranscribeVoice bool                `json:"transcribeVoice,omitempty"` // reply to voice messages with their transcript
+	SedCorrections  bool                `json:"sedCorrections,omitempty"`  // answer s/typo/fix/ with the sender's corrected message
+}
+
+// CrosspostConfig mirrors every link accepted in the room (not opted out or
+// blacklisted) into the links room Room, crediting the sender and tagged
+// with Tags and the message's #hashtags. A link already crossposted to Room
+// in the last DedupeDays days is skipped.
+type CrosspostConfig struct {
+	Room       string   `json:"room"`
+	Tags       []string `json:"tags,omitempty"`
+	DedupeDays int      `json:"dedupeDays,omitempty"` // defaults to 30; -1 posts repeats
+}
+
+// DupQuestionsConfig points people asking a question that closely matches an
+// earlier, answered one to that answer.
+type DupQuestionsConfig struct {
+	Threshold float64 `json:"threshold,omitempty"` // word overlap from 0 to 1; defaults to 0.6
+	Days      int     `json:"days,omitempty"`      // how far back to look; defaults to 90
+}
+
+// ThreadDigestConfig offers a summary of threads that reach Threshold
+// messages. Anyone reacting 👍 to the offer gets it posted into the thread,
+// written by the bot.json ai command Command (input_type "thread").
+type ThreadDigestConfig struct {
+	Threshold int    `json:"threshold"`
+	Command   string `json:"command,omitempty"` // defaults to "tldr"
+}
+
+// SlowModeConfig limits each user to one message per Seconds while slow mode
+// is on. It can be toggled at runtime with /bot slowmode.
+type SlowModeConfig struct {
+	Enabled     bool   `json:"enabled,omitempty"`
+	Seconds     int    `json:"seconds"`
+	Action      string `json:"action,omitempty"` // "warn" or "mute"; defaults to warn
+	MuteMinutes int    `json:"muteMinutes,omitempty"`
+}
+
+// WelcomeConfig greets members joining a room. Template uses text/template
+// with {{.DisplayName}}, {{.UserID}} and {{.RoomName}}.
+type WelcomeConfig struct {
+	Template     string `json:"template"`
+	DM           bool   `json:"dm,omitempty"`
+	MaxPerMinute int    `json:"maxPerMinute,omitempty"` // defaults to 3
+}
+
+// FloodConfig sets a room's spam thresholds, counted per user over a one
+// minute sliding window. Zero disables a check.
+type FloodConfig struct {
+	MessagesPerMinute int      `json:"messagesPerMinute,omitempty"`
+	DuplicateLimit    int      `json:"duplicateLimit,omitempty"`
+	LinksPerMinute    int      `json:"linksPerMinute,omitempty"`
+	Actions           []string `json:"actions,omitempty"` // "warn", "ignore", "notify"; defaults to warn
+	IgnoreMinutes     int      `json:"ignoreMinutes,omitempty"`
+}
+
+// YapGuessConfig sets the rewards and limits of the /bot yap guess game.
+// Zero values use the defaults; -1 disables close-guess points.
+type YapGuessConfig struct {
+	ExactPoints int  `json:"exactPoints,omitempty"` // defaults to 3
+	ClosePoints int  `json:"closePoints,omitempty"` // off by one place; defaults to 1
+	MaxPerDay   int  `json:"maxPerDay,omitempty"`   // guesses per user per room; defaults to 3
+	WeeklyPost  bool `json:"weeklyPost,omitempty"`  // post last week's winners each Monday
+}
+
+// AdminAPIConfig enables the HTTP admin API. Every request must carry
+// "Authorization: Bearer <token>".
+type AdminAPIConfig struct {
+	Listen string `json:"listen"` // e.g. "127.0.0.1:8089"
+	Token  string `json:"token"`
+}
+
+// InboundHooksConfig serves POST /hooks/<token>, posting each request's body
+// into the room mapped to token.
+type InboundHooksConfig struct {
+	Listen       string                  `json:"listen"` // e.g. "0.0.0.0:8091"
+	Hooks        map[string]InboundHook  `json:"hooks"`  // by token
+	GitHub       *GitHubHookConfig       `json:"github,omitempty"`
+	Alertmanager *AlertmanagerHookConfig `json:"alertmanager,omitempty"`
+}
+
+// AlertmanagerHookConfig serves POST /alertmanager for Prometheus
+// Alertmanager's webhook receiver, posting alert groups into Room. Requests
+// must carry "Authorization: Bearer <token>".
+type AlertmanagerHookConfig struct {
+	Token string `json:"token"`
+	Room  string `json:"room"`
+}
+
+// GitHubHookConfig serves POST /github for GitHub webhooks signed with
+// Secret, posting push, pull request, issue and release events into the
+// room mapped to the repository ("owner/name", or "*" for any other).
+type GitHubHookConfig struct {
+	Secret string            `json:"secret"`
+	Repos  map[string]string `json:"repos"`
+}
+
+// InboundHook is the room an inbound hook posts to. Template is a Go
+// text/template over the JSON body's fields, or {{.text}} for plain text.
+type InboundHook struct {
+	Room     string `json:"room"`
+	Template string `json:"template,omitempty"`
+}
+
+// AILogConfig records AI prompts and responses in the ai_log table for
+// review. Users can opt out with /bot ailog off, or, with OptIn, must opt in
+// with /bot ailog on before anything of theirs is kept.
+type AILogConfig struct {
+	RetentionDays int  `json:"retentionDays,omitempty"` // defaults to 30
+	OptIn         bool `json:"optIn,omitempty"`
+}
+
+// FeedsConfig turns on the RSS/Atom poller for feeds rooms subscribe to with
+// /bot feed add.
+type FeedsConfig struct {
+	IntervalMinutes int `json:"intervalMinutes,omitempty"` // defaults to 30
+	MaxItems        int `json:"maxItems,omitempty"`        // new items posted per feed per poll, defaults to 5
+}
+
+// GIFSearchConfig sets up /bot gif, searching Tenor or Giphy with apiKey.
+type GIFSearchConfig struct {
+	Provider string `json:"provider"` // "tenor" or "giphy"
+	APIKey   string `json:"apiKey"`
+	Rating   string `json:"rating,omitempty"` // highest content rating: g, pg, pg-13 (default) or r
+}
+
+// TranscriptionConfig sets up voice message transcription, for /bot
+// transcribe and rooms with transcribeVoice.
+type TranscriptionConfig struct {
+	Backend  string `json:"backend"`            // "api" (OpenAI-compatible, Groq by default) or "whisper.cpp"
+	URL      string `json:"url,omitempty"`      // api: base URL
+	APIKey   string `json:"apiKey,omitempty"`   // api: defaults to GROQ_API_KEY
+	Model    string `json:"model,omitempty"`    // api: model name; whisper.cpp: path to the ggml model
+	Command  string `json:"command,omitempty"`  // whisper.cpp: program, default whisper-cli
+	Language string `json:"language,omitempty"` // spoken language, e.g. "en"; detected if unset
+}
+
+// OCRConfig picks the backend /bot ocr reads text with. Without it, ocr
+// runs tesseract with English.
+type OCRConfig struct {
+	Backend   string `json:"backend"`             // "tesseract" or "ocr.space"
+	Languages string `json:"languages,omitempty"` // tesseract: e.g. "eng+deu"; ocr.space: one code like "ger"
+	APIKey    string `json:"apiKey,omitempty"`    // ocr.space
+}
+
+// EmailConfig runs a plain SMTP listener (no TLS or AUTH, so keep it on a
+// private address behind your mail relay) that posts incoming mail into
+// the room of the first matching rule, with attachments uploaded as media.
+// Mail no rule matches is refused.
+type EmailConfig struct {
+	Listen    string      `json:"listen"`              // e.g. "127.0.0.1:2525"
+	Hostname  string      `json:"hostname,omitempty"`  // in the SMTP greeting, defaults to "ash"
+	MaxSizeMB int         `json:"maxSizeMB,omitempty"` // largest accepted message, defaults to 25
+	Rules     []EmailRule `json:"rules"`
+}
+
+// EmailRule matches mail by case-insensitive regexes on an envelope
+// recipient, the From address and the subject; empty ones match anything.
+type EmailRule struct {
+	To      string `json:"to,omitempty"`
+	From    string `json:"from,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Room    string `json:"room"`
+}
+
+// NotificationsConfig maps notification names to their templates.
+type NotificationsConfig map[string]NotificationTemplate
+
+// NotificationTemplate is a named message sent by the admin API (POST
+// /api/notify/{name}) or by the bot itself for internal events of the same
+// name. Text and HTML are Go templates over the data it's sent with; HTML is
+// escaped as html/template does.
+type NotificationTemplate struct {
+	Room    string `json:"room"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+	MsgType string `json:"msgType,omitempty"` // "notice" (default) or "text"
+}
+
+// DashboardConfig enables the read-only web dashboard of room statistics.
+// With user and password set, it asks for HTTP basic auth.
+type DashboardConfig struct {
+	Listen   string `json:"listen"` // e.g. "127.0.0.1:8090"
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// WordFilterConfig lists case-insensitive regex patterns that trigger the
+// configured actions when a message matches.
+type WordFilterConfig struct {
+	Patterns []string `json:"patterns"`
+	Actions  []string `json:"actions,omitempty"` // "warn", "notify", "redact"; defaults to warn
 }
 
 // Config holds all application configuration loaded from config.json.
 type Config struct {
-	Homeserver    string        `json:"MATRIX_HOMESERVER"`
-	User          string        `json:"MATRIX_USER"`
-	Password      string        `json:"MATRIX_PASSWORD"`
-	RecoveryKey   string        `json:"MATRIX_RECOVERY_KEY"`
-	RoomIDs       []RoomIDEntry `json:"MATRIX_ROOM_ID"`
-	DBPath        string        `json:"DB_PATH"`
-	MetaDBPath    string        `json:"META_DB_PATH"`
-	LinksPath     string        `json:"LINKS_JSON_PATH"`
-	BotConfigPath string        `json:"BOT_CONFIG_PATH"`
-	BotReplyLabel string        `json:"BOT_REPLY_LABEL,omitempty"`
-	LinkstashURL  string        `json:"LINKSTASH_URL,omitempty"`
-	GroqAPIKey    string        `json:"GROQ_API_KEY,omitempty"`
-	SyncTimeoutMS int           `json:"SYNC_TIMEOUT_MS"`
-	Debug         bool          `json:"DEBUG"`
-	DryRun        bool          `json:"DRY_RUN"`
-	DeviceName    string        `json:"MATRIX_DEVICE_NAME"`
-	OptOutTag     string        `json:"OPT_OUT_TAG"`
-	Timezone      string        `json:"TIMEZONE,omitempty"`
+	Homeserver           string               `json:"MATRIX_HOMESERVER"`
+	User                 string               `json:"MATRIX_USER"`
+	Password             string               `json:"MATRIX_PASSWORD"`
+	RecoveryKey          string               `json:"MATRIX_RECOVERY_KEY"`
+	RoomIDs              []RoomIDEntry        `json:"MATRIX_ROOM_ID"`
+	DBPath               string               `json:"DB_PATH"`
+	MetaDBPath           string               `json:"META_DB_PATH"`
+	LinksPath            string               `json:"LINKS_JSON_PATH"`
+	BotConfigPath        string               `json:"BOT_CONFIG_PATH"`
+	BotReplyLabel        string               `json:"BOT_REPLY_LABEL,omitempty"`
+	MessagesPath         string               `json:"MESSAGES_PATH,omitempty"`    // translations of the bot's messages
+	ReplyPageChars       int                  `json:"REPLY_PAGE_CHARS,omitempty"` // longer command replies are paged
+	LinkstashURL         string               `json:"LINKSTASH_URL,omitempty"`
+	GroqAPIKey           string               `json:"GROQ_API_KEY,omitempty"`
+	AIKeysSecret         string               `json:"AI_KEYS_SECRET,omitempty"` // encrypts keys from /bot aikey set
+	SyncTimeoutMS        int                  `json:"SYNC_TIMEOUT_MS"`
+	Debug                bool                 `json:"DEBUG"`
+	DryRun               bool                 `json:"DRY_RUN"`
+	DryRunNoNetwork      bool                 `json:"DRY_RUN_NO_NETWORK,omitempty"`
+	DeviceName           string               `json:"MATRIX_DEVICE_NAME"`
+	DisplayName          string               `json:"BOT_DISPLAY_NAME,omitempty"`
+	OptOutTag            string               `json:"OPT_OUT_TAG"`
+	Timezone             string               `json:"TIMEZONE,omitempty"`
+	YapExclude           []string             `json:"YAP_EXCLUDE,omitempty"`
+	YapGuess             *YapGuessConfig      `json:"YAP_GUESS,omitempty"`
+	Admins               []string             `json:"ADMINS,omitempty"`
+	ModRoomID            string               `json:"MOD_ROOM_ID,omitempty"`
+	MaxUploadMB          int                  `json:"MAX_UPLOAD_MB,omitempty"`
+	ImageBlurhash        bool                 `json:"IMAGE_BLURHASH,omitempty"` // add blurhashes to images sent
+	MediaQuotaMB         int                  `json:"MEDIA_QUOTA_MB,omitempty"`
+	ExecTmpDir           string               `json:"EXEC_TMP_DIR,omitempty"`
+	ExecAllowlist        []string             `json:"EXEC_ALLOWLIST,omitempty"` // absolute paths exec commands may run
+	CaptureFailedEvents  bool                 `json:"CAPTURE_FAILED_EVENTS,omitempty"`
+	CaptureRetentionDays int                  `json:"CAPTURE_RETENTION_DAYS,omitempty"`
+	ExportMode           string               `json:"EXPORT_MODE,omitempty"`
+	ExportDebounceSecs   int                  `json:"EXPORT_DEBOUNCE_SECONDS,omitempty"`
+	ExportIntervalMins   int                  `json:"EXPORT_INTERVAL_MINUTES,omitempty"`
+	HookBatchSize        int                  `json:"HOOK_BATCH_SIZE,omitempty"`
+	HookBatchSecs        int                  `json:"HOOK_BATCH_SECONDS,omitempty"`
+	ProxyURL             string               `json:"PROXY_URL,omitempty"`
+	EnrichLinks          bool                 `json:"ENRICH_LINKS,omitempty"`
+	EnrichDomainSecs     int                  `json:"ENRICH_DOMAIN_DELAY_SECONDS,omitempty"`
+	ReadOnly             bool                 `json:"READ_ONLY,omitempty"`
+	AllJoinedRooms       bool                 `json:"ALL_JOINED_ROOMS,omitempty"`
+	ExcludeRoomIDs       []string             `json:"EXCLUDE_ROOM_IDS,omitempty"`
+	RoomDefaults         *RoomIDEntry         `json:"ROOM_DEFAULTS,omitempty"`
+	AdminAPI             *AdminAPIConfig      `json:"ADMIN_API,omitempty"`
+	Dashboard            *DashboardConfig     `json:"DASHBOARD,omitempty"`
+	InboundHooks         *InboundHooksConfig  `json:"INBOUND_HOOKS,omitempty"`
+	AILog                *AILogConfig         `json:"AI_LOG,omitempty"`
+	Feeds                *FeedsConfig         `json:"FEEDS,omitempty"`
+	GIFSearch            *GIFSearchConfig     `json:"GIF_SEARCH,omitempty"`
+	Transcription        *TranscriptionConfig `json:"TRANSCRIPTION,omitempty"`
+	OCR                  *OCRConfig           `json:"OCR,omitempty"`
+	Email                *EmailConfig         `json:"EMAIL,omitempty"`
+	Notifications        NotificationsConfig  `json:"NOTIFICATIONS,omitempty"`
+}
+
+// Room returns the settings for a room. Rooms listed in MATRIX_ROOM_ID use
+// their own entry. With ALL_JOINED_ROOMS any other room that isn't in
+// EXCLUDE_ROOM_IDS gets a copy of ROOM_DEFAULTS, named after its ID.
+func (c *Config) Room(roomID string) (RoomIDEntry, bool) {
+	for _, r := range c.RoomIDs {
+		if r.ID == roomID {
+			return r, true
+		}
+	}
+	if !c.AllJoinedRooms || roomID == "" {
+		return RoomIDEntry{}, false
+	}
+	for _, ex := range c.ExcludeRoomIDs {
+		if ex == roomID {
+			return RoomIDEntry{}, false
+		}
+	}
+	var r RoomIDEntry
+	if c.RoomDefaults != nil {
+		r = *c.RoomDefaults
+	}
+	r.ID = roomID
+	if r.Comment == "" {
+		r.Comment = roomID
+	}
+	return r, true
+}
+
+// Rooms returns the entries for the configured rooms plus, with
+// ALL_JOINED_ROOMS, every other non-excluded room in seen.
+func (c *Config) Rooms(seen []string) []RoomIDEntry {
+	rooms := append([]RoomIDEntry(nil), c.RoomIDs...)
+	if !c.AllJoinedRooms {
+		return rooms
+	}
+	for _, roomID := range seen {
+		if r, ok := c.Room(roomID); ok && !c.isListed(roomID) {
+			rooms = append(rooms, r)
+		}
+	}
+	return rooms
+}
+
+func (c *Config) isListed(roomID string) bool {
+	for _, r := range c.RoomIDs {
+		if r.ID == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecTmpPath returns where exec commands get their temp directories:
+// EXEC_TMP_DIR, or a tmp directory next to DB_PATH.
+func (c *Config) ExecTmpPath() string {
+	if c.ExecTmpDir != "" {
+		return c.ExecTmpDir
+	}
+	if c.DBPath != "" {
+		return filepath.Join(filepath.Dir(c.DBPath), "tmp")
+	}
+	return filepath.Join(os.TempDir(), "ash")
+}
+
+// MediaQuotaBytes returns the daily media quota for a room in bytes, taking
+// per-room overrides into account. 0 means unlimited.
+func (c *Config) MediaQuotaBytes(roomID string) int64 {
+	mb := c.MediaQuotaMB
+	if r, ok := c.Room(roomID); ok && r.MediaQuotaMB != 0 {
+		mb = r.MediaQuotaMB
+	}
+	if mb <= 0 {
+		return 0
+	}
+	return int64(mb) << 20
 }
 
 // LoadConfig reads and parses the config.json file.
@@ -53,3 +372,16 @@ func LoadConfig() (*Config, error) {
 	}
 	return &cfg, nil
 }
+
+// Save writes the config as indented JSON. The file holds the password and
+// recovery key, so it is only readable by the owner.
+func (c *Config) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
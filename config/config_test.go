@@ -0,0 +1,117 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	good := &Config{
+		Admins:    []string{"@admin:example.com"},
+		ModRoomID: "!mods:example.com",
+		RoomIDs: []RoomIDEntry{{
+			ID:         "!room:example.com",
+			WordFilter: &WordFilterConfig{Patterns: []string{`\bspam\b`}},
+			SlowMode:   &SlowModeConfig{Seconds: 30, Action: "mute"},
+		}},
+	}
+	if errs := good.Validate(); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+
+	bad := &Config{
+		Admins:          []string{"admin"},
+		ModRoomID:       "#mods:example.com",
+		DryRunNoNetwork: true,
+		HookBatchSize:   -1,
+		ReplyPageChars:  -1,
+		ProxyURL:        "localhost:3128",
+		ExecAllowlist:   []string{"magick"},
+		AdminAPI:        &AdminAPIConfig{Listen: "127.0.0.1:8089", Token: "short"},
+		Dashboard:       &DashboardConfig{Listen: "127.0.0.1:8090", User: "admin"},
+		InboundHooks: &InboundHooksConfig{Listen: ":8091", Hooks: map[string]InboundHook{
+			"short": {Room: "!ci:example.com", Template: "{{.status"},
+		}},
+		AIKeysSecret:  "short",
+		AILog:         &AILogConfig{RetentionDays: -1},
+		Feeds:         &FeedsConfig{IntervalMinutes: -5},
+		GIFSearch:     &GIFSearchConfig{Provider: "imgur"},
+		Transcription: &TranscriptionConfig{Backend: "whisper.cpp"},
+		OCR:           &OCRConfig{Backend: "ocr.space"},
+		Email:         &EmailConfig{Listen: ":2525", Rules: []EmailRule{{Subject: "[", Room: "alerts"}}},
+		Notifications: NotificationsConfig{
+			"deployment_done": {Room: "!ops:example.com", Text: "{{.service}} deployed", HTML: "<b>{{.service</b>"},
+		},
+		RoomIDs: []RoomIDEntry{{
+			ID:         "room",
+			Comment:    "lounge",
+			WordFilter: &WordFilterConfig{Patterns: []string{"("}},
+			SlowMode:   &SlowModeConfig{Action: "ban"},
+			Timezone:   "Mars/Olympus",
+			Crosspost:  &CrosspostConfig{Room: "#links:example.com"},
+		}},
+	}
+	if errs := bad.Validate(); len(errs) != 27 {
+		t.Errorf("expected 27 errors, got %d: %v", len(errs), errs)
+	}
+}
+
+func TestRoomAllJoined(t *testing.T) {
+	cfg := &Config{
+		RoomIDs:        []RoomIDEntry{{ID: "!listed:example.com", Comment: "listed", MediaQuotaMB: 5}},
+		AllJoinedRooms: true,
+		ExcludeRoomIDs: []string{"!skip:example.com"},
+		RoomDefaults:   &RoomIDEntry{AllowedCommands: []string{"hi"}, MediaQuotaMB: 2},
+	}
+	if r, ok := cfg.Room("!listed:example.com"); !ok || r.Comment != "listed" {
+		t.Errorf("listed room = %+v, %v", r, ok)
+	}
+	r, ok := cfg.Room("!other:example.com")
+	if !ok || r.ID != "!other:example.com" || r.Comment != "!other:example.com" || len(r.AllowedCommands) != 1 {
+		t.Errorf("default room = %+v, %v", r, ok)
+	}
+	if _, ok := cfg.Room("!skip:example.com"); ok {
+		t.Error("excluded room should not be watched")
+	}
+	if got := cfg.MediaQuotaBytes("!other:example.com"); got != 2<<20 {
+		t.Errorf("default room quota = %d, want 2 MB", got)
+	}
+	rooms := cfg.Rooms([]string{"!listed:example.com", "!other:example.com", "!skip:example.com"})
+	if len(rooms) != 2 || rooms[1].ID != "!other:example.com" {
+		t.Errorf("Rooms() = %+v", rooms)
+	}
+
+	cfg.AllJoinedRooms = false
+	if _, ok := cfg.Room("!other:example.com"); ok {
+		t.Error("unlisted room should not be watched without ALL_JOINED_ROOMS")
+	}
+	if errs := cfg.Validate(); len(errs) != 1 {
+		t.Errorf("expected ROOM_DEFAULTS warning, got %v", errs)
+	}
+}
+
+func TestSave(t *testing.T) {
+	cfg := &Config{
+		Homeserver: "https://matrix.example.com",
+		User:       "@ash:example.com",
+		RoomIDs:    []RoomIDEntry{{ID: "!room:example.com", Comment: "lounge"}},
+		DBPath:     "./data/messages.db",
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := cfg.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var got Config
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Homeserver != cfg.Homeserver || len(got.RoomIDs) != 1 || got.RoomIDs[0].Comment != "lounge" || got.DBPath != cfg.DBPath {
+		t.Errorf("round trip = %+v", got)
+	}
+}
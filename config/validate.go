@@ -0,0 +1,265 @@
+package config
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/polarhive/ash/util"
+)
+
+// Validate checks the config for mistakes that would otherwise only show up
+// at runtime, returning one error per problem.
+func (c *Config) Validate() []error {
+	var errs []error
+	if c.ModRoomID != "" && !strings.HasPrefix(c.ModRoomID, "!") {
+		errs = append(errs, fmt.Errorf("MOD_ROOM_ID %q is not a room ID", c.ModRoomID))
+	}
+	for _, a := range c.Admins {
+		if !strings.HasPrefix(a, "@") || !strings.Contains(a, ":") {
+			errs = append(errs, fmt.Errorf("ADMINS entry %q is not a user ID", a))
+		}
+	}
+	if c.AIKeysSecret != "" && len(c.AIKeysSecret) < 16 {
+		errs = append(errs, fmt.Errorf("AI_KEYS_SECRET must be at least 16 characters"))
+	}
+	if c.DryRunNoNetwork && !c.DryRun {
+		errs = append(errs, fmt.Errorf("DRY_RUN_NO_NETWORK has no effect without DRY_RUN"))
+	}
+	switch c.ExportMode {
+	case "", "per-message", "debounce", "schedule", "on-demand", "shutdown":
+	default:
+		errs = append(errs, fmt.Errorf("EXPORT_MODE %q must be per-message, debounce, schedule, on-demand or shutdown", c.ExportMode))
+	}
+	if c.HookBatchSize < 0 || c.HookBatchSecs < 0 {
+		errs = append(errs, fmt.Errorf("HOOK_BATCH_SIZE and HOOK_BATCH_SECONDS must not be negative"))
+	}
+	if c.ReplyPageChars < 0 {
+		errs = append(errs, fmt.Errorf("REPLY_PAGE_CHARS must not be negative"))
+	}
+	for _, p := range c.ExecAllowlist {
+		if !filepath.IsAbs(p) {
+			errs = append(errs, fmt.Errorf("EXEC_ALLOWLIST entry %q is not an absolute path", p))
+		}
+	}
+	if c.ProxyURL != "" {
+		if _, err := util.ParseProxyURL(c.ProxyURL); err != nil {
+			errs = append(errs, fmt.Errorf("PROXY_URL: %w", err))
+		}
+	}
+	for _, x := range c.YapExclude {
+		switch strings.ToLower(x) {
+		case "urls", "code", "quotes", "emoji":
+		default:
+			errs = append(errs, fmt.Errorf("YAP_EXCLUDE entry %q must be urls, code, quotes or emoji", x))
+		}
+	}
+	if g := c.YapGuess; g != nil {
+		if g.ExactPoints < 0 || g.ClosePoints < -1 || g.MaxPerDay < 0 {
+			errs = append(errs, fmt.Errorf("YAP_GUESS: exactPoints and maxPerDay must not be negative; closePoints may be -1 to disable"))
+		}
+	}
+	if a := c.AdminAPI; a != nil {
+		if a.Listen == "" {
+			errs = append(errs, fmt.Errorf("ADMIN_API: listen is required"))
+		}
+		if len(a.Token) < 16 {
+			errs = append(errs, fmt.Errorf("ADMIN_API: token must be at least 16 characters"))
+		}
+	}
+	if d := c.Dashboard; d != nil {
+		if d.Listen == "" {
+			errs = append(errs, fmt.Errorf("DASHBOARD: listen is required"))
+		}
+		if (d.User == "") != (d.Password == "") {
+			errs = append(errs, fmt.Errorf("DASHBOARD: set both user and password, or neither"))
+		}
+	}
+	if h := c.InboundHooks; h != nil {
+		if h.Listen == "" {
+			errs = append(errs, fmt.Errorf("INBOUND_HOOKS: listen is required"))
+		}
+		for token, hook := range h.Hooks {
+			name := hook.Room
+			if len(token) < 16 {
+				errs = append(errs, fmt.Errorf("INBOUND_HOOKS: token for %s must be at least 16 characters", name))
+			}
+			if !strings.HasPrefix(hook.Room, "!") {
+				errs = append(errs, fmt.Errorf("INBOUND_HOOKS: room %q is not a room ID", name))
+			}
+			if _, err := template.New("hook").Parse(hook.Template); err != nil {
+				errs = append(errs, fmt.Errorf("INBOUND_HOOKS: template for %s: %w", name, err))
+			}
+		}
+		if gh := h.GitHub; gh != nil {
+			if len(gh.Secret) < 16 {
+				errs = append(errs, fmt.Errorf("INBOUND_HOOKS: github.secret must be at least 16 characters"))
+			}
+			for repo, room := range gh.Repos {
+				if !strings.HasPrefix(room, "!") {
+					errs = append(errs, fmt.Errorf("INBOUND_HOOKS: github room %q for %s is not a room ID", room, repo))
+				}
+			}
+		}
+		if am := h.Alertmanager; am != nil {
+			if len(am.Token) < 16 {
+				errs = append(errs, fmt.Errorf("INBOUND_HOOKS: alertmanager.token must be at least 16 characters"))
+			}
+			if !strings.HasPrefix(am.Room, "!") {
+				errs = append(errs, fmt.Errorf("INBOUND_HOOKS: alertmanager room %q is not a room ID", am.Room))
+			}
+		}
+	}
+	if l := c.AILog; l != nil && l.RetentionDays < 0 {
+		errs = append(errs, fmt.Errorf("AI_LOG: retentionDays must not be negative"))
+	}
+	if f := c.Feeds; f != nil && (f.IntervalMinutes < 0 || f.MaxItems < 0) {
+		errs = append(errs, fmt.Errorf("FEEDS: intervalMinutes and maxItems must not be negative"))
+	}
+	if g := c.GIFSearch; g != nil {
+		if g.Provider != "tenor" && g.Provider != "giphy" {
+			errs = append(errs, fmt.Errorf("GIF_SEARCH: provider %q must be tenor or giphy", g.Provider))
+		}
+		if g.APIKey == "" {
+			errs = append(errs, fmt.Errorf("GIF_SEARCH: apiKey is required"))
+		}
+		switch g.Rating {
+		case "", "g", "pg", "pg-13", "r":
+		default:
+			errs = append(errs, fmt.Errorf("GIF_SEARCH: rating %q must be g, pg, pg-13 or r", g.Rating))
+		}
+	}
+	if t := c.Transcription; t != nil {
+		switch t.Backend {
+		case "api":
+			if t.APIKey == "" && c.GroqAPIKey == "" {
+				errs = append(errs, fmt.Errorf("TRANSCRIPTION: apiKey or GROQ_API_KEY is required"))
+			}
+		case "whisper.cpp":
+			if t.Model == "" {
+				errs = append(errs, fmt.Errorf("TRANSCRIPTION: model is required for whisper.cpp"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("TRANSCRIPTION: backend %q must be api or whisper.cpp", t.Backend))
+		}
+	}
+	if o := c.OCR; o != nil {
+		switch o.Backend {
+		case "tesseract":
+		case "ocr.space":
+			if o.APIKey == "" {
+				errs = append(errs, fmt.Errorf("OCR: apiKey is required for ocr.space"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("OCR: backend %q must be tesseract or ocr.space", o.Backend))
+		}
+	}
+	if e := c.Email; e != nil {
+		if e.Listen == "" {
+			errs = append(errs, fmt.Errorf("EMAIL: listen is required"))
+		}
+		if e.MaxSizeMB < 0 {
+			errs = append(errs, fmt.Errorf("EMAIL: maxSizeMB must not be negative"))
+		}
+		if len(e.Rules) == 0 {
+			errs = append(errs, fmt.Errorf("EMAIL: at least one rule is required"))
+		}
+		for i, rule := range e.Rules {
+			if !strings.HasPrefix(rule.Room, "!") {
+				errs = append(errs, fmt.Errorf("EMAIL: rule %d: room %q is not a room ID", i+1, rule.Room))
+			}
+			for _, p := range []string{rule.To, rule.From, rule.Subject} {
+				if _, err := regexp.Compile("(?i)" + p); err != nil {
+					errs = append(errs, fmt.Errorf("EMAIL: rule %d: %w", i+1, err))
+				}
+			}
+		}
+	}
+	for name, n := range c.Notifications {
+		if !strings.HasPrefix(n.Room, "!") {
+			errs = append(errs, fmt.Errorf("NOTIFICATIONS: %s: room %q is not a room ID", name, n.Room))
+		}
+		if strings.TrimSpace(n.Text) == "" {
+			errs = append(errs, fmt.Errorf("NOTIFICATIONS: %s: text is required", name))
+		} else if _, err := template.New(name).Parse(n.Text); err != nil {
+			errs = append(errs, fmt.Errorf("NOTIFICATIONS: %s: text: %w", name, err))
+		}
+		if _, err := htmltemplate.New(name).Parse(n.HTML); err != nil {
+			errs = append(errs, fmt.Errorf("NOTIFICATIONS: %s: html: %w", name, err))
+		}
+		if t := n.MsgType; t != "" && t != "notice" && t != "text" {
+			errs = append(errs, fmt.Errorf("NOTIFICATIONS: %s: msgType %q must be notice or text", name, t))
+		}
+	}
+	for i, r := range c.RoomIDs {
+		name := r.Comment
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		if !strings.HasPrefix(r.ID, "!") {
+			errs = append(errs, fmt.Errorf("room %s: id %q is not a room ID", name, r.ID))
+		}
+		errs = append(errs, validateRoom(name, r)...)
+	}
+	for _, ex := range c.ExcludeRoomIDs {
+		if !strings.HasPrefix(ex, "!") {
+			errs = append(errs, fmt.Errorf("EXCLUDE_ROOM_IDS entry %q is not a room ID", ex))
+		}
+	}
+	if c.RoomDefaults != nil {
+		if !c.AllJoinedRooms {
+			errs = append(errs, fmt.Errorf("ROOM_DEFAULTS has no effect without ALL_JOINED_ROOMS"))
+		}
+		if c.RoomDefaults.ID != "" {
+			errs = append(errs, fmt.Errorf("ROOM_DEFAULTS must not set an id"))
+		}
+		errs = append(errs, validateRoom("defaults", *c.RoomDefaults)...)
+	}
+	return errs
+}
+
+// validateRoom checks a room entry's optional settings.
+func validateRoom(name string, r RoomIDEntry) []error {
+	var errs []error
+	if r.WordFilter != nil {
+		for _, p := range r.WordFilter.Patterns {
+			if _, err := regexp.Compile("(?i)" + p); err != nil {
+				errs = append(errs, fmt.Errorf("room %s: invalid wordFilter pattern %q: %w", name, p, err))
+			}
+		}
+	}
+	if r.Timezone != "" {
+		if _, err := time.LoadLocation(r.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("room %s: invalid timezone %q", name, r.Timezone))
+		}
+	}
+	if r.ThreadDigest != nil && r.ThreadDigest.Threshold < 2 {
+		errs = append(errs, fmt.Errorf("room %s: threadDigest.threshold must be at least 2", name))
+	}
+	if q := r.DupQuestions; q != nil && (q.Threshold < 0 || q.Threshold > 1 || q.Days < 0) {
+		errs = append(errs, fmt.Errorf("room %s: duplicateQuestions.threshold must be between 0 and 1 and days not negative", name))
+	}
+	if c := r.Crosspost; c != nil {
+		if !strings.HasPrefix(c.Room, "!") {
+			errs = append(errs, fmt.Errorf("room %s: crosspost.room %q is not a room ID", name, c.Room))
+		} else if c.Room == r.ID {
+			errs = append(errs, fmt.Errorf("room %s: crosspost.room can't be the room itself", name))
+		}
+		if c.DedupeDays < -1 {
+			errs = append(errs, fmt.Errorf("room %s: crosspost.dedupeDays must be -1 or more", name))
+		}
+	}
+	if r.SlowMode != nil {
+		if r.SlowMode.Seconds <= 0 {
+			errs = append(errs, fmt.Errorf("room %s: slowMode.seconds must be positive", name))
+		}
+		if a := r.SlowMode.Action; a != "" && a != "warn" && a != "mute" {
+			errs = append(errs, fmt.Errorf("room %s: slowMode.action %q must be warn or mute", name, a))
+		}
+	}
+	return errs
+}
@@ -16,6 +16,9 @@ CREATE TABLE IF NOT EXISTS links (
     idx INTEGER,
     title TEXT,
     ts_ms INTEGER,
+    status_code INTEGER,
+    content_type TEXT,
+    enriched_at_ms INTEGER,
     PRIMARY KEY (message_id, url, idx)
 );
 
@@ -43,3 +46,209 @@ CREATE TABLE IF NOT EXISTS reactions (
 
 CREATE INDEX IF NOT EXISTS idx_reactions_room_ts ON reactions(room_id, created_at_ms);
 CREATE INDEX IF NOT EXISTS idx_reactions_msg ON reactions(message_id);
+
+-- Moderation audit log for actions taken through the bot
+CREATE TABLE IF NOT EXISTS mod_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id TEXT,
+    actor TEXT,
+    action TEXT,
+    target TEXT,
+    reason TEXT,
+    event_id TEXT,
+    ts_ms INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_mod_audit_room_ts ON mod_audit(room_id, ts_ms);
+
+-- Power levels of muted users before their mute, restored on unmute, and
+-- when timed mutes (expires_at_ms set) are lifted
+CREATE TABLE IF NOT EXISTS mutes (
+    room_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    prev_level INTEGER NOT NULL,
+    mute_level INTEGER,
+    expires_at_ms INTEGER,
+    ts_ms INTEGER,
+    PRIMARY KEY (room_id, user_id)
+);
+
+-- Media uploaded by the bot per room and UTC day, for quota enforcement
+CREATE TABLE IF NOT EXISTS media_usage (
+    room_id TEXT,
+    day TEXT,
+    bytes INTEGER,
+    PRIMARY KEY (room_id, day)
+);
+
+-- Users whose messages are archived but never processed by the bot
+CREATE TABLE IF NOT EXISTS ignored_users (
+    user_id TEXT PRIMARY KEY,
+    added_by TEXT,
+    ts_ms INTEGER
+);
+
+-- Messages sent by the bot, so it can redact its own recent output
+CREATE TABLE IF NOT EXISTS sent_messages (
+    event_id TEXT PRIMARY KEY,
+    room_id TEXT,
+    ts_ms INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_sent_messages_room_ts ON sent_messages(room_id, ts_ms);
+
+-- Events whose commands failed, captured for replay when CAPTURE_FAILED_EVENTS is set
+CREATE TABLE IF NOT EXISTS debug_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id TEXT,
+    room_id TEXT,
+    command TEXT,
+    error TEXT,
+    raw_json TEXT,
+    state_json TEXT,
+    ts_ms INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_debug_events_ts ON debug_events(ts_ms);
+
+-- Final yap leaderboard ranks per room and day, for movement indicators
+CREATE TABLE IF NOT EXISTS yap_history (
+    room_id TEXT,
+    day TEXT,
+    sender TEXT,
+    rank INTEGER,
+    words INTEGER,
+    PRIMARY KEY (room_id, day, sender)
+);
+
+-- Points earned in bot games, one row per award
+CREATE TABLE IF NOT EXISTS game_scores (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id TEXT,
+    user_id TEXT,
+    game TEXT,
+    points INTEGER,
+    ts_ms INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_game_scores_room_ts ON game_scores(room_id, game, ts_ms);
+
+-- Plays per user and day, for per-day limits
+CREATE TABLE IF NOT EXISTS game_attempts (
+    room_id TEXT,
+    user_id TEXT,
+    game TEXT,
+    day TEXT,
+    attempts INTEGER,
+    PRIMARY KEY (room_id, user_id, game, day)
+);
+
+-- Weekly winners announcements already posted
+CREATE TABLE IF NOT EXISTS game_weekly_posts (
+    room_id TEXT,
+    game TEXT,
+    week TEXT,
+    ts_ms INTEGER,
+    PRIMARY KEY (room_id, game, week)
+);
+
+-- Summaries offered for long threads, keyed by the offer message
+CREATE TABLE IF NOT EXISTS thread_digests (
+    room_id TEXT,
+    root_id TEXT,
+    prompt_id TEXT,
+    offered_at_ms INTEGER,
+    posted_at_ms INTEGER,
+    PRIMARY KEY (room_id, root_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_thread_digests_prompt ON thread_digests(prompt_id);
+
+-- Per-room glossary for /bot what is
+CREATE TABLE IF NOT EXISTS glossary (
+    room_id TEXT,
+    term TEXT,
+    definition TEXT,
+    added_by TEXT,
+    updated_at_ms INTEGER,
+    PRIMARY KEY (room_id, term)
+);
+
+-- AI prompts and responses kept for review (AI_LOG)
+CREATE TABLE IF NOT EXISTS ai_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id TEXT,
+    user_id TEXT,
+    command TEXT,
+    model TEXT,
+    prompt TEXT,
+    response TEXT,
+    error TEXT,
+    ts_ms INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_log_ts ON ai_log(ts_ms);
+
+-- Users' choice about having their AI requests logged
+CREATE TABLE IF NOT EXISTS ai_log_consent (
+    user_id TEXT PRIMARY KEY,
+    consent INTEGER,
+    ts_ms INTEGER
+);
+
+-- RSS/Atom subscriptions (/bot feed)
+CREATE TABLE IF NOT EXISTS feeds (
+    room_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    title TEXT,
+    added_by TEXT,
+    ts_ms INTEGER,
+    polled_ms INTEGER,
+    last_error TEXT,
+    PRIMARY KEY (room_id, url)
+);
+
+-- Feed items already seen, by GUID
+CREATE TABLE IF NOT EXISTS feed_items (
+    room_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    guid TEXT NOT NULL,
+    ts_ms INTEGER,
+    PRIMARY KEY (room_id, url, guid)
+);
+
+-- Users' own AI API keys (/bot aikey), sealed with AI_KEYS_SECRET
+CREATE TABLE IF NOT EXISTS ai_keys (
+    user_id TEXT PRIMARY KEY,
+    sealed TEXT NOT NULL,
+    ts_ms INTEGER
+);
+
+-- AI requests and tokens per user and day, split by whose key paid
+CREATE TABLE IF NOT EXISTS ai_usage (
+    day TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    own_key INTEGER NOT NULL,
+    requests INTEGER NOT NULL DEFAULT 0,
+    tokens INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, user_id, own_key)
+);
+
+-- Links mirrored into links rooms by crosspost, by canonical URL, so each
+-- is only posted once per dedupe window
+CREATE TABLE IF NOT EXISTS crossposts (
+    links_room TEXT NOT NULL,
+    url TEXT NOT NULL,
+    room_id TEXT,
+    message_id TEXT,
+    ts_ms INTEGER,
+    PRIMARY KEY (links_room, url)
+);
+
+-- Responses of http commands with cache_persist, by command, until expiry
+CREATE TABLE IF NOT EXISTS http_cache (
+    key TEXT PRIMARY KEY,
+    body BLOB,
+    content_type TEXT,
+    expires_ms INTEGER NOT NULL
+);
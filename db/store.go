@@ -1,17 +1,23 @@
 package db
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
@@ -31,7 +37,7 @@ func (s *MetaSyncStore) LoadNextBatch(ctx context.Context, userID id.UserID) (st
 	return GetMeta(ctx, s.DB, "sync_token")
 }
 func (s *MetaSyncStore) SaveNextBatch(ctx context.Context, userID id.UserID, token string) error {
-	return SetMeta(ctx, s.DB, "sync_token", token)
+	return RetryBusy(ctx, func() error { return SetMeta(ctx, s.DB, "sync_token", token) })
 }
 func (s *MetaSyncStore) Close() error { return nil }
 func (s *MetaSyncStore) Name() string { return "MetaSyncStore" }
@@ -75,13 +81,56 @@ func OpenMessages(ctx context.Context, path string) (*sql.DB, error) {
 	return openWithSchema(ctx, path, "schema_messages.sql")
 }
 
+// connParams are applied by the driver to every pooled connection: wait up
+// to 5s for a lock instead of failing with "database is locked", enforce
+// foreign keys, skip the fsync per commit that WAL makes unnecessary, and
+// keep a 16 MiB page cache.
+const connParams = "_busy_timeout=5000&_foreign_keys=on&_synchronous=NORMAL&_cache_size=-16384"
+
+// busyRetries and busyBackoff bound RetryBusy.
+const (
+	busyRetries = 5
+	busyBackoff = 20 * time.Millisecond
+)
+
+// IsBusy reports whether err is SQLite's "database is locked" or "table is
+// locked".
+func IsBusy(err error) bool {
+	var se sqlite3.Error
+	return errors.As(err, &se) && (se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked)
+}
+
+// RetryBusy runs fn, running it again with backoff while it fails with
+// IsBusy. busy_timeout covers most contention, but not a transaction that
+// read before writing while another connection wrote, which SQLite fails
+// at once; fn must be safe to repeat.
+func RetryBusy(ctx context.Context, fn func() error) error {
+	delay := busyBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if !IsBusy(err) || attempt == busyRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
 func openWithSchema(ctx context.Context, path, schemaFile string) (*sql.DB, error) {
 	if dir := filepath.Dir(path); dir != "." && dir != "" {
 		if err := os.MkdirAll(dir, 0o755); err != nil {
 			return nil, fmt.Errorf("create db dir: %w", err)
 		}
 	}
-	database, err := sql.Open("sqlite3", path)
+	dsn := path + "?" + connParams
+	if strings.Contains(path, "?") {
+		dsn = path + "&" + connParams
+	}
+	database, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("open sqlite: %w", err)
 	}
@@ -95,9 +144,51 @@ func openWithSchema(ctx context.Context, path, schemaFile string) (*sql.DB, erro
 	if _, err := database.ExecContext(ctx, string(sqlBytes)); err != nil {
 		return nil, fmt.Errorf("apply schema: %w", err)
 	}
+	if schemaFile == "schema_messages.sql" {
+		// Columns added after a table was first released.
+		for _, c := range []struct{ table, column, colType string }{
+			{"mod_audit", "event_id", "TEXT"},
+			{"links", "status_code", "INTEGER"},
+			{"links", "content_type", "TEXT"},
+			{"links", "enriched_at_ms", "INTEGER"},
+			{"mutes", "mute_level", "INTEGER"},
+			{"mutes", "expires_at_ms", "INTEGER"},
+		} {
+			if err := ensureColumn(ctx, database, c.table, c.column, c.colType); err != nil {
+				return nil, fmt.Errorf("migrate schema: %w", err)
+			}
+		}
+	}
 	return database, nil
 }
 
+// ensureColumn adds a column to an existing table if it is missing.
+func ensureColumn(ctx context.Context, database *sql.DB, table, column, colType string) error {
+	rows, err := database.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = database.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, colType))
+	return err
+}
+
 // GetMeta retrieves a value from the meta key-value table.
 func GetMeta(ctx context.Context, database *sql.DB, key string) (string, error) {
 	var val string
@@ -116,6 +207,55 @@ func SetMeta(ctx context.Context, database *sql.DB, key, value string) error {
 	return err
 }
 
+// AllMeta returns every row of the meta key-value table.
+func AllMeta(ctx context.Context, database *sql.DB) (map[string]string, error) {
+	rows, err := database.QueryContext(ctx, `SELECT key, value FROM meta`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	meta := make(map[string]string)
+	for rows.Next() {
+		var key string
+		var val sql.NullString
+		if err := rows.Scan(&key, &val); err != nil {
+			return nil, err
+		}
+		meta[key] = val.String
+	}
+	return meta, rows.Err()
+}
+
+// SetMetaAll inserts or updates several meta values in one transaction.
+func SetMetaAll(ctx context.Context, database *sql.DB, values map[string]string) error {
+	tx, err := database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for key, val := range values {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO meta(key, value) VALUES(?, ?) ON CONFLICT(key) DO UPDATE SET value=excluded.value`, key, val); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteMeta removes keys from the meta key-value table in one transaction.
+func DeleteMeta(ctx context.Context, database *sql.DB, keys ...string) error {
+	tx, err := database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, key := range keys {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM meta WHERE key = ?`, key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // ---------------------------------------------------------------------------
 // Message storage
 // ---------------------------------------------------------------------------
@@ -148,101 +288,1507 @@ func ProcessMessageEvent(ev *event.Event) (*MessageData, error) {
 	}, nil
 }
 
-// StoreMessage persists a message and its links to the database.
+// StoreMessage persists a message and its links to the database in one
+// transaction, retrying while the database is busy.
 func StoreMessage(database *sql.DB, data *MessageData) error {
 	rawJSON, _ := json.Marshal(data.Event.Content.Raw)
+	return RetryBusy(context.Background(), func() error {
+		tx, err := database.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(`
+			INSERT OR IGNORE INTO messages(id, room_id, sender, ts_ms, body, msgtype, raw_json)
+			VALUES (?, ?, ?, ?, ?, ?, ?);
+		`, data.Event.ID, data.Event.RoomID, data.Event.Sender, int64(data.Event.Timestamp),
+			data.Msg.Body, data.Msg.MsgType, string(rawJSON)); err != nil {
+			return err
+		}
+		for idx, u := range data.URLs {
+			if _, err := tx.Exec(`
+				INSERT OR IGNORE INTO links(message_id, url, idx, title, ts_ms)
+				VALUES (?, ?, ?, NULL, ?);
+			`, data.Event.ID, u, idx, int64(data.Event.Timestamp)); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// StoreReaction persists an emoji reaction to the database.
+func StoreReaction(database *sql.DB, messageID string, roomID string, emoji string, reactor string, ts int64) error {
+	return RetryBusy(context.Background(), func() error {
+		_, err := database.Exec(`
+			INSERT OR IGNORE INTO reactions(message_id, room_id, emoji, reactor, created_at_ms)
+			VALUES (?, ?, ?, ?, ?);
+		`, messageID, roomID, emoji, reactor, ts)
+		return err
+	})
+}
+
+// ModLogEntry is a moderation action recorded in the audit log. EventID
+// references the message that triggered or was affected by the action.
+type ModLogEntry struct {
+	RoomID   string
+	Actor    string
+	Action   string
+	Target   string
+	Reason   string
+	EventID  string
+	TSMillis int64
+}
+
+// StoreModAction records a moderation action in the audit log.
+func StoreModAction(database *sql.DB, e ModLogEntry) error {
 	_, err := database.Exec(`
-		INSERT OR IGNORE INTO messages(id, room_id, sender, ts_ms, body, msgtype, raw_json)
+		INSERT INTO mod_audit(room_id, actor, action, target, reason, event_id, ts_ms)
 		VALUES (?, ?, ?, ?, ?, ?, ?);
-	`, data.Event.ID, data.Event.RoomID, data.Event.Sender, int64(data.Event.Timestamp),
-		data.Msg.Body, data.Msg.MsgType, string(rawJSON))
+	`, e.RoomID, e.Actor, e.Action, e.Target, e.Reason, e.EventID, e.TSMillis)
+	return err
+}
+
+// ModLog returns the most recent audit log entries for a room, or for every
+// room if roomID is empty, newest first.
+func ModLog(database *sql.DB, roomID string, limit int) ([]ModLogEntry, error) {
+	rows, err := database.Query(`
+		SELECT room_id, actor, action, target, COALESCE(reason, ''), COALESCE(event_id, ''), ts_ms
+		FROM mod_audit WHERE ? = '' OR room_id = ?
+		ORDER BY ts_ms DESC, id DESC LIMIT ?;
+	`, roomID, roomID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []ModLogEntry
+	for rows.Next() {
+		var e ModLogEntry
+		if err := rows.Scan(&e.RoomID, &e.Actor, &e.Action, &e.Target, &e.Reason, &e.EventID, &e.TSMillis); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Mute is a user's mute in a room. PrevLevel is their power level before it
+// and MuteLevel the level it set; ExpiresMillis is when a timed mute should
+// be lifted, or 0 if it lasts until unmuted.
+type Mute struct {
+	RoomID        string
+	UserID        string
+	PrevLevel     int
+	MuteLevel     int
+	ExpiresMillis int64
+}
+
+// SaveMute records a mute. A user muted again keeps the level recorded by
+// their first mute, with the new mute level and expiry.
+func SaveMute(database *sql.DB, m Mute, ts int64) error {
+	_, err := database.Exec(`
+		INSERT INTO mutes(room_id, user_id, prev_level, mute_level, expires_at_ms, ts_ms)
+		VALUES (?, ?, ?, ?, NULLIF(?, 0), ?)
+		ON CONFLICT(room_id, user_id) DO UPDATE SET
+			mute_level = excluded.mute_level, expires_at_ms = excluded.expires_at_ms, ts_ms = excluded.ts_ms;
+	`, m.RoomID, m.UserID, m.PrevLevel, m.MuteLevel, m.ExpiresMillis, ts)
+	return err
+}
+
+// GetMute returns a user's recorded mute, and false if there is none.
+func GetMute(database *sql.DB, roomID, userID string) (Mute, bool, error) {
+	m := Mute{RoomID: roomID, UserID: userID}
+	err := database.QueryRow(`
+		SELECT prev_level, COALESCE(mute_level, 0), COALESCE(expires_at_ms, 0)
+		FROM mutes WHERE room_id = ? AND user_id = ?
+	`, roomID, userID).Scan(&m.PrevLevel, &m.MuteLevel, &m.ExpiresMillis)
+	if err == sql.ErrNoRows {
+		return m, false, nil
+	}
+	return m, err == nil, err
+}
+
+// ExpiredMutes returns the timed mutes that expired at or before now.
+func ExpiredMutes(database *sql.DB, now int64) ([]Mute, error) {
+	rows, err := database.Query(`
+		SELECT room_id, user_id, prev_level, COALESCE(mute_level, 0), expires_at_ms
+		FROM mutes WHERE expires_at_ms <= ? ORDER BY expires_at_ms;
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var mutes []Mute
+	for rows.Next() {
+		var m Mute
+		if err := rows.Scan(&m.RoomID, &m.UserID, &m.PrevLevel, &m.MuteLevel, &m.ExpiresMillis); err != nil {
+			return nil, err
+		}
+		mutes = append(mutes, m)
+	}
+	return mutes, rows.Err()
+}
+
+// DeleteMute forgets a user's recorded mute.
+func DeleteMute(database *sql.DB, roomID, userID string) error {
+	_, err := database.Exec(`DELETE FROM mutes WHERE room_id = ? AND user_id = ?`, roomID, userID)
+	return err
+}
+
+// AddMediaUsage adds uploaded bytes to a room's usage for the given day.
+func AddMediaUsage(database *sql.DB, roomID, day string, bytes int64) error {
+	_, err := database.Exec(`
+		INSERT INTO media_usage(room_id, day, bytes) VALUES (?, ?, ?)
+		ON CONFLICT(room_id, day) DO UPDATE SET bytes = bytes + excluded.bytes;
+	`, roomID, day, bytes)
+	return err
+}
+
+// GetMediaUsage returns the bytes uploaded to a room on the given day.
+func GetMediaUsage(database *sql.DB, roomID, day string) (int64, error) {
+	var bytes int64
+	err := database.QueryRow(`SELECT bytes FROM media_usage WHERE room_id = ? AND day = ?`, roomID, day).Scan(&bytes)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return bytes, err
+}
+
+// AddIgnoredUser adds a user to the persisted ignore list.
+func AddIgnoredUser(database *sql.DB, userID, addedBy string, ts int64) error {
+	_, err := database.Exec(`
+		INSERT OR REPLACE INTO ignored_users(user_id, added_by, ts_ms)
+		VALUES (?, ?, ?);
+	`, userID, addedBy, ts)
+	return err
+}
+
+// RemoveIgnoredUser removes a user from the persisted ignore list.
+func RemoveIgnoredUser(database *sql.DB, userID string) error {
+	_, err := database.Exec(`DELETE FROM ignored_users WHERE user_id = ?`, userID)
+	return err
+}
+
+// LoadIgnoredUsers returns all users on the persisted ignore list.
+func LoadIgnoredUsers(database *sql.DB) ([]string, error) {
+	rows, err := database.Query(`SELECT user_id FROM ignored_users ORDER BY user_id`)
 	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// StoreSentMessage records a message sent by the bot.
+func StoreSentMessage(database *sql.DB, eventID, roomID string, ts int64) error {
+	return RetryBusy(context.Background(), func() error {
+		_, err := database.Exec(`
+			INSERT OR IGNORE INTO sent_messages(event_id, room_id, ts_ms)
+			VALUES (?, ?, ?);
+		`, eventID, roomID, ts)
 		return err
+	})
+}
+
+// RecentSentMessages returns the IDs of the bot's last n messages in a room,
+// newest first.
+func RecentSentMessages(database *sql.DB, roomID string, n int) ([]string, error) {
+	rows, err := database.Query(`
+		SELECT event_id FROM sent_messages WHERE room_id = ?
+		ORDER BY ts_ms DESC LIMIT ?;
+	`, roomID, n)
+	if err != nil {
+		return nil, err
 	}
-	for idx, u := range data.URLs {
-		if _, err := database.Exec(`
-			INSERT OR IGNORE INTO links(message_id, url, idx, title, ts_ms)
-			VALUES (?, ?, ?, NULL, ?);
-		`, data.Event.ID, u, idx, int64(data.Event.Timestamp)); err != nil {
-			return err
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var evID string
+		if err := rows.Scan(&evID); err != nil {
+			return nil, err
 		}
+		ids = append(ids, evID)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteSentMessage forgets a sent message, e.g. after it was redacted.
+func DeleteSentMessage(database *sql.DB, eventID string) error {
+	_, err := database.Exec(`DELETE FROM sent_messages WHERE event_id = ?`, eventID)
+	return err
+}
+
+// DebugEvent is a captured event whose command failed.
+type DebugEvent struct {
+	ID        int64
+	EventID   string
+	RoomID    string
+	Command   string
+	Error     string
+	RawJSON   string
+	StateJSON string
+	TSMillis  int64
+}
+
+// StoreDebugEvent captures a failing event and prunes captures older than
+// retentionMS.
+func StoreDebugEvent(database *sql.DB, e DebugEvent, retentionMS int64) error {
+	if _, err := database.Exec(`
+		INSERT INTO debug_events(event_id, room_id, command, error, raw_json, state_json, ts_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?);
+	`, e.EventID, e.RoomID, e.Command, e.Error, e.RawJSON, e.StateJSON, e.TSMillis); err != nil {
+		return err
+	}
+	if retentionMS > 0 {
+		_, err := database.Exec(`DELETE FROM debug_events WHERE ts_ms < ?`, e.TSMillis-retentionMS)
+		return err
 	}
 	return nil
 }
 
-// StoreReaction persists an emoji reaction to the database.
-func StoreReaction(database *sql.DB, messageID string, roomID string, emoji string, reactor string, ts int64) error {
+// GetDebugEvent loads a captured event by its row ID.
+func GetDebugEvent(database *sql.DB, rowID int64) (*DebugEvent, error) {
+	var e DebugEvent
+	err := database.QueryRow(`
+		SELECT id, event_id, room_id, command, error, raw_json, state_json, ts_ms
+		FROM debug_events WHERE id = ?;
+	`, rowID).Scan(&e.ID, &e.EventID, &e.RoomID, &e.Command, &e.Error, &e.RawJSON, &e.StateJSON, &e.TSMillis)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no captured event with id %d", rowID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// AILogEntry is a logged AI request.
+type AILogEntry struct {
+	ID       int64
+	RoomID   string
+	UserID   string
+	Command  string
+	Model    string
+	Prompt   string
+	Response string
+	Error    string
+	TSMillis int64
+}
+
+// StoreAILogEntry logs an AI request and prunes entries older than
+// retentionMS.
+func StoreAILogEntry(database *sql.DB, e AILogEntry, retentionMS int64) error {
+	if _, err := database.Exec(`
+		INSERT INTO ai_log(room_id, user_id, command, model, prompt, response, error, ts_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+	`, e.RoomID, e.UserID, e.Command, e.Model, e.Prompt, e.Response, e.Error, e.TSMillis); err != nil {
+		return err
+	}
+	if retentionMS > 0 {
+		_, err := database.Exec(`DELETE FROM ai_log WHERE ts_ms < ?`, e.TSMillis-retentionMS)
+		return err
+	}
+	return nil
+}
+
+// AILogEntries returns up to limit logged AI requests since sinceMS, oldest
+// first. An empty roomID means all rooms.
+func AILogEntries(database *sql.DB, roomID string, sinceMS int64, limit int) ([]AILogEntry, error) {
+	rows, err := database.Query(`
+		SELECT id, room_id, user_id, command, model, prompt, response, error, ts_ms
+		FROM ai_log
+		WHERE (? = '' OR room_id = ?) AND ts_ms >= ?
+		ORDER BY ts_ms, id LIMIT ?;
+	`, roomID, roomID, sinceMS, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AILogEntry
+	for rows.Next() {
+		var e AILogEntry
+		if err := rows.Scan(&e.ID, &e.RoomID, &e.UserID, &e.Command, &e.Model, &e.Prompt, &e.Response, &e.Error, &e.TSMillis); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// SetAILogConsent records whether a user agrees to AI logging.
+func SetAILogConsent(database *sql.DB, userID string, consent bool, ts int64) error {
 	_, err := database.Exec(`
-		INSERT OR IGNORE INTO reactions(message_id, room_id, emoji, reactor, created_at_ms)
-		VALUES (?, ?, ?, ?, ?);
-	`, messageID, roomID, emoji, reactor, ts)
+		INSERT OR REPLACE INTO ai_log_consent(user_id, consent, ts_ms) VALUES (?, ?, ?);
+	`, userID, consent, ts)
 	return err
 }
 
-// ---------------------------------------------------------------------------
-// Link snapshots
-// ---------------------------------------------------------------------------
+// AILogConsent returns a user's AI logging choice; set is false if they
+// never made one.
+func AILogConsent(database *sql.DB, userID string) (consent, set bool, err error) {
+	err = database.QueryRow(`SELECT consent FROM ai_log_consent WHERE user_id = ?`, userID).Scan(&consent)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	return consent, err == nil, err
+}
 
-// LinkRow represents a link entry for JSON export.
-type LinkRow struct {
-	MessageID string `json:"message_id"`
-	URL       string `json:"url"`
-	TSMillis  int64  `json:"ts_ms"`
-	Sender    string `json:"sender"`
+// SetAIKey stores a user's sealed AI API key, replacing any earlier one.
+func SetAIKey(database *sql.DB, userID, sealed string, ts int64) error {
+	_, err := database.Exec(`INSERT OR REPLACE INTO ai_keys(user_id, sealed, ts_ms) VALUES (?, ?, ?)`, userID, sealed, ts)
+	return err
 }
 
-// ExportAllSnapshots exports all links from monitored rooms to a JSON file.
-func ExportAllSnapshots(database *sql.DB, rooms []config.RoomIDEntry, path string) error {
-	roomMap := make(map[string]string)
-	for _, r := range rooms {
-		roomMap[r.ID] = r.Comment
+// DeleteAIKey removes a user's AI API key, reporting whether there was one.
+func DeleteAIKey(database *sql.DB, userID string) (bool, error) {
+	res, err := database.Exec(`DELETE FROM ai_keys WHERE user_id = ?`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AIKey returns a user's sealed AI API key, or "" if they haven't set one.
+func AIKey(database *sql.DB, userID string) (string, error) {
+	var sealed string
+	err := database.QueryRow(`SELECT sealed FROM ai_keys WHERE user_id = ?`, userID).Scan(&sealed)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return sealed, err
+}
+
+// AIUsage is a user's AI requests and tokens, on their own key or the bot's.
+type AIUsage struct {
+	UserID   string
+	OwnKey   bool
+	Requests int
+	Tokens   int
+}
+
+// AddAIUsage counts one AI request for a user on day (YYYY-MM-DD).
+func AddAIUsage(database *sql.DB, day, userID string, ownKey bool, tokens int) error {
+	_, err := database.Exec(`
+		INSERT INTO ai_usage(day, user_id, own_key, requests, tokens) VALUES (?, ?, ?, 1, ?)
+		ON CONFLICT(day, user_id, own_key) DO UPDATE SET requests = requests + 1, tokens = tokens + excluded.tokens;
+	`, day, userID, ownKey, tokens)
+	return err
+}
+
+// AIUsageSince totals AI usage per user and key owner from sinceDay on,
+// heaviest users first. An empty userID means everyone.
+func AIUsageSince(database *sql.DB, userID, sinceDay string) ([]AIUsage, error) {
+	rows, err := database.Query(`
+		SELECT user_id, own_key, SUM(requests), SUM(tokens) FROM ai_usage
+		WHERE day >= ? AND (? = '' OR user_id = ?)
+		GROUP BY user_id, own_key
+		ORDER BY SUM(tokens) DESC, user_id, own_key;
+	`, sinceDay, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AIUsage
+	for rows.Next() {
+		var u AIUsage
+		if err := rows.Scan(&u.UserID, &u.OwnKey, &u.Requests, &u.Tokens); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// StoredMessage is a row of the messages table. RawJSON holds the event
+// content.
+type StoredMessage struct {
+	ID       string
+	RoomID   string
+	Sender   string
+	TSMillis int64
+	RawJSON  string
+}
+
+// GetStoredMessage returns the stored message with the given event ID.
+func GetStoredMessage(database *sql.DB, eventID string) (*StoredMessage, error) {
+	var m StoredMessage
+	err := database.QueryRow(`
+		SELECT id, room_id, sender, ts_ms, raw_json FROM messages WHERE id = ?;
+	`, eventID).Scan(&m.ID, &m.RoomID, &m.Sender, &m.TSMillis, &m.RawJSON)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no stored message with id %s", eventID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// StoredMessagesSince returns up to limit of a room's stored messages from
+// sinceMS on, oldest first.
+func StoredMessagesSince(database *sql.DB, roomID string, sinceMS int64, limit int) ([]StoredMessage, error) {
+	rows, err := database.Query(`
+		SELECT id, room_id, sender, ts_ms, raw_json FROM messages
+		WHERE room_id = ? AND ts_ms >= ?
+		ORDER BY ts_ms LIMIT ?;
+	`, roomID, sinceMS, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []StoredMessage
+	for rows.Next() {
+		var m StoredMessage
+		if err := rows.Scan(&m.ID, &m.RoomID, &m.Sender, &m.TSMillis, &m.RawJSON); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// ArchivedMessage is a stored message as served by the admin API.
+type ArchivedMessage struct {
+	ID       string
+	Sender   string
+	Body     string
+	MsgType  string
+	TSMillis int64
+}
+
+// SearchMessages returns up to limit of a room's messages sent at or after
+// sinceMS, oldest first. A non-empty query keeps only messages whose body
+// contains it, case-insensitively.
+func SearchMessages(database *sql.DB, roomID string, sinceMS int64, query string, limit int) ([]ArchivedMessage, error) {
+	pattern := "%"
+	if query != "" {
+		pattern = "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	}
+	rows, err := database.Query(`
+		SELECT id, sender, body, COALESCE(msgtype, ''), ts_ms FROM messages
+		WHERE room_id = ? AND ts_ms >= ? AND body LIKE ? ESCAPE '\'
+		ORDER BY ts_ms, id LIMIT ?;
+	`, roomID, sinceMS, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ArchivedMessage
+	for rows.Next() {
+		var m ArchivedMessage
+		if err := rows.Scan(&m.ID, &m.Sender, &m.Body, &m.MsgType, &m.TSMillis); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// RecentMessagesBy returns up to limit of sender's latest messages in a
+// room other than exceptID, newest first. Bot commands are left out.
+func RecentMessagesBy(database *sql.DB, roomID, sender, exceptID string, limit int) ([]ArchivedMessage, error) {
+	rows, err := database.Query(`
+		SELECT id, sender, body, COALESCE(msgtype, ''), ts_ms FROM messages
+		WHERE room_id = ? AND sender = ? AND id != ? AND body NOT LIKE '/bot %'
+		ORDER BY ts_ms DESC, id DESC LIMIT ?;
+	`, roomID, sender, exceptID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ArchivedMessage
+	for rows.Next() {
+		var m ArchivedMessage
+		if err := rows.Scan(&m.ID, &m.Sender, &m.Body, &m.MsgType, &m.TSMillis); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
 	}
+	return out, rows.Err()
+}
+
+// RoomLinksSince returns up to limit of the links posted in a room at or
+// after sinceMS, oldest first.
+func RoomLinksSince(database *sql.DB, roomID string, sinceMS int64, limit int) ([]LinkRow, error) {
 	rows, err := database.Query(`
-		SELECT m.room_id, l.message_id, l.url, l.ts_ms, m.sender
+		SELECT l.message_id, l.url, l.ts_ms, m.sender,
+			COALESCE(l.title, ''), COALESCE(l.status_code, 0), COALESCE(l.content_type, '')
 		FROM links l
 		JOIN messages m ON m.id = l.message_id
-		WHERE m.room_id IN (`+strings.Repeat("?,", len(rooms)-1)+`?)
-		ORDER BY m.room_id, l.ts_ms ASC, l.message_id, l.idx;
-	`, func() []any {
-		args := make([]any, len(rooms))
-		for i, r := range rooms {
-			args[i] = r.ID
-		}
-		return args
-	}()...)
+		WHERE m.room_id = ? AND l.ts_ms >= ?
+		ORDER BY l.ts_ms, l.message_id, l.idx
+		LIMIT ?;
+	`, roomID, sinceMS, limit)
 	if err != nil {
-		return fmt.Errorf("query links: %w", err)
+		return nil, err
 	}
 	defer rows.Close()
-	roomLinks := make(map[string][]LinkRow)
+	var out []LinkRow
 	for rows.Next() {
-		var roomID string
 		var r LinkRow
-		if err := rows.Scan(&roomID, &r.MessageID, &r.URL, &r.TSMillis, &r.Sender); err != nil {
-			return fmt.Errorf("scan link: %w", err)
+		if err := rows.Scan(&r.MessageID, &r.URL, &r.TSMillis, &r.Sender, &r.Title, &r.StatusCode, &r.ContentType); err != nil {
+			return nil, err
 		}
-		comment := roomMap[roomID]
-		roomLinks[comment] = append(roomLinks[comment], r)
+		out = append(out, r)
 	}
-	if err := rows.Err(); err != nil {
-		return err
+	return out, rows.Err()
+}
+
+// ThreadMessage is a stored message belonging to a thread.
+type ThreadMessage struct {
+	ID       string
+	Sender   string
+	Body     string
+	TSMillis int64
+}
+
+// ThreadMessages returns a thread's stored root and replies, oldest first.
+func ThreadMessages(database *sql.DB, roomID, rootID string) ([]ThreadMessage, error) {
+	rows, err := database.Query(`
+		SELECT id, sender, body, ts_ms FROM messages
+		WHERE room_id = ?
+		  AND (id = ? OR (
+		    CASE WHEN json_valid(raw_json) THEN json_extract(raw_json, '$."m.relates_to".rel_type') END = 'm.thread'
+		    AND CASE WHEN json_valid(raw_json) THEN json_extract(raw_json, '$."m.relates_to".event_id') END = ?))
+		ORDER BY ts_ms;
+	`, roomID, rootID, rootID)
+	if err != nil {
+		return nil, err
 	}
-	payload := struct {
-		LastSync time.Time            `json:"last_sync"`
-		Rooms    map[string][]LinkRow `json:"rooms"`
-	}{
-		LastSync: time.Now().UTC(),
-		Rooms:    roomLinks,
+	defer rows.Close()
+	var out []ThreadMessage
+	for rows.Next() {
+		var m ThreadMessage
+		if err := rows.Scan(&m.ID, &m.Sender, &m.Body, &m.TSMillis); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
 	}
-	file, err := os.Create(path)
+	return out, rows.Err()
+}
+
+// ThreadReplyCount returns how many stored messages reply in a thread.
+func ThreadReplyCount(database *sql.DB, roomID, rootID string) (int, error) {
+	var n int
+	err := database.QueryRow(`
+		SELECT COUNT(*) FROM messages
+		WHERE room_id = ?
+		  AND CASE WHEN json_valid(raw_json) THEN json_extract(raw_json, '$."m.relates_to".rel_type') END = 'm.thread'
+		  AND CASE WHEN json_valid(raw_json) THEN json_extract(raw_json, '$."m.relates_to".event_id') END = ?;
+	`, roomID, rootID).Scan(&n)
+	return n, err
+}
+
+// ClaimThreadDigest records that a summary of a thread is being offered. It
+// returns false if one already was.
+func ClaimThreadDigest(database *sql.DB, roomID, rootID string, ts int64) (bool, error) {
+	res, err := database.Exec(`
+		INSERT OR IGNORE INTO thread_digests(room_id, root_id, offered_at_ms) VALUES (?, ?, ?);
+	`, roomID, rootID, ts)
 	if err != nil {
-		return fmt.Errorf("create export file: %w", err)
-	}
-	defer file.Close()
-	enc := json.NewEncoder(file)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(payload); err != nil {
-		return fmt.Errorf("encode export: %w", err)
+		return false, err
 	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// SetThreadDigestPrompt stores the event ID of a thread's summary offer.
+func SetThreadDigestPrompt(database *sql.DB, roomID, rootID, promptID string) error {
+	_, err := database.Exec(`
+		UPDATE thread_digests SET prompt_id = ? WHERE room_id = ? AND root_id = ?;
+	`, promptID, roomID, rootID)
+	return err
+}
+
+// ClaimThreadDigestPost marks the summary offered by promptID as posted and
+// returns the thread's root. It returns "" if promptID isn't an offer or its
+// summary was already posted.
+func ClaimThreadDigestPost(database *sql.DB, roomID, promptID string, ts int64) (string, error) {
+	var rootID string
+	err := database.QueryRow(`
+		UPDATE thread_digests SET posted_at_ms = ?
+		WHERE room_id = ? AND prompt_id = ? AND posted_at_ms IS NULL
+		RETURNING root_id;
+	`, ts, roomID, promptID).Scan(&rootID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return rootID, err
+}
+
+// AnsweredQuestion is a stored question and the first reply to it by
+// someone else.
+type AnsweredQuestion struct {
+	ID       string
+	Sender   string
+	Body     string
+	TSMillis int64
+	AnswerID string
+}
+
+// AnsweredQuestions returns a room's messages since sinceMS that contain a
+// question mark and got a reply, or a thread reply, from someone other than
+// their sender, newest first.
+func AnsweredQuestions(database *sql.DB, roomID string, sinceMS int64, limit int) ([]AnsweredQuestion, error) {
+	rows, err := database.Query(`
+		WITH replies AS (
+			SELECT id, sender, ts_ms,
+				CASE WHEN json_valid(raw_json) THEN
+					CASE WHEN json_extract(raw_json, '$."m.relates_to".rel_type') = 'm.thread'
+						THEN json_extract(raw_json, '$."m.relates_to".event_id')
+						ELSE json_extract(raw_json, '$."m.relates_to"."m.in_reply_to".event_id')
+					END
+				END AS target
+			FROM messages
+			WHERE room_id = ? AND ts_ms >= ?
+		)
+		SELECT q.id, q.sender, q.body, q.ts_ms, r.id, MIN(r.ts_ms)
+		FROM messages q
+		JOIN replies r ON r.target = q.id AND r.sender != q.sender
+		WHERE q.room_id = ? AND q.ts_ms >= ? AND q.body LIKE '%?%' AND q.body NOT LIKE '/bot %'
+		GROUP BY q.id
+		ORDER BY q.ts_ms DESC
+		LIMIT ?;
+	`, roomID, sinceMS, roomID, sinceMS, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AnsweredQuestion
+	for rows.Next() {
+		var q AnsweredQuestion
+		var answerTS int64
+		if err := rows.Scan(&q.ID, &q.Sender, &q.Body, &q.TSMillis, &q.AnswerID, &answerTS); err != nil {
+			return nil, err
+		}
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+// ---------------------------------------------------------------------------
+// Glossary
+// ---------------------------------------------------------------------------
+
+// SetGlossaryEntry adds or replaces a room's definition of term.
+func SetGlossaryEntry(database *sql.DB, roomID, term, definition, addedBy string, ts int64) error {
+	_, err := database.Exec(`
+		INSERT OR REPLACE INTO glossary(room_id, term, definition, added_by, updated_at_ms)
+		VALUES (?, ?, ?, ?, ?);
+	`, roomID, term, definition, addedBy, ts)
+	return err
+}
+
+// DeleteGlossaryEntry removes a room's definition of term, reporting whether
+// there was one.
+func DeleteGlossaryEntry(database *sql.DB, roomID, term string) (bool, error) {
+	res, err := database.Exec(`DELETE FROM glossary WHERE room_id = ? AND term = ?`, roomID, term)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GlossaryDefinition returns a room's definition of term, or "" if it has
+// none.
+func GlossaryDefinition(database *sql.DB, roomID, term string) (string, error) {
+	var def string
+	err := database.QueryRow(`SELECT definition FROM glossary WHERE room_id = ? AND term = ?`, roomID, term).Scan(&def)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return def, err
+}
+
+// GlossaryTerms returns the terms a room has defined, sorted.
+func GlossaryTerms(database *sql.DB, roomID string) ([]string, error) {
+	rows, err := database.Query(`SELECT term FROM glossary WHERE room_id = ? ORDER BY term`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var terms []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	return terms, rows.Err()
+}
+
+// ---------------------------------------------------------------------------
+// Game scores
+// ---------------------------------------------------------------------------
+
+// GameScore is a user's total points in a game over some period.
+type GameScore struct {
+	UserID string
+	Points int
+}
+
+// AddGameAttempt records a play for the user on day and returns how many
+// plays they have made that day, this one included.
+func AddGameAttempt(database *sql.DB, roomID, userID, game, day string) (int, error) {
+	var n int
+	err := database.QueryRow(`
+		INSERT INTO game_attempts(room_id, user_id, game, day, attempts) VALUES (?, ?, ?, ?, 1)
+		ON CONFLICT(room_id, user_id, game, day) DO UPDATE SET attempts = attempts + 1
+		RETURNING attempts;
+	`, roomID, userID, game, day).Scan(&n)
+	return n, err
+}
+
+// AddGamePoints awards points to a user.
+func AddGamePoints(database *sql.DB, roomID, userID, game string, points int, ts int64) error {
+	_, err := database.Exec(`
+		INSERT INTO game_scores(room_id, user_id, game, points, ts_ms) VALUES (?, ?, ?, ?, ?);
+	`, roomID, userID, game, points, ts)
+	return err
+}
+
+// GameScores returns each user's points in a room's game between fromMS and
+// toMS, highest first; ties go to whoever scored first.
+func GameScores(database *sql.DB, roomID, game string, fromMS, toMS int64) ([]GameScore, error) {
+	rows, err := database.Query(`
+		SELECT user_id, SUM(points) AS total
+		FROM game_scores
+		WHERE room_id = ? AND game = ? AND ts_ms >= ? AND ts_ms < ?
+		GROUP BY user_id
+		ORDER BY total DESC, MAX(ts_ms), user_id;
+	`, roomID, game, fromMS, toMS)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []GameScore
+	for rows.Next() {
+		var s GameScore
+		if err := rows.Scan(&s.UserID, &s.Points); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// GameRooms returns the rooms where a game was scored between fromMS and toMS.
+func GameRooms(database *sql.DB, game string, fromMS, toMS int64) ([]string, error) {
+	rows, err := database.Query(`
+		SELECT DISTINCT room_id FROM game_scores WHERE game = ? AND ts_ms >= ? AND ts_ms < ?;
+	`, game, fromMS, toMS)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var roomID string
+		if err := rows.Scan(&roomID); err != nil {
+			return nil, err
+		}
+		out = append(out, roomID)
+	}
+	return out, rows.Err()
+}
+
+// ClaimWeeklyPost records that a game's winners for week are being posted
+// in a room. It returns false if they already were.
+func ClaimWeeklyPost(database *sql.DB, roomID, game, week string, ts int64) (bool, error) {
+	res, err := database.Exec(`
+		INSERT OR IGNORE INTO game_weekly_posts(room_id, game, week, ts_ms) VALUES (?, ?, ?, ?);
+	`, roomID, game, week, ts)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ---------------------------------------------------------------------------
+// Link snapshots
+// ---------------------------------------------------------------------------
+
+// PendingLink is a stored link that hasn't been enriched yet.
+type PendingLink struct {
+	MessageID string
+	URL       string
+	Idx       int
+}
+
+// PendingLinks returns up to limit links without metadata, newest first.
+func PendingLinks(database *sql.DB, limit int) ([]PendingLink, error) {
+	rows, err := database.Query(`
+		SELECT message_id, url, idx FROM links
+		WHERE enriched_at_ms IS NULL
+		ORDER BY ts_ms DESC
+		LIMIT ?;
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []PendingLink
+	for rows.Next() {
+		var l PendingLink
+		if err := rows.Scan(&l.MessageID, &l.URL, &l.Idx); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// SetLinkMetadata stores fetched metadata for a link. An empty title leaves
+// the column NULL.
+func SetLinkMetadata(database *sql.DB, l PendingLink, title string, statusCode int, contentType string, ts int64) error {
+	_, err := database.Exec(`
+		UPDATE links SET title = NULLIF(?, ''), status_code = ?, content_type = NULLIF(?, ''), enriched_at_ms = ?
+		WHERE message_id = ? AND url = ? AND idx = ?;
+	`, title, statusCode, contentType, ts, l.MessageID, l.URL, l.Idx)
+	return err
+}
+
+// LinkRoomIDs returns the rooms that have stored links.
+func LinkRoomIDs(database *sql.DB) ([]string, error) {
+	rows, err := database.Query(`
+		SELECT DISTINCT m.room_id FROM links l JOIN messages m ON m.id = l.message_id;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var roomID string
+		if err := rows.Scan(&roomID); err != nil {
+			return nil, err
+		}
+		out = append(out, roomID)
+	}
+	return out, rows.Err()
+}
+
+// LinkRow represents a link entry for JSON export. Title, StatusCode and
+// ContentType are empty until the link enricher has fetched the page.
+type LinkRow struct {
+	MessageID   string `json:"message_id"`
+	URL         string `json:"url"`
+	TSMillis    int64  `json:"ts_ms"`
+	Sender      string `json:"sender"`
+	Title       string `json:"title,omitempty"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// ExportAllSnapshots exports all links from monitored rooms to a JSON file,
+// streaming rows from the database into the file rather than holding them.
+func ExportAllSnapshots(database *sql.DB, rooms []config.RoomIDEntry, path string) error {
+	return writeSnapshot(path, func(sw *snapshotWriter) error {
+		if len(rooms) == 0 {
+			return nil
+		}
+		// Rooms are matched to their comments in SQL, so rows arrive grouped
+		// by comment in the order the export lists them.
+		args := make([]any, 0, 2*len(rooms))
+		seen := make(map[string]bool)
+		for _, r := range rooms {
+			if !seen[r.ID] {
+				seen[r.ID] = true
+				args = append(args, r.ID, r.Comment)
+			}
+		}
+		rows, err := database.Query(`
+			WITH rooms(id, comment) AS (VALUES `+strings.Repeat("(?, ?),", len(seen)-1)+`(?, ?))
+			SELECT r.comment, l.message_id, l.url, l.ts_ms, m.sender,
+				COALESCE(l.title, ''), COALESCE(l.status_code, 0), COALESCE(l.content_type, '')
+			FROM links l
+			JOIN messages m ON m.id = l.message_id
+			JOIN rooms r ON r.id = m.room_id
+			ORDER BY r.comment, l.ts_ms ASC, l.message_id, l.idx;
+		`, args...)
+		if err != nil {
+			return fmt.Errorf("query links: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var comment string
+			var r LinkRow
+			if err := rows.Scan(&comment, &r.MessageID, &r.URL, &r.TSMillis, &r.Sender, &r.Title, &r.StatusCode, &r.ContentType); err != nil {
+				return fmt.Errorf("scan link: %w", err)
+			}
+			if err := sw.add(comment, r); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// LinkSnapshot remembers the state of the links a previous Export wrote, so
+// Export can skip rewriting the file when nothing was stored, enriched or
+// deleted since. Only that state is kept in memory; the links themselves are
+// streamed from the database as ExportAllSnapshots does. It is safe for
+// concurrent use.
+type LinkSnapshot struct {
+	mu      sync.Mutex
+	path    string
+	rooms   string // the monitored room IDs the last export held
+	state   linkState
+	written bool
+}
+
+// linkState summarizes the exported links: a new row raises maxRowID,
+// enrichment raises maxEnriched and a deletion lowers count.
+type linkState struct {
+	count       int64
+	maxRowID    int64
+	maxEnriched int64
+}
+
+// NewLinkSnapshot returns a LinkSnapshot whose first Export writes the file.
+func NewLinkSnapshot() *LinkSnapshot {
+	return &LinkSnapshot{}
+}
+
+// Invalidate makes the next Export rewrite the file even if the links look
+// unchanged.
+func (s *LinkSnapshot) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = false
+}
+
+// Export writes the links of rooms to path, unless the file already holds
+// them as they are in the database.
+func (s *LinkSnapshot) Export(database *sql.DB, rooms []config.RoomIDEntry, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(rooms))
+	for _, r := range rooms {
+		ids = append(ids, r.ID+"="+r.Comment)
+	}
+	slices.Sort(ids)
+	key := strings.Join(ids, ",")
+	state, err := currentLinkState(database, rooms)
+	if err != nil {
+		return err
+	}
+	if s.written && s.path == path && s.rooms == key && s.state == state {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+	}
+	s.written = false
+	if err := ExportAllSnapshots(database, rooms, path); err != nil {
+		return err
+	}
+	s.path, s.rooms, s.state, s.written = path, key, state, true
+	return nil
+}
+
+// currentLinkState reads the linkState of the links in rooms.
+func currentLinkState(database *sql.DB, rooms []config.RoomIDEntry) (linkState, error) {
+	var st linkState
+	if len(rooms) == 0 {
+		return st, nil
+	}
+	args := make([]any, 0, len(rooms))
+	for _, r := range rooms {
+		args = append(args, r.ID)
+	}
+	err := database.QueryRow(`
+		SELECT COUNT(*), COALESCE(MAX(l.rowid), 0), COALESCE(MAX(l.enriched_at_ms), 0)
+		FROM links l
+		JOIN messages m ON m.id = l.message_id
+		WHERE m.room_id IN (`+strings.Repeat("?,", len(rooms)-1)+`?);
+	`, args...).Scan(&st.count, &st.maxRowID, &st.maxEnriched)
+	if err != nil {
+		return st, fmt.Errorf("query link state: %w", err)
+	}
+	return st, nil
+}
+
+// writeSnapshot writes the links export file from the links fill adds,
+// through a temporary file so a failed or interrupted export never leaves a
+// truncated one behind.
+func writeSnapshot(path string, fill func(sw *snapshotWriter) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create export file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		return fmt.Errorf("create export file: %w", err)
+	}
+	sw, err := newSnapshotWriter(file, time.Now().UTC())
+	if err == nil {
+		err = fill(sw)
+	}
+	if err == nil {
+		err = sw.close()
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("encode export: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("write export: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("replace export file: %w", err)
+	}
+	return nil
+}
+
+// snapshotWriter streams the links export one link at a time, laid out as
+// encoding {"last_sync", "rooms": {comment: [LinkRow]}} with two-space
+// indentation would. Links must be added grouped by room comment, in
+// comment order.
+type snapshotWriter struct {
+	w     *bufio.Writer
+	room  string
+	rooms int // rooms started
+	links int // links written in the current room
+}
+
+func newSnapshotWriter(w io.Writer, lastSync time.Time) (*snapshotWriter, error) {
+	ts, err := json.Marshal(lastSync)
+	if err != nil {
+		return nil, err
+	}
+	sw := &snapshotWriter{w: bufio.NewWriter(w)}
+	fmt.Fprintf(sw.w, "{\n  \"last_sync\": %s,\n  \"rooms\": {", ts)
+	return sw, nil
+}
+
+// add writes the next link of the room with the given comment.
+func (sw *snapshotWriter) add(comment string, r LinkRow) error {
+	if sw.rooms == 0 || comment != sw.room {
+		key, err := json.Marshal(comment)
+		if err != nil {
+			return err
+		}
+		if sw.rooms > 0 {
+			sw.w.WriteString("\n    ],")
+		}
+		fmt.Fprintf(sw.w, "\n    %s: [", key)
+		sw.room, sw.links = comment, 0
+		sw.rooms++
+	}
+	row, err := json.MarshalIndent(r, "      ", "  ")
+	if err != nil {
+		return err
+	}
+	if sw.links > 0 {
+		sw.w.WriteByte(',')
+	}
+	sw.w.WriteString("\n      ")
+	sw.w.Write(row)
+	sw.links++
 	return nil
 }
+
+// close ends the export and flushes it.
+func (sw *snapshotWriter) close() error {
+	if sw.rooms > 0 {
+		sw.w.WriteString("\n    ]\n  ")
+	}
+	sw.w.WriteString("}\n}\n")
+	return sw.w.Flush()
+}
+
+// ---------------------------------------------------------------------------
+// Room statistics
+// ---------------------------------------------------------------------------
+
+// Count is a number of messages for a day, sender or command.
+type Count struct {
+	Key string
+	N   int
+}
+
+// queryCounts runs a query returning (key, count) rows.
+func queryCounts(database *sql.DB, query string, args ...any) ([]Count, error) {
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Count
+	for rows.Next() {
+		var c Count
+		if err := rows.Scan(&c.Key, &c.N); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// DailyMessageCounts returns a room's messages per day (YYYY-MM-DD) since
+// sinceMS, oldest first. Days start at UTC plus offsetMinutes.
+func DailyMessageCounts(database *sql.DB, roomID string, sinceMS int64, offsetMinutes int) ([]Count, error) {
+	return queryCounts(database, `
+		SELECT strftime('%Y-%m-%d', ts_ms / 1000, 'unixepoch', ? || ' minutes') AS day, COUNT(*)
+		FROM messages
+		WHERE room_id = ? AND ts_ms >= ?
+		GROUP BY day ORDER BY day;
+	`, fmt.Sprintf("%+d", offsetMinutes), roomID, sinceMS)
+}
+
+// TopSenders returns the senders of the most messages in a room since
+// sinceMS. Bot commands don't count.
+func TopSenders(database *sql.DB, roomID string, sinceMS int64, limit int) ([]Count, error) {
+	return queryCounts(database, `
+		SELECT sender, COUNT(*) AS n
+		FROM messages
+		WHERE room_id = ? AND ts_ms >= ? AND body NOT LIKE '/bot %'
+		GROUP BY sender ORDER BY n DESC, sender LIMIT ?;
+	`, roomID, sinceMS, limit)
+}
+
+// DailyLinkCounts returns the links posted in a room per day since sinceMS,
+// like DailyMessageCounts.
+func DailyLinkCounts(database *sql.DB, roomID string, sinceMS int64, offsetMinutes int) ([]Count, error) {
+	return queryCounts(database, `
+		SELECT strftime('%Y-%m-%d', l.ts_ms / 1000, 'unixepoch', ? || ' minutes') AS day, COUNT(*)
+		FROM links l
+		JOIN messages m ON m.id = l.message_id
+		WHERE m.room_id = ? AND l.ts_ms >= ?
+		GROUP BY day ORDER BY day;
+	`, fmt.Sprintf("%+d", offsetMinutes), roomID, sinceMS)
+}
+
+// DailyCommandCounts returns the /bot commands used in a room per day since
+// sinceMS, like DailyMessageCounts.
+func DailyCommandCounts(database *sql.DB, roomID string, sinceMS int64, offsetMinutes int) ([]Count, error) {
+	return queryCounts(database, `
+		SELECT strftime('%Y-%m-%d', ts_ms / 1000, 'unixepoch', ? || ' minutes') AS day, COUNT(*)
+		FROM messages
+		WHERE room_id = ? AND ts_ms >= ? AND body LIKE '/bot %'
+		GROUP BY day ORDER BY day;
+	`, fmt.Sprintf("%+d", offsetMinutes), roomID, sinceMS)
+}
+
+// CommandCounts returns how often each /bot command was used in a room since
+// sinceMS, most used first.
+func CommandCounts(database *sql.DB, roomID string, sinceMS int64) ([]Count, error) {
+	return CommandCountsBetween(database, roomID, sinceMS, math.MaxInt64)
+}
+
+// CommandCountsBetween is CommandCounts for commands used before untilMS.
+func CommandCountsBetween(database *sql.DB, roomID string, sinceMS, untilMS int64) ([]Count, error) {
+	return queryCounts(database, `
+		SELECT lower(CASE WHEN instr(rest, ' ') > 0 THEN substr(rest, 1, instr(rest, ' ') - 1) ELSE rest END) AS cmd, COUNT(*) AS n
+		FROM (SELECT trim(substr(body, 6)) AS rest FROM messages WHERE room_id = ? AND ts_ms >= ? AND ts_ms < ? AND body LIKE '/bot %')
+		WHERE rest != ''
+		GROUP BY cmd ORDER BY n DESC, cmd;
+	`, roomID, sinceMS, untilMS)
+}
+
+// RecentLinks returns a room's most recently posted links, newest first.
+func RecentLinks(database *sql.DB, roomID string, limit int) ([]LinkRow, error) {
+	rows, err := database.Query(`
+		SELECT l.message_id, l.url, l.ts_ms, m.sender,
+			COALESCE(l.title, ''), COALESCE(l.status_code, 0), COALESCE(l.content_type, '')
+		FROM links l
+		JOIN messages m ON m.id = l.message_id
+		WHERE m.room_id = ?
+		ORDER BY l.ts_ms DESC, l.message_id, l.idx
+		LIMIT ?;
+	`, roomID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []LinkRow
+	for rows.Next() {
+		var r LinkRow
+		if err := rows.Scan(&r.MessageID, &r.URL, &r.TSMillis, &r.Sender, &r.Title, &r.StatusCode, &r.ContentType); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// ---------------------------------------------------------------------------
+// Feeds
+// ---------------------------------------------------------------------------
+
+// FeedSub is a room's subscription to an RSS or Atom feed.
+type FeedSub struct {
+	RoomID    string
+	URL       string
+	Title     string
+	AddedBy   string
+	PolledMS  int64
+	LastError string
+}
+
+// AddFeed subscribes a room to a feed, or updates its title if it already
+// is. The items passed in are marked as seen so only later ones are posted.
+func AddFeed(database *sql.DB, sub FeedSub, guids []string, ts int64) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		INSERT INTO feeds(room_id, url, title, added_by, ts_ms, polled_ms) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(room_id, url) DO UPDATE SET title = excluded.title;
+	`, sub.RoomID, sub.URL, sub.Title, sub.AddedBy, ts, ts); err != nil {
+		return err
+	}
+	for _, g := range guids {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO feed_items(room_id, url, guid, ts_ms) VALUES (?, ?, ?, ?)`,
+			sub.RoomID, sub.URL, g, ts); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveFeed unsubscribes a room from a feed and forgets its items. It
+// reports whether the room was subscribed.
+func RemoveFeed(database *sql.DB, roomID, url string) (bool, error) {
+	res, err := database.Exec(`DELETE FROM feeds WHERE room_id = ? AND url = ?`, roomID, url)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = database.Exec(`DELETE FROM feed_items WHERE room_id = ? AND url = ?`, roomID, url)
+	return true, err
+}
+
+// Feeds returns a room's subscriptions in the order they were added, or
+// every room's with an empty roomID.
+func Feeds(database *sql.DB, roomID string) ([]FeedSub, error) {
+	rows, err := database.Query(`
+		SELECT room_id, url, COALESCE(title, ''), COALESCE(added_by, ''), COALESCE(polled_ms, 0), COALESCE(last_error, '')
+		FROM feeds WHERE ? = '' OR room_id = ?
+		ORDER BY ts_ms, url;
+	`, roomID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []FeedSub
+	for rows.Next() {
+		var f FeedSub
+		if err := rows.Scan(&f.RoomID, &f.URL, &f.Title, &f.AddedBy, &f.PolledMS, &f.LastError); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// ClaimFeedItem marks a feed item as seen in a room, returning true only the
+// first time, so each item is posted once.
+func ClaimFeedItem(database *sql.DB, roomID, url, guid string, ts int64) (bool, error) {
+	res, err := database.Exec(`
+		INSERT OR IGNORE INTO feed_items(room_id, url, guid, ts_ms) VALUES (?, ?, ?, ?);
+	`, roomID, url, guid, ts)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// SetFeedPolled records when a feed was last fetched and the error, if any.
+func SetFeedPolled(database *sql.DB, roomID, url string, ts int64, pollErr string) error {
+	_, err := database.Exec(`UPDATE feeds SET polled_ms = ?, last_error = ? WHERE room_id = ? AND url = ?`,
+		ts, pollErr, roomID, url)
+	return err
+}
+
+// ---------------------------------------------------------------------------
+// Crossposts
+// ---------------------------------------------------------------------------
+
+// ClaimCrosspost records that url is being crossposted into linksRoom,
+// returning false if it already was at or after sinceMS.
+func ClaimCrosspost(database *sql.DB, linksRoom, url, roomID, messageID string, ts, sinceMS int64) (bool, error) {
+	res, err := database.Exec(`
+		INSERT INTO crossposts(links_room, url, room_id, message_id, ts_ms) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(links_room, url) DO UPDATE SET
+			room_id = excluded.room_id, message_id = excluded.message_id, ts_ms = excluded.ts_ms
+		WHERE crossposts.ts_ms < ?;
+	`, linksRoom, url, roomID, messageID, ts, sinceMS)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ---------------------------------------------------------------------------
+// HTTP command cache
+// ---------------------------------------------------------------------------
+
+// HTTPCacheEntry is a cached http command response.
+type HTTPCacheEntry struct {
+	Body        []byte
+	ContentType string
+	ExpiresMS   int64
+}
+
+// HTTPCacheGet returns the cached response for key if it hasn't expired
+// by nowMS.
+func HTTPCacheGet(database *sql.DB, key string, nowMS int64) (HTTPCacheEntry, bool, error) {
+	var e HTTPCacheEntry
+	err := database.QueryRow(`
+		SELECT body, COALESCE(content_type, ''), expires_ms FROM http_cache
+		WHERE key = ? AND expires_ms > ?;
+	`, key, nowMS).Scan(&e.Body, &e.ContentType, &e.ExpiresMS)
+	if errors.Is(err, sql.ErrNoRows) {
+		return HTTPCacheEntry{}, false, nil
+	}
+	if err != nil {
+		return HTTPCacheEntry{}, false, err
+	}
+	return e, true, nil
+}
+
+// HTTPCachePut stores a response under key, dropping expired entries.
+func HTTPCachePut(database *sql.DB, key string, e HTTPCacheEntry, nowMS int64) error {
+	return RetryBusy(context.Background(), func() error {
+		if _, err := database.Exec(`DELETE FROM http_cache WHERE expires_ms <= ?`, nowMS); err != nil {
+			return err
+		}
+		_, err := database.Exec(`
+			INSERT INTO http_cache(key, body, content_type, expires_ms) VALUES (?, ?, ?, ?)
+			ON CONFLICT(key) DO UPDATE SET body = excluded.body, content_type = excluded.content_type, expires_ms = excluded.expires_ms;
+		`, key, e.Body, e.ContentType, e.ExpiresMS)
+		return err
+	})
+}
+
+// ---------------------------------------------------------------------------
+// User data (/bot mydata)
+// ---------------------------------------------------------------------------
+
+// userDataQueries select what is stored about a user, by section of the
+// export. Each takes the user ID once. The sealed AI key isn't exported,
+// only when it was set.
+var userDataQueries = []struct{ name, query string }{
+	{"messages", `SELECT id, room_id, ts_ms, msgtype, body, raw_json FROM messages WHERE sender = ? ORDER BY ts_ms, id`},
+	{"links", `SELECT l.message_id, l.url, l.title, l.ts_ms FROM links l JOIN messages m ON m.id = l.message_id WHERE m.sender = ? ORDER BY l.ts_ms, l.message_id, l.idx`},
+	{"reactions", `SELECT message_id, room_id, emoji, created_at_ms FROM reactions WHERE reactor = ? ORDER BY created_at_ms`},
+	{"quotewall", `SELECT room_id, target_message, target_ts_ms, logged_by, logged_at_ms FROM quotewall WHERE target_user = ? ORDER BY target_ts_ms`},
+	{"yap_history", `SELECT room_id, day, rank, words FROM yap_history WHERE sender = ? ORDER BY day, room_id`},
+	{"game_scores", `SELECT room_id, game, points, ts_ms FROM game_scores WHERE user_id = ? ORDER BY ts_ms`},
+	{"game_attempts", `SELECT room_id, game, day, attempts FROM game_attempts WHERE user_id = ? ORDER BY day, room_id`},
+	{"ai_log", `SELECT room_id, command, model, prompt, response, error, ts_ms FROM ai_log WHERE user_id = ? ORDER BY ts_ms`},
+	{"ai_log_consent", `SELECT consent, ts_ms FROM ai_log_consent WHERE user_id = ?`},
+	{"ai_usage", `SELECT day, own_key, requests, tokens FROM ai_usage WHERE user_id = ? ORDER BY day`},
+	{"ai_keys", `SELECT ts_ms FROM ai_keys WHERE user_id = ?`},
+}
+
+// ExportUserData returns the rows stored about userID, by section, as
+// column name to value maps. Sections with no rows are left out.
+func ExportUserData(database *sql.DB, userID string) (map[string][]map[string]any, error) {
+	out := make(map[string][]map[string]any)
+	for _, q := range userDataQueries {
+		rows, err := database.Query(q.query, userID)
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", q.name, err)
+		}
+		cols, err := rows.Columns()
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		for rows.Next() {
+			values := make([]any, len(cols))
+			ptrs := make([]any, len(cols))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			row := make(map[string]any, len(cols))
+			for i, col := range cols {
+				// The event content is stored as JSON; keep it that way.
+				if s, ok := values[i].(string); ok && col == "raw_json" && json.Valid([]byte(s)) {
+					values[i] = json.RawMessage(s)
+				}
+				row[col] = values[i]
+			}
+			out[q.name] = append(out[q.name], row)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", q.name, err)
+		}
+	}
+	return out, nil
+}
+
+// userDataDeletes remove what is stored about a user, messages last since
+// the others find rows by the user's message IDs. Each takes the user ID
+// once. Reactions to the user's messages go too; moderation records,
+// ignore-list entries and what the user added to glossaries and feeds stay.
+var userDataDeletes = []string{
+	`DELETE FROM links WHERE message_id IN (SELECT id FROM messages WHERE sender = ?)`,
+	`DELETE FROM reactions WHERE message_id IN (SELECT id FROM messages WHERE sender = ?)`,
+	`DELETE FROM reactions WHERE reactor = ?`,
+	`DELETE FROM crossposts WHERE message_id IN (SELECT id FROM messages WHERE sender = ?)`,
+	`DELETE FROM debug_events WHERE event_id IN (SELECT id FROM messages WHERE sender = ?)`,
+	`DELETE FROM quotewall WHERE target_user = ?`,
+	`DELETE FROM yap_history WHERE sender = ?`,
+	`DELETE FROM game_scores WHERE user_id = ?`,
+	`DELETE FROM game_attempts WHERE user_id = ?`,
+	`DELETE FROM ai_log WHERE user_id = ?`,
+	`DELETE FROM ai_log_consent WHERE user_id = ?`,
+	`DELETE FROM ai_usage WHERE user_id = ?`,
+	`DELETE FROM ai_keys WHERE user_id = ?`,
+	`DELETE FROM messages WHERE sender = ?`,
+}
+
+// DeleteUserData removes what is stored about userID in one transaction and
+// returns how many of their messages were deleted.
+func DeleteUserData(database *sql.DB, userID string) (int64, error) {
+	var messages int64
+	err := RetryBusy(context.Background(), func() error {
+		tx, err := database.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for _, q := range userDataDeletes {
+			res, err := tx.Exec(q, userID)
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(q, "DELETE FROM messages ") {
+				if messages, err = res.RowsAffected(); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Commit()
+	})
+	return messages, err
+}
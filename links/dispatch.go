@@ -0,0 +1,180 @@
+package links
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/polarhive/ash/util"
+	"github.com/polarhive/ash/version"
+)
+
+// HookLink is a link queued for a hook, with what its payload credits.
+type HookLink struct {
+	URL         string
+	Sender      string
+	SenderName  string
+	RoomID      string
+	RoomComment string
+	SendUser    bool
+	SendTopic   bool
+}
+
+// hookEndpoint is a hook URL and the key it's called with; links are
+// batched per endpoint.
+type hookEndpoint struct {
+	url string
+	key string
+}
+
+// hookQueue holds an endpoint's pending links. sending serializes its
+// requests so one endpoint never gets several at once.
+type hookQueue struct {
+	pending []HookLink
+	timer   *time.Timer
+	sending sync.Mutex
+}
+
+// Dispatcher sends links to their hooks over util.HTTPClient. With a
+// batch size above 1, an endpoint's links are collected for up to the batch
+// interval and posted together as a JSON array of HookPayload objects; a
+// repeated link in a batch is sent once.
+type Dispatcher struct {
+	batchSize int
+	interval  time.Duration
+
+	mu     sync.Mutex
+	queues map[hookEndpoint]*hookQueue
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a Dispatcher. A batch size below 1 sends every link
+// on its own, as soon as it's queued; a zero interval falls back to 2
+// seconds.
+func NewDispatcher(batchSize int, interval time.Duration) *Dispatcher {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	return &Dispatcher{
+		batchSize: batchSize,
+		interval:  interval,
+		queues:    make(map[hookEndpoint]*hookQueue),
+	}
+}
+
+// Enqueue queues a link for hookURL. It never waits for the request.
+func (d *Dispatcher) Enqueue(hookURL, key string, l HookLink) {
+	ep := hookEndpoint{url: hookURL, key: key}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	q := d.queues[ep]
+	if q == nil {
+		q = &hookQueue{}
+		d.queues[ep] = q
+	}
+	for _, p := range q.pending {
+		if p.URL == l.URL && p.RoomID == l.RoomID {
+			return
+		}
+	}
+	q.pending = append(q.pending, l)
+	if len(q.pending) >= d.batchSize {
+		d.flushLocked(ep, q)
+		return
+	}
+	if q.timer == nil {
+		q.timer = time.AfterFunc(d.interval, func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.flushLocked(ep, q)
+		})
+	}
+}
+
+// flushLocked sends q's pending links in the background. d.mu must be held.
+func (d *Dispatcher) flushLocked(ep hookEndpoint, q *hookQueue) {
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	batch := q.pending
+	q.pending = nil
+	if len(batch) == 0 {
+		return
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		q.sending.Lock()
+		defer q.sending.Unlock()
+		d.send(ep, batch)
+	}()
+}
+
+// Close sends every pending link and waits for all requests to finish.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	for ep, q := range d.queues {
+		d.flushLocked(ep, q)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// send resolves and posts a batch of links to one endpoint.
+func (d *Dispatcher) send(ep hookEndpoint, batch []HookLink) {
+	payloads := make([]json.RawMessage, 0, len(batch))
+	for _, l := range batch {
+		p, err := HookPayload(resolveURL(l.URL), l.Sender, l.SenderName, l.RoomID, l.RoomComment, l.SendUser, l.SendTopic)
+		if err != nil {
+			log.Error().Err(err).Str("hook_url", ep.url).Str("link", l.URL).Msg("failed to marshal hook payload")
+			continue
+		}
+		payloads = append(payloads, p)
+	}
+	if len(payloads) == 0 {
+		return
+	}
+	body := []byte(payloads[0])
+	if d.batchSize > 1 {
+		var err error
+		if body, err = json.Marshal(payloads); err != nil {
+			log.Error().Err(err).Str("hook_url", ep.url).Msg("failed to marshal hook batch")
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", ep.url, bytes.NewReader(body))
+	if err != nil {
+		log.Error().Err(err).Str("hook_url", ep.url).Msg("failed to create hook request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	if ep.key != "" {
+		req.Header.Set("Authorization", "Bearer "+ep.key)
+	}
+	resp, err := util.HTTPClient.Do(req)
+	if err != nil {
+		log.Error().Err(err).Str("hook_url", ep.url).Int("links", len(payloads)).Msg("failed to send hook")
+		return
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection is reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 300 {
+		log.Warn().Int("status", resp.StatusCode).Str("hook_url", ep.url).Int("links", len(payloads)).Msg("hook response not ok")
+	} else {
+		log.Info().Str("hook_url", ep.url).Int("links", len(payloads)).Msg("hook sent successfully")
+	}
+}
@@ -0,0 +1,75 @@
+package links
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcher(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			return // link resolution
+		}
+		if r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	link := func(path string) HookLink { return HookLink{URL: srv.URL + path, RoomID: "!r:x"} }
+
+	d := NewDispatcher(3, 50*time.Millisecond)
+	d.Enqueue(srv.URL+"/hook", "key", link("/a"))
+	d.Enqueue(srv.URL+"/hook", "key", link("/b"))
+	d.Enqueue(srv.URL+"/hook", "key", link("/a"))
+	d.Enqueue(srv.URL+"/hook", "key", link("/c"))
+	d.Enqueue(srv.URL+"/hook", "key", link("/d"))
+	time.Sleep(150 * time.Millisecond)
+	d.Close()
+	mu.Lock()
+	if len(bodies) != 2 || strings.Count(bodies[0], `"url"`) != 3 || !strings.HasPrefix(bodies[0], "[") ||
+		strings.Count(bodies[1], `"url"`) != 1 || !strings.Contains(bodies[1], "/d") {
+		t.Errorf("batched bodies = %q", bodies)
+	}
+	bodies = nil
+	mu.Unlock()
+
+	d = NewDispatcher(0, 0)
+	d.Enqueue(srv.URL+"/hook", "key", link("/a"))
+	d.Enqueue(srv.URL+"/hook", "key", link("/b"))
+	d.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 || !strings.HasPrefix(bodies[0], `{"link"`) {
+		t.Errorf("unbatched bodies = %q", bodies)
+	}
+}
+
+func TestDispatcherCloseFlushes(t *testing.T) {
+	var n int
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			mu.Lock()
+			n++
+			mu.Unlock()
+		}
+	}))
+	defer srv.Close()
+	d := NewDispatcher(10, time.Hour)
+	d.Enqueue(srv.URL, "", HookLink{URL: srv.URL + "/a"})
+	d.Close()
+	if n != 1 {
+		t.Errorf("Close sent %d requests, want 1", n)
+	}
+}
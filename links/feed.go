@@ -0,0 +1,146 @@
+package links
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/polarhive/ash/version"
+)
+
+// maxFeedSize bounds how much of a feed is read.
+const maxFeedSize = 4 << 20
+
+// Feed is a parsed RSS or Atom feed.
+type Feed struct {
+	Title string
+	Items []FeedItem
+}
+
+// FeedItem is one entry of a feed. GUID identifies it across polls and falls
+// back to the link, then the title, for feeds that don't set one.
+type FeedItem struct {
+	GUID  string
+	Title string
+	Link  string
+}
+
+type rssItem struct {
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+	GUID  string `xml:"guid"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+type atomEntry struct {
+	Title string     `xml:"title"`
+	ID    string     `xml:"id"`
+	Links []atomLink `xml:"link"`
+}
+
+// xmlFeed covers RSS 2.0 (<rss><channel><item>), RSS 1.0 (<rdf:RDF> with
+// <item> next to <channel>) and Atom (<feed><entry>).
+type xmlFeed struct {
+	XMLName xml.Name
+	Title   string `xml:"title"`
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// ParseFeed parses an RSS or Atom document. Items are in document order,
+// which for most feeds is newest first.
+func ParseFeed(data []byte) (Feed, error) {
+	var f xmlFeed
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// Non-UTF-8 feeds are read as is; titles may come out mangled, but the
+	// links and GUIDs still work.
+	dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	dec.Strict = false
+	if err := dec.Decode(&f); err != nil {
+		return Feed{}, fmt.Errorf("invalid feed: %w", err)
+	}
+	var out Feed
+	switch strings.ToLower(f.XMLName.Local) {
+	case "rss", "rdf":
+		out.Title = cleanFeedText(f.Channel.Title)
+		for _, it := range append(f.Channel.Items, f.Items...) {
+			out.Items = append(out.Items, newFeedItem(it.GUID, it.Title, it.Link))
+		}
+	case "feed":
+		out.Title = cleanFeedText(f.Title)
+		for _, e := range f.Entries {
+			link := ""
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = l.Href
+					break
+				}
+			}
+			out.Items = append(out.Items, newFeedItem(e.ID, e.Title, link))
+		}
+	default:
+		return Feed{}, fmt.Errorf("not an RSS or Atom feed: <%s>", f.XMLName.Local)
+	}
+	return out, nil
+}
+
+func newFeedItem(guid, title, link string) FeedItem {
+	it := FeedItem{
+		GUID:  strings.TrimSpace(guid),
+		Title: cleanFeedText(title),
+		Link:  strings.TrimSpace(link),
+	}
+	if it.GUID == "" {
+		it.GUID = it.Link
+	}
+	if it.GUID == "" {
+		it.GUID = it.Title
+	}
+	return it
+}
+
+// cleanFeedText collapses whitespace and decodes entities left in titles
+// that were escaped twice.
+func cleanFeedText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// FetchFeed GETs and parses an RSS or Atom feed.
+func FetchFeed(ctx context.Context, client *http.Client, feedURL string) (Feed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return Feed{}, err
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return Feed{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Feed{}, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return Feed{}, err
+	}
+	if len(data) > maxFeedSize {
+		return Feed{}, errors.New("feed is too large")
+	}
+	return ParseFeed(data)
+}
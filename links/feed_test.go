@@ -0,0 +1,86 @@
+package links
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		title string
+		items []FeedItem
+	}{
+		{
+			"rss 2.0",
+			`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel><title>Ash  news</title>
+<item><title>Release 1.2 &amp;amp; more</title><link>https://example.com/1.2</link><guid isPermaLink="false">rel-1.2</guid></item>
+<item><title>No guid</title><link>https://example.com/old</link></item>
+</channel></rss>`,
+			"Ash news",
+			[]FeedItem{
+				{GUID: "rel-1.2", Title: "Release 1.2 & more", Link: "https://example.com/1.2"},
+				{GUID: "https://example.com/old", Title: "No guid", Link: "https://example.com/old"},
+			},
+		},
+		{
+			"atom",
+			`<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Blog</title>
+<entry><title type="html">Hello</title><id>tag:example.com,2026:1</id>
+<link rel="edit" href="https://example.com/edit/1"/><link href="https://example.com/hello"/></entry>
+</feed>`,
+			"Blog",
+			[]FeedItem{{GUID: "tag:example.com,2026:1", Title: "Hello", Link: "https://example.com/hello"}},
+		},
+		{
+			"rss 1.0",
+			`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/">
+<channel><title>Old school</title></channel>
+<item><title>First</title><link>https://example.com/first</link></item>
+</rdf:RDF>`,
+			"Old school",
+			[]FeedItem{{GUID: "https://example.com/first", Title: "First", Link: "https://example.com/first"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFeed([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Title != tt.title {
+				t.Errorf("title = %q, want %q", f.Title, tt.title)
+			}
+			if fmt.Sprint(f.Items) != fmt.Sprint(tt.items) {
+				t.Errorf("items = %+v, want %+v", f.Items, tt.items)
+			}
+		})
+	}
+	if _, err := ParseFeed([]byte("<html><body>not a feed</body></html>")); err == nil {
+		t.Error("expected an error for HTML")
+	}
+}
+
+func TestFetchFeed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<rss><channel><title>T</title><item><title>A</title><link>https://example.com/a</link></item></channel></rss>`)
+	}))
+	defer srv.Close()
+	f, err := FetchFeed(context.Background(), srv.Client(), srv.URL+"/feed.xml")
+	if err != nil || len(f.Items) != 1 || f.Items[0].Title != "A" {
+		t.Errorf("FetchFeed = %+v, %v", f, err)
+	}
+	if _, err := FetchFeed(context.Background(), srv.Client(), srv.URL+"/missing"); err == nil {
+		t.Error("expected an error for a 404")
+	}
+}
@@ -1,14 +1,22 @@
 package links
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"html"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
+
+	"github.com/polarhive/ash/util"
+	"github.com/polarhive/ash/version"
 )
 
 var urlRe = regexp.MustCompile(`(?i)https?://[^\s>]+`)
@@ -18,16 +26,19 @@ func ExtractLinks(text string) []string {
 	return urlRe.FindAllString(text, -1)
 }
 
-// SendHook posts a link to the configured webhook URL.
-func SendHook(hookURL, link, key, sender, roomID, roomComment string, sendUser, sendTopic bool) {
-	resolvedLink := resolveURL(link)
+// HookPayload builds the JSON body SendHook posts for a resolved link. With
+// sendUser it credits sender, and senderName when the sender has one.
+func HookPayload(link, sender, senderName, roomID, roomComment string, sendUser, sendTopic bool) ([]byte, error) {
 	payload := map[string]any{
 		"link": map[string]any{
-			"url": resolvedLink,
+			"url": link,
 		},
 	}
 	if sendUser {
 		payload["link"].(map[string]any)["submittedBy"] = sender
+		if senderName != "" {
+			payload["link"].(map[string]any)["submittedByName"] = senderName
+		}
 	}
 	if sendTopic && (roomID != "" || roomComment != "") {
 		payload["room"] = map[string]string{
@@ -35,41 +46,34 @@ func SendHook(hookURL, link, key, sender, roomID, roomComment string, sendUser,
 			"comment": roomComment,
 		}
 	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		log.Error().Err(err).Str("hook_url", hookURL).Str("link", link).Msg("failed to marshal hook payload")
-		return
-	}
-	req, err := http.NewRequest("POST", hookURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Error().Err(err).Str("hook_url", hookURL).Str("link", link).Msg("failed to create hook request")
-		return
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if key != "" {
-		req.Header.Set("Authorization", "Bearer "+key)
+	return json.Marshal(payload)
+}
+
+// LogHook logs the payload SendHook would post, for dry runs. The link is
+// resolved first, as SendHook does, only if resolve is set.
+func LogHook(hookURL, link, sender, senderName, roomID, roomComment string, sendUser, sendTopic, resolve bool) {
+	if resolve {
+		link = resolveURL(link)
 	}
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	jsonData, err := HookPayload(link, sender, senderName, roomID, roomComment, sendUser, sendTopic)
 	if err != nil {
-		log.Error().Err(err).Str("hook_url", hookURL).Str("link", link).Msg("failed to send hook")
+		log.Error().Err(err).Str("hook_url", hookURL).Str("link", link).Msg("failed to marshal hook payload")
 		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		log.Warn().Int("status", resp.StatusCode).Str("hook_url", hookURL).Str("link", link).Msg("hook response not ok")
-	} else {
-		log.Info().Str("hook_url", hookURL).Str("link", link).Msg("hook sent successfully")
-	}
+	log.Info().Str("hook_url", hookURL).RawJSON("payload", jsonData).Msg("dry run mode: hook not sent")
 }
 
-func resolveURL(url string) string {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+// resolveURL follows a link's redirects, returning it unchanged on error.
+func resolveURL(link string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, link, nil)
+	if err != nil {
+		return link
 	}
-	resp, err := client.Head(url)
+	resp, err := util.HTTPClient.Do(req)
 	if err != nil {
-		return url
+		return link
 	}
 	defer resp.Body.Close()
 	return resp.Request.URL.String()
@@ -113,3 +117,79 @@ func IsBlacklisted(url string, blacklist []*regexp.Regexp) bool {
 	}
 	return false
 }
+
+// CanonicalURL normalizes a link for duplicate detection: the scheme and
+// host are lowercased, and the fragment, a trailing slash and utm_* tracking
+// parameters are dropped. Links that don't parse are returned unchanged.
+func CanonicalURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment, u.RawFragment = "", ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			if strings.HasPrefix(strings.ToLower(k), "utm_") {
+				q.Del(k)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// Metadata is what FetchMetadata learns about a link.
+type Metadata struct {
+	StatusCode  int
+	ContentType string
+	Title       string
+}
+
+// maxTitleScan bounds how much of an HTML page is read looking for a title.
+const maxTitleScan = 256 << 10
+
+var (
+	titleRe   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	ogTitleRe = regexp.MustCompile(`(?is)<meta[^>]+property=["']og:title["'][^>]+content=["']([^"']*)["']`)
+)
+
+// FetchMetadata GETs a link and returns its final status code, content type
+// and, for HTML pages, its title.
+func FetchMetadata(ctx context.Context, client *http.Client, link string) (Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+	req.Header.Set("Accept", "text/html,*/*;q=0.8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+	meta := Metadata{StatusCode: resp.StatusCode}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		meta.ContentType = mediaType
+	}
+	if meta.ContentType == "text/html" || meta.ContentType == "application/xhtml+xml" {
+		page, _ := io.ReadAll(io.LimitReader(resp.Body, maxTitleScan))
+		meta.Title = ExtractTitle(page)
+	}
+	return meta, nil
+}
+
+// ExtractTitle returns the page's <title>, falling back to og:title.
+func ExtractTitle(page []byte) string {
+	var title string
+	if m := titleRe.FindSubmatch(page); m != nil {
+		title = string(m[1])
+	} else if m := ogTitleRe.FindSubmatch(page); m != nil {
+		title = string(m[1])
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(title)), " ")
+}
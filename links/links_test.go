@@ -43,3 +43,56 @@ func TestIsBlacklisted(t *testing.T) {
 	// Just verify it doesn't crash with a normal URL
 	_ = IsBlacklisted("https://example.com", blacklist)
 }
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"https://Example.COM/post/", "https://example.com/post"},
+		{"HTTPS://example.com/a?utm_source=x&b=2&a=1#top", "https://example.com/a?a=1&b=2"},
+		{"https://example.com/?utm_medium=social", "https://example.com"},
+		{"not a url", "not a url"},
+	}
+	for _, tt := range tests {
+		if got := CanonicalURL(tt.in); got != tt.want {
+			t.Errorf("CanonicalURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExtractTitle(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want string
+	}{
+		{"title", "<html><head><title>Hello &amp; welcome</title></head></html>", "Hello & welcome"},
+		{"whitespace", "<TITLE lang=en>\n  Multi\n  line\n</TITLE>", "Multi line"},
+		{"og fallback", `<meta property="og:title" content="From OG">`, "From OG"},
+		{"none", "<p>no title</p>", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractTitle([]byte(tt.page)); got != tt.want {
+				t.Errorf("ExtractTitle() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookPayload(t *testing.T) {
+	got, err := HookPayload("https://example.com", "@a:x", "", "!r:x", "lounge", true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"link":{"submittedBy":"@a:x","url":"https://example.com"},"room":{"comment":"lounge","id":"!r:x"}}`
+	if string(got) != want {
+		t.Errorf("HookPayload() = %s, want %s", got, want)
+	}
+	got, _ = HookPayload("https://example.com", "@a:x", "Alice", "!r:x", "lounge", false, false)
+	if string(got) != `{"link":{"url":"https://example.com"}}` {
+		t.Errorf("HookPayload() without user and topic = %s", got)
+	}
+	got, _ = HookPayload("https://example.com", "@a:x", "Alice", "", "", true, false)
+	if string(got) != `{"link":{"submittedBy":"@a:x","submittedByName":"Alice","url":"https://example.com"}}` {
+		t.Errorf("HookPayload() with a display name = %s", got)
+	}
+}
@@ -0,0 +1,160 @@
+// Package locale holds the bot's user-facing messages, so communities can
+// translate them without forking. Every message has an English default in
+// Messages; MESSAGES_PATH points at a JSON catalog of translations, and a
+// room's "language" picks the one its replies use.
+package locale
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+)
+
+// Messages are the English messages by key, as fmt formats.
+var Messages = map[string]string{
+	"command_not_allowed":  "command not allowed in this room",
+	"help":                 "Available commands: %s",
+	"unknown_command":      "Unknown command. %s",
+	"admin_only":           "this command is restricted to bot admins",
+	"no_bot_config":        "no bot configuration loaded",
+	"command_failed":       "sorry, couldn't execute %s right now",
+	"nothing_more":         "there's nothing more to show",
+	"page":                 "(page %d/%d)",
+	"page_next":            "(page %d/%d, react %s or send /bot more for the next)",
+	"need_text":            "give some text or reply to a message to use this command",
+	"need_image":           "reply to an image to use this command",
+	"no_messages":          "no messages found",
+	"no_messages_today":    "no messages found today",
+	"no_messages_to_quote": "no messages found to quote",
+
+	// /bot feed
+	"feed_disabled":       "feeds aren't enabled (FEEDS in config.json)",
+	"feed_read_failed":    "couldn't read the room's feeds",
+	"feed_none":           "no feeds yet. admins can add one with /bot %s add <url>",
+	"feed_admin_only":     "only bot admins can change feeds",
+	"feed_usage_url":      "usage: /bot %s %s <url>",
+	"feed_remove_failed":  "couldn't remove that feed",
+	"feed_not_subscribed": "this room isn't subscribed to %s",
+	"feed_unsubscribed":   "unsubscribed from %s",
+	"feed_bad_url":        "that doesn't look like a feed URL",
+	"feed_no_network":     "can't fetch feeds with DRY_RUN_NO_NETWORK",
+	"feed_fetch_failed":   "couldn't read that feed: %v",
+	"feed_save_failed":    "couldn't save that feed",
+	"feed_subscribed":     "subscribed to %s. new items will be posted here",
+	"feed_usage":          "usage: /bot %s [list|add <url>|remove <url|n>]",
+
+	// /bot sticker
+	"sticker_read_failed":   "couldn't read the sticker pack",
+	"sticker_none":          "no stickers yet. admins can reply to an image with /bot %s add <name>",
+	"sticker_list":          "stickers: %s",
+	"sticker_admin_only":    "only bot admins can change the sticker pack",
+	"sticker_usage_name":    "usage: /bot %s %s <name>, with letters, digits, _, + or - in the name",
+	"sticker_usage":         "usage: /bot %s [list] | /bot %s add <name> (replying to an image) | /bot %s remove <name>",
+	"sticker_not_found":     "there's no sticker called %q",
+	"sticker_update_failed": "couldn't update the sticker pack; does the bot have permission to change room state?",
+	"sticker_removed":       "removed %q",
+	"sticker_need_image":    "reply to an image with /bot %s add %s",
+	"sticker_not_image":     "that isn't an image",
+	"sticker_added":         "added %q to the room's sticker pack",
+
+	// /bot glossary
+	"glossary_read_failed":   "couldn't read the glossary",
+	"glossary_empty":         "the glossary is empty. admins can add terms with /bot %s add <term> = <definition>",
+	"glossary_list":          "glossary: %s",
+	"glossary_admin_only":    "only bot admins can edit the glossary",
+	"glossary_usage":         "usage: /bot %s add <term> = <definition> | /bot %s forget <term>",
+	"glossary_update_failed": "couldn't update the glossary",
+	"glossary_saved":         "saved %q",
+	"glossary_forgot":        "forgot %q",
+	"glossary_unknown":       "%q isn't in the glossary",
+	"glossary_usage_lookup":  "usage: /bot %s is <term>",
+}
+
+// Catalog maps languages to their translated messages by key. Keys a
+// language leaves out fall back to Messages.
+type Catalog map[string]map[string]string
+
+// Translations is the loaded catalog. Set from config MESSAGES_PATH.
+var Translations Catalog
+
+// RoomLanguages maps room IDs to their language, and DefaultLanguage is the
+// language of other rooms. Set from config; empty means English.
+var (
+	RoomLanguages   = map[string]string{}
+	DefaultLanguage string
+)
+
+// Load reads a catalog of translations from a JSON file like
+// {"de": {"command_not_allowed": "..."}}.
+func Load(path string) (Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// verbRe matches a fmt verb, so translations can be checked to take the
+// same arguments as the English message.
+var verbRe = regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]*)?[a-zA-Z%]`)
+
+// Check reports translations of unknown keys and ones whose fmt verbs
+// differ from the English message's.
+func (c Catalog) Check() []error {
+	var errs []error
+	for _, lang := range slices.Sorted(maps.Keys(c)) {
+		for _, key := range slices.Sorted(maps.Keys(c[lang])) {
+			english, ok := Messages[key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("messages %s: unknown key %q", lang, key))
+				continue
+			}
+			if !slices.Equal(verbRe.FindAllString(english, -1), verbRe.FindAllString(c[lang][key], -1)) {
+				errs = append(errs, fmt.Errorf("messages %s: %q must use the same %% verbs as %q", lang, key, english))
+			}
+		}
+	}
+	return errs
+}
+
+// CheckLanguages reports languages, other than English, the catalog doesn't
+// have.
+func (c Catalog) CheckLanguages(langs ...string) []error {
+	var errs []error
+	for _, lang := range langs {
+		if _, ok := c[lang]; !ok && lang != "" && lang != "en" {
+			errs = append(errs, fmt.Errorf("language %q is not in MESSAGES_PATH", lang))
+		}
+	}
+	return errs
+}
+
+// Get returns the message key in lang, formatted with args. Messages lang
+// doesn't translate are in English.
+func Get(lang, key string, args ...any) string {
+	format, ok := Translations[lang][key]
+	if !ok {
+		format = Messages[key]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Language returns the language of roomID.
+func Language(roomID string) string {
+	if lang, ok := RoomLanguages[roomID]; ok {
+		return lang
+	}
+	return DefaultLanguage
+}
+
+// ForRoom returns the message key in roomID's language, formatted with args.
+func ForRoom(roomID, key string, args ...any) string {
+	return Get(Language(roomID), key, args...)
+}
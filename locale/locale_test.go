@@ -0,0 +1,42 @@
+package locale
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	defer func(tr Catalog, rooms map[string]string) { Translations, RoomLanguages = tr, rooms }(Translations, RoomLanguages)
+	Translations = Catalog{"de": {"command_failed": "%s geht gerade nicht"}}
+	RoomLanguages = map[string]string{"!de:example.com": "de"}
+
+	if got := ForRoom("!de:example.com", "command_failed", "yap"); got != "yap geht gerade nicht" {
+		t.Errorf("ForRoom(de) = %q", got)
+	}
+	// Untranslated messages and other rooms are in English.
+	if got := ForRoom("!de:example.com", "admin_only"); got != Messages["admin_only"] {
+		t.Errorf("ForRoom(de, untranslated) = %q", got)
+	}
+	if got := ForRoom("!en:example.com", "command_failed", "yap"); got != "sorry, couldn't execute yap right now" {
+		t.Errorf("ForRoom(en) = %q", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.json")
+	raw := `{"de": {"help": "Befehle: %s", "command_failed": "geht nicht", "comand_not_allowed": "nein"}}`
+	if err := os.WriteFile(path, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := c.Check(); len(errs) != 2 {
+		t.Errorf("Check = %v, want the unknown key and the missing %%s", errs)
+	}
+	if errs := c.CheckLanguages("", "en", "de", "fr"); len(errs) != 1 {
+		t.Errorf("CheckLanguages = %v, want fr", errs)
+	}
+}
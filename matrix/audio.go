@@ -0,0 +1,152 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// waveformPoints is the number of samples in an MSC3245 voice waveform.
+const waveformPoints = 100
+
+// AudioMeta holds the duration and waveform of an audio clip. Waveform values
+// range from 0 to 1024 as MSC1767 expects.
+type AudioMeta struct {
+	DurationMS int
+	Waveform   []int
+}
+
+// AnalyzeAudio decodes audio to mono PCM and computes its metadata. WAV is
+// read natively; other formats are decoded with ffmpeg when it's on PATH.
+func AnalyzeAudio(ctx context.Context, data []byte) (*AudioMeta, error) {
+	if mimeType, _ := SniffMediaType(data); mimeType == "audio/wav" {
+		samples, rate, err := parseWAV(data)
+		if err == nil {
+			return audioMetaFromPCM(samples, rate), nil
+		}
+	}
+	const rate = 8000
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-i", "pipe:0", "-f", "s16le", "-ac", "1", "-ar", fmt.Sprint(rate), "pipe:1")
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("decode audio: %w, stderr: %s", err, stderr.String())
+	}
+	pcm := stdout.Bytes()
+	samples := make([]int16, len(pcm)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[i*2:]))
+	}
+	return audioMetaFromPCM(samples, rate), nil
+}
+
+// audioMetaFromPCM computes the duration and a peak-amplitude waveform.
+func audioMetaFromPCM(samples []int16, rate int) *AudioMeta {
+	meta := &AudioMeta{}
+	if rate <= 0 || len(samples) == 0 {
+		return meta
+	}
+	meta.DurationMS = int(int64(len(samples)) * 1000 / int64(rate))
+	points := min(waveformPoints, len(samples))
+	meta.Waveform = make([]int, points)
+	for p := range points {
+		start := p * len(samples) / points
+		end := (p + 1) * len(samples) / points
+		peak := 0
+		for _, s := range samples[start:end] {
+			v := int(s)
+			if v < 0 {
+				v = -v
+			}
+			peak = max(peak, v)
+		}
+		meta.Waveform[p] = peak * 1024 / 32768
+	}
+	return meta
+}
+
+// parseWAV extracts the first channel of 8- or 16-bit PCM WAV data.
+func parseWAV(data []byte) ([]int16, int, error) {
+	var channels, bits, rate int
+	i := 12
+	for i+8 <= len(data) {
+		fourcc := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		body := data[i+8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+		switch fourcc {
+		case "fmt ":
+			if size < 16 || binary.LittleEndian.Uint16(body) != 1 {
+				return nil, 0, fmt.Errorf("unsupported wav encoding")
+			}
+			channels = int(binary.LittleEndian.Uint16(body[2:]))
+			rate = int(binary.LittleEndian.Uint32(body[4:]))
+			bits = int(binary.LittleEndian.Uint16(body[14:]))
+		case "data":
+			if channels == 0 || (bits != 8 && bits != 16) {
+				return nil, 0, fmt.Errorf("unsupported wav format")
+			}
+			frame := channels * bits / 8
+			samples := make([]int16, size/frame)
+			for n := range samples {
+				off := n * frame
+				if bits == 16 {
+					samples[n] = int16(binary.LittleEndian.Uint16(body[off:]))
+				} else {
+					samples[n] = int16(int(body[off])-128) << 8
+				}
+			}
+			return samples, rate, nil
+		}
+		i += 8 + size + size%2
+	}
+	return nil, 0, fmt.Errorf("no wav data chunk")
+}
+
+// SendAudioToMatrix uploads and sends audio as a reply, with an optional
+// caption. Duration and waveform are included when the audio can be decoded,
+// and voice marks the message as an MSC3245 voice message so clients render
+// a voice bubble.
+func SendAudioToMatrix(ctx context.Context, client *mautrix.Client, roomID id.RoomID, eventID id.EventID, audioData []byte, contentType, body, caption string, voice bool) error {
+	if err := CheckUploadSize(int64(len(audioData))); err != nil {
+		return err
+	}
+	if err := CheckQuota(ctx, roomID, int64(len(audioData))); err != nil {
+		return err
+	}
+	uploadResp, err := client.UploadBytes(ctx, audioData, contentType)
+	if err != nil {
+		return fmt.Errorf("upload audio: %w", err)
+	}
+	recordUpload(roomID, int64(len(audioData)))
+	content := event.MessageEventContent{
+		MsgType:   event.MsgAudio,
+		Body:      body,
+		URL:       uploadResp.ContentURI.CUString(),
+		Info:      &event.FileInfo{MimeType: contentType, Size: len(audioData)},
+		RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: eventID}},
+	}
+	if meta, err := AnalyzeAudio(ctx, audioData); err == nil {
+		content.Info.Duration = meta.DurationMS
+		content.MSC1767Audio = &event.MSC1767Audio{Duration: meta.DurationMS, Waveform: meta.Waveform}
+	}
+	if voice {
+		content.MSC3245Voice = &event.MSC3245Voice{}
+	}
+	setCaption(&content, caption)
+	if _, err := client.SendMessageEvent(ctx, roomID, event.EventMessage, &content); err != nil {
+		return fmt.Errorf("send audio: %w", err)
+	}
+	return nil
+}
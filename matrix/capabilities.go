@@ -0,0 +1,110 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+)
+
+// FeatureThreads is threading support, stable since spec v1.4.
+var FeatureThreads = mautrix.UnstableFeature{UnstableFlag: "org.matrix.msc3440.stable", SpecVersion: mautrix.SpecV14}
+
+// Capabilities describes what the homeserver supports. Async uploads
+// (MSC2246) aren't used: attachments are sent only once their upload
+// finished, so a failed upload or the media quota is reported to the user
+// instead of leaving an event pointing at missing media.
+type Capabilities struct {
+	SpecVersion        string
+	Threads            bool
+	AuthenticatedMedia bool
+	// SetDisplayname is m.set_displayname; EnsureDisplayName needs it.
+	SetDisplayname bool
+	// MaxUploadBytes is the server's m.upload.size, or 0 if unknown.
+	MaxUploadBytes int64
+}
+
+// Caps holds the detected capabilities. It assumes a modern homeserver until
+// DetectCapabilities runs. Thread summaries are only offered when Threads is
+// set.
+var Caps = &Capabilities{Threads: true, SetDisplayname: true}
+
+// capabilitiesFrom builds Capabilities from the server's responses; any of
+// them may be nil if the query failed.
+func capabilitiesFrom(versions *mautrix.RespVersions, caps *mautrix.RespCapabilities, media *mautrix.RespMediaConfig) *Capabilities {
+	c := &Capabilities{Threads: true, SetDisplayname: true}
+	if versions != nil {
+		c.SpecVersion = versions.GetLatest().String()
+		c.Threads = versions.Supports(FeatureThreads)
+		c.AuthenticatedMedia = versions.Supports(mautrix.FeatureAuthenticatedMedia)
+	}
+	if caps != nil {
+		c.SetDisplayname = caps.SetDisplayname.IsEnabled()
+	}
+	if media != nil {
+		c.MaxUploadBytes = media.UploadSize
+	}
+	return c
+}
+
+// DetectCapabilities queries /versions, /capabilities and the media config,
+// stores the result in Caps and logs a capability report. Failed queries are logged and
+// leave optimistic defaults in place rather than aborting startup.
+func DetectCapabilities(ctx context.Context, client *mautrix.Client) *Capabilities {
+	// Versions also records the spec versions on the client, which mautrix
+	// uses to choose authenticated media endpoints for downloads.
+	versions, err := client.Versions(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to query server versions")
+		versions = nil
+	}
+	caps, err := client.Capabilities(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to query server capabilities")
+		caps = nil
+	}
+	media, err := client.GetMediaConfig(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to query media config")
+		media = nil
+	}
+	c := capabilitiesFrom(versions, caps, media)
+	Caps = c
+
+	if c.MaxUploadBytes > 0 && (MaxUploadBytes <= 0 || c.MaxUploadBytes < MaxUploadBytes) {
+		log.Info().Int64("bytes", c.MaxUploadBytes).Msg("lowering upload limit to server maximum")
+		MaxUploadBytes = c.MaxUploadBytes
+	}
+	report := log.Info().
+		Str("spec", c.SpecVersion).
+		Bool("threads", c.Threads).
+		Bool("authenticated_media", c.AuthenticatedMedia).
+		Bool("set_displayname", c.SetDisplayname).
+		Int64("max_upload_bytes", c.MaxUploadBytes)
+	if caps != nil && caps.RoomVersions != nil {
+		report = report.Str("default_room_version", caps.RoomVersions.Default)
+	}
+	report.Msg("homeserver capabilities")
+	return c
+}
+
+// EnsureDisplayName sets the bot's display name to name if it differs and
+// the homeserver lets users change it.
+func EnsureDisplayName(ctx context.Context, client *mautrix.Client, name string) error {
+	if name == "" {
+		return nil
+	}
+	if !Caps.SetDisplayname {
+		log.Warn().Str("name", name).Msg("homeserver doesn't allow changing the display name; keeping the current one")
+		return nil
+	}
+	if current, err := client.GetOwnDisplayName(ctx); err == nil && current.DisplayName == name {
+		return nil
+	}
+	if err := client.SetDisplayName(ctx, name); err != nil {
+		return fmt.Errorf("set display name: %w", err)
+	}
+	log.Info().Str("name", name).Msg("set display name")
+	return nil
+}
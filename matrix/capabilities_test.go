@@ -0,0 +1,73 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestCapabilitiesFrom(t *testing.T) {
+	defaults := capabilitiesFrom(nil, nil, nil)
+	if !defaults.Threads || !defaults.SetDisplayname || defaults.AuthenticatedMedia || defaults.MaxUploadBytes != 0 {
+		t.Errorf("unexpected defaults: %+v", defaults)
+	}
+
+	old := &mautrix.RespVersions{Versions: []mautrix.SpecVersion{mautrix.SpecV11, mautrix.SpecV12}}
+	caps := &mautrix.RespCapabilities{SetDisplayname: &mautrix.CapBooleanTrue{Enabled: false}}
+	c := capabilitiesFrom(old, caps, &mautrix.RespMediaConfig{UploadSize: 50 << 20})
+	if c.Threads || c.AuthenticatedMedia {
+		t.Errorf("v1.2 server should not support threads or authenticated media: %+v", c)
+	}
+	if c.SetDisplayname {
+		t.Error("expected SetDisplayname to be disabled")
+	}
+	if c.MaxUploadBytes != 50<<20 {
+		t.Errorf("MaxUploadBytes = %d, want %d", c.MaxUploadBytes, 50<<20)
+	}
+	if c.SpecVersion != "v1.2" {
+		t.Errorf("SpecVersion = %q, want v1.2", c.SpecVersion)
+	}
+
+	modern := &mautrix.RespVersions{Versions: []mautrix.SpecVersion{mautrix.SpecV111}}
+	c = capabilitiesFrom(modern, nil, nil)
+	if !c.Threads || !c.AuthenticatedMedia {
+		t.Errorf("v1.11 server should support threads and authenticated media: %+v", c)
+	}
+}
+
+func TestEnsureDisplayName(t *testing.T) {
+	var set []string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			body, _ := io.ReadAll(r.Body)
+			set = append(set, string(body))
+		}
+		fmt.Fprint(w, `{"displayname":"ash"}`)
+	}))
+	defer hs.Close()
+	client, err := mautrix.NewClient(hs.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps := Caps
+	defer func() { Caps = caps }()
+
+	Caps = &Capabilities{SetDisplayname: true}
+	if err := EnsureDisplayName(context.Background(), client, "ash"); err != nil || len(set) != 0 {
+		t.Errorf("unchanged name: %v, set %q", err, set)
+	}
+	if err := EnsureDisplayName(context.Background(), client, "Ash Bot"); err != nil || len(set) != 1 || !strings.Contains(set[0], `"Ash Bot"`) {
+		t.Errorf("new name: %v, set %q", err, set)
+	}
+	Caps = &Capabilities{}
+	if err := EnsureDisplayName(context.Background(), client, "Other"); err != nil || len(set) != 1 {
+		t.Errorf("without m.set_displayname: %v, set %q", err, set)
+	}
+}
@@ -0,0 +1,32 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// ResolveRoom turns a room ID or alias into a room ID. Aliases (#room:server)
+// are looked up in the homeserver's room directory, which doesn't need an
+// access token; room IDs are returned unchanged.
+func ResolveRoom(ctx context.Context, homeserver, room string) (id.RoomID, error) {
+	room = strings.TrimSpace(room)
+	switch {
+	case strings.HasPrefix(room, "!"):
+		return id.RoomID(room), nil
+	case !strings.HasPrefix(room, "#"):
+		return "", fmt.Errorf("%q is not a room ID (!id:server) or alias (#alias:server)", room)
+	}
+	client, err := mautrix.NewClient(homeserver, "", "")
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.ResolveAlias(ctx, id.RoomAlias(room))
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", room, err)
+	}
+	return resp.RoomID, nil
+}
@@ -0,0 +1,39 @@
+package matrix
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveRoom(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/directory/room/") {
+			http.NotFound(w, r)
+			return
+		}
+		if !strings.Contains(r.URL.Path, "lounge") {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"Room alias not found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"room_id":"!abc:example.com","servers":["example.com"]}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	if got, err := ResolveRoom(ctx, srv.URL, "#lounge:example.com"); err != nil || got != "!abc:example.com" {
+		t.Errorf("alias: got %q, %v", got, err)
+	}
+	if got, err := ResolveRoom(ctx, srv.URL, " !xyz:example.com "); err != nil || got != "!xyz:example.com" {
+		t.Errorf("room ID: got %q, %v", got, err)
+	}
+	if _, err := ResolveRoom(ctx, srv.URL, "#missing:example.com"); err == nil {
+		t.Error("expected error for unknown alias")
+	}
+	if _, err := ResolveRoom(ctx, srv.URL, "lounge"); err == nil {
+		t.Error("expected error for bare name")
+	}
+}
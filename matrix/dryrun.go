@@ -0,0 +1,108 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// DryRunTransport answers Matrix API requests locally, logging what would
+// have been sent. With a Base, only requests others would see (the ones
+// READ_ONLY refuses) are answered locally and the rest, such as syncing and
+// E2EE key traffic, go to the homeserver; without one nothing leaves the
+// process. Messages are printed to Out instead of logged when it is set.
+type DryRunTransport struct {
+	Base http.RoundTripper
+	Out  io.Writer
+
+	n atomic.Int64
+}
+
+func (t *DryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	if t.Base != nil && !blocksReadOnly(req.Method, path) {
+		return t.Base.RoundTrip(req)
+	}
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(io.LimitReader(req.Body, 1<<20))
+		req.Body.Close()
+	}
+	n := t.n.Add(1)
+	isJSON := len(body) > 0 && strings.Contains(req.Header.Get("Content-Type"), "json")
+	if t.Out != nil && isJSON && req.Method == http.MethodPut && strings.Contains(path, "/send/m.room.message/") {
+		var msg event.MessageEventContent
+		if err := json.Unmarshal(body, &msg); err == nil {
+			if msg.MsgType == event.MsgText || msg.MsgType == event.MsgNotice {
+				fmt.Fprintf(t.Out, "< %s\n", msg.Body)
+			} else {
+				fmt.Fprintf(t.Out, "< [%s] %s\n", msg.MsgType, msg.Body)
+			}
+		}
+	} else {
+		logEv := log.Info().Str("method", req.Method).Str("path", path)
+		if isJSON {
+			logEv = logEv.Str("body", string(body[:min(len(body), 4096)]))
+		}
+		logEv.Msg("dry run request")
+	}
+
+	status, resp := http.StatusOK, "{}"
+	switch {
+	case req.Method == http.MethodPut && strings.Contains(path, "/send/"),
+		req.Method == http.MethodPut && strings.Contains(path, "/redact/"),
+		req.Method == http.MethodPut && strings.Contains(path, "/state/"):
+		resp = fmt.Sprintf(`{"event_id":"$dryrun-%d"}`, n)
+	case strings.Contains(path, "/media/") && strings.HasSuffix(path, "/upload"):
+		resp = fmt.Sprintf(`{"content_uri":"mxc://dryrun.invalid/%d"}`, n)
+	case req.Method == http.MethodPost && strings.HasSuffix(path, "/createRoom"):
+		resp = fmt.Sprintf(`{"room_id":"!dryrun-%d:dryrun.invalid"}`, n)
+	case req.Method == http.MethodGet:
+		status, resp = http.StatusNotFound, `{"errcode":"M_NOT_FOUND","error":"not available in dry run"}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewBufferString(resp)),
+		Request:    req,
+	}, nil
+}
+
+// dryRunCrypto logs events in the clear instead of encrypting them, so the
+// log shows what would have been sent and no Megolm session is created or
+// shared for messages that never go out. Decryption is unchanged.
+type dryRunCrypto struct {
+	mautrix.CryptoHelper
+}
+
+func (c dryRunCrypto) Encrypt(_ context.Context, roomID id.RoomID, evtType event.Type, content any) (*event.EncryptedEventContent, error) {
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("room", string(roomID)).Str("type", evtType.Type).RawJSON("content", raw).Msg("dry run encrypted event")
+	return &event.EncryptedEventContent{Algorithm: id.AlgorithmMegolmV1}, nil
+}
+
+// MakeDryRun makes the client log, rather than send, anything other users
+// would see, while it keeps syncing and decrypting as usual.
+func MakeDryRun(client *mautrix.Client) {
+	base := client.Client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Client.Transport = &DryRunTransport{Base: base}
+	if client.Crypto != nil {
+		client.Crypto = dryRunCrypto{client.Crypto}
+	}
+}
@@ -0,0 +1,40 @@
+package matrix
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDryRunTransport(t *testing.T) {
+	var hits []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, r.Method+" "+r.URL.Path)
+		io.WriteString(w, `{"next_batch":"s1"}`)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &DryRunTransport{Base: http.DefaultTransport}}
+
+	resp, err := client.Get(srv.URL + "/_matrix/client/v3/sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/_matrix/client/v3/rooms/!r:x/send/m.room.message/1", strings.NewReader(`{"msgtype":"m.text","body":"hi"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"event_id":"$dryrun-`) {
+		t.Errorf("send response = %s", body)
+	}
+	if len(hits) != 1 || hits[0] != "GET /_matrix/client/v3/sync" {
+		t.Errorf("homeserver saw %v, want only the sync", hits)
+	}
+}
@@ -0,0 +1,181 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// roomEmotesType is the MSC2545 state event holding a room's image
+	// pack, one per state key.
+	roomEmotesType = "im.ponies.room_emotes"
+	// userEmotesType is the MSC2545 account data holding the bot's own
+	// image pack.
+	userEmotesType = "im.ponies.user_emotes"
+	// emoticonHeight is the height custom emoticons are shown at, in pixels.
+	emoticonHeight = 32
+)
+
+// EmoticonCacheTTL is how long a room's image packs are used before they're
+// fetched again.
+var EmoticonCacheTTL = 10 * time.Minute
+
+// Emoticons maps shortcodes, without colons, to custom emoticon images.
+type Emoticons map[string]id.ContentURIString
+
+// shortcodeRe matches a :shortcode:.
+var shortcodeRe = regexp.MustCompile(`:([A-Za-z0-9_+-]+):`)
+
+// emojiShortcodes are the common Unicode emoji shortcodes.
+var emojiShortcodes = map[string]string{
+	"+1": "👍", "-1": "👎", "thumbsup": "👍", "thumbsdown": "👎", "ok_hand": "👌", "wave": "👋",
+	"clap": "👏", "pray": "🙏", "muscle": "💪", "raised_hands": "🙌", "point_up": "☝️", "v": "✌️",
+	"smile": "😄", "smiley": "😃", "grin": "😁", "joy": "😂", "rofl": "🤣", "laughing": "😆",
+	"wink": "😉", "blush": "😊", "slightly_smiling_face": "🙂", "upside_down_face": "🙃", "heart_eyes": "😍", "kissing_heart": "😘",
+	"yum": "😋", "stuck_out_tongue": "😛", "sunglasses": "😎", "nerd_face": "🤓", "thinking": "🤔", "neutral_face": "😐",
+	"expressionless": "😑", "unamused": "😒", "roll_eyes": "🙄", "grimacing": "😬", "relieved": "😌", "pensive": "😔",
+	"sleeping": "😴", "sleepy": "😪", "confused": "😕", "worried": "😟", "frowning": "☹️", "cry": "😢",
+	"sob": "😭", "scream": "😱", "angry": "😠", "rage": "😡", "skull": "💀", "clown_face": "🤡",
+	"see_no_evil": "🙈", "eyes": "👀", "brain": "🧠", "heart": "❤️", "broken_heart": "💔", "sparkling_heart": "💖",
+	"fire": "🔥", "sparkles": "✨", "star": "⭐", "zap": "⚡", "boom": "💥", "100": "💯",
+	"tada": "🎉", "confetti_ball": "🎊", "gift": "🎁", "trophy": "🏆", "medal": "🏅", "crown": "👑",
+	"rocket": "🚀", "coffee": "☕", "beer": "🍺", "pizza": "🍕", "cake": "🍰", "popcorn": "🍿",
+	"check": "✔️", "white_check_mark": "✅", "x": "❌", "warning": "⚠️", "question": "❓", "exclamation": "❗",
+	"bulb": "💡", "memo": "📝", "books": "📚", "calendar": "📅", "link": "🔗", "lock": "🔒",
+	"bug": "🐛", "robot": "🤖", "ghost": "👻", "alien": "👽", "cat": "🐱", "dog": "🐶",
+	"duck": "🦆", "penguin": "🐧", "snake": "🐍", "crab": "🦀", "sun": "☀️", "rainbow": "🌈",
+	"shrug": "🤷", "facepalm": "🤦", "salute": "🫡", "party": "🥳", "hug": "🤗", "zipper_mouth": "🤐",
+}
+
+// imagePack is the content of an MSC2545 image pack.
+type imagePack struct {
+	Images map[string]struct {
+		URL   id.ContentURIString `json:"url"`
+		Usage []string            `json:"usage,omitempty"`
+	} `json:"images"`
+	Pack struct {
+		Usage []string `json:"usage,omitempty"`
+	} `json:"pack"`
+}
+
+// addTo adds the pack's emoticons, not its stickers, to e.
+func (p *imagePack) addTo(e Emoticons) {
+	for code, img := range p.Images {
+		usage := img.Usage
+		if len(usage) == 0 {
+			usage = p.Pack.Usage
+		}
+		if len(usage) > 0 && !slices.Contains(usage, "emoticon") {
+			continue
+		}
+		if strings.HasPrefix(string(img.URL), "mxc://") {
+			e[code] = img.URL
+		}
+	}
+}
+
+type roomEmoticons struct {
+	emotes  Emoticons
+	fetched time.Time
+}
+
+var (
+	emoticonsMu    sync.Mutex
+	emoticonsCache = make(map[id.RoomID]*roomEmoticons)
+)
+
+// RoomEmoticons returns the custom emoticons the bot can use in roomID: the
+// room's image packs and the bot's own, cached for EmoticonCacheTTL. A room
+// pack's shortcode wins over the bot's. Packs that fail to load are left
+// out.
+func RoomEmoticons(ctx context.Context, client *mautrix.Client, roomID id.RoomID) Emoticons {
+	if client == nil {
+		return nil
+	}
+	emoticonsMu.Lock()
+	cached := emoticonsCache[roomID]
+	emoticonsMu.Unlock()
+	if cached != nil && time.Since(cached.fetched) < EmoticonCacheTTL {
+		return cached.emotes
+	}
+
+	emotes := make(Emoticons)
+	var own imagePack
+	if err := client.GetAccountData(ctx, userEmotesType, &own); err == nil {
+		own.addTo(emotes)
+	}
+	state, err := client.State(ctx, roomID)
+	if err != nil {
+		log.Debug().Err(err).Str("room", string(roomID)).Msg("failed to load room image packs")
+	}
+	for evType, byKey := range state {
+		if evType.Type != roomEmotesType {
+			continue
+		}
+		// Later packs win; go by state key so that's stable.
+		for _, key := range slices.Sorted(maps.Keys(byKey)) {
+			var pack imagePack
+			if err := json.Unmarshal(byKey[key].Content.VeryRaw, &pack); err == nil {
+				pack.addTo(emotes)
+			}
+		}
+	}
+
+	emoticonsMu.Lock()
+	emoticonsCache[roomID] = &roomEmoticons{emotes: emotes, fetched: time.Now()}
+	emoticonsMu.Unlock()
+	return emotes
+}
+
+// HasShortcodes reports whether text has anything that looks like a
+// :shortcode:.
+func HasShortcodes(text string) bool {
+	return shortcodeRe.MatchString(text)
+}
+
+// ExpandShortcodes replaces :shortcode:s in a message's plain and HTML
+// bodies. Custom emoticons become <img data-mx-emoticon> in the HTML body
+// and stay as :shortcode: in the plain one; common emoji shortcodes become
+// the emoji in both. Unknown shortcodes are left alone. An empty formatted
+// body is made from plain, and stays empty if there's nothing to expand.
+func ExpandShortcodes(plain, formatted string, emotes Emoticons) (string, string) {
+	if !HasShortcodes(plain) && !HasShortcodes(formatted) {
+		return plain, formatted
+	}
+	if formatted == "" {
+		formatted = event.TextToHTML(plain)
+	}
+	plain = shortcodeRe.ReplaceAllStringFunc(plain, func(m string) string {
+		code := m[1 : len(m)-1]
+		if _, custom := emotes[code]; !custom {
+			if emoji, ok := emojiShortcodes[code]; ok {
+				return emoji
+			}
+		}
+		return m
+	})
+	formatted = shortcodeRe.ReplaceAllStringFunc(formatted, func(m string) string {
+		code := m[1 : len(m)-1]
+		if url, ok := emotes[code]; ok {
+			return `<img data-mx-emoticon src="` + html.EscapeString(string(url)) + `" alt="` + m + `" title="` + m + `" height="` + strconv.Itoa(emoticonHeight) + `"/>`
+		}
+		if emoji, ok := emojiShortcodes[code]; ok {
+			return emoji
+		}
+		return m
+	})
+	return plain, formatted
+}
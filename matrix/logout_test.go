@@ -0,0 +1,78 @@
+package matrix
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+)
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		force, forget bool
+		wantErr       bool
+	}{
+		{"ok", http.StatusOK, `{}`, false, false, false},
+		{"unknown token", http.StatusUnauthorized, `{"errcode":"M_UNKNOWN_TOKEN","error":"gone"}`, false, true, false},
+		{"server error", http.StatusInternalServerError, `{"errcode":"M_UNKNOWN","error":"boom"}`, false, false, true},
+		{"server error forced", http.StatusInternalServerError, `{"errcode":"M_UNKNOWN","error":"boom"}`, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/_matrix/client/v3/logout" || r.Header.Get("Authorization") != "Bearer token" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			cfg := &config.Config{Homeserver: srv.URL, MetaDBPath: filepath.Join(dir, "meta.db")}
+			metaDB, err := db.OpenMeta(ctx, cfg.MetaDBPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer metaDB.Close()
+			for k, v := range map[string]string{"user_id": "@ash:example.com", "access_token": "token", "device_id": "DEV", "pickle_key": "pk", "sync_token": "s1", "password": "pw"} {
+				if err := db.SetMeta(ctx, metaDB, k, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cryptoPath := cfg.MetaDBPath + ".crypto"
+			if err := os.WriteFile(cryptoPath, []byte("x"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err = Logout(ctx, metaDB, cfg, tt.forget, tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Logout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			token, _ := db.GetMeta(ctx, metaDB, "access_token")
+			password, _ := db.GetMeta(ctx, metaDB, "password")
+			_, statErr := os.Stat(cryptoPath)
+			if tt.wantErr {
+				if token == "" || statErr != nil {
+					t.Error("failed logout should leave the local session alone")
+				}
+				return
+			}
+			if token != "" || !os.IsNotExist(statErr) {
+				t.Errorf("session not removed: token %q, crypto store err %v", token, statErr)
+			}
+			if (password == "") != tt.forget {
+				t.Errorf("password = %q with forget-secrets %v", password, tt.forget)
+			}
+		})
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"net/http"
 	"os"
-	"os/exec"
 	"strings"
 
 	"github.com/rs/zerolog/log"
@@ -132,6 +135,61 @@ func storeCreds(ctx context.Context, database *sql.DB, creds *Credentials) error
 	return db.SetMeta(ctx, database, "device_id", creds.DeviceID)
 }
 
+// sessionMetaKeys are the meta keys tied to one logged-in device.
+var sessionMetaKeys = []string{"access_token", "device_id", "pickle_key", "sync_token"}
+
+// secretMetaKeys are the values EnsureSecrets prompts for.
+var secretMetaKeys = []string{"homeserver", "user_id", "password", "recovery_key"}
+
+// Logout invalidates the stored access token with the homeserver, then
+// deletes the session from the meta DB and removes the crypto store. With
+// forgetSecrets the homeserver, user, password and recovery key are removed
+// too, so the next run prompts for them. An access token the server no
+// longer accepts is treated as already logged out; other server errors
+// abort unless force is set. The messages DB is never touched.
+func Logout(ctx context.Context, metaDB *sql.DB, cfg *config.Config, forgetSecrets, force bool) error {
+	creds, err := loadStored(ctx, metaDB)
+	if err == nil {
+		homeserver := cfg.Homeserver
+		if homeserver == "" {
+			homeserver, _ = db.GetMeta(ctx, metaDB, "homeserver")
+		}
+		client, err := createClientFromCreds(homeserver, creds)
+		if err != nil {
+			return fmt.Errorf("create client: %w", err)
+		}
+		if _, err := client.Logout(ctx); err != nil {
+			switch {
+			case errors.Is(err, mautrix.MUnknownToken):
+				log.Info().Msg("access token was already invalid")
+			case force:
+				log.Warn().Err(err).Msg("logout failed, removing local session anyway")
+			default:
+				return fmt.Errorf("logout: %w (use --force to remove the local session anyway)", err)
+			}
+		} else {
+			log.Info().Str("user", creds.UserID).Str("device", creds.DeviceID).Msg("logged out")
+		}
+	} else {
+		log.Info().Msg("no stored session")
+	}
+
+	keys := sessionMetaKeys
+	if forgetSecrets {
+		keys = append(append([]string(nil), keys...), secretMetaKeys...)
+	}
+	if err := db.DeleteMeta(ctx, metaDB, keys...); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	cryptoDBPath := cfg.MetaDBPath + ".crypto"
+	for _, fname := range []string{cryptoDBPath, cryptoDBPath + "-shm", cryptoDBPath + "-wal"} {
+		if err := os.Remove(fname); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove crypto store: %w", err)
+		}
+	}
+	return nil
+}
+
 // EnsurePickleKey generates or retrieves the pickle key for crypto.
 func EnsurePickleKey(ctx context.Context, metaDB *sql.DB) (string, error) {
 	pickleKey, err := db.GetMeta(ctx, metaDB, "pickle_key")
@@ -243,18 +301,43 @@ func IsImageMessage(msg *event.MessageEventContent) bool {
 	return msg.MsgType == event.MsgImage || msg.MsgType == "m.sticker" || msg.URL != "" || msg.File != nil
 }
 
-// SendImageToMatrix uploads and sends an image as a reply.
-func SendImageToMatrix(ctx context.Context, client *mautrix.Client, roomID id.RoomID, eventID id.EventID, imageData []byte, contentType, body string) error {
+// SendImageToMatrix uploads and sends an image as a reply, with an optional
+// caption. Its Content-Type comes from sniffing the data, and anything that
+// isn't an image is refused with ErrNotImage. Large still images get a
+// thumbnail, and with ImageBlurhash images get a blurhash.
+func SendImageToMatrix(ctx context.Context, client *mautrix.Client, roomID id.RoomID, eventID id.EventID, imageData []byte, body, caption string) error {
+	contentType, _, err := SniffImage(imageData)
+	if err != nil {
+		return err
+	}
+	if err := CheckUploadSize(int64(len(imageData))); err != nil {
+		return err
+	}
+	if err := CheckQuota(ctx, roomID, int64(len(imageData))); err != nil {
+		return err
+	}
 	uploadResp, err := client.UploadBytes(ctx, imageData, contentType)
 	if err != nil {
 		return fmt.Errorf("upload image: %w", err)
 	}
+	recordUpload(roomID, int64(len(imageData)))
+	width, height := ImageDimensions(imageData)
+	info := &event.FileInfo{
+		MimeType:   contentType,
+		Size:       len(imageData),
+		Width:      width,
+		Height:     height,
+		IsAnimated: IsAnimated(imageData),
+	}
+	addImagePreviews(ctx, client, roomID, info, imageData)
 	content := event.MessageEventContent{
 		MsgType:   event.MsgImage,
 		Body:      body,
 		URL:       uploadResp.ContentURI.CUString(),
+		Info:      info,
 		RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: eventID}},
 	}
+	setCaption(&content, caption)
 	if _, err := client.SendMessageEvent(ctx, roomID, event.EventMessage, &content); err != nil {
 		return fmt.Errorf("send image: %w", err)
 	}
@@ -285,8 +368,23 @@ func DownloadImageFromMessage(ctx context.Context, client *mautrix.Client, ev *e
 	return nil, fmt.Errorf("no image found")
 }
 
-// DownloadImageBytes downloads image data from a Matrix content URI.
+// DownloadImageBytes downloads image data from a Matrix content URI. Data
+// that doesn't sniff as an image, whatever the event claimed, fails with
+// ErrNotImage.
 func DownloadImageBytes(ctx context.Context, client *mautrix.Client, mediaURL id.ContentURIString, encryptedFile *event.EncryptedFileInfo) ([]byte, error) {
+	data, err := DownloadMedia(ctx, client, mediaURL, encryptedFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := SniffImage(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DownloadMedia downloads media from a Matrix content URI, decrypting it
+// with encryptedFile when it's set.
+func DownloadMedia(ctx context.Context, client *mautrix.Client, mediaURL id.ContentURIString, encryptedFile *event.EncryptedFileInfo) ([]byte, error) {
 	if mediaURL == "" {
 		return nil, fmt.Errorf("no media URL")
 	}
@@ -296,7 +394,7 @@ func DownloadImageBytes(ctx context.Context, client *mautrix.Client, mediaURL id
 	}
 	data, err := client.DownloadBytes(ctx, parsed)
 	if err != nil {
-		return nil, fmt.Errorf("download image: %w", err)
+		return nil, fmt.Errorf("download media: %w", err)
 	}
 	if encryptedFile != nil {
 		if err := encryptedFile.PrepareForDecryption(); err != nil {
@@ -304,7 +402,7 @@ func DownloadImageBytes(ctx context.Context, client *mautrix.Client, mediaURL id
 		}
 		data, err = encryptedFile.Decrypt(data)
 		if err != nil {
-			return nil, fmt.Errorf("decrypt image: %w", err)
+			return nil, fmt.Errorf("decrypt media: %w", err)
 		}
 	}
 	return data, nil
@@ -321,23 +419,81 @@ func MediaFromMessage(msg *event.MessageEventContent) (id.ContentURIString, *eve
 	return "", nil, fmt.Errorf("no media URL")
 }
 
-// DetectImageExtension uses the `file` command to determine image type.
-func DetectImageExtension(inputPath string) string {
-	out, err := exec.Command("file", inputPath).Output()
+// ErrNotImage is returned for media that should be an image but whose
+// content isn't one.
+var ErrNotImage = errors.New("not an image")
+
+// SniffImage returns the MIME type and extension of image data, or
+// ErrNotImage if its content is something else.
+func SniffImage(data []byte) (string, string, error) {
+	mimeType, ext := SniffMediaType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", "", fmt.Errorf("%w: content is %s", ErrNotImage, mimeType)
+	}
+	return mimeType, ext, nil
+}
+
+// DetectFileType reads the head of a file and returns its MIME type and extension.
+func DetectFileType(path string) (string, string) {
+	f, err := os.Open(path)
 	if err != nil {
-		return ".png"
+		return "application/octet-stream", ".bin"
 	}
-	lower := strings.ToLower(string(out))
-	switch {
-	case strings.Contains(lower, "jpeg") || strings.Contains(lower, "jpg"):
-		return ".jpg"
-	case strings.Contains(lower, "png"):
-		return ".png"
-	case strings.Contains(lower, "gif"):
-		return ".gif"
-	case strings.Contains(lower, "webp") || strings.Contains(lower, "web/p"):
-		return ".webp"
-	default:
-		return ".png"
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return SniffMediaType(head[:n])
+}
+
+// SniffMediaType returns the MIME type and extension for media data. Magic
+// numbers for common image, video and audio containers are checked first,
+// falling back to http.DetectContentType.
+func SniffMediaType(data []byte) (string, string) {
+	has := func(off int, magic string) bool {
+		return len(data) >= off+len(magic) && string(data[off:off+len(magic)]) == magic
 	}
+	switch {
+	case has(0, "\xff\xd8\xff"):
+		return "image/jpeg", ".jpg"
+	case has(0, "\x89PNG\r\n\x1a\n"):
+		return "image/png", ".png"
+	case has(0, "GIF87a"), has(0, "GIF89a"):
+		return "image/gif", ".gif"
+	case has(0, "RIFF") && has(8, "WEBP"):
+		return "image/webp", ".webp"
+	case has(0, "RIFF") && has(8, "WAVE"):
+		return "audio/wav", ".wav"
+	case has(0, "RIFF") && has(8, "AVI "):
+		return "video/x-msvideo", ".avi"
+	case has(4, "ftyp"):
+		switch {
+		case has(8, "qt  "):
+			return "video/quicktime", ".mov"
+		case has(8, "M4A "), has(8, "M4B "):
+			return "audio/mp4", ".m4a"
+		case has(8, "avif"):
+			return "image/avif", ".avif"
+		default:
+			return "video/mp4", ".mp4"
+		}
+	case has(0, "\x1a\x45\xdf\xa3"):
+		if strings.Contains(string(data[:min(len(data), 64)]), "webm") {
+			return "video/webm", ".webm"
+		}
+		return "video/x-matroska", ".mkv"
+	case has(0, "OggS"):
+		return "audio/ogg", ".ogg"
+	case has(0, "fLaC"):
+		return "audio/flac", ".flac"
+	case has(0, "ID3"), has(0, "\xff\xfb"), has(0, "\xff\xf3"), has(0, "\xff\xf2"):
+		return "audio/mpeg", ".mp3"
+	}
+	ct := http.DetectContentType(data)
+	if idx := strings.Index(ct, ";"); idx >= 0 {
+		ct = ct[:idx]
+	}
+	if exts, err := mime.ExtensionsByType(ct); err == nil && len(exts) > 0 {
+		return ct, exts[0]
+	}
+	return ct, ".bin"
 }
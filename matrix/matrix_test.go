@@ -0,0 +1,332 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestSniffMediaType(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantMIME string
+		wantExt  string
+	}{
+		{"jpeg", "\xff\xd8\xff\xe0\x00\x10JFIF", "image/jpeg", ".jpg"},
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "image/png", ".png"},
+		{"gif", "GIF89a\x01\x00\x01\x00", "image/gif", ".gif"},
+		{"webp", "RIFF\x24\x00\x00\x00WEBPVP8 ", "image/webp", ".webp"},
+		{"wav", "RIFF\x24\x00\x00\x00WAVEfmt ", "audio/wav", ".wav"},
+		{"mp4", "\x00\x00\x00\x18ftypisom\x00\x00\x02\x00", "video/mp4", ".mp4"},
+		{"mov", "\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00", "video/quicktime", ".mov"},
+		{"m4a", "\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00", "audio/mp4", ".m4a"},
+		{"webm", "\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm", "video/webm", ".webm"},
+		{"ogg", "OggS\x00\x02\x00\x00", "audio/ogg", ".ogg"},
+		{"flac", "fLaC\x00\x00\x00\x22", "audio/flac", ".flac"},
+		{"mp3", "ID3\x04\x00\x00\x00\x00\x00\x00", "audio/mpeg", ".mp3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMIME, gotExt := SniffMediaType([]byte(tt.data))
+			if gotMIME != tt.wantMIME || gotExt != tt.wantExt {
+				t.Errorf("SniffMediaType() = (%q, %q), want (%q, %q)", gotMIME, gotExt, tt.wantMIME, tt.wantExt)
+			}
+		})
+	}
+}
+
+func TestSniffImage(t *testing.T) {
+	if ct, ext, err := SniffImage([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")); err != nil || ct != "image/png" || ext != ".png" {
+		t.Errorf("SniffImage(png) = (%q, %q, %v)", ct, ext, err)
+	}
+	// An HTML error page served as image/jpeg is still not an image.
+	if _, _, err := SniffImage([]byte("<!DOCTYPE html><html>404</html>")); !errors.Is(err, ErrNotImage) {
+		t.Errorf("SniffImage(html) error = %v, want ErrNotImage", err)
+	}
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	exif := "\xff\xe1\x00\x0fExif\x00\x00GPSDATA"
+	jfif := "\xff\xe0\x00\x06JFIF"
+	jpeg := "\xff\xd8" + jfif + exif + "\xff\xda\x00\x02pixels\xff\xd9"
+	got := string(StripImageMetadata([]byte(jpeg)))
+	if want := "\xff\xd8" + jfif + "\xff\xda\x00\x02pixels\xff\xd9"; got != want {
+		t.Errorf("jpeg: got %q, want %q", got, want)
+	}
+
+	chunk := func(typ, data string) string {
+		n := len(data)
+		return string([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}) + typ + data + "CRC!"
+	}
+	sig := "\x89PNG\r\n\x1a\n"
+	png := sig + chunk("IHDR", "0123456789abc") + chunk("tEXt", "Author\x00me") + chunk("IEND", "")
+	got = string(StripImageMetadata([]byte(png)))
+	if want := sig + chunk("IHDR", "0123456789abc") + chunk("IEND", ""); got != want {
+		t.Errorf("png: got %q, want %q", got, want)
+	}
+
+	gif := "GIF89a\x01\x00\x01\x00"
+	if got := string(StripImageMetadata([]byte(gif))); got != gif {
+		t.Errorf("unsupported formats should be unchanged, got %q", got)
+	}
+}
+
+func TestIsAnimated(t *testing.T) {
+	frame := func() *image.Paletted {
+		return image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	}
+	var still, anim bytes.Buffer
+	if err := gif.EncodeAll(&still, &gif.GIF{Image: []*image.Paletted{frame()}, Delay: []int{0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.EncodeAll(&anim, &gif.GIF{Image: []*image.Paletted{frame(), frame()}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if IsAnimated(still.Bytes()) {
+		t.Error("single-frame gif reported as animated")
+	}
+	if !IsAnimated(anim.Bytes()) {
+		t.Error("two-frame gif not reported as animated")
+	}
+	if w, h := ImageDimensions(anim.Bytes()); w != 2 || h != 2 {
+		t.Errorf("ImageDimensions() = %dx%d, want 2x2", w, h)
+	}
+
+	apng := "\x89PNG\r\n\x1a\n" +
+		"\x00\x00\x00\x0dIHDR0123456789abcCRC!" +
+		"\x00\x00\x00\x08acTL\x00\x00\x00\x02\x00\x00\x00\x00CRC!" +
+		"\x00\x00\x00\x00IDATCRC!"
+	if !IsAnimated([]byte(apng)) {
+		t.Error("png with acTL not reported as animated")
+	}
+
+	// 400x300 animated WebP, 640x480 lossy and 17x9 lossless ones.
+	webps := []struct {
+		data     string
+		animated bool
+		w, h     int
+	}{
+		{"RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x02\x00\x00\x00\x8f\x01\x00\x2b\x01\x00", true, 400, 300},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 \x00\x00\x00\x00\x10\x02\x00\x9d\x01\x2a\x80\x02\xe0\x01", false, 640, 480},
+		{"RIFF\x00\x00\x00\x00WEBPVP8L\x00\x00\x00\x00\x2f\x10\x00\x02\x00\x00\x00\x00\x00\x00", false, 17, 9},
+	}
+	for _, tc := range webps {
+		if IsAnimated([]byte(tc.data)) != tc.animated {
+			t.Errorf("IsAnimated(%q) = %v", tc.data[12:16], !tc.animated)
+		}
+		if w, h := ImageDimensions([]byte(tc.data)); w != tc.w || h != tc.h {
+			t.Errorf("ImageDimensions(%q) = %dx%d, want %dx%d", tc.data[12:16], w, h, tc.w, tc.h)
+		}
+	}
+}
+
+func TestAnalyzeWAV(t *testing.T) {
+	const rate = 8000
+	pcm := make([]byte, rate*2) // one second of 16-bit mono
+	for i := 0; i < rate; i++ {
+		v := int16(0)
+		if i >= rate/2 {
+			v = 16384
+		}
+		pcm[i*2] = byte(v)
+		pcm[i*2+1] = byte(v >> 8)
+	}
+	le32 := func(n int) string { return string([]byte{byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)}) }
+	fmtChunk := "fmt " + le32(16) + "\x01\x00\x01\x00" + le32(rate) + le32(rate*2) + "\x02\x00\x10\x00"
+	wav := "RIFF" + le32(4+len(fmtChunk)+8+len(pcm)) + "WAVE" + fmtChunk + "data" + le32(len(pcm)) + string(pcm)
+
+	meta, err := AnalyzeAudio(context.Background(), []byte(wav))
+	if err != nil {
+		t.Fatalf("AnalyzeAudio: %v", err)
+	}
+	if meta.DurationMS != 1000 {
+		t.Errorf("DurationMS = %d, want 1000", meta.DurationMS)
+	}
+	if len(meta.Waveform) != waveformPoints {
+		t.Fatalf("len(Waveform) = %d, want %d", len(meta.Waveform), waveformPoints)
+	}
+	if meta.Waveform[0] != 0 || meta.Waveform[waveformPoints-1] != 512 {
+		t.Errorf("waveform endpoints = %d, %d, want 0, 512", meta.Waveform[0], meta.Waveform[waveformPoints-1])
+	}
+}
+
+func TestCheckUploadSize(t *testing.T) {
+	orig := MaxUploadBytes
+	defer func() { MaxUploadBytes = orig }()
+	MaxUploadBytes = 1 << 20
+	if err := CheckUploadSize(1 << 20); err != nil {
+		t.Errorf("size at limit rejected: %v", err)
+	}
+	if err := CheckUploadSize(1<<20 + 1); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("oversized upload: got %v, want ErrFileTooLarge", err)
+	}
+	if err := CheckUploadSize(-1); err != nil {
+		t.Errorf("unknown size rejected: %v", err)
+	}
+}
+
+func TestReplyContent(t *testing.T) {
+	c := ReplyContent("$ev", "> <b>hi</b>\nthere", "")
+	if c.Format != event.FormatHTML || c.FormattedBody != "&gt; &lt;b&gt;hi&lt;/b&gt;<br/>there" {
+		t.Errorf("formatted body = %q", c.FormattedBody)
+	}
+	if c.RelatesTo == nil || c.RelatesTo.InReplyTo.EventID != "$ev" {
+		t.Errorf("not a reply to $ev: %+v", c.RelatesTo)
+	}
+	if c := ReplyContent("", "plain", "<i>rich</i>"); c.FormattedBody != "<i>rich</i>" || c.RelatesTo != nil {
+		t.Errorf("ReplyContent with formatted body = %+v", c)
+	}
+}
+
+func TestPermalink(t *testing.T) {
+	if got, want := Permalink("!room:example.com", "$ev"), "https://matrix.to/#/!room:example.com/$ev"; got != want {
+		t.Errorf("Permalink() = %q, want %q", got, want)
+	}
+	if got, want := UserLink("@alice:example.com"), "https://matrix.to/#/@alice:example.com"; got != want {
+		t.Errorf("UserLink() = %q, want %q", got, want)
+	}
+}
+
+func TestExpandShortcodes(t *testing.T) {
+	emotes := Emoticons{"party_parrot": "mxc://example.com/parrot", "fire": "mxc://example.com/fire"}
+	plain, formatted := ExpandShortcodes("nice :tada: :party_parrot: :fire: 10:30:00 :nope:", "", emotes)
+	if plain != "nice 🎉 :party_parrot: :fire: 10:30:00 :nope:" {
+		t.Errorf("plain = %q", plain)
+	}
+	want := `nice 🎉 <img data-mx-emoticon src="mxc://example.com/parrot" alt=":party_parrot:" title=":party_parrot:" height="32"/> ` +
+		`<img data-mx-emoticon src="mxc://example.com/fire" alt=":fire:" title=":fire:" height="32"/> 10:30:00 :nope:`
+	if formatted != want {
+		t.Errorf("formatted = %q", formatted)
+	}
+	if plain, formatted := ExpandShortcodes("no codes <here>", "", nil); plain != "no codes <here>" || formatted != "" {
+		t.Errorf("ExpandShortcodes without codes = (%q, %q)", plain, formatted)
+	}
+}
+
+func TestImagePack(t *testing.T) {
+	var pack imagePack
+	raw := `{"pack":{"usage":["emoticon"]},"images":{
+		"wave":{"url":"mxc://example.com/wave"},
+		"sticker":{"url":"mxc://example.com/sticker","usage":["sticker"]},
+		"bad":{"url":"https://example.com/bad.png"}}}`
+	if err := json.Unmarshal([]byte(raw), &pack); err != nil {
+		t.Fatal(err)
+	}
+	emotes := make(Emoticons)
+	pack.addTo(emotes)
+	if len(emotes) != 1 || emotes["wave"] != "mxc://example.com/wave" {
+		t.Errorf("emoticons = %v", emotes)
+	}
+}
+
+func TestImageThumbnail(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1600, 400))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	data, mimeType, w, h, err := ImageThumbnail(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mimeType != "image/jpeg" || w != imageThumbnailSize || h != 200 {
+		t.Errorf("thumbnail = %s %dx%d", mimeType, w, h)
+	}
+	if gw, gh := ImageDimensions(data); gw != w || gh != h {
+		t.Errorf("thumbnail decodes as %dx%d", gw, gh)
+	}
+	img.Pix[3] = 0
+	if _, mimeType, _, _, _ := ImageThumbnail(img); mimeType != "image/png" {
+		t.Errorf("transparent thumbnail = %s, want image/png", mimeType)
+	}
+	if needsThumbnail(1000, 640, 480) || !needsThumbnail(1000, 1024, 768) || !needsThumbnail(2<<20, 10, 10) {
+		t.Error("needsThumbnail")
+	}
+}
+
+func TestBlurhash(t *testing.T) {
+	black := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := 3; i < len(black.Pix); i += 4 {
+		black.Pix[i] = 0xff
+	}
+	// A flat image has only the average colour; every other component is
+	// zero, quantised to the middle of the range.
+	if got, want := Blurhash(black), "L00000"+strings.Repeat("fQ", 11); got != want {
+		t.Errorf("Blurhash(black) = %s, want %s", got, want)
+	}
+	white := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for i := range white.Pix {
+		white.Pix[i] = 0xff
+	}
+	if got := Blurhash(white); got[2:6] != encode83(0xffffff, 4) {
+		t.Errorf("Blurhash(white) = %s, want DC %s", got, encode83(0xffffff, 4))
+	}
+}
+
+func TestSendAttachment(t *testing.T) {
+	var uploaded []byte
+	var uploadType string
+	var sent event.MessageEventContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.URL.Path, "/upload") {
+			uploaded, _ = io.ReadAll(r.Body)
+			uploadType = r.Header.Get("Content-Type")
+			w.Write([]byte(`{"content_uri":"mxc://example.com/file"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&sent)
+		w.Write([]byte(`{"event_id":"$file"}`))
+	}))
+	defer srv.Close()
+	client, err := mautrix.NewClient(srv.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.StateStore = mautrix.NewMemoryStateStore()
+	ctx := context.Background()
+	data := []byte("%PDF-1.4 report")
+
+	if err := SendAttachment(ctx, client, "!plain:example.com", "$cmd", data, "application/pdf", "report.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if string(uploaded) != string(data) || uploadType != "application/pdf" {
+		t.Errorf("plain upload = %q as %s", uploaded, uploadType)
+	}
+	if sent.MsgType != event.MsgFile || sent.URL != "mxc://example.com/file" || sent.File != nil ||
+		sent.FileName != "report.pdf" || sent.Info.Size != len(data) || sent.Info.MimeType != "application/pdf" {
+		t.Errorf("plain message = %+v", sent)
+	}
+
+	room := id.RoomID("!secret:example.com")
+	client.StateStore.SetEncryptionEvent(ctx, room, &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1})
+	sent = event.MessageEventContent{}
+	if err := SendAttachment(ctx, client, room, "$cmd", data, "application/pdf", "report.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(uploaded, data) || uploadType != "application/octet-stream" {
+		t.Errorf("encrypted upload = %q as %s", uploaded, uploadType)
+	}
+	if sent.URL != "" || sent.File == nil || sent.File.URL != "mxc://example.com/file" {
+		t.Fatalf("encrypted message = %+v", sent)
+	}
+	if err := sent.File.PrepareForDecryption(); err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := sent.File.Decrypt(uploaded); err != nil || !bytes.Equal(plain, data) {
+		t.Errorf("decrypted = %q, %v", plain, err)
+	}
+}
@@ -0,0 +1,184 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// IsAnimated reports whether GIF, APNG or WebP data holds more than one frame.
+func IsAnimated(data []byte) bool {
+	mimeType, _ := SniffMediaType(data)
+	switch mimeType {
+	case "image/gif":
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		return err == nil && len(g.Image) > 1
+	case "image/png":
+		return pngHasChunk(data, "acTL")
+	case "image/webp":
+		return len(data) > 20 && string(data[12:16]) == "VP8X" && data[20]&0x02 != 0
+	}
+	return false
+}
+
+// ImageDimensions returns the width and height of the image, or zeros if the
+// format can't be decoded. WebP, which the standard library can't decode,
+// is read from its headers.
+func ImageDimensions(data []byte) (int, int) {
+	if mimeType, _ := SniffMediaType(data); mimeType == "image/webp" {
+		return webpDimensions(data)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
+
+// webpDimensions reads the canvas size of extended (VP8X) WebP data, which
+// animated WebPs are, or the frame size of simple lossy (VP8) and lossless
+// (VP8L) ones.
+func webpDimensions(data []byte) (int, int) {
+	if len(data) < 30 {
+		return 0, 0
+	}
+	u24 := func(b []byte) int { return int(b[0]) | int(b[1])<<8 | int(b[2])<<16 }
+	switch string(data[12:16]) {
+	case "VP8X":
+		return u24(data[24:]) + 1, u24(data[27:]) + 1
+	case "VP8 ":
+		// A key frame's 3-byte tag and start code come before its size.
+		if string(data[23:26]) != "\x9d\x01\x2a" {
+			return 0, 0
+		}
+		return int(binary.LittleEndian.Uint16(data[26:]) & 0x3fff), int(binary.LittleEndian.Uint16(data[28:]) & 0x3fff)
+	case "VP8L":
+		if data[20] != 0x2f {
+			return 0, 0
+		}
+		bits := binary.LittleEndian.Uint32(data[21:])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1
+	}
+	return 0, 0
+}
+
+// pngHasChunk reports whether a chunk of the given type appears before the
+// image data.
+func pngHasChunk(data []byte, chunkType string) bool {
+	i := 8
+	for i+8 <= len(data) {
+		typ := string(data[i+4 : i+8])
+		if typ == chunkType {
+			return true
+		}
+		if typ == "IDAT" {
+			return false
+		}
+		i += 12 + int(binary.BigEndian.Uint32(data[i:]))
+	}
+	return false
+}
+
+// StripImageMetadata removes EXIF, XMP and text metadata (GPS, camera and
+// device details) from JPEG, PNG and WebP data. Pixel data and colour
+// profiles are kept. Unsupported or malformed input is returned unchanged.
+func StripImageMetadata(data []byte) []byte {
+	mimeType, _ := SniffMediaType(data)
+	var out []byte
+	var ok bool
+	switch mimeType {
+	case "image/jpeg":
+		out, ok = stripJPEG(data)
+	case "image/png":
+		out, ok = stripPNG(data)
+	case "image/webp":
+		out, ok = stripWebP(data)
+	}
+	if !ok {
+		return data
+	}
+	return out
+}
+
+// stripJPEG drops APP1 (EXIF/XMP), APP13 (IPTC) and COM segments.
+func stripJPEG(data []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	buf.Write(data[:2])
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return nil, false
+		}
+		marker := data[i+1]
+		if marker == 0xda { // start of scan: the rest is image data
+			buf.Write(data[i:])
+			return buf.Bytes(), true
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + size
+		if size < 2 || end > len(data) {
+			return nil, false
+		}
+		switch marker {
+		case 0xe1, 0xed, 0xfe:
+		default:
+			buf.Write(data[i:end])
+		}
+		i = end
+	}
+	return nil, false
+}
+
+// stripPNG drops eXIf, tEXt, zTXt, iTXt and tIME chunks.
+func stripPNG(data []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	buf.Write(data[:8])
+	i := 8
+	for i+12 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + size
+		if end > len(data) {
+			return nil, false
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			buf.Write(data[i:end])
+		}
+		i = end
+	}
+	return buf.Bytes(), i == len(data)
+}
+
+// stripWebP drops EXIF and XMP chunks and clears their VP8X flags.
+func stripWebP(data []byte) ([]byte, bool) {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	i := 12
+	for i+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) {
+			return nil, false
+		}
+		switch fourcc := string(data[i : i+4]); fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if size > 0 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			body.Write(chunk)
+		default:
+			body.Write(data[i:end])
+		}
+		i = end
+	}
+	out := make([]byte, 8, 8+body.Len())
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(body.Len()))
+	return append(out, body.Bytes()...), true
+}
@@ -0,0 +1,113 @@
+package matrix
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// NameCacheTTL is how long a room's member list fetched with JoinedMembers
+// is used before it's fetched again. Membership events from sync keep it
+// current in between.
+var NameCacheTTL = 15 * time.Minute
+
+// roomNames maps a room's joined members to their display names, "" for
+// members without one.
+type roomNames struct {
+	names   map[id.UserID]string
+	fetched time.Time
+}
+
+var (
+	namesMu   sync.Mutex
+	nameCache = make(map[id.RoomID]*roomNames)
+)
+
+// UpdateDisplayNames applies an m.room.member event from sync to the display
+// name cache. Rooms that were never looked up are left alone.
+func UpdateDisplayNames(ev *event.Event) {
+	if ev.StateKey == nil {
+		return
+	}
+	if ev.Content.Raw != nil {
+		_ = ev.Content.ParseRaw(ev.Type)
+	}
+	member := ev.Content.AsMember()
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	room := nameCache[ev.RoomID]
+	if room == nil {
+		return
+	}
+	userID := id.UserID(*ev.StateKey)
+	if member.Membership == event.MembershipJoin {
+		room.names[userID] = member.Displayname
+	} else {
+		delete(room.names, userID)
+	}
+}
+
+// refreshNames fetches a room's members if they aren't cached or the cache
+// is older than NameCacheTTL. On error the stale entry, if any, is kept.
+func refreshNames(ctx context.Context, client *mautrix.Client, roomID id.RoomID) {
+	namesMu.Lock()
+	room := nameCache[roomID]
+	fresh := room != nil && time.Since(room.fetched) < NameCacheTTL
+	namesMu.Unlock()
+	if fresh || client == nil {
+		return
+	}
+	resp, err := client.JoinedMembers(ctx, roomID)
+	if err != nil {
+		log.Debug().Err(err).Str("room", string(roomID)).Msg("failed to fetch room members")
+		return
+	}
+	names := make(map[id.UserID]string, len(resp.Joined))
+	for userID, member := range resp.Joined {
+		names[userID] = member.DisplayName
+	}
+	namesMu.Lock()
+	nameCache[roomID] = &roomNames{names: names, fetched: time.Now()}
+	namesMu.Unlock()
+}
+
+// RoomDisplayNames returns the display names of a room's joined members
+// that set one, by user ID, fetching the member list only when the cache
+// has none or it expired.
+func RoomDisplayNames(ctx context.Context, client *mautrix.Client, roomID id.RoomID) map[string]string {
+	refreshNames(ctx, client, roomID)
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	out := make(map[string]string)
+	if room := nameCache[roomID]; room != nil {
+		for userID, name := range room.names {
+			if name != "" {
+				out[string(userID)] = name
+			}
+		}
+	}
+	return out
+}
+
+// DisplayName returns a room member's display name, or "" if they have none
+// or aren't joined, fetching the member list only when needed.
+func DisplayName(ctx context.Context, client *mautrix.Client, roomID id.RoomID, userID id.UserID) string {
+	refreshNames(ctx, client, roomID)
+	return CachedDisplayName(roomID, userID)
+}
+
+// CachedDisplayName is DisplayName without any request, for hot paths like
+// logging: "" unless the room's members are cached.
+func CachedDisplayName(roomID id.RoomID, userID id.UserID) string {
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	if room := nameCache[roomID]; room != nil {
+		return room.names[userID]
+	}
+	return ""
+}
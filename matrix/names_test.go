@@ -0,0 +1,78 @@
+package matrix
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestDisplayNames(t *testing.T) {
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/joined_members") {
+			http.NotFound(w, r)
+			return
+		}
+		fetches++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"joined":{"@alice:example.com":{"display_name":"Alice"},"@bob:example.com":{}}}`))
+	}))
+	defer srv.Close()
+	client, err := mautrix.NewClient(srv.URL, "@ash:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	room := id.RoomID("!names:example.com")
+
+	if got := CachedDisplayName(room, "@alice:example.com"); got != "" {
+		t.Errorf("uncached name = %q", got)
+	}
+	if got := DisplayName(ctx, client, room, "@alice:example.com"); got != "Alice" {
+		t.Errorf("DisplayName = %q", got)
+	}
+	if got := RoomDisplayNames(ctx, client, room); len(got) != 1 || got["@alice:example.com"] != "Alice" {
+		t.Errorf("RoomDisplayNames = %v", got)
+	}
+	if fetches != 1 {
+		t.Errorf("fetched members %d times, want 1", fetches)
+	}
+
+	member := func(user, membership, name string) *event.Event {
+		state := user
+		return &event.Event{
+			Type:     event.StateMember,
+			RoomID:   room,
+			StateKey: &state,
+			Content: event.Content{Parsed: &event.MemberEventContent{
+				Membership:  event.Membership(membership),
+				Displayname: name,
+			}},
+		}
+	}
+	UpdateDisplayNames(member("@bob:example.com", "join", "Bob"))
+	UpdateDisplayNames(member("@alice:example.com", "leave", ""))
+	if got := CachedDisplayName(room, "@bob:example.com"); got != "Bob" {
+		t.Errorf("renamed member = %q", got)
+	}
+	if got := CachedDisplayName(room, "@alice:example.com"); got != "" {
+		t.Errorf("departed member = %q", got)
+	}
+	UpdateDisplayNames(&event.Event{Type: event.StateMember, RoomID: "!other:example.com", StateKey: new(string)})
+	if fetches != 1 {
+		t.Errorf("membership events fetched members")
+	}
+
+	old := NameCacheTTL
+	NameCacheTTL = 0
+	defer func() { NameCacheTTL = old }()
+	if got := DisplayName(ctx, client, room, "@alice:example.com"); got != "Alice" || fetches != 2 {
+		t.Errorf("after expiry: %q, %d fetches", got, fetches)
+	}
+}
@@ -0,0 +1,78 @@
+package matrix
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/db"
+)
+
+// ErrQuotaExceeded is returned when an upload would exceed a room's daily
+// media quota.
+var ErrQuotaExceeded = errors.New("daily media quota exceeded")
+
+// MediaQuota enforces per-room daily upload limits, persisting usage in the
+// messages database so restarts don't reset the count.
+type MediaQuota struct {
+	DB *sql.DB
+	// Limit returns the daily byte limit for a room; 0 means unlimited.
+	Limit func(roomID id.RoomID) int64
+}
+
+// Quota is the active media quota. Nil disables quota tracking. Set from
+// config MEDIA_QUOTA_MB and per-room mediaQuotaMB.
+var Quota *MediaQuota
+
+type quotaOverrideKey struct{}
+
+// WithQuotaOverride marks uploads made with ctx as exempt from quotas, e.g.
+// commands run by bot admins. Usage is still recorded.
+func WithQuotaOverride(ctx context.Context) context.Context {
+	return context.WithValue(ctx, quotaOverrideKey{}, true)
+}
+
+func quotaDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// CheckQuota returns ErrQuotaExceeded if uploading size more bytes to the
+// room today would exceed its quota.
+func CheckQuota(ctx context.Context, roomID id.RoomID, size int64) error {
+	q := Quota
+	if q == nil || q.DB == nil || q.Limit == nil {
+		return nil
+	}
+	limit := q.Limit(roomID)
+	if limit <= 0 {
+		return nil
+	}
+	if override, _ := ctx.Value(quotaOverrideKey{}).(bool); override {
+		return nil
+	}
+	used, err := db.GetMediaUsage(q.DB, string(roomID), quotaDay(time.Now()))
+	if err != nil {
+		log.Warn().Err(err).Str("room", string(roomID)).Msg("failed to read media usage")
+		return nil
+	}
+	if used+size > limit {
+		return fmt.Errorf("%w: %.1f of %.1f MB used today", ErrQuotaExceeded, float64(used)/(1<<20), float64(limit)/(1<<20))
+	}
+	return nil
+}
+
+// recordUpload adds size bytes to the room's usage for today.
+func recordUpload(roomID id.RoomID, size int64) {
+	q := Quota
+	if q == nil || q.DB == nil {
+		return
+	}
+	if err := db.AddMediaUsage(q.DB, string(roomID), quotaDay(time.Now()), size); err != nil {
+		log.Warn().Err(err).Str("room", string(roomID)).Msg("failed to record media usage")
+	}
+}
@@ -0,0 +1,49 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/db"
+)
+
+func TestCheckQuota(t *testing.T) {
+	database, err := db.OpenMessages(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+
+	old := Quota
+	defer func() { Quota = old }()
+	room := id.RoomID("!room:example.com")
+	Quota = &MediaQuota{DB: database, Limit: func(r id.RoomID) int64 {
+		if r == room {
+			return 1000
+		}
+		return 0
+	}}
+	ctx := context.Background()
+
+	if err := CheckQuota(ctx, room, 600); err != nil {
+		t.Fatalf("first upload: %v", err)
+	}
+	recordUpload(room, 600)
+	recordUpload(room, 300)
+	if err := CheckQuota(ctx, room, 100); err != nil {
+		t.Errorf("upload reaching the limit exactly: %v", err)
+	}
+	if err := CheckQuota(ctx, room, 101); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if err := CheckQuota(WithQuotaOverride(ctx), room, 101); err != nil {
+		t.Errorf("override should bypass quota: %v", err)
+	}
+	if err := CheckQuota(ctx, "!other:example.com", 1<<30); err != nil {
+		t.Errorf("unlimited room: %v", err)
+	}
+}
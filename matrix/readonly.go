@@ -0,0 +1,54 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"maunium.net/go/mautrix"
+)
+
+// ErrReadOnly is returned for requests blocked in READ_ONLY mode.
+var ErrReadOnly = errors.New("read-only mode: request blocked")
+
+// readOnlyTransport refuses requests that would be visible to other users:
+// anything that writes to a room, joins or creates rooms, uploads media,
+// changes the profile or presence, or sends to-device messages. Syncing,
+// reading history and the E2EE key endpoints needed to decrypt still work.
+type readOnlyTransport struct {
+	base http.RoundTripper
+}
+
+func (t readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if blocksReadOnly(req.Method, req.URL.Path) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: %s %s", ErrReadOnly, req.Method, req.URL.Path)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// blocksReadOnly reports whether a request is refused in READ_ONLY mode.
+func blocksReadOnly(method, path string) bool {
+	if method == http.MethodGet || method == http.MethodHead {
+		return false
+	}
+	for _, p := range []string{"/rooms/", "/createRoom", "/join/", "/_matrix/media/", "/profile/", "/presence/", "/sendToDevice/"} {
+		if strings.Contains(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// MakeReadOnly makes the client refuse any request that would send
+// something to other users.
+func MakeReadOnly(client *mautrix.Client) {
+	base := client.Client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Client.Transport = readOnlyTransport{base: base}
+}
@@ -0,0 +1,28 @@
+package matrix
+
+import "testing"
+
+func TestBlocksReadOnly(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/_matrix/client/v3/sync", false},
+		{"GET", "/_matrix/client/v3/rooms/!r:x/messages", false},
+		{"POST", "/_matrix/client/v3/keys/upload", false},
+		{"POST", "/_matrix/client/v3/keys/query", false},
+		{"POST", "/_matrix/client/v3/user/@a:x/filter", false},
+		{"PUT", "/_matrix/client/v3/rooms/!r:x/send/m.room.message/1", true},
+		{"PUT", "/_matrix/client/v3/rooms/!r:x/typing/@a:x", true},
+		{"POST", "/_matrix/client/v3/rooms/!r:x/receipt/m.read/$e", true},
+		{"POST", "/_matrix/client/v3/createRoom", true},
+		{"POST", "/_matrix/media/v3/upload", true},
+		{"PUT", "/_matrix/client/v3/presence/@a:x/status", true},
+		{"PUT", "/_matrix/client/v3/sendToDevice/m.room_key_request/1", true},
+	}
+	for _, tt := range tests {
+		if got := blocksReadOnly(tt.method, tt.path); got != tt.want {
+			t.Errorf("blocksReadOnly(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
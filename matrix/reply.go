@@ -0,0 +1,50 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ReplyContent returns an m.text reply to replyTo with both a plain and an
+// HTML body. An empty formatted body is made from plain, escaped, so every
+// bot reply renders the same way in clients that prefer HTML. With replyTo
+// empty the message isn't a reply.
+func ReplyContent(replyTo id.EventID, plain, formatted string) event.MessageEventContent {
+	if formatted == "" {
+		formatted = event.TextToHTML(plain)
+	}
+	content := event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          plain,
+		Format:        event.FormatHTML,
+		FormattedBody: formatted,
+	}
+	if replyTo != "" {
+		content.RelatesTo = &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: replyTo}}
+	}
+	return content
+}
+
+// SendReply sends ReplyContent to roomID and returns the new event's ID.
+func SendReply(ctx context.Context, client *mautrix.Client, roomID id.RoomID, replyTo id.EventID, plain, formatted string) (id.EventID, error) {
+	content := ReplyContent(replyTo, plain, formatted)
+	resp, err := client.SendMessageEvent(ctx, roomID, event.EventMessage, &content)
+	if err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
+}
+
+// Permalink returns a matrix.to link to an event.
+func Permalink(roomID id.RoomID, eventID id.EventID) string {
+	return fmt.Sprintf("https://matrix.to/#/%s/%s", roomID, eventID)
+}
+
+// UserLink returns a matrix.to link to a user.
+func UserLink(userID id.UserID) string {
+	return "https://matrix.to/#/" + string(userID)
+}
@@ -0,0 +1,205 @@
+package matrix
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/polarhive/ash/db"
+)
+
+const (
+	secretsFormat     = "ash-secrets"
+	secretsVersion    = 1
+	secretsKDF        = "pbkdf2-sha256"
+	secretsIterations = 600_000
+)
+
+// ErrPassphraseRequired is returned by ParseSecrets for an encrypted bundle
+// when no passphrase was given.
+var ErrPassphraseRequired = errors.New("secrets bundle is encrypted; a passphrase is required")
+
+// SecretsBundle is a portable copy of a deployment's session: every meta DB
+// row (homeserver, credentials, device ID, pickle key, sync token) and a
+// snapshot of the crypto store, so the same device can carry on elsewhere.
+type SecretsBundle struct {
+	Meta        map[string]string `json:"meta"`
+	CryptoStore []byte            `json:"crypto_store,omitempty"`
+}
+
+// secretsFile is the on-disk form of a bundle, either in the clear or
+// sealed with AES-256-GCM under a passphrase-derived key.
+type secretsFile struct {
+	Format     string         `json:"format"`
+	Version    int            `json:"version"`
+	Bundle     *SecretsBundle `json:"bundle,omitempty"`
+	KDF        string         `json:"kdf,omitempty"`
+	Iterations int            `json:"iterations,omitempty"`
+	Salt       []byte         `json:"salt,omitempty"`
+	Nonce      []byte         `json:"nonce,omitempty"`
+	Ciphertext []byte         `json:"ciphertext,omitempty"`
+}
+
+// ExportSecrets reads the meta DB and takes a consistent snapshot of the
+// crypto store next to it, if there is one.
+func ExportSecrets(ctx context.Context, metaDB *sql.DB, metaDBPath string) (*SecretsBundle, error) {
+	meta, err := db.AllMeta(ctx, metaDB)
+	if err != nil {
+		return nil, fmt.Errorf("read meta db: %w", err)
+	}
+	b := &SecretsBundle{Meta: meta}
+	cryptoDBPath := metaDBPath + ".crypto"
+	if _, err := os.Stat(cryptoDBPath); errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if b.CryptoStore, err = snapshotSQLite(ctx, cryptoDBPath); err != nil {
+		return nil, fmt.Errorf("snapshot crypto store: %w", err)
+	}
+	return b, nil
+}
+
+// snapshotSQLite copies a live SQLite database, WAL included, with
+// VACUUM INTO and returns the copy's bytes.
+func snapshotSQLite(ctx context.Context, path string) ([]byte, error) {
+	src, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	dir, err := os.MkdirTemp("", "ash-secrets-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "crypto.db")
+	if _, err := src.ExecContext(ctx, `VACUUM INTO ?`, out); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
+
+// ImportSecrets writes a bundle into the meta DB and replaces the crypto
+// store. It refuses to overwrite an existing session unless force is set.
+func ImportSecrets(ctx context.Context, metaDB *sql.DB, metaDBPath string, b *SecretsBundle, force bool) error {
+	if !force {
+		if token, _ := db.GetMeta(ctx, metaDB, "access_token"); token != "" {
+			return errors.New("meta DB already holds a session (use --force to replace it)")
+		}
+	}
+	// Remove the old session first so keys missing from the bundle don't
+	// linger alongside the imported device.
+	if err := db.DeleteMeta(ctx, metaDB, sessionMetaKeys...); err != nil {
+		return fmt.Errorf("clear session: %w", err)
+	}
+	if err := db.SetMetaAll(ctx, metaDB, b.Meta); err != nil {
+		return fmt.Errorf("write meta db: %w", err)
+	}
+	cryptoDBPath := metaDBPath + ".crypto"
+	for _, fname := range []string{cryptoDBPath, cryptoDBPath + "-shm", cryptoDBPath + "-wal"} {
+		if err := os.Remove(fname); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove crypto store: %w", err)
+		}
+	}
+	if len(b.CryptoStore) == 0 {
+		return nil
+	}
+	if err := os.WriteFile(cryptoDBPath, b.CryptoStore, 0o600); err != nil {
+		return fmt.Errorf("write crypto store: %w", err)
+	}
+	return nil
+}
+
+// Marshal encodes the bundle, encrypted if passphrase is non-empty.
+func (b *SecretsBundle) Marshal(passphrase string) ([]byte, error) {
+	f := secretsFile{Format: secretsFormat, Version: secretsVersion}
+	if passphrase == "" {
+		f.Bundle = b
+		return json.MarshalIndent(f, "", "  ")
+	}
+	plain, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	f.KDF, f.Iterations = secretsKDF, secretsIterations
+	f.Salt = make([]byte, 16)
+	if _, err := rand.Read(f.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := secretsCipher(passphrase, f.Salt, f.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return nil, err
+	}
+	f.Ciphertext = aead.Seal(nil, f.Nonce, plain, []byte(secretsFormat))
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// ParseSecrets decodes a bundle written by Marshal, decrypting it with
+// passphrase if needed.
+func ParseSecrets(data []byte, passphrase string) (*SecretsBundle, error) {
+	var f secretsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode secrets bundle: %w", err)
+	}
+	if f.Format != secretsFormat || f.Version != secretsVersion {
+		return nil, fmt.Errorf("not an ash secrets bundle (format %q, version %d)", f.Format, f.Version)
+	}
+	if f.Ciphertext == nil {
+		if f.Bundle == nil {
+			return nil, errors.New("secrets bundle is empty")
+		}
+		return f.Bundle, nil
+	}
+	if f.KDF != secretsKDF {
+		return nil, fmt.Errorf("unsupported key derivation %q", f.KDF)
+	}
+	// Only the iteration count ash writes is accepted, so a crafted file
+	// can't make import spin on a huge count or weaken the key with a tiny one.
+	if f.Iterations != secretsIterations {
+		return nil, fmt.Errorf("unsupported key derivation iterations %d", f.Iterations)
+	}
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+	aead, err := secretsCipher(passphrase, f.Salt, f.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return nil, errors.New("secrets bundle has a malformed nonce")
+	}
+	plain, err := aead.Open(nil, f.Nonce, f.Ciphertext, []byte(secretsFormat))
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted secrets bundle")
+	}
+	var b SecretsBundle
+	if err := json.Unmarshal(plain, &b); err != nil {
+		return nil, fmt.Errorf("decode secrets bundle: %w", err)
+	}
+	return &b, nil
+}
+
+// secretsCipher derives an AES-256-GCM cipher from a passphrase.
+func secretsCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,105 @@
+package matrix
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/polarhive/ash/db"
+)
+
+func TestSecretsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	srcPath := filepath.Join(t.TempDir(), "meta.db")
+	src, err := db.OpenMeta(ctx, srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	want := map[string]string{"user_id": "@ash:example.com", "access_token": "token", "device_id": "DEV", "pickle_key": "pk", "sync_token": "s1"}
+	if err := db.SetMetaAll(ctx, src, want); err != nil {
+		t.Fatal(err)
+	}
+	cryptoDB, err := sql.Open("sqlite3", srcPath+".crypto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cryptoDB.Exec(`CREATE TABLE crypto_account (device_id TEXT); INSERT INTO crypto_account VALUES ('DEV')`); err != nil {
+		t.Fatal(err)
+	}
+	cryptoDB.Close()
+
+	b, err := ExportSecrets(ctx, src, srcPath)
+	if err != nil {
+		t.Fatalf("ExportSecrets: %v", err)
+	}
+	data, err := b.Marshal("hunter2")
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if _, err := ParseSecrets(data, ""); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("no passphrase: err = %v", err)
+	}
+	if _, err := ParseSecrets(data, "wrong"); err == nil {
+		t.Error("wrong passphrase should fail")
+	}
+	for _, n := range []int{1, secretsIterations * 1000} {
+		var raw map[string]any
+		if err := json.Unmarshal(data, &raw); err != nil {
+			t.Fatal(err)
+		}
+		raw["iterations"] = n
+		tampered, _ := json.Marshal(raw)
+		if _, err := ParseSecrets(tampered, "hunter2"); err == nil {
+			t.Errorf("%d iterations should be rejected", n)
+		}
+	}
+	got, err := ParseSecrets(data, "hunter2")
+	if err != nil {
+		t.Fatalf("ParseSecrets: %v", err)
+	}
+
+	dstPath := filepath.Join(t.TempDir(), "meta.db")
+	dst, err := db.OpenMeta(ctx, dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := db.SetMeta(ctx, dst, "access_token", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportSecrets(ctx, dst, dstPath, got, false); err == nil {
+		t.Error("import over an existing session should need force")
+	}
+	if err := ImportSecrets(ctx, dst, dstPath, got, true); err != nil {
+		t.Fatalf("ImportSecrets: %v", err)
+	}
+	for k, v := range want {
+		if val, _ := db.GetMeta(ctx, dst, k); val != v {
+			t.Errorf("%s = %q, want %q", k, val, v)
+		}
+	}
+	imported, err := sql.Open("sqlite3", dstPath+".crypto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imported.Close()
+	var deviceID string
+	if err := imported.QueryRow(`SELECT device_id FROM crypto_account`).Scan(&deviceID); err != nil || deviceID != "DEV" {
+		t.Errorf("crypto store device = %q, %v", deviceID, err)
+	}
+
+	plain, err := b.Marshal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ParseSecrets(plain, ""); err != nil || got.Meta["device_id"] != "DEV" {
+		t.Errorf("unencrypted bundle: %+v, %v", got, err)
+	}
+	if _, err := ParseSecrets([]byte(`{"format":"other"}`), ""); err == nil {
+		t.Error("foreign JSON should be rejected")
+	}
+}
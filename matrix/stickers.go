@@ -0,0 +1,150 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// StickerPackKey is the state key of the image pack /bot sticker
+	// manages. Packs under other state keys are left alone.
+	StickerPackKey = "ash_stickers"
+	// stickerPackName is the pack's display name when the bot creates it.
+	stickerPackName = "Stickers"
+)
+
+// StickerNameRe matches valid sticker names, which are also the pack's
+// shortcodes.
+var StickerNameRe = regexp.MustCompile(`^[A-Za-z0-9_+-]{1,64}$`)
+
+// ErrNoSticker is returned by RemoveSticker when the pack has no sticker
+// by that name.
+var ErrNoSticker = errors.New("no such sticker")
+
+// stickerPackType is the MSC2545 room pack state event type.
+var stickerPackType = event.Type{Type: roomEmotesType, Class: event.StateEventType}
+
+// stickerPack is an MSC2545 room pack, with what the bot doesn't change
+// kept as is.
+type stickerPack struct {
+	raw    map[string]json.RawMessage
+	images map[string]json.RawMessage
+}
+
+// loadStickerPack reads the room's StickerPackKey pack, or an empty one if
+// it has none yet.
+func loadStickerPack(ctx context.Context, client *mautrix.Client, roomID id.RoomID) (*stickerPack, error) {
+	p := &stickerPack{raw: make(map[string]json.RawMessage), images: make(map[string]json.RawMessage)}
+	err := client.StateEvent(ctx, roomID, stickerPackType, StickerPackKey, &p.raw)
+	if errors.Is(err, mautrix.MNotFound) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read sticker pack: %w", err)
+	}
+	if images, ok := p.raw["images"]; ok {
+		if err := json.Unmarshal(images, &p.images); err != nil {
+			return nil, fmt.Errorf("read sticker pack: %w", err)
+		}
+	}
+	if p.images == nil {
+		p.images = make(map[string]json.RawMessage)
+	}
+	return p, nil
+}
+
+// save writes the pack to the room, naming it and marking it a sticker
+// pack if it's new.
+func (p *stickerPack) save(ctx context.Context, client *mautrix.Client, roomID id.RoomID) error {
+	images, err := json.Marshal(p.images)
+	if err != nil {
+		return err
+	}
+	p.raw["images"] = images
+	if _, ok := p.raw["pack"]; !ok {
+		p.raw["pack"], _ = json.Marshal(event.ImagePackMetadata{
+			DisplayName: stickerPackName,
+			Usage:       []event.ImagePackUsage{event.ImagePackUsageSticker},
+		})
+	}
+	if _, err := client.SendStateEvent(ctx, roomID, stickerPackType, StickerPackKey, p.raw); err != nil {
+		return fmt.Errorf("save sticker pack: %w", err)
+	}
+	emoticonsMu.Lock()
+	delete(emoticonsCache, roomID)
+	emoticonsMu.Unlock()
+	return nil
+}
+
+// StickerNames returns the names of the stickers in the room's pack, sorted.
+func StickerNames(ctx context.Context, client *mautrix.Client, roomID id.RoomID) ([]string, error) {
+	p, err := loadStickerPack(ctx, client, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(p.images)), nil
+}
+
+// AddSticker adds the image in msg to the room's pack as name, replacing
+// any sticker by that name. Encrypted images are decrypted and uploaded
+// again, since pack images must be readable by anyone in the room.
+func AddSticker(ctx context.Context, client *mautrix.Client, roomID id.RoomID, name string, msg *event.MessageEventContent) error {
+	mediaURL, file, err := MediaFromMessage(msg)
+	if err != nil {
+		return err
+	}
+	info := &event.FileInfo{}
+	if msg.Info != nil {
+		info.MimeType, info.Size, info.Width, info.Height = msg.Info.MimeType, msg.Info.Size, msg.Info.Width, msg.Info.Height
+	}
+	if file != nil {
+		data, err := DownloadImageBytes(ctx, client, mediaURL, file)
+		if err != nil {
+			return err
+		}
+		if err := CheckQuota(ctx, roomID, int64(len(data))); err != nil {
+			return err
+		}
+		contentType, _, _ := SniffImage(data)
+		resp, err := client.UploadBytes(ctx, data, contentType)
+		if err != nil {
+			return fmt.Errorf("upload sticker: %w", err)
+		}
+		recordUpload(roomID, int64(len(data)))
+		mediaURL = resp.ContentURI.CUString()
+		info.MimeType, info.Size = contentType, len(data)
+		info.Width, info.Height = ImageDimensions(data)
+	}
+
+	p, err := loadStickerPack(ctx, client, roomID)
+	if err != nil {
+		return err
+	}
+	p.images[name], err = json.Marshal(event.ImagePackImage{URL: mediaURL, Body: name, Info: info})
+	if err != nil {
+		return err
+	}
+	return p.save(ctx, client, roomID)
+}
+
+// RemoveSticker removes name from the room's pack, or returns ErrNoSticker.
+func RemoveSticker(ctx context.Context, client *mautrix.Client, roomID id.RoomID, name string) error {
+	p, err := loadStickerPack(ctx, client, roomID)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.images[name]; !ok {
+		return ErrNoSticker
+	}
+	delete(p.images, name)
+	return p.save(ctx, client, roomID)
+}
@@ -0,0 +1,47 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// FetchThread returns up to limit replies to a thread root from the
+// relations API, newest first, decrypting them where the keys are known.
+// Replies that can't be decrypted are skipped.
+func FetchThread(ctx context.Context, client *mautrix.Client, roomID id.RoomID, rootID id.EventID, limit int) ([]*event.Event, error) {
+	var out []*event.Event
+	from := ""
+	for len(out) < limit {
+		resp, err := client.GetRelations(ctx, roomID, rootID, &mautrix.ReqGetRelations{
+			RelationType: event.RelThread,
+			From:         from,
+			Limit:        min(limit-len(out), 100),
+		})
+		if err != nil {
+			return out, fmt.Errorf("fetch thread %s: %w", rootID, err)
+		}
+		for _, ev := range resp.Chunk {
+			ev.RoomID = roomID
+			ParseEvent(ev)
+			if ev.Type == event.EventEncrypted && client.Crypto != nil {
+				decrypted, err := client.Crypto.Decrypt(ctx, ev)
+				if err != nil {
+					log.Debug().Err(err).Str("event_id", string(ev.ID)).Msg("skipping undecryptable thread event")
+					continue
+				}
+				ev = decrypted
+			}
+			out = append(out, ev)
+		}
+		if resp.NextBatch == "" || len(resp.Chunk) == 0 {
+			break
+		}
+		from = resp.NextBatch
+	}
+	return out, nil
+}
@@ -0,0 +1,250 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// imageThumbnailSize is the widest and tallest thumbnail generated for
+	// an image. Smaller images, and animated ones, get none.
+	imageThumbnailSize = 800
+	// imageThumbnailMinBytes is the size from which an image gets a
+	// thumbnail whatever its dimensions.
+	imageThumbnailMinBytes = 1 << 20
+	// maxThumbnailPixels bounds the images decoded for a thumbnail or
+	// blurhash, so a huge image can't exhaust memory.
+	maxThumbnailPixels = 25_000_000
+	// blurhashX and blurhashY are the blurhash components across and down.
+	blurhashX, blurhashY = 4, 3
+	// blurhashSize is what images are shrunk to before their blurhash is
+	// computed; more detail is lost anyway.
+	blurhashSize = 32
+)
+
+// ImageBlurhash adds a blurhash of each image sent, and of video
+// thumbnails, for clients to show while loading. Set from config
+// IMAGE_BLURHASH.
+var ImageBlurhash bool
+
+// decodeImage decodes data as a still image of at most maxThumbnailPixels.
+func decodeImage(data []byte) (image.Image, bool) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, false
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err == nil
+}
+
+// needsThumbnail reports whether an image of this size and dimensions
+// should get a thumbnail.
+func needsThumbnail(size, width, height int) bool {
+	return width > imageThumbnailSize || height > imageThumbnailSize || size >= imageThumbnailMinBytes
+}
+
+// shrink returns img scaled down, keeping its aspect ratio, to fit in
+// size by size pixels, averaging the pixels each new one covers. Images that
+// already fit are copied as is.
+func shrink(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	if w >= h {
+		w, h = size, int(math.Max(1, math.Round(float64(h)*float64(size)/float64(w))))
+	} else {
+		w, h = int(math.Max(1, math.Round(float64(w)*float64(size)/float64(h)))), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			o := dst.PixOffset(x, y)
+			for i := range sum {
+				dst.Pix[o+i] = uint8((sum[i] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// isOpaque reports whether every pixel of img is fully opaque.
+func isOpaque(img *image.RGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// ImageThumbnail renders img as a thumbnail at most imageThumbnailSize on
+// each side: a JPEG, or a PNG if it has transparency. It returns the
+// thumbnail's data, MIME type and dimensions.
+func ImageThumbnail(img image.Image) ([]byte, string, int, int, error) {
+	thumb := shrink(img, imageThumbnailSize)
+	var buf bytes.Buffer
+	mimeType := "image/jpeg"
+	var err error
+	if isOpaque(thumb) {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+	} else {
+		mimeType = "image/png"
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
+	return buf.Bytes(), mimeType, thumb.Rect.Dx(), thumb.Rect.Dy(), nil
+}
+
+// Blurhash returns the blurhash (https://blurha.sh) of img, with
+// blurhashX by blurhashY components.
+func Blurhash(img image.Image) string {
+	small := shrink(img, blurhashSize)
+	w, h := small.Rect.Dx(), small.Rect.Dy()
+	var factors [blurhashX * blurhashY][3]float64
+	for j := 0; j < blurhashY; j++ {
+		for i := 0; i < blurhashX; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := norm * math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					o := small.PixOffset(x, y)
+					for c := range f {
+						f[c] += basis * srgbToLinear(small.Pix[o+c])
+					}
+				}
+			}
+			for c := range f {
+				factors[j*blurhashX+i][c] = f[c] / float64(w*h)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(encode83((blurhashX-1)+(blurhashY-1)*9, 1))
+	maxAC := 0.0
+	for _, f := range factors[1:] {
+		for _, v := range f {
+			maxAC = math.Max(maxAC, math.Abs(v))
+		}
+	}
+	quantMax := int(math.Max(0, math.Min(82, math.Floor(maxAC*166-0.5))))
+	sb.WriteString(encode83(quantMax, 1))
+	acScale := float64(quantMax+1) / 166
+
+	dc := factors[0]
+	sb.WriteString(encode83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+	for _, f := range factors[1:] {
+		var q [3]int
+		for c, v := range f {
+			q[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(v/acScale, 0.5)*9+9.5))))
+		}
+		sb.WriteString(encode83(q[0]*19*19+q[1]*19+q[2], 2))
+	}
+	return sb.String()
+}
+
+// setBlurhash sets info's blurhash to img's, under both the field MSC2448
+// settled on and the one clients have long read.
+func setBlurhash(info *event.FileInfo, img image.Image) {
+	info.Blurhash = Blurhash(img)
+	info.AnoaBlurhash = info.Blurhash
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// encode83 encodes value as length base 83 digits, as blurhash does.
+func encode83(value, length int) string {
+	b := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = base83[value%83]
+		value /= 83
+	}
+	return string(b)
+}
+
+// addImagePreviews adds a thumbnail to info, the info of the image in data,
+// if it's large and not animated, and with ImageBlurhash a blurhash. Either
+// is left out if it can't be made; the image is sent without it.
+func addImagePreviews(ctx context.Context, client *mautrix.Client, roomID id.RoomID, info *event.FileInfo, data []byte) {
+	wantThumb := needsThumbnail(len(data), info.Width, info.Height) && !info.IsAnimated
+	if !wantThumb && !ImageBlurhash {
+		return
+	}
+	img, ok := decodeImage(data)
+	if !ok {
+		return
+	}
+	if ImageBlurhash {
+		setBlurhash(info, img)
+	}
+	if !wantThumb {
+		return
+	}
+	thumb, mimeType, width, height, err := ImageThumbnail(img)
+	if err != nil || len(thumb) >= len(data) {
+		return
+	}
+	resp, err := client.UploadBytes(ctx, thumb, mimeType)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to upload image thumbnail")
+		return
+	}
+	recordUpload(roomID, int64(len(thumb)))
+	info.ThumbnailURL = resp.ContentURI.CUString()
+	info.ThumbnailInfo = &event.FileInfo{MimeType: mimeType, Size: len(thumb), Width: width, Height: height}
+}
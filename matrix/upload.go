@@ -0,0 +1,208 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// MaxUploadBytes caps media uploads. Set from config MAX_UPLOAD_MB.
+var MaxUploadBytes int64 = 100 << 20
+
+// ErrFileTooLarge is returned when media exceeds MaxUploadBytes.
+var ErrFileTooLarge = errors.New("file too large")
+
+// CheckUploadSize returns ErrFileTooLarge if size exceeds MaxUploadBytes.
+func CheckUploadSize(size int64) error {
+	if MaxUploadBytes > 0 && size > MaxUploadBytes {
+		return fmt.Errorf("%w: %.1f MB (limit %.1f MB)", ErrFileTooLarge, float64(size)/(1<<20), float64(MaxUploadBytes)/(1<<20))
+	}
+	return nil
+}
+
+// progressReader logs upload progress in 25% steps.
+type progressReader struct {
+	r     io.Reader
+	name  string
+	total int64
+	read  int64
+	step  int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.total > 0 {
+		if step := p.read * 4 / p.total; step > p.step {
+			p.step = step
+			log.Debug().Str("file", p.name).Int64("bytes", p.read).Int64("total", p.total).Msgf("upload %d%%", step*25)
+		}
+	}
+	return n, err
+}
+
+// UploadFile streams a file to the content repository without loading it
+// into memory.
+func UploadFile(ctx context.Context, client *mautrix.Client, path, contentType string) (id.ContentURI, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return id.ContentURI{}, 0, fmt.Errorf("open upload: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return id.ContentURI{}, 0, fmt.Errorf("stat upload: %w", err)
+	}
+	if err := CheckUploadSize(info.Size()); err != nil {
+		return id.ContentURI{}, 0, err
+	}
+	name := filepath.Base(path)
+	resp, err := client.UploadMedia(ctx, mautrix.ReqUploadMedia{
+		Content:       &progressReader{r: f, name: name, total: info.Size()},
+		ContentLength: info.Size(),
+		ContentType:   contentType,
+		FileName:      name,
+	})
+	if err != nil {
+		return id.ContentURI{}, 0, fmt.Errorf("upload %s: %w", name, err)
+	}
+	log.Info().Str("file", name).Int64("bytes", info.Size()).Msg("uploaded media")
+	return resp.ContentURI, info.Size(), nil
+}
+
+// SendFileToMatrix streams a file upload and sends it as a reply with the
+// given message type and an optional caption, detecting the MIME type from
+// the file's contents.
+func SendFileToMatrix(ctx context.Context, client *mautrix.Client, roomID id.RoomID, eventID id.EventID, path string, msgType event.MessageType, body, caption string) error {
+	contentType, _ := DetectFileType(path)
+	if info, err := os.Stat(path); err == nil {
+		if err := CheckQuota(ctx, roomID, info.Size()); err != nil {
+			return err
+		}
+	}
+	uri, size, err := UploadFile(ctx, client, path, contentType)
+	if err != nil {
+		return err
+	}
+	recordUpload(roomID, size)
+	content := event.MessageEventContent{
+		MsgType:   msgType,
+		Body:      body,
+		URL:       uri.CUString(),
+		Info:      &event.FileInfo{MimeType: contentType, Size: int(size)},
+		RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: eventID}},
+	}
+	setCaption(&content, caption)
+	if _, err := client.SendMessageEvent(ctx, roomID, event.EventMessage, &content); err != nil {
+		return fmt.Errorf("send file: %w", err)
+	}
+	return nil
+}
+
+// setCaption makes caption a media message's body, moving the file name to
+// filename as MSC2530 specifies. An empty caption leaves content alone.
+func setCaption(content *event.MessageEventContent, caption string) {
+	if caption == "" {
+		return
+	}
+	content.FileName = content.Body
+	content.Body = caption
+}
+
+// SendDataToMatrix uploads data held in memory and sends it as a reply with
+// the given message type, like SendFileToMatrix.
+func SendDataToMatrix(ctx context.Context, client *mautrix.Client, roomID id.RoomID, eventID id.EventID, data []byte, contentType string, msgType event.MessageType, body string) error {
+	if err := CheckUploadSize(int64(len(data))); err != nil {
+		return err
+	}
+	if err := CheckQuota(ctx, roomID, int64(len(data))); err != nil {
+		return err
+	}
+	resp, err := client.UploadMedia(ctx, mautrix.ReqUploadMedia{
+		ContentBytes: data,
+		ContentType:  contentType,
+		FileName:     body,
+	})
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", body, err)
+	}
+	recordUpload(roomID, int64(len(data)))
+	content := event.MessageEventContent{
+		MsgType:   msgType,
+		Body:      body,
+		URL:       resp.ContentURI.CUString(),
+		Info:      &event.FileInfo{MimeType: contentType, Size: len(data)},
+		RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: eventID}},
+	}
+	if _, err := client.SendMessageEvent(ctx, roomID, event.EventMessage, &content); err != nil {
+		return fmt.Errorf("send file: %w", err)
+	}
+	return nil
+}
+
+// roomEncrypted reports whether roomID has encryption turned on, as far as
+// the client's state store knows.
+func roomEncrypted(ctx context.Context, client *mautrix.Client, roomID id.RoomID) bool {
+	if client.StateStore == nil {
+		return false
+	}
+	encrypted, err := client.StateStore.IsEncrypted(ctx, roomID)
+	if err != nil {
+		log.Warn().Err(err).Str("room", string(roomID)).Msg("failed to check room encryption")
+	}
+	return encrypted
+}
+
+// SendAttachment uploads data and sends it as an m.file reply named
+// fileName, with its MIME type and size; with eventID empty it isn't a
+// reply. In encrypted rooms the file is
+// encrypted before it's uploaded, as clients do, so the media repository
+// only sees ciphertext.
+func SendAttachment(ctx context.Context, client *mautrix.Client, roomID id.RoomID, eventID id.EventID, data []byte, contentType, fileName string) error {
+	if err := CheckUploadSize(int64(len(data))); err != nil {
+		return err
+	}
+	if err := CheckQuota(ctx, roomID, int64(len(data))); err != nil {
+		return err
+	}
+	content := event.MessageEventContent{
+		MsgType:  event.MsgFile,
+		Body:     fileName,
+		FileName: fileName,
+		Info:     &event.FileInfo{MimeType: contentType, Size: len(data)},
+	}
+	if eventID != "" {
+		content.RelatesTo = &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: eventID}}
+	}
+	upload := mautrix.ReqUploadMedia{ContentBytes: data, ContentType: contentType, FileName: fileName}
+	var file *attachment.EncryptedFile
+	if roomEncrypted(ctx, client, roomID) {
+		file = attachment.NewEncryptedFile()
+		upload.ContentBytes = file.Encrypt(data)
+		upload.ContentType = "application/octet-stream"
+		upload.FileName = ""
+	}
+	resp, err := client.UploadMedia(ctx, upload)
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", fileName, err)
+	}
+	recordUpload(roomID, int64(len(upload.ContentBytes)))
+	if file != nil {
+		content.File = &event.EncryptedFileInfo{EncryptedFile: *file, URL: resp.ContentURI.CUString()}
+	} else {
+		content.URL = resp.ContentURI.CUString()
+	}
+	if _, err := client.SendMessageEvent(ctx, roomID, event.EventMessage, &content); err != nil {
+		return fmt.Errorf("send file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// videoThumbnailWidth is the widest thumbnail generated for a video.
+const videoThumbnailWidth = 640
+
+// VideoMeta holds what clients need to lay out a video before loading it.
+type VideoMeta struct {
+	DurationMS int
+	Width      int
+	Height     int
+}
+
+// AnalyzeVideo reads a video file's duration and dimensions with ffprobe.
+func AnalyzeVideo(ctx context.Context, path string) (*VideoMeta, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration", "-of", "json", path)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("probe video: %w, stderr: %s", err, stderr.String())
+	}
+	return parseFFprobe(stdout.Bytes())
+}
+
+// parseFFprobe reads ffprobe's JSON output for AnalyzeVideo.
+func parseFFprobe(out []byte) (*VideoMeta, error) {
+	var probe struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("decode ffprobe output: %w", err)
+	}
+	if len(probe.Streams) == 0 {
+		return nil, fmt.Errorf("no video stream")
+	}
+	meta := &VideoMeta{Width: probe.Streams[0].Width, Height: probe.Streams[0].Height}
+	if secs, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		meta.DurationMS = int(secs * 1000)
+	}
+	return meta, nil
+}
+
+// VideoThumbnail renders a video's first frame as a JPEG at most
+// videoThumbnailWidth wide, with ffmpeg.
+func VideoThumbnail(ctx context.Context, path string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-i", path, "-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", videoThumbnailWidth), "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("render thumbnail: %w, stderr: %s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// SendVideoToMatrix streams a video file upload and sends it as an m.video
+// reply, with an optional caption. Duration, dimensions and a thumbnail are
+// included when ffprobe and ffmpeg are on PATH; without them the video is
+// still sent.
+func SendVideoToMatrix(ctx context.Context, client *mautrix.Client, roomID id.RoomID, eventID id.EventID, path, body, caption string) error {
+	contentType, _ := DetectFileType(path)
+	info := &event.FileInfo{MimeType: contentType}
+	if meta, err := AnalyzeVideo(ctx, path); err != nil {
+		log.Debug().Err(err).Str("file", path).Msg("no video metadata")
+	} else {
+		info.Duration, info.Width, info.Height = meta.DurationMS, meta.Width, meta.Height
+	}
+	thumb, err := VideoThumbnail(ctx, path)
+	if err != nil {
+		log.Debug().Err(err).Str("file", path).Msg("no video thumbnail")
+	}
+
+	uri, size, err := UploadFile(ctx, client, path, contentType)
+	if err != nil {
+		return err
+	}
+	recordUpload(roomID, size)
+	info.Size = int(size)
+	if len(thumb) > 0 {
+		if resp, err := client.UploadBytes(ctx, thumb, "image/jpeg"); err != nil {
+			log.Warn().Err(err).Msg("failed to upload video thumbnail")
+		} else {
+			recordUpload(roomID, int64(len(thumb)))
+			width, height := ImageDimensions(thumb)
+			info.ThumbnailURL = resp.ContentURI.CUString()
+			info.ThumbnailInfo = &event.FileInfo{MimeType: "image/jpeg", Size: len(thumb), Width: width, Height: height}
+			if ImageBlurhash {
+				if img, ok := decodeImage(thumb); ok {
+					setBlurhash(info, img)
+				}
+			}
+		}
+	}
+	content := event.MessageEventContent{
+		MsgType:   event.MsgVideo,
+		Body:      body,
+		URL:       uri.CUString(),
+		Info:      info,
+		RelatesTo: &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: eventID}},
+	}
+	setCaption(&content, caption)
+	if _, err := client.SendMessageEvent(ctx, roomID, event.EventMessage, &content); err != nil {
+		return fmt.Errorf("send video: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package matrix
+
+import "testing"
+
+func TestParseFFprobe(t *testing.T) {
+	out := `{"programs":[],"streams":[{"width":1280,"height":720}],"format":{"duration":"12.345000"}}`
+	meta, err := parseFFprobe([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *meta != (VideoMeta{DurationMS: 12345, Width: 1280, Height: 720}) {
+		t.Errorf("meta = %+v", meta)
+	}
+	if _, err := parseFFprobe([]byte(`{"streams":[],"format":{"duration":"1.0"}}`)); err == nil {
+		t.Error("audio-only probe accepted")
+	}
+}
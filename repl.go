@@ -0,0 +1,133 @@
+package ash
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/app"
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/locale"
+)
+
+// replSession holds what each REPL command runs against.
+type replSession struct {
+	cfg        *config.Config
+	botCfg     *bot.BotConfig
+	client     *mautrix.Client
+	messagesDB *sql.DB
+	room       config.RoomIDEntry
+	roomID     id.RoomID
+	sender     id.UserID
+	label      string
+	out        io.Writer
+}
+
+// REPL reads commands from in and runs each through FetchBotCommand as if
+// sender had typed it in roomID, printing replies to out. The real messages
+// database is used so stats commands see history, but nothing is sent to
+// the homeserver. Lines without a "/bot" prefix get one.
+func (a *Ash) REPL(ctx context.Context, in io.Reader, out io.Writer, roomID id.RoomID, sender id.UserID) error {
+	botCfg := a.loadBotConfig()
+	if botCfg == nil {
+		return fmt.Errorf("no bot configuration loaded")
+	}
+	messagesDB, err := db.OpenMessages(ctx, a.cfg.DBPath)
+	if err != nil {
+		return fmt.Errorf("open messages db: %w", err)
+	}
+	defer messagesDB.Close()
+	client, err := newDryRunClient(a.cfg, out)
+	if err != nil {
+		return err
+	}
+	a.applySettings()
+	bot.InitTriviaState()
+
+	s := &replSession{
+		cfg:        a.cfg,
+		botCfg:     botCfg,
+		client:     client,
+		messagesDB: messagesDB,
+		roomID:     roomID,
+		sender:     sender,
+		label:      app.ResolveReplyLabel(a.cfg, botCfg),
+		out:        out,
+	}
+	for _, r := range a.cfg.RoomIDs {
+		if r.ID == string(roomID) {
+			s.room = r
+		}
+	}
+
+	scanner := bufio.NewScanner(in)
+	for fmt.Fprint(out, "> "); scanner.Scan(); fmt.Fprint(out, "> ") {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "quit" || line == "exit" {
+			return nil
+		}
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "/bot") {
+			line = "/bot " + line
+		}
+		s.run(ctx, line)
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+	fmt.Fprintln(out)
+	return scanner.Err()
+}
+
+// run executes one command line and prints its reply.
+func (s *replSession) run(ctx context.Context, body string) {
+	parts := strings.Fields(body)
+	cmd := "hi"
+	if len(parts) >= 2 {
+		cmd = parts[1]
+	}
+	if cmd == "help" {
+		fmt.Fprintf(s.out, "< %s%s\n", s.label, app.GenerateHelpMessage(s.botCfg, s.room.AllowedCommands))
+		return
+	}
+	cmdCfg, ok := s.botCfg.Commands[cmd]
+	if !ok {
+		fmt.Fprintf(s.out, "< %s%s\n", s.label, locale.Get("", "unknown_command", app.GenerateHelpMessage(s.botCfg, s.room.AllowedCommands)))
+		return
+	}
+
+	raw, _ := json.Marshal(map[string]any{"msgtype": "m.text", "body": body})
+	ev := &event.Event{
+		Type:      event.EventMessage,
+		ID:        id.EventID(fmt.Sprintf("$repl-%d", time.Now().UnixNano())),
+		RoomID:    s.roomID,
+		Sender:    s.sender,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if err := json.Unmarshal(raw, &ev.Content); err != nil {
+		fmt.Fprintf(s.out, "! %v\n", err)
+		return
+	}
+	_ = ev.Content.ParseRaw(ev.Type)
+
+	resp, err := bot.FetchBotCommand(ctx, &cmdCfg, s.cfg.LinkstashURL, ev, s.client, s.cfg.GroqAPIKey, s.label, s.messagesDB, s.room)
+	switch {
+	case err != nil:
+		fmt.Fprintf(s.out, "! %s failed: %v\n", cmd, err)
+	case resp != "":
+		fmt.Fprintf(s.out, "< %s%s\n", s.label, resp)
+	}
+}
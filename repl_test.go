@@ -0,0 +1,33 @@
+package ash
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+)
+
+func TestREPL(t *testing.T) {
+	cfg := &config.Config{DBPath: filepath.Join(t.TempDir(), "messages.db"), BotReplyLabel: "> "}
+	botCfg := &bot.BotConfig{Commands: map[string]bot.BotCommand{
+		"hi": {Type: "http", Response: "hello"},
+	}}
+	var out strings.Builder
+	in := strings.NewReader("hi\n/bot nosuch\nquit\nhi\n")
+	if err := New(cfg).WithBotConfig(botCfg).REPL(context.Background(), in, &out, "!room:example.com", "@alice:example.com"); err != nil {
+		t.Fatalf("REPL: %v", err)
+	}
+	got := out.String()
+	if !strings.Contains(got, "< > hello\n") {
+		t.Errorf("missing hi reply in %q", got)
+	}
+	if !strings.Contains(got, "Unknown command. Available commands: hi") {
+		t.Errorf("missing unknown command reply in %q", got)
+	}
+	if strings.Count(got, "hello") != 1 {
+		t.Errorf("expected REPL to stop at quit, got %q", got)
+	}
+}
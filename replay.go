@@ -0,0 +1,142 @@
+package ash
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/polarhive/ash/bot"
+	"github.com/polarhive/ash/config"
+	"github.com/polarhive/ash/db"
+	"github.com/polarhive/ash/matrix"
+)
+
+// ParseReplayEvent decodes a captured event. raw may be a full event as
+// returned by /event or seen in logs, or just its content (the raw_json
+// column of the messages table), in which case roomID and sender are used.
+func ParseReplayEvent(raw []byte, roomID id.RoomID, sender id.UserID) (*event.Event, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, fmt.Errorf("decode event: %w", err)
+	}
+	ev := &event.Event{}
+	if _, ok := probe["content"]; ok {
+		if err := json.Unmarshal(raw, ev); err != nil {
+			return nil, fmt.Errorf("decode event: %w", err)
+		}
+	} else if err := json.Unmarshal(raw, &ev.Content); err != nil {
+		return nil, fmt.Errorf("decode event content: %w", err)
+	}
+	if ev.Type.Type == "" {
+		ev.Type = event.EventMessage
+	}
+	ev.Type.Class = event.MessageEventType
+	if ev.RoomID == "" {
+		ev.RoomID = roomID
+	}
+	if ev.Sender == "" {
+		ev.Sender = sender
+	}
+	if ev.ID == "" {
+		ev.ID = id.EventID(fmt.Sprintf("$replay-%d", time.Now().UnixNano()))
+	}
+	if ev.Timestamp == 0 {
+		ev.Timestamp = time.Now().UnixMilli()
+	}
+	if ev.RoomID == "" || ev.Sender == "" {
+		return nil, fmt.Errorf("event has no room_id or sender; pass them explicitly")
+	}
+	return ev, nil
+}
+
+// StoredReplayEvent rebuilds an event from a row of the messages table.
+func StoredReplayEvent(m db.StoredMessage) (*event.Event, error) {
+	ev, err := ParseReplayEvent([]byte(m.RawJSON), id.RoomID(m.RoomID), id.UserID(m.Sender))
+	if err != nil {
+		return nil, fmt.Errorf("stored message %s: %w", m.ID, err)
+	}
+	ev.ID = id.EventID(m.ID)
+	ev.Timestamp = m.TSMillis
+	return ev, nil
+}
+
+// Replay feeds a captured event through the full message pipeline against a
+// dry-run client that logs outgoing requests instead of sending them. A
+// scratch database is used and link hooks are disabled; commands run for
+// up to wait before Replay returns.
+func (a *Ash) Replay(ctx context.Context, ev *event.Event, wait time.Duration) error {
+	return a.ReplayAll(ctx, []*event.Event{ev}, wait)
+}
+
+// ReplayAll is Replay for several events, handled in order against the same
+// scratch database, so state such as flood counters carries over.
+func (a *Ash) ReplayAll(ctx context.Context, evs []*event.Event, wait time.Duration) error {
+	cfg := *a.cfg
+	cfg.RoomIDs = append(cfg.RoomIDs[:0:0], cfg.RoomIDs...)
+	for i := range cfg.RoomIDs {
+		cfg.RoomIDs[i].Hook = ""
+	}
+	replay := &Ash{cfg: &cfg, botCfg: a.botCfg, router: a.router}
+
+	dir, err := os.MkdirTemp("", "ash-replay-")
+	if err != nil {
+		return fmt.Errorf("create replay dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	messagesDB, err := db.OpenMessages(ctx, filepath.Join(dir, "messages.db"))
+	if err != nil {
+		return fmt.Errorf("open replay db: %w", err)
+	}
+	defer messagesDB.Close()
+
+	client, err := newDryRunClient(&cfg, nil)
+	if err != nil {
+		return err
+	}
+
+	replay.applySettings()
+	readyChan := make(chan bool)
+	close(readyChan)
+	h, err := replay.newApp(client, messagesDB, replay.loadBotConfig(), readyChan)
+	if err != nil {
+		return err
+	}
+	bot.InitTriviaState()
+
+	for _, ev := range evs {
+		log.Info().Str("event_id", string(ev.ID)).Str("room", string(ev.RoomID)).Str("sender", string(ev.Sender)).Msg("replaying event")
+		h.HandleMessage(ctx, ev)
+	}
+
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+	}
+	log.Info().Int("events", len(evs)).Msg("replay finished")
+	return nil
+}
+
+// newDryRunClient creates a Matrix client whose requests never leave the
+// process. Messages it sends are printed to out when it is non-nil.
+func newDryRunClient(cfg *config.Config, out io.Writer) (*mautrix.Client, error) {
+	homeserver := cfg.Homeserver
+	if homeserver == "" {
+		homeserver = "https://replay.invalid"
+	}
+	client, err := mautrix.NewClient(homeserver, id.UserID(cfg.User), "replay")
+	if err != nil {
+		return nil, fmt.Errorf("create dry-run client: %w", err)
+	}
+	client.Client = &http.Client{Transport: &matrix.DryRunTransport{Out: out}}
+	return client, nil
+}
@@ -0,0 +1,64 @@
+package ash
+
+import (
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+
+	"github.com/polarhive/ash/db"
+)
+
+func TestParseReplayEvent(t *testing.T) {
+	full := `{"type":"m.room.message","room_id":"!room:example.com","sender":"@alice:example.com","event_id":"$abc","origin_server_ts":1700000000000,"content":{"msgtype":"m.text","body":"/bot hi"}}`
+	ev, err := ParseReplayEvent([]byte(full), "", "")
+	if err != nil {
+		t.Fatalf("full event: %v", err)
+	}
+	if ev.ID != "$abc" || ev.RoomID != "!room:example.com" || ev.Sender != "@alice:example.com" || ev.Type != event.EventMessage {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	if body, _ := ev.Content.Raw["body"].(string); body != "/bot hi" {
+		t.Errorf("body = %q, want /bot hi", body)
+	}
+
+	content := `{"msgtype":"m.text","body":"/bot hi"}`
+	if _, err := ParseReplayEvent([]byte(content), "", ""); err == nil {
+		t.Error("expected error for content without room and sender")
+	}
+	ev, err = ParseReplayEvent([]byte(content), "!room:example.com", "@bob:example.com")
+	if err != nil {
+		t.Fatalf("content only: %v", err)
+	}
+	if ev.Sender != "@bob:example.com" || ev.ID == "" || ev.Timestamp == 0 {
+		t.Errorf("unexpected defaults: %+v", ev)
+	}
+
+	if _, err := ParseReplayEvent([]byte("not json"), "", ""); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
+
+func TestStoredReplayEvent(t *testing.T) {
+	m := db.StoredMessage{
+		ID:       "$stored",
+		RoomID:   "!room:example.com",
+		Sender:   "@alice:example.com",
+		TSMillis: 1700000000000,
+		RawJSON:  `{"msgtype":"m.text","body":"/bot yap"}`,
+	}
+	ev, err := StoredReplayEvent(m)
+	if err != nil {
+		t.Fatalf("StoredReplayEvent: %v", err)
+	}
+	if ev.ID != "$stored" || ev.RoomID != "!room:example.com" || ev.Sender != "@alice:example.com" || ev.Timestamp != 1700000000000 {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	if body, _ := ev.Content.Raw["body"].(string); body != "/bot yap" {
+		t.Errorf("body = %q, want /bot yap", body)
+	}
+
+	m.RawJSON = "{"
+	if _, err := StoredReplayEvent(m); err == nil {
+		t.Error("expected error for corrupt raw_json")
+	}
+}
@@ -63,10 +63,11 @@ func validateCommand(t *testing.T, name string, cmd BotCommand) {
 
 	// Check that type is specified and valid
 	validTypes := map[string]bool{
-		"http":    true,
-		"exec":    true,
-		"ai":      true,
-		"builtin": true,
+		"http":     true,
+		"exec":     true,
+		"ai":       true,
+		"builtin":  true,
+		"download": true,
 	}
 
 	if cmd.Type == "" {
@@ -75,7 +76,7 @@ func validateCommand(t *testing.T, name string, cmd BotCommand) {
 	}
 
 	if !validTypes[cmd.Type] {
-		t.Errorf("Command %s: invalid type '%s', must be one of: http, exec, ai, builtin", name, cmd.Type)
+		t.Errorf("Command %s: invalid type '%s', must be one of: http, exec, ai, builtin, download", name, cmd.Type)
 		return
 	}
 
@@ -94,22 +95,28 @@ func validateCommand(t *testing.T, name string, cmd BotCommand) {
 	// Validate input/output types if specified
 	if cmd.InputType != "" {
 		validIOTypes := map[string]bool{
-			"none":  true,
-			"text":  true,
-			"image": true,
+			"none":   true,
+			"text":   true,
+			"image":  true,
+			"thread": true,
 		}
 		if !validIOTypes[cmd.InputType] {
-			t.Errorf("Command %s: invalid input_type '%s', must be one of: none, text, image", name, cmd.InputType)
+			t.Errorf("Command %s: invalid input_type '%s', must be one of: none, text, image, thread", name, cmd.InputType)
 		}
 	}
 
 	if cmd.OutputType != "" {
 		validIOTypes := map[string]bool{
-			"text":  true,
-			"image": true,
+			"text":     true,
+			"image":    true,
+			"audio":    true,
+			"video":    true,
+			"file":     true,
+			"media":    true,
+			"reaction": true,
 		}
 		if !validIOTypes[cmd.OutputType] {
-			t.Errorf("Command %s: invalid output_type '%s', must be one of: text, image", name, cmd.OutputType)
+			t.Errorf("Command %s: invalid output_type '%s', must be one of: text, image, audio, video, file, media, reaction", name, cmd.OutputType)
 		}
 	}
 }
@@ -166,8 +173,8 @@ func validateExecCommand(t *testing.T, name string, cmd BotCommand) {
 		t.Errorf("Command %s: input_type 'image' requires {input} placeholder in args", name)
 	}
 
-	if cmd.OutputType == "image" && !hasOutput {
-		t.Errorf("Command %s: output_type 'image' requires {output} placeholder in args", name)
+	if (cmd.OutputType == "image" || cmd.OutputType == "audio" || cmd.OutputType == "file") && !hasOutput {
+		t.Errorf("Command %s: output_type '%s' requires {output} placeholder in args", name, cmd.OutputType)
 	}
 }
 
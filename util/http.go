@@ -0,0 +1,92 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/polarhive/ash/version"
+)
+
+// httpTimeout bounds any request made with HTTPClient; callers that need a
+// tighter limit set one on the request's context.
+const httpTimeout = 60 * time.Second
+
+// HTTPClient is the client for every outgoing HTTP request other than to
+// the homeserver: link hooks and resolution, http and ai commands, image
+// downloads, feeds and link metadata. It keeps idle connections for reuse,
+// goes through the proxy set with ConfigureHTTP or else the HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY environment variables, and sends ash's
+// User-Agent when a request doesn't set one.
+var HTTPClient = NewHTTPClient(nil)
+
+// NewHTTPClient returns a pooled client that uses proxy, or the proxy
+// environment variables if proxy is nil.
+func NewHTTPClient(proxy *url.URL) *http.Client {
+	return &http.Client{
+		Timeout:   httpTimeout,
+		Transport: userAgentTransport{newTransport(proxy)},
+	}
+}
+
+// newTransport returns a pooled transport that uses proxy, or the proxy
+// environment variables if proxy is nil.
+func newTransport(proxy *url.URL) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 10
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return transport
+}
+
+// ConfigureHTTP replaces HTTPClient and PublicHTTPClient with ones using the
+// proxy at proxyURL (http, https or socks5). An empty proxyURL keeps the
+// environment's.
+func ConfigureHTTP(proxyURL string) error {
+	if proxyURL == "" {
+		return nil
+	}
+	proxy, err := ParseProxyURL(proxyURL)
+	if err != nil {
+		return err
+	}
+	HTTPClient = NewHTTPClient(proxy)
+	PublicHTTPClient = NewPublicHTTPClient(proxy)
+	return nil
+}
+
+// ParseProxyURL parses a proxy URL, accepting only schemes the transport
+// supports.
+func ParseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy %q must be an http, https or socks5 URL", proxyURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy %q has no host", proxyURL)
+	}
+	return u, nil
+}
+
+// userAgentTransport sets ash's User-Agent on requests without one.
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", version.UserAgent())
+	}
+	return t.base.RoundTrip(req)
+}
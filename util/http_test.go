@@ -0,0 +1,67 @@
+package util
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHTTPClientUserAgent(t *testing.T) {
+	var agents []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.UserAgent())
+	}))
+	defer srv.Close()
+	client := NewHTTPClient(nil)
+	if _, err := client.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("User-Agent", "custom/1.0")
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 2 || !strings.HasPrefix(agents[0], "ash/") || agents[1] != "custom/1.0" {
+		t.Errorf("User-Agents = %q", agents)
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	for _, ok := range []string{"http://proxy:3128", "socks5://127.0.0.1:1080"} {
+		if _, err := ParseProxyURL(ok); err != nil {
+			t.Errorf("ParseProxyURL(%q) = %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"proxy:3128", "ftp://proxy", "http://"} {
+		if _, err := ParseProxyURL(bad); err == nil {
+			t.Errorf("ParseProxyURL(%q) accepted", bad)
+		}
+	}
+}
+
+func TestPublicHTTPClient(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8": true, "2606:4700::1111": true,
+		"127.0.0.1": false, "10.1.2.3": false, "192.168.0.1": false, "169.254.169.254": false,
+		"100.64.0.1": false, "0.1.2.3": false, "::1": false, "fd00::1": false, "::ffff:127.0.0.1": false,
+	} {
+		if got := IsPublicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("IsPublicAddr(%s) = %v", addr, got)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	if _, err := NewPublicHTTPClient(nil).Get(srv.URL); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("request to %s: %v", srv.URL, err)
+	}
+	// Behind a proxy, the target is checked before the request is sent.
+	proxy, _ := url.Parse(srv.URL)
+	if _, err := NewPublicHTTPClient(proxy).Get("http://localhost/"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("proxied request to localhost: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// ErrPrivateAddress is returned for requests PublicHTTPClient refuses
+// because the host resolves to an address that isn't public.
+var ErrPrivateAddress = errors.New("refusing to connect to a non-public address")
+
+// PublicHTTPClient is HTTPClient for URLs users give the bot: it only
+// connects to public addresses, so a URL can't reach the bot's own host,
+// its network or cloud metadata endpoints. Redirects are checked too.
+// Without a proxy, the address actually dialled is checked, so DNS can't
+// be changed between the check and the connection; behind a proxy, which
+// resolves names itself, the host is resolved and checked first.
+var PublicHTTPClient = NewPublicHTTPClient(nil)
+
+// nonPublicPrefixes are ranges IsGlobalUnicast lets through that aren't
+// reachable from the internet: "this network", which Linux connects to
+// the host itself, and the shared space carrier-grade NAT uses.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// IsPublicAddr reports whether addr is a global unicast address outside
+// private and shared ranges.
+func IsPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewPublicHTTPClient returns a client like NewHTTPClient's that only
+// connects to public addresses.
+func NewPublicHTTPClient(proxy *url.URL) *http.Client {
+	transport := newTransport(proxy)
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if proxyHost, _ := ctx.Value(proxyHostKey{}).(string); proxyHost != "" && address == proxyHost {
+			return dialer.DialContext(ctx, network, address)
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := publicAddrs(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var conn net.Conn
+		for _, addr := range addrs {
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+	return &http.Client{
+		Timeout:   httpTimeout,
+		Transport: userAgentTransport{publicTransport{transport}},
+	}
+}
+
+// proxyHostKey is the context key under which publicTransport passes the
+// proxy a request goes through, as host:port, to the dialer.
+type proxyHostKey struct{}
+
+// publicTransport checks the host of requests that go through a proxy.
+type publicTransport struct {
+	base *http.Transport
+}
+
+func (t publicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxy, err := t.base.Proxy(req)
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		if _, err := publicAddrs(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+		port := proxy.Port()
+		if port == "" {
+			port = map[string]string{"https": "443", "socks5": "1080", "socks5h": "1080"}[proxy.Scheme]
+			if port == "" {
+				port = "80"
+			}
+		}
+		req = req.WithContext(context.WithValue(req.Context(), proxyHostKey{}, net.JoinHostPort(proxy.Hostname(), port)))
+	}
+	return t.base.RoundTrip(req)
+}
+
+// publicAddrs resolves host and returns its addresses, or ErrPrivateAddress
+// if any of them isn't public.
+func publicAddrs(ctx context.Context, host string) ([]netip.Addr, error) {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if !IsPublicAddr(addr) {
+			return nil, fmt.Errorf("%w: %s is %s", ErrPrivateAddress, host, addr)
+		}
+	}
+	return addrs, nil
+}
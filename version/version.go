@@ -0,0 +1,102 @@
+// Package version reports which build of ash is running. Release builds set
+// the variables with -ldflags (see the Makefile); otherwise they are filled
+// in from the module and VCS information Go embeds in the binary.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// Set with -ldflags "-X github.com/polarhive/ash/version.Version=...".
+var (
+	Version = ""
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes the running build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+// Get returns the build info, falling back to debug.ReadBuildInfo for
+// anything not set with -ldflags.
+func Get() Info {
+	once.Do(func() {
+		bi, _ := debug.ReadBuildInfo()
+		info = resolve(Version, Commit, Date, bi)
+	})
+	return info
+}
+
+// resolve merges ldflags values with embedded build info.
+func resolve(version, commit, date string, bi *debug.BuildInfo) Info {
+	i := Info{Version: version, Commit: commit, Date: date, GoVersion: runtime.Version()}
+	if bi != nil {
+		if i.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			i.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if i.Commit == "" {
+					i.Commit = s.Value
+				}
+			case "vcs.time":
+				if i.Date == "" {
+					i.Date = s.Value
+				}
+			case "vcs.modified":
+				i.Modified = s.Value == "true"
+			}
+		}
+	}
+	if i.Version == "" {
+		i.Version = "dev"
+	}
+	if len(i.Commit) > 12 {
+		i.Commit = i.Commit[:12]
+	}
+	return i
+}
+
+// String formats the info on one line, e.g.
+// "ash v1.2.0 (commit 0123456789ab, built 2026-01-02T03:04:05Z, go1.26.4)".
+func (i Info) String() string {
+	var details []string
+	if i.Commit != "" {
+		commit := "commit " + i.Commit
+		if i.Modified {
+			commit += "-dirty"
+		}
+		details = append(details, commit)
+	}
+	if i.Date != "" {
+		details = append(details, "built "+i.Date)
+	}
+	details = append(details, i.GoVersion)
+	return fmt.Sprintf("ash %s (%s)", i.Version, strings.Join(details, ", "))
+}
+
+// UserAgent is the User-Agent ash sends with its own HTTP requests, e.g.
+// "ash/v1.2.0 (+0123456789ab)".
+func UserAgent() string {
+	i := Get()
+	if i.Commit == "" {
+		return "ash/" + i.Version
+	}
+	return fmt.Sprintf("ash/%s (+%s)", i.Version, i.Commit)
+}
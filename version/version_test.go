@@ -0,0 +1,33 @@
+package version
+
+import (
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Main: debug.Module{Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+			{Key: "vcs.time", Value: "2026-01-02T03:04:05Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	i := resolve("", "", "", bi)
+	if i.Version != "dev" || i.Commit != "0123456789ab" || i.Date != "2026-01-02T03:04:05Z" || !i.Modified {
+		t.Errorf("from build info: %+v", i)
+	}
+	if s := i.String(); !strings.Contains(s, "ash dev (commit 0123456789ab-dirty, built 2026-01-02T03:04:05Z, go") {
+		t.Errorf("String() = %q", s)
+	}
+
+	i = resolve("v1.2.0", "abc", "today", bi)
+	if i.Version != "v1.2.0" || i.Commit != "abc" || i.Date != "today" {
+		t.Errorf("ldflags should win: %+v", i)
+	}
+	if s := resolve("", "", "", nil).String(); !strings.HasPrefix(s, "ash dev (go") {
+		t.Errorf("no build info: %q", s)
+	}
+}